@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+)
+
+// rebootDelay is the time after which the Windows node is rebooted with --reboot, which leaves wmcb the time to exit
+const rebootDelay = 10 * time.Second
+
+var (
+	// applyUpdatesCmd describes the apply-updates command
+	applyUpdatesCmd = &cobra.Command{
+		Use:   "apply-updates",
+		Short: "Installs the given Windows updates on the node",
+		Long: "Installs the Windows updates with the knowledge base IDs given with --kb through Windows Update, and " +
+			"records the patch level of the node in the bootstrap status. Only the given updates are installed, " +
+			"which pins the patch level of the node. The updates already installed are skipped. If the node needs " +
+			"to be rebooted for the updates to take effect, wmcb exits with code 5 and the node is rebooted if " +
+			"--reboot is given. Running wmcb again once the node has rebooted continues the bootstrap.",
+		Run: runApplyUpdatesCmd,
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return cmd.MarkPersistentFlagRequired("kb")
+		},
+	}
+
+	// applyUpdatesOpts holds the apply-updates CLI options, which are shared with the bootstrap command
+	applyUpdatesOpts struct {
+		// installDir is the main installation directory
+		installDir string
+		// kbs are the knowledge base IDs of the Windows updates to install
+		kbs []string
+		// reboot indicates that the node is rebooted if the updates require it
+		reboot bool
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(applyUpdatesCmd)
+	applyUpdatesCmd.PersistentFlags().StringVar(&applyUpdatesOpts.installDir, "install-dir", "c:\\k",
+		"Installation directory. Defaults to C:\\k")
+	addApplyUpdatesFlags(applyUpdatesCmd.PersistentFlags())
+}
+
+// addApplyUpdatesFlags adds the flags of the apply-updates command that are shared with the bootstrap command
+func addApplyUpdatesFlags(flags *pflag.FlagSet) {
+	flags.StringSliceVar(&applyUpdatesOpts.kbs, "kb", nil,
+		"Knowledge base ID of a Windows update to install, like KB5005568. Can be specified multiple times")
+	flags.BoolVar(&applyUpdatesOpts.reboot, "reboot", false,
+		"Reboot the Windows node if the installed updates require it")
+}
+
+// applyWindowsUpdates installs the Windows updates given with --kb, and reboots the node if required and --reboot is
+// given
+func applyWindowsUpdates(ctx context.Context) error {
+	wmcb, err := bootstrapper.New(bootstrapper.WithInstallDir(applyUpdatesOpts.installDir))
+	if err != nil {
+		return fmt.Errorf("could not create bootstrapper: %w", err)
+	}
+	defer func() {
+		if err := wmcb.Disconnect(); err != nil {
+			log.Error(err, "can't clean up bootstrapper")
+		}
+	}()
+
+	patchLevel, err := wmcb.ApplyWindowsUpdates(ctx, applyUpdatesOpts.kbs)
+	if patchLevel != nil {
+		log.Info("Windows patch level", "build", patchLevel.Build, "applied", patchLevel.AppliedKBs)
+	}
+	rebootIfRequired(err, applyUpdatesOpts.reboot)
+	return err
+}
+
+// rebootIfRequired reboots the Windows node if the given error requires it and reboot is true. The node is rebooted
+// after a delay, for wmcb to exit with the reboot required code first.
+func rebootIfRequired(err error, reboot bool) {
+	if !errors.Is(err, bootstrapper.ErrRebootRequired) || !reboot {
+		return
+	}
+	log.Info("rebooting the Windows node", "delay", rebootDelay)
+	if rebootErr := bootstrapper.RebootNode(rebootDelay); rebootErr != nil {
+		log.Error(rebootErr, "could not reboot the Windows node")
+	}
+}
+
+// runApplyUpdatesCmd installs the Windows updates given with --kb
+func runApplyUpdatesCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+	if err := applyWindowsUpdates(cmd.Context()); err != nil {
+		log.Error(err, "could not apply Windows updates")
+		os.Exit(exitCode(err))
+	}
+	// Send success message to StdOut to ascertain that the updates were installed
+	os.Stdout.WriteString("Windows updates applied successfully")
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+	"github.com/spf13/cobra"
+)
+
+// bootstrapPhase is a step of the bootstrap command, which runs the equivalent of one of the other commands
+type bootstrapPhase struct {
+	// name is the name of the phase passed to --from-phase
+	name string
+	// markFlagsRequired marks the flags required by the phase as required
+	markFlagsRequired func(cmd *cobra.Command) error
+	// run runs the phase
+	run func(ctx context.Context) error
+}
+
+var (
+	// bootstrapCmd describes the bootstrap command
+	bootstrapCmd = &cobra.Command{
+		Use:   "bootstrap",
+		Short: "Bootstraps the Windows node in one invocation",
+		Long: "Bootstraps the Windows node by running the enable-features, apply-updates, initialize-kubelet, " +
+			"wait-for-csr-approval, configure-cni, configure-kube-proxy and wait-for-node-ready phases in order. The " +
+			"enable-features phase only enables the Windows features with --enable-features, and the apply-updates " +
+			"phase only installs the Windows updates given with --kb. The bootstrap stops with exit code 5 if the " +
+			"node needs to be rebooted for them to take effect, to be run again once it has rebooted. wmcb does not " +
+			"approve the CSRs of the node: the wait-for-csr-approval phase waits for them to be approved by an " +
+			"external approver, like the cluster machine approver, and for the kubelet certificates to be issued. The " +
+			"bootstrap only succeeds once the node is Ready. A failed bootstrap can be resumed from the failed phase " +
+			"using --from-phase. A Windows node that has already been bootstrapped is left unchanged unless --force is given.",
+		Run: runBootstrapCmd,
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			// The required flags of the phases depend on the shared options
+			setBootstrapOpts()
+			phases, err := bootstrapPhasesFrom(bootstrapOpts.fromPhase)
+			if err != nil {
+				return err
+			}
+			for _, phase := range phases {
+				if err := phase.markFlagsRequired(cmd); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+
+	// bootstrapOpts holds the bootstrap CLI options shared by the commands run by the bootstrap phases, and the
+	// options specific to the bootstrap command
+	bootstrapOpts struct {
+		// installDir is the main installation directory
+		installDir string
+		// artifactsDir is the directory containing a verified bundle of the artifacts required for offline
+		// bootstrapping
+		artifactsDir string
+		// fetch holds the options used to download the kubelet and the CNI plugins archive
+		fetch fetchOpts
+		// kubeletSHA256 is the SHA256 checksum of the downloaded kubelet.exe
+		kubeletSHA256 string
+		// cniSHA256 is the SHA256 checksum of the downloaded CNI plugins archive
+		cniSHA256 string
+		// recovery holds the recovery settings of the Windows services
+		recovery recoveryOpts
+		// certificatesTimeout is the time to wait for the kubelet certificates to be issued
+		certificatesTimeout time.Duration
+		// nodeReadyTimeout is the time to wait for the node to be Ready
+		nodeReadyTimeout time.Duration
+		// fromPhase is the phase the bootstrap starts from
+		fromPhase string
+		// rollbackOnFailure indicates that the changes of the bootstrap are rolled back if a phase fails
+		rollbackOnFailure bool
+		// force bootstraps the Windows node again even if it has already been bootstrapped
+		force bool
+		// enableFeatures enables the Windows features and services required by the container runtime
+		enableFeatures bool
+	}
+
+	// bootstrapPhases are the phases of the bootstrap command in the order they are run
+	bootstrapPhases = []bootstrapPhase{
+		{
+			name:              "enable-features",
+			markFlagsRequired: func(*cobra.Command) error { return nil },
+			run: func(ctx context.Context) error {
+				if !bootstrapOpts.enableFeatures {
+					return nil
+				}
+				return enableWindowsFeatures(ctx)
+			},
+		},
+		{
+			name:              "apply-updates",
+			markFlagsRequired: func(*cobra.Command) error { return nil },
+			run:               applyWindowsUpdates,
+		},
+		{
+			name: "initialize-kubelet",
+			markFlagsRequired: func(cmd *cobra.Command) error {
+				return markInitializeKubeletFlagsRequired(cmd, "kubelet-sha256")
+			},
+			run: initializeKubelet,
+		},
+		{
+			name:              "wait-for-csr-approval",
+			markFlagsRequired: func(*cobra.Command) error { return nil },
+			run:               waitForCSRApproval,
+		},
+		{
+			name: "configure-cni",
+			markFlagsRequired: func(cmd *cobra.Command) error {
+				return markConfigureCNIFlagsRequired(cmd, "cni-sha256")
+			},
+			run: configureCNI,
+		},
+		{
+			name:              "configure-kube-proxy",
+			markFlagsRequired: markConfigureKubeProxyFlagsRequired,
+			run:               configureKubeProxy,
+		},
+		{
+			name:              "wait-for-node-ready",
+			markFlagsRequired: func(*cobra.Command) error { return nil },
+			run:               waitForNodeReady,
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(bootstrapCmd)
+	flags := bootstrapCmd.PersistentFlags()
+	addInitializeKubeletFlags(flags)
+	addConfigureCNIFlags(flags)
+	addConfigureKubeProxyFlags(flags)
+	addApplyUpdatesFlags(flags)
+	flags.StringVar(&bootstrapOpts.installDir, "install-dir", "c:\\k", "Installation directory. Defaults to C:\\k")
+	flags.StringVar(&bootstrapOpts.artifactsDir, "artifacts-dir", "",
+		"Directory with the artifacts and their SHA256SUMS manifest for offline bootstrapping. The kubelet, "+
+			"containerd binaries, pause image, CNI binaries and hybrid-overlay-node are taken from it unless given "+
+			"explicitly")
+	flags.StringArrayVar(&bootstrapOpts.fetch.mirrors, "mirror", nil,
+		"HTTPS base URL the path of the kubelet and CNI URLs is appended to, tried before the URL. "+
+			"Can be specified multiple times")
+	flags.StringVar(&bootstrapOpts.fetch.downloadDir, "download-dir", "",
+		"Directory the kubelet and CNI binaries are downloaded to. Defaults to the downloads directory in the "+
+			"install dir")
+	flags.StringVar(&bootstrapOpts.kubeletSHA256, "kubelet-sha256", "",
+		"SHA256 checksum of the kubelet.exe. Required with --kubelet-url")
+	flags.StringVar(&bootstrapOpts.cniSHA256, "cni-sha256", "",
+		"SHA256 checksum of the CNI binaries archive. Required with --cni-url")
+	addRecoveryFlags(flags, &bootstrapOpts.recovery)
+	flags.DurationVar(&bootstrapOpts.certificatesTimeout, "certificates-timeout", 10*time.Minute,
+		"Time to wait for the CSRs of the node to be approved by an external approver and the kubelet certificates to "+
+			"be issued")
+	flags.DurationVar(&bootstrapOpts.nodeReadyTimeout, "node-ready-timeout", 10*time.Minute,
+		"Time to wait for the node to be registered and Ready once kube-proxy is configured")
+	flags.StringVar(&bootstrapOpts.fromPhase, "from-phase", bootstrapPhases[0].name,
+		"Phase the bootstrap starts from, one of "+strings.Join(bootstrapPhaseNames(), ", ")+
+			". Used to resume a failed bootstrap. Defaults to "+bootstrapPhases[0].name)
+	flags.BoolVar(&bootstrapOpts.rollbackOnFailure, "rollback-on-failure", false,
+		"Roll back the changes made to the Windows node if a phase fails, instead of leaving the node to be resumed "+
+			"with --from-phase")
+	flags.BoolVar(&bootstrapOpts.force, "force", false,
+		"Bootstrap the Windows node again even if the bootstrap status shows that it has already been bootstrapped")
+	flags.BoolVar(&bootstrapOpts.enableFeatures, "enable-features", false,
+		"Enable the Windows features and services required by the container runtime if they are not enabled")
+	flags.BoolVar(&enableFeaturesOpts.hyperV, "hyperv", false,
+		"Enable the Hyper-V feature along with the Containers one with --enable-features")
+}
+
+// bootstrapPhaseNames returns the names of the bootstrap phases in the order they are run
+func bootstrapPhaseNames() []string {
+	var names []string
+	for _, phase := range bootstrapPhases {
+		names = append(names, phase.name)
+	}
+	return names
+}
+
+// bootstrapPhasesFrom returns the bootstrap phases starting from the phase with the given name
+func bootstrapPhasesFrom(name string) ([]bootstrapPhase, error) {
+	for i, phase := range bootstrapPhases {
+		if phase.name == name {
+			return bootstrapPhases[i:], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown phase %s, expected one of %s", name, strings.Join(bootstrapPhaseNames(), ", "))
+}
+
+// setBootstrapOpts populates the options of the commands run by the bootstrap phases with the bootstrap options they
+// share
+func setBootstrapOpts() {
+	enableFeaturesOpts.installDir = bootstrapOpts.installDir
+	enableFeaturesOpts.reboot = applyUpdatesOpts.reboot
+	applyUpdatesOpts.installDir = bootstrapOpts.installDir
+
+	initializeKubeletOpts.installDir = bootstrapOpts.installDir
+	initializeKubeletOpts.artifactsDir = bootstrapOpts.artifactsDir
+	initializeKubeletOpts.fetch = bootstrapOpts.fetch
+	initializeKubeletOpts.fetch.sha256 = bootstrapOpts.kubeletSHA256
+	initializeKubeletOpts.recovery = bootstrapOpts.recovery
+
+	configureCNIOpts.installDir = bootstrapOpts.installDir
+	configureCNIOpts.artifactsDir = bootstrapOpts.artifactsDir
+	configureCNIOpts.fetch = bootstrapOpts.fetch
+	configureCNIOpts.fetch.sha256 = bootstrapOpts.cniSHA256
+	configureCNIOpts.recovery = bootstrapOpts.recovery
+	// The CNI config values shared with kube-proxy are given using the configure-kube-proxy flags
+	configureCNIOpts.clusterCIDR = configureKubeProxyOpts.clusterCIDR
+	configureCNIOpts.sourceVIP = configureKubeProxyOpts.sourceVIP
+	configureCNIOpts.networkName = configureKubeProxyOpts.networkName
+
+	configureKubeProxyOpts.installDir = bootstrapOpts.installDir
+	configureKubeProxyOpts.recovery = bootstrapOpts.recovery
+	configureKubeProxyOpts.serviceAccount = initializeKubeletOpts.serviceAccount
+}
+
+// waitForCSRApproval waits for the CSRs of the node to be approved outside of wmcb and for the kubelet certificates
+// to be issued with the bootstrap options
+func waitForCSRApproval(ctx context.Context) error {
+	wmcb, err := bootstrapper.New(bootstrapper.WithInstallDir(bootstrapOpts.installDir))
+	if err != nil {
+		return fmt.Errorf("could not create bootstrapper: %w", err)
+	}
+	defer func() {
+		if err := wmcb.Disconnect(); err != nil {
+			log.Error(err, "can't clean up bootstrapper")
+		}
+	}()
+
+	if err = wmcb.SetCertDir(initializeKubeletOpts.certDir); err != nil {
+		return invalidInput("could not set cert dir: %v", err)
+	}
+	return wmcb.WaitForKubeletCertificates(ctx, bootstrapOpts.certificatesTimeout)
+}
+
+// waitForNodeReady waits for the node to be Ready with the bootstrap options
+func waitForNodeReady(ctx context.Context) error {
+	wmcb, err := bootstrapper.New(bootstrapper.WithInstallDir(bootstrapOpts.installDir))
+	if err != nil {
+		return fmt.Errorf("could not create bootstrapper: %w", err)
+	}
+	defer func() {
+		if err := wmcb.Disconnect(); err != nil {
+			log.Error(err, "can't clean up bootstrapper")
+		}
+	}()
+
+	// The node name is overridden by the hostname-override kubelet argument
+	kubeletArgs, err := parseKeyValues("kubelet argument", initializeKubeletOpts.kubeletArgs)
+	if err != nil {
+		return invalidInput("could not parse kubelet arguments: %v", err)
+	}
+	if err = wmcb.SetKubeletArgs(kubeletArgs); err != nil {
+		return invalidInput("could not set kubelet arguments: %v", err)
+	}
+	return wmcb.WaitForNodeReady(ctx, bootstrapOpts.nodeReadyTimeout)
+}
+
+// nodeBootstrapped returns true if every phase of the bootstrap command succeeded according to the bootstrap status
+// in the given install directory
+func nodeBootstrapped(installDir string) bool {
+	status, err := bootstrapper.ReadStatus(installDir)
+	if err != nil {
+		return false
+	}
+	return status.Succeeded(bootstrapper.PhaseFilesWritten, bootstrapper.PhaseServiceCreated,
+		bootstrapper.PhaseKubeletStarted, bootstrapper.PhaseCertificatesIssued, bootstrapper.PhaseCNIConfigured,
+		bootstrapper.PhaseKubeProxyConfigured)
+}
+
+// runBootstrapCmd bootstraps the Windows node by running the bootstrap phases in order
+func runBootstrapCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	phases, err := bootstrapPhasesFrom(bootstrapOpts.fromPhase)
+	if err != nil {
+		log.Error(err, "could not bootstrap")
+		os.Exit(exitValidation)
+	}
+	// A resumed bootstrap runs the given phases regardless of the status
+	if !bootstrapOpts.force && bootstrapOpts.fromPhase == bootstrapPhases[0].name &&
+		nodeBootstrapped(bootstrapOpts.installDir) {
+		log.Info("Windows node is already bootstrapped, use --force to bootstrap it again",
+			"exitCode", exitAlreadyBootstrapped)
+		os.Exit(exitAlreadyBootstrapped)
+	}
+	for _, phase := range phases {
+		log.Info("running bootstrap phase", "phase", phase.name)
+		if err = phase.run(cmd.Context()); err != nil {
+			if !bootstrapOpts.rollbackOnFailure {
+				log.Error(err, "bootstrap phase failed, resume with --from-phase once the failure has been addressed",
+					"phase", phase.name)
+				os.Exit(exitCode(err))
+			}
+			log.Error(err, "bootstrap phase failed, rolling back bootstrap", "phase", phase.name)
+			code := exitCode(err)
+			if err = rollbackBootstrap(bootstrapOpts.installDir); err != nil {
+				log.Error(err, "could not roll back bootstrap")
+			}
+			os.Exit(code)
+		}
+	}
+	// Send success message to StdOut for WSU to ascertain that bootstrapping was successful
+	os.Stdout.WriteString("Bootstrapping completed successfully")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+)
+
+var (
+	// cleanupNodeCmd describes the cleanup-node command
+	cleanupNodeCmd = &cobra.Command{
+		Use:   "cleanup-node",
+		Short: "Deconfigures the Windows node so that it can be removed from the cluster",
+		Long: "Deconfigures the Windows node so that it can be removed from the cluster. " +
+			"This cordons and drains the node, stops the kubelet, kube-proxy and hybrid-overlay-node services, " +
+			"deletes the HNS networks and endpoints used by CNI and optionally deletes the node object. " +
+			"The services and install directory can then be removed using the uninstall command.",
+		Run: runCleanupNodeCmd,
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return cmd.MarkPersistentFlagRequired("kubeconfig")
+		},
+	}
+
+	// cleanupNodeOpts holds the cleanup-node CLI options
+	cleanupNodeOpts struct {
+		// installDir is the main installation directory
+		installDir string
+		// kubeconfig is the location of the kubeconfig used to drain and delete the node object
+		kubeconfig string
+		// nodeName is the name of the node object of this Windows node
+		nodeName string
+		// drainTimeout is the time to wait for the pods of the node to be evicted
+		drainTimeout time.Duration
+		// deleteNode is set if the node object is deleted
+		deleteNode bool
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(cleanupNodeCmd)
+	cleanupNodeCmd.PersistentFlags().StringVar(&cleanupNodeOpts.installDir, "install-dir", "c:\\k",
+		"Installation directory. Defaults to C:\\k")
+	cleanupNodeCmd.PersistentFlags().StringVar(&cleanupNodeOpts.kubeconfig, "kubeconfig", "",
+		"The location of the kubeconfig used to cordon, drain and delete the node. It needs to be allowed to evict "+
+			"the pods of the node")
+	cleanupNodeCmd.PersistentFlags().StringVar(&cleanupNodeOpts.nodeName, "node-name", "",
+		"The name of the node object. Defaults to the lower case hostname")
+	cleanupNodeCmd.PersistentFlags().DurationVar(&cleanupNodeOpts.drainTimeout, "drain-timeout", 5*time.Minute,
+		"Time to wait for the pods of the node to be evicted. Defaults to 5m")
+	cleanupNodeCmd.PersistentFlags().BoolVar(&cleanupNodeOpts.deleteNode, "delete-node", false,
+		"Delete the node object once the node has been cleaned up")
+}
+
+// runCleanupNodeCmd deconfigures the Windows node so that it can be removed from the cluster
+func runCleanupNodeCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+	wmcb, err := bootstrapper.New(bootstrapper.WithInstallDir(cleanupNodeOpts.installDir))
+	if err != nil {
+		log.Error(err, "could not create bootstrapper")
+		os.Exit(exitCode(err))
+	}
+
+	err = wmcb.CleanupNode(cmd.Context(), cleanupNodeOpts.kubeconfig, cleanupNodeOpts.nodeName,
+		cleanupNodeOpts.drainTimeout, cleanupNodeOpts.deleteNode)
+	if err != nil {
+		log.Error(err, "could not clean up node")
+		os.Exit(exitCode(err))
+	}
+
+	// Send success message to StdOut to ascertain that the node clean up was successful
+	os.Stdout.WriteString("Node cleanup completed successfully")
+
+	if err = wmcb.Disconnect(); err != nil {
+		log.Error(err, "can't clean up bootstrapper")
+	}
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
 
 	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/hns"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
@@ -17,15 +21,7 @@ var (
 			"This command needs to be executed every time initialize-kubelet is executed.",
 		Run: runConfigureCNICmd,
 		PreRunE: func(cmd *cobra.Command, _ []string) error {
-			err := cmd.MarkPersistentFlagRequired("cni-dir")
-			if err != nil {
-				return err
-			}
-			err = cmd.MarkPersistentFlagRequired("cni-config")
-			if err != nil {
-				return err
-			}
-			return nil
+			return markConfigureCNIFlagsRequired(cmd, "sha256")
 		},
 	}
 
@@ -37,40 +33,250 @@ var (
 		config string
 		// installDir is the main installation directory
 		installDir string
+		// hybridOverlayPath is the location of the hybrid-overlay-node.exe. The hybrid overlay is enabled only if it
+		// is set.
+		hybridOverlayPath string
+		// nodeName is the name of the node object of this Windows node, used by the hybrid-overlay-node
+		nodeName string
+		// artifactsDir is the directory containing a verified bundle of the artifacts required for offline
+		// bootstrapping
+		artifactsDir string
+		// url is the HTTPS URL of the CNI plugins archive, which is downloaded if dir is not set
+		url string
+		// fetch holds the options used to download the CNI plugins archive
+		fetch fetchOpts
+		// recovery holds the recovery settings of the hybrid-overlay-node Windows service
+		recovery recoveryOpts
+		// serviceCIDR is the CIDR range of the services in the cluster, substituted into the CNI config
+		serviceCIDR string
+		// clusterCIDR is the CIDR range of the pods in the cluster, substituted into the CNI config
+		clusterCIDR string
+		// sourceVIP is the IP address used as the source of the load balanced traffic, substituted into the CNI config
+		sourceVIP string
+		// dnsServerIP is the IP address of the cluster DNS service, substituted into the CNI config
+		dnsServerIP string
+		// networkName is the name of the HNS network, substituted into the CNI config
+		networkName string
+		// hnsNetworkType is the type of the HNS network created by WMCB. The network is managed by WMCB only if it is
+		// set.
+		hnsNetworkType string
+		// hnsNetworkSubnet is the CIDR of the subnet of the HNS network created by WMCB
+		hnsNetworkSubnet string
+		// hnsNetworkGateway is the gateway of the subnet of the HNS network created by WMCB
+		hnsNetworkGateway string
+		// hnsNetworkAdapter is the name of the network adapter the HNS network created by WMCB is bound to
+		hnsNetworkAdapter string
+		// dns holds the DNS settings of the kubelet and the Windows node
+		dns bootstrapper.DNSOptions
+		// dryRun indicates that the changes are printed instead of being made
+		dryRun bool
+		// rollbackOnFailure indicates that the changes of the bootstrap are rolled back if the configuration fails
+		rollbackOnFailure bool
 	}
 )
 
+// markConfigureCNIFlagsRequired marks the configure-cni flags required by the given options as required. sha256Flag is
+// the name of the flag of the SHA256 checksum of the download
+func markConfigureCNIFlagsRequired(cmd *cobra.Command, sha256Flag string) error {
+	if configureCNIOpts.url != "" {
+		if err := cmd.MarkPersistentFlagRequired(sha256Flag); err != nil {
+			return err
+		}
+	}
+	// The CNI binaries are taken from the artifacts dir or downloaded if either is given
+	if configureCNIOpts.artifactsDir == "" && configureCNIOpts.url == "" {
+		err := cmd.MarkPersistentFlagRequired("cni-dir")
+		if err != nil {
+			return err
+		}
+	}
+	err := cmd.MarkPersistentFlagRequired("cni-config")
+	if err != nil {
+		return err
+	}
+	// The HNS network is created from the network name, subnet and gateway
+	if configureCNIOpts.hnsNetworkType != "" {
+		for _, flag := range []string{"network-name", "hns-network-subnet", "hns-network-gateway"} {
+			if err = cmd.MarkPersistentFlagRequired(flag); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(configureCNICmd)
+	addConfigureCNIFlags(configureCNICmd.PersistentFlags())
 	configureCNICmd.PersistentFlags().StringVar(&configureCNIOpts.installDir, "install-dir", "c:\\k",
 		"Installation directory. Defaults to C:\\k")
-	configureCNICmd.PersistentFlags().StringVar(&configureCNIOpts.dir, "cni-dir", "",
+	configureCNICmd.PersistentFlags().StringVar(&configureCNIOpts.artifactsDir, "artifacts-dir", "",
+		"Directory with the artifacts and their SHA256SUMS manifest for offline bootstrapping. The CNI binaries and "+
+			"hybrid-overlay-node are taken from it unless given explicitly")
+	addFetchFlags(configureCNICmd.PersistentFlags(), &configureCNIOpts.fetch)
+	addRecoveryFlags(configureCNICmd.PersistentFlags(), &configureCNIOpts.recovery)
+	configureCNICmd.PersistentFlags().StringVar(&configureCNIOpts.clusterCIDR, "cluster-cidr", "",
+		"The CIDR range of the pods in the cluster, substituted for {{.ClusterCIDR}} in the CNI config")
+	configureCNICmd.PersistentFlags().StringVar(&configureCNIOpts.sourceVIP, "source-vip", "",
+		"The IP address used as the source of the load balanced traffic, substituted for {{.SourceVIP}} in the CNI "+
+			"config")
+	configureCNICmd.PersistentFlags().StringVar(&configureCNIOpts.networkName, "network-name", "",
+		"The name of the HNS network, substituted for {{.NetworkName}} in the CNI config. Defaults to the hybrid "+
+			"overlay network if the hybrid overlay is enabled")
+	configureCNICmd.PersistentFlags().BoolVar(&configureCNIOpts.dryRun, "dry-run", false,
+		"Parse and validate the inputs, and print the files that would be written, the kubelet command line and the "+
+			"Windows services that would be created or updated without making any change to the Windows node")
+	configureCNICmd.PersistentFlags().BoolVar(&configureCNIOpts.rollbackOnFailure, "rollback-on-failure", false,
+		"Roll back the changes made to the Windows node since the kubelet was initialized if the configuration fails")
+}
+
+// addConfigureCNIFlags adds the configure-cni flags that are not shared with the other commands to the given flag set
+func addConfigureCNIFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&configureCNIOpts.dir, "cni-dir", "",
 		"The location of the CNI binaries")
-	configureCNICmd.PersistentFlags().StringVar(&configureCNIOpts.config, "cni-config", "",
-		"The location of the CNI configuration file")
+	flags.StringVar(&configureCNIOpts.config, "cni-config", "",
+		"The location of the CNI configuration file, or of a directory of .conf, .conflist and .json CNI "+
+			"configuration files, of which the first one in lexical order is used by the kubelet")
+	flags.StringVar(&configureCNIOpts.hybridOverlayPath, "hybrid-overlay-path", "",
+		"The location of hybrid-overlay-node.exe. If set, the OVN hybrid-overlay-node is run as a Windows service")
+	flags.StringVar(&configureCNIOpts.nodeName, "node-name", "",
+		"The name of the node object used by the hybrid-overlay-node. Defaults to the lower case hostname")
+	flags.StringVar(&configureCNIOpts.url, "cni-url", "",
+		"HTTPS URL of the .zip, .tar.gz or .tgz archive of the CNI binaries, which is downloaded and extracted when "+
+			"--cni-dir is not given. Requires the SHA256 checksum of the archive")
+	flags.StringVar(&configureCNIOpts.serviceCIDR, "service-cidr", "",
+		"The CIDR range of the services in the cluster, substituted for {{.ServiceCIDR}} in the CNI config")
+	flags.StringVar(&configureCNIOpts.dnsServerIP, "dns-server-ip", "",
+		"The IP address of the cluster DNS service, substituted for {{.DNSServerIP}} in the CNI config. Defaults to "+
+			"the first clusterDNS IP of the kubelet configuration")
+	flags.StringVar(&configureCNIOpts.hnsNetworkType, "hns-network-type", "",
+		"Type of the HNS network named --network-name that is created, or recreated if its settings differ, before "+
+			"configuring CNI. One of overlay or l2bridge. If not given, the network is expected to be created by "+
+			"someone else")
+	flags.StringVar(&configureCNIOpts.hnsNetworkSubnet, "hns-network-subnet", "",
+		"The CIDR of the subnet of the HNS network. Required with --hns-network-type")
+	flags.StringVar(&configureCNIOpts.hnsNetworkGateway, "hns-network-gateway", "",
+		"The gateway of the subnet of the HNS network. Required with --hns-network-type")
+	flags.StringVar(&configureCNIOpts.hnsNetworkAdapter, "hns-network-adapter", "",
+		"The name of the network adapter the HNS network is bound to. Picked by HNS if not given")
+	flags.StringVar(&configureCNIOpts.dns.ResolvConf, "resolv-conf", "",
+		"resolv.conf file the kubelet passes to the pods. Defaults to an empty file, which makes the pods use the DNS "+
+			"settings of the CNI config")
+	flags.BoolVar(&configureCNIOpts.dns.ManageHostDNS, "manage-host-dns", false,
+		"Configure the Windows node to resolve the names of the cluster domain of the kubelet configuration through "+
+			"the cluster DNS, and add the cluster DNS suffixes to its search list")
+	flags.StringArrayVar(&configureCNIOpts.dns.SearchSuffixes, "dns-search-suffix", nil,
+		"DNS suffix added to the search list of the Windows node. Defaults to svc.<cluster domain> and <cluster "+
+			"domain>. Can be specified multiple times. Only used with --manage-host-dns")
+	flags.BoolVar(&configureCNIOpts.dns.IgnoreConflicts, "ignore-dns-conflicts", false,
+		"Configure the host DNS even if existing rules, like the ones of a VPN client, send the queries of the "+
+			"cluster domain elsewhere. Only used with --manage-host-dns")
 }
 
 // runConfigureCNICmd configures the CNI on the Windows node
 func runConfigureCNICmd(cmd *cobra.Command, args []string) {
 	flag.Parse()
 
-	wmcb, err := bootstrapper.NewWinNodeBootstrapper(configureCNIOpts.installDir, "", "", configureCNIOpts.dir,
-		configureCNIOpts.config)
-	if err != nil {
-		log.Error(err, "could not create bootstrapper")
-		os.Exit(1)
-	}
-
-	err = wmcb.Configure()
-	if err != nil {
+	if err := configureCNI(cmd.Context()); err != nil {
 		log.Error(err, "could not configure CNI")
-		os.Exit(1)
+		code := exitCode(err)
+		if configureCNIOpts.rollbackOnFailure && !configureCNIOpts.dryRun {
+			if err = rollbackBootstrap(configureCNIOpts.installDir); err != nil {
+				log.Error(err, "could not roll back bootstrap")
+			}
+		}
+		os.Exit(code)
+	}
+	if configureCNIOpts.dryRun {
+		return
 	}
 	// Send success message to StdOut for WSU to ascertain that CNI configuration was successful
 	os.Stdout.WriteString("CNI configuration completed successfully")
+}
+
+// configureCNI configures CNI with the configure-cni options
+func configureCNI(ctx context.Context) error {
+	cniDir := configureCNIOpts.dir
+	hybridOverlayPath := configureCNIOpts.hybridOverlayPath
+	if cniDir == "" && configureCNIOpts.url != "" {
+		if configureCNIOpts.dryRun {
+			return invalidInput("--cni-url cannot be used with --dry-run, as the CNI binaries are downloaded to the " +
+				"install dir")
+		}
+		// The proxy is taken from the environment
+		fetcher, err := configureCNIOpts.fetch.newFetcher(configureCNIOpts.installDir, "")
+		if err != nil {
+			return fmt.Errorf("could not create fetcher: %v", err)
+		}
+		cniDir, err = fetcher.FetchArchive(ctx, configureCNIOpts.url, configureCNIOpts.fetch.sha256)
+		if err != nil {
+			return fmt.Errorf("could not download CNI binaries: %w", err)
+		}
+	}
+	if configureCNIOpts.artifactsDir != "" {
+		artifacts, err := bootstrapper.NewArtifacts(configureCNIOpts.artifactsDir)
+		if err != nil {
+			return invalidInput("could not verify artifacts: %v", err)
+		}
+		if cniDir == "" {
+			cniDir = artifacts.CNIDir()
+		}
+		if hybridOverlayPath == "" {
+			hybridOverlayPath = artifacts.HybridOverlayPath()
+		}
+	}
+
+	wmcb, err := bootstrapper.New(bootstrapper.WithInstallDir(configureCNIOpts.installDir),
+		bootstrapper.WithCNI(cniDir, configureCNIOpts.config))
+	if err != nil {
+		return fmt.Errorf("could not create bootstrapper: %w", err)
+	}
+	defer func() {
+		if err := wmcb.Disconnect(); err != nil {
+			log.Error(err, "can't clean up bootstrapper")
+		}
+	}()
 
-	err = wmcb.Disconnect()
+	recovery := configureCNIOpts.recovery
+	err = wmcb.SetServiceRecovery(recovery.restartOnFailure, recovery.restartDelay, recovery.resetPeriod)
 	if err != nil {
-		log.Error(err, "can't clean up bootstrapper")
+		return invalidInput("could not set service recovery: %v", err)
+	}
+	err = wmcb.SetCNIConfigValues(configureCNIOpts.serviceCIDR, configureCNIOpts.clusterCIDR,
+		configureCNIOpts.sourceVIP, configureCNIOpts.dnsServerIP, configureCNIOpts.networkName)
+	if err != nil {
+		return invalidInput("could not set CNI config values: %v", err)
+	}
+	if err = wmcb.SetDNS(configureCNIOpts.dns); err != nil {
+		return invalidInput("could not set DNS: %v", err)
+	}
+	if configureCNIOpts.hnsNetworkType != "" {
+		err = wmcb.SetHNSNetwork(hns.NetworkConfig{
+			Name:          configureCNIOpts.networkName,
+			Type:          hns.NetworkType(configureCNIOpts.hnsNetworkType),
+			AddressPrefix: configureCNIOpts.hnsNetworkSubnet,
+			Gateway:       configureCNIOpts.hnsNetworkGateway,
+			AdapterName:   configureCNIOpts.hnsNetworkAdapter,
+		})
+		if err != nil {
+			return invalidInput("could not set HNS network: %v", err)
+		}
+	}
+	if hybridOverlayPath != "" {
+		err = wmcb.EnableHybridOverlay(hybridOverlayPath, configureCNIOpts.nodeName)
+		if err != nil {
+			return invalidInput("could not enable hybrid overlay: %v", err)
+		}
+	}
+
+	if configureCNIOpts.dryRun {
+		wmcb.SetDryRun()
+	}
+	if err = wmcb.Configure(ctx); err != nil {
+		return err
+	}
+	if configureCNIOpts.dryRun {
+		printDryRunPlan(os.Stdout, wmcb.DryRunPlan())
 	}
+	return nil
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+)
+
+var (
+	// configureHostSecurityCmd describes the configure-host-security command
+	configureHostSecurityCmd = &cobra.Command{
+		Use:   "configure-host-security",
+		Short: "Configures the Windows Firewall rules and Windows Defender exclusions required by the node",
+		Long: "Configures the Windows Firewall rules required by the node components, which open the kubelet, " +
+			"hybrid overlay VXLAN and kube-proxy health check ports, and excludes the node components from the " +
+			"Windows Defender real-time scanning. With --dry-run, the PowerShell commands are printed instead of " +
+			"being run.",
+		Run: runConfigureHostSecurityCmd,
+	}
+
+	// configureHostSecurityOpts holds the configure-host-security CLI options
+	configureHostSecurityOpts struct {
+		// installDir is the main installation directory
+		installDir string
+		// dryRun indicates that the commands are printed instead of being run
+		dryRun bool
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(configureHostSecurityCmd)
+	configureHostSecurityCmd.PersistentFlags().StringVar(&configureHostSecurityOpts.installDir, "install-dir",
+		"c:\\k", "Installation directory. Defaults to C:\\k")
+	configureHostSecurityCmd.PersistentFlags().BoolVar(&configureHostSecurityOpts.dryRun, "dry-run", false,
+		"Print the PowerShell commands instead of running them")
+}
+
+// runConfigureHostSecurityCmd configures the Windows Firewall rules and Windows Defender exclusions
+func runConfigureHostSecurityCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+	wmcb, err := bootstrapper.New(bootstrapper.WithInstallDir(configureHostSecurityOpts.installDir))
+	if err != nil {
+		log.Error(err, "could not create bootstrapper")
+		os.Exit(exitCode(err))
+	}
+
+	commands, err := wmcb.ConfigureHostSecurity(configureHostSecurityOpts.dryRun)
+	if err != nil {
+		log.Error(err, "could not configure host security")
+		os.Exit(exitCode(err))
+	}
+	if configureHostSecurityOpts.dryRun {
+		os.Stdout.WriteString(strings.Join(commands, "\n") + "\n")
+	} else {
+		// Send success message to StdOut to ascertain that the configuration was successful
+		os.Stdout.WriteString("Host security configured successfully")
+	}
+
+	if err = wmcb.Disconnect(); err != nil {
+		log.Error(err, "can't clean up bootstrapper")
+	}
+}
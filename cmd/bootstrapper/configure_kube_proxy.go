@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	// configureKubeProxyCmd describes the configure-kube-proxy command
+	configureKubeProxyCmd = &cobra.Command{
+		Use:   "configure-kube-proxy",
+		Short: "Configures kube-proxy on the Windows node",
+		Long: "Configures kube-proxy on the Windows node and runs it as a Windows service. " +
+			"This command needs to be executed after initialize-kubelet is executed.",
+		Run: runConfigureKubeProxyCmd,
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return markConfigureKubeProxyFlagsRequired(cmd)
+		},
+	}
+
+	// configureKubeProxyOpts holds the configure-kube-proxy CLI options
+	configureKubeProxyOpts struct {
+		// path is the location where kube-proxy.exe has been downloaded to
+		path string
+		// clusterCIDR is the CIDR range of the pods in the cluster
+		clusterCIDR string
+		// networkName is the name of the HNS network kube-proxy programs the load balancers on
+		networkName string
+		// sourceVIP is the IP address used as the source of the load balanced traffic on the node
+		sourceVIP string
+		// installDir is the main installation directory
+		installDir string
+		// recovery holds the recovery settings of the kube-proxy Windows service
+		recovery recoveryOpts
+		// serviceAccount is the account the kube-proxy Windows service is run as instead of LocalSystem
+		serviceAccount string
+	}
+)
+
+// markConfigureKubeProxyFlagsRequired marks the configure-kube-proxy flags required by the given options as required
+func markConfigureKubeProxyFlagsRequired(cmd *cobra.Command) error {
+	err := cmd.MarkPersistentFlagRequired("kube-proxy-path")
+	if err != nil {
+		return err
+	}
+	err = cmd.MarkPersistentFlagRequired("cluster-cidr")
+	if err != nil {
+		return err
+	}
+	err = cmd.MarkPersistentFlagRequired("network-name")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(configureKubeProxyCmd)
+	addConfigureKubeProxyFlags(configureKubeProxyCmd.PersistentFlags())
+	configureKubeProxyCmd.PersistentFlags().StringVar(&configureKubeProxyOpts.installDir, "install-dir", "c:\\k",
+		"Installation directory. Defaults to C:\\k")
+	addRecoveryFlags(configureKubeProxyCmd.PersistentFlags(), &configureKubeProxyOpts.recovery)
+	configureKubeProxyCmd.PersistentFlags().StringVar(&configureKubeProxyOpts.serviceAccount, "service-account", "",
+		"Runs the kube-proxy Windows service as the given account with the minimal rights it requires instead of "+
+			"LocalSystem. Either virtual, for the NT SERVICE\\kube-proxy virtual account, or a group managed service "+
+			"account in the DOMAIN\\name$ form")
+}
+
+// addConfigureKubeProxyFlags adds the configure-kube-proxy flags that are not shared with the other commands to the
+// given flag set
+func addConfigureKubeProxyFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&configureKubeProxyOpts.path, "kube-proxy-path", "",
+		"The location of kube-proxy.exe")
+	flags.StringVar(&configureKubeProxyOpts.clusterCIDR, "cluster-cidr", "",
+		"The CIDR range of the pods in the cluster")
+	flags.StringVar(&configureKubeProxyOpts.networkName, "network-name", "",
+		"The name of the HNS network kube-proxy programs the load balancers on")
+	flags.StringVar(&configureKubeProxyOpts.sourceVIP, "source-vip", "",
+		"The IP address used as the source of the load balanced traffic. Required for overlay networks")
+}
+
+// runConfigureKubeProxyCmd configures kube-proxy on the Windows node
+func runConfigureKubeProxyCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	if err := configureKubeProxy(cmd.Context()); err != nil {
+		log.Error(err, "could not configure kube-proxy")
+		os.Exit(exitCode(err))
+	}
+	// Send success message to StdOut for WSU to ascertain that kube-proxy configuration was successful
+	os.Stdout.WriteString("kube-proxy configuration completed successfully")
+}
+
+// configureKubeProxy configures kube-proxy with the configure-kube-proxy options
+func configureKubeProxy(ctx context.Context) error {
+	wmcb, err := bootstrapper.New(bootstrapper.WithInstallDir(configureKubeProxyOpts.installDir))
+	if err != nil {
+		return fmt.Errorf("could not create bootstrapper: %w", err)
+	}
+	defer func() {
+		if err := wmcb.Disconnect(); err != nil {
+			log.Error(err, "can't clean up bootstrapper")
+		}
+	}()
+
+	recovery := configureKubeProxyOpts.recovery
+	err = wmcb.SetServiceRecovery(recovery.restartOnFailure, recovery.restartDelay, recovery.resetPeriod)
+	if err != nil {
+		return invalidInput("could not set service recovery: %v", err)
+	}
+	if configureKubeProxyOpts.serviceAccount != "" {
+		if err = wmcb.SetServiceAccount(configureKubeProxyOpts.serviceAccount); err != nil {
+			return invalidInput("could not set service account: %v", err)
+		}
+	}
+
+	return wmcb.ConfigureKubeProxy(ctx, configureKubeProxyOpts.path, configureKubeProxyOpts.clusterCIDR,
+		configureKubeProxyOpts.networkName, configureKubeProxyOpts.sourceVIP)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+)
+
+// printDryRunPlan prints the changes recorded in dry-run mode to the given writer
+func printDryRunPlan(w io.Writer, plan *bootstrapper.DryRunPlan) {
+	fmt.Fprintln(w, "Files that would be written:")
+	for _, file := range plan.Files {
+		details := []string{fmt.Sprintf("%d bytes", file.Size)}
+		if file.Source != "" {
+			details = append(details, "from "+file.Source)
+		}
+		if file.Unchanged {
+			details = append(details, "unchanged")
+		}
+		fmt.Fprintf(w, "  %s (%s)\n", file.Path, strings.Join(details, ", "))
+	}
+	fmt.Fprintln(w, "Windows services that would be created or updated:")
+	for _, service := range plan.Services {
+		action := "create"
+		if service.Exists {
+			action = "update"
+		}
+		fmt.Fprintf(w, "  %s (%s): %s\n", service.Name, action, service.Command)
+		if len(service.Dependencies) > 0 {
+			fmt.Fprintf(w, "    depends on %s\n", strings.Join(service.Dependencies, ", "))
+		}
+	}
+	if len(plan.Actions) > 0 {
+		fmt.Fprintln(w, "Other changes:")
+		for _, action := range plan.Actions {
+			fmt.Fprintf(w, "  %s\n", action)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+)
+
+var (
+	// enableFeaturesCmd describes the enable-features command
+	enableFeaturesCmd = &cobra.Command{
+		Use:   "enable-features",
+		Short: "Enables the Windows features and services required by the container runtime",
+		Long: "Enables the Containers Windows feature, and the Hyper-V one with --hyperv, along with the hns and " +
+			"vmcompute services of the Host Networking Service and Host Compute Service, instead of assuming that " +
+			"the image of the Windows node was prepared with them. The features already enabled are left as is. If " +
+			"the node needs to be rebooted for the features to take effect, wmcb exits with code 5 and the node is " +
+			"rebooted if --reboot is given. Running wmcb again once the node has rebooted enables the services.",
+		Run: runEnableFeaturesCmd,
+	}
+
+	// enableFeaturesOpts holds the enable-features CLI options
+	enableFeaturesOpts struct {
+		// installDir is the main installation directory
+		installDir string
+		// hyperV enables the Hyper-V feature along with the Containers one
+		hyperV bool
+		// reboot indicates that the node is rebooted if the features require it
+		reboot bool
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(enableFeaturesCmd)
+	enableFeaturesCmd.PersistentFlags().StringVar(&enableFeaturesOpts.installDir, "install-dir", "c:\\k",
+		"Installation directory. Defaults to C:\\k")
+	enableFeaturesCmd.PersistentFlags().BoolVar(&enableFeaturesOpts.hyperV, "hyperv", false,
+		"Enable the Hyper-V feature, for running Hyper-V isolated containers")
+	enableFeaturesCmd.PersistentFlags().BoolVar(&enableFeaturesOpts.reboot, "reboot", false,
+		"Reboot the Windows node if the enabled features require it")
+}
+
+// enableWindowsFeatures enables the Windows features and services required by the container runtime, and reboots the
+// node if required and --reboot is given
+func enableWindowsFeatures(ctx context.Context) error {
+	wmcb, err := bootstrapper.New(bootstrapper.WithInstallDir(enableFeaturesOpts.installDir))
+	if err != nil {
+		return fmt.Errorf("could not create bootstrapper: %w", err)
+	}
+	defer func() {
+		if err := wmcb.Disconnect(); err != nil {
+			log.Error(err, "can't clean up bootstrapper")
+		}
+	}()
+
+	err = wmcb.EnableWindowsFeatures(ctx, enableFeaturesOpts.hyperV)
+	rebootIfRequired(err, enableFeaturesOpts.reboot)
+	return err
+}
+
+// runEnableFeaturesCmd enables the Windows features and services required by the container runtime
+func runEnableFeaturesCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+	if err := enableWindowsFeatures(cmd.Context()); err != nil {
+		log.Error(err, "could not enable Windows features")
+		os.Exit(exitCode(err))
+	}
+	// Send success message to StdOut to ascertain that the features were enabled
+	os.Stdout.WriteString("Windows features enabled successfully")
+}
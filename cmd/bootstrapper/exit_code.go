@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+)
+
+// The exit codes of wmcb are a contract with the automation running it, which branches on them rather than on the
+// logs. The codes of the existing categories must not change.
+const (
+	// exitPermanent is the exit code of the failures that are not resolved by running wmcb again, until the Windows
+	// node has been fixed
+	exitPermanent = 1
+	// exitValidation is the exit code of the failures caused by invalid flags or inputs, like an invalid ignition file
+	// or CNI config
+	exitValidation = 2
+	// exitTransient is the exit code of the failures caused by an endpoint that could not be reached or did not respond
+	// in time. Running wmcb again once the endpoint is available resumes the bootstrap.
+	exitTransient = 3
+	// exitAlreadyBootstrapped is the exit code of the bootstrap command when the Windows node has already been
+	// bootstrapped, in which case no change is made to it
+	exitAlreadyBootstrapped = 4
+	// exitRebootRequired is the exit code of the failures caused by changes that only take effect once the Windows node
+	// has been rebooted. Running wmcb again once the node has rebooted resumes the bootstrap.
+	exitRebootRequired = 5
+)
+
+// failureClasses maps the classes of bootstrap failures to the exit code of their category and the hint logged on how
+// to address them. The permissions class comes first, as a service that cannot be created because wmcb is not elevated
+// is a permissions failure rather than a service one.
+var failureClasses = []struct {
+	// err is the bootstrapper error the failures of the class match
+	err error
+	// code is the exit code of the category of the class
+	code int
+	// hint describes how to address the failures of the class
+	hint string
+}{
+	{
+		err:  bootstrapper.ErrPermissions,
+		code: exitPermanent,
+		hint: "run wmcb from an elevated prompt, as an Administrator",
+	},
+	{
+		err:  bootstrapper.ErrRebootRequired,
+		code: exitRebootRequired,
+		hint: "reboot the Windows node, or use --reboot, and run wmcb again once it has rebooted",
+	},
+	{
+		err:  bootstrapper.ErrTransient,
+		code: exitTransient,
+		hint: "retry once the API server, the machine config server or the download mirrors are reachable",
+	},
+	{
+		err:  bootstrapper.ErrIgnitionParse,
+		code: exitValidation,
+		hint: "check that --ignition-file or --ignition-url is the worker ignition of the cluster",
+	},
+	{
+		err:  bootstrapper.ErrServiceCreate,
+		code: exitPermanent,
+		hint: "check that no conflicting Windows service is installed, or uninstall the previous bootstrap",
+	},
+	{
+		err:  bootstrapper.ErrCNIInvalid,
+		code: exitValidation,
+		hint: "check that --cni-dir holds the CNI plugins and --cni-config is a valid CNI config",
+	},
+	{
+		err:  bootstrapper.ErrVersionSkew,
+		code: exitValidation,
+		hint: "use a kubelet supported by wmcb and compatible with the cluster version, or pass --skip-version-check",
+	},
+}
+
+// validationError is returned when the flags given to a command are invalid
+type validationError struct {
+	err error
+}
+
+// invalidInput returns an error formatted according to the given format specifier, that is classified as a validation
+// failure
+func invalidInput(format string, args ...interface{}) error {
+	return &validationError{err: fmt.Errorf(format, args...)}
+}
+
+// Error returns the description of the invalid flags
+func (e *validationError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (e *validationError) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the exit code of the category of the given failure and logs the hint on how to address it
+func exitCode(err error) int {
+	for _, class := range failureClasses {
+		if errors.Is(err, class.err) {
+			log.Info(class.hint, "exitCode", class.code)
+			return class.code
+		}
+	}
+	var validationErr *validationError
+	var optionErr *bootstrapper.OptionError
+	if errors.As(err, &validationErr) || errors.As(err, &optionErr) {
+		return exitValidation
+	}
+	return exitPermanent
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+	"github.com/spf13/pflag"
+)
+
+// fetchOpts holds the CLI options of the commands that can download their artifacts over HTTPS
+type fetchOpts struct {
+	// sha256 is the SHA256 checksum of the downloaded artifact
+	sha256 string
+	// mirrors are the base URLs the artifact is downloaded from before its original URL
+	mirrors []string
+	// downloadDir is the directory the artifact is downloaded to
+	downloadDir string
+}
+
+// addFetchFlags adds the flags of the given fetch options to the given flag set
+func addFetchFlags(flags *pflag.FlagSet, opts *fetchOpts) {
+	flags.StringVar(&opts.sha256, "sha256", "",
+		"SHA256 checksum of the downloaded artifact. Required if the artifact is downloaded")
+	flags.StringArrayVar(&opts.mirrors, "mirror", nil,
+		"HTTPS base URL the path of the artifact URL is appended to, tried before the artifact URL. "+
+			"Can be specified multiple times")
+	flags.StringVar(&opts.downloadDir, "download-dir", "",
+		"Directory the artifact is downloaded to. Defaults to the downloads directory in the install dir")
+}
+
+// newFetcher returns the fetcher for the given fetch options, which downloads through the given proxy if it is set
+func (opts *fetchOpts) newFetcher(installDir, proxy string) (*bootstrapper.Fetcher, error) {
+	downloadDir := opts.downloadDir
+	if downloadDir == "" {
+		downloadDir = filepath.Join(installDir, "downloads")
+	}
+	return bootstrapper.NewFetcher(downloadDir, proxy, opts.mirrors, bootstrapper.WithLogger(log))
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
@@ -16,36 +20,300 @@ var (
 			"If this command is run after configure-cni is executed, it will overwrite the CNI options.",
 		Run: runInitializeKubeletCmd,
 		PreRunE: func(cmd *cobra.Command, _ []string) error {
-			err := cmd.MarkPersistentFlagRequired("ignition-file")
-			if err != nil {
-				return err
-			}
-			err = cmd.MarkPersistentFlagRequired("kubelet-path")
-			if err != nil {
-				return err
-			}
-			return nil
+			return markInitializeKubeletFlagsRequired(cmd, "sha256")
 		},
 	}
 
 	initializeKubeletOpts struct {
 		// The location of the ignition file
 		ignitionFile string
+		// The machine config server URL the ignition is fetched from instead of the ignition file
+		ignitionURL string
+		// The CA bundle the machine config server serving certificate is verified with
+		ignitionCA string
 		// The location where the kubelet.exe has been downloaded to
 		kubeletPath string
 		// The directory to install the kubelet and related files
 		installDir string
+		// The container runtime the kubelet is configured to use
+		containerRuntime string
+		// The directory where the containerd binaries have been downloaded to
+		containerdDir string
+		// Additional kubelet arguments in the key=value format that override the default kubelet arguments
+		kubeletArgs []string
+		// The directory in which the kubelet stores its certificates
+		certDir string
+		// The proxy used for HTTP requests
+		httpProxy string
+		// The proxy used for HTTPS requests
+		httpsProxy string
+		// The comma separated list of hosts, domains and CIDRs that are not proxied
+		noProxy string
+		// The directory containing a verified bundle of the artifacts required for offline bootstrapping
+		artifactsDir string
+		// Additional labels in the key=value format the node is registered with
+		nodeLabels []string
+		// Additional taints in the key=value:effect format the node is registered with
+		nodeTaints []string
+		// The paths of the image credential provider plugins the kubelet is configured with
+		credentialProviders []string
+		// Indicates that the workloads using Group Managed Service Accounts are supported
+		gmsa bool
+		// The DLL of the CCG plugin retrieving the GMSA credentials on a node that is not domain joined
+		ccgPlugin string
+		// The COM class ID the CCG plugin is registered with
+		ccgPluginCLSID string
+		// The names of the allowlisted files extracted from the ignition file in addition to the kubelet files
+		ignitionFiles []string
+		// The NTP servers the clock of the node is synchronized with
+		timeServers []string
+		// The JSON or YAML file with the KubeletConfiguration fields that override the generated kubelet configuration
+		kubeletConfigOverrides string
+		// The thresholds at which the kubelet evicts the pods and garbage collects the unused images
+		eviction evictionOpts
+		// The IP address the node is registered with
+		nodeIP string
+		// The name of the network adapter the IP address of the node is taken from
+		nodeIPInterface string
+		// Indicates that the kubelet is run with --cloud-provider=external whatever the ignition file
+		externalCloudProvider bool
+		// The location of the cloud node manager run as a Windows service with an external cloud provider
+		cloudNodeManagerPath string
+		// The HTTPS URL the kubelet.exe is downloaded from if kubeletPath is not set
+		kubeletURL string
+		// The options used to download the kubelet.exe
+		fetch fetchOpts
+		// The URL of the API server the bootstrap kubeconfig is generated for
+		apiServer string
+		// The CA bundle the API server serving certificate is verified with
+		caBundle string
+		// The bootstrap or service account token the bootstrap kubeconfig is generated with
+		bootstrapToken string
+		// The CA the kubelet authenticates the client certificates of the API server with
+		kubeletCA string
+		// The recovery settings of the kubelet Windows service
+		recovery recoveryOpts
+		// The account the kubelet Windows service is run as instead of LocalSystem
+		serviceAccount string
+		// Indicates that the Azure cloud config is rewritten to use the managed identity of the VM
+		cloudConfigManagedIdentity bool
+		// The ID of the user assigned identity the kubelet uses instead of the system assigned one
+		userAssignedIdentityID string
+		// Indicates that the kubelet version is not validated against the supported versions and the cluster version
+		skipVersionCheck bool
+		// Indicates that the changes are printed instead of being made
+		dryRun bool
+		// Indicates that the changes are rolled back if the initialization fails
+		rollbackOnFailure bool
 	}
 )
 
+// markInitializeKubeletFlagsRequired marks the initialize-kubelet flags required by the given options as required.
+// sha256Flag is the name of the flag of the SHA256 checksum of the download
+func markInitializeKubeletFlagsRequired(cmd *cobra.Command, sha256Flag string) error {
+	// The ignition is fetched from the machine config server instead of being read from the ignition file if
+	// its URL is given, and the bootstrap kubeconfig is generated instead of being taken from the ignition
+	// file if a token is given
+	if initializeKubeletOpts.ignitionURL != "" {
+		if err := cmd.MarkPersistentFlagRequired("ignition-ca"); err != nil {
+			return err
+		}
+	}
+	if initializeKubeletOpts.bootstrapToken == "" {
+		if initializeKubeletOpts.ignitionURL == "" {
+			if err := cmd.MarkPersistentFlagRequired("ignition-file"); err != nil {
+				return err
+			}
+		}
+	} else {
+		for _, name := range []string{"api-server", "ca-bundle"} {
+			if err := cmd.MarkPersistentFlagRequired(name); err != nil {
+				return err
+			}
+		}
+	}
+	if initializeKubeletOpts.kubeletURL != "" {
+		return cmd.MarkPersistentFlagRequired(sha256Flag)
+	}
+	// The kubelet is taken from the artifacts dir if it is given
+	if initializeKubeletOpts.artifactsDir != "" {
+		return nil
+	}
+	err := cmd.MarkPersistentFlagRequired("kubelet-path")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(initializeKubeletCmd)
-	initializeKubeletCmd.PersistentFlags().StringVar(&initializeKubeletOpts.ignitionFile, "ignition-file", "",
-		"Ignition file location to bootstrap the Windows node")
-	initializeKubeletCmd.PersistentFlags().StringVar(&initializeKubeletOpts.kubeletPath, "kubelet-path", "",
-		"Kubelet file location to bootstrap the Windows node")
+	addInitializeKubeletFlags(initializeKubeletCmd.PersistentFlags())
 	initializeKubeletCmd.PersistentFlags().StringVar(&initializeKubeletOpts.installDir, "install-dir", "c:\\k",
 		"Kubelet file location to bootstrap the Windows node. Defaults to C:\\k")
+	initializeKubeletCmd.PersistentFlags().StringVar(&initializeKubeletOpts.artifactsDir, "artifacts-dir", "",
+		"Directory with the artifacts and their SHA256SUMS manifest for offline bootstrapping. The kubelet, "+
+			"containerd binaries and pause image are taken from it unless given explicitly")
+	addFetchFlags(initializeKubeletCmd.PersistentFlags(), &initializeKubeletOpts.fetch)
+	addRecoveryFlags(initializeKubeletCmd.PersistentFlags(), &initializeKubeletOpts.recovery)
+	initializeKubeletCmd.PersistentFlags().BoolVar(&initializeKubeletOpts.dryRun, "dry-run", false,
+		"Parse and validate the inputs, and print the files that would be written, the kubelet command line and the "+
+			"Windows services that would be created without making any change to the Windows node")
+	initializeKubeletCmd.PersistentFlags().BoolVar(&initializeKubeletOpts.rollbackOnFailure, "rollback-on-failure",
+		false, "Roll back the changes made to the Windows node if the initialization fails")
+}
+
+// addInitializeKubeletFlags adds the initialize-kubelet flags that are not shared with the other commands to the given
+// flag set
+func addInitializeKubeletFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&initializeKubeletOpts.ignitionFile, "ignition-file", "",
+		"Ignition file location to bootstrap the Windows node. The worker ignition is fetched from the machine "+
+			"config server if it is the stub ignition of the worker machines. Not required with --ignition-url or "+
+			"--bootstrap-token")
+	flags.StringVar(&initializeKubeletOpts.ignitionURL, "ignition-url", "",
+		"HTTPS URL of the machine config server the worker ignition is fetched from instead of --ignition-file, "+
+			"for example https://api-int.<cluster domain>:22623/config/worker. Requires --ignition-ca")
+	flags.StringVar(&initializeKubeletOpts.ignitionCA, "ignition-ca", "",
+		"PEM encoded CA bundle the machine config server serving certificate is verified with, which is the root CA "+
+			"of the cluster. Only used with --ignition-url")
+	flags.StringVar(&initializeKubeletOpts.kubeletPath, "kubelet-path", "",
+		"Kubelet file location to bootstrap the Windows node")
+	flags.StringVar(&initializeKubeletOpts.containerRuntime, "container-runtime",
+		"docker", "Container runtime the kubelet is configured to use, either docker or containerd. Defaults to docker")
+	flags.StringVar(&initializeKubeletOpts.containerdDir, "containerd-dir", "",
+		"Directory where the containerd binaries have been downloaded to. Only used with the containerd runtime")
+	flags.StringArrayVar(&initializeKubeletOpts.kubeletArgs, "kubelet-arg", nil,
+		"Kubelet argument in the key=value format, for example max-pods=100. Overrides the default value of the "+
+			"argument if present. Can be specified multiple times")
+	flags.StringVar(&initializeKubeletOpts.certDir, "cert-dir",
+		"c:\\var\\lib\\kubelet\\pki\\", "Directory in which the kubelet stores its certificates. "+
+			"Defaults to c:\\var\\lib\\kubelet\\pki\\")
+	flags.StringVar(&initializeKubeletOpts.serviceAccount, "service-account", "",
+		"Runs the kubelet and kube-proxy Windows services as the given account with the minimal rights they require "+
+			"instead of LocalSystem. Either virtual, for the NT SERVICE virtual account of each service, or a group "+
+			"managed service account in the DOMAIN\\name$ form")
+	flags.BoolVar(&initializeKubeletOpts.cloudConfigManagedIdentity, "cloud-config-managed-identity", false,
+		"Rewrites the Azure cloud config so that the kubelet uses the managed identity of the VM, and keeps the "+
+			"service principal secrets it held encrypted with DPAPI instead")
+	flags.StringVar(&initializeKubeletOpts.userAssignedIdentityID, "user-assigned-identity-id", "",
+		"The ID of the user assigned identity the kubelet uses. Defaults to the system assigned identity. Only used "+
+			"with --cloud-config-managed-identity")
+	flags.BoolVar(&initializeKubeletOpts.skipVersionCheck, "skip-version-check", false,
+		"Skips the validation of the kubelet version against the kubelet versions wmcb supports and the version of "+
+			"the API server")
+	flags.StringVar(&initializeKubeletOpts.httpProxy, "http-proxy", "",
+		"Proxy used for HTTP requests. Defaults to the cluster-wide proxy in the ignition file")
+	flags.StringVar(&initializeKubeletOpts.httpsProxy, "https-proxy", "",
+		"Proxy used for HTTPS requests. Defaults to the cluster-wide proxy in the ignition file")
+	flags.StringVar(&initializeKubeletOpts.noProxy, "no-proxy", "",
+		"Comma separated list of hosts, domains and CIDRs that are not proxied. Defaults to the cluster-wide proxy "+
+			"in the ignition file")
+	flags.StringArrayVar(&initializeKubeletOpts.nodeLabels, "node-label", nil,
+		"Label in the key=value format the node is registered with, in addition to the Windows node label. "+
+			"Can be specified multiple times")
+	flags.StringArrayVar(&initializeKubeletOpts.nodeTaints, "node-taint", nil,
+		"Taint in the key=value:effect format the node is registered with, in addition to the os=Windows:NoSchedule "+
+			"taint. Can be specified multiple times")
+	flags.StringArrayVar(&initializeKubeletOpts.credentialProviders,
+		"image-credential-provider", nil, "Path of an image credential provider plugin the kubelet uses to fetch "+
+			"the credentials of the private cloud registries, one of ecr-credential-provider.exe, "+
+			"acr-credential-provider.exe or gcr-credential-provider.exe. Can be specified multiple times")
+	flags.BoolVar(&initializeKubeletOpts.gmsa, "gmsa", false,
+		"Support the workloads using Group Managed Service Accounts. The Windows node needs to be joined to a domain "+
+			"unless --ccg-plugin is given")
+	flags.StringVar(&initializeKubeletOpts.ccgPlugin, "ccg-plugin", "",
+		"DLL of the Container Credential Guard plugin retrieving the GMSA credentials on a Windows node that is not "+
+			"domain joined. Requires --ccg-plugin-clsid. Only used with --gmsa")
+	flags.StringVar(&initializeKubeletOpts.ccgPluginCLSID, "ccg-plugin-clsid", "",
+		"COM class ID the CCG plugin is registered with, for example {01234567-89AB-CDEF-0123-456789ABCDEF}. Only "+
+			"used with --gmsa")
+	flags.StringArrayVar(&initializeKubeletOpts.ignitionFiles, "ignition-extra-file", nil,
+		"Name of a file of the ignition file installed on the Windows node, one of registry-cas, registries-conf or "+
+			"chrony. Can be specified multiple times")
+	flags.StringArrayVar(&initializeKubeletOpts.timeServers, "ntp-server", nil,
+		"NTP server the clock of the Windows node is synchronized with before the kubelet is started, instead of the "+
+			"servers of the chrony configuration of the ignition file. Can be specified multiple times")
+	flags.StringVar(&initializeKubeletOpts.kubeletConfigOverrides,
+		"kubelet-config-overrides", "", "JSON or YAML file with the KubeletConfiguration fields, for example "+
+			"evictionHard, maxPods or systemReserved, that override the generated kubelet configuration")
+	addEvictionFlags(flags, &initializeKubeletOpts.eviction)
+	flags.StringVar(&initializeKubeletOpts.nodeIP, "node-ip", "",
+		"IP address the node is registered with, which needs to be assigned to one of the network adapters of the "+
+			"Windows node. Defaults to the address of the network adapter the API server is reached through if the "+
+			"Windows node has several network adapters")
+	flags.StringVar(&initializeKubeletOpts.nodeIPInterface, "node-ip-interface", "",
+		"Name of the network adapter the IP address of the node is taken from, for example \"Ethernet 2\", instead "+
+			"of --node-ip")
+	flags.BoolVar(&initializeKubeletOpts.externalCloudProvider, "external-cloud-provider", false,
+		"Run the kubelet with --cloud-provider=external, for the clusters where the cloud controller manager "+
+			"initializes the nodes. Detected from the kubelet arguments of the ignition file otherwise")
+	flags.StringVar(&initializeKubeletOpts.cloudNodeManagerPath, "cloud-node-manager-path", "",
+		"Cloud node manager file location, for example azure-cloud-node-manager.exe, which is run as a Windows "+
+			"service depending on the kubelet. Requires --external-cloud-provider")
+	flags.StringVar(&initializeKubeletOpts.kubeletURL, "kubelet-url", "",
+		"HTTPS URL the kubelet.exe is downloaded from, through the --https-proxy if given, when --kubelet-path is "+
+			"not given. Requires the SHA256 checksum of the kubelet.exe")
+	flags.StringVar(&initializeKubeletOpts.bootstrapToken, "bootstrap-token", "",
+		"Bootstrap or service account token the bootstrap kubeconfig is generated with, instead of taking it from the "+
+			"ignition file. Requires --api-server and --ca-bundle")
+	flags.StringVar(&initializeKubeletOpts.apiServer, "api-server", "",
+		"HTTPS URL of the API server the bootstrap kubeconfig is generated for. Only used with --bootstrap-token")
+	flags.StringVar(&initializeKubeletOpts.caBundle, "ca-bundle", "",
+		"PEM encoded CA bundle the API server serving certificate is verified with. Only used with --bootstrap-token")
+	flags.StringVar(&initializeKubeletOpts.kubeletCA, "kubelet-ca", "",
+		"PEM encoded CA the kubelet authenticates the client certificates of the API server with. Defaults to "+
+			"--ca-bundle. Only used with --bootstrap-token")
+}
+
+// evictionOpts holds the CLI options of the thresholds at which the kubelet evicts the pods and garbage collects the
+// unused images
+type evictionOpts struct {
+	// evictionHard are the hard eviction thresholds in the signal=threshold format
+	evictionHard []string
+	// imageGCHighThreshold is the disk usage in percent above which the unused images are garbage collected
+	imageGCHighThreshold int
+	// imageGCLowThreshold is the disk usage in percent the unused images are garbage collected down to
+	imageGCLowThreshold int
+}
+
+// addEvictionFlags adds the flags of the given eviction options to the given flag set
+func addEvictionFlags(flags *pflag.FlagSet, opts *evictionOpts) {
+	flags.StringArrayVar(&opts.evictionHard, "eviction-hard", nil,
+		"Hard eviction threshold of the kubelet in the signal=threshold format, for example nodefs.available=15% or "+
+			"memory.available=1Gi, overriding the Windows default of the signal. Defaults to memory.available=500Mi, "+
+			"nodefs.available=10% and imagefs.available=10%. Can be specified multiple times")
+	flags.IntVar(&opts.imageGCHighThreshold, "image-gc-high-threshold", 0,
+		"Disk usage in percent of the install volume above which the kubelet garbage collects the unused images. "+
+			"Defaults to 75")
+	flags.IntVar(&opts.imageGCLowThreshold, "image-gc-low-threshold", 0,
+		"Disk usage in percent of the install volume the kubelet garbage collects the unused images down to. "+
+			"Defaults to 65")
+}
+
+// options returns the eviction options of the bootstrapper given by the CLI options
+func (o evictionOpts) options() (bootstrapper.EvictionOptions, error) {
+	evictionHard, err := parseKeyValues("eviction threshold", o.evictionHard)
+	if err != nil {
+		return bootstrapper.EvictionOptions{}, err
+	}
+	return bootstrapper.EvictionOptions{
+		EvictionHard:                evictionHard,
+		ImageGCHighThresholdPercent: o.imageGCHighThreshold,
+		ImageGCLowThresholdPercent:  o.imageGCLowThreshold,
+	}, nil
+}
+
+// parseKeyValues converts the given key=value pairs of the given kind into a map
+func parseKeyValues(kind string, pairs []string) (map[string]string, error) {
+	keyValues := make(map[string]string)
+	for _, pair := range pairs {
+		keyValue := strings.SplitN(pair, "=", 2)
+		if len(keyValue) != 2 || keyValue[0] == "" {
+			return nil, fmt.Errorf("invalid %s %q, expected key=value", kind, pair)
+		}
+		keyValues[keyValue[0]] = keyValue[1]
+	}
+	return keyValues, nil
 }
 
 // runInitializeKubeletCmd starts the Windows Machine Config Bootstrapper
@@ -53,24 +321,195 @@ func runInitializeKubeletCmd(cmd *cobra.Command, args []string) {
 	flag.Parse()
 	// TODO: add validation for flags
 
-	wmcb, err := bootstrapper.NewWinNodeBootstrapper(initializeKubeletOpts.installDir,
-		initializeKubeletOpts.ignitionFile, initializeKubeletOpts.kubeletPath, "", "")
+	if err := initializeKubelet(cmd.Context()); err != nil {
+		log.Error(err, "could not run bootstrapper")
+		code := exitCode(err)
+		if initializeKubeletOpts.rollbackOnFailure && !initializeKubeletOpts.dryRun {
+			if err = rollbackBootstrap(initializeKubeletOpts.installDir); err != nil {
+				log.Error(err, "could not roll back bootstrap")
+			}
+		}
+		os.Exit(code)
+	}
+	if initializeKubeletOpts.dryRun {
+		return
+	}
+	// Send success message to StdOut for WSU to ascertain that bootstrapping was successful
+	os.Stdout.WriteString("Bootstrapping completed successfully")
+}
+
+// initializeKubelet initializes the kubelet with the initialize-kubelet options
+func initializeKubelet(ctx context.Context) error {
+	wmcb, err := newKubeletBootstrapper(ctx)
 	if err != nil {
-		log.Error(err, "could not create bootstrapper")
-		os.Exit(1)
+		return err
 	}
+	defer func() {
+		if err := wmcb.Disconnect(); err != nil {
+			log.Error(err, "can't clean up bootstrapper")
+		}
+	}()
 
-	err = wmcb.InitializeKubelet()
+	if initializeKubeletOpts.dryRun {
+		wmcb.SetDryRun()
+	}
+	if err = wmcb.InitializeKubelet(ctx); err != nil {
+		return err
+	}
+	if initializeKubeletOpts.dryRun {
+		printDryRunPlan(os.Stdout, wmcb.DryRunPlan())
+	}
+	return nil
+}
+
+// newKubeletBootstrapper returns a bootstrapper set up with the initialize-kubelet options, downloading the kubelet
+// if needed. The caller needs to disconnect it.
+func newKubeletBootstrapper(ctx context.Context) (_ *bootstrapper.WinNodeBootstrapper, err error) {
+	kubeletPath := initializeKubeletOpts.kubeletPath
+	containerdDir := initializeKubeletOpts.containerdDir
+	if kubeletPath == "" && initializeKubeletOpts.kubeletURL != "" {
+		if initializeKubeletOpts.dryRun {
+			return nil, invalidInput("--kubelet-url cannot be used with --dry-run, as the kubelet is downloaded to the " +
+				"install dir")
+		}
+		fetcher, err := initializeKubeletOpts.fetch.newFetcher(initializeKubeletOpts.installDir,
+			initializeKubeletOpts.httpsProxy)
+		if err != nil {
+			return nil, fmt.Errorf("could not create fetcher: %v", err)
+		}
+		kubeletPath, err = fetcher.Fetch(ctx, initializeKubeletOpts.kubeletURL,
+			initializeKubeletOpts.fetch.sha256)
+		if err != nil {
+			return nil, fmt.Errorf("could not download kubelet: %w", err)
+		}
+	}
+	var artifacts *bootstrapper.Artifacts
+	if initializeKubeletOpts.artifactsDir != "" {
+		artifacts, err = bootstrapper.NewArtifacts(initializeKubeletOpts.artifactsDir)
+		if err != nil {
+			return nil, invalidInput("could not verify artifacts: %v", err)
+		}
+		if kubeletPath == "" {
+			kubeletPath = artifacts.KubeletPath()
+		}
+		if containerdDir == "" && initializeKubeletOpts.containerRuntime == "containerd" {
+			containerdDir = artifacts.ContainerdDir()
+		}
+	}
+
+	wmcb, err := bootstrapper.New(bootstrapper.WithInstallDir(initializeKubeletOpts.installDir),
+		bootstrapper.WithIgnitionFile(initializeKubeletOpts.ignitionFile), bootstrapper.WithKubeletPath(kubeletPath),
+		bootstrapper.WithContainerRuntime(initializeKubeletOpts.containerRuntime, containerdDir))
 	if err != nil {
-		log.Error(err, "could not run bootstrapper")
-		os.Exit(1)
-	} else {
-		// Send success message to StdOut for WSU to ascertain that bootstrapping was successful
-		os.Stdout.WriteString("Bootstrapping completed successfully")
+		return nil, fmt.Errorf("could not create bootstrapper: %w", err)
 	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if err := wmcb.Disconnect(); err != nil {
+			log.Error(err, "can't clean up bootstrapper")
+		}
+	}()
 
-	err = wmcb.Disconnect()
+	if artifacts != nil && artifacts.PauseImagePath() != "" {
+		if err = wmcb.SetPauseImageArchive(artifacts.PauseImagePath()); err != nil {
+			return nil, invalidInput("could not set pause image archive: %v", err)
+		}
+	}
+
+	kubeletArgs, err := parseKeyValues("kubelet argument", initializeKubeletOpts.kubeletArgs)
+	if err != nil {
+		return nil, invalidInput("could not parse kubelet arguments: %v", err)
+	}
+	if err = wmcb.SetKubeletArgs(kubeletArgs); err != nil {
+		return nil, invalidInput("could not set kubelet arguments: %v", err)
+	}
+	nodeLabels, err := parseKeyValues("node label", initializeKubeletOpts.nodeLabels)
+	if err != nil {
+		return nil, invalidInput("could not parse node labels: %v", err)
+	}
+	if err = wmcb.SetNodeLabels(nodeLabels); err != nil {
+		return nil, invalidInput("could not set node labels: %v", err)
+	}
+	if err = wmcb.SetNodeTaints(initializeKubeletOpts.nodeTaints); err != nil {
+		return nil, invalidInput("could not set node taints: %v", err)
+	}
+	if initializeKubeletOpts.kubeletConfigOverrides != "" {
+		if err = wmcb.SetKubeletConfigOverrides(initializeKubeletOpts.kubeletConfigOverrides); err != nil {
+			return nil, invalidInput("could not set kubelet config overrides: %v", err)
+		}
+	}
+	if initializeKubeletOpts.externalCloudProvider {
+		// The cloud node manager manages the node the kubelet registers
+		err = wmcb.SetExternalCloudProvider(initializeKubeletOpts.cloudNodeManagerPath, kubeletArgs["hostname-override"])
+		if err != nil {
+			return nil, invalidInput("could not set external cloud provider: %v", err)
+		}
+	} else if initializeKubeletOpts.cloudNodeManagerPath != "" {
+		return nil, invalidInput("--cloud-node-manager-path requires --external-cloud-provider")
+	}
+	if err = wmcb.SetNodeIP(initializeKubeletOpts.nodeIP, initializeKubeletOpts.nodeIPInterface); err != nil {
+		return nil, invalidInput("could not set node IP: %v", err)
+	}
+	eviction, err := initializeKubeletOpts.eviction.options()
+	if err != nil {
+		return nil, invalidInput("could not parse eviction thresholds: %v", err)
+	}
+	if err = wmcb.SetEviction(eviction); err != nil {
+		return nil, invalidInput("could not set eviction thresholds: %v", err)
+	}
+	if err = wmcb.SetImageCredentialProviders(initializeKubeletOpts.credentialProviders); err != nil {
+		return nil, invalidInput("could not set image credential providers: %v", err)
+	}
+	if initializeKubeletOpts.gmsa {
+		if err = wmcb.SetGMSA(initializeKubeletOpts.ccgPlugin, initializeKubeletOpts.ccgPluginCLSID); err != nil {
+			return nil, invalidInput("could not set GMSA support: %v", err)
+		}
+	}
+	if err = wmcb.SetIgnitionFiles(initializeKubeletOpts.ignitionFiles); err != nil {
+		return nil, invalidInput("could not set ignition files: %v", err)
+	}
+	if err = wmcb.SetTimeServers(initializeKubeletOpts.timeServers); err != nil {
+		return nil, invalidInput("could not set time servers: %v", err)
+	}
+	if initializeKubeletOpts.ignitionURL != "" {
+		if err = wmcb.SetIgnitionURL(initializeKubeletOpts.ignitionURL, initializeKubeletOpts.ignitionCA); err != nil {
+			return nil, invalidInput("could not set ignition URL: %v", err)
+		}
+	}
+	if initializeKubeletOpts.bootstrapToken != "" {
+		err = wmcb.SetBootstrapToken(initializeKubeletOpts.apiServer, initializeKubeletOpts.caBundle,
+			initializeKubeletOpts.bootstrapToken, initializeKubeletOpts.kubeletCA)
+		if err != nil {
+			return nil, invalidInput("could not set bootstrap token: %v", err)
+		}
+	}
+	recovery := initializeKubeletOpts.recovery
+	err = wmcb.SetServiceRecovery(recovery.restartOnFailure, recovery.restartDelay, recovery.resetPeriod)
+	if err != nil {
+		return nil, invalidInput("could not set service recovery: %v", err)
+	}
+	if err = wmcb.SetCertDir(initializeKubeletOpts.certDir); err != nil {
+		return nil, invalidInput("could not set cert dir: %v", err)
+	}
+	if initializeKubeletOpts.serviceAccount != "" {
+		if err = wmcb.SetServiceAccount(initializeKubeletOpts.serviceAccount); err != nil {
+			return nil, invalidInput("could not set service account: %v", err)
+		}
+	}
+	if initializeKubeletOpts.cloudConfigManagedIdentity {
+		wmcb.SetCloudConfigManagedIdentity(initializeKubeletOpts.userAssignedIdentityID)
+	} else if initializeKubeletOpts.userAssignedIdentityID != "" {
+		return nil, invalidInput("--user-assigned-identity-id can only be used with --cloud-config-managed-identity")
+	}
+	if initializeKubeletOpts.skipVersionCheck {
+		wmcb.SkipVersionCheck()
+	}
+	err = wmcb.SetProxy(initializeKubeletOpts.httpProxy, initializeKubeletOpts.httpsProxy,
+		initializeKubeletOpts.noProxy)
 	if err != nil {
-		log.Error(err, "can't clean up bootstrapper")
+		return nil, invalidInput("could not set proxy: %v", err)
 	}
+	return wmcb, nil
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	// logOpts holds the logging CLI options shared by all the commands
+	logOpts struct {
+		// level is the minimum level of the logs that are written, one of debug, info or error
+		level string
+		// format is the format in which the logs are written, either json or text
+		format string
+		// file is the file the logs are written to instead of StdOut and StdErr
+		file string
+	}
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&logOpts.level, "log-level", "info",
+		"Minimum level of the logs that are written, one of debug, info or error. Defaults to info")
+	rootCmd.PersistentFlags().StringVar(&logOpts.format, "log-format", "json",
+		"Format in which the logs are written, either json or text. Defaults to json")
+	rootCmd.PersistentFlags().StringVar(&logOpts.file, "log-file", "",
+		"File the logs are appended to. By default the logs are written to StdOut and StdErr")
+}
+
+// newLogger returns a logger configured as per the logging CLI options. Unless a log file is given, error logs are
+// written to StdErr and the rest of the logs to StdOut, as WMCO interprets logs in StdErr as an indication that
+// bootstrapping failed.
+func newLogger() (logr.Logger, error) {
+	var level zapcore.Level
+	switch logOpts.level {
+	case "debug":
+		level = zapcore.DebugLevel
+	case "info":
+		level = zapcore.InfoLevel
+	case "error":
+		level = zapcore.ErrorLevel
+	default:
+		return nil, fmt.Errorf("unsupported log level %s", logOpts.level)
+	}
+
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	var encoder zapcore.Encoder
+	switch logOpts.format {
+	case "json":
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	case "text":
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	default:
+		return nil, fmt.Errorf("unsupported log format %s", logOpts.format)
+	}
+
+	var core zapcore.Core
+	if logOpts.file != "" {
+		logFile, err := os.OpenFile(logOpts.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("error opening log file %s: %v", logOpts.file, err)
+		}
+		core = zapcore.NewCore(encoder, zapcore.AddSync(logFile), level)
+	} else {
+		core = zapcore.NewTee(
+			zapcore.NewCore(encoder, zapcore.Lock(os.Stdout), zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+				return level.Enabled(l) && l < zapcore.ErrorLevel
+			})),
+			zapcore.NewCore(encoder, zapcore.Lock(os.Stderr), zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+				return level.Enabled(l) && l >= zapcore.ErrorLevel
+			})),
+		)
+	}
+	return zapr.NewLogger(zap.New(core)), nil
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
 	"github.com/spf13/cobra"
 	logger "sigs.k8s.io/controller-runtime/pkg/log"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
 const (
@@ -19,23 +22,50 @@ var (
 		Short: "Run Windows machine config bootstrapper",
 		Long: "Runs the Machine Config Bootstrapper which is responsible for bootstrapping the windows to ensure that" +
 			"the node can join existing OpenShift cluster",
+		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			l, err := newLogger()
+			if err != nil {
+				return err
+			}
+			// The bootstrapper package logs through the controller-runtime logger as well
+			logger.SetLogger(l)
+			// The bootstrap does not depend on the event log, which cannot be written to without being elevated
+			if eventLog {
+				if err = bootstrapper.SetEventLog(bootstrapper.EventLogSource); err != nil {
+					log.Error(err, "unable to write to the event log")
+				}
+			}
+			if metricsFile != "" {
+				return bootstrapper.SetMetricsFile(metricsFile)
+			}
+			return nil
+		},
 	}
 	log = logger.Log.WithName("wmcb")
+	// metricsFile is the file the bootstrap status is written to as Prometheus metrics
+	metricsFile string
+	// eventLog indicates that the bootstrap events are written to the Application event log
+	eventLog bool
 )
 
 func init() {
 	rootCmd.PersistentFlags().AddGoFlagSet(flag.CommandLine)
-	// Controller-runtime's zap package redirects logs to StdErr by default. Functionality to set up the destination of
-	// logs would require bumping up the version of controller-runtime to at least 0.4.0, which is dependent on
-	// https://issues.redhat.com/browse/WINC-347
-	// Here we set up the logger that sends logs to StdErr. Info level logs should be bubbled up to StdOut instead
-	// WMCO interprets logs in StdErr as an indication that bootstrapping failed
-	logger.SetLogger(zap.New())
+	rootCmd.PersistentFlags().StringVar(&metricsFile, "metrics-file", "",
+		"File the bootstrap status is written to as Prometheus metrics, for example in the textfile collector "+
+			"directory of the Windows exporter. The file needs to have the .prom extension.")
+	rootCmd.PersistentFlags().BoolVar(&eventLog, "event-log", false,
+		"Write the outcome of the bootstrap phases and the restarts of the Windows services to the Application "+
+			"event log, with the "+bootstrapper.EventLogSource+" source")
 }
 
 func main() {
-	if err := rootCmd.Execute(); err != nil {
+	// Interrupting wmcb aborts the running command instead of leaving it blocked on the node services
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
+		// The commands exit on their own failures, so the remaining errors are the ones of the flags
 		log.Error(err, "wmcb execution failed")
-		os.Exit(1)
+		os.Exit(exitValidation)
 	}
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+)
+
+var (
+	// mustGatherCmd describes the must-gather command
+	mustGatherCmd = &cobra.Command{
+		Use:   "must-gather",
+		Short: "Collects the diagnostics of the Windows node into a zip archive",
+		Long: "Collects the diagnostics of the Windows node into a zip archive that can be attached to support cases. " +
+			"This collects the kubelet, kube-proxy, hybrid-overlay-node, cloud-node-manager and containerd logs, the " +
+			"bootstrap status, the HNS networks and endpoints, the status of the Windows services and the recent " +
+			"entries of the System and Application event logs.",
+		Run: runMustGatherCmd,
+	}
+
+	// mustGatherOpts holds the must-gather CLI options
+	mustGatherOpts struct {
+		// installDir is the main installation directory
+		installDir string
+		// dest is the path the archive is written to
+		dest string
+		// since is the duration within which the collected event log entries were written
+		since time.Duration
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(mustGatherCmd)
+	mustGatherCmd.PersistentFlags().StringVar(&mustGatherOpts.installDir, "install-dir", "c:\\k",
+		"Installation directory. Defaults to C:\\k")
+	mustGatherCmd.PersistentFlags().StringVar(&mustGatherOpts.dest, "dest", "",
+		"The path the zip archive is written to. Defaults to wmcb-must-gather-<timestamp>.zip in the current directory")
+	mustGatherCmd.PersistentFlags().DurationVar(&mustGatherOpts.since, "since", 24*time.Hour,
+		"Collect the event log entries written within this duration. Defaults to 24h")
+}
+
+// runMustGatherCmd collects the diagnostics of the Windows node
+func runMustGatherCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+	wmcb, err := bootstrapper.New(bootstrapper.WithInstallDir(mustGatherOpts.installDir))
+	if err != nil {
+		log.Error(err, "could not create bootstrapper")
+		os.Exit(exitCode(err))
+	}
+
+	dest := mustGatherOpts.dest
+	if dest == "" {
+		dest = "wmcb-must-gather-" + time.Now().UTC().Format("20060102T150405Z") + ".zip"
+	}
+	if err = wmcb.MustGather(cmd.Context(), dest, mustGatherOpts.since); err != nil {
+		log.Error(err, "could not collect diagnostics")
+		os.Exit(exitCode(err))
+	}
+
+	// Send success message to StdOut to ascertain that the diagnostics were collected
+	os.Stdout.WriteString("Diagnostics written to " + dest)
+
+	if err = wmcb.Disconnect(); err != nil {
+		log.Error(err, "can't clean up bootstrapper")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+)
+
+var (
+	// preflightCmd describes the preflight command
+	preflightCmd = &cobra.Command{
+		Use:   "preflight",
+		Short: "Checks that the Windows node can be bootstrapped",
+		Long: "Checks, without making any change to the Windows node, that the Windows features required by the " +
+			"container runtime are enabled, the volume of the install directory is large enough and has enough " +
+			"free space for the kubelet not to evict the pods given its eviction thresholds, the clock is in sync with " +
+			"the API server, the API server and machine config server resolve and are reachable, the proxy is " +
+			"reachable and bypassed for the API server, no conflicting Windows service is installed, and no " +
+			"antivirus, EDR agent, VPN client or network filter driver is known to interfere with the node. The " +
+			"security software found is recorded in the bootstrap status. A pass/fail report is printed and the " +
+			"command fails if any check fails. The checks requiring the API server or the machine config server are " +
+			"skipped if neither --ignition-file, --ignition-url nor --api-server is given. " +
+			"With --enable-features, the missing Windows features and services are enabled before the checks are " +
+			"run, and the command exits with code 5 if the node needs to be rebooted for them to take effect.",
+		Run: runPreflightCmd,
+	}
+
+	// preflightOpts holds the preflight CLI options
+	preflightOpts struct {
+		// installDir is the main installation directory
+		installDir string
+		// ignitionFile is the stub ignition file the machine config server URL is read from
+		ignitionFile string
+		// ignitionURL is the URL of the machine config server
+		ignitionURL string
+		// ignitionCA is the CA bundle the machine config server serving certificate is verified with
+		ignitionCA string
+		// apiServer is the URL of the API server
+		apiServer string
+		// httpProxy is the proxy used for HTTP requests
+		httpProxy string
+		// httpsProxy is the proxy used for HTTPS requests
+		httpsProxy string
+		// noProxy is the comma separated list of hosts, domains and CIDRs that are not proxied
+		noProxy string
+		// hyperV requires the Hyper-V feature to be enabled
+		hyperV bool
+		// minFreeDiskGiB is the free space in GiB required on the volume of the install directory
+		minFreeDiskGiB uint64
+		// minDiskSizeGiB is the size in GiB required of the volume of the install directory
+		minDiskSizeGiB uint64
+		// eviction are the eviction thresholds of the kubelet the free space is checked against
+		eviction evictionOpts
+		// maxClockSkew is the maximum difference between the clocks of the Windows node and the API server
+		maxClockSkew time.Duration
+		// json prints the report in the JSON format
+		json bool
+		// enableFeatures enables the missing Windows features and services before running the checks
+		enableFeatures bool
+		// reboot indicates that the node is rebooted if the enabled features require it
+		reboot bool
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(preflightCmd)
+	preflightCmd.PersistentFlags().StringVar(&preflightOpts.installDir, "install-dir", "c:\\k",
+		"Installation directory. Defaults to C:\\k")
+	preflightCmd.PersistentFlags().StringVar(&preflightOpts.ignitionFile, "ignition-file", "",
+		"Stub ignition file of the worker machines the machine config server URL is read from")
+	preflightCmd.PersistentFlags().StringVar(&preflightOpts.ignitionURL, "ignition-url", "",
+		"HTTPS URL of the machine config server, instead of --ignition-file. Requires --ignition-ca")
+	preflightCmd.PersistentFlags().StringVar(&preflightOpts.ignitionCA, "ignition-ca", "",
+		"PEM encoded CA bundle the machine config server serving certificate is verified with. Only used with "+
+			"--ignition-url")
+	preflightCmd.PersistentFlags().StringVar(&preflightOpts.apiServer, "api-server", "",
+		"HTTPS URL of the API server. Defaults to port 6443 of the host of the machine config server")
+	preflightCmd.PersistentFlags().StringVar(&preflightOpts.httpProxy, "http-proxy", "",
+		"Proxy used for HTTP requests. Defaults to $HTTP_PROXY")
+	preflightCmd.PersistentFlags().StringVar(&preflightOpts.httpsProxy, "https-proxy", "",
+		"Proxy used for HTTPS requests. Defaults to $HTTPS_PROXY")
+	preflightCmd.PersistentFlags().StringVar(&preflightOpts.noProxy, "no-proxy", "",
+		"Comma separated list of hosts, domains and CIDRs that are not proxied. Defaults to $NO_PROXY")
+	preflightCmd.PersistentFlags().BoolVar(&preflightOpts.hyperV, "hyperv", false,
+		"Require the Hyper-V feature, for running Hyper-V isolated containers")
+	preflightCmd.PersistentFlags().Uint64Var(&preflightOpts.minFreeDiskGiB, "min-free-disk", 20,
+		"Free space in GiB required on the volume of the install directory. Defaults to 20")
+	preflightCmd.PersistentFlags().Uint64Var(&preflightOpts.minDiskSizeGiB, "min-disk-size", 0,
+		"Size in GiB required of the volume of the install directory. Not checked if 0, the default")
+	addEvictionFlags(preflightCmd.PersistentFlags(), &preflightOpts.eviction)
+	preflightCmd.PersistentFlags().DurationVar(&preflightOpts.maxClockSkew, "max-clock-skew", time.Minute,
+		"Maximum difference between the clocks of the Windows node and the API server. A larger skew makes the "+
+			"kubelet certificates invalid. Defaults to 1m")
+	preflightCmd.PersistentFlags().BoolVar(&preflightOpts.json, "json", false,
+		"Print the report in the JSON format")
+	preflightCmd.PersistentFlags().BoolVar(&preflightOpts.enableFeatures, "enable-features", false,
+		"Enable the missing Windows features and services required by the container runtime, including Hyper-V "+
+			"with --hyperv, before running the checks")
+	preflightCmd.PersistentFlags().BoolVar(&preflightOpts.reboot, "reboot", false,
+		"Reboot the Windows node if the features enabled with --enable-features require it")
+}
+
+// runPreflightCmd runs the preflight checks and prints their report
+func runPreflightCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	report, err := runPreflight()
+	if err != nil {
+		log.Error(err, "could not run preflight checks")
+		os.Exit(exitCode(err))
+	}
+	if preflightOpts.json {
+		content, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Error(err, "could not marshal preflight report")
+			os.Exit(exitPermanent)
+		}
+		os.Stdout.Write(append(content, '\n'))
+	} else {
+		for _, result := range report.Results {
+			fmt.Printf("[%s] %s: %s\n", strings.ToUpper(string(result.Status)), result.Check, result.Message)
+		}
+	}
+	if !report.Passed() {
+		os.Exit(exitPermanent)
+	}
+}
+
+// runPreflight creates the bootstrapper with the preflight options and runs the preflight checks
+func runPreflight() (*bootstrapper.PreflightReport, error) {
+	wmcb, err := bootstrapper.New(bootstrapper.WithInstallDir(preflightOpts.installDir),
+		bootstrapper.WithIgnitionFile(preflightOpts.ignitionFile))
+	if err != nil {
+		return nil, fmt.Errorf("could not create bootstrapper: %w", err)
+	}
+	defer func() {
+		if err := wmcb.Disconnect(); err != nil {
+			log.Error(err, "can't clean up bootstrapper")
+		}
+	}()
+
+	if preflightOpts.ignitionURL != "" {
+		if err = wmcb.SetIgnitionURL(preflightOpts.ignitionURL, preflightOpts.ignitionCA); err != nil {
+			return nil, invalidInput("could not set ignition URL: %v", err)
+		}
+	}
+	if err = wmcb.SetProxy(preflightOpts.httpProxy, preflightOpts.httpsProxy, preflightOpts.noProxy); err != nil {
+		return nil, invalidInput("could not set proxy: %v", err)
+	}
+	eviction, err := preflightOpts.eviction.options()
+	if err != nil {
+		return nil, invalidInput("could not parse eviction thresholds: %v", err)
+	}
+	if err = wmcb.SetEviction(eviction); err != nil {
+		return nil, invalidInput("could not set eviction thresholds: %v", err)
+	}
+	if preflightOpts.enableFeatures {
+		err = wmcb.EnableWindowsFeatures(context.Background(), preflightOpts.hyperV)
+		rebootIfRequired(err, preflightOpts.reboot)
+		if err != nil {
+			return nil, fmt.Errorf("could not enable Windows features: %w", err)
+		}
+	}
+	return wmcb.Preflight(context.Background(), bootstrapper.PreflightOptions{
+		APIServer:    preflightOpts.apiServer,
+		HyperV:       preflightOpts.hyperV,
+		MinFreeDisk:  preflightOpts.minFreeDiskGiB << 30,
+		MinDiskSize:  preflightOpts.minDiskSizeGiB << 30,
+		MaxClockSkew: preflightOpts.maxClockSkew,
+	}), nil
+}
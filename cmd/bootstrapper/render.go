@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	// renderCmd describes the render command
+	renderCmd = &cobra.Command{
+		Use:   "render",
+		Short: "Prints the kubelet service initialize-kubelet would create",
+		Long: "Prints the command line, environment and dependencies of the kubelet Windows service initialize-kubelet " +
+			"would create or update with the given initialize-kubelet options, without making any change to the " +
+			"Windows node. initialize-kubelet, configure-cni and upgrade write the unit of the kubelet service to " +
+			"kubelet-unit.yaml in the install dir, which the rendered one can be diffed with.",
+		Run: runRenderCmd,
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			if initializeKubeletOpts.kubeletURL != "" {
+				return fmt.Errorf("--kubelet-url cannot be used with render, use --kubelet-path")
+			}
+			return markInitializeKubeletFlagsRequired(cmd, "sha256")
+		},
+	}
+
+	// renderOpts holds the render CLI options that are not shared with initialize-kubelet
+	renderOpts struct {
+		// json prints the kubelet unit in the JSON format instead of the YAML one
+		json bool
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(renderCmd)
+	addInitializeKubeletFlags(renderCmd.PersistentFlags())
+	renderCmd.PersistentFlags().StringVar(&initializeKubeletOpts.installDir, "install-dir", "c:\\k",
+		"Kubelet file location to bootstrap the Windows node. Defaults to C:\\k")
+	renderCmd.PersistentFlags().StringVar(&initializeKubeletOpts.artifactsDir, "artifacts-dir", "",
+		"Directory with the artifacts and their SHA256SUMS manifest for offline bootstrapping. The kubelet and "+
+			"pause image are taken from it unless given explicitly")
+	renderCmd.PersistentFlags().BoolVar(&renderOpts.json, "json", false,
+		"Print the kubelet unit in the JSON format instead of the YAML one")
+}
+
+// renderKubeletUnit renders the kubelet unit with the initialize-kubelet options in dry-run mode, and returns it
+// marshalled in the format given by the render options
+func renderKubeletUnit(ctx context.Context) ([]byte, error) {
+	initializeKubeletOpts.dryRun = true
+	wmcb, err := newKubeletBootstrapper(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := wmcb.Disconnect(); err != nil {
+			log.Error(err, "can't clean up bootstrapper")
+		}
+	}()
+
+	wmcb.SetDryRun()
+	if err = wmcb.InitializeKubelet(ctx); err != nil {
+		return nil, err
+	}
+	if renderOpts.json {
+		return json.MarshalIndent(wmcb.KubeletUnit(), "", "  ")
+	}
+	return yaml.Marshal(wmcb.KubeletUnit())
+}
+
+// runRenderCmd prints the kubelet unit initialize-kubelet would create
+func runRenderCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+	content, err := renderKubeletUnit(cmd.Context())
+	if err != nil {
+		log.Error(err, "could not render kubelet unit")
+		os.Exit(exitCode(err))
+	}
+	if renderOpts.json {
+		content = append(content, '\n')
+	}
+	os.Stdout.Write(content)
+}
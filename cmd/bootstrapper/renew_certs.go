@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// renewCertsCmd describes the renew-certs command
+	renewCertsCmd = &cobra.Command{
+		Use:   "renew-certs",
+		Short: "Renews the kubelet serving certificate on the Windows node",
+		Long: "Renews the kubelet serving certificate on the Windows node by restarting the kubelet without its " +
+			"current serving certificate and waiting for the CSR the kubelet creates to be approved. " +
+			"This command needs to be executed after initialize-kubelet is executed.",
+		Run: runRenewCertsCmd,
+	}
+
+	// renewCertsOpts holds the renew-certs CLI options
+	renewCertsOpts struct {
+		// certDir is the directory in which the kubelet stores its certificates
+		certDir string
+		// renewWithin is the duration before expiry within which the certificate is renewed
+		renewWithin time.Duration
+		// timeout is the time to wait for the new certificate to be issued
+		timeout time.Duration
+		// schedule indicates that a daily renewal task should be registered instead of renewing the certificate
+		schedule bool
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(renewCertsCmd)
+	renewCertsCmd.PersistentFlags().StringVar(&renewCertsOpts.certDir, "cert-dir", "c:\\var\\lib\\kubelet\\pki\\",
+		"Directory in which the kubelet stores its certificates. Defaults to c:\\var\\lib\\kubelet\\pki\\")
+	renewCertsCmd.PersistentFlags().DurationVar(&renewCertsOpts.renewWithin, "renew-within", 0,
+		"Renew the certificate only if it expires within this duration, for example 720h. By default the "+
+			"certificate is always renewed")
+	renewCertsCmd.PersistentFlags().DurationVar(&renewCertsOpts.timeout, "timeout", 10*time.Minute,
+		"Time to wait for the kubelet serving CSR to be approved and the certificate to be issued")
+	renewCertsCmd.PersistentFlags().BoolVar(&renewCertsOpts.schedule, "schedule", false,
+		"Register a Windows scheduled task that runs renew-certs daily with the given --renew-within instead of "+
+			"renewing the certificate now")
+}
+
+// runRenewCertsCmd renews the kubelet serving certificate or schedules its renewal
+func runRenewCertsCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	wmcb, err := bootstrapper.New()
+	if err != nil {
+		log.Error(err, "could not create bootstrapper")
+		os.Exit(exitCode(err))
+	}
+	if err = wmcb.SetCertDir(renewCertsOpts.certDir); err != nil {
+		log.Error(err, "could not set cert dir")
+		os.Exit(exitValidation)
+	}
+
+	if renewCertsOpts.schedule {
+		wmcbPath, err := os.Executable()
+		if err != nil {
+			log.Error(err, "could not get the path of wmcb")
+			os.Exit(exitCode(err))
+		}
+		if err = wmcb.ScheduleKubeletServerCertRenewal(wmcbPath, renewCertsOpts.renewWithin); err != nil {
+			log.Error(err, "could not schedule kubelet serving certificate renewal")
+			os.Exit(exitCode(err))
+		}
+		os.Stdout.WriteString("kubelet serving certificate renewal scheduled successfully")
+	} else {
+		if err = wmcb.RenewKubeletServerCert(cmd.Context(), renewCertsOpts.renewWithin, renewCertsOpts.timeout); err != nil {
+			log.Error(err, "could not renew kubelet serving certificate")
+			os.Exit(exitCode(err))
+		}
+		os.Stdout.WriteString("kubelet serving certificate renewal completed successfully")
+	}
+
+	err = wmcb.Disconnect()
+	if err != nil {
+		log.Error(err, "can't clean up bootstrapper")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+)
+
+var (
+	// rollbackCmd describes the rollback command
+	rollbackCmd = &cobra.Command{
+		Use:   "rollback",
+		Short: "Rolls back a failed bootstrap of the Windows node",
+		Long: "Reverts the changes made to the Windows node by initialize-kubelet, configure-cni and " +
+			"configure-kube-proxy since the kubelet was last initialized, as recorded in the bootstrap journal of the " +
+			"install dir. The Windows services created are removed, the ones updated get their previous config back " +
+			"and the files written are restored, or removed if they did not exist. The changes that could not be " +
+			"rolled back are kept in the journal, so that the command can be retried.",
+		Run: runRollbackCmd,
+	}
+
+	// rollbackOpts holds the rollback CLI options
+	rollbackOpts struct {
+		// installDir is the main installation directory
+		installDir string
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+	rollbackCmd.PersistentFlags().StringVar(&rollbackOpts.installDir, "install-dir", "c:\\k",
+		"Installation directory. Defaults to C:\\k")
+}
+
+// runRollbackCmd rolls back the bootstrap of the Windows node
+func runRollbackCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	if err := rollbackBootstrap(rollbackOpts.installDir); err != nil {
+		log.Error(err, "could not roll back bootstrap")
+		os.Exit(exitCode(err))
+	}
+	os.Stdout.WriteString("Bootstrap rolled back successfully")
+}
+
+// rollbackBootstrap rolls back the changes recorded in the bootstrap journal of the given install dir. It needs to be
+// called once the bootstrapper that made the changes has been disconnected, so that the services can be removed.
+func rollbackBootstrap(installDir string) error {
+	wmcb, err := bootstrapper.New(bootstrapper.WithInstallDir(installDir))
+	if err != nil {
+		return fmt.Errorf("could not create bootstrapper: %w", err)
+	}
+	defer func() {
+		if err := wmcb.Disconnect(); err != nil {
+			log.Error(err, "can't clean up bootstrapper")
+		}
+	}()
+	return wmcb.Rollback()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// rotateLogsCmd describes the rotate-logs command
+	rotateLogsCmd = &cobra.Command{
+		Use:   "rotate-logs",
+		Short: "Rotates the logs of the kubelet and of the other Windows services on the Windows node",
+		Long: "Rotates the log files of the kubelet, kube-proxy, hybrid-overlay-node, cloud-node-manager and " +
+			"containerd services that are larger than --max-size, and removes the rotated files older than --max-age " +
+			"or in excess of --max-files, so that the logs do not fill the disk of the Windows node.",
+		Run: runRotateLogsCmd,
+	}
+
+	// rotateLogsOpts holds the rotate-logs CLI options
+	rotateLogsOpts struct {
+		// options are the limits the logs are rotated and retained with
+		options bootstrapper.LogRotationOptions
+		// schedule indicates that an hourly rotation task should be registered instead of rotating the logs
+		schedule bool
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(rotateLogsCmd)
+	rotateLogsCmd.PersistentFlags().IntVar(&rotateLogsOpts.options.MaxSizeMB, "max-size", 100,
+		"Size in megabytes above which a log file is rotated. Defaults to 100")
+	rotateLogsCmd.PersistentFlags().DurationVar(&rotateLogsOpts.options.MaxAge, "max-age", 7*24*time.Hour,
+		"Age after which a rotated log file is removed, 0 to keep the rotated files whatever their age. Defaults "+
+			"to 168h")
+	rotateLogsCmd.PersistentFlags().IntVar(&rotateLogsOpts.options.MaxFiles, "max-files", 5,
+		"Number of rotated files kept for each log file, 0 to keep the rotated files whatever their number. "+
+			"Defaults to 5")
+	rotateLogsCmd.PersistentFlags().BoolVar(&rotateLogsOpts.schedule, "schedule", false,
+		"Register a Windows scheduled task that runs rotate-logs hourly with the given limits instead of rotating "+
+			"the logs now")
+}
+
+// runRotateLogsCmd rotates the logs of the node components or schedules their rotation
+func runRotateLogsCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	wmcb, err := bootstrapper.New()
+	if err != nil {
+		log.Error(err, "could not create bootstrapper")
+		os.Exit(exitCode(err))
+	}
+
+	if rotateLogsOpts.schedule {
+		wmcbPath, err := os.Executable()
+		if err != nil {
+			log.Error(err, "could not get the path of wmcb")
+			os.Exit(exitCode(err))
+		}
+		if err = wmcb.ScheduleLogRotation(wmcbPath, rotateLogsOpts.options); err != nil {
+			log.Error(err, "could not schedule log rotation")
+			os.Exit(exitCode(err))
+		}
+		os.Stdout.WriteString("log rotation scheduled successfully")
+	} else {
+		if err = wmcb.RotateLogs(rotateLogsOpts.options); err != nil {
+			log.Error(err, "could not rotate logs")
+			os.Exit(exitCode(err))
+		}
+		os.Stdout.WriteString("log rotation completed successfully")
+	}
+
+	err = wmcb.Disconnect()
+	if err != nil {
+		log.Error(err, "can't clean up bootstrapper")
+	}
+}
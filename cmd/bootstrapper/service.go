@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	// serviceCmd groups the commands that manage the Windows services created by WMCB
+	serviceCmd = &cobra.Command{
+		Use:   "service",
+		Short: "Manages the Windows services created by WMCB",
+		Long:  "Manages the kubelet, kube-proxy and hybrid-overlay-node Windows services created by WMCB.",
+	}
+
+	// setRecoveryCmd describes the service set-recovery command
+	setRecoveryCmd = &cobra.Command{
+		Use:   "set-recovery",
+		Short: "Sets the recovery settings of the Windows services created by WMCB",
+		Long: "Sets whether the Windows services created by WMCB are restarted when they fail, the delay before they " +
+			"are restarted and the period without failures after which their failure count is reset. The settings " +
+			"are applied to all the kubelet, kube-proxy and hybrid-overlay-node services that are installed unless " +
+			"services are given.",
+		Run: runSetRecoveryCmd,
+	}
+
+	// setRecoveryOpts holds the service set-recovery CLI options
+	setRecoveryOpts struct {
+		// installDir is the main installation directory
+		installDir string
+		// services are the names of the Windows services the recovery settings are applied to
+		services []string
+		// recovery holds the recovery settings
+		recovery recoveryOpts
+	}
+)
+
+// recoveryOpts holds the CLI options of the recovery settings of the Windows services created by WMCB
+type recoveryOpts struct {
+	// restartOnFailure is set if the services are restarted when they fail
+	restartOnFailure bool
+	// restartDelay is the time to wait before restarting a failed service
+	restartDelay time.Duration
+	// resetPeriod is the time without failures after which the failure count of a service is reset
+	resetPeriod time.Duration
+}
+
+func init() {
+	rootCmd.AddCommand(serviceCmd)
+	serviceCmd.AddCommand(setRecoveryCmd)
+	setRecoveryCmd.PersistentFlags().StringVar(&setRecoveryOpts.installDir, "install-dir", "c:\\k",
+		"Installation directory. Defaults to C:\\k")
+	setRecoveryCmd.PersistentFlags().StringArrayVar(&setRecoveryOpts.services, "service", nil,
+		"Windows service the recovery settings are applied to, one of kubelet, kube-proxy, hybrid-overlay-node or "+
+			"cloud-node-manager. Can be specified multiple times. Defaults to all of them that are installed")
+	addRecoveryFlags(setRecoveryCmd.PersistentFlags(), &setRecoveryOpts.recovery)
+}
+
+// addRecoveryFlags adds the flags of the given recovery options to the given flag set
+func addRecoveryFlags(flags *pflag.FlagSet, opts *recoveryOpts) {
+	flags.BoolVar(&opts.restartOnFailure, "restart-on-failure", true,
+		"Restart the Windows services created by WMCB when they fail. Defaults to true")
+	flags.DurationVar(&opts.restartDelay, "restart-delay", 5*time.Second,
+		"Time to wait before restarting a failed Windows service. Defaults to 5s")
+	flags.DurationVar(&opts.resetPeriod, "reset-period", 10*time.Minute,
+		"Time without failures after which the failure count of a Windows service is reset. Defaults to 10m")
+}
+
+// runSetRecoveryCmd sets the recovery settings of the Windows services created by WMCB
+func runSetRecoveryCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	wmcb, err := bootstrapper.New(bootstrapper.WithInstallDir(setRecoveryOpts.installDir))
+	if err != nil {
+		log.Error(err, "could not create bootstrapper")
+		os.Exit(exitCode(err))
+	}
+	recovery := setRecoveryOpts.recovery
+	err = wmcb.SetServiceRecovery(recovery.restartOnFailure, recovery.restartDelay, recovery.resetPeriod)
+	if err != nil {
+		log.Error(err, "could not set service recovery")
+		os.Exit(exitValidation)
+	}
+	if err = wmcb.UpdateServiceRecovery(setRecoveryOpts.services); err != nil {
+		log.Error(err, "could not update service recovery")
+		os.Exit(exitCode(err))
+	}
+	os.Stdout.WriteString("Service recovery configuration completed successfully")
+
+	err = wmcb.Disconnect()
+	if err != nil {
+		log.Error(err, "can't clean up bootstrapper")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+)
+
+var (
+	// statusCmd describes the status command
+	statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Prints the bootstrap status of the Windows node",
+		Long: "Prints the machine readable bootstrap status of the Windows node in the JSON format. The status records " +
+			"the outcome and time of each bootstrap phase executed by initialize-kubelet, configure-cni and " +
+			"configure-kube-proxy, the patch level of the node once Windows updates have been installed with " +
+			"apply-updates, and the antivirus, EDR agents, VPN clients and filter drivers found by preflight, along " +
+			"with the scanning exclusions of the node directories.",
+		Run: runStatusCmd,
+	}
+
+	// statusOpts holds the status CLI options
+	statusOpts struct {
+		// installDir is the main installation directory
+		installDir string
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.PersistentFlags().StringVar(&statusOpts.installDir, "install-dir", "c:\\k",
+		"Installation directory. Defaults to C:\\k")
+}
+
+// runStatusCmd prints the bootstrap status of the Windows node
+func runStatusCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	status, err := bootstrapper.ReadStatus(statusOpts.installDir)
+	if err != nil {
+		log.Error(err, "could not read bootstrap status")
+		os.Exit(exitCode(err))
+	}
+	content, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		log.Error(err, "could not marshal bootstrap status")
+		os.Exit(exitPermanent)
+	}
+	os.Stdout.Write(append(content, '\n'))
+}
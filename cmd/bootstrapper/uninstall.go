@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+)
+
+var (
+	// uninstallCmd describes the uninstall command
+	uninstallCmd = &cobra.Command{
+		Use:   "uninstall",
+		Short: "Removes the node configuration performed by the bootstrapper",
+		Long: "Removes the node configuration performed by the bootstrapper. " +
+			"This stops and removes the kubelet service, removes the firewall rules and Windows Defender exclusions " +
+			"and deletes the install directory along with the CNI directories.",
+		Run: runUninstallCmd,
+	}
+
+	// uninstallOpts holds the uninstall CLI options
+	uninstallOpts struct {
+		// installDir is the main installation directory
+		installDir string
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(uninstallCmd)
+	uninstallCmd.PersistentFlags().StringVar(&uninstallOpts.installDir, "install-dir", "c:\\k",
+		"Installation directory. Defaults to C:\\k")
+}
+
+// runUninstallCmd removes the bootstrapped node configuration from the Windows node
+func runUninstallCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+	wmcb, err := bootstrapper.New(bootstrapper.WithInstallDir(uninstallOpts.installDir))
+	if err != nil {
+		log.Error(err, "could not create bootstrapper")
+		os.Exit(exitCode(err))
+	}
+
+	if err = wmcb.Uninstall(); err != nil {
+		log.Error(err, "could not uninstall")
+		os.Exit(exitCode(err))
+	}
+
+	// Send success message to StdOut to ascertain that the removal was successful
+	os.Stdout.WriteString("Uninstall completed successfully")
+
+	if err = wmcb.Disconnect(); err != nil {
+		log.Error(err, "can't clean up bootstrapper")
+	}
+}
@@ -26,16 +26,16 @@ func init() {
 // runUninstallKubeletCmd uninstalls kubelet service from the Windows node
 func runUninstallKubeletCmd(cmd *cobra.Command, args []string) {
 	flag.Parse()
-	wmcb, err := bootstrapper.NewWinNodeBootstrapper("", "", "", "", "")
+	wmcb, err := bootstrapper.New()
 	if err != nil {
 		log.Error(err, "could not create bootstrapper")
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 
 	// uninstall kubelet Windows service
 	if err = wmcb.UninstallKubelet(); err != nil {
 		log.Error(err, "could not uninstall kubelet")
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 
 	// Send success message to StdOut to ascertain that kubelet removal was successful
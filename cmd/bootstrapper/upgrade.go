@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+)
+
+var (
+	// upgradeCmd describes the upgrade command
+	upgradeCmd = &cobra.Command{
+		Use:   "upgrade",
+		Short: "Upgrades the kubelet and the CNI plugins of the Windows node in place",
+		Long: "Upgrades the kubelet and the CNI plugins of a bootstrapped Windows node to the ones of the given " +
+			"artifacts bundle, without provisioning the node again. The kubelet service and its dependent services " +
+			"are stopped while the binaries are replaced, the kubelet arguments removed by the new kubelet version " +
+			"are dropped from the kubelet service, and the services are restarted. If the node is not Ready again " +
+			"within --node-ready-timeout, the previous binaries and kubelet service config are restored. A " +
+			"successful upgrade can be reverted with the rollback command.",
+		Run: runUpgradeCmd,
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return cmd.MarkPersistentFlagRequired("artifacts-dir")
+		},
+	}
+
+	// upgradeOpts holds the upgrade CLI options
+	upgradeOpts struct {
+		// artifactsDir is the directory of the artifacts bundle the node is upgraded to
+		artifactsDir string
+		// installDir is the main installation directory
+		installDir string
+		// nodeReadyTimeout is the time to wait for the node to be Ready after the upgrade
+		nodeReadyTimeout time.Duration
+		// skipVersionCheck indicates that the new kubelet version is not validated against the supported versions
+		// and the cluster version
+		skipVersionCheck bool
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(upgradeCmd)
+	upgradeCmd.PersistentFlags().StringVar(&upgradeOpts.artifactsDir, "artifacts-dir", "",
+		"Directory of the artifacts bundle holding the kubelet and CNI plugins to upgrade to")
+	upgradeCmd.PersistentFlags().StringVar(&upgradeOpts.installDir, "install-dir", "c:\\k",
+		"Installation directory. Defaults to C:\\k")
+	upgradeCmd.PersistentFlags().DurationVar(&upgradeOpts.nodeReadyTimeout, "node-ready-timeout", 10*time.Minute,
+		"Time to wait for the node to be Ready after the upgrade before rolling it back")
+	upgradeCmd.PersistentFlags().BoolVar(&upgradeOpts.skipVersionCheck, "skip-version-check", false,
+		"Skips the validation of the new kubelet version against the kubelet versions wmcb supports and the version "+
+			"of the API server")
+}
+
+// runUpgradeCmd upgrades the kubelet and the CNI plugins of the Windows node
+func runUpgradeCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	if err := upgrade(cmd.Context()); err != nil {
+		log.Error(err, "could not upgrade the Windows node")
+		os.Exit(exitCode(err))
+	}
+	os.Stdout.WriteString("Upgrade completed successfully")
+}
+
+// upgrade upgrades the kubelet and the CNI plugins to the ones of the artifacts bundle given on the command line
+func upgrade(ctx context.Context) error {
+	artifacts, err := bootstrapper.NewArtifacts(upgradeOpts.artifactsDir)
+	if err != nil {
+		return invalidInput("could not verify artifacts: %v", err)
+	}
+	wmcb, err := bootstrapper.New(bootstrapper.WithInstallDir(upgradeOpts.installDir))
+	if err != nil {
+		return fmt.Errorf("could not create bootstrapper: %w", err)
+	}
+	defer func() {
+		if err := wmcb.Disconnect(); err != nil {
+			log.Error(err, "can't clean up bootstrapper")
+		}
+	}()
+	if upgradeOpts.skipVersionCheck {
+		wmcb.SkipVersionCheck()
+	}
+	return wmcb.Upgrade(ctx, artifacts, upgradeOpts.nodeReadyTimeout)
+}
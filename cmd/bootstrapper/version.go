@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/bootstrapper"
+)
+
+var (
+	// version is the version of wmcb, which is set when building wmcb with -ldflags -X
+	version = "unknown"
+	// gitCommit is the commit wmcb is built from, which is set when building wmcb with -ldflags -X
+	gitCommit = "unknown"
+)
+
+var (
+	// versionCmd describes the version command
+	versionCmd = &cobra.Command{
+		Use:   "version",
+		Short: "Prints the version of wmcb and of the components installed on the Windows node",
+		Long: "Prints the build information of wmcb, including the kubelet versions it supports, along with the " +
+			"versions of the kubelet, containerd, CNI plugins, hybrid-overlay-node and CSI proxy installed on the " +
+			"Windows node, and of the API server if the kubelet kubeconfig is present. The incompatibilities found " +
+			"between them are listed, in which case the command fails.",
+		Run: runVersionCmd,
+	}
+
+	// versionOpts holds the version CLI options
+	versionOpts struct {
+		// installDir is the main installation directory
+		installDir string
+		// client indicates that only the build information of wmcb is printed
+		client bool
+		// json prints the versions in the JSON format
+		json bool
+	}
+)
+
+// buildInfo is the build information of wmcb
+type buildInfo struct {
+	// Version is the version of wmcb
+	Version string `json:"version"`
+	// GitCommit is the commit wmcb is built from
+	GitCommit string `json:"gitCommit"`
+	// GoVersion is the version of Go wmcb is built with
+	GoVersion string `json:"goVersion"`
+	// MinKubeletVersion and MaxKubeletVersion are the oldest and the newest kubelet minor versions wmcb supports
+	MinKubeletVersion string `json:"minKubeletVersion"`
+	MaxKubeletVersion string `json:"maxKubeletVersion"`
+}
+
+// versionInfo is the output of the version command
+type versionInfo struct {
+	// WMCB is the build information of wmcb
+	WMCB buildInfo `json:"wmcb"`
+	// Node holds the versions of the components installed on the Windows node. It is nil with --client.
+	Node *bootstrapper.VersionReport `json:"node,omitempty"`
+}
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+	versionCmd.PersistentFlags().StringVar(&versionOpts.installDir, "install-dir", "c:\\k",
+		"Installation directory. Defaults to C:\\k")
+	versionCmd.PersistentFlags().BoolVar(&versionOpts.client, "client", false,
+		"Print only the build information of wmcb, without inspecting the Windows node")
+	versionCmd.PersistentFlags().BoolVar(&versionOpts.json, "json", false, "Print the versions in the JSON format")
+}
+
+// runVersionCmd prints the version of wmcb and of the components installed on the Windows node
+func runVersionCmd(cmd *cobra.Command, args []string) {
+	flag.Parse()
+
+	info := versionInfo{WMCB: buildInfo{Version: version, GitCommit: gitCommit, GoVersion: runtime.Version()}}
+	info.WMCB.MinKubeletVersion, info.WMCB.MaxKubeletVersion = bootstrapper.SupportedKubeletVersions()
+	if !versionOpts.client {
+		wmcb, err := bootstrapper.New(bootstrapper.WithInstallDir(versionOpts.installDir))
+		if err != nil {
+			log.Error(err, "could not create bootstrapper")
+			os.Exit(exitCode(err))
+		}
+		info.Node = wmcb.Versions(cmd.Context())
+		if err = wmcb.Disconnect(); err != nil {
+			log.Error(err, "can't clean up bootstrapper")
+		}
+	}
+
+	if versionOpts.json {
+		content, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			log.Error(err, "could not marshal versions")
+			os.Exit(exitPermanent)
+		}
+		os.Stdout.Write(append(content, '\n'))
+	} else {
+		printVersions(info)
+	}
+	if info.Node != nil && !info.Node.Compatible() {
+		os.Exit(exitPermanent)
+	}
+}
+
+// printVersions prints the given versions as a table
+func printVersions(info versionInfo) {
+	fmt.Printf("wmcb %s (commit %s, %s), supporting kubelet %s to %s\n", info.WMCB.Version, info.WMCB.GitCommit,
+		info.WMCB.GoVersion, info.WMCB.MinKubeletVersion, info.WMCB.MaxKubeletVersion)
+	if info.Node == nil {
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\nCOMPONENT\tVERSION\tPATH")
+	for _, component := range info.Node.Components {
+		componentVersion := component.Version
+		if componentVersion == "" {
+			componentVersion = "(" + component.Error + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", component.Name, componentVersion, component.Path)
+	}
+	w.Flush()
+	for _, incompatibility := range info.Node.Incompatibilities {
+		fmt.Printf("[INCOMPATIBLE] %s\n", incompatibility)
+	}
+}
@@ -11,16 +11,23 @@ replace (
 )
 
 require (
+	github.com/Microsoft/hcsshim v0.8.14
 	github.com/coreos/ign-converter v0.0.0-20200825151652-ea20012f9844
 	github.com/coreos/ignition v0.35.0
 	github.com/coreos/ignition/v2 v2.6.0
 	github.com/go-bindata/go-bindata/v3 v3.1.3
+	github.com/go-logr/logr v0.3.0
+	github.com/go-logr/zapr v0.2.0
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.6.1
 	github.com/vincent-petithory/dataurl v0.0.0-20160330182126-9a301d65acbb
-	golang.org/x/sys v0.0.0-20201112073958-5cba982894dd
+	go.uber.org/zap v1.15.0
+	golang.org/x/sys v0.0.0-20201201145000-ef89a241ccb3
+	k8s.io/api v0.20.0
 	k8s.io/apimachinery v0.20.0
+	k8s.io/client-go v0.20.0
 	sigs.k8s.io/controller-runtime v0.7.0
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -41,11 +41,22 @@ fi
 
 OC=$(get_oc)
 
-if ! $OC create secret generic cloud-private-key --from-file=private-key.pem=$KUBE_SSH_KEY_PATH -n default; then
+# The passphrase of the private key and the VM password are optional
+SSH_AUTH_ARGS=""
+if [ -n "${KUBE_SSH_KEY_PASSPHRASE:-}" ]; then
+  SSH_AUTH_ARGS="$SSH_AUTH_ARGS --from-literal=passphrase=$KUBE_SSH_KEY_PASSPHRASE"
+fi
+if [ -n "${WINDOWS_VM_PASSWORD:-}" ]; then
+  SSH_AUTH_ARGS="$SSH_AUTH_ARGS --from-literal=vm-password=$WINDOWS_VM_PASSWORD"
+fi
+if ! $OC create secret generic cloud-private-key --from-file=private-key.pem=$KUBE_SSH_KEY_PATH $SSH_AUTH_ARGS -n default; then
     echo "cloud-private-key already present"
 fi
-if ! $OC create secret generic aws-creds --from-file=credentials=$AWS_SHARED_CREDENTIALS_FILE -n default; then
-    echo "aws credentials already present"
+# AWS credentials are only required on AWS clusters
+if [ -n "${AWS_SHARED_CREDENTIALS_FILE:-}" ]; then
+  if ! $OC create secret generic aws-creds --from-file=credentials=$AWS_SHARED_CREDENTIALS_FILE -n default; then
+      echo "aws credentials already present"
+  fi
 fi
 if ! $OC apply -f internal/test/wmcb/deploy/role.yaml -n default; then
     echo "role already present"
@@ -56,6 +67,13 @@ fi
 
 sed -i "s~ARTIFACT_DIR_VALUE~${ARTIFACT_DIR}~g" internal/test/wmcb/deploy/job.yaml
 sed -i "s~REPLACE_IMAGE~${WMCB_IMAGE}~g" internal/test/wmcb/deploy/job.yaml
+sed -i "s~WINDOWS_VM_TRANSPORT_VALUE~${WINDOWS_VM_TRANSPORT:-ssh}~g" internal/test/wmcb/deploy/job.yaml
+# An existing Windows instance is used instead of creating a Windows MachineSet if its address is given
+sed -i "s~WINDOWS_VM_ADDRESS_VALUE~${WINDOWS_VM_ADDRESS:-}~g" internal/test/wmcb/deploy/job.yaml
+sed -i "s~WINDOWS_VM_USERNAME_VALUE~${WINDOWS_VM_USERNAME:-}~g" internal/test/wmcb/deploy/job.yaml
+# The tests are run against each of the comma separated Windows Server versions in turn
+WINDOWS_SERVER_VERSIONS=${WINDOWS_SERVER_VERSIONS:-${WINDOWS_SERVER_VERSION:-2019}}
+sed -i "s~WINDOWS_SERVER_VERSIONS_VALUE~${WINDOWS_SERVER_VERSIONS}~g" internal/test/wmcb/deploy/job.yaml
 
 # deploy the test pod on test cluster
 if ! $OC apply -f internal/test/wmcb/deploy/job.yaml -n default; then
@@ -45,7 +45,13 @@ func (o *OpenShift) GetInfrastructureID() (string, error) {
 // GetCloudProvider returns the Provider details of a given OpenShift client including provider type and region or
 // an error.
 func (o *OpenShift) GetCloudProvider() (*v1.PlatformStatus, error) {
-	infra, err := o.getInfrastructure()
+	return GetPlatformStatus(context.TODO(), o.Client)
+}
+
+// GetPlatformStatus returns the platform the cluster of the given client runs on, including its type and region, as
+// read from the Infrastructure object of the cluster
+func GetPlatformStatus(ctx context.Context, client clientset.Interface) (*v1.PlatformStatus, error) {
+	infra, err := client.ConfigV1().Infrastructures().Get(ctx, "cluster", meta.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
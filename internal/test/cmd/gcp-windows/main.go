@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/credentials"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/cli"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/gcp"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/userdata"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/wsu"
+)
+
+const (
+	// credentialsEnv is the environment variable holding the path of the JSON key of the GCP service account
+	credentialsEnv = "GOOGLE_APPLICATION_CREDENTIALS"
+	// createTimeout is the time the Windows instance has to start and generate its password
+	createTimeout = 20 * time.Minute
+)
+
+// gcp-windows creates Windows instances for the Windows nodes of an OpenShift cluster running on GCP, adding them to
+// the windows-node-installer.json file wsu bootstraps them from, and destroys them. The project and the label
+// identifying the instances of the cluster are read from the Infrastructure object of the cluster.
+func main() {
+	tool := cli.NewTool("gcp-windows", "instances")
+	kubeconfig := tool.KubeconfigFlag()
+	project := tool.Flags.String("project", "", "Project the Windows instances are managed in. Defaults to the "+
+		"project of the cluster")
+	name := tool.Flags.String("name", "", "Name of the Windows instance. Defaults to <infrastructure name>-windows-"+
+		"<random>")
+	zone := tool.Flags.String("zone", "", "Zone the Windows instance is created in, in the region of the cluster")
+	network := tool.Flags.String("network", "", "VPC network of the cluster the Windows instance is created in. "+
+		"Defaults to <infrastructure name>-network")
+	subnetwork := tool.Flags.String("subnetwork", "", "Subnetwork of the cluster the Windows instance is created "+
+		"in. Defaults to <infrastructure name>-worker-subnet")
+	publicKey := tool.Flags.String("public-key", "", "Public key authorized to access the Windows instance over ssh")
+	image := tool.Flags.String("image", "", "Image of the Windows instance. Defaults to the latest public image of "+
+		"--windows-version")
+	windowsVersion := tool.Flags.String("windows-version", "2019", "Windows Server version of the Windows instance")
+	machineType := tool.Flags.String("machine-type", gcp.DefaultMachineType, "Machine type of the Windows instance")
+	allowedCIDR := tool.Flags.String("allowed-cidr", gcp.DefaultAllowedCIDR,
+		"CIDR the ssh, WinRM and kubelet ports of the Windows instance are opened to")
+	tool.Parse()
+
+	keyFile := os.Getenv(credentialsEnv)
+	if keyFile == "" {
+		log.Fatalf("%s is required", credentialsEnv)
+	}
+	keyJSON, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		log.Fatalf("error reading service account key: %v", err)
+	}
+	cluster, err := getCluster(*kubeconfig)
+	if err != nil {
+		log.Fatalf("error getting the cluster: %v", err)
+	}
+	if *project == "" {
+		*project = cluster.Platform.GCP.ProjectID
+	}
+	provider, err := gcp.NewProvider(keyJSON, *project, cluster.InfrastructureName)
+	if err != nil {
+		log.Fatalf("error creating GCP provider: %v", err)
+	}
+
+	var authorizedKey []byte
+	if tool.Command == cli.Create {
+		if *zone == "" || *publicKey == "" {
+			log.Fatal("--zone and --public-key are required")
+		}
+		if authorizedKey, err = ioutil.ReadFile(*publicKey); err != nil {
+			log.Fatalf("error reading public key: %v", err)
+		}
+		if *name == "" {
+			*name = cluster.InfrastructureName + "-windows-" + rand.String(5)
+		}
+		if *network == "" {
+			*network = cluster.InfrastructureName + "-network"
+		}
+		if *subnetwork == "" {
+			*subnetwork = cluster.InfrastructureName + "-worker-subnet"
+		}
+	}
+	tool.Run(cli.Commands{
+		Create: func(ctx context.Context) ([]wsu.Instance, error) {
+			username := credentials.DefaultUsername(configv1.GCPPlatformType)
+			instance, err := provider.CreateWindowsInstance(ctx, gcp.InstanceSpec{
+				Name:           *name,
+				Zone:           *zone,
+				Network:        *network,
+				Subnetwork:     *subnetwork,
+				Image:          *image,
+				WindowsVersion: *windowsVersion,
+				MachineType:    *machineType,
+				AllowedCIDR:    *allowedCIDR,
+				Username:       username,
+				UserData:       []byte(userdata.SetupScript(string(authorizedKey))),
+			})
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("created Windows instance %s (%s) at %s, private address %s", instance.Name, instance.ID,
+				instance.ExternalIP, instance.PrivateIP)
+			return []wsu.Instance{{
+				InstanceID:     instance.Name,
+				IPAddress:      instance.ExternalIP,
+				Username:       username,
+				Password:       instance.Password,
+				SecurityGroups: []string{instance.FirewallRule},
+			}}, nil
+		},
+		CreateTimeout:    createTimeout,
+		Destroy:          provider.DestroyTaggedInstances,
+		Destroyed:        "instances",
+		DestroyInstances: provider.DestroyInstances,
+		Scope:            *project + "/" + cluster.InfrastructureName,
+		ClusterAddress:   cluster.Address,
+	})
+}
+
+// getCluster returns the cluster of the given kubeconfig, after checking that it runs on GCP
+func getCluster(kubeconfig string) (*cli.Cluster, error) {
+	cluster, err := cli.GetCluster(context.TODO(), kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	if cluster.Platform.Type != configv1.GCPPlatformType || cluster.Platform.GCP == nil {
+		return nil, fmt.Errorf("the cluster does not run on GCP")
+	}
+	return cluster, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/credentials"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/cli"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/ibmcloud"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/userdata"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/wsu"
+)
+
+const (
+	// apiKeyEnv is the environment variable holding the IBM Cloud API key
+	apiKeyEnv = "IBMCLOUD_API_KEY"
+	// tagPrefix prefixes the infrastructure name of the cluster in the tag of the Windows instances
+	tagPrefix = "windows-node-installer:"
+	// createTimeout is the time the Windows instance has to start
+	createTimeout = 15 * time.Minute
+)
+
+// ibmcloud-windows creates Windows instances for the Windows nodes of an OpenShift cluster running on IBM Cloud VPC,
+// adding them to the windows-node-installer.json file wsu bootstraps them from, and destroys them. The region and the
+// tag identifying the instances of the cluster are read from the Infrastructure object of the cluster.
+func main() {
+	tool := cli.NewTool("ibmcloud-windows", "instances")
+	kubeconfig := tool.KubeconfigFlag()
+	region := tool.Flags.String("region", "", "Region the Windows instances are managed in. Defaults to the region "+
+		"of the cluster")
+	name := tool.Flags.String("name", "", "Name of the Windows instance. Defaults to <infrastructure name>-windows-"+
+		"<random>")
+	subnetID := tool.Flags.String("subnet-id", "", "ID of the subnet of the cluster the Windows instance is created "+
+		"in")
+	keyID := tool.Flags.String("key-id", "", "ID of the VPC ssh key of the Windows instance")
+	publicKey := tool.Flags.String("public-key", "", "Public key authorized to access the Windows instance over ssh")
+	imageID := tool.Flags.String("image-id", "", "ID of the image of the Windows instance. Defaults to the latest "+
+		"public image of --windows-version")
+	windowsVersion := tool.Flags.String("windows-version", "2019", "Windows Server version of the Windows instance")
+	profile := tool.Flags.String("profile", ibmcloud.DefaultProfile, "Profile of the Windows instance")
+	securityGroupID := tool.Flags.String("security-group-id", "", "ID of the security group of the Windows "+
+		"instance. Defaults to the default security group of the VPC")
+	allowedCIDR := tool.Flags.String("allowed-cidr", ibmcloud.DefaultAllowedCIDR,
+		"CIDR the ssh, WinRM and kubelet ports of the Windows instance are opened to")
+	tool.Parse()
+
+	apiKey := os.Getenv(apiKeyEnv)
+	if apiKey == "" {
+		log.Fatalf("%s is required", apiKeyEnv)
+	}
+	cluster, err := getCluster(*kubeconfig)
+	if err != nil {
+		log.Fatalf("error getting the cluster: %v", err)
+	}
+	if *region == "" {
+		*region = cluster.Platform.IBMCloud.Location
+	}
+	provider, err := ibmcloud.NewProvider(apiKey, *region, tagPrefix+cluster.InfrastructureName)
+	if err != nil {
+		log.Fatalf("error creating IBM Cloud provider: %v", err)
+	}
+
+	var authorizedKey []byte
+	if tool.Command == cli.Create {
+		if *subnetID == "" || *keyID == "" || *publicKey == "" {
+			log.Fatal("--subnet-id, --key-id and --public-key are required")
+		}
+		if authorizedKey, err = ioutil.ReadFile(*publicKey); err != nil {
+			log.Fatalf("error reading public key: %v", err)
+		}
+		if *name == "" {
+			*name = cluster.InfrastructureName + "-windows-" + rand.String(5)
+		}
+	}
+	tool.Run(cli.Commands{
+		Create: func(ctx context.Context) ([]wsu.Instance, error) {
+			instance, err := provider.CreateWindowsInstance(ctx, ibmcloud.InstanceSpec{
+				Name:            *name,
+				SubnetID:        *subnetID,
+				ImageID:         *imageID,
+				WindowsVersion:  *windowsVersion,
+				Profile:         *profile,
+				KeyID:           *keyID,
+				SecurityGroupID: *securityGroupID,
+				AllowedCIDR:     *allowedCIDR,
+				UserData:        userdata.CloudbaseInitUserData(userdata.SetupScript(string(authorizedKey))),
+			})
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("created Windows instance %s (%s) at %s, private address %s", instance.Name, instance.ID,
+				instance.FloatingIP, instance.PrivateIP)
+			return []wsu.Instance{{
+				InstanceID:     instance.ID,
+				IPAddress:      instance.FloatingIP,
+				Username:       credentials.DefaultUsername(configv1.IBMCloudPlatformType),
+				SecurityGroups: []string{instance.SecurityGroupID},
+				KeyPair:        *keyID,
+			}}, nil
+		},
+		CreateTimeout:    createTimeout,
+		Destroy:          provider.DestroyTaggedInstances,
+		Destroyed:        "instances",
+		DestroyInstances: provider.DestroyInstances,
+		Scope:            *region + "/" + tagPrefix + cluster.InfrastructureName,
+		ClusterAddress:   cluster.Address,
+	})
+}
+
+// getCluster returns the cluster of the given kubeconfig, after checking that it runs on IBM Cloud VPC
+func getCluster(kubeconfig string) (*cli.Cluster, error) {
+	cluster, err := cli.GetCluster(context.TODO(), kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	platform := cluster.Platform
+	if platform.Type != configv1.IBMCloudPlatformType || platform.IBMCloud == nil {
+		return nil, fmt.Errorf("the cluster does not run on IBM Cloud")
+	}
+	if platform.IBMCloud.ProviderType != configv1.IBMCloudProviderTypeVPC {
+		return nil, fmt.Errorf("the cluster runs on IBM Cloud %s, only VPC is supported", platform.IBMCloud.ProviderType)
+	}
+	return cluster, nil
+}
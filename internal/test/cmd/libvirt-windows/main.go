@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/rand"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/cli"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/libvirt"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/userdata"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/wsu"
+)
+
+const (
+	// defaultTag is the description of the domains of the Windows VMs
+	defaultTag = "windows-node-installer"
+	// createTimeout is the time the Windows VM has to boot and get an address
+	createTimeout = 20 * time.Minute
+)
+
+// libvirt-windows creates Windows VMs with libvirt/KVM for local WMCB development, adding them to the
+// windows-node-installer.json file wsu bootstraps them from, and destroys them
+func main() {
+	tool := cli.NewTool("libvirt-windows", "VMs")
+	uri := tool.Flags.String("connect", libvirt.DefaultURI, "libvirt connection URI of the hypervisor, e.g. "+
+		"qemu+ssh://user@host/system for a remote hypervisor")
+	tag := tool.Flags.String("tag", defaultTag, "Description of the domains of the Windows VMs, identifying the VMs "+
+		"that are destroyed")
+	clusterAddress := tool.Flags.String("cluster-address", "", "Address of the cluster written to "+
+		"--inventory-file, like cluster.example.com for the API server at api.cluster.example.com")
+	name := tool.Flags.String("name", "", "Name of the domain of the Windows VM. Defaults to windows-<random>")
+	baseImage := tool.Flags.String("base-image", "", "Path on the hypervisor of the Windows qcow2 image with the "+
+		"virtio drivers and cloudbase-init installed")
+	pool := tool.Flags.String("pool", libvirt.DefaultPool, "Storage pool the disk of the Windows VM is created in")
+	bridge := tool.Flags.String("bridge", "", "Bridge of the hypervisor connected to the network of the cluster")
+	memory := tool.Flags.Int("memory", libvirt.DefaultMemory, "Memory of the Windows VM in MiB")
+	vcpus := tool.Flags.Int("vcpus", libvirt.DefaultVCPUs, "Number of virtual CPUs of the Windows VM")
+	publicKey := tool.Flags.String("public-key", "", "Public key authorized to access the Windows VM over ssh")
+	tool.Parse()
+
+	provider, err := libvirt.NewProvider(*uri, *tag)
+	if err != nil {
+		log.Fatalf("error creating libvirt provider: %v", err)
+	}
+
+	var authorizedKey []byte
+	if tool.Command == cli.Create {
+		if *baseImage == "" || *bridge == "" || *publicKey == "" {
+			log.Fatal("--base-image, --bridge and --public-key are required")
+		}
+		if tool.WritesInventory() && *clusterAddress == "" {
+			log.Fatal("--cluster-address is required with --inventory-file")
+		}
+		if authorizedKey, err = ioutil.ReadFile(*publicKey); err != nil {
+			log.Fatalf("error reading public key: %v", err)
+		}
+		if *name == "" {
+			*name = "windows-" + rand.String(5)
+		}
+	}
+	tool.Run(cli.Commands{
+		Create: func(ctx context.Context) ([]wsu.Instance, error) {
+			instance, err := provider.CreateWindowsInstance(ctx, libvirt.InstanceSpec{
+				Name:      *name,
+				BaseImage: *baseImage,
+				Pool:      *pool,
+				Bridge:    *bridge,
+				Memory:    *memory,
+				VCPUs:     *vcpus,
+				UserData:  userdata.CloudbaseInitUserData(userdata.SetupScript(string(authorizedKey))),
+			})
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("created Windows VM %s at %s", instance.Name, instance.IPAddress)
+			creds := instance.Credentials()
+			return []wsu.Instance{{
+				InstanceID: creds.InstanceId(),
+				IPAddress:  creds.IPAddress(),
+				Username:   creds.UserName(),
+			}}, nil
+		},
+		CreateTimeout:    createTimeout,
+		Destroy:          provider.DestroyTaggedInstances,
+		Destroyed:        "VMs",
+		DestroyInstances: provider.DestroyInstances,
+		Scope:            *uri + "/" + *tag,
+		ClusterAddress: func() (string, error) {
+			return *clusterAddress, nil
+		},
+	})
+}
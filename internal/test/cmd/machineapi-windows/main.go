@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	mapi "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/credentials"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/cli"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/machineapi"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/wsu"
+)
+
+const (
+	// createTimeout is the time the Machines have to be provisioned
+	createTimeout = 20 * time.Minute
+)
+
+// machineapi-windows creates Windows instances for the Windows nodes of an OpenShift cluster through the Machine API,
+// as the Machines of a Windows MachineSet it creates or of an existing one it scales, adding them to the
+// windows-node-installer.json file wsu bootstraps them from, and destroys the MachineSets it created. The instances
+// are managed by the Machine API like the other nodes of the cluster, rather than through the SDK of the cloud, so
+// they are destroyed with their MachineSets rather than with --all or --older-than.
+func main() {
+	tool := cli.NewTool("machineapi-windows", "instances")
+	kubeconfig := tool.KubeconfigFlag()
+	replicas := tool.Flags.Int("replicas", 1, "Number of Windows instances")
+	machineSet := tool.Flags.String("machineset", "", "Name of an existing Windows MachineSet that is scaled to "+
+		"--replicas instead of creating a MachineSet")
+	spreadZones := tool.Flags.Bool("spread-zones", false, "Spread the Windows instances round-robin across the "+
+		"zones of the worker MachineSets of the cluster, creating a MachineSet per zone")
+	windowsVersion := tool.Flags.String("windows-version", "", "Windows Server version of the Windows instances. "+
+		"Defaults to $WINDOWS_SERVER_VERSION or 2019")
+	keyPair := tool.Flags.String("key-pair", "", "Name of the cloud key pair of the Windows instances, on the "+
+		"platforms that take one like AWS")
+	publicKey := tool.Flags.String("public-key", "", "Public key authorized to access the Windows instances over ssh")
+	tool.Parse()
+
+	// The cloud provider reads the Infrastructure object of the cluster of $KUBECONFIG
+	if err := os.Setenv("KUBECONFIG", *kubeconfig); err != nil {
+		log.Fatalf("error setting KUBECONFIG: %v", err)
+	}
+	cluster, err := cli.GetCluster(context.TODO(), *kubeconfig)
+	if err != nil {
+		log.Fatalf("error getting the cluster: %v", err)
+	}
+	cloudProvider, err := providers.NewCloudProvider(*keyPair, *windowsVersion)
+	if err != nil {
+		log.Fatalf("error creating cloud provider: %v", err)
+	}
+	provider, err := machineapi.NewProvider(cluster.RESTConfig, cloudProvider)
+	if err != nil {
+		log.Fatalf("error creating Machine API provider: %v", err)
+	}
+
+	var authorizedKey []byte
+	if tool.Command == cli.Create {
+		if *publicKey == "" {
+			log.Fatal("--public-key is required")
+		}
+		if *replicas < 1 {
+			log.Fatal("--replicas needs to be at least 1")
+		}
+		if *spreadZones && *machineSet != "" {
+			log.Fatal("--spread-zones cannot be used with --machineset")
+		}
+		if authorizedKey, err = ioutil.ReadFile(*publicKey); err != nil {
+			log.Fatalf("error reading public key: %v", err)
+		}
+	}
+	tool.Run(cli.Commands{
+		Create: func(ctx context.Context) ([]wsu.Instance, error) {
+			if err := provider.EnsureUserDataSecret(ctx, string(authorizedKey)); err != nil {
+				return nil, fmt.Errorf("error creating user data secret: %v", err)
+			}
+			machineSets, err := createMachineSets(ctx, provider, *machineSet, *spreadZones, int32(*replicas))
+			if err != nil {
+				return nil, err
+			}
+			var instances []wsu.Instance
+			for _, ms := range machineSets {
+				msInstances, err := provider.WaitForInstances(ctx, ms.Name, *ms.Spec.Replicas)
+				if err != nil {
+					return nil, fmt.Errorf("error waiting for the Windows instances of MachineSet %s: %v", ms.Name,
+						err)
+				}
+				for _, instance := range msInstances {
+					log.Printf("created Windows instance %s of Machine %s at %s", instance.InstanceID,
+						instance.MachineName, instance.IPAddress)
+					instances = append(instances, wsu.Instance{
+						InstanceID: instance.InstanceID,
+						IPAddress:  instance.IPAddress,
+						Username:   credentials.DefaultUsername(cluster.Platform.Type),
+						KeyPair:    *keyPair,
+					})
+				}
+			}
+			return instances, nil
+		},
+		CreateTimeout:  createTimeout,
+		Destroy:        provider.DestroyWindowsMachineSets,
+		Destroyed:      "MachineSets",
+		Scope:          cluster.InfrastructureName,
+		ClusterAddress: cluster.Address,
+	})
+}
+
+// createMachineSets creates the Windows MachineSets with the given number of replicas, spread across the zones of the
+// worker MachineSets if spreadZones is set, or scales the given existing MachineSet instead
+func createMachineSets(ctx context.Context, provider *machineapi.Provider, machineSet string, spreadZones bool,
+	replicas int32) ([]*mapi.MachineSet, error) {
+	switch {
+	case spreadZones:
+		machineSets, err := provider.CreateZonalWindowsMachineSets(ctx, replicas, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error creating Windows MachineSets: %v", err)
+		}
+		return machineSets, nil
+	case machineSet == "":
+		created, err := provider.CreateWindowsMachineSet(ctx, replicas, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error creating Windows MachineSet: %v", err)
+		}
+		return []*mapi.MachineSet{created}, nil
+	default:
+		scaled, _, err := provider.ScaleWindowsMachineSet(ctx, machineSet, replicas)
+		if err != nil {
+			return nil, fmt.Errorf("error scaling Windows MachineSet: %v", err)
+		}
+		return []*mapi.MachineSet{scaled}, nil
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/credentials"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/payload"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/windows"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/wsu"
+)
+
+// wsu bootstraps a Windows instance described in the windows-node-installer.json file into a Windows node of an
+// OpenShift cluster, without requiring Ansible. With --all, every instance of the file is bootstrapped in parallel.
+func main() {
+	kubeconfig := flag.String("kubeconfig", os.Getenv("KUBECONFIG"),
+		"Kubeconfig of the cluster the node joins. Defaults to $KUBECONFIG")
+	instancesFile := flag.String("instances-file", "windows-node-installer.json",
+		"File describing the Windows instances")
+	instanceID := flag.String("instance-id", "",
+		"ID of the instance in the instances file to bootstrap. Required if the file has more than one instance, "+
+			"unless --all is given")
+	all := flag.Bool("all", false, "Bootstrap every instance of the instances file in parallel, reporting the "+
+		"outcome of each instance. The instances that fail do not stop the bootstrap of the others")
+	parallel := flag.Int("parallel", wsu.DefaultParallelism, "Number of instances bootstrapped at the same time "+
+		"with --all")
+	reportFile := flag.String("report-file", "", "File the outcome of each instance is written to in the JSON "+
+		"format with --all")
+	privateKey := flag.String("private-key", "", "Private key the instance is accessed with. "+
+		"KUBE_SSH_KEY_PASSPHRASE is used as the passphrase of a passphrase protected key")
+	transport := flag.String("transport", string(windows.SSHTransport),
+		"Transport used to access the instance, either ssh or winrm. winrm requires the password of the instance")
+	payloadDir := flag.String("payload-dir", "", "Directory containing wmcb.exe, kubelet.exe, "+
+		"hybrid-overlay-node.exe and the cni directory with the CNI plugins")
+	payloadSource := flag.String("payload", "", "Source the payload is fetched from instead of --payload-dir: "+
+		"a local directory, the http(s) URL of a mirror serving the payload and its SHA256SUMS manifest, "+
+		"image://<pullspec> or release://<release image component>, extracting --payload-image-path of the image")
+	payloadCacheDir := flag.String("payload-cache-dir", defaultPayloadCacheDir(),
+		"Directory the payloads fetched from a mirror or an image are cached in")
+	payloadImagePath := flag.String("payload-image-path", payload.DefaultImagePath,
+		"Directory of the image the payload is extracted from with --payload image:// or release://")
+	releaseImage := flag.String("release-image", "", "Release image the component image is read from with "+
+		"--payload release://. Defaults to the release image of the cluster")
+	pullSecret := flag.String("pull-secret", "", "Registry config the images of --payload image:// and release:// "+
+		"are pulled with. Defaults to the registry config of oc")
+	nodeReadyTimeout := flag.Duration("node-ready-timeout", wsu.DefaultNodeReadyTimeout,
+		"Time to wait for the node to be Ready")
+	streamKubeletLog := flag.Bool("stream-kubelet-log", false,
+		"Stream the kubelet log of the instance to StdErr while the node is bootstrapped. Not supported over winrm")
+	checkWorkload := flag.Bool("check-workload", false, "Check that the node runs workloads once it is Ready, by "+
+		"deploying a Windows web server pod to it and reaching it through a service from a Linux pod")
+	flag.Parse()
+
+	if *privateKey == "" || (*payloadDir == "") == (*payloadSource == "") {
+		log.Fatal("--private-key and one of --payload-dir or --payload are required")
+	}
+	if *all && (*instanceID != "" || *streamKubeletLog) {
+		log.Fatal("--all cannot be used with --instance-id or --stream-kubelet-log")
+	}
+	signer, err := credentials.LoadSigner(*privateKey, os.Getenv("KUBE_SSH_KEY_PASSPHRASE"))
+	if err != nil {
+		log.Fatalf("error loading private key: %v", err)
+	}
+
+	// Interrupting wsu aborts the bootstrap instead of leaving it waiting on the instance or the node
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	if *payloadSource != "" {
+		options := payload.Options{
+			CacheDir:     *payloadCacheDir,
+			Kubeconfig:   *kubeconfig,
+			ReleaseImage: *releaseImage,
+			PullSecret:   *pullSecret,
+			ImagePath:    *payloadImagePath,
+		}
+		source, err := payload.NewSource(*payloadSource, options)
+		if err != nil {
+			log.Fatalf("error creating payload source: %v", err)
+		}
+		if *payloadDir, err = source.Resolve(ctx); err != nil {
+			log.Fatalf("error fetching payload: %v", err)
+		}
+		// WMCB checks the kubelet of the payload against the API server, this only reports what it is expected to be
+		if strings.HasPrefix(*payloadSource, "release://") {
+			if kubernetes, err := payload.ReleaseKubernetesVersion(ctx, options); err == nil {
+				log.Printf("payload extracted from a release with Kubernetes %s", kubernetes)
+			}
+		}
+	}
+
+	config := wsu.Config{
+		Kubeconfig:       *kubeconfig,
+		Signer:           signer,
+		Transport:        windows.Transport(*transport),
+		PayloadDir:       *payloadDir,
+		NodeReadyTimeout: *nodeReadyTimeout,
+		CheckWorkload:    *checkWorkload,
+	}
+	if *streamKubeletLog {
+		config.KubeletLog = os.Stderr
+	}
+
+	if *all {
+		instances, err := wsu.LoadInstances(*instancesFile)
+		if err != nil {
+			log.Fatalf("error loading instances: %v", err)
+		}
+		if len(instances) == 0 {
+			log.Fatalf("no instance in %s", *instancesFile)
+		}
+		results := wsu.RunBatch(ctx, config, instances, *parallel)
+		if !reportResults(results, *reportFile) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	instance, err := wsu.LoadInstance(*instancesFile, *instanceID)
+	if err != nil {
+		log.Fatalf("error loading instance: %v", err)
+	}
+	config.Instance = *instance
+	w, err := wsu.New(config)
+	if err != nil {
+		log.Fatalf("error creating WSU: %v", err)
+	}
+	if err = w.Run(ctx); err != nil {
+		log.Fatalf("error bootstrapping instance %s: %v", instance.InstanceID, err)
+	}
+}
+
+// reportResults prints the outcome of each instance of a batch to StdOut, and writes it to the given report file in
+// the JSON format if it is set. It returns true if every instance was bootstrapped.
+func reportResults(results []wsu.HostResult, reportFile string) bool {
+	failed := 0
+	for _, result := range results {
+		status := "OK"
+		if result.Err != nil {
+			status = "FAILED"
+			failed++
+		}
+		fmt.Printf("[%s] %s (%s) in %v", status, result.InstanceID, result.IPAddress, result.Duration)
+		if result.Err != nil {
+			fmt.Printf(": %v", result.Err)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d of %d instances bootstrapped\n", len(results)-failed, len(results))
+	if reportFile != "" {
+		content, err := json.MarshalIndent(results, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(reportFile, append(content, '\n'), 0644)
+		}
+		if err != nil {
+			log.Printf("error writing report %s: %v", reportFile, err)
+		}
+	}
+	return failed == 0
+}
+
+// defaultPayloadCacheDir returns the wsu directory of the cache directory of the user, or of the temporary directory
+// if the user has none
+func defaultPayloadCacheDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return filepath.Join(cacheDir, "wsu")
+}
@@ -2,14 +2,33 @@ package credentials
 
 import (
 	"fmt"
+	"io/ioutil"
+
+	v1 "github.com/openshift/api/config/v1"
 	"golang.org/x/crypto/ssh"
 )
 
 const (
 	// Username is the default windows username on AWS
 	Username = "Administrator"
+	// AzureUsername is the windows username the Machine API creates the Azure VMs with
+	AzureUsername = "capi"
+	// GCPUsername is the windows username the password of the GCP VMs is generated for, as the Administrator account
+	// is disabled in the GCP images
+	GCPUsername = "wmcb"
 )
 
+// DefaultUsername returns the username of the Windows instances created on the given platform
+func DefaultUsername(platform v1.PlatformType) string {
+	switch platform {
+	case v1.AzurePlatformType:
+		return AzureUsername
+	case v1.GCPPlatformType:
+		return GCPUsername
+	}
+	return Username
+}
+
 // Credentials holds the information to access the Windows instance created.
 type Credentials struct {
 	// instanceID uniquely identifies the instanceID
@@ -18,6 +37,8 @@ type Credentials struct {
 	ipAddress string
 	// sshKey to access the instance created
 	sshKey ssh.Signer
+	// password to access the instance created. It is used in addition to the ssh key if set.
+	password string
 	// user used for accessing the  instance created
 	user string
 }
@@ -43,6 +64,16 @@ func (cred *Credentials) SetSSHKey(signer ssh.Signer) {
 	cred.sshKey = signer
 }
 
+// Password returns the password associated with the given node
+func (cred *Credentials) Password() string {
+	return cred.password
+}
+
+// SetPassword sets the password for the given node
+func (cred *Credentials) SetPassword(password string) {
+	cred.password = password
+}
+
 // GetInstanceID returns the instanceId associated with the given node
 func (cred *Credentials) InstanceId() string {
 	return cred.instanceID
@@ -57,3 +88,24 @@ func (cred *Credentials) UserName() string {
 func (c *Credentials) String() string {
 	return fmt.Sprintf("%v", *c)
 }
+
+// LoadSigner returns the ssh signer of the private key at the given path. The passphrase is only used if the private
+// key is passphrase protected.
+func LoadSigner(privateKeyPath, passphrase string) (ssh.Signer, error) {
+	privateKeyBytes, err := ioutil.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find private key from path: %v, err: %v", privateKeyPath, err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(privateKeyBytes)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		if passphrase == "" {
+			return nil, fmt.Errorf("private key %v is passphrase protected but no passphrase is given", privateKeyPath)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(privateKeyBytes, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key: %v, err: %v", err, privateKeyPath)
+	}
+	return signer, nil
+}
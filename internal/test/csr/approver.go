@@ -0,0 +1,137 @@
+package csr
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	certificates "k8s.io/api/certificates/v1"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// nodeBootstrapperUsername is the user that creates the kubelet client CSR while the node is being bootstrapped
+	nodeBootstrapperUsername = "system:serviceaccount:openshift-machine-config-operator:node-bootstrapper"
+	// bootstrapTokenUserPrefix is the prefix of the users that create the kubelet client CSR while the node is being
+	// bootstrapped with a bootstrap token
+	bootstrapTokenUserPrefix = "system:bootstrap:"
+	// nodeUserPrefix is the prefix of the user and common name with which a node identifies itself
+	nodeUserPrefix = "system:node:"
+)
+
+// Approver approves the pending node-bootstrapper and kubelet-serving CSRs of a single node. This is meant to be
+// used by the tests and for manual bootstraps, where there is no machine approver to approve the CSRs of the node.
+type Approver struct {
+	// client is the client of the cluster the node is joining
+	client kubernetes.Interface
+	// nodeName is the name of the node whose CSRs are approved, which is the lower case hostname of the Windows VM
+	nodeName string
+}
+
+// NewApprover returns an Approver that approves the CSRs of the given node using the given client
+func NewApprover(client kubernetes.Interface, nodeName string) (*Approver, error) {
+	if client == nil {
+		return nil, fmt.Errorf("client cannot be nil")
+	}
+	if nodeName == "" {
+		return nil, fmt.Errorf("node name cannot be empty")
+	}
+	return &Approver{client: client, nodeName: strings.ToLower(nodeName)}, nil
+}
+
+// Run approves the CSRs of the node every interval until stopCh is closed. Errors are logged and retried on the next
+// interval.
+func (a *Approver) Run(interval time.Duration, stopCh <-chan struct{}) {
+	log.Printf("approving CSRs of node %s", a.nodeName)
+	wait.Until(func() {
+		if _, err := a.ApprovePending(); err != nil {
+			log.Printf("error approving CSRs of node %s: %v", a.nodeName, err)
+		}
+	}, interval, stopCh)
+}
+
+// ApprovePending approves all the pending CSRs that belong to the node and returns the number of CSRs approved
+func (a *Approver) ApprovePending() (int, error) {
+	csrs, err := a.client.CertificatesV1().CertificateSigningRequests().List(context.TODO(), meta.ListOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("error listing CSRs: %v", err)
+	}
+
+	approved := 0
+	for i := range csrs.Items {
+		csr := &csrs.Items[i]
+		if !isPending(csr) || !a.isNodeCSR(csr) {
+			continue
+		}
+		csr.Status.Conditions = append(csr.Status.Conditions, certificates.CertificateSigningRequestCondition{
+			Type:    certificates.CertificateApproved,
+			Status:  core.ConditionTrue,
+			Reason:  "WMCBTestApprove",
+			Message: "CSR approved for Windows node " + a.nodeName,
+		})
+		_, err = a.client.CertificatesV1().CertificateSigningRequests().UpdateApproval(context.TODO(), csr.Name, csr,
+			meta.UpdateOptions{})
+		if err != nil {
+			return approved, fmt.Errorf("error approving CSR %s: %v", csr.Name, err)
+		}
+		log.Printf("approved CSR %s of node %s", csr.Name, a.nodeName)
+		approved++
+	}
+	return approved, nil
+}
+
+// isNodeCSR returns true if the CSR is a kubelet client CSR created by the node-bootstrapper or a bootstrap token user,
+// or a kubelet serving CSR created by the node, for the node
+func (a *Approver) isNodeCSR(csr *certificates.CertificateSigningRequest) bool {
+	nodeUser := nodeUserPrefix + a.nodeName
+	switch csr.Spec.SignerName {
+	case certificates.KubeAPIServerClientKubeletSignerName:
+		if csr.Spec.Username != nodeBootstrapperUsername && csr.Spec.Username != nodeUser &&
+			!strings.HasPrefix(csr.Spec.Username, bootstrapTokenUserPrefix) {
+			return false
+		}
+	case certificates.KubeletServingSignerName:
+		if csr.Spec.Username != nodeUser {
+			return false
+		}
+	default:
+		return false
+	}
+
+	commonName, err := requestCommonName(csr.Spec.Request)
+	if err != nil {
+		log.Printf("ignoring CSR %s: %v", csr.Name, err)
+		return false
+	}
+	return commonName == nodeUser
+}
+
+// isPending returns true if the CSR has neither been approved nor denied
+func isPending(csr *certificates.CertificateSigningRequest) bool {
+	for _, condition := range csr.Status.Conditions {
+		if condition.Type == certificates.CertificateApproved || condition.Type == certificates.CertificateDenied {
+			return false
+		}
+	}
+	return true
+}
+
+// requestCommonName returns the common name of the subject of the given PEM encoded certificate request
+func requestCommonName(request []byte) (string, error) {
+	block, _ := pem.Decode(request)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return "", fmt.Errorf("request is not a PEM encoded certificate request")
+	}
+	certRequest, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("error parsing certificate request: %v", err)
+	}
+	return certRequest.Subject.CommonName, nil
+}
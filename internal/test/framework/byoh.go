@@ -0,0 +1,75 @@
+package framework
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/credentials"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/windows"
+)
+
+const (
+	// vmAddressEnv is the environment variable holding the address of an existing Windows instance the tests are run
+	// against instead of creating a Windows MachineSet, also known as bring your own host (BYOH)
+	vmAddressEnv = "WINDOWS_VM_ADDRESS"
+	// vmUsernameEnv is the environment variable holding the username used to access the existing Windows instance.
+	// credentials.Username is used if it is not set.
+	vmUsernameEnv = "WINDOWS_VM_USERNAME"
+)
+
+// firewallRule is an inbound firewall rule that needs to be present on a Windows instance for it to join the cluster
+type firewallRule struct {
+	// name is the display name of the rule
+	name string
+	// protocol is the protocol of the traffic allowed by the rule, either TCP or UDP
+	protocol string
+	// port is the local port the traffic is allowed on
+	port string
+}
+
+// byohFirewallRules are the firewall rules opened on an existing Windows instance. The MachineSet VMs get these through
+// their user data.
+var byohFirewallRules = []firewallRule{
+	// ContainerLogsPort matches the rule opened in the user data, which is removed when the node is uninstalled
+	{name: "ContainerLogsPort", protocol: "TCP", port: "10250"},
+	// the VXLAN port used by the hybrid overlay
+	{name: "HybridOverlayVXLAN", protocol: "UDP", port: "4789"},
+}
+
+// attachWindowsInstance returns the existing Windows instance at the given address, after checking that it can be
+// accessed and opening the firewall ports required for it to join the cluster. No MachineSet is created for the
+// instance, so it is not destroyed on TearDown.
+func (f *TestFramework) attachWindowsInstance(address, username string) ([]TestWindowsVM, error) {
+	if username == "" {
+		username = credentials.Username
+	}
+	log.Printf("attaching existing Windows instance %s", address)
+	winVM := &windows.Windows{}
+	// There is no cloud instance ID for an existing instance, so the address identifies it
+	winVM.Credentials = credentials.NewCredentials(address, address, username)
+	winVM.Credentials.SetSSHKey(f.Signer)
+	winVM.Credentials.SetPassword(os.Getenv(vmPasswordEnv))
+	winVM.Transport = windows.Transport(os.Getenv(vmTransportEnv))
+	if err := winVM.Connect(); err != nil {
+		return nil, fmt.Errorf("unable to connect to Windows instance %s: %v", address, err)
+	}
+	if err := openFirewallPorts(winVM); err != nil {
+		return nil, fmt.Errorf("unable to open firewall ports on Windows instance %s: %v", address, err)
+	}
+	return []TestWindowsVM{winVM}, nil
+}
+
+// openFirewallPorts creates the firewall rules required on the Windows instance, skipping the rules that are already
+// present
+func openFirewallPorts(winVM windows.WindowsVM) error {
+	for _, rule := range byohFirewallRules {
+		cmd := fmt.Sprintf("if (-not (Get-NetFirewallRule -DisplayName %s -ErrorAction SilentlyContinue)) "+
+			"{ New-NetFirewallRule -DisplayName %s -Direction Inbound -Action Allow -Protocol %s -LocalPort %s "+
+			"-EdgeTraversalPolicy Allow }", rule.name, rule.name, rule.protocol, rule.port)
+		if out, err := winVM.Run(cmd, true); err != nil {
+			return fmt.Errorf("error creating firewall rule %s: %v: %s", rule.name, err, out)
+		}
+	}
+	return nil
+}
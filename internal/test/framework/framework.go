@@ -25,6 +25,9 @@ import (
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	restclient "k8s.io/client-go/rest"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/credentials"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/machineapi"
 )
 
 const (
@@ -37,11 +40,24 @@ const (
 	// remoteLogPath is the directory where all the log files related to components that we need are generated on the
 	// Windows VM
 	remoteLogPath = "C:\\var\\log\\"
+	// remoteBootstrapStatusPath is the file WMCB records the progress of the bootstrap in on the Windows VM
+	remoteBootstrapStatusPath = "C:\\k\\bootstrap-status.json"
 	// PrivateKeyPath contains the path to the private key which is used to access the VMs. This would have been mounted
 	// as a secret by user
 	PrivateKeyPath = "/etc/private-key/private-key.pem"
+	// privateKeyPassphraseEnv is the environment variable holding the passphrase of the private key, if the private
+	// key is passphrase protected
+	privateKeyPassphraseEnv = "KUBE_SSH_KEY_PASSPHRASE"
+	// vmPasswordEnv is the environment variable holding the password used to access the VMs, in addition to the
+	// private key. This is optional.
+	vmPasswordEnv = "WINDOWS_VM_PASSWORD"
+	// vmTransportEnv is the environment variable holding the transport used to access the VMs, either ssh or winrm.
+	// ssh is used if it is not set. winrm requires the password to be set.
+	vmTransportEnv = "WINDOWS_VM_TRANSPORT"
 	// AWSCredentialsPath contains the path to the AWS credentials to interact with AWS cloud provider.
 	AWSCredentialsPath = "/etc/aws-creds/credentials"
+	// userDataSecretName is the name of the secret holding the user data the Windows VMs are created with
+	userDataSecretName = "windows-user-data"
 )
 
 var (
@@ -59,8 +75,6 @@ type TestFramework struct {
 	OSConfigClient *configclient.Clientset
 	// OSOperatorClient is the OpenShift operator client, we will use to interact with OpenShift operator objects
 	OSOperatorClient *operatorv1.OperatorV1Client
-	// noTeardown is an indicator that the user supplied the VMs and they should not be destroyed
-	noTeardown bool
 	// ClusterVersion is the major.minor.patch version of the OpenShift cluster
 	ClusterVersion string
 	// latestRelease is the latest release of the wmcb
@@ -74,8 +88,32 @@ type TestFramework struct {
 	Signer ssh.Signer
 	// client for interacting with machine objects
 	machineClient *machine.MachineV1beta1Client
-	// machineSet holds the MachineSet configuration used to destroy MachineSets
-	machineSet *mapi.MachineSet
+	// machineSets holds the MachineSets of the test run, used to destroy them
+	machineSets []*mapi.MachineSet
+	// machineAPI scales the existing Windows MachineSet given by the WINDOWS_MACHINESET environment variable, if set
+	machineAPI *machineapi.Provider
+	// StaleMachineSetAge is the age after which the MachineSets left behind by previous test runs are destroyed before
+	// the MachineSet of the test run is created. Stale MachineSets are not destroyed if it is not set.
+	StaleMachineSetAge time.Duration
+	// ReuseMachineSet reuses the MachineSet left behind by a previous test run with the required number of replicas
+	// rather than creating a new one, and leaves the MachineSet of the test run behind on tear down so that it can be
+	// reused by the next test run. This saves provisioning the Windows VMs when iterating on the tests.
+	ReuseMachineSet bool
+	// SpreadZones spreads the Windows VMs round-robin across the zones of the Linux worker MachineSets of the cluster,
+	// creating a MachineSet per zone, instead of creating them all in a single zone. The cloud provider must support
+	// it. MachineSets are not reused when it is set.
+	SpreadZones bool
+	// RemediateNetwork adds the rules missing for the nodes of the cluster to reach the Windows VMs to the network of
+	// the cluster, instead of failing the set up of the Windows VMs
+	RemediateNetwork bool
+	// WindowsVersion is the Windows Server version of the images the Windows VMs are created with. The version given
+	// by the WINDOWS_SERVER_VERSION environment variable, or 2019, is used if it is not set.
+	WindowsVersion string
+	// Resources tracks the resources created by the framework, which are destroyed on TearDown. Resources tracking the
+	// resources of a single test run is created by Setup if it is not set.
+	Resources *Resources
+	// leases holds the Windows VMs that are not leased to a test
+	leases chan TestWindowsVM
 }
 
 // Setup creates and initializes a variable amount of Windows VMs. If the array of credentials are passed then it will
@@ -84,6 +122,9 @@ type TestFramework struct {
 func (f *TestFramework) Setup(vmCount int, skipVMSetup bool) error {
 	// register the MachineSet to scheme so as to create machine sets
 	mapi.AddToScheme(scheme.Scheme)
+	if f.Resources == nil {
+		f.Resources = NewResources(false)
+	}
 
 	// initialize the artifacts directory variable
 	artifactDir = os.Getenv("ARTIFACT_DIR")
@@ -115,14 +156,31 @@ func (f *TestFramework) Setup(vmCount int, skipVMSetup bool) error {
 		return fmt.Errorf("unable to create ssh signer: %v", err)
 	}
 
+	// an existing Windows instance is used in lieu of creating a MachineSet if its address is given
+	if address := os.Getenv(vmAddressEnv); address != "" {
+		f.WinVMs, err = f.attachWindowsInstance(address, os.Getenv(vmUsernameEnv))
+		if err != nil {
+			return fmt.Errorf("unable to attach windows instance: %v", err)
+		}
+		f.resetLeases()
+		return nil
+	}
+
 	if err := f.createUserDataSecret(); err != nil {
 		return fmt.Errorf("unable to create user data secret: %v", err)
 	}
+	// an existing Windows MachineSet is scaled in lieu of creating a MachineSet if its name is given
+	if os.Getenv(windowsMachineSetEnv) != "" {
+		if err := f.getMachineAPIProvider(config); err != nil {
+			return fmt.Errorf("unable to get the Machine API provider: %v", err)
+		}
+	}
 
 	f.WinVMs, err = f.newWindowsMachineSet(vmCount, skipVMSetup)
 	if err != nil {
 		return fmt.Errorf("unable to create windows vm %v", err)
 	}
+	f.resetLeases()
 	return nil
 }
 
@@ -136,6 +194,16 @@ func (f *TestFramework) getMachineAPIClient(config *restclient.Config) error {
 	return nil
 }
 
+// getMachineAPIProvider sets up the provider scaling the existing Windows MachineSet through the Machine API
+func (f *TestFramework) getMachineAPIProvider(config *restclient.Config) error {
+	cloudProvider, err := getCloudProvider(f.windowsVersion())
+	if err != nil {
+		return err
+	}
+	f.machineAPI, err = machineapi.NewProvider(config, cloudProvider)
+	return err
+}
+
 // getKubeClient setups the kubeclient that can be used across all the test suites.
 func (f *TestFramework) getKubeClient(config *restclient.Config) error {
 	clientset, err := kubernetes.NewForConfig(config)
@@ -323,6 +391,11 @@ func (f *TestFramework) WriteToArtifactDir(contents []byte, subDirName, filename
 	return ioutil.WriteFile(path, contents, os.ModePerm)
 }
 
+// ArtifactPath returns the path of the given elements in the artifact directory
+func (f *TestFramework) ArtifactPath(elem ...string) string {
+	return filepath.Join(append([]string{artifactDir}, elem...)...)
+}
+
 // GetNode uses internal IP and finds out the name associated with the node
 func (f *TestFramework) GetNodeName(internalIP string) (string, error) {
 	node, err := f.GetNode(internalIP)
@@ -375,9 +448,12 @@ func (f *TestFramework) RetrieveArtifacts() {
 		// TODO: Make this a map["'"artifact_that_we_want_to_pull"]="log_file.name" to only capture
 		//  the logs we are interested in, to avoid capturing every directory in c:\\k\\log
 		// Retrieve directories copies the directories from remote VM to the Artifacts directory
-		if err := vm.RetrieveDirectories(remoteLogPath, localKubeletLogPath); err != nil {
+		if err := vm.RetrieveDirectory(remoteLogPath, localKubeletLogPath); err != nil {
 			log.Printf("failed retrieving log directories on vm %s: %v", instanceID, err)
-			continue
+		}
+		// The bootstrap status records the phase the bootstrap failed in
+		if err := vm.RetrieveFile(remoteBootstrapStatusPath, filepath.Dir(localKubeletLogPath)); err != nil {
+			log.Printf("failed retrieving bootstrap status on vm %s: %v", instanceID, err)
 		}
 	}
 }
@@ -425,16 +501,24 @@ func (f *TestFramework) waitUntilOVNPodsReady() error {
 
 }
 
-// TearDown destroys the resources created by the Setup function
+// TearDown destroys the resources created by the Setup function, unless they are kept to be reused by the next test
+// run or for debugging
 func (f *TestFramework) TearDown() {
-	if f.noTeardown || f.WinVMs == nil {
+	if f.Resources == nil {
 		return
 	}
-
-	if err := f.DestroyMachineSet(); err != nil {
-		log.Printf("failed to delete MachineSets with error: %v", err)
+	// The existing MachineSet scaled through the Machine API is always scaled back
+	if f.ReuseMachineSet && !f.SpreadZones && len(f.machineSets) > 0 && f.machineAPI == nil {
+		for _, machineSet := range f.machineSets {
+			log.Printf("Keeping MachineSet %s to be reused by the next test run", machineSet.Name)
+			f.Resources.Untrack(machineSetResource, machineSet.Name)
+		}
+		// The VMs of the MachineSet are created with the user data secret
+		f.Resources.Untrack(secretResource, userDataSecretName)
+	}
+	if err := f.Resources.DestroyAll(); err != nil {
+		log.Printf("failed to destroy the resources of the test run: %v", err)
 	}
-	return
 }
 
 // k8sVersionToOpenShiftVersion converts a Kubernetes minor version to an OpenShift version in format
@@ -457,14 +541,9 @@ func k8sVersionToOpenShiftVersion(k8sMinorVersion int) (string, error) {
 
 // createSigner creates a signer using the private key from the PrivateKeyPath
 func (f *TestFramework) createSigner() error {
-	privateKeyBytes, err := ioutil.ReadFile(PrivateKeyPath)
-	if err != nil {
-		return fmt.Errorf("failed to find private key from path: %v, err: %v", PrivateKeyPath, err)
-	}
-
-	signer, err := ssh.ParsePrivateKey(privateKeyBytes)
+	signer, err := credentials.LoadSigner(PrivateKeyPath, os.Getenv(privateKeyPassphraseEnv))
 	if err != nil {
-		return fmt.Errorf("unable to parse private key: %v, err: %v", err, PrivateKeyPath)
+		return err
 	}
 	f.Signer = signer
 	return nil
@@ -472,7 +551,7 @@ func (f *TestFramework) createSigner() error {
 
 // createUserDataSecret creates a secret 'windows-user-data' in 'openshift-machine-api'
 // namespace. This secret will be used to inject cloud provider user data for creating
-// windows machines
+// windows machines, which enables ssh and WinRM on the machines. The secret is destroyed on TearDown if it is created.
 func (f *TestFramework) createUserDataSecret() error {
 	if f.Signer == nil {
 		return fmt.Errorf("failed to retrieve signer for private key: %v", PrivateKeyPath)
@@ -483,43 +562,22 @@ func (f *TestFramework) createUserDataSecret() error {
 		return fmt.Errorf("failed to retrieve public key using signer for private key: %v", PrivateKeyPath)
 	}
 
-	// sshd service is started to create the default sshd_config file. This file is modified
-	// for enabling publicKey auth and the service is restarted for the changes to take effect.
+	cloudProvider, err := getCloudProvider(f.windowsVersion())
+	if err != nil {
+		return err
+	}
 	userDataSecret := &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "windows-user-data",
+			Name:      userDataSecretName,
 			Namespace: "openshift-machine-api",
 		},
 		Data: map[string][]byte{
-			"userData": []byte(`<powershell>
-			Add-WindowsCapability -Online -Name OpenSSH.Server~~~~0.0.1.0
-			$firewallRuleName = "ContainerLogsPort"
-			$containerLogsPort = "10250"
-			New-NetFirewallRule -DisplayName $firewallRuleName -Direction Inbound -Action Allow -Protocol TCP -LocalPort $containerLogsPort -EdgeTraversalPolicy Allow
-			Install-PackageProvider -Name NuGet -MinimumVersion 2.8.5.201 -Force
-			Install-Module -Force OpenSSHUtils
-			Set-Service -Name ssh-agent -StartupType ‘Automatic’
-			Set-Service -Name sshd -StartupType ‘Automatic’
-			Start-Service ssh-agent
-			Start-Service sshd
-			$pubKeyConf = (Get-Content -path C:\ProgramData\ssh\sshd_config) -replace '#PubkeyAuthentication yes','PubkeyAuthentication yes'
-			$pubKeyConf | Set-Content -Path C:\ProgramData\ssh\sshd_config
- 			$passwordConf = (Get-Content -path C:\ProgramData\ssh\sshd_config) -replace '#PasswordAuthentication yes','PasswordAuthentication yes'
-			$passwordConf | Set-Content -Path C:\ProgramData\ssh\sshd_config
-			$authFileConf = (Get-Content -path C:\ProgramData\ssh\sshd_config) -replace 'AuthorizedKeysFile __PROGRAMDATA__/ssh/administrators_authorized_keys','#AuthorizedKeysFile __PROGRAMDATA__/ssh/administrators_authorized_keys'
-			$authFileConf | Set-Content -Path C:\ProgramData\ssh\sshd_config
-			$pubKeyLocationConf = (Get-Content -path C:\ProgramData\ssh\sshd_config) -replace 'Match Group administrators','#Match Group administrators'
-			$pubKeyLocationConf | Set-Content -Path C:\ProgramData\ssh\sshd_config
-			Restart-Service sshd
-			New-item -Path $env:USERPROFILE -Name .ssh -ItemType Directory -force
-			echo "` + string(pubKeyBytes[:]) + `"| Out-File $env:USERPROFILE\.ssh\authorized_keys -Encoding ascii
-			</powershell>
-			<persist>true</persist>`),
+			"userData": cloudProvider.GenerateUserData(string(pubKeyBytes)),
 		},
 	}
 
 	// check if the userDataSecret already exists
-	_, err := f.K8sclientset.CoreV1().Secrets(userDataSecret.Namespace).Get(context.TODO(), userDataSecret.Name, metav1.GetOptions{})
+	_, err = f.K8sclientset.CoreV1().Secrets(userDataSecret.Namespace).Get(context.TODO(), userDataSecret.Name, metav1.GetOptions{})
 	if err != nil {
 		if k8sapierrors.IsNotFound(err) {
 			log.Print("Creating a new Secret", "Secret.Namespace", userDataSecret.Namespace, "Secret.Name", userDataSecret.Name)
@@ -527,6 +585,14 @@ func (f *TestFramework) createUserDataSecret() error {
 			if err != nil {
 				return fmt.Errorf("error creating windows user data secret: %v", err)
 			}
+			f.Resources.Track(secretResource, userDataSecret.Name, func() error {
+				err := f.K8sclientset.CoreV1().Secrets(userDataSecret.Namespace).Delete(context.TODO(),
+					userDataSecret.Name, metav1.DeleteOptions{})
+				if err != nil && !k8sapierrors.IsNotFound(err) {
+					return err
+				}
+				return nil
+			})
 			return nil
 		}
 		return fmt.Errorf("error creating windows user data secret: %v", err)
@@ -0,0 +1,148 @@
+package framework
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	// machineSetResource is the kind of the MachineSets tracked by Resources
+	machineSetResource = "MachineSet"
+	// secretResource is the kind of the secrets tracked by Resources
+	secretResource = "Secret"
+	// timeoutMargin is the time before the timeout of the test binary at which the tracked resources are destroyed, as
+	// the test binary exits without running the clean up of the tests once the timeout expires
+	timeoutMargin = 2 * time.Minute
+)
+
+// resource is a resource created by the test framework
+type resource struct {
+	// kind is the kind of the resource, like MachineSet
+	kind string
+	// name is the name of the resource
+	name string
+	// destroy destroys the resource
+	destroy func() error
+}
+
+// Resources tracks the resources created by the test framework along with how to destroy them, so that they are
+// destroyed on tear down whatever the outcome of the tests, including when a test panics, times out or the test binary
+// is interrupted. The resources are destroyed in the reverse order they were created in.
+type Resources struct {
+	// skipCleanup keeps the resources rather than destroying them, which helps debugging failed test runs. The kept
+	// resources are logged.
+	skipCleanup bool
+	// lock guards resources
+	lock sync.Mutex
+	// resources are the tracked resources, in the order they were created in
+	resources []resource
+}
+
+// NewResources returns an empty resource tracker, which keeps the resources rather than destroying them if
+// skipCleanup is true
+func NewResources(skipCleanup bool) *Resources {
+	return &Resources{skipCleanup: skipCleanup}
+}
+
+// Track tracks the resource of the given kind and name, which the given function destroys
+func (r *Resources) Track(kind, name string, destroy func() error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.resources = append(r.resources, resource{kind: kind, name: name, destroy: destroy})
+}
+
+// Untrack stops tracking the resource of the given kind and name, which is then neither destroyed nor kept by the
+// tracker. It returns false if the resource was not tracked.
+func (r *Resources) Untrack(kind, name string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, res := range r.resources {
+		if res.kind == kind && res.name == name {
+			r.resources = append(r.resources[:i], r.resources[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// DestroyAll destroys all the tracked resources, or logs them if they are kept, and stops tracking them. Destroying
+// the remaining resources is attempted if one of them cannot be destroyed, and the errors are returned together.
+func (r *Resources) DestroyAll() error {
+	r.lock.Lock()
+	resources := r.resources
+	r.resources = nil
+	r.lock.Unlock()
+
+	var errs []error
+	for i := len(resources) - 1; i >= 0; i-- {
+		res := resources[i]
+		if r.skipCleanup {
+			log.Printf("Skipping clean up, %s %s needs to be destroyed manually", res.kind, res.name)
+			continue
+		}
+		log.Printf("Destroying %s %s", res.kind, res.name)
+		if err := res.destroy(); err != nil {
+			errs = append(errs, fmt.Errorf("unable to destroy %s %s: %v", res.kind, res.name, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// DestroyOnExit destroys the tracked resources when the test binary is interrupted, before exiting, and shortly before
+// the given timeout of the test binary expires, as the test binary exits without running the clean up of the tests in
+// both cases. There is no timeout if it is 0.
+func (r *Resources) DestroyOnExit(timeout time.Duration) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		log.Printf("Received %v, destroying the resources of the test run", sig)
+		if err := r.DestroyAll(); err != nil {
+			log.Printf("failed to destroy the resources of the test run: %v", err)
+		}
+		os.Exit(1)
+	}()
+
+	if timeout <= 0 {
+		return
+	}
+	margin := timeoutMargin
+	if margin > timeout/2 {
+		margin = timeout / 2
+	}
+	time.AfterFunc(timeout-margin, func() {
+		log.Printf("Test run is about to time out, destroying its resources")
+		if err := r.DestroyAll(); err != nil {
+			log.Printf("failed to destroy the resources of the test run: %v", err)
+		}
+	})
+}
+
+// LeaseVM leases one of the Windows VMs of the framework to the given test until the test and its subtests complete,
+// so that no other test uses the VM meanwhile. It waits for a VM to be released if they are all leased, and fails the
+// test if the framework has no Windows VMs.
+func (f *TestFramework) LeaseVM(t testing.TB) TestWindowsVM {
+	t.Helper()
+	if len(f.WinVMs) == 0 {
+		t.Fatal("no Windows VMs to lease")
+	}
+	vm := <-f.leases
+	t.Cleanup(func() { f.leases <- vm })
+	return vm
+}
+
+// resetLeases makes all the Windows VMs of the framework available to be leased
+func (f *TestFramework) resetLeases() {
+	f.leases = make(chan TestWindowsVM, len(f.WinVMs))
+	for _, vm := range f.WinVMs {
+		f.leases <- vm
+	}
+}
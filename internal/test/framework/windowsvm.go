@@ -2,60 +2,210 @@ package framework
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	mapi "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	"golang.org/x/crypto/ssh"
 	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 
 	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/credentials"
 	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/machineapi"
 	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/windows"
 )
 
 const (
 	// sshKey is the key that will be used to access created Windows VMs
 	sshKey = "openshift-dev"
+	// maxParallelVMSetup is the maximum number of VMs that are set up concurrently
+	maxParallelVMSetup = 5
+	// e2eMachineSetLabel is the label applied to the MachineSets created by the test framework, so that the ones
+	// left behind by failed test runs can be found and garbage collected
+	e2eMachineSetLabel = "windows-machine-config-bootstrapper.openshift.io/e2e"
+	// e2eWindowsVersionLabel is the label holding the Windows Server version of the VMs of the MachineSets created by
+	// the test framework
+	e2eWindowsVersionLabel = "windows-machine-config-bootstrapper.openshift.io/windows-version"
+	// vmReadinessTimeout is the time a provisioned VM has to boot and accept connections
+	vmReadinessTimeout = 15 * time.Minute
+	// vmReadinessInterval is the interval at which the readiness of a VM is checked
+	vmReadinessInterval = 10 * time.Second
+	// windowsMachineSetEnv is the environment variable holding the name of an existing Windows MachineSet that is
+	// scaled through the Machine API to provide the Windows VMs, instead of creating a MachineSet
+	windowsMachineSetEnv = "WINDOWS_MACHINESET"
 )
 
 // cloudProvider holds the information related to cloud provider
 // TODO: Move this to proper location which can destroy the VM that got created.
-//		https://issues.redhat.com/browse/WINC-245
+//
+//	https://issues.redhat.com/browse/WINC-245
 var cloudProvider providers.CloudProvider
 
+// cloudProviderWindowsVersion is the Windows Server version the VMs created by cloudProvider run
+var cloudProviderWindowsVersion string
+
 // TestWindowsVM is the interface for interacting with a Windows VM in the test framework. This will hold the
 // specialized information related to test suite
 type TestWindowsVM interface {
-	// RetrieveDirectories recursively copies the files and directories from the directory in the remote Windows VM
-	// to the given directory on the local host.
-	RetrieveDirectories(string, string) error
 	// Compose the Windows VM we have from MachineSets
 	windows.WindowsVM
 }
 
+// spotCapacityError is returned when a machine of the MachineSet of the test run failed because its spot instance
+// could not be created
+type spotCapacityError struct {
+	// machine is the name of the machine
+	machine string
+	// message is the error message of the machine
+	message string
+}
+
+func (e *spotCapacityError) Error() string {
+	return fmt.Sprintf("spot instance of machine %s could not be created: %s", e.machine, e.message)
+}
+
+// getCloudProvider returns the cloud provider of the cluster creating VMs running the given Windows Server version.
+// The cloud provider is kept across the MachineSets of a Windows Server version, as it tracks the spot instance
+// fallbacks.
+func getCloudProvider(windowsVersion string) (providers.CloudProvider, error) {
+	if cloudProvider == nil || cloudProviderWindowsVersion != windowsVersion {
+		provider, err := providers.NewCloudProvider(sshKey, windowsVersion)
+		if err != nil {
+			return nil, fmt.Errorf("error instantiating cloud provider %v", err)
+		}
+		cloudProvider = provider
+		cloudProviderWindowsVersion = windowsVersion
+	}
+	return cloudProvider, nil
+}
+
 // createMachineSet() gets the generated MachineSet configuration from cloudprovider package and creates a MachineSet
-func (f *TestFramework) createMachineSet() error {
-	cloudProvider, err := providers.NewCloudProvider(sshKey)
+// with the given number of replicas. If SpreadZones is set, a MachineSet is created per zone instead, with the replicas
+// spread round-robin across the zones.
+func (f *TestFramework) createMachineSet(replicas int) error {
+	cloudProvider, err := getCloudProvider(f.windowsVersion())
 	if err != nil {
-		return fmt.Errorf("error instantiating cloud provider %v", err)
+		return err
 	}
-	machineSet, err := cloudProvider.GenerateMachineSet(true, 1)
-	if err != nil {
-		return fmt.Errorf("error generating Windows MachineSet: %v", err)
+	var machineSets []*mapi.MachineSet
+	if f.SpreadZones {
+		zonalProvider, ok := cloudProvider.(providers.ZonalCloudProvider)
+		if !ok {
+			return fmt.Errorf("spreading the Windows VMs across zones is not supported by the cloud provider")
+		}
+		zonalMachineSets, err := zonalProvider.GenerateZonalMachineSets(true)
+		if err != nil {
+			return fmt.Errorf("error generating Windows MachineSets: %v", err)
+		}
+		machineSets = providers.SpreadReplicas(zonalMachineSets, int32(replicas))
+	} else {
+		machineSet, err := cloudProvider.GenerateMachineSet(true, int32(replicas))
+		if err != nil {
+			return fmt.Errorf("error generating Windows MachineSet: %v", err)
+		}
+		machineSets = []*mapi.MachineSet{machineSet}
 	}
 	log.Print("Creating Machine Sets")
-	_, err = f.machineClient.MachineSets("openshift-machine-api").Create(context.TODO(), machineSet, metav1.CreateOptions{})
+	for _, machineSet := range machineSets {
+		if machineSet.Labels == nil {
+			machineSet.Labels = make(map[string]string)
+		}
+		machineSet.Labels[e2eMachineSetLabel] = "true"
+		machineSet.Labels[e2eWindowsVersionLabel] = f.windowsVersion()
+		_, err = machineapi.CreateMachineSet(context.TODO(), f.machineClient.MachineSets("openshift-machine-api"),
+			machineSet)
+		if err != nil {
+			return fmt.Errorf("error creating MachineSet %v", err)
+		}
+		f.machineSets = append(f.machineSets, machineSet)
+		name := machineSet.Name
+		f.Resources.Track(machineSetResource, name, func() error {
+			return f.deleteMachineSet(name)
+		})
+		log.Printf("Created Machine Set %v", name)
+	}
+	return nil
+}
+
+// scaleMachineSet scales the existing Windows MachineSet with the given name to the given number of replicas through
+// the Machine API, and uses its Machines as the VMs of the test run. The MachineSet is scaled back to its previous
+// number of replicas on TearDown rather than destroyed.
+func (f *TestFramework) scaleMachineSet(name string, replicas int) error {
+	machineSet, previous, err := f.machineAPI.ScaleWindowsMachineSet(context.TODO(), name, int32(replicas))
+	if err != nil {
+		return err
+	}
+	f.machineSets = []*mapi.MachineSet{machineSet}
+	f.Resources.Track(machineSetResource, machineSet.Name, func() error {
+		_, _, err := f.machineAPI.ScaleWindowsMachineSet(context.TODO(), machineSet.Name, previous)
+		return err
+	})
+	return nil
+}
+
+// validateNetwork checks that the nodes of the cluster can reach the Windows VMs, if the cloud provider supports it,
+// adding the missing rules if the RemediateNetwork option is set
+func (f *TestFramework) validateNetwork() error {
+	cloudProvider, err := getCloudProvider(f.windowsVersion())
+	if err != nil {
+		return err
+	}
+	networkProvider, ok := cloudProvider.(providers.NetworkCloudProvider)
+	if !ok {
+		return nil
+	}
+	winRM := windows.Transport(os.Getenv(vmTransportEnv)) == windows.WinRMTransport
+	if err = networkProvider.ValidateNetwork(winRM, f.RemediateNetwork); err != nil {
+		return fmt.Errorf("error validating the network of the cluster: %v", err)
+	}
+	return nil
+}
+
+// findReusableMachineSet sets the MachineSet of the test run to the most recent MachineSet left behind by a previous
+// test run with the given number of replicas, if any. The Windows VMs of the MachineSet are set up again by the tests.
+func (f *TestFramework) findReusableMachineSet(replicas int) error {
+	machineSets, err := f.ListE2EMachineSets()
 	if err != nil {
-		return fmt.Errorf("error creating MachineSet %v", err)
+		return err
 	}
-	f.machineSet = machineSet
-	log.Printf("Created Machine Set %v", machineSet.Name)
+	var reused *mapi.MachineSet
+	for i, machineSet := range machineSets {
+		if machineSet.DeletionTimestamp != nil || machineSet.Spec.Replicas == nil ||
+			int(*machineSet.Spec.Replicas) != replicas ||
+			machineSet.Labels[e2eWindowsVersionLabel] != f.windowsVersion() {
+			continue
+		}
+		if reused == nil || reused.CreationTimestamp.Before(&machineSet.CreationTimestamp) {
+			reused = &machineSets[i]
+		}
+	}
+	if reused == nil {
+		log.Printf("No MachineSet with %d replicas to reuse", replicas)
+		return nil
+	}
+	f.machineSets = []*mapi.MachineSet{reused}
+	log.Printf("Reusing MachineSet %s created %v ago", reused.Name,
+		time.Since(reused.CreationTimestamp.Time).Round(time.Second))
 	return nil
 }
 
+// ownsMachine returns true if the given machine belongs to one of the MachineSets of the test run
+func (f *TestFramework) ownsMachine(machine mapi.Machine) bool {
+	for _, machineSet := range f.machineSets {
+		if machineapi.OwnedBy(machine, machineSet.Name) {
+			return true
+		}
+	}
+	return false
+}
+
 // getWindowsMachines() waits until all the machines required are in Provisioned state. It returns an array of all
 // the machines created. All the machines are created concurrently.
 func (f *TestFramework) getWindowsMachines(vmCount int, skipVMSetup bool) ([]mapi.Machine, error) {
@@ -69,8 +219,13 @@ func (f *TestFramework) getWindowsMachines(vmCount int, skipVMSetup bool) ([]map
 	}
 	startTime := time.Now()
 	for i := 0; time.Since(startTime) <= timeOut; i++ {
-		allMachines := &mapi.MachineList{}
-		allMachines, err := f.machineClient.Machines("openshift-machine-api").List(context.TODO(), metav1.ListOptions{LabelSelector: windowsOSLabel})
+		var allMachines *mapi.MachineList
+		err := windows.KubeAPIRetryPolicy.Do(context.TODO(), "listing Machines", func() error {
+			var err error
+			allMachines, err = f.machineClient.Machines("openshift-machine-api").List(context.TODO(),
+				metav1.ListOptions{LabelSelector: windowsOSLabel})
+			return err
+		}, nil)
 		if err != nil {
 			return nil, fmt.Errorf("failed to list machines: %v", err)
 		}
@@ -79,10 +234,21 @@ func (f *TestFramework) getWindowsMachines(vmCount int, skipVMSetup bool) ([]map
 		phaseProvisioned := "Provisioned"
 
 		for _, machine := range allMachines.Items {
+			// The machines of the MachineSets of other Windows Server versions are not the ones of the test run
+			if len(f.machineSets) > 0 && !f.ownsMachine(machine) {
+				continue
+			}
 			instanceStatus := machine.Status
 			if instanceStatus.Phase != nil && *instanceStatus.Phase == phaseProvisioned {
 				provisionedMachines = append(provisionedMachines, machine)
 			}
+			if err := f.checkSpotCapacity(machine); err != nil {
+				return nil, err
+			}
+		}
+		// The machines of a reused MachineSet are already provisioned
+		if !skipVMSetup && len(provisionedMachines) == vmCount {
+			break
 		}
 		time.Sleep(5 * time.Second)
 	}
@@ -98,21 +264,87 @@ func (f *TestFramework) getWindowsMachines(vmCount int, skipVMSetup bool) ([]map
 	return nil, fmt.Errorf("expected VM count %d but got %d", vmCount, len(provisionedMachines))
 }
 
+// checkSpotCapacity returns a spotCapacityError if the given machine belongs to a MachineSet of the test run and
+// failed because its spot instance could not be created
+func (f *TestFramework) checkSpotCapacity(machine mapi.Machine) error {
+	spotProvider, ok := cloudProvider.(providers.SpotCloudProvider)
+	if !ok || machine.Status.ErrorMessage == nil || !spotProvider.IsSpotCapacityError(*machine.Status.ErrorMessage) {
+		return nil
+	}
+	if f.ownsMachine(machine) {
+		return &spotCapacityError{machine: machine.Name, message: *machine.Status.ErrorMessage}
+	}
+	return nil
+}
+
+// windowsVersion returns the Windows Server version the VMs of the test run are created with
+func (f *TestFramework) windowsVersion() string {
+	if f.WindowsVersion == "" {
+		return providers.DefaultWindowsVersion()
+	}
+	return f.WindowsVersion
+}
+
+// waitForWindowsMachines waits until the machines required are in Provisioned state and returns them. If the spot
+// instances of the MachineSets cannot be created, the MachineSets are recreated as per the fallback of the cloud
+// provider, until the machines are provisioned or there is nothing left to fall back to.
+func (f *TestFramework) waitForWindowsMachines(vmCount int, skipVMSetup bool) ([]mapi.Machine, error) {
+	for {
+		machines, err := f.getWindowsMachines(vmCount, skipVMSetup)
+		var spotErr *spotCapacityError
+		if !errors.As(err, &spotErr) {
+			return machines, err
+		}
+		// Spot capacity errors are only returned by spot cloud providers. The existing MachineSet scaled through the
+		// Machine API is not recreated.
+		if f.machineAPI != nil || !cloudProvider.(providers.SpotCloudProvider).FallBack() {
+			return nil, err
+		}
+		log.Printf("%v, recreating the Windows MachineSets", err)
+		if err = f.DestroyMachineSet(); err != nil {
+			return nil, err
+		}
+		if err = f.createMachineSet(vmCount); err != nil {
+			return nil, fmt.Errorf("error creating Windows MachineSet: %v", err)
+		}
+	}
+}
+
 // newWindowsMachineSet creates and sets up Windows VMs in the cloud and returns the WindowsVM interface that can be used to
 // interact with the VM. If no error is returned then it is guaranteed that the VM was
 // created and can be interacted with.
 func (f *TestFramework) newWindowsMachineSet(vmCount int, skipVMSetup bool) ([]TestWindowsVM, error) {
-	w := make([]TestWindowsVM, vmCount)
 	if skipVMSetup {
 		log.Print("Skip VM setup option selected. Not setting up the VMs...")
 	} else {
-		err := f.createMachineSet()
-		if err != nil {
-			return nil, fmt.Errorf("error creating Windows MachineSet: %v", err)
+		if err := f.validateNetwork(); err != nil {
+			return nil, err
+		}
+		if f.machineAPI != nil {
+			if err := f.scaleMachineSet(os.Getenv(windowsMachineSetEnv), vmCount); err != nil {
+				return nil, fmt.Errorf("error scaling Windows MachineSet: %v", err)
+			}
+		}
+		if f.ReuseMachineSet && !f.SpreadZones && len(f.machineSets) == 0 {
+			if err := f.findReusableMachineSet(vmCount); err != nil {
+				return nil, err
+			}
+		}
+		// The reused MachineSet is never destroyed as stale
+		if f.StaleMachineSetAge > 0 && f.machineAPI == nil {
+			if err := f.DestroyStaleMachineSets(f.StaleMachineSetAge); err != nil {
+				return nil, fmt.Errorf("error destroying stale MachineSets: %v", err)
+			}
+		}
+		if len(f.machineSets) == 0 {
+			err := f.createMachineSet(vmCount)
+			if err != nil {
+				return nil, fmt.Errorf("error creating Windows MachineSet: %v", err)
+			}
 		}
 	}
 
-	provisionedMachines, err := f.getWindowsMachines(vmCount, skipVMSetup)
+	provisionedMachines, err := f.waitForWindowsMachines(vmCount, skipVMSetup)
 	if err != nil {
 		log.Print("unable to provision MachineSets. Trying to delete created MachineSets")
 		if skipVMSetup {
@@ -126,55 +358,182 @@ func (f *TestFramework) newWindowsMachineSet(vmCount int, skipVMSetup bool) ([]T
 		return nil, err
 	}
 
+	// The machines are set up concurrently, as connecting to a machine can take minutes. The remaining set ups are
+	// cancelled once one fails, as the MachineSet is unusable anyway.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := make([]TestWindowsVM, len(provisionedMachines))
+	var setupErrs []error
+	var errLock sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxParallelVMSetup)
 	for i, machine := range provisionedMachines {
-		winVM := &windows.Windows{}
-
-		ipAddress := ""
-		for _, address := range machine.Status.Addresses {
-			if address.Type == core.NodeInternalIP {
-				ipAddress = address.Address
+		wg.Add(1)
+		go func(i int, machine mapi.Machine) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			winVM, err := newWindowsVM(ctx, machine, f.Signer)
+			if err != nil {
+				cancel()
+				errLock.Lock()
+				setupErrs = append(setupErrs, fmt.Errorf("machine %s: %v", machine.Name, err))
+				errLock.Unlock()
+				return
 			}
-		}
-		if len(ipAddress) == 0 {
-			return nil, fmt.Errorf("no associated internal ip for machine: %s", machine.Name)
-		}
+			w[i] = winVM
+		}(i, machine)
+	}
+	wg.Wait()
+	if len(setupErrs) > 0 {
+		return nil, utilerrors.NewAggregate(setupErrs)
+	}
+	return w, nil
+}
 
-		// Get the instance ID associated with the Windows machine.
-		providerID := *machine.Spec.ProviderID
-		if len(providerID) == 0 {
-			return nil, fmt.Errorf("no provider id associated with machine")
-		}
-		// Ex: aws:///us-east-1e/i-078285fdadccb2eaa. We always want the last entry which is the instanceID
-		providerTokens := strings.Split(providerID, "/")
-		instanceID := providerTokens[len(providerTokens)-1]
-		if len(instanceID) == 0 {
-			return nil, fmt.Errorf("empty instance id in provider id")
+// newWindowsVM returns the WindowsVM interface that can be used to interact with the VM of the given machine, after
+// waiting for it to boot, as reported by the cloud provider, and connecting to it using the given signer. Waiting is
+// aborted once the context is done or after vmReadinessTimeout.
+func newWindowsVM(ctx context.Context, machine mapi.Machine, signer ssh.Signer) (TestWindowsVM, error) {
+	winVM := &windows.Windows{}
+
+	ipAddress := ""
+	for _, address := range machine.Status.Addresses {
+		if address.Type == core.NodeInternalIP {
+			ipAddress = address.Address
 		}
-		creds := credentials.NewCredentials(instanceID, ipAddress, credentials.Username)
-		winVM.Credentials = creds
-		log.Print("setting up ssh")
-		log.Print("using the mounted private key to access the VMs through ssh")
-		winVM.Credentials.SetSSHKey(f.Signer)
-		if err := winVM.GetSSHClient(); err != nil {
-			return nil, fmt.Errorf("unable to get ssh client for vm %s : %v", instanceID, err)
+	}
+	if len(ipAddress) == 0 {
+		return nil, fmt.Errorf("no associated internal ip for machine: %s", machine.Name)
+	}
+
+	// Get the instance ID associated with the Windows machine.
+	providerID := *machine.Spec.ProviderID
+	if len(providerID) == 0 {
+		return nil, fmt.Errorf("no provider id associated with machine")
+	}
+	instanceID := machineapi.InstanceID(providerID)
+	if len(instanceID) == 0 {
+		return nil, fmt.Errorf("empty instance id in provider id")
+	}
+	username := credentials.Username
+	if strings.HasPrefix(providerID, "azure://") {
+		username = credentials.AzureUsername
+	}
+	creds := credentials.NewCredentials(instanceID, ipAddress, username)
+	winVM.Credentials = creds
+	log.Printf("setting up ssh for %s", instanceID)
+	log.Print("using the mounted private key to access the VMs through ssh")
+	winVM.Credentials.SetSSHKey(signer)
+	winVM.Credentials.SetPassword(os.Getenv(vmPasswordEnv))
+	winVM.Transport = windows.Transport(os.Getenv(vmTransportEnv))
+
+	if rebootProvider, ok := cloudProvider.(providers.RebootCloudProvider); ok {
+		winVM.RebootFunc = func(ctx context.Context) error {
+			return rebootProvider.Reboot(ctx, instanceID)
 		}
-		w[i] = winVM
 	}
-	return w, nil
+
+	var probes []windows.ReadinessProbe
+	if readinessProvider, ok := cloudProvider.(providers.ReadinessCloudProvider); ok {
+		probes = append(probes, readinessProvider.ReadinessProbes(instanceID)...)
+	}
+	// The transport probe connects to the VM
+	probes = append(probes, winVM.PortProbe(), winVM.TransportProbe())
+	ctx, cancel := context.WithTimeout(ctx, vmReadinessTimeout)
+	defer cancel()
+	if err := windows.WaitForReadiness(ctx, vmReadinessInterval, probes...); err != nil {
+		return nil, fmt.Errorf("unable to connect to vm %s : %v", instanceID, err)
+	}
+	return winVM, nil
 }
 
-// DestroyMachineSet() deletes the MachineSet which in turn deletes all the Machines created by the MachineSet
+// CreateImage creates an image with the given name from the given Windows VM and returns the ID of the image. The
+// Windows VMs of the following test runs can be created from the image, which saves staging the test binaries and
+// enabling ssh on them. Only the AWS cloud provider can create images.
+func (f *TestFramework) CreateImage(vm TestWindowsVM, name string) (string, error) {
+	cloudProvider, err := getCloudProvider(f.windowsVersion())
+	if err != nil {
+		return "", err
+	}
+	imageProvider, ok := cloudProvider.(providers.ImageCloudProvider)
+	if !ok {
+		return "", fmt.Errorf("creating images is not supported by the cloud provider")
+	}
+	return imageProvider.CreateImage(vm.GetCredentials().InstanceId(), name)
+}
+
+// DestroyMachineSet() deletes the MachineSets of the test run which in turn deletes all the Machines created by the
+// MachineSets. The existing MachineSet scaled through the Machine API is scaled back instead, on TearDown.
 func (f *TestFramework) DestroyMachineSet() error {
 	log.Print("Destroying MachineSets")
-	if f.machineSet == nil {
+	if len(f.machineSets) == 0 {
 		log.Print("unable to find MachineSet to be deleted, was skip VM setup option selected ?")
 		log.Print("MachineSets/Machines needs to be deleted manually \nNot deleting MachineSets...")
 		return nil
 	}
-	err := f.machineClient.MachineSets("openshift-machine-api").Delete(context.TODO(), f.machineSet.Name, metav1.DeleteOptions{})
-	if err != nil {
-		return fmt.Errorf("unable to delete MachineSet %v", err)
+	if f.machineAPI != nil {
+		log.Printf("Not deleting existing MachineSet %s, it is scaled back on tear down", f.machineSets[0].Name)
+		return nil
+	}
+	for len(f.machineSets) > 0 {
+		name := f.machineSets[0].Name
+		if err := f.deleteMachineSet(name); err != nil {
+			return fmt.Errorf("unable to delete MachineSet %v", err)
+		}
+		f.Resources.Untrack(machineSetResource, name)
+		f.machineSets = f.machineSets[1:]
 	}
 	log.Print("MachineSets Destroyed")
 	return nil
 }
+
+// deleteMachineSet deletes the MachineSet with the given name, if it still exists
+func (f *TestFramework) deleteMachineSet(name string) error {
+	return machineapi.DeleteMachineSet(context.TODO(), f.machineClient.MachineSets("openshift-machine-api"), name)
+}
+
+// ListE2EMachineSets returns the MachineSets created by the test framework, including the ones left behind by previous
+// test runs that failed to clean up
+func (f *TestFramework) ListE2EMachineSets() ([]mapi.MachineSet, error) {
+	var machineSets *mapi.MachineSetList
+	err := windows.KubeAPIRetryPolicy.Do(context.TODO(), "listing MachineSets", func() error {
+		var err error
+		machineSets, err = f.machineClient.MachineSets("openshift-machine-api").List(context.TODO(),
+			metav1.ListOptions{LabelSelector: e2eMachineSetLabel})
+		return err
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list MachineSets: %v", err)
+	}
+	return machineSets.Items, nil
+}
+
+// DestroyStaleMachineSets deletes the MachineSets created by the test framework that are older than the given age,
+// which in turn deletes their Machines and the associated cloud instances. The MachineSets of the current test run are
+// never deleted.
+func (f *TestFramework) DestroyStaleMachineSets(olderThan time.Duration) error {
+	machineSets, err := f.ListE2EMachineSets()
+	if err != nil {
+		return err
+	}
+	current := make(map[string]bool)
+	for _, machineSet := range f.machineSets {
+		current[machineSet.Name] = true
+	}
+	var errs []error
+	for _, machineSet := range machineSets {
+		if current[machineSet.Name] {
+			continue
+		}
+		age := time.Since(machineSet.CreationTimestamp.Time)
+		if age < olderThan {
+			continue
+		}
+		log.Printf("Destroying stale MachineSet %s created %v ago", machineSet.Name, age.Round(time.Second))
+		if err := f.deleteMachineSet(machineSet.Name); err != nil {
+			errs = append(errs, fmt.Errorf("unable to delete MachineSet %s: %v", machineSet.Name, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
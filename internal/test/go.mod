@@ -6,6 +6,7 @@ replace (
 	github.com/Azure/go-autorest => github.com/Azure/go-autorest v13.3.2+incompatible // Required by OLM
 	github.com/openshift/api => github.com/openshift/api v0.0.0-20200901182017-7ac89ba6b971 // OpenShift 4.6
 	github.com/openshift/client-go => github.com/openshift/client-go v0.0.0-20200827190008-3062137373b5 // OpenShift 4.6
+	github.com/openshift/windows-machine-config-bootstrapper => ../.. // Shares the dependency free packages of the bootstrapper
 	k8s.io/api => k8s.io/api v0.20.0
 	k8s.io/apimachinery => k8s.io/apimachinery v0.20.0
 	k8s.io/client-go => k8s.io/client-go v0.20.0
@@ -16,9 +17,12 @@ replace (
 require (
 	github.com/aws/aws-sdk-go v1.23.2
 	github.com/google/go-github/v29 v29.0.2
+	github.com/masterzen/simplexml v0.0.0-20190410153822-31eea3082786 // indirect
+	github.com/masterzen/winrm v0.0.0-20200615185753-c42b5136ff88
 	github.com/openshift/api v0.0.0-20200901182017-7ac89ba6b971
 	github.com/openshift/client-go v0.0.0-20200827190008-3062137373b5
 	github.com/openshift/machine-api-operator v0.2.1-0.20200520080344-fe76daf636f4
+	github.com/openshift/windows-machine-config-bootstrapper v0.0.0-00010101000000-000000000000
 	github.com/pkg/errors v0.9.1
 	github.com/pkg/sftp v1.11.0
 	github.com/stretchr/testify v1.6.1
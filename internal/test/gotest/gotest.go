@@ -0,0 +1,153 @@
+package gotest
+
+import (
+	"bufio"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Action is the action of a test event, as reported by go test2json
+type Action string
+
+const (
+	// ActionRun is reported when a test starts running
+	ActionRun Action = "run"
+	// ActionPause is reported when a parallel test is paused
+	ActionPause Action = "pause"
+	// ActionCont is reported when a parallel test resumes
+	ActionCont Action = "cont"
+	// ActionPass is reported when a test or the test binary passes
+	ActionPass Action = "pass"
+	// ActionFail is reported when a test or the test binary fails
+	ActionFail Action = "fail"
+	// ActionSkip is reported when a test is skipped
+	ActionSkip Action = "skip"
+	// ActionOutput is reported for the output of a test or of the test binary
+	ActionOutput Action = "output"
+)
+
+var (
+	// statusLine matches the verbose status lines of the test binaries, like "=== RUN   TestFoo"
+	statusLine = regexp.MustCompile(`^=== (RUN|PAUSE|CONT)\s+(\S+)`)
+	// resultLine matches the verbose result lines of the tests, like "    --- PASS: TestFoo/bar (0.01s)"
+	resultLine = regexp.MustCompile(`^\s*--- (PASS|FAIL|SKIP): (\S+) \(([0-9.]+)s\)`)
+)
+
+// Event is an event of a test run, in the format of go test2json
+type Event struct {
+	// Time is the time the event was parsed at
+	Time time.Time `json:",omitempty"`
+	// Action is what happened
+	Action Action
+	// Package is the package the test binary was built from
+	Package string `json:",omitempty"`
+	// Test is the name of the test the event is about. It is empty for the events of the test binary.
+	Test string `json:",omitempty"`
+	// Elapsed is the time the test took in seconds, for the pass, fail and skip actions
+	Elapsed float64 `json:",omitempty"`
+	// Output is a line of output, for the output action
+	Output string `json:",omitempty"`
+}
+
+// Parse parses the output of a test binary run with -test.v into go test2json events of the given package. The lines
+// already in the go test2json format, as printed by a test binary wrapped by go tool test2json, are kept as is. The
+// output of the tests is attributed to the last test that was reported as running or as done, which is the test that
+// printed it unless tests are run in parallel.
+func Parse(output, pkg string) []Event {
+	var events []Event
+	add := func(action Action, test string, elapsed float64, line string) {
+		events = append(events, Event{Time: time.Now(), Action: action, Package: pkg, Test: test, Elapsed: elapsed,
+			Output: line})
+	}
+
+	current := ""
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, "{") {
+			var event Event
+			if err := json.Unmarshal([]byte(line), &event); err == nil && event.Action != "" {
+				if event.Package == "" {
+					event.Package = pkg
+				}
+				events = append(events, event)
+				continue
+			}
+		}
+		if match := statusLine.FindStringSubmatch(line); match != nil {
+			current = match[2]
+			add(ActionOutput, current, 0, line+"\n")
+			add(Action(strings.ToLower(match[1])), current, 0, "")
+			continue
+		}
+		if match := resultLine.FindStringSubmatch(line); match != nil {
+			current = match[2]
+			elapsed, _ := strconv.ParseFloat(match[3], 64)
+			add(ActionOutput, current, 0, line+"\n")
+			add(Action(strings.ToLower(match[1])), current, elapsed, "")
+			continue
+		}
+		switch {
+		case line == "PASS" || line == "FAIL":
+			current = ""
+			add(ActionOutput, "", 0, line+"\n")
+		case strings.HasPrefix(line, "panic: "):
+			// The panic ends the test binary, it is attributed to the test that was running
+			add(ActionOutput, current, 0, line+"\n")
+			current = ""
+		default:
+			add(ActionOutput, current, 0, line+"\n")
+		}
+	}
+	return events
+}
+
+// Result is the outcome of a test
+type Result struct {
+	// Name is the name of the test, including the names of its parent tests for subtests
+	Name string
+	// Action is the outcome of the test: ActionPass, ActionFail or ActionSkip
+	Action Action
+	// Elapsed is the time the test took
+	Elapsed time.Duration
+	// Output is the output of the test
+	Output string
+}
+
+// Results returns the outcome of every test of the given events, in the order the tests started. The tests that did
+// not report an outcome, for example because the test binary panicked or was killed, are failed.
+func Results(events []Event) []Result {
+	var results []*Result
+	byName := make(map[string]*Result)
+	for _, event := range events {
+		if event.Test == "" {
+			continue
+		}
+		result, ok := byName[event.Test]
+		if !ok {
+			result = &Result{Name: event.Test}
+			byName[event.Test] = result
+			results = append(results, result)
+		}
+		switch event.Action {
+		case ActionOutput:
+			result.Output += event.Output
+		case ActionPass, ActionFail, ActionSkip:
+			result.Action = event.Action
+			result.Elapsed = time.Duration(event.Elapsed * float64(time.Second))
+		}
+	}
+	outcomes := make([]Result, 0, len(results))
+	for _, result := range results {
+		if result.Action == "" {
+			result.Action = ActionFail
+			result.Output += "test did not complete\n"
+		}
+		outcomes = append(outcomes, *result)
+	}
+	return outcomes
+}
@@ -0,0 +1,108 @@
+package gotest
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// junitTestSuites is the root element of a JUnit report
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite is the JUnit report of a test binary run
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+	SystemErr string          `xml:"system-err,omitempty"`
+}
+
+// junitTestCase is the JUnit report of a test
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+// junitMessage is the failure or the skip reason of a test
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Output  string `xml:",chardata"`
+}
+
+// JUnit returns the JUnit XML report of the given suites, with a test case for each test. The test binaries that
+// failed without a failed test, for example because they could not start, are reported as a failed test case named
+// after the suite.
+func JUnit(suites ...*Suite) ([]byte, error) {
+	report := junitTestSuites{}
+	for _, suite := range suites {
+		junitSuite := junitTestSuite{
+			Name:      suite.Name,
+			Time:      seconds(suite.Elapsed.Seconds()),
+			SystemErr: suite.Stderr,
+		}
+		for _, result := range suite.Results {
+			testCase := junitTestCase{
+				Name:      result.Name,
+				Classname: suite.Name,
+				Time:      seconds(result.Elapsed.Seconds()),
+			}
+			switch result.Action {
+			case ActionFail:
+				testCase.Failure = &junitMessage{Message: "Failed", Output: result.Output}
+				junitSuite.Failures++
+			case ActionSkip:
+				testCase.Skipped = &junitMessage{Message: "Skipped", Output: result.Output}
+				junitSuite.Skipped++
+			default:
+				testCase.SystemOut = result.Output
+			}
+			junitSuite.TestCases = append(junitSuite.TestCases, testCase)
+		}
+		if err := suite.Err(); err != nil && len(suite.Failures()) == 0 {
+			junitSuite.TestCases = append(junitSuite.TestCases, junitTestCase{
+				Name:      suite.Name,
+				Classname: suite.Name,
+				Time:      seconds(suite.Elapsed.Seconds()),
+				Failure:   &junitMessage{Message: "Failed", Output: err.Error()},
+			})
+			junitSuite.Failures++
+		}
+		junitSuite.Tests = len(junitSuite.TestCases)
+		report.Suites = append(report.Suites, junitSuite)
+	}
+	out, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
+
+// JSON returns the go test2json events of the given suite, one JSON object per line
+func JSON(suite *Suite) ([]byte, error) {
+	var b strings.Builder
+	for _, event := range suite.Events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(line)
+		b.WriteString("\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// seconds formats the given number of seconds as JUnit does
+func seconds(s float64) string {
+	return fmt.Sprintf("%.3f", s)
+}
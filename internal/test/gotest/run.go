@@ -0,0 +1,82 @@
+package gotest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/windows"
+)
+
+// Suite is the outcome of a run of a test binary
+type Suite struct {
+	// Name is the name of the run, which names the JUnit test suite
+	Name string
+	// Events are the go test2json events of the run
+	Events []Event
+	// Results are the outcomes of the tests that ran
+	Results []Result
+	// ExitCode is the exit code of the test binary
+	ExitCode int
+	// Stderr is the standard error of the test binary
+	Stderr string
+	// Elapsed is the time the run took
+	Elapsed time.Duration
+}
+
+// Failed returns true if the test binary failed, or did not run any test
+func (s *Suite) Failed() bool {
+	return s.ExitCode != 0 || len(s.Failures()) > 0 || len(s.Results) == 0
+}
+
+// Failures returns the names of the tests that failed
+func (s *Suite) Failures() []string {
+	var failures []string
+	for _, result := range s.Results {
+		if result.Action == ActionFail {
+			failures = append(failures, result.Name)
+		}
+	}
+	return failures
+}
+
+// Err returns an error describing why the test binary failed, or nil if it passed
+func (s *Suite) Err() error {
+	switch {
+	case len(s.Failures()) > 0:
+		return fmt.Errorf("%s: failed tests: %s", s.Name, strings.Join(s.Failures(), ", "))
+	case s.ExitCode != 0:
+		return fmt.Errorf("%s: test binary exited with code %d: %s", s.Name, s.ExitCode, strings.TrimSpace(s.Stderr))
+	case len(s.Results) == 0:
+		return fmt.Errorf("%s: no test ran", s.Name)
+	}
+	return nil
+}
+
+// Run runs the given test binary on the Windows VM with -test.v, restricted to the tests matching the given regular
+// expression if it is not empty, and returns the outcome of its tests as the suite with the given name. The output of
+// the test binary is written to the given writer as it is produced, if it is not nil. An error is only returned if the
+// test binary could not be run, the failures of the tests are reported by the suite.
+func Run(ctx context.Context, vm windows.WindowsVM, name, binary, run string, output io.Writer) (*Suite, error) {
+	cmd := binary + " -test.v"
+	if run != "" {
+		// Single quoted strings are not expanded by PowerShell, and quotes are escaped by doubling them
+		cmd += " -test.run '" + strings.ReplaceAll(run, "'", "''") + "'"
+	}
+	start := time.Now()
+	result, err := vm.RunCommand(ctx, windows.Command{Command: cmd, PowerShell: true, Output: output})
+	if err != nil {
+		return nil, fmt.Errorf("error running %s: %v", binary, err)
+	}
+	events := Parse(result.Stdout, name)
+	return &Suite{
+		Name:     name,
+		Events:   events,
+		Results:  Results(events),
+		ExitCode: result.ExitCode,
+		Stderr:   result.Stderr,
+		Elapsed:  time.Since(start),
+	}, nil
+}
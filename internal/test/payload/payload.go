@@ -0,0 +1,290 @@
+package payload
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ManifestName is the name of the manifest of a payload that lists the SHA256 checksum of every file of the
+	// payload, in the format generated by sha256sum
+	ManifestName = "SHA256SUMS"
+	// DefaultImagePath is the directory of the container images the payload is extracted from
+	DefaultImagePath = "/payload/"
+	// imagePrefix prefixes the sources extracting the payload from a container image
+	imagePrefix = "image://"
+	// releasePrefix prefixes the sources extracting the payload from the image of a component of the release image of
+	// the cluster
+	releasePrefix = "release://"
+)
+
+// Source resolves the payload a Windows node is bootstrapped with: wmcb.exe, kubelet.exe, hybrid-overlay-node.exe and
+// the cni directory with the CNI plugins
+type Source interface {
+	// Resolve returns the local directory holding the payload, fetching it if needed
+	Resolve(ctx context.Context) (string, error)
+}
+
+// Options configure the payload sources that fetch the payload
+type Options struct {
+	// CacheDir is the directory the fetched payloads are cached in
+	CacheDir string
+	// Kubeconfig is the kubeconfig of the cluster the release image is read from if ReleaseImage is not set
+	Kubeconfig string
+	// ReleaseImage is the pullspec of the release image the component images are read from. The release image of the
+	// cluster is used if it is not set.
+	ReleaseImage string
+	// PullSecret is the registry config the images are pulled with. The default registry config of oc is used if it
+	// is not set.
+	PullSecret string
+	// ImagePath is the directory of the images the payload is extracted from. It defaults to DefaultImagePath.
+	ImagePath string
+}
+
+// NewSource returns the payload source described by the given spec, which is one of:
+//   - the path of a local directory
+//   - the http:// or https:// URL of a mirror serving the payload files along with their manifest
+//   - image://<pullspec>, extracting the image path of the given container image with oc
+//   - release://<component>, extracting the image path of the image of the given component of the release image
+//     with oc
+//
+// The payloads that are fetched are cached in the cache directory. The mirrors need to serve a manifest, and the
+// payloads that have one are verified against it.
+func NewSource(spec string, options Options) (Source, error) {
+	if options.ImagePath == "" {
+		options.ImagePath = DefaultImagePath
+	}
+	switch {
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		return &mirrorSource{url: strings.TrimSuffix(spec, "/"), cacheDir: options.CacheDir}, nil
+	case strings.HasPrefix(spec, imagePrefix):
+		return &imageSource{image: strings.TrimPrefix(spec, imagePrefix), options: options}, nil
+	case strings.HasPrefix(spec, releasePrefix):
+		return NewReleaseSource(strings.TrimPrefix(spec, releasePrefix), options), nil
+	case strings.Contains(spec, "://"):
+		return nil, fmt.Errorf("unsupported payload source %s", spec)
+	}
+	return dirSource(spec), nil
+}
+
+// dirSource is a payload in a local directory
+type dirSource string
+
+// Resolve returns the directory after verifying it against its manifest, if it has one
+func (d dirSource) Resolve(ctx context.Context) (string, error) {
+	dir := string(d)
+	if _, err := os.Stat(filepath.Join(dir, ManifestName)); err == nil {
+		if err = Verify(dir); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// mirrorSource is a payload served over HTTP, which is downloaded to the cache
+type mirrorSource struct {
+	// url is the URL of the directory of the mirror holding the payload files and their manifest
+	url string
+	// cacheDir is the directory the payload is downloaded to
+	cacheDir string
+}
+
+// Resolve downloads the manifest of the mirror and the files it lists that are not already in the cache with the
+// expected checksum, and returns the cached payload
+func (m *mirrorSource) Resolve(ctx context.Context) (string, error) {
+	dir := filepath.Join(m.cacheDir, cacheKey(m.url))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating payload cache: %v", err)
+	}
+	manifestPath := filepath.Join(dir, ManifestName)
+	if err := download(ctx, m.url+"/"+ManifestName, manifestPath); err != nil {
+		return "", err
+	}
+	checksums, err := parseManifest(manifestPath)
+	if err != nil {
+		return "", err
+	}
+	for relPath, checksum := range checksums {
+		dest := filepath.Join(dir, filepath.FromSlash(relPath))
+		if actual, err := sha256File(dest); err == nil && actual == checksum {
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return "", fmt.Errorf("error creating payload cache: %v", err)
+		}
+		if err = download(ctx, m.url+"/"+relPath, dest); err != nil {
+			return "", err
+		}
+	}
+	if err = Verify(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// imageSource is a payload extracted from a container image to the cache
+type imageSource struct {
+	// image is the pullspec of the image. It is resolved from component if empty.
+	image string
+	// component is the name of the component of the release image the image is the one of
+	component string
+	// options configure how the image is pulled and where it is extracted to
+	options Options
+}
+
+// Resolve extracts the payload of the image with oc, unless the image is given by digest and has already been
+// extracted, and returns the extracted payload
+func (i *imageSource) Resolve(ctx context.Context) (string, error) {
+	image := i.image
+	if image == "" {
+		var err error
+		if image, err = releaseComponentImage(ctx, i.component, i.options); err != nil {
+			return "", err
+		}
+	}
+	dir := filepath.Join(i.options.CacheDir, cacheKey(image+":"+i.options.ImagePath))
+	// The images given by digest do not change, unlike the ones given by tag
+	if strings.Contains(image, "@sha256:") {
+		if _, err := os.Stat(filepath.Join(dir, ManifestName)); err == nil && Verify(dir) == nil {
+			return dir, nil
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return "", fmt.Errorf("error clearing payload cache: %v", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating payload cache: %v", err)
+	}
+	args := []string{"image", "extract", image, "--path", i.options.ImagePath + ":" + dir, "--confirm"}
+	if i.options.PullSecret != "" {
+		args = append(args, "--registry-config", i.options.PullSecret)
+	}
+	if _, err := oc(ctx, args...); err != nil {
+		return "", fmt.Errorf("error extracting payload of %s: %v", image, err)
+	}
+	return dirSource(dir).Resolve(ctx)
+}
+
+// Verify checks the files of the payload in the given directory against the checksums of its manifest
+func Verify(dir string) error {
+	checksums, err := parseManifest(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return err
+	}
+	for relPath, checksum := range checksums {
+		actual, err := sha256File(filepath.Join(dir, filepath.FromSlash(relPath)))
+		if err != nil {
+			return fmt.Errorf("error verifying %s: %v", relPath, err)
+		}
+		if actual != checksum {
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", relPath, checksum, actual)
+		}
+	}
+	return nil
+}
+
+// parseManifest parses the given sha256sum formatted manifest into a map of the relative paths of the payload files
+// to their checksums
+func parseManifest(manifestPath string) (map[string]string, error) {
+	manifest, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening payload manifest: %v", err)
+	}
+	defer manifest.Close()
+
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(manifest)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			return nil, fmt.Errorf("invalid payload manifest entry %q", scanner.Text())
+		}
+		// sha256sum prefixes the path with a * in binary mode
+		relPath := strings.TrimPrefix(path.Clean(strings.TrimPrefix(fields[1], "*")), "./")
+		if path.IsAbs(relPath) || relPath == ".." || strings.HasPrefix(relPath, "../") {
+			return nil, fmt.Errorf("payload file %s is outside the payload", fields[1])
+		}
+		checksums[relPath] = strings.ToLower(fields[0])
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading payload manifest: %v", err)
+	}
+	return checksums, nil
+}
+
+// download downloads the given URL to the given file
+func download(ctx context.Context, url, dest string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error downloading %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error downloading %s: %s", url, resp.Status)
+	}
+	// The file is only replaced once it has been downloaded completely
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), filepath.Base(dest))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error downloading %s: %v", url, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// oc runs oc with the given arguments and returns its output
+func oc(ctx context.Context, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, "oc", args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+	return string(out), nil
+}
+
+// cacheKey returns the name of the directory of the cache the payload of the given source is kept in
+func cacheKey(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:8])
+}
+
+// sha256File returns the hex encoded SHA256 checksum of the given file
+func sha256File(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,76 @@
+package payload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DefaultReleaseComponent is the component of the release image the Windows node binaries are extracted from by
+// default
+const DefaultReleaseComponent = "machine-os-content"
+
+// releaseInfo is the part of the output of oc adm release info -o json that describes the versions of the release
+type releaseInfo struct {
+	DisplayVersions map[string]struct {
+		Version string `json:"Version"`
+	} `json:"displayVersions"`
+}
+
+// NewReleaseSource returns the payload source extracting the Windows node binaries from the image of the given
+// component of the release image of the options, or of the release image of the cluster of the kubeconfig of the
+// options if it is not set. The binaries then match the release of the cluster, instead of possibly having a
+// different version than the cluster. The component defaults to DefaultReleaseComponent.
+func NewReleaseSource(component string, options Options) Source {
+	if component == "" {
+		component = DefaultReleaseComponent
+	}
+	if options.ImagePath == "" {
+		options.ImagePath = DefaultImagePath
+	}
+	return &imageSource{component: component, options: options}
+}
+
+// ReleaseKubernetesVersion returns the Kubernetes version of the release image of the given options, which the kubelet
+// extracted from the release is expected to report
+func ReleaseKubernetesVersion(ctx context.Context, options Options) (string, error) {
+	out, err := oc(ctx, releaseInfoArgs(options, "-o", "json")...)
+	if err != nil {
+		return "", fmt.Errorf("error getting release info: %v", err)
+	}
+	var info releaseInfo
+	if err = json.Unmarshal([]byte(out), &info); err != nil {
+		return "", fmt.Errorf("invalid release info: %v", err)
+	}
+	kubernetes, ok := info.DisplayVersions["kubernetes"]
+	if !ok || kubernetes.Version == "" {
+		return "", fmt.Errorf("release has no Kubernetes version")
+	}
+	return kubernetes.Version, nil
+}
+
+// releaseComponentImage returns the pullspec of the image of the given component of the release image of the given
+// options
+func releaseComponentImage(ctx context.Context, component string, options Options) (string, error) {
+	out, err := oc(ctx, releaseInfoArgs(options, "--image-for", component)...)
+	if err != nil {
+		return "", fmt.Errorf("error getting image of release component %s: %v", component, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// releaseInfoArgs returns the arguments of oc adm release info for the release image and the pull secret of the given
+// options, followed by the given arguments
+func releaseInfoArgs(options Options, args ...string) []string {
+	releaseArgs := []string{"adm", "release", "info"}
+	if options.ReleaseImage != "" {
+		releaseArgs = append(releaseArgs, options.ReleaseImage)
+	} else if options.Kubeconfig != "" {
+		releaseArgs = append(releaseArgs, "--kubeconfig", options.Kubeconfig)
+	}
+	if options.PullSecret != "" {
+		releaseArgs = append(releaseArgs, "--registry-config", options.PullSecret)
+	}
+	return append(releaseArgs, args...)
+}
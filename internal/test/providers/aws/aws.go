@@ -1,20 +1,25 @@
 package aws
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"k8s.io/apimachinery/pkg/util/rand"
 	"log"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	awssession "github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/ssm"
 	mapi "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -22,6 +27,8 @@ import (
 	awsprovider "sigs.k8s.io/cluster-api-provider-aws/pkg/apis/awsprovider/v1beta1"
 
 	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/clusterinfo"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/userdata"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/windows"
 )
 
 const (
@@ -31,8 +38,19 @@ const (
 	windowsLabel = "machine.openshift.io/os-id"
 	// instanceType is the AWS specific instance type to create the VM with
 	instanceType = "m5a.large"
+	// windowsAMIParameterPrefix is the prefix of the public SSM parameters holding the ID of the latest Windows AMIs
+	windowsAMIParameterPrefix = "/aws/service/ami-windows-latest/"
 )
 
+// windowsAMINames maps the supported Windows Server versions to the name of their "Windows Server with Containers" AMI.
+// The name of the released AMIs is suffixed with their date of creation.
+var windowsAMINames = map[string]string{
+	"2019": "Windows_Server-2019-English-Full-ContainersLatest",
+	"2004": "Windows_Server-2004-English-Core-ContainersLatest",
+	"20H2": "Windows_Server-20H2-English-Core-ContainersLatest",
+	"2022": "Windows_Server-2022-English-Full-ContainersLatest",
+}
+
 type awsProvider struct {
 	// imageID is the AMI image-id that is used to create new Virtual Machines
 	imageID string
@@ -48,47 +66,100 @@ type awsProvider struct {
 	region string
 	// sshKeyPair is the key pair associated with the Windows VM
 	sshKeyPair string
+	// options overrides the resources discovered from the cluster the Windows VMs are created with
+	options Options
+	// spotAttempt is the index of the subnet the spot instances of the next generated MachineSet are placed in, among
+	// the subnets the Windows VMs can be created in. On-demand instances are created once every subnet has been tried.
+	spotAttempt int
+	// spotSubnetCount is the number of subnets the spot instances can be placed in
+	spotSubnetCount int
+}
+
+// metadataServiceOptions are the instance metadata service options of the machine API AWS provider spec
+type metadataServiceOptions struct {
+	// Authorization is Required for the instance metadata service to only accept the IMDSv2 requests, which are
+	// authorized with a session token, or Optional
+	Authorization string `json:"authorization,omitempty"`
+}
+
+// providerConfig is the machine API AWS provider spec of the Windows instances. It adds the instance metadata service
+// options and the placement group of the machine API to the vendored AWSMachineProviderConfig, which predates them.
+type providerConfig struct {
+	*awsprovider.AWSMachineProviderConfig
+	// MetadataServiceOptions are the instance metadata service options the instances are created with
+	MetadataServiceOptions metadataServiceOptions `json:"metadataServiceOptions,omitempty"`
+	// PlacementGroupName is the name of the placement group the instances are launched in
+	PlacementGroupName string `json:"placementGroupName,omitempty"`
 }
 
-// newSession uses AWS credentials to create and returns a session for interacting with EC2.
+// policyRetryer retries the AWS API calls as per a retry policy. The calls are retried on the throttling, server and
+// transient errors, as classified by the SDK.
+type policyRetryer struct {
+	client.DefaultRetryer
+	// policy is the retry policy the number of retries and the delay between them are taken from
+	policy windows.RetryPolicy
+}
+
+// newPolicyRetryer returns a policyRetryer retrying the AWS API calls as per the given policy
+func newPolicyRetryer(policy windows.RetryPolicy) policyRetryer {
+	return policyRetryer{DefaultRetryer: client.DefaultRetryer{NumMaxRetries: policy.MaxAttempts - 1}, policy: policy}
+}
+
+// RetryRules returns the delay before retrying the given failed request
+func (r policyRetryer) RetryRules(req *request.Request) time.Duration {
+	return r.policy.Delay(req.RetryCount)
+}
+
+// newSession uses AWS credentials to create and returns a session for interacting with EC2. The calls made with the
+// session are retried as per the default retry policy.
 func newSession(credentialPath, credentialAccountID, region string) (*awssession.Session, error) {
 	if _, err := os.Stat(credentialPath); err != nil {
 		return nil, fmt.Errorf("failed to find AWS credentials from path '%v'", credentialPath)
 	}
-	return awssession.NewSession(&aws.Config{
+	return awssession.NewSession(request.WithRetryer(&aws.Config{
 		Credentials: credentials.NewSharedCredentials(credentialPath, credentialAccountID),
 		Region:      aws.String(region),
-	})
+	}, newPolicyRetryer(windows.DefaultRetryPolicy)))
 }
 
 // newAWSProvider returns the AWS implementations of the Cloud interface with AWS session in the same region as OpenShift Cluster.
 // credentialPath is the file path the AWS credentials file.
 // credentialAccountID is the account name the user uses to create VM instance.
 // The credentialAccountID should exist in the AWS credentials file pointing at one specific credential.
+// windowsVersion is the Windows Server version of the latest AMI used, unless an AMI is pinned in the options.
 func newAWSProvider(openShiftClient *clusterinfo.OpenShift, credentialPath,
-	credentialAccountID, instanceType, region, sshKeyPair string) (*awsProvider, error) {
+	credentialAccountID, instanceType, region, sshKeyPair, windowsVersion string, options Options) (*awsProvider,
+	error) {
 	session, err := newSession(credentialPath, credentialAccountID, region)
 	if err != nil {
 		return nil, fmt.Errorf("could not create new AWS session: %v", err)
 	}
 	ec2Client := ec2.New(session, aws.NewConfig())
 	iamClient := iam.New(session, aws.NewConfig())
-	imageID, err := getLatestWindowsAMI(ec2Client)
-	if err != nil {
-		return nil, fmt.Errorf("unable to get latest Windows AMI: %v", err)
+	imageID := options.ImageID
+	if imageID == "" {
+		imageID, err = getLatestWindowsAMI(ssm.New(session, aws.NewConfig()), ec2Client, windowsVersion)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get latest Windows AMI: %v", err)
+		}
 	}
+	log.Printf("Creating Windows VMs with AMI %s", imageID)
 
-	return &awsProvider{imageID, instanceType,
-		iamClient,
-		ec2Client,
-		openShiftClient,
-		region,
-		sshKeyPair,
+	return &awsProvider{
+		imageID:         imageID,
+		instanceType:    instanceType,
+		iam:             iamClient,
+		ec2:             ec2Client,
+		openShiftClient: openShiftClient,
+		region:          region,
+		sshKeyPair:      sshKeyPair,
+		options:         options,
 	}, nil
 }
 
-// SetupAWSCloudProvider creates AWS provider using the give OpenShift client
-func SetupAWSCloudProvider(region, sshKeyPair string) (*awsProvider, error) {
+// SetupAWSCloudProvider creates AWS provider using the give OpenShift client. The Windows VMs are created with the latest
+// AMI of the given Windows Server version, unless an AMI is pinned with the AWS_WINDOWS_AMI_ID environment variable.
+func SetupAWSCloudProvider(region, sshKeyPair, windowsVersion string) (*awsProvider, error) {
 	oc, err := clusterinfo.NewOpenShift()
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize OpenShift client with error: %v", err)
@@ -98,7 +169,12 @@ func SetupAWSCloudProvider(region, sshKeyPair string) (*awsProvider, error) {
 	if awsCredentials == "" {
 		return nil, fmt.Errorf("AWS_SHARED_CREDENTIALS_FILE env var is empty")
 	}
-	awsProvider, err := newAWSProvider(oc, awsCredentials, "default", instanceType, region, sshKeyPair)
+	options, err := OptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	awsProvider, err := newAWSProvider(oc, awsCredentials, "default", instanceType, region, sshKeyPair,
+		windowsVersion, options)
 	if err != nil {
 		return nil, fmt.Errorf("error obtaining aws interface object: %v", err)
 	}
@@ -114,18 +190,36 @@ func (a *awsProvider) getInfraID() (string, error) {
 	return infraID, nil
 }
 
-// getLatestWindowsAMI returns the imageID of the latest released "Windows Server with Containers" image
-func getLatestWindowsAMI(ec2Client *ec2.EC2) (string, error) {
+// getLatestWindowsAMI returns the imageID of the latest released "Windows Server with Containers" image of the given
+// Windows Server version. The image is looked up in the public SSM parameters AWS maintains for the latest Windows
+// AMIs, falling back to searching the AMIs by name if the parameter cannot be read, for example because the
+// credentials are not allowed to read SSM parameters.
+func getLatestWindowsAMI(ssmClient *ssm.SSM, ec2Client *ec2.EC2, windowsVersion string) (string, error) {
+	amiName, ok := windowsAMINames[windowsVersion]
+	if !ok {
+		return "", fmt.Errorf("unsupported Windows Server version %s", windowsVersion)
+	}
+	parameter, err := ssmClient.GetParameter(&ssm.GetParameterInput{
+		Name: aws.String(windowsAMIParameterPrefix + amiName),
+	})
+	if err == nil && parameter.Parameter != nil && aws.StringValue(parameter.Parameter.Value) != "" {
+		return *parameter.Parameter.Value, nil
+	}
+	if err != nil {
+		log.Printf("unable to read the latest %s AMI from SSM, searching the AMIs instead: %v", amiName, err)
+	}
+	return searchLatestWindowsAMI(ec2Client, amiName)
+}
+
+// searchLatestWindowsAMI returns the imageID of the latest released image with the given name
+func searchLatestWindowsAMI(ec2Client *ec2.EC2, amiName string) (string, error) {
 	// Have to create these variables, as the below functions require pointers to them
 	windowsAMIOwner := "amazon"
 	windowsAMIFilterName := "name"
 	// This filter will grab all ami's that match the exact name. The '?' indicate any character will match.
 	// The ami's will have the name format: Windows_Server-2019-English-Full-ContainersLatest-2020.01.15
 	// so the question marks will match the date of creation
-	// The image obtained by using windowsAMIFilterValue is compatible with  the test container image -
-	// "mcr.microsoft.com/powershell:lts-nanoserver-1809". If the windowsAMIFilterValue changes,
-	// the test container image also needs to be changed.
-	windowsAMIFilterValue := "Windows_Server-2019-English-Full-ContainersLatest-????.??.??"
+	windowsAMIFilterValue := amiName + "-????.??.??"
 	searchFilter := ec2.Filter{Name: &windowsAMIFilterName, Values: []*string{&windowsAMIFilterValue}}
 
 	describedImages, err := ec2Client.DescribeImages(&ec2.DescribeImagesInput{
@@ -158,9 +252,9 @@ func getLatestWindowsAMI(ec2Client *ec2.EC2) (string, error) {
 	return *latestImage.ImageId, nil
 }
 
-// getSubnet tries to find a subnet under the VPC and returns subnet or an error.
-// These subnets belongs to the OpenShift cluster.
-func (a *awsProvider) getSubnet(infraID string) (*ec2.Subnet, error) {
+// getSubnets tries to find the subnets under the VPC that are in a zone supporting the instance type and returns the
+// subnets or an error. These subnets belongs to the OpenShift cluster.
+func (a *awsProvider) getSubnets(infraID string) ([]*ec2.Subnet, error) {
 	vpc, err := a.getVPCByInfrastructure(infraID)
 	if err != nil {
 		return nil, fmt.Errorf("unable to get the VPC %v", err)
@@ -178,32 +272,17 @@ func (a *awsProvider) getSubnet(infraID string) (*ec2.Subnet, error) {
 		return nil, err
 	}
 
-	// Get the instance offerings that support Windows instances
-	scope := "Availability Zone"
-	productDescription := "Windows"
-	f := false
-	offerings, err := a.ec2.DescribeReservedInstancesOfferings(&ec2.DescribeReservedInstancesOfferingsInput{
-		Filters: []*ec2.Filter{
-			{
-				Name:   aws.String("scope"),
-				Values: []*string{&scope},
-			},
-		},
-		IncludeMarketplace: &f,
-		InstanceType:       &a.instanceType,
-		ProductDescription: &productDescription,
-	})
+	offerings, err := a.getWindowsInstanceOfferings()
 	if err != nil {
-		return nil, fmt.Errorf("error checking instance offerings of %s: %v", a.instanceType, err)
-	}
-	if offerings.ReservedInstancesOfferings == nil {
-		return nil, fmt.Errorf("no instance offerings returned for %s", a.instanceType)
+		return nil, err
 	}
 
 	// Finding required subnet within the vpc.
 	foundSubnet := false
 	requiredSubnet := "-private-"
+	var supportedSubnets []*ec2.Subnet
 
+subnetLoop:
 	for _, subnet := range subnets.Subnets {
 		for _, tag := range subnet.Tags {
 			// TODO: find required subnet by checking igw gateway in routing.
@@ -212,17 +291,21 @@ func (a *awsProvider) getSubnet(infraID string) (*ec2.Subnet, error) {
 			if *tag.Key == "Name" && strings.Contains(*tag.Value, infraID+requiredSubnet) {
 				foundSubnet = true
 				// Ensure that the instance type we want is supported in the zone that the subnet is in
-				for _, instanceOffering := range offerings.ReservedInstancesOfferings {
+				for _, instanceOffering := range offerings {
 					if instanceOffering.AvailabilityZone == nil {
 						continue
 					}
 					if *instanceOffering.AvailabilityZone == *subnet.AvailabilityZone {
-						return subnet, nil
+						supportedSubnets = append(supportedSubnets, subnet)
+						continue subnetLoop
 					}
 				}
 			}
 		}
 	}
+	if len(supportedSubnets) > 0 {
+		return supportedSubnets, nil
+	}
 
 	err = fmt.Errorf("could not find the required subnet in VPC: %v", *vpc.VpcId)
 	if !foundSubnet {
@@ -232,6 +315,70 @@ func (a *awsProvider) getSubnet(infraID string) (*ec2.Subnet, error) {
 	return nil, err
 }
 
+// subnetsInZone returns the given subnets that are in the given availability zone
+func subnetsInZone(subnets []*ec2.Subnet, zone string) ([]*ec2.Subnet, error) {
+	var found []*ec2.Subnet
+	for _, subnet := range subnets {
+		if aws.StringValue(subnet.AvailabilityZone) == zone {
+			found = append(found, subnet)
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no private subnet of the cluster in zone %s supports the Windows instance type", zone)
+	}
+	return found, nil
+}
+
+// getWindowsInstanceOfferings returns the instance offerings of the instance type that support Windows instances
+func (a *awsProvider) getWindowsInstanceOfferings() ([]*ec2.ReservedInstancesOffering, error) {
+	scope := "Availability Zone"
+	productDescription := "Windows"
+	f := false
+	offerings, err := a.ec2.DescribeReservedInstancesOfferings(&ec2.DescribeReservedInstancesOfferingsInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("scope"),
+				Values: []*string{&scope},
+			},
+		},
+		IncludeMarketplace: &f,
+		InstanceType:       &a.instanceType,
+		ProductDescription: &productDescription,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error checking instance offerings of %s: %v", a.instanceType, err)
+	}
+	if offerings.ReservedInstancesOfferings == nil {
+		return nil, fmt.Errorf("no instance offerings returned for %s", a.instanceType)
+	}
+	return offerings.ReservedInstancesOfferings, nil
+}
+
+// getSubnetByID returns the subnet with the given ID, after checking that the instance type is supported in the zone
+// the subnet is in
+func (a *awsProvider) getSubnetByID(subnetID string) (*ec2.Subnet, error) {
+	subnets, err := a.ec2.DescribeSubnets(&ec2.DescribeSubnetsInput{SubnetIds: aws.StringSlice([]string{subnetID})})
+	if err != nil {
+		return nil, err
+	}
+	if len(subnets.Subnets) < 1 {
+		return nil, fmt.Errorf("subnet %s not found", subnetID)
+	}
+	subnet := subnets.Subnets[0]
+
+	offerings, err := a.getWindowsInstanceOfferings()
+	if err != nil {
+		return nil, err
+	}
+	for _, instanceOffering := range offerings {
+		if instanceOffering.AvailabilityZone != nil && *instanceOffering.AvailabilityZone == *subnet.AvailabilityZone {
+			return subnet, nil
+		}
+	}
+	return nil, fmt.Errorf("subnet %s is in zone %s that does not support %s instance type", subnetID,
+		*subnet.AvailabilityZone, a.instanceType)
+}
+
 // getClusterWorkerSGID gets worker security group id from the existing cluster or returns an error.
 func (a *awsProvider) getClusterWorkerSGID(infraID string) (string, error) {
 	sg, err := a.ec2.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
@@ -255,6 +402,19 @@ func (a *awsProvider) getClusterWorkerSGID(infraID string) (string, error) {
 	return *sg.SecurityGroups[0].GroupId, nil
 }
 
+// getSecurityGroupIDs returns the IDs of the security groups of the Windows instances, which default to the worker
+// security group of the cluster
+func (a *awsProvider) getSecurityGroupIDs(infraID string) ([]string, error) {
+	if len(a.options.SecurityGroupIDs) > 0 {
+		return a.options.SecurityGroupIDs, nil
+	}
+	sgID, err := a.getClusterWorkerSGID(infraID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get security group id: %v", err)
+	}
+	return []string{sgID}, nil
+}
+
 // GetVPCByInfrastructure finds the VPC of an infrastructure and returns the VPC struct or an error.
 func (a *awsProvider) getVPCByInfrastructure(infraID string) (*ec2.Vpc, error) {
 	res, err := a.ec2.DescribeVpcs(&ec2.DescribeVpcsInput{
@@ -297,27 +457,246 @@ func (a *awsProvider) getIAMWorkerRole(infraID string) (*ec2.IamInstanceProfileS
 	}, nil
 }
 
-// GenerateMachineSet generates the machineset object which is aws provider specific
-func (a *awsProvider) GenerateMachineSet(withWindowsLabel bool, replicas int32) (*mapi.MachineSet, error) {
+// spotCapacityErrors are the error codes returned by EC2 when a spot instance cannot be created for lack of capacity or
+// because the maximum price is lower than the spot price
+var spotCapacityErrors = []string{
+	"InsufficientInstanceCapacity",
+	"SpotMaxPriceTooLow",
+	"MaxSpotInstanceCountExceeded",
+	"UnfulfillableCapacity",
+}
+
+// IsSpotCapacityError returns true if the given error message of a Machine indicates that its spot instance could not
+// be created for lack of spot capacity
+func (a *awsProvider) IsSpotCapacityError(message string) bool {
+	for _, code := range spotCapacityErrors {
+		if strings.Contains(message, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// FallBack places the spot instances of the next generated MachineSet in the next zone supporting the instance type,
+// or creates on-demand instances once every zone has been tried. It returns false if the MachineSets already create
+// on-demand instances.
+func (a *awsProvider) FallBack() bool {
+	if !a.options.Spot || a.spotAttempt >= a.spotSubnetCount {
+		return false
+	}
+	a.spotAttempt++
+	if a.spotAttempt == a.spotSubnetCount {
+		log.Print("Spot instances could not be created in any zone, falling back to on-demand instances")
+	}
+	return true
+}
+
+// GenerateUserData generates the user data run by the EC2Launch agent of the Windows AMI
+func (a *awsProvider) GenerateUserData(authorizedKey string) []byte {
+	return userdata.PowerShellUserData(userdata.SetupScript(authorizedKey))
+}
+
+// ReadinessProbes returns the probes checking that the EC2 instance with the given ID is running and that Windows has
+// completed its first boot, which EC2Launch signals by making the encrypted Administrator password available
+func (a *awsProvider) ReadinessProbes(instanceID string) []windows.ReadinessProbe {
+	probes := []windows.ReadinessProbe{
+		{
+			Name: fmt.Sprintf("instance %s is running", instanceID),
+			Check: func(ctx context.Context) error {
+				statuses, err := a.ec2.DescribeInstanceStatusWithContext(ctx, &ec2.DescribeInstanceStatusInput{
+					InstanceIds:         aws.StringSlice([]string{instanceID}),
+					IncludeAllInstances: aws.Bool(true),
+				})
+				if err != nil {
+					return err
+				}
+				if len(statuses.InstanceStatuses) < 1 || statuses.InstanceStatuses[0].InstanceState == nil {
+					return fmt.Errorf("no status found for instance %s", instanceID)
+				}
+				state := aws.StringValue(statuses.InstanceStatuses[0].InstanceState.Name)
+				if state != ec2.InstanceStateNameRunning {
+					return fmt.Errorf("instance %s is %s", instanceID, state)
+				}
+				return nil
+			},
+		},
+	}
+	// The password of the instances created from an image of a test VM, which can be pinned, is never made available
+	// as the image is not generalized
+	if a.options.ImageID != "" {
+		return probes
+	}
+	return append(probes,
+		windows.ReadinessProbe{
+			Name: fmt.Sprintf("password data of instance %s is available", instanceID),
+			Check: func(ctx context.Context) error {
+				passwordData, err := a.ec2.GetPasswordDataWithContext(ctx, &ec2.GetPasswordDataInput{
+					InstanceId: aws.String(instanceID),
+				})
+				if err != nil {
+					return err
+				}
+				if aws.StringValue(passwordData.PasswordData) == "" {
+					return fmt.Errorf("password data of instance %s is not available yet", instanceID)
+				}
+				return nil
+			},
+		})
+}
+
+// Reboot reboots the EC2 instance with the given ID. EC2 performs a hard reboot if Windows does not shut down cleanly
+// within four minutes.
+func (a *awsProvider) Reboot(ctx context.Context, instanceID string) error {
+	_, err := a.ec2.RebootInstancesWithContext(ctx, &ec2.RebootInstancesInput{
+		InstanceIds: aws.StringSlice([]string{instanceID}),
+	})
+	return err
+}
+
+// CreateImage creates an AMI with the given name from the EC2 instance with the given ID and returns its ID. The
+// instance is not rebooted, so that it can keep being used while the AMI is created in the background.
+func (a *awsProvider) CreateImage(instanceID, name string) (string, error) {
+	image, err := a.ec2.CreateImage(&ec2.CreateImageInput{
+		InstanceId:  aws.String(instanceID),
+		Name:        aws.String(name),
+		Description: aws.String(fmt.Sprintf("Windows test VM %s with the test binaries staged", instanceID)),
+		NoReboot:    aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating image of instance %s: %v", instanceID, err)
+	}
+	return aws.StringValue(image.ImageId), nil
+}
+
+// machineSetResources holds the resources of the cluster the MachineSets of the Windows VMs are created with
+type machineSetResources struct {
+	// clusterName is the infrastructure ID of the cluster
+	clusterName string
+	// instanceProfileName is the name of the IAM instance profile of the Windows VMs
+	instanceProfileName string
+	// securityGroups are the security groups of the Windows VMs
+	securityGroups []awsprovider.AWSResourceReference
+}
+
+// getMachineSetResources returns the resources of the cluster the MachineSets of the Windows VMs are created with
+func (a *awsProvider) getMachineSetResources() (*machineSetResources, error) {
 	clusterName, err := a.getInfraID()
 	if err != nil {
 		return nil, fmt.Errorf("unable to get infrastructure id %v", err)
 	}
 
-	instanceProfile, err := a.getIAMWorkerRole(clusterName)
+	instanceProfileName := a.options.IAMInstanceProfile
+	if instanceProfileName == "" {
+		instanceProfile, err := a.getIAMWorkerRole(clusterName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get instance profile %v", err)
+		}
+		instanceProfileName = *instanceProfile.Name
+	}
+
+	sgIDs, err := a.getSecurityGroupIDs(clusterName)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get instance profile %v", err)
+		return nil, err
+	}
+	securityGroups := make([]awsprovider.AWSResourceReference, 0, len(sgIDs))
+	for i := range sgIDs {
+		securityGroups = append(securityGroups, awsprovider.AWSResourceReference{ID: &sgIDs[i]})
 	}
+	return &machineSetResources{clusterName: clusterName, instanceProfileName: instanceProfileName,
+		securityGroups: securityGroups}, nil
+}
 
-	sgID, err := a.getClusterWorkerSGID(clusterName)
+// spotMarketOptions returns the spot market options of the Windows VMs of the next generated MachineSets, or nil if
+// on-demand instances are created
+func (a *awsProvider) spotMarketOptions() *awsprovider.SpotMarketOptions {
+	if !a.options.Spot || a.spotAttempt >= a.spotSubnetCount {
+		return nil
+	}
+	spotMarketOptions := &awsprovider.SpotMarketOptions{}
+	if a.options.SpotMaxPrice != "" {
+		spotMarketOptions.MaxPrice = &a.options.SpotMaxPrice
+	}
+	return spotMarketOptions
+}
+
+// GenerateMachineSet generates the machineset object which is aws provider specific
+func (a *awsProvider) GenerateMachineSet(withWindowsLabel bool, replicas int32) (*mapi.MachineSet, error) {
+	resources, err := a.getMachineSetResources()
 	if err != nil {
-		return nil, fmt.Errorf("unable to get security group id: %v", err)
+		return nil, err
 	}
 
-	subnet, err := a.getSubnet(clusterName)
+	var subnets []*ec2.Subnet
+	if a.options.SubnetID != "" {
+		var subnet *ec2.Subnet
+		subnet, err = a.getSubnetByID(a.options.SubnetID)
+		subnets = []*ec2.Subnet{subnet}
+	} else {
+		subnets, err = a.getSubnets(resources.clusterName)
+		if err == nil && a.options.AvailabilityZone != "" {
+			subnets, err = subnetsInZone(subnets, a.options.AvailabilityZone)
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to get subnet: %v", err)
 	}
+	subnet := subnets[0]
+	// The spot instances are placed in the subnets in turn, see FallBack
+	a.spotSubnetCount = len(subnets)
+	spotMarketOptions := a.spotMarketOptions()
+	if spotMarketOptions != nil {
+		subnet = subnets[a.spotAttempt]
+		log.Printf("Creating spot instances in zone %s", *subnet.AvailabilityZone)
+	}
+	return a.newMachineSet(resources, withWindowsLabel, replicas, subnet, spotMarketOptions)
+}
+
+// GenerateZonalMachineSets generates a MachineSet per zone of the private subnets of the cluster supporting the instance
+// type, sorted by zone, with no replicas. If the spot instances cannot be created, the MachineSets fall back to
+// on-demand instances in the same zones at once, rather than moving to another zone.
+func (a *awsProvider) GenerateZonalMachineSets(withWindowsLabel bool) ([]*mapi.MachineSet, error) {
+	if a.options.SubnetID != "" || a.options.AvailabilityZone != "" {
+		return nil, fmt.Errorf("the Windows VMs cannot be spread across zones with %s or %s set", subnetIDEnv,
+			availabilityZoneEnv)
+	}
+	resources, err := a.getMachineSetResources()
+	if err != nil {
+		return nil, err
+	}
+	subnets, err := a.getSubnets(resources.clusterName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get subnet: %v", err)
+	}
+	zoneSubnets := make(map[string]*ec2.Subnet)
+	var zones []string
+	for _, subnet := range subnets {
+		zone := aws.StringValue(subnet.AvailabilityZone)
+		if _, ok := zoneSubnets[zone]; !ok {
+			zoneSubnets[zone] = subnet
+			zones = append(zones, zone)
+		}
+	}
+	sort.Strings(zones)
+
+	a.spotSubnetCount = 1
+	spotMarketOptions := a.spotMarketOptions()
+	var machineSets []*mapi.MachineSet
+	for _, zone := range zones {
+		machineSet, err := a.newMachineSet(resources, withWindowsLabel, 0, zoneSubnets[zone], spotMarketOptions)
+		if err != nil {
+			return nil, err
+		}
+		machineSets = append(machineSets, machineSet)
+	}
+	log.Printf("Spreading the Windows VMs across zones %s", strings.Join(zones, ", "))
+	return machineSets, nil
+}
+
+// newMachineSet returns the MachineSet of the given number of Windows VMs created in the given subnet with the given
+// resources, as spot instances if the spot market options are not nil
+func (a *awsProvider) newMachineSet(resources *machineSetResources, withWindowsLabel bool, replicas int32,
+	subnet *ec2.Subnet, spotMarketOptions *awsprovider.SpotMarketOptions) (*mapi.MachineSet, error) {
+	clusterName := resources.clusterName
 	machineSetName := "e2e-windows-machineset-"
 	publicIP := false
 	matchLabels := map[string]string{
@@ -339,33 +718,35 @@ func (a *awsProvider) GenerateMachineSet(withWindowsLabel bool, replicas int32)
 		machineLabels[k] = v
 	}
 
-	providerSpec := &awsprovider.AWSMachineProviderConfig{
-		AMI: awsprovider.AWSResourceReference{
-			ID: &a.imageID,
-		},
-		InstanceType: a.instanceType,
-		IAMInstanceProfile: &awsprovider.AWSResourceReference{
-			ID: instanceProfile.Name,
-		},
-		CredentialsSecret: &core.LocalObjectReference{
-			Name: "aws-cloud-credentials",
-		},
-		SecurityGroups: []awsprovider.AWSResourceReference{
-			{
-				ID: &sgID,
+	providerSpec := &providerConfig{
+		AWSMachineProviderConfig: &awsprovider.AWSMachineProviderConfig{
+			AMI: awsprovider.AWSResourceReference{
+				ID: &a.imageID,
 			},
+			InstanceType: a.instanceType,
+			IAMInstanceProfile: &awsprovider.AWSResourceReference{
+				ID: &resources.instanceProfileName,
+			},
+			CredentialsSecret: &core.LocalObjectReference{
+				Name: "aws-cloud-credentials",
+			},
+			SecurityGroups: resources.securityGroups,
+			Tags:           a.options.tagSpecifications(),
+			Subnet: awsprovider.AWSResourceReference{
+				ID: subnet.SubnetId,
+			},
+			// query placement
+			Placement: awsprovider.Placement{
+				Region:           a.region,
+				AvailabilityZone: *subnet.AvailabilityZone,
+			},
+			UserDataSecret:    &core.LocalObjectReference{Name: "windows-user-data"},
+			KeyName:           &a.sshKeyPair,
+			PublicIP:          &publicIP,
+			SpotMarketOptions: spotMarketOptions,
 		},
-		Subnet: awsprovider.AWSResourceReference{
-			ID: subnet.SubnetId,
-		},
-		// query placement
-		Placement: awsprovider.Placement{
-			a.region,
-			*subnet.AvailabilityZone,
-		},
-		UserDataSecret: &core.LocalObjectReference{Name: "windows-user-data"},
-		KeyName:        &a.sshKeyPair,
-		PublicIP:       &publicIP,
+		MetadataServiceOptions: a.options.metadataServiceOptions(),
+		PlacementGroupName:     a.options.PlacementGroupName,
 	}
 
 	rawBytes, err := json.Marshal(providerSpec)
@@ -0,0 +1,148 @@
+package aws
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// nodePort is a port of the Windows instances the nodes of the cluster need to reach
+type nodePort struct {
+	// name describes the traffic on the port
+	name string
+	// protocol is the IP protocol of the traffic, either tcp or udp
+	protocol string
+	// port is the port the traffic is sent to
+	port int64
+}
+
+var (
+	// windowsNodePorts are the ports the Windows nodes need to be reachable on from the other nodes of the cluster
+	windowsNodePorts = []nodePort{
+		{name: "hybrid overlay VXLAN", protocol: "udp", port: 4789},
+		{name: "kubelet", protocol: "tcp", port: 10250},
+	}
+	// winRMPort is the port the Windows instances are accessed on by the tests over WinRM
+	winRMPort = nodePort{name: "WinRM over HTTPS", protocol: "tcp", port: 5986}
+)
+
+// allows returns true if the given permission allows the traffic to the given port from the given security group or
+// from the given CIDR
+func (p nodePort) allows(permission *ec2.IpPermission, sourceGroupID string, sourceCIDR *net.IPNet) bool {
+	protocol := aws.StringValue(permission.IpProtocol)
+	// -1 allows all protocols and ports
+	if protocol != "-1" {
+		if protocol != p.protocol || aws.Int64Value(permission.FromPort) > p.port ||
+			aws.Int64Value(permission.ToPort) < p.port {
+			return false
+		}
+	}
+	for _, pair := range permission.UserIdGroupPairs {
+		if aws.StringValue(pair.GroupId) == sourceGroupID {
+			return true
+		}
+	}
+	for _, ipRange := range permission.IpRanges {
+		_, cidr, err := net.ParseCIDR(aws.StringValue(ipRange.CidrIp))
+		if err != nil {
+			continue
+		}
+		// The range needs to contain the whole source CIDR
+		sourceOnes, _ := sourceCIDR.Mask.Size()
+		ones, _ := cidr.Mask.Size()
+		if ones <= sourceOnes && cidr.Contains(sourceCIDR.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateNetwork checks that the security groups of the Windows instances allow the nodes of the cluster to reach the
+// hybrid overlay VXLAN and kubelet ports of the Windows instances, as well as the WinRM port if winRM is true. The
+// traffic is allowed if it is allowed from the worker security group of the cluster or from the CIDR of its VPC. If
+// remediate is true, the missing rules are added to the first security group of the Windows instances, allowing the
+// traffic from the worker security group. An error listing the missing rules is returned otherwise, so that the test
+// run fails before the Windows instances are created rather than with networking symptoms once they join the cluster.
+func (a *awsProvider) ValidateNetwork(winRM, remediate bool) error {
+	infraID, err := a.getInfraID()
+	if err != nil {
+		return err
+	}
+	sgIDs, err := a.getSecurityGroupIDs(infraID)
+	if err != nil {
+		return err
+	}
+	workerSGID, err := a.getClusterWorkerSGID(infraID)
+	if err != nil {
+		return fmt.Errorf("unable to get worker security group id: %v", err)
+	}
+	vpc, err := a.getVPCByInfrastructure(infraID)
+	if err != nil {
+		return fmt.Errorf("unable to get VPC: %v", err)
+	}
+	_, vpcCIDR, err := net.ParseCIDR(aws.StringValue(vpc.CidrBlock))
+	if err != nil {
+		return fmt.Errorf("invalid CIDR of VPC %s: %v", aws.StringValue(vpc.VpcId), err)
+	}
+	groups, err := a.ec2.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{GroupIds: aws.StringSlice(sgIDs)})
+	if err != nil {
+		return fmt.Errorf("unable to describe security groups %v: %v", sgIDs, err)
+	}
+
+	ports := append([]nodePort{}, windowsNodePorts...)
+	if winRM {
+		ports = append(ports, winRMPort)
+	}
+	var missing []nodePort
+	for _, port := range ports {
+		allowed := false
+		// The rules of all the security groups of an instance apply
+		for _, group := range groups.SecurityGroups {
+			for _, permission := range group.IpPermissions {
+				if port.allows(permission, workerSGID, vpcCIDR) {
+					allowed = true
+				}
+			}
+		}
+		if !allowed {
+			missing = append(missing, port)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var descriptions []string
+	var permissions []*ec2.IpPermission
+	for _, port := range missing {
+		descriptions = append(descriptions, fmt.Sprintf("%s (%d/%s)", port.name, port.port, port.protocol))
+		permissions = append(permissions, &ec2.IpPermission{
+			IpProtocol: aws.String(port.protocol),
+			FromPort:   aws.Int64(port.port),
+			ToPort:     aws.Int64(port.port),
+			UserIdGroupPairs: []*ec2.UserIdGroupPair{{
+				GroupId:     aws.String(workerSGID),
+				Description: aws.String("Windows node " + port.name),
+			}},
+		})
+	}
+	if !remediate {
+		return fmt.Errorf("security groups %v do not allow traffic from the worker nodes to %s", sgIDs,
+			strings.Join(descriptions, ", "))
+	}
+	_, err = a.ec2.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(sgIDs[0]),
+		IpPermissions: permissions,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to allow traffic to %s in security group %s: %v", strings.Join(descriptions, ", "),
+			sgIDs[0], err)
+	}
+	log.Printf("allowed traffic from the worker nodes to %s in security group %s", strings.Join(descriptions, ", "),
+		sgIDs[0])
+	return nil
+}
@@ -0,0 +1,177 @@
+package aws
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	awsprovider "sigs.k8s.io/cluster-api-provider-aws/pkg/apis/awsprovider/v1beta1"
+)
+
+const (
+	// subnetIDEnv is the environment variable holding the ID of the subnet the Windows instances are created in
+	subnetIDEnv = "AWS_WINDOWS_SUBNET_ID"
+	// securityGroupIDsEnv is the environment variable holding the comma separated IDs of the security groups of the
+	// Windows instances
+	securityGroupIDsEnv = "AWS_WINDOWS_SECURITY_GROUP_IDS"
+	// iamInstanceProfileEnv is the environment variable holding the name of the IAM instance profile of the Windows
+	// instances
+	iamInstanceProfileEnv = "AWS_WINDOWS_IAM_INSTANCE_PROFILE"
+	// tagsEnv is the environment variable holding the comma separated key=value tags added to the Windows instances
+	tagsEnv = "AWS_WINDOWS_TAGS"
+	// spotEnv is the environment variable enabling the creation of the Windows instances as spot instances
+	spotEnv = "AWS_WINDOWS_SPOT"
+	// spotMaxPriceEnv is the environment variable holding the maximum hourly price paid for the spot instances
+	spotMaxPriceEnv = "AWS_WINDOWS_SPOT_MAX_PRICE"
+	// imageIDEnv is the environment variable holding the ID of the AMI the Windows instances are created with
+	imageIDEnv = "AWS_WINDOWS_AMI_ID"
+	// metadataAuthorizationEnv is the environment variable holding whether the instance metadata service of the
+	// Windows instances requires session tokens, that is IMDSv2
+	metadataAuthorizationEnv = "AWS_WINDOWS_METADATA_AUTHORIZATION"
+	// availabilityZoneEnv is the environment variable holding the availability zone the Windows instances are created
+	// in
+	availabilityZoneEnv = "AWS_WINDOWS_AVAILABILITY_ZONE"
+	// placementGroupEnv is the environment variable holding the name of the placement group the Windows instances are
+	// launched in
+	placementGroupEnv = "AWS_WINDOWS_PLACEMENT_GROUP"
+)
+
+const (
+	// MetadataAuthorizationRequired requires the requests to the instance metadata service to be authorized with a
+	// session token, only allowing IMDSv2 as required by the hardened AWS accounts
+	MetadataAuthorizationRequired = "Required"
+	// MetadataAuthorizationOptional allows both IMDSv1 and IMDSv2 requests to the instance metadata service
+	MetadataAuthorizationOptional = "Optional"
+)
+
+// Options holds the AWS resources the Windows instances are created with. The resources that are not set are
+// discovered from the OpenShift cluster, so that the Windows instances can be placed in a dedicated subnet or account
+// while still being created like the Linux worker nodes by default.
+type Options struct {
+	// ImageID is the ID of the AMI the Windows instances are created with, which pins the image for reproducible test
+	// runs. The latest "Windows Server with Containers" AMI of the Windows Server version is used if it is empty.
+	ImageID string
+	// SubnetID is the ID of the subnet the Windows instances are created in. The private subnet of the cluster in a
+	// zone that supports the instance type is used if it is empty.
+	SubnetID string
+	// AvailabilityZone is the availability zone the Windows instances are created in, in the private subnet of the
+	// cluster in that zone, so that zone aware scheduling can be tested with the Windows nodes. It cannot be set along
+	// with SubnetID, which determines the zone.
+	AvailabilityZone string
+	// PlacementGroupName is the name of an existing placement group the Windows instances are launched in
+	PlacementGroupName string
+	// SecurityGroupIDs are the IDs of the security groups of the Windows instances. The worker security group of the
+	// cluster is used if it is empty.
+	SecurityGroupIDs []string
+	// IAMInstanceProfile is the name of the IAM instance profile of the Windows instances. The worker instance profile
+	// of the cluster is used if it is empty.
+	IAMInstanceProfile string
+	// Tags are the tags added to the Windows instances, on top of the ones added by the machine API
+	Tags map[string]string
+	// Spot creates the Windows instances as spot instances, which are considerably cheaper than on-demand instances.
+	// If the spot instances cannot be created, they are placed in the other zones supporting the instance type in
+	// turn, and on-demand instances are created once every zone has been tried.
+	Spot bool
+	// SpotMaxPrice is the maximum hourly price in USD paid for the spot instances. The on-demand price is used if it is
+	// empty.
+	SpotMaxPrice string
+	// MetadataAuthorization is either MetadataAuthorizationRequired, the default if it is empty, for the instance
+	// metadata service of the Windows instances to only accept IMDSv2 requests, or MetadataAuthorizationOptional
+	MetadataAuthorization string
+}
+
+// OptionsFromEnv returns the options set in the AWS_WINDOWS_AMI_ID, AWS_WINDOWS_SUBNET_ID,
+// AWS_WINDOWS_AVAILABILITY_ZONE, AWS_WINDOWS_PLACEMENT_GROUP, AWS_WINDOWS_SECURITY_GROUP_IDS,
+// AWS_WINDOWS_IAM_INSTANCE_PROFILE, AWS_WINDOWS_TAGS, AWS_WINDOWS_SPOT, AWS_WINDOWS_SPOT_MAX_PRICE and
+// AWS_WINDOWS_METADATA_AUTHORIZATION environment variables
+func OptionsFromEnv() (Options, error) {
+	options := Options{
+		ImageID:            strings.TrimSpace(os.Getenv(imageIDEnv)),
+		SubnetID:           strings.TrimSpace(os.Getenv(subnetIDEnv)),
+		AvailabilityZone:   strings.TrimSpace(os.Getenv(availabilityZoneEnv)),
+		PlacementGroupName: strings.TrimSpace(os.Getenv(placementGroupEnv)),
+		SecurityGroupIDs:   splitList(os.Getenv(securityGroupIDsEnv)),
+		IAMInstanceProfile: strings.TrimSpace(os.Getenv(iamInstanceProfileEnv)),
+		SpotMaxPrice:       strings.TrimSpace(os.Getenv(spotMaxPriceEnv)),
+	}
+	if options.SubnetID != "" && options.AvailabilityZone != "" {
+		return Options{}, fmt.Errorf("%s and %s cannot both be set, the zone is the one of the subnet", subnetIDEnv,
+			availabilityZoneEnv)
+	}
+	switch authorization := strings.TrimSpace(os.Getenv(metadataAuthorizationEnv)); {
+	case authorization == "" || strings.EqualFold(authorization, MetadataAuthorizationRequired):
+		options.MetadataAuthorization = MetadataAuthorizationRequired
+	case strings.EqualFold(authorization, MetadataAuthorizationOptional):
+		options.MetadataAuthorization = MetadataAuthorizationOptional
+	default:
+		return Options{}, fmt.Errorf("invalid %s value %q, expected %s or %s", metadataAuthorizationEnv, authorization,
+			MetadataAuthorizationRequired, MetadataAuthorizationOptional)
+	}
+	if spot := strings.TrimSpace(os.Getenv(spotEnv)); spot != "" {
+		var err error
+		if options.Spot, err = strconv.ParseBool(spot); err != nil {
+			return Options{}, fmt.Errorf("invalid %s value %q: %v", spotEnv, spot, err)
+		}
+	}
+	if options.SpotMaxPrice != "" {
+		if !options.Spot {
+			return Options{}, fmt.Errorf("%s requires %s to be set", spotMaxPriceEnv, spotEnv)
+		}
+		if price, err := strconv.ParseFloat(options.SpotMaxPrice, 64); err != nil || price <= 0 {
+			return Options{}, fmt.Errorf("invalid %s value %q, expected a price in USD", spotMaxPriceEnv,
+				options.SpotMaxPrice)
+		}
+	}
+
+	tags := splitList(os.Getenv(tagsEnv))
+	if len(tags) == 0 {
+		return options, nil
+	}
+	options.Tags = make(map[string]string, len(tags))
+	for _, tag := range tags {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return Options{}, fmt.Errorf("invalid tag %q in %s, expected key=value", tag, tagsEnv)
+		}
+		options.Tags[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return options, nil
+}
+
+// splitList returns the non empty elements of the given comma separated list
+func splitList(list string) []string {
+	var elements []string
+	for _, element := range strings.Split(list, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
+// tagSpecifications returns the tags of the options as machine API tag specifications, sorted by key so that the
+// generated MachineSet is deterministic
+func (o Options) tagSpecifications() []awsprovider.TagSpecification {
+	keys := make([]string, 0, len(o.Tags))
+	for key := range o.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var tags []awsprovider.TagSpecification
+	for _, key := range keys {
+		tags = append(tags, awsprovider.TagSpecification{Name: key, Value: o.Tags[key]})
+	}
+	return tags
+}
+
+// metadataServiceOptions returns the instance metadata service options of the machine API provider spec of the
+// Windows instances
+func (o Options) metadataServiceOptions() metadataServiceOptions {
+	authorization := o.MetadataAuthorization
+	if authorization == "" {
+		authorization = MetadataAuthorizationRequired
+	}
+	return metadataServiceOptions{Authorization: authorization}
+}
@@ -0,0 +1,277 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	mapi "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	machine "github.com/openshift/machine-api-operator/pkg/generated/clientset/versioned/typed/machine/v1beta1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/clusterinfo"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/userdata"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/windows"
+)
+
+const (
+	// windowsLabel is the label added to identify windows machine objects
+	windowsLabel = "machine.openshift.io/os-id"
+	// machineAPINamespace is the namespace in which the MachineSets are present
+	machineAPINamespace = "openshift-machine-api"
+	// vmSize is the Azure specific VM size to create the VM with
+	vmSize = "Standard_D2s_v3"
+	// imageVersionEnv is the environment variable holding the version of the Azure Marketplace image the Windows VMs
+	// are created with
+	imageVersionEnv = "AZURE_WINDOWS_IMAGE_VERSION"
+	// zoneEnv is the environment variable holding the availability zone the Windows VMs are created in
+	zoneEnv = "AZURE_WINDOWS_ZONE"
+)
+
+// windowsImageSKUs maps the supported Windows Server versions to the SKU of their Azure Marketplace "Windows Server
+// with Containers" image
+var windowsImageSKUs = map[string]string{
+	"2019": "2019-Datacenter-with-Containers",
+	"2004": "datacenter-core-2004-with-containers-smalldisk",
+	"20H2": "datacenter-core-20h2-with-containers-smalldisk",
+	// There is no "with Containers" image of Windows Server 2022, which is only meant to be used with containerd
+	"2022": "2022-datacenter-smalldisk",
+}
+
+type azureProvider struct {
+	// openShiftClient is the client of the existing OpenShift cluster.
+	openShiftClient *clusterinfo.OpenShift
+	// machineClient is the client used to get the existing worker MachineSets
+	machineClient *machine.MachineV1beta1Client
+	// vmSize is the flavor of VM to be used
+	vmSize string
+	// image is the Azure Marketplace image the Windows VMs are created with
+	image map[string]interface{}
+	// zone is the availability zone the Windows VMs are created in, the one of the first Linux worker MachineSet if it
+	// is empty
+	zone string
+}
+
+// SetupAzureCloudProvider creates the Azure provider using the current OpenShift cluster. The network, resource group
+// and identity of the Windows VMs are taken from the existing Linux worker MachineSets, so no Azure credentials are
+// required and the Machine API takes care of creating and destroying the NIC and the VM. The Windows VMs are created
+// with the latest Marketplace image of the given Windows Server version, unless the version of the image is pinned with
+// the AZURE_WINDOWS_IMAGE_VERSION environment variable. The Windows VMs are created in the availability zone set in the
+// AZURE_WINDOWS_ZONE environment variable, if any, by taking the network of the worker MachineSet of that zone.
+func SetupAzureCloudProvider(windowsVersion string) (*azureProvider, error) {
+	sku, ok := windowsImageSKUs[windowsVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported Windows Server version %s", windowsVersion)
+	}
+	imageVersion := os.Getenv(imageVersionEnv)
+	if imageVersion == "" {
+		imageVersion = "latest"
+	}
+	log.Printf("Creating Windows VMs with the %s version of the %s image", imageVersion, sku)
+
+	oc, err := clusterinfo.NewOpenShift()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize OpenShift client with error: %v", err)
+	}
+	rc, err := config.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error creating the config object %v", err)
+	}
+	machineClient, err := machine.NewForConfig(rc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to instantiate the machine api client: %v", err)
+	}
+	return &azureProvider{
+		openShiftClient: oc,
+		machineClient:   machineClient,
+		vmSize:          vmSize,
+		image: map[string]interface{}{
+			"publisher":  "MicrosoftWindowsServer",
+			"offer":      "WindowsServer",
+			"sku":        sku,
+			"version":    imageVersion,
+			"resourceID": "",
+		},
+		zone: strings.TrimSpace(os.Getenv(zoneEnv)),
+	}, nil
+}
+
+// getWorkerProviderSpecs returns the provider specs of the existing Linux worker MachineSets of the cluster, in the
+// zone of the Windows VMs if it is set
+func (a *azureProvider) getWorkerProviderSpecs(infraID string) ([]map[string]interface{}, error) {
+	var machineSets *mapi.MachineSetList
+	err := windows.KubeAPIRetryPolicy.Do(context.TODO(), "listing MachineSets", func() error {
+		var err error
+		machineSets, err = a.machineClient.MachineSets(machineAPINamespace).List(context.TODO(), meta.ListOptions{
+			LabelSelector: mapi.MachineClusterIDLabel + "=" + infraID,
+		})
+		return err
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error listing MachineSets: %v", err)
+	}
+	var specs []map[string]interface{}
+	for _, machineSet := range machineSets.Items {
+		if _, ok := machineSet.Spec.Template.Labels[windowsLabel]; ok {
+			continue
+		}
+		if machineSet.Spec.Template.Labels["machine.openshift.io/cluster-api-machine-role"] != "worker" {
+			continue
+		}
+		providerSpec := machineSet.Spec.Template.Spec.ProviderSpec.Value
+		if providerSpec == nil {
+			continue
+		}
+		var spec map[string]interface{}
+		if err := json.Unmarshal(providerSpec.Raw, &spec); err != nil {
+			return nil, fmt.Errorf("error unmarshalling provider spec of MachineSet %s: %v", machineSet.Name, err)
+		}
+		if zone, _ := spec["zone"].(string); a.zone != "" && zone != a.zone {
+			continue
+		}
+		specs = append(specs, spec)
+	}
+	if len(specs) > 0 {
+		return specs, nil
+	}
+	if a.zone != "" {
+		return nil, fmt.Errorf("unable to find a Linux worker MachineSet in zone %s for cluster %s", a.zone, infraID)
+	}
+	return nil, fmt.Errorf("unable to find a Linux worker MachineSet for cluster %s", infraID)
+}
+
+// GenerateUserData generates the user data of the Windows VMs, which is passed to the VMs as custom data by the Machine
+// API. The Windows VMs on Azure are created with the same user data as on AWS.
+func (a *azureProvider) GenerateUserData(authorizedKey string) []byte {
+	return userdata.PowerShellUserData(userdata.SetupScript(authorizedKey))
+}
+
+// GenerateMachineSet generates the machineset object which is azure provider specific
+func (a *azureProvider) GenerateMachineSet(withWindowsLabel bool, replicas int32) (*mapi.MachineSet, error) {
+	clusterName, err := a.openShiftClient.GetInfrastructureID()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get infrastructure id %v", err)
+	}
+
+	providerSpecs, err := a.getWorkerProviderSpecs(clusterName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get worker provider spec: %v", err)
+	}
+	return a.newMachineSet(clusterName, withWindowsLabel, replicas, providerSpecs[0])
+}
+
+// GenerateZonalMachineSets generates a MachineSet per zone of the Linux worker MachineSets of the cluster, sorted by
+// zone, with no replicas. The network of the Windows VMs of a zone is taken from the worker MachineSet of that zone.
+func (a *azureProvider) GenerateZonalMachineSets(withWindowsLabel bool) ([]*mapi.MachineSet, error) {
+	if a.zone != "" {
+		return nil, fmt.Errorf("the Windows VMs cannot be spread across zones with %s set", zoneEnv)
+	}
+	clusterName, err := a.openShiftClient.GetInfrastructureID()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get infrastructure id %v", err)
+	}
+	providerSpecs, err := a.getWorkerProviderSpecs(clusterName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get worker provider spec: %v", err)
+	}
+	zoneSpecs := make(map[string]map[string]interface{})
+	var zones []string
+	for _, spec := range providerSpecs {
+		zone, _ := spec["zone"].(string)
+		if _, ok := zoneSpecs[zone]; !ok {
+			zoneSpecs[zone] = spec
+			zones = append(zones, zone)
+		}
+	}
+	sort.Strings(zones)
+
+	var machineSets []*mapi.MachineSet
+	for _, zone := range zones {
+		machineSet, err := a.newMachineSet(clusterName, withWindowsLabel, 0, zoneSpecs[zone])
+		if err != nil {
+			return nil, err
+		}
+		machineSets = append(machineSets, machineSet)
+	}
+	log.Printf("Spreading the Windows VMs across zones %s", strings.Join(zones, ", "))
+	return machineSets, nil
+}
+
+// newMachineSet returns the MachineSet of the given number of Windows VMs created with the given provider spec of a
+// Linux worker MachineSet
+func (a *azureProvider) newMachineSet(clusterName string, withWindowsLabel bool, replicas int32,
+	providerSpec map[string]interface{}) (*mapi.MachineSet, error) {
+	// Replace the Linux specific fields of the worker provider spec with the Windows ones
+	providerSpec["image"] = a.image
+	providerSpec["vmSize"] = a.vmSize
+	providerSpec["publicIP"] = false
+	providerSpec["userDataSecret"] = map[string]interface{}{"name": "windows-user-data"}
+	osDisk, ok := providerSpec["osDisk"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("osDisk not found in worker provider spec")
+	}
+	osDisk["osType"] = "Windows"
+	osDisk["diskSizeGB"] = 128
+
+	// Azure limits the Windows computer name, which is derived from the Machine name, to 15 characters
+	machineSetName := "e2e-win-"
+	matchLabels := map[string]string{
+		"machine.openshift.io/cluster-api-cluster": clusterName,
+	}
+
+	if withWindowsLabel {
+		matchLabels[windowsLabel] = "Windows"
+		machineSetName = machineSetName + "l-"
+	}
+	machineSetName = machineSetName + rand.String(4)
+	matchLabels["machine.openshift.io/cluster-api-machineset"] = machineSetName
+
+	machineLabels := map[string]string{
+		"machine.openshift.io/cluster-api-machine-role": "worker",
+		"machine.openshift.io/cluster-api-machine-type": "worker",
+	}
+	// append matchlabels to machinelabels
+	for k, v := range matchLabels {
+		machineLabels[k] = v
+	}
+
+	rawBytes, err := json.Marshal(providerSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set up the test machineSet
+	machineSet := &mapi.MachineSet{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      machineSetName,
+			Namespace: machineAPINamespace,
+			Labels: map[string]string{
+				mapi.MachineClusterIDLabel: clusterName,
+			},
+		},
+		Spec: mapi.MachineSetSpec{
+			Selector: meta.LabelSelector{
+				MatchLabels: matchLabels,
+			},
+			Replicas: &replicas,
+			Template: mapi.MachineTemplateSpec{
+				ObjectMeta: mapi.ObjectMeta{Labels: machineLabels},
+				Spec: mapi.MachineSpec{
+					ProviderSpec: mapi.ProviderSpec{
+						Value: &runtime.RawExtension{
+							Raw: rawBytes,
+						},
+					},
+				},
+			},
+		},
+	}
+	return machineSet, nil
+}
@@ -0,0 +1,277 @@
+// Package cli holds the command line skeleton shared by the tools creating the Windows instances wsu bootstraps, like
+// gcp-windows or libvirt-windows: their create, destroy and list commands, the instances file and Ansible inventory the
+// created instances are written to, and the cluster the instances are created for. The instances file is the
+// inventory of the created instances, which the destroy command garbage collects with --all or --older-than.
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+	configclient "github.com/openshift/client-go/config/clientset/versioned"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/clusterinfo"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/wsu"
+)
+
+const (
+	// Create is the command creating the Windows instances
+	Create = "create"
+	// Destroy is the command destroying the Windows instances
+	Destroy = "destroy"
+	// List is the command listing the Windows instances of the instances file
+	List = "list"
+)
+
+// Tool is a command line tool creating Windows instances and adding them to the windows-node-installer.json file wsu
+// bootstraps them from, or destroying them
+type Tool struct {
+	// Command is the command given on the command line
+	Command string
+	// Flags are the flags of the command. The tool adds its own flags before calling Parse.
+	Flags *flag.FlagSet
+	// name is the name of the tool, recorded as the provider of the created Windows instances
+	name string
+	// instances names the Windows instances of the tool in the messages, like instances or VMs
+	instances string
+	// instancesFile is the file the created Windows instances are added to
+	instancesFile *string
+	// inventoryFile is the Ansible inventory of the WSU playbook written after creating the Windows instances, if set
+	inventoryFile *string
+	// all destroys all the Windows instances of the tool recorded in the instances file. It is only set for Destroy.
+	all *bool
+	// olderThan destroys the Windows instances of the tool recorded in the instances file that were created before
+	// this duration, if set. It is only set for Destroy.
+	olderThan *time.Duration
+}
+
+// Commands are the functions of a tool implementing its commands
+type Commands struct {
+	// Create creates the Windows instances within CreateTimeout, and returns them as they are saved to the instances
+	// file
+	Create func(ctx context.Context) ([]wsu.Instance, error)
+	// CreateTimeout is the time the Windows instances have to be created
+	CreateTimeout time.Duration
+	// Destroy destroys the Windows instances, or the resources they are created from, and returns their names
+	Destroy func(ctx context.Context) ([]string, error)
+	// Destroyed names what Destroy destroys in the messages, like instances or MachineSets
+	Destroyed string
+	// DestroyInstances destroys the Windows instances with the given IDs, and returns the given IDs of the destroyed
+	// instances and of the instances that do not exist anymore. The tool does not support --all and --older-than if
+	// it is not set.
+	DestroyInstances func(ctx context.Context, ids []string) ([]string, error)
+	// Scope identifies where the tool creates the Windows instances, like the project and label of the cluster, and is
+	// recorded with the created instances. --all and --older-than only destroy the instances of the same scope.
+	Scope string
+	// ClusterAddress returns the address of the cluster written to the Ansible inventory
+	ClusterAddress func() (string, error)
+}
+
+// NewTool returns the Tool of the command given on the command line, with the flags common to the tools. The tool
+// exits with its usage if no command is given. name is the name of the tool, like gcp-windows, and instances names its
+// Windows instances, like instances or VMs.
+func NewTool(name, instances string) *Tool {
+	if len(os.Args) < 2 || (os.Args[1] != Create && os.Args[1] != Destroy && os.Args[1] != List) {
+		log.Fatalf("usage: %s %s|%s|%s [flags]", os.Args[0], Create, Destroy, List)
+	}
+	flags := flag.NewFlagSet(os.Args[0]+" "+os.Args[1], flag.ExitOnError)
+	tool := &Tool{
+		Command:   os.Args[1],
+		Flags:     flags,
+		name:      name,
+		instances: instances,
+		instancesFile: flags.String("instances-file", "windows-node-installer.json",
+			fmt.Sprintf("File the created Windows %s are added to", instances)),
+		inventoryFile: flags.String("inventory-file", "", fmt.Sprintf("Ansible inventory of the WSU playbook "+
+			"that is written with the Windows %s of --instances-file and the cluster address, if set", instances)),
+	}
+	if tool.Command == Destroy {
+		tool.all = flags.Bool("all", false, fmt.Sprintf("Destroy all the Windows %s of --instances-file created "+
+			"by %s, instead of the tagged ones", instances, name))
+		tool.olderThan = flags.Duration("older-than", 0, fmt.Sprintf("Destroy the Windows %s of "+
+			"--instances-file created by %s before this duration, like 24h, instead of the tagged ones", instances,
+			name))
+	}
+	return tool
+}
+
+// KubeconfigFlag adds the flag of the kubeconfig of the cluster the Windows instances are created for
+func (t *Tool) KubeconfigFlag() *string {
+	return t.Flags.String("kubeconfig", os.Getenv("KUBECONFIG"),
+		fmt.Sprintf("Kubeconfig of the cluster the Windows %s are created for. Defaults to $KUBECONFIG", t.instances))
+}
+
+// Parse parses the flags of the command. The list command only reads the instances file, so the tool exits after
+// running it, before the flags of the tool are used.
+func (t *Tool) Parse() {
+	t.Flags.Parse(os.Args[2:])
+	switch t.Command {
+	case List:
+		if err := t.list(); err != nil {
+			log.Fatalf("error listing Windows %s: %v", t.instances, err)
+		}
+		os.Exit(0)
+	case Destroy:
+		if *t.all && *t.olderThan != 0 {
+			log.Fatal("--all and --older-than cannot be used together")
+		}
+		if *t.olderThan < 0 {
+			log.Fatal("--older-than needs to be positive")
+		}
+	}
+}
+
+// list prints the Windows instances of the instances file with their inventory
+func (t *Tool) list() error {
+	instances, err := wsu.LoadInstances(*t.instancesFile)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE ID\tADDRESS\tPROVIDER\tCREATED\tSECURITY GROUPS\tKEY PAIR")
+	for _, instance := range instances {
+		created := ""
+		if instance.CreationTime != nil {
+			created = instance.CreationTime.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", instance.InstanceID, instance.IPAddress, instance.Provider,
+			created, strings.Join(instance.SecurityGroups, ","), instance.KeyPair)
+	}
+	return w.Flush()
+}
+
+// WritesInventory returns true if the Ansible inventory is written after creating the Windows instances
+func (t *Tool) WritesInventory() bool {
+	return *t.inventoryFile != ""
+}
+
+// Run runs the command with the given commands, exiting on failure. Interrupting the tool aborts waiting on the
+// Windows instances.
+func (t *Tool) Run(commands Commands) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	if t.Command == Destroy && (*t.all || *t.olderThan != 0) {
+		if commands.DestroyInstances == nil {
+			log.Fatalf("--all and --older-than are not supported by %s", t.name)
+		}
+		instances, err := wsu.LoadInstances(*t.instancesFile)
+		if err != nil {
+			log.Fatalf("error reading the Windows %s to destroy: %v", t.instances, err)
+		}
+		ids := selectInstances(instances, t.name, commands.Scope, *t.olderThan, time.Now())
+		destroyed, err := commands.DestroyInstances(ctx, ids)
+		log.Printf("destroyed %s %v", t.instances, destroyed)
+		// The instances that failed to be destroyed are kept in the instances file to be destroyed by the next run
+		if removeErr := wsu.RemoveInstances(*t.instancesFile, destroyed); removeErr != nil {
+			log.Fatalf("error removing the destroyed Windows %s: %v", t.instances, removeErr)
+		}
+		if err != nil {
+			log.Fatalf("error destroying Windows %s: %v", t.instances, err)
+		}
+		return
+	}
+	if t.Command == Destroy {
+		destroyed, err := commands.Destroy(ctx)
+		log.Printf("destroyed %s %v", commands.Destroyed, destroyed)
+		if err != nil {
+			log.Fatalf("error destroying %s: %v", commands.Destroyed, err)
+		}
+		return
+	}
+
+	ctx, cancelCreate := context.WithTimeout(ctx, commands.CreateTimeout)
+	defer cancelCreate()
+	instances, err := commands.Create(ctx)
+	if err != nil {
+		log.Fatalf("error creating Windows %s: %v", t.instances, err)
+	}
+	created := time.Now().UTC().Truncate(time.Second)
+	for _, instance := range instances {
+		instance.Provider = t.name
+		instance.Scope = commands.Scope
+		instance.CreationTime = &created
+		if err = wsu.SaveInstance(*t.instancesFile, instance); err != nil {
+			log.Fatalf("error saving Windows instance %s: %v", instance.InstanceID, err)
+		}
+	}
+	if t.WritesInventory() {
+		clusterAddress, err := commands.ClusterAddress()
+		if err != nil {
+			log.Fatalf("error getting the cluster address: %v", err)
+		}
+		if err = wsu.WriteInventory(*t.instancesFile, *t.inventoryFile, clusterAddress); err != nil {
+			log.Fatalf("error writing Ansible inventory: %v", err)
+		}
+	}
+}
+
+// selectInstances returns the IDs of the given instances created by the given provider in the given scope, only
+// keeping the instances created before olderThan if it is set. The instances without a creation time are only
+// selected if olderThan is not set, as their age is unknown.
+func selectInstances(instances []wsu.Instance, provider, scope string, olderThan time.Duration,
+	now time.Time) []string {
+	var ids []string
+	for _, instance := range instances {
+		if instance.Provider != provider || instance.Scope != scope {
+			continue
+		}
+		if olderThan != 0 && (instance.CreationTime == nil || now.Sub(*instance.CreationTime) < olderThan) {
+			continue
+		}
+		ids = append(ids, instance.InstanceID)
+	}
+	return ids
+}
+
+// Cluster is the OpenShift cluster the Windows instances are created for
+type Cluster struct {
+	// RESTConfig is the config of the clients of the cluster
+	RESTConfig *rest.Config
+	// Platform is the platform the cluster runs on
+	Platform *configv1.PlatformStatus
+	// InfrastructureName is the name identifying the cloud resources of the cluster
+	InfrastructureName string
+}
+
+// GetCluster returns the cluster of the given kubeconfig, as read from its Infrastructure object
+func GetCluster(ctx context.Context, kubeconfig string) (*Cluster, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build config from kubeconfig: %v", err)
+	}
+	client, err := configclient.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get OpenShift config client: %v", err)
+	}
+	platform, err := clusterinfo.GetPlatformStatus(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the platform of the cluster: %v", err)
+	}
+	infraName, err := (&clusterinfo.OpenShift{Client: client}).GetInfrastructureID()
+	if err != nil {
+		return nil, fmt.Errorf("error getting the infrastructure name of the cluster: %v", err)
+	}
+	return &Cluster{RESTConfig: restConfig, Platform: platform, InfrastructureName: infraName}, nil
+}
+
+// Address returns the address of the cluster written to the Ansible inventory, read from its API server URL
+func (c *Cluster) Address() (string, error) {
+	return wsu.ClusterAddress(c.RESTConfig.Host)
+}
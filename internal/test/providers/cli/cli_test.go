@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/wsu"
+)
+
+// TestSelectInstances tests that --all and --older-than only select the instances of the tool and scope, by age
+func TestSelectInstances(t *testing.T) {
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
+	created := func(age time.Duration) *time.Time {
+		creationTime := now.Add(-age)
+		return &creationTime
+	}
+	instances := []wsu.Instance{
+		{InstanceID: "old", Provider: "gcp-windows", Scope: "project/infra", CreationTime: created(48 * time.Hour)},
+		{InstanceID: "recent", Provider: "gcp-windows", Scope: "project/infra", CreationTime: created(time.Hour)},
+		{InstanceID: "unknown-age", Provider: "gcp-windows", Scope: "project/infra"},
+		{InstanceID: "other-cluster", Provider: "gcp-windows", Scope: "project/other",
+			CreationTime: created(48 * time.Hour)},
+		{InstanceID: "other-tool", Provider: "libvirt-windows", Scope: "project/infra",
+			CreationTime: created(48 * time.Hour)},
+		{InstanceID: "manual"},
+	}
+
+	assert.Equal(t, []string{"old", "recent", "unknown-age"},
+		selectInstances(instances, "gcp-windows", "project/infra", 0, now))
+	assert.Equal(t, []string{"old"}, selectInstances(instances, "gcp-windows", "project/infra", 24*time.Hour, now))
+	assert.Empty(t, selectInstances(instances, "gcp-windows", "project/infra", 72*time.Hour, now))
+	assert.Empty(t, selectInstances(instances, "ibmcloud-windows", "project/infra", 0, now))
+}
@@ -1,7 +1,12 @@
 package providers
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/openshift/api/config/v1"
 	mapi "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
@@ -9,26 +14,194 @@ import (
 
 	oc "github.com/openshift/windows-machine-config-bootstrapper/internal/test/clusterinfo"
 	awsProvider "github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/aws"
+	azureProvider "github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers/azure"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/windows"
+)
+
+const (
+	// windowsVersionEnv is the environment variable holding the Windows Server version of the images the Windows VMs
+	// are created with
+	windowsVersionEnv = "WINDOWS_SERVER_VERSION"
+	// defaultWindowsVersion is the Windows Server version used if windowsVersionEnv is not set
+	defaultWindowsVersion = "2019"
 )
 
 type CloudProvider interface {
 	GenerateMachineSet(bool, int32) (*mapi.MachineSet, error)
+	// GenerateUserData generates the user data the Windows VMs are created with, which enables ssh authorizing the
+	// given public key and WinRM over HTTPS on the VMs
+	GenerateUserData(string) []byte
+}
+
+// ReadinessCloudProvider is implemented by the cloud providers that can tell when a Windows VM has booted, before it
+// can be accessed over ssh or WinRM. The Azure provider does not implement it, as the state of the VM agent cannot be
+// read without Azure credentials.
+type ReadinessCloudProvider interface {
+	// ReadinessProbes returns the probes checking that the VM with the given instance ID has booted
+	ReadinessProbes(string) []windows.ReadinessProbe
+}
+
+// ImageCloudProvider is implemented by the cloud providers that can create an image of a Windows VM, from which the
+// Windows VMs of the following test runs can be created
+type ImageCloudProvider interface {
+	// CreateImage creates an image with the given name from the VM with the given instance ID and returns the ID of
+	// the image
+	CreateImage(string, string) (string, error)
+}
+
+// RebootCloudProvider is implemented by the cloud providers that can reboot a Windows VM through their API, which works
+// even if the VM cannot be accessed over ssh or WinRM
+type RebootCloudProvider interface {
+	// Reboot reboots the VM with the given instance ID
+	Reboot(context.Context, string) error
+}
+
+// NetworkCloudProvider is implemented by the cloud providers that can check that the network of the cluster allows the
+// traffic the Windows nodes need. The Azure provider does not implement it, as the network security groups cannot be
+// read without Azure credentials.
+type NetworkCloudProvider interface {
+	// ValidateNetwork returns an error if the nodes of the cluster cannot reach the hybrid overlay VXLAN and kubelet
+	// ports of the Windows VMs, as well as their WinRM port if the first argument is true. The missing rules are added
+	// instead if the second argument is true.
+	ValidateNetwork(bool, bool) error
+}
+
+// SpotCloudProvider is implemented by the cloud providers that can create the Windows VMs as spot instances, which are
+// cheaper but cannot always be created
+type SpotCloudProvider interface {
+	CloudProvider
+	// IsSpotCapacityError returns true if the given error message of a Machine indicates that its spot instance could
+	// not be created for lack of spot capacity
+	IsSpotCapacityError(string) bool
+	// FallBack changes the MachineSets generated next to create VMs where they are more likely to be created after the
+	// spot instances of the previous MachineSet could not be created, eventually creating on-demand instances. It
+	// returns false if there is nothing left to fall back to.
+	FallBack() bool
+}
+
+// ZonalCloudProvider is implemented by the cloud providers that can spread the Windows VMs across the zones the worker
+// nodes of the cluster run in, so that zone aware scheduling can be tested with the Windows nodes
+type ZonalCloudProvider interface {
+	CloudProvider
+	// GenerateZonalMachineSets generates a MachineSet per zone of the worker nodes, sorted by zone, with no replicas.
+	// The replicas are then spread across them with SpreadReplicas.
+	GenerateZonalMachineSets(bool) ([]*mapi.MachineSet, error)
 }
 
-func NewCloudProvider(sshKeyPair string) (CloudProvider, error) {
+// SpreadReplicas spreads the given number of replicas round-robin across the given MachineSets, in order, and returns
+// the MachineSets that got at least one replica
+func SpreadReplicas(machineSets []*mapi.MachineSet, replicas int32) []*mapi.MachineSet {
+	if len(machineSets) == 0 {
+		return nil
+	}
+	counts := make([]int32, len(machineSets))
+	for i := int32(0); i < replicas; i++ {
+		counts[int(i)%len(machineSets)]++
+	}
+	var spread []*mapi.MachineSet
+	for i, machineSet := range machineSets {
+		if counts[i] == 0 {
+			continue
+		}
+		count := counts[i]
+		machineSet.Spec.Replicas = &count
+		spread = append(spread, machineSet)
+	}
+	return spread
+}
+
+// Factory creates the cloud provider of a cluster running on the platform with the given status. The Windows VMs
+// created by the cloud provider are accessed using the given key pair and run the given Windows Server version.
+type Factory func(platform *v1.PlatformStatus, sshKeyPair, windowsVersion string) (CloudProvider, error)
+
+var (
+	// factories holds the factories of the registered cloud providers by the platform they support
+	factories = make(map[v1.PlatformType]Factory)
+	// factoriesLock protects factories, as cloud providers can be registered concurrently
+	factoriesLock sync.RWMutex
+)
+
+func init() {
+	Register(v1.AWSPlatformType, func(platform *v1.PlatformStatus, sshKeyPair, windowsVersion string) (CloudProvider,
+		error) {
+		if platform.AWS == nil {
+			return nil, fmt.Errorf("AWS platform status is missing")
+		}
+		// Setup the AWS cloud provider in the same region where the cluster is running. The error is checked so that
+		// a nil provider is not returned as a non nil CloudProvider.
+		provider, err := awsProvider.SetupAWSCloudProvider(platform.AWS.Region, sshKeyPair, windowsVersion)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	})
+	Register(v1.AzurePlatformType, func(_ *v1.PlatformStatus, _, windowsVersion string) (CloudProvider, error) {
+		// The Azure VMs are accessed using the SSH key given in the user data, so the key pair is not required
+		provider, err := azureProvider.SetupAzureCloudProvider(windowsVersion)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	})
+}
+
+// Register makes the cloud provider created by the given factory available for the clusters running on the given
+// platform. It is meant to be called from the init function of the packages implementing cloud providers out of tree,
+// and panics if a cloud provider is already registered for the platform.
+func Register(platform v1.PlatformType, factory Factory) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	if factory == nil {
+		panic(fmt.Sprintf("nil factory registered for the '%v' cloud provider", platform))
+	}
+	if _, ok := factories[platform]; ok {
+		panic(fmt.Sprintf("the '%v' cloud provider is registered twice", platform))
+	}
+	factories[platform] = factory
+}
+
+// RegisteredPlatforms returns the sorted platforms cloud providers are registered for
+func RegisteredPlatforms() []string {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+	platforms := make([]string, 0, len(factories))
+	for platform := range factories {
+		platforms = append(platforms, string(platform))
+	}
+	sort.Strings(platforms)
+	return platforms
+}
+
+// DefaultWindowsVersion returns the Windows Server version of the images the Windows VMs are created with if none is
+// given, which is read from the WINDOWS_SERVER_VERSION environment variable
+func DefaultWindowsVersion() string {
+	if windowsVersion := os.Getenv(windowsVersionEnv); windowsVersion != "" {
+		return windowsVersion
+	}
+	return defaultWindowsVersion
+}
+
+// NewCloudProvider returns the cloud provider registered for the platform of the cluster, as detected from the
+// infrastructure object of the cluster. The Windows VMs are created with the images of the given Windows Server
+// version, or of DefaultWindowsVersion if it is empty.
+func NewCloudProvider(sshKeyPair, windowsVersion string) (CloudProvider, error) {
 	openshift, err := oc.NewOpenShift()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get OpenShift client failed")
 	}
-	cloudProvider, err := openshift.GetCloudProvider()
+	platform, err := openshift.GetCloudProvider()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get cloud provider type")
 	}
-	switch provider := cloudProvider.Type; provider {
-	case v1.AWSPlatformType:
-		// 	Setup the AWS cloud provider in the same region where the cluster is running
-		return awsProvider.SetupAWSCloudProvider(cloudProvider.AWS.Region, sshKeyPair)
-	default:
-		return nil, fmt.Errorf("the '%v' cloud provider is not supported", provider)
+	if windowsVersion == "" {
+		windowsVersion = DefaultWindowsVersion()
+	}
+	factoriesLock.RLock()
+	factory, ok := factories[platform.Type]
+	factoriesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("the '%v' cloud provider is not supported, supported cloud providers are %s",
+			platform.Type, strings.Join(RegisteredPlatforms(), ", "))
 	}
+	return factory(platform, sshKeyPair, windowsVersion)
 }
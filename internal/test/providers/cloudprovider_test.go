@@ -0,0 +1,44 @@
+package providers
+
+import (
+	"testing"
+
+	mapi "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSpreadReplicas(t *testing.T) {
+	newMachineSets := func(zones ...string) []*mapi.MachineSet {
+		var machineSets []*mapi.MachineSet
+		for _, zone := range zones {
+			machineSets = append(machineSets, &mapi.MachineSet{ObjectMeta: meta.ObjectMeta{Name: zone}})
+		}
+		return machineSets
+	}
+	tests := []struct {
+		name     string
+		zones    []string
+		replicas int32
+		expected map[string]int32
+	}{
+		{name: "no zone", replicas: 2, expected: map[string]int32{}},
+		{name: "single zone", zones: []string{"a"}, replicas: 3, expected: map[string]int32{"a": 3}},
+		{name: "as many replicas as zones", zones: []string{"a", "b", "c"}, replicas: 3,
+			expected: map[string]int32{"a": 1, "b": 1, "c": 1}},
+		{name: "more replicas than zones", zones: []string{"a", "b", "c"}, replicas: 5,
+			expected: map[string]int32{"a": 2, "b": 2, "c": 1}},
+		{name: "fewer replicas than zones", zones: []string{"a", "b", "c"}, replicas: 2,
+			expected: map[string]int32{"a": 1, "b": 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spread := SpreadReplicas(newMachineSets(test.zones...), test.replicas)
+			replicas := make(map[string]int32)
+			for _, machineSet := range spread {
+				replicas[machineSet.Name] = *machineSet.Spec.Replicas
+			}
+			assert.Equal(t, test.expected, replicas)
+		})
+	}
+}
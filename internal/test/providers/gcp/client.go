@@ -0,0 +1,300 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/windows"
+)
+
+const (
+	// computeURLFormat is the format of the endpoint of the Compute Engine API of a project
+	computeURLFormat = "https://compute.googleapis.com/compute/v1/projects/%s"
+	// defaultTokenURL is the endpoint exchanging a signed JWT for an OAuth2 access token if the service account key does
+	// not specify it
+	defaultTokenURL = "https://oauth2.googleapis.com/token"
+	// computeScope is the OAuth2 scope of the access tokens
+	computeScope = "https://www.googleapis.com/auth/compute"
+	// requestTimeout is the timeout of the individual requests made to the GCP APIs
+	requestTimeout = time.Minute
+	// tokenLifetime is the lifetime of the access tokens requested
+	tokenLifetime = time.Hour
+	// tokenExpiryMargin is the time before its expiry after which an access token is renewed
+	tokenExpiryMargin = 5 * time.Minute
+)
+
+// serviceAccountKey is the JSON key of a GCP service account
+type serviceAccountKey struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// client makes authenticated requests to the Compute Engine API. The GCP SDKs are not vendored, the REST API being
+// simple enough to be called directly. The requests are authenticated with OAuth2 access tokens requested with a JWT
+// signed by the key of a service account.
+type client struct {
+	// key is the service account key the access tokens are requested with
+	key serviceAccountKey
+	// signer is the private key of the service account
+	signer *rsa.PrivateKey
+	// computeURL is the endpoint of the Compute Engine API of the project the resources are managed in
+	computeURL string
+	// httpClient makes the requests
+	httpClient *http.Client
+	// token is the current access token
+	token string
+	// tokenExpiry is the time after which the token needs to be renewed
+	tokenExpiry time.Time
+	// tokenLock protects token and tokenExpiry
+	tokenLock sync.Mutex
+}
+
+// apiError is the error body returned by the GCP APIs
+type apiError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Errors  []struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"error"`
+}
+
+// statusError is the error returned for a request the API failed with the given HTTP status code
+type statusError struct {
+	// code is the HTTP status code of the response
+	code int
+	// message describes the error
+	message string
+}
+
+// Error returns the description of the error
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// isNotFound returns true if the given error is returned for a resource that does not exist
+func isNotFound(err error) bool {
+	statusErr, ok := err.(*statusError)
+	return ok && statusErr.code == http.StatusNotFound
+}
+
+// isRetryable returns the function classifying the errors of the requests with the given HTTP method that are retried
+func isRetryable(method string) func(error) bool {
+	return func(err error) bool {
+		statusCode := 0
+		if statusErr, ok := err.(*statusError); ok {
+			statusCode = statusErr.code
+		}
+		return windows.IsRetryableHTTPError(method, statusCode, err)
+	}
+}
+
+// newClient returns a client for the Compute Engine API of the given project, authenticated with the given JSON key
+// of a service account. The project of the service account is used if the given project is empty.
+func newClient(keyJSON []byte, project string) (*client, error) {
+	var key serviceAccountKey
+	if err := json.Unmarshal(keyJSON, &key); err != nil {
+		return nil, fmt.Errorf("invalid service account key: %v", err)
+	}
+	if key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, fmt.Errorf("the service account key has no client email or private key")
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = defaultTokenURL
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("the private key of the service account key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key of service account %s: %v", key.ClientEmail, err)
+	}
+	signer, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the private key of service account %s is not an RSA key", key.ClientEmail)
+	}
+	if project == "" {
+		project = key.ProjectID
+	}
+	if project == "" {
+		return nil, fmt.Errorf("a project is required")
+	}
+	return &client{
+		key:        key,
+		signer:     signer,
+		computeURL: fmt.Sprintf(computeURLFormat, project),
+		httpClient: &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+// signedJWT returns the JWT asserting the identity of the service account, which is exchanged for an access token
+func (c *client) signedJWT(now time.Time) (string, error) {
+	encode := func(v interface{}) (string, error) {
+		content, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return base64.RawURLEncoding.EncodeToString(content), nil
+	}
+	header, err := encode(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := encode(map[string]interface{}{
+		"iss":   c.key.ClientEmail,
+		"scope": computeScope,
+		"aud":   c.key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(tokenLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(header + "." + claims))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.signer, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("error signing JWT: %v", err)
+	}
+	return header + "." + claims + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// accessToken returns a valid access token, requesting a new one if the current one is about to expire
+func (c *client) accessToken(ctx context.Context) (string, error) {
+	c.tokenLock.Lock()
+	defer c.tokenLock.Unlock()
+	if c.token != "" && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	assertion, err := c.signedJWT(time.Now())
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.key.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err = c.do(req, &token); err != nil {
+		return "", fmt.Errorf("error requesting access token: %v", err)
+	}
+	c.token = token.AccessToken
+	c.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpiryMargin)
+	return c.token, nil
+}
+
+// compute makes a request to the given path of the Compute Engine API of the project, encoding the given body and
+// decoding the response in out if they are not nil. The path can also be the self link of a resource. The request is
+// retried on the throttling, server and transient errors as per the default retry policy, except for the POST requests
+// creating resources, which are only retried when throttled.
+func (c *client) compute(ctx context.Context, method, path string, body, out interface{}) error {
+	endpoint := path
+	if !strings.HasPrefix(path, "https://") {
+		endpoint = c.computeURL + path
+	}
+	var content []byte
+	if body != nil {
+		var err error
+		if content, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("error encoding request body: %v", err)
+		}
+	}
+	policy := windows.DefaultRetryPolicy
+	policy.IsRetryable = isRetryable(method)
+	operation := method + " " + strings.SplitN(endpoint, "?", 2)[0]
+	err := policy.Do(ctx, operation, func() error {
+		token, err := c.accessToken(ctx)
+		if err != nil {
+			return err
+		}
+		var reqBody io.Reader
+		if content != nil {
+			reqBody = bytes.NewReader(content)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", "application/json")
+		if content != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return c.do(req, out)
+	}, nil)
+	if err != nil {
+		// The status of the error is kept, for the callers to tell missing resources apart
+		if statusErr, ok := err.(*statusError); ok {
+			statusErr.message = fmt.Sprintf("error in %s: %s", operation, statusErr.message)
+			return statusErr
+		}
+		return fmt.Errorf("error in %s: %v", operation, err)
+	}
+	return nil
+}
+
+// do sends the given request and decodes the response in out if it is not nil. The errors returned by the API are
+// converted to a statusError holding their messages.
+func (c *client) do(req *http.Request, out interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr apiError
+		if json.Unmarshal(content, &apiErr) == nil && apiErr.Error.Message != "" {
+			var messages []string
+			for _, e := range apiErr.Error.Errors {
+				messages = append(messages, fmt.Sprintf("%s: %s", e.Reason, e.Message))
+			}
+			if len(messages) == 0 {
+				messages = append(messages, apiErr.Error.Message)
+			}
+			return &statusError{code: resp.StatusCode,
+				message: fmt.Sprintf("%s: %s", resp.Status, strings.Join(messages, ", "))}
+		}
+		return &statusError{code: resp.StatusCode,
+			message: fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(content)))}
+	}
+	if out == nil || len(content) == 0 {
+		return nil
+	}
+	if err = json.Unmarshal(content, out); err != nil {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+	return nil
+}
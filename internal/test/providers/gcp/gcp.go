@@ -0,0 +1,605 @@
+// Package gcp creates Windows instances in Compute Engine for the Windows nodes of OpenShift clusters running on GCP,
+// as the GCP Machine API provider does not run the user data the Windows VMs are set up with. The instances are placed
+// in the network of the cluster, are reachable through an ephemeral external IP opened by a firewall rule targeting
+// their network tag, and are labeled so that they can be cleaned up.
+package gcp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	// DefaultMachineType is the machine type of the Windows instances if the spec does not specify it
+	DefaultMachineType = "n1-standard-4"
+	// DefaultAllowedCIDR is the CIDR the ports of the Windows instances are opened to if the spec does not specify it
+	DefaultAllowedCIDR = "0.0.0.0/0"
+	// imageProject is the project of the public Windows Server images
+	imageProject = "windows-cloud"
+	// bootDiskSizeGB is the size of the boot disk of the Windows instances, which holds the container images
+	bootDiskSizeGB = 128
+	// labelKey is the key of the label identifying the cluster of the Windows instances
+	labelKey = "windows-node-installer"
+	// startupScriptKey is the metadata key of the PowerShell script run by the GCE agent when the instance boots
+	startupScriptKey = "windows-startup-script-ps1"
+	// windowsKeysKey is the metadata key the GCE agent reads the password reset requests from
+	windowsKeysKey = "windows-keys"
+	// passwordSerialPort is the serial port the GCE agent writes the encrypted passwords to
+	passwordSerialPort = 4
+	// pollInterval is the interval at which the operations, the status of a new instance and its password are checked
+	pollInterval = 10 * time.Second
+)
+
+// windowsPorts are the TCP ports opened to the Windows instances: ssh, WinRM over HTTPS and the kubelet, which serves
+// the container logs
+var windowsPorts = []int{22, 5986, 10250}
+
+// namePattern is the format of the names of the labels, network tags and firewall rules
+var namePattern = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,53}[a-z0-9])?$`)
+
+// operation is a Compute Engine operation
+type operation struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	SelfLink string `json:"selfLink"`
+	Error    *struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"error"`
+}
+
+// metadataItem is an entry of the metadata of an instance
+type metadataItem struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// metadata is the metadata of an instance
+type metadata struct {
+	Fingerprint string         `json:"fingerprint,omitempty"`
+	Items       []metadataItem `json:"items,omitempty"`
+}
+
+// instance is a Compute Engine instance
+type instance struct {
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	Zone              string `json:"zone"`
+	Status            string `json:"status"`
+	NetworkInterfaces []struct {
+		NetworkIP     string `json:"networkIP"`
+		AccessConfigs []struct {
+			NatIP string `json:"natIP"`
+		} `json:"accessConfigs"`
+	} `json:"networkInterfaces"`
+	Metadata metadata `json:"metadata"`
+}
+
+// firewallAllowed is a protocol and its ports allowed by a firewall rule
+type firewallAllowed struct {
+	IPProtocol string   `json:"IPProtocol"`
+	Ports      []string `json:"ports,omitempty"`
+}
+
+// firewall is a VPC firewall rule
+type firewall struct {
+	Name         string            `json:"name"`
+	Network      string            `json:"network"`
+	Direction    string            `json:"direction"`
+	SourceRanges []string          `json:"sourceRanges"`
+	TargetTags   []string          `json:"targetTags"`
+	Allowed      []firewallAllowed `json:"allowed"`
+}
+
+// windowsKey is a request to the GCE agent to reset the password of a Windows user, encrypted with the given public
+// key
+type windowsKey struct {
+	UserName string `json:"userName"`
+	Modulus  string `json:"modulus"`
+	Exponent string `json:"exponent"`
+	Email    string `json:"email"`
+	ExpireOn string `json:"expireOn"`
+}
+
+// encryptedPassword is the response of the GCE agent to a windowsKey, written to the password serial port
+type encryptedPassword struct {
+	Modulus           string `json:"modulus"`
+	EncryptedPassword string `json:"encryptedPassword"`
+	PasswordFound     bool   `json:"passwordFound"`
+	ErrorMessage      string `json:"errorMessage"`
+}
+
+// InstanceSpec describes the Windows instance to create
+type InstanceSpec struct {
+	// Name is the name of the instance
+	Name string
+	// Zone is the zone the instance is created in
+	Zone string
+	// Network is the name of the VPC network of the cluster the instance is placed in
+	Network string
+	// Subnetwork is the name of the subnetwork of the cluster the instance is placed in, in the region of the zone
+	Subnetwork string
+	// Image is the image of the instance, for example projects/<project>/global/images/<name>. The latest public image
+	// of the Windows Server version is used if it is empty.
+	Image string
+	// WindowsVersion is the Windows Server version of the image, e.g. 2019, used if Image is empty
+	WindowsVersion string
+	// MachineType is the machine type of the instance. DefaultMachineType is used if it is empty.
+	MachineType string
+	// AllowedCIDR is the CIDR the ssh, WinRM and kubelet ports of the instance are opened to. DefaultAllowedCIDR is used
+	// if it is empty.
+	AllowedCIDR string
+	// Username is the Windows user the password is generated for, which the instance is accessed as
+	Username string
+	// UserData is the PowerShell script run by the GCE agent when the instance boots
+	UserData []byte
+}
+
+// Instance is a Windows instance created in Compute Engine
+type Instance struct {
+	// ID is the ID of the instance
+	ID string
+	// Name is the name of the instance
+	Name string
+	// Zone is the zone of the instance
+	Zone string
+	// PrivateIP is the address of the instance in the network of the cluster
+	PrivateIP string
+	// ExternalIP is the public address of the instance
+	ExternalIP string
+	// Password is the password of the Windows user of the spec
+	Password string
+	// FirewallRule is the firewall rule opening the ports of the instance
+	FirewallRule string
+}
+
+// Provider creates and destroys the Windows instances of a cluster in Compute Engine
+type Provider struct {
+	// client makes the requests to the Compute Engine API
+	client *client
+	// label is the value of the label the instances are labeled with, which identifies the cluster
+	label string
+}
+
+// NewProvider returns a provider managing the Windows instances in the given project with the given JSON key of a
+// service account, in its project if the given one is empty. The instances are labeled with the given label, and only
+// the instances with this label are destroyed.
+func NewProvider(keyJSON []byte, project, label string) (*Provider, error) {
+	if !namePattern.MatchString(label) {
+		return nil, fmt.Errorf("invalid label %q, expected lowercase letters, digits and dashes", label)
+	}
+	c, err := newClient(keyJSON, project)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{client: c, label: label}, nil
+}
+
+// networkTag returns the network tag of the instances, which the firewall rule opening their ports targets
+func (p *Provider) networkTag() string {
+	return p.label + "-windows"
+}
+
+// firewallName returns the name of the firewall rule opening the ports of the instances
+func (p *Provider) firewallName() string {
+	return p.label + "-windows"
+}
+
+// CreateWindowsInstance creates a Windows instance as per the given spec with an ephemeral external IP, waits for it
+// to run, and generates the password of the Windows user of the spec through the GCE agent. The ports required by the
+// tests and the node are opened by a firewall rule of the network targeting the network tag of the instance. An
+// instance that fails to start is left behind, labeled, to be investigated and destroyed.
+func (p *Provider) CreateWindowsInstance(ctx context.Context, spec InstanceSpec) (*Instance, error) {
+	if spec.Name == "" || spec.Zone == "" || spec.Network == "" || spec.Subnetwork == "" || spec.Username == "" {
+		return nil, fmt.Errorf("a name, a zone, a network, a subnetwork and a username are required")
+	}
+	if spec.MachineType == "" {
+		spec.MachineType = DefaultMachineType
+	}
+	if spec.AllowedCIDR == "" {
+		spec.AllowedCIDR = DefaultAllowedCIDR
+	}
+	if spec.Image == "" {
+		if spec.WindowsVersion == "" {
+			return nil, fmt.Errorf("an image or a Windows Server version is required")
+		}
+		// The image family resolves to its most recent image
+		spec.Image = "projects/" + imageProject + "/global/images/family/windows-" + spec.WindowsVersion + "-core"
+	}
+	network := "global/networks/" + spec.Network
+	if err := p.openPorts(ctx, network, spec.AllowedCIDR); err != nil {
+		return nil, err
+	}
+
+	body := map[string]interface{}{
+		"name":        spec.Name,
+		"machineType": "zones/" + spec.Zone + "/machineTypes/" + spec.MachineType,
+		"disks": []map[string]interface{}{{
+			"boot":       true,
+			"autoDelete": true,
+			"initializeParams": map[string]interface{}{
+				"sourceImage": spec.Image,
+				"diskSizeGb":  strconv.Itoa(bootDiskSizeGB),
+			},
+		}},
+		"networkInterfaces": []map[string]interface{}{{
+			"network":       network,
+			"subnetwork":    "regions/" + zoneRegion(spec.Zone) + "/subnetworks/" + spec.Subnetwork,
+			"accessConfigs": []map[string]string{{"type": "ONE_TO_ONE_NAT", "name": "External NAT"}},
+		}},
+		"tags":     map[string][]string{"items": {p.networkTag()}},
+		"labels":   map[string]string{labelKey: p.label},
+		"metadata": metadata{Items: []metadataItem{{Key: startupScriptKey, Value: string(spec.UserData)}}},
+	}
+	var op operation
+	if err := p.client.compute(ctx, http.MethodPost, "/zones/"+spec.Zone+"/instances", body, &op); err != nil {
+		return nil, fmt.Errorf("error creating instance %s: %v", spec.Name, err)
+	}
+	if err := p.waitForOperation(ctx, &op); err != nil {
+		return nil, fmt.Errorf("error creating instance %s: %v", spec.Name, err)
+	}
+	log.Printf("created instance %s in zone %s", spec.Name, spec.Zone)
+
+	running, err := p.waitForInstance(ctx, spec.Zone, spec.Name)
+	if err != nil {
+		return nil, err
+	}
+	password, err := p.resetWindowsPassword(ctx, running, spec.Username)
+	if err != nil {
+		return nil, err
+	}
+	created := &Instance{ID: running.ID, Name: running.Name, Zone: spec.Zone, Password: password,
+		FirewallRule: p.firewallName()}
+	if len(running.NetworkInterfaces) > 0 {
+		created.PrivateIP = running.NetworkInterfaces[0].NetworkIP
+		if len(running.NetworkInterfaces[0].AccessConfigs) > 0 {
+			created.ExternalIP = running.NetworkInterfaces[0].AccessConfigs[0].NatIP
+		}
+	}
+	return created, nil
+}
+
+// zoneRegion returns the region of the given zone
+func zoneRegion(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+// windowsFirewall returns the firewall rule of the given network opening the ports of the Windows instances to the
+// given CIDR
+func (p *Provider) windowsFirewall(network, cidr string) firewall {
+	ports := make([]string, 0, len(windowsPorts))
+	for _, port := range windowsPorts {
+		ports = append(ports, strconv.Itoa(port))
+	}
+	return firewall{
+		Name:         p.firewallName(),
+		Network:      network,
+		Direction:    "INGRESS",
+		SourceRanges: []string{cidr},
+		TargetTags:   []string{p.networkTag()},
+		Allowed:      []firewallAllowed{{IPProtocol: "tcp", Ports: ports}},
+	}
+}
+
+// hasPorts returns true if the given firewall rule opens the ports of the Windows instances to the given CIDR
+func hasPorts(rule firewall, cidr string) bool {
+	hasCIDR := false
+	for _, sourceRange := range rule.SourceRanges {
+		hasCIDR = hasCIDR || sourceRange == cidr
+	}
+	if !hasCIDR {
+		return false
+	}
+	opened := make(map[string]bool)
+	for _, allowed := range rule.Allowed {
+		if allowed.IPProtocol == "tcp" {
+			for _, port := range allowed.Ports {
+				opened[port] = true
+			}
+		}
+	}
+	for _, port := range windowsPorts {
+		if !opened[strconv.Itoa(port)] {
+			return false
+		}
+	}
+	return true
+}
+
+// openPorts creates the firewall rule opening the ports of the Windows instances to the given CIDR in the given
+// network, or updates it if it does not open them
+func (p *Provider) openPorts(ctx context.Context, network, cidr string) error {
+	rule := p.windowsFirewall(network, cidr)
+	var existing firewall
+	err := p.client.compute(ctx, http.MethodGet, "/global/firewalls/"+rule.Name, nil, &existing)
+	var op operation
+	switch {
+	case isNotFound(err):
+		err = p.client.compute(ctx, http.MethodPost, "/global/firewalls", rule, &op)
+	case err != nil:
+		return fmt.Errorf("error getting firewall rule %s: %v", rule.Name, err)
+	case hasPorts(existing, cidr):
+		return nil
+	default:
+		err = p.client.compute(ctx, http.MethodPatch, "/global/firewalls/"+rule.Name, rule, &op)
+	}
+	if err == nil {
+		err = p.waitForOperation(ctx, &op)
+	}
+	if err != nil {
+		return fmt.Errorf("error opening ports %v in firewall rule %s: %v", windowsPorts, rule.Name, err)
+	}
+	log.Printf("opened ports %v to %s in firewall rule %s", windowsPorts, cidr, rule.Name)
+	return nil
+}
+
+// waitForOperation waits for the given operation to be done, and returns its errors
+func (p *Provider) waitForOperation(ctx context.Context, op *operation) error {
+	for op.Status != "DONE" {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("operation %s is %s: %v", op.Name, op.Status, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+		if err := p.client.compute(ctx, http.MethodGet, op.SelfLink, nil, op); err != nil {
+			return fmt.Errorf("error getting operation %s: %v", op.Name, err)
+		}
+	}
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		var messages []string
+		for _, e := range op.Error.Errors {
+			messages = append(messages, fmt.Sprintf("%s: %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("operation %s failed: %s", op.Name, strings.Join(messages, ", "))
+	}
+	return nil
+}
+
+// waitForInstance waits for the instance with the given name to be running and returns it
+func (p *Provider) waitForInstance(ctx context.Context, zone, name string) (*instance, error) {
+	for {
+		var i instance
+		if err := p.client.compute(ctx, http.MethodGet, "/zones/"+zone+"/instances/"+name, nil, &i); err != nil {
+			return nil, fmt.Errorf("error getting instance %s: %v", name, err)
+		}
+		switch i.Status {
+		case "RUNNING":
+			return &i, nil
+		case "TERMINATED":
+			return nil, fmt.Errorf("instance %s failed to start", name)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("instance %s is %s: %v", name, i.Status, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// newWindowsKey returns a request to reset the password of the given Windows user, encrypted with the public key of
+// the given private key, on behalf of the given email
+func newWindowsKey(key *rsa.PrivateKey, username, email string, expireOn time.Time) windowsKey {
+	return windowsKey{
+		UserName: username,
+		Modulus:  base64.StdEncoding.EncodeToString(key.N.Bytes()),
+		Exponent: base64.StdEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		Email:    email,
+		ExpireOn: expireOn.UTC().Format(time.RFC3339),
+	}
+}
+
+// findPassword returns the password encrypted for the given key in the given output of the password serial port, or
+// an empty string if the GCE agent has not written it yet
+func findPassword(output string, key windowsKey, privateKey *rsa.PrivateKey) (string, error) {
+	for _, line := range strings.Split(output, "\n") {
+		var response encryptedPassword
+		if json.Unmarshal([]byte(strings.TrimSpace(line)), &response) != nil || response.Modulus != key.Modulus {
+			continue
+		}
+		if response.ErrorMessage != "" {
+			return "", fmt.Errorf("GCE agent failed to reset the password of %s: %s", key.UserName,
+				response.ErrorMessage)
+		}
+		if !response.PasswordFound {
+			continue
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(response.EncryptedPassword)
+		if err != nil {
+			return "", fmt.Errorf("invalid encrypted password: %v", err)
+		}
+		password, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, privateKey, ciphertext, nil)
+		if err != nil {
+			return "", fmt.Errorf("error decrypting password: %v", err)
+		}
+		return string(password), nil
+	}
+	return "", nil
+}
+
+// resetWindowsPassword creates the given Windows user on the given instance, or resets its password, and returns the
+// password. The GCE agent reads the request from the metadata of the instance and writes the password, encrypted with
+// the public key of the request, to the password serial port.
+func (p *Provider) resetWindowsPassword(ctx context.Context, i *instance, username string) (string, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", fmt.Errorf("error generating password key: %v", err)
+	}
+	key := newWindowsKey(privateKey, username, p.client.key.ClientEmail, time.Now().Add(5*time.Minute))
+	request, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	items := []metadataItem{{Key: windowsKeysKey, Value: string(request)}}
+	for _, item := range i.Metadata.Items {
+		if item.Key == windowsKeysKey {
+			items[0].Value = item.Value + "\n" + items[0].Value
+			continue
+		}
+		items = append(items, item)
+	}
+	zone := path.Base(i.Zone)
+	instancePath := "/zones/" + zone + "/instances/" + i.Name
+	var op operation
+	err = p.client.compute(ctx, http.MethodPost, instancePath+"/setMetadata",
+		metadata{Fingerprint: i.Metadata.Fingerprint, Items: items}, &op)
+	if err == nil {
+		err = p.waitForOperation(ctx, &op)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error requesting password of instance %s: %v", i.Name, err)
+	}
+
+	for {
+		var output struct {
+			Contents string `json:"contents"`
+		}
+		err = p.client.compute(ctx, http.MethodGet, fmt.Sprintf("%s/serialPort?port=%d", instancePath,
+			passwordSerialPort), nil, &output)
+		if err != nil {
+			return "", fmt.Errorf("error reading password of instance %s: %v", i.Name, err)
+		}
+		password, err := findPassword(output.Contents, key, privateKey)
+		if err != nil {
+			return "", fmt.Errorf("error reading password of instance %s: %v", i.Name, err)
+		}
+		if password != "" {
+			log.Printf("generated password of %s on instance %s", username, i.Name)
+			return password, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("password of instance %s was not generated: %v", i.Name, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// DestroyTaggedInstances deletes the instances labeled with the label of the provider in all the zones, and then the
+// firewall rule opening their ports, and returns the names of the deleted instances
+func (p *Provider) DestroyTaggedInstances(ctx context.Context) ([]string, error) {
+	instances, err := p.labeledInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.destroyInstances(ctx, instances, len(instances))
+}
+
+// DestroyInstances deletes the labeled instances with the given names, and then the firewall rule opening their ports
+// if no labeled instance remains. It returns the given names of the deleted instances and of the instances that do not
+// exist anymore.
+func (p *Provider) DestroyInstances(ctx context.Context, names []string) ([]string, error) {
+	labeled, err := p.labeledInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool, len(labeled))
+	for _, i := range labeled {
+		found[i.Name] = true
+	}
+	var gone []string
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		selected[name] = true
+		if !found[name] {
+			gone = append(gone, name)
+		}
+	}
+	var instances []instance
+	for _, i := range labeled {
+		if selected[i.Name] {
+			instances = append(instances, i)
+		}
+	}
+	destroyed, err := p.destroyInstances(ctx, instances, len(labeled))
+	return append(gone, destroyed...), err
+}
+
+// labeledInstances returns the instances labeled with the label of the provider in all the zones
+func (p *Provider) labeledInstances(ctx context.Context) ([]instance, error) {
+	var instances []instance
+	filter := url.QueryEscape(fmt.Sprintf("labels.%s=%s", labelKey, p.label))
+	pageToken := ""
+	for {
+		var list struct {
+			Items map[string]struct {
+				Instances []instance `json:"instances"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		listPath := "/aggregated/instances?filter=" + filter
+		if pageToken != "" {
+			listPath += "&pageToken=" + url.QueryEscape(pageToken)
+		}
+		if err := p.client.compute(ctx, http.MethodGet, listPath, nil, &list); err != nil {
+			return nil, fmt.Errorf("error listing instances: %v", err)
+		}
+		for _, zone := range list.Items {
+			instances = append(instances, zone.Instances...)
+		}
+		if list.NextPageToken == "" {
+			return instances, nil
+		}
+		pageToken = list.NextPageToken
+	}
+}
+
+// destroyInstances deletes the given instances out of the given number of labeled instances, and then the firewall
+// rule opening their ports if all the labeled instances are deleted. It returns the names of the deleted instances.
+func (p *Provider) destroyInstances(ctx context.Context, instances []instance, labeled int) ([]string, error) {
+	var destroyed []string
+	var errs []error
+	for _, i := range instances {
+		if err := p.destroyInstance(ctx, i); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		destroyed = append(destroyed, i.Name)
+	}
+	// The firewall rule is kept as long as it opens the ports of remaining instances
+	if len(destroyed) == labeled {
+		var op operation
+		err := p.client.compute(ctx, http.MethodDelete, "/global/firewalls/"+p.firewallName(), nil, &op)
+		if err == nil {
+			err = p.waitForOperation(ctx, &op)
+		}
+		if err != nil && !isNotFound(err) {
+			errs = append(errs, fmt.Errorf("error deleting firewall rule %s: %v", p.firewallName(), err))
+		}
+	}
+	return destroyed, utilerrors.NewAggregate(errs)
+}
+
+// destroyInstance deletes the given instance along with its boot disk
+func (p *Provider) destroyInstance(ctx context.Context, i instance) error {
+	var op operation
+	err := p.client.compute(ctx, http.MethodDelete, "/zones/"+path.Base(i.Zone)+"/instances/"+i.Name, nil, &op)
+	if err == nil {
+		err = p.waitForOperation(ctx, &op)
+	}
+	if err != nil {
+		return fmt.Errorf("error deleting instance %s: %v", i.Name, err)
+	}
+	log.Printf("deleted instance %s (%s)", i.Name, i.ID)
+	return nil
+}
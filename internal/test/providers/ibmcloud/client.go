@@ -0,0 +1,213 @@
+package ibmcloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/windows"
+)
+
+const (
+	// iamTokenURL is the endpoint exchanging an IBM Cloud API key for an IAM access token
+	iamTokenURL = "https://iam.cloud.ibm.com/identity/token"
+	// vpcURLFormat is the format of the endpoint of the VPC API in a region
+	vpcURLFormat = "https://%s.iaas.cloud.ibm.com/v1"
+	// vpcAPIVersion is the date of the version of the VPC API the requests are made against
+	vpcAPIVersion = "2021-01-12"
+	// taggingURL is the endpoint of the global tagging API
+	taggingURL = "https://tags.global-search-tagging.cloud.ibm.com/v3"
+	// requestTimeout is the timeout of the individual requests made to the IBM Cloud APIs
+	requestTimeout = time.Minute
+	// tokenExpiryMargin is the time before its expiry after which an IAM access token is renewed
+	tokenExpiryMargin = 5 * time.Minute
+)
+
+// client makes authenticated requests to the IBM Cloud APIs. The IBM Cloud SDKs are not vendored, the REST APIs being
+// simple enough to be called directly.
+type client struct {
+	// apiKey is the IBM Cloud API key the IAM access tokens are requested with
+	apiKey string
+	// vpcURL is the endpoint of the VPC API in the region the resources are managed in
+	vpcURL string
+	// httpClient makes the requests
+	httpClient *http.Client
+	// token is the current IAM access token
+	token string
+	// tokenExpiry is the time after which the token needs to be renewed
+	tokenExpiry time.Time
+	// tokenLock protects token and tokenExpiry
+	tokenLock sync.Mutex
+}
+
+// apiError is the error body returned by the IBM Cloud APIs
+type apiError struct {
+	Errors []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// statusError is the error returned for a request the API failed with the given HTTP status code
+type statusError struct {
+	// code is the HTTP status code of the response
+	code int
+	// message describes the error
+	message string
+}
+
+// Error returns the description of the error
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// isNotFound returns true if the given error is returned for a resource that does not exist
+func isNotFound(err error) bool {
+	statusErr, ok := err.(*statusError)
+	return ok && statusErr.code == http.StatusNotFound
+}
+
+// isRetryable returns the function classifying the errors of the requests with the given HTTP method that are retried
+func isRetryable(method string) func(error) bool {
+	return func(err error) bool {
+		statusCode := 0
+		if statusErr, ok := err.(*statusError); ok {
+			statusCode = statusErr.code
+		}
+		return windows.IsRetryableHTTPError(method, statusCode, err)
+	}
+}
+
+// newClient returns a client for the VPC API of the given region, authenticated with the given API key
+func newClient(apiKey, region string) *client {
+	return &client{
+		apiKey:     apiKey,
+		vpcURL:     fmt.Sprintf(vpcURLFormat, region),
+		httpClient: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// accessToken returns a valid IAM access token, requesting a new one if the current one is about to expire
+func (c *client) accessToken(ctx context.Context) (string, error) {
+	c.tokenLock.Lock()
+	defer c.tokenLock.Unlock()
+	if c.token != "" && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "urn:ibm:params:oauth:grant-type:apikey")
+	form.Set("apikey", c.apiKey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, iamTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err = c.do(req, &token); err != nil {
+		return "", fmt.Errorf("error requesting IAM access token: %v", err)
+	}
+	c.token = token.AccessToken
+	c.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpiryMargin)
+	return c.token, nil
+}
+
+// vpc makes a request to the given path of the VPC API, encoding the given body and decoding the response in out if
+// they are not nil
+func (c *client) vpc(ctx context.Context, method, path string, body, out interface{}) error {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return c.request(ctx, method, c.vpcURL+path+separator+"version="+vpcAPIVersion+"&generation=2", body, out)
+}
+
+// tagging makes a request to the given path of the global tagging API
+func (c *client) tagging(ctx context.Context, method, path string, body, out interface{}) error {
+	return c.request(ctx, method, taggingURL+path, body, out)
+}
+
+// request makes an authenticated request to the given endpoint, retrying it on the throttling, server and transient
+// errors as per the default retry policy. The POST requests creating resources are only retried when throttled.
+func (c *client) request(ctx context.Context, method, endpoint string, body, out interface{}) error {
+	var content []byte
+	if body != nil {
+		var err error
+		if content, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("error encoding request body: %v", err)
+		}
+	}
+	policy := windows.DefaultRetryPolicy
+	policy.IsRetryable = isRetryable(method)
+	operation := method + " " + strings.SplitN(endpoint, "?", 2)[0]
+	err := policy.Do(ctx, operation, func() error {
+		token, err := c.accessToken(ctx)
+		if err != nil {
+			return err
+		}
+		var reqBody io.Reader
+		if content != nil {
+			reqBody = bytes.NewReader(content)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", "application/json")
+		if content != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return c.do(req, out)
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error in %s: %v", operation, err)
+	}
+	return nil
+}
+
+// do sends the given request and decodes the response in out if it is not nil. The errors returned by the API are
+// converted to a statusError holding their messages.
+func (c *client) do(req *http.Request, out interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr apiError
+		if json.Unmarshal(content, &apiErr) == nil && len(apiErr.Errors) > 0 {
+			var messages []string
+			for _, e := range apiErr.Errors {
+				messages = append(messages, fmt.Sprintf("%s: %s", e.Code, e.Message))
+			}
+			return &statusError{code: resp.StatusCode,
+				message: fmt.Sprintf("%s: %s", resp.Status, strings.Join(messages, ", "))}
+		}
+		return &statusError{code: resp.StatusCode,
+			message: fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(content)))}
+	}
+	if out == nil || len(content) == 0 {
+		return nil
+	}
+	if err = json.Unmarshal(content, out); err != nil {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+	return nil
+}
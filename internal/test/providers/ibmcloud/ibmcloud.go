@@ -0,0 +1,475 @@
+// Package ibmcloud creates Windows instances in IBM Cloud VPC for the Windows nodes of OpenShift clusters running on
+// IBM Cloud, which cannot be created through the Machine API. The instances are placed in a subnet of the VPC of the
+// cluster, are reachable through a floating IP and are tagged so that they can be cleaned up.
+package ibmcloud
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	// DefaultProfile is the profile of the Windows instances if the spec does not specify it
+	DefaultProfile = "bx2-4x16"
+	// DefaultAllowedCIDR is the CIDR the ports of the Windows instances are opened to if the spec does not specify it
+	DefaultAllowedCIDR = "0.0.0.0/0"
+	// listLimit is the maximum number of resources returned by a single list request
+	listLimit = 100
+	// pollInterval is the interval at which the status of a new instance is checked
+	pollInterval = 10 * time.Second
+)
+
+// windowsPorts are the TCP ports opened to the Windows instances: ssh, WinRM over HTTPS and the kubelet, which serves
+// the container logs
+var windowsPorts = []int{22, 5986, 10250}
+
+// reference is a reference to a VPC resource
+type reference struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	CRN  string `json:"crn,omitempty"`
+}
+
+// page is the pagination of a list of VPC resources
+type page struct {
+	Next *struct {
+		Href string `json:"href"`
+	} `json:"next"`
+}
+
+// nextStart returns the start token of the next page, or an empty string on the last page
+func (p page) nextStart() (string, error) {
+	if p.Next == nil || p.Next.Href == "" {
+		return "", nil
+	}
+	next, err := url.Parse(p.Next.Href)
+	if err != nil {
+		return "", fmt.Errorf("invalid next page %s: %v", p.Next.Href, err)
+	}
+	return next.Query().Get("start"), nil
+}
+
+// networkInterface is a network interface of a VPC instance
+type networkInterface struct {
+	ID                 string `json:"id"`
+	PrimaryIPv4Address string `json:"primary_ipv4_address"`
+}
+
+// instance is a VPC instance
+type instance struct {
+	ID                      string           `json:"id"`
+	CRN                     string           `json:"crn"`
+	Name                    string           `json:"name"`
+	Status                  string           `json:"status"`
+	PrimaryNetworkInterface networkInterface `json:"primary_network_interface"`
+}
+
+// floatingIP is a VPC floating IP
+type floatingIP struct {
+	ID      string `json:"id"`
+	CRN     string `json:"crn"`
+	Address string `json:"address"`
+}
+
+// securityGroupRule is a rule of a VPC security group
+type securityGroupRule struct {
+	Direction string `json:"direction"`
+	Protocol  string `json:"protocol"`
+	PortMin   int    `json:"port_min,omitempty"`
+	PortMax   int    `json:"port_max,omitempty"`
+	Remote    struct {
+		CIDRBlock string `json:"cidr_block,omitempty"`
+	} `json:"remote"`
+}
+
+// image is a VPC image
+type image struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Status          string `json:"status"`
+	CreatedAt       string `json:"created_at"`
+	OperatingSystem struct {
+		Name string `json:"name"`
+	} `json:"operating_system"`
+}
+
+// InstanceSpec describes the Windows instance to create
+type InstanceSpec struct {
+	// Name is the name of the instance
+	Name string
+	// SubnetID is the ID of the subnet of the cluster the instance is placed in. The VPC and the zone of the instance
+	// are the ones of the subnet.
+	SubnetID string
+	// ImageID is the ID of the image of the instance. The latest public image of the Windows Server version is used if
+	// it is empty.
+	ImageID string
+	// WindowsVersion is the Windows Server version of the image, e.g. 2019, used if ImageID is empty
+	WindowsVersion string
+	// Profile is the profile of the instance. DefaultProfile is used if it is empty.
+	Profile string
+	// KeyID is the ID of the VPC ssh key of the instance, which encrypts the Administrator password of the instance
+	KeyID string
+	// SecurityGroupID is the ID of the security group of the instance. The default security group of the VPC is used
+	// if it is empty.
+	SecurityGroupID string
+	// AllowedCIDR is the CIDR the ssh, WinRM and kubelet ports of the instance are opened to. DefaultAllowedCIDR is used
+	// if it is empty.
+	AllowedCIDR string
+	// UserData is the user data run by cloudbase-init when the instance boots
+	UserData []byte
+}
+
+// Instance is a Windows instance created in IBM Cloud VPC
+type Instance struct {
+	// ID is the ID of the instance
+	ID string
+	// Name is the name of the instance
+	Name string
+	// PrivateIP is the address of the instance in the subnet of the cluster
+	PrivateIP string
+	// FloatingIP is the public address of the instance
+	FloatingIP string
+	// SecurityGroupID is the ID of the security group opening the ports of the instance
+	SecurityGroupID string
+}
+
+// Provider creates and destroys the Windows instances of a cluster in IBM Cloud VPC
+type Provider struct {
+	// client makes the requests to the IBM Cloud APIs
+	client *client
+	// tag is the user tag the instances and their floating IPs are tagged with, which identifies the cluster
+	tag string
+}
+
+// NewProvider returns a provider managing the Windows instances in the given region with the given IBM Cloud API key.
+// The instances are tagged with the given tag, and only the instances with this tag are destroyed.
+func NewProvider(apiKey, region, tag string) (*Provider, error) {
+	if apiKey == "" || region == "" || tag == "" {
+		return nil, fmt.Errorf("an API key, a region and a tag are required")
+	}
+	return &Provider{client: newClient(apiKey, region), tag: tag}, nil
+}
+
+// CreateWindowsInstance creates a Windows instance as per the given spec, waits for it to run and attaches a floating
+// IP to it. The ports required by the tests and the node are opened in the security group of the instance. An instance
+// that fails to start is left behind, tagged, to be investigated and destroyed.
+func (p *Provider) CreateWindowsInstance(ctx context.Context, spec InstanceSpec) (*Instance, error) {
+	if spec.Name == "" || spec.SubnetID == "" || spec.KeyID == "" {
+		return nil, fmt.Errorf("a name, a subnet ID and a key ID are required")
+	}
+	if spec.Profile == "" {
+		spec.Profile = DefaultProfile
+	}
+	if spec.AllowedCIDR == "" {
+		spec.AllowedCIDR = DefaultAllowedCIDR
+	}
+
+	var subnet struct {
+		VPC  reference `json:"vpc"`
+		Zone reference `json:"zone"`
+	}
+	if err := p.client.vpc(ctx, http.MethodGet, "/subnets/"+spec.SubnetID, nil, &subnet); err != nil {
+		return nil, fmt.Errorf("error getting subnet %s: %v", spec.SubnetID, err)
+	}
+	if spec.SecurityGroupID == "" {
+		var vpc struct {
+			DefaultSecurityGroup reference `json:"default_security_group"`
+		}
+		if err := p.client.vpc(ctx, http.MethodGet, "/vpcs/"+subnet.VPC.ID, nil, &vpc); err != nil {
+			return nil, fmt.Errorf("error getting VPC %s: %v", subnet.VPC.ID, err)
+		}
+		spec.SecurityGroupID = vpc.DefaultSecurityGroup.ID
+	}
+	if err := p.openPorts(ctx, spec.SecurityGroupID, spec.AllowedCIDR); err != nil {
+		return nil, err
+	}
+	if spec.ImageID == "" {
+		var err error
+		if spec.ImageID, err = p.latestWindowsImage(ctx, spec.WindowsVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	body := map[string]interface{}{
+		"name":    spec.Name,
+		"profile": reference{Name: spec.Profile},
+		"vpc":     reference{ID: subnet.VPC.ID},
+		"zone":    reference{Name: subnet.Zone.Name},
+		"image":   reference{ID: spec.ImageID},
+		"keys":    []reference{{ID: spec.KeyID}},
+		"primary_network_interface": map[string]interface{}{
+			"subnet":          reference{ID: spec.SubnetID},
+			"security_groups": []reference{{ID: spec.SecurityGroupID}},
+		},
+		"user_data": string(spec.UserData),
+	}
+	var created instance
+	if err := p.client.vpc(ctx, http.MethodPost, "/instances", body, &created); err != nil {
+		return nil, fmt.Errorf("error creating instance %s: %v", spec.Name, err)
+	}
+	log.Printf("created instance %s (%s) in zone %s", created.Name, created.ID, subnet.Zone.Name)
+	if err := p.attachTag(ctx, created.CRN); err != nil {
+		return nil, err
+	}
+
+	running, err := p.waitForInstance(ctx, created.ID)
+	if err != nil {
+		return nil, err
+	}
+	var ip floatingIP
+	err = p.client.vpc(ctx, http.MethodPost, "/floating_ips", map[string]interface{}{
+		"name":   spec.Name,
+		"target": reference{ID: running.PrimaryNetworkInterface.ID},
+	}, &ip)
+	if err != nil {
+		return nil, fmt.Errorf("error creating floating IP of instance %s: %v", created.ID, err)
+	}
+	if err = p.attachTag(ctx, ip.CRN); err != nil {
+		return nil, err
+	}
+	return &Instance{ID: running.ID, Name: running.Name, PrivateIP: running.PrimaryNetworkInterface.PrimaryIPv4Address,
+		FloatingIP: ip.Address, SecurityGroupID: spec.SecurityGroupID}, nil
+}
+
+// openPorts adds the inbound rules opening the ports of the Windows instances to the given CIDR to the security group
+// with the given ID, skipping the rules that are already present
+func (p *Provider) openPorts(ctx context.Context, securityGroupID, cidr string) error {
+	var existing struct {
+		Rules []securityGroupRule `json:"rules"`
+	}
+	path := "/security_groups/" + securityGroupID + "/rules"
+	if err := p.client.vpc(ctx, http.MethodGet, path, nil, &existing); err != nil {
+		return fmt.Errorf("error listing rules of security group %s: %v", securityGroupID, err)
+	}
+	for _, port := range windowsPorts {
+		rule := securityGroupRule{Direction: "inbound", Protocol: "tcp", PortMin: port, PortMax: port}
+		rule.Remote.CIDRBlock = cidr
+		if hasRule(existing.Rules, rule) {
+			continue
+		}
+		if err := p.client.vpc(ctx, http.MethodPost, path, rule, nil); err != nil {
+			return fmt.Errorf("error opening port %d in security group %s: %v", port, securityGroupID, err)
+		}
+		log.Printf("opened port %d to %s in security group %s", port, cidr, securityGroupID)
+	}
+	return nil
+}
+
+// hasRule returns true if the given rules already open the port of the given rule to its CIDR
+func hasRule(rules []securityGroupRule, rule securityGroupRule) bool {
+	for _, r := range rules {
+		if r.Direction != rule.Direction || r.Remote.CIDRBlock != rule.Remote.CIDRBlock {
+			continue
+		}
+		// A rule for all protocols has no port range
+		if r.Protocol == "all" || (r.Protocol == rule.Protocol && r.PortMin <= rule.PortMin &&
+			r.PortMax >= rule.PortMax) {
+			return true
+		}
+	}
+	return false
+}
+
+// latestWindowsImage returns the ID of the most recent available public image of the given Windows Server version
+func (p *Provider) latestWindowsImage(ctx context.Context, windowsVersion string) (string, error) {
+	if windowsVersion == "" {
+		return "", fmt.Errorf("an image ID or a Windows Server version is required")
+	}
+	osName := "windows-" + windowsVersion + "-amd64"
+	var images []image
+	start := ""
+	for {
+		var list struct {
+			page
+			Images []image `json:"images"`
+		}
+		path := fmt.Sprintf("/images?visibility=public&limit=%d", listLimit)
+		if start != "" {
+			path += "&start=" + url.QueryEscape(start)
+		}
+		if err := p.client.vpc(ctx, http.MethodGet, path, nil, &list); err != nil {
+			return "", fmt.Errorf("error listing images: %v", err)
+		}
+		for _, i := range list.Images {
+			if i.OperatingSystem.Name == osName && i.Status == "available" {
+				images = append(images, i)
+			}
+		}
+		next, err := list.nextStart()
+		if err != nil {
+			return "", err
+		}
+		if next == "" {
+			break
+		}
+		start = next
+	}
+	if len(images) == 0 {
+		return "", fmt.Errorf("no available public image found for Windows Server %s", windowsVersion)
+	}
+	// The creation dates are in RFC 3339 format, so they sort lexicographically
+	sort.Slice(images, func(i, j int) bool { return images[i].CreatedAt > images[j].CreatedAt })
+	log.Printf("using image %s (%s)", images[0].Name, images[0].ID)
+	return images[0].ID, nil
+}
+
+// waitForInstance waits for the instance with the given ID to be running and returns it
+func (p *Provider) waitForInstance(ctx context.Context, id string) (*instance, error) {
+	for {
+		var i instance
+		if err := p.client.vpc(ctx, http.MethodGet, "/instances/"+id, nil, &i); err != nil {
+			return nil, fmt.Errorf("error getting instance %s: %v", id, err)
+		}
+		switch i.Status {
+		case "running":
+			return &i, nil
+		case "failed":
+			return nil, fmt.Errorf("instance %s failed to start", id)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("instance %s is %s: %v", id, i.Status, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// attachTag tags the resource with the given CRN with the tag of the provider
+func (p *Provider) attachTag(ctx context.Context, crn string) error {
+	err := p.client.tagging(ctx, http.MethodPost, "/tags/attach?tag_type=user", map[string]interface{}{
+		"resources": []map[string]string{{"resource_id": crn}},
+		"tag_names": []string{p.tag},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error tagging %s: %v", crn, err)
+	}
+	return nil
+}
+
+// isTagged returns true if the resource with the given CRN has the tag of the provider
+func (p *Provider) isTagged(ctx context.Context, crn string) (bool, error) {
+	var tags struct {
+		Items []struct {
+			Name string `json:"name"`
+		} `json:"items"`
+	}
+	err := p.client.tagging(ctx, http.MethodGet, "/tags?tag_type=user&attached_to="+url.QueryEscape(crn), nil, &tags)
+	if err != nil {
+		return false, fmt.Errorf("error getting tags of %s: %v", crn, err)
+	}
+	for _, tag := range tags.Items {
+		if tag.Name == p.tag {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DestroyTaggedInstances deletes the instances tagged with the tag of the provider along with their floating IPs, and
+// returns the IDs of the deleted instances
+func (p *Provider) DestroyTaggedInstances(ctx context.Context) ([]string, error) {
+	var destroyed []string
+	var errs []error
+	start := ""
+	for {
+		var list struct {
+			page
+			Instances []instance `json:"instances"`
+		}
+		path := fmt.Sprintf("/instances?limit=%d", listLimit)
+		if start != "" {
+			path += "&start=" + url.QueryEscape(start)
+		}
+		if err := p.client.vpc(ctx, http.MethodGet, path, nil, &list); err != nil {
+			return destroyed, fmt.Errorf("error listing instances: %v", err)
+		}
+		for _, i := range list.Instances {
+			tagged, err := p.isTagged(ctx, i.CRN)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if !tagged {
+				continue
+			}
+			if err = p.destroyInstance(ctx, i); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			destroyed = append(destroyed, i.ID)
+		}
+		next, err := list.nextStart()
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+		if next == "" {
+			break
+		}
+		start = next
+	}
+	return destroyed, utilerrors.NewAggregate(errs)
+}
+
+// DestroyInstances deletes the tagged instances with the given IDs along with their floating IPs, and returns the
+// given IDs of the deleted instances and of the instances that do not exist anymore
+func (p *Provider) DestroyInstances(ctx context.Context, ids []string) ([]string, error) {
+	var destroyed []string
+	var errs []error
+	for _, id := range ids {
+		var i instance
+		err := p.client.vpc(ctx, http.MethodGet, "/instances/"+id, nil, &i)
+		if isNotFound(err) {
+			destroyed = append(destroyed, id)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error getting instance %s: %v", id, err))
+			continue
+		}
+		tagged, err := p.isTagged(ctx, i.CRN)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !tagged {
+			errs = append(errs, fmt.Errorf("instance %s is not tagged with %s", id, p.tag))
+			continue
+		}
+		if err = p.destroyInstance(ctx, i); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		destroyed = append(destroyed, id)
+	}
+	return destroyed, utilerrors.NewAggregate(errs)
+}
+
+// destroyInstance deletes the floating IPs of the given instance and then the instance, as the floating IPs are not
+// released with the instance
+func (p *Provider) destroyInstance(ctx context.Context, i instance) error {
+	var ips struct {
+		FloatingIPs []floatingIP `json:"floating_ips"`
+	}
+	path := "/instances/" + i.ID + "/network_interfaces/" + i.PrimaryNetworkInterface.ID + "/floating_ips"
+	if err := p.client.vpc(ctx, http.MethodGet, path, nil, &ips); err != nil {
+		return fmt.Errorf("error listing floating IPs of instance %s: %v", i.ID, err)
+	}
+	for _, ip := range ips.FloatingIPs {
+		if err := p.client.vpc(ctx, http.MethodDelete, "/floating_ips/"+ip.ID, nil, nil); err != nil {
+			return fmt.Errorf("error deleting floating IP %s of instance %s: %v", ip.Address, i.ID, err)
+		}
+	}
+	if err := p.client.vpc(ctx, http.MethodDelete, "/instances/"+i.ID, nil, nil); err != nil {
+		return fmt.Errorf("error deleting instance %s: %v", i.ID, err)
+	}
+	log.Printf("deleted instance %s (%s)", i.Name, i.ID)
+	return nil
+}
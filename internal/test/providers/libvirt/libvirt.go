@@ -0,0 +1,290 @@
+// Package libvirt creates Windows VMs with libvirt/KVM, on the machine of the developer or on a remote hypervisor, so
+// that WMCB can be developed and tested without a cloud account. The VMs boot from a copy on write overlay of a Windows
+// qcow2 image with the virtio drivers and cloudbase-init installed, and are bridged to the network of the cluster.
+// virt-install and virsh are used rather than the libvirt API, which would require cgo.
+package libvirt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/credentials"
+)
+
+const (
+	// DefaultURI is the libvirt connection URI of the local hypervisor
+	DefaultURI = "qemu:///system"
+	// DefaultPool is the storage pool the disks of the VMs are created in if the spec does not specify it
+	DefaultPool = "default"
+	// DefaultMemory is the memory in MiB of the VMs if the spec does not specify it
+	DefaultMemory = 8192
+	// DefaultVCPUs is the number of virtual CPUs of the VMs if the spec does not specify it
+	DefaultVCPUs = 4
+	// pollInterval is the interval at which the address of a new VM is looked up
+	pollInterval = 10 * time.Second
+)
+
+// addressSources are the sources the address of a VM is looked up from: the DHCP leases of the libvirt networks, the
+// QEMU guest agent and the ARP table of the hypervisor, which is the only one available for bridged VMs without the
+// guest agent
+var addressSources = []string{"lease", "agent", "arp"}
+
+// InstanceSpec describes the Windows VM to create
+type InstanceSpec struct {
+	// Name is the name of the libvirt domain of the VM
+	Name string
+	// BaseImage is the path on the hypervisor of the Windows qcow2 image the disk of the VM is an overlay of. The image
+	// needs to have the virtio drivers and cloudbase-init with the NoCloud config drive service installed.
+	BaseImage string
+	// Pool is the storage pool the disk of the VM is created in. DefaultPool is used if it is empty.
+	Pool string
+	// Bridge is the bridge of the hypervisor connected to the network of the cluster the VM is attached to
+	Bridge string
+	// Memory is the memory of the VM in MiB. DefaultMemory is used if it is zero.
+	Memory int
+	// VCPUs is the number of virtual CPUs of the VM. DefaultVCPUs is used if it is zero.
+	VCPUs int
+	// UserData is the user data run by cloudbase-init when the VM first boots
+	UserData []byte
+}
+
+// Instance is a Windows VM created with libvirt
+type Instance struct {
+	// Name is the name of the libvirt domain of the VM
+	Name string
+	// IPAddress is the address of the VM on the network of the cluster
+	IPAddress string
+}
+
+// Credentials returns the credentials the VM is accessed with, the name of the domain identifying the VM like the
+// instance ID of a cloud instance. The ssh key and password need to be set on the returned credentials.
+func (i *Instance) Credentials() *credentials.Credentials {
+	return credentials.NewCredentials(i.Name, i.IPAddress, credentials.Username)
+}
+
+// Provider creates and destroys Windows VMs on a libvirt hypervisor
+type Provider struct {
+	// uri is the libvirt connection URI of the hypervisor
+	uri string
+	// tag is the description of the domains of the VMs, which identifies the VMs created by the provider
+	tag string
+}
+
+// NewProvider returns a provider managing the Windows VMs of the hypervisor with the given libvirt connection URI, e.g.
+// qemu+ssh://user@host/system for a remote hypervisor. The domains of the VMs are described with the given tag, and
+// only the domains with this description are destroyed.
+func NewProvider(uri, tag string) (*Provider, error) {
+	if uri == "" || tag == "" {
+		return nil, fmt.Errorf("a connection URI and a tag are required")
+	}
+	for _, tool := range []string{"virt-install", "virsh"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return nil, fmt.Errorf("%s is required: %v", tool, err)
+		}
+	}
+	return &Provider{uri: uri, tag: tag}, nil
+}
+
+// run runs the given libvirt tool against the hypervisor and returns its output
+func (p *Provider) run(ctx context.Context, tool string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, tool, append([]string{"--connect", p.uri}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("error running %s %s: %v: %s", tool, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// CreateWindowsInstance creates and starts a Windows VM as per the given spec, and waits for its address to be known.
+// A VM whose address cannot be found is left behind, tagged, to be investigated and destroyed.
+func (p *Provider) CreateWindowsInstance(ctx context.Context, spec InstanceSpec) (*Instance, error) {
+	if spec.Name == "" || spec.BaseImage == "" || spec.Bridge == "" {
+		return nil, fmt.Errorf("a name, a base image and a bridge are required")
+	}
+	if spec.Pool == "" {
+		spec.Pool = DefaultPool
+	}
+	if spec.Memory == 0 {
+		spec.Memory = DefaultMemory
+	}
+	if spec.VCPUs == 0 {
+		spec.VCPUs = DefaultVCPUs
+	}
+
+	// virt-install puts the user data on a NoCloud config drive attached to the VM
+	userData, err := ioutil.TempFile("", spec.Name+"-user-data")
+	if err != nil {
+		return nil, fmt.Errorf("error creating user data file: %v", err)
+	}
+	defer os.Remove(userData.Name())
+	_, err = userData.Write(spec.UserData)
+	if closeErr := userData.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error writing user data file: %v", err)
+	}
+
+	_, err = p.run(ctx, "virt-install",
+		"--name", spec.Name,
+		"--metadata", "description="+p.tag,
+		"--memory", fmt.Sprint(spec.Memory),
+		"--vcpus", fmt.Sprint(spec.VCPUs),
+		"--os-variant", "win2k19",
+		"--import",
+		"--disk", fmt.Sprintf("pool=%s,backing_store=%s,backing_format=qcow2,format=qcow2,bus=virtio", spec.Pool,
+			spec.BaseImage),
+		"--network", "bridge="+spec.Bridge+",model=virtio",
+		"--cloud-init", "user-data="+userData.Name(),
+		"--graphics", "vnc",
+		"--noautoconsole")
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("created VM %s", spec.Name)
+
+	address, err := p.waitForAddress(ctx, spec.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &Instance{Name: spec.Name, IPAddress: address}, nil
+}
+
+// waitForAddress waits for the IPv4 address of the domain with the given name to be known from one of the address
+// sources and returns it
+func (p *Provider) waitForAddress(ctx context.Context, name string) (string, error) {
+	for {
+		var errs []error
+		for _, source := range addressSources {
+			out, err := p.run(ctx, "virsh", "domifaddr", name, "--source", source)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if address := parseDomIfAddr(out); address != "" {
+				log.Printf("VM %s has address %s", name, address)
+				return address, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("address of VM %s not found: %v: %v", name, ctx.Err(),
+				utilerrors.NewAggregate(errs))
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// parseDomIfAddr returns the first IPv4 address in the given output of virsh domifaddr, which is a table of the
+// interfaces of the domain with their name, MAC address, protocol and address with prefix
+func parseDomIfAddr(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[2] != "ipv4" {
+			continue
+		}
+		// The loopback and link local addresses reported by the guest agent cannot be reached
+		address := strings.SplitN(fields[3], "/", 2)[0]
+		if strings.HasPrefix(address, "127.") || strings.HasPrefix(address, "169.254.") {
+			continue
+		}
+		return address
+	}
+	return ""
+}
+
+// DestroyTaggedInstances destroys the domains described with the tag of the provider along with their disks, and
+// returns the names of the destroyed domains. The base image the disks are overlays of is kept.
+func (p *Provider) DestroyTaggedInstances(ctx context.Context) ([]string, error) {
+	out, err := p.run(ctx, "virsh", "list", "--all", "--name")
+	if err != nil {
+		return nil, err
+	}
+	var destroyed []string
+	var errs []error
+	for _, name := range strings.Fields(out) {
+		tagged, err := p.isTagged(ctx, name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !tagged {
+			continue
+		}
+		if err = p.destroyDomain(ctx, name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		destroyed = append(destroyed, name)
+	}
+	return destroyed, utilerrors.NewAggregate(errs)
+}
+
+// DestroyInstances destroys the domains with the given names that are described with the tag of the provider along
+// with their disks, and returns the given names of the destroyed domains and of the domains that do not exist anymore
+func (p *Provider) DestroyInstances(ctx context.Context, names []string) ([]string, error) {
+	out, err := p.run(ctx, "virsh", "list", "--all", "--name")
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool)
+	for _, name := range strings.Fields(out) {
+		found[name] = true
+	}
+	var destroyed []string
+	var errs []error
+	for _, name := range names {
+		if !found[name] {
+			destroyed = append(destroyed, name)
+			continue
+		}
+		tagged, err := p.isTagged(ctx, name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !tagged {
+			errs = append(errs, fmt.Errorf("VM %s is not described with %s", name, p.tag))
+			continue
+		}
+		if err = p.destroyDomain(ctx, name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		destroyed = append(destroyed, name)
+	}
+	return destroyed, utilerrors.NewAggregate(errs)
+}
+
+// isTagged returns true if the domain with the given name is described with the tag of the provider
+func (p *Provider) isTagged(ctx context.Context, name string) (bool, error) {
+	description, err := p.run(ctx, "virsh", "desc", name)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(description) == p.tag, nil
+}
+
+// destroyDomain stops the domain with the given name and undefines it along with its disks
+func (p *Provider) destroyDomain(ctx context.Context, name string) error {
+	// Stopping a domain that is not running fails, which is fine as long as it can be undefined
+	if _, err := p.run(ctx, "virsh", "destroy", name); err != nil {
+		log.Printf("unable to stop VM %s: %v", name, err)
+	}
+	if _, err := p.run(ctx, "virsh", "undefine", name, "--remove-all-storage"); err != nil {
+		return err
+	}
+	log.Printf("deleted VM %s", name)
+	return nil
+}
@@ -0,0 +1,326 @@
+// Package machineapi creates the Windows instances of OpenShift clusters through the Machine API, as Machines of a
+// Windows MachineSet, instead of calling the SDK of the cloud provider. The instances are then managed like the other
+// nodes of the cluster: the Machine API deletes them along with their MachineSet and replaces the failed ones.
+package machineapi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	mapi "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	machine "github.com/openshift/machine-api-operator/pkg/generated/clientset/versioned/typed/machine/v1beta1"
+	core "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/windows"
+)
+
+const (
+	// namespace is the namespace of the Machine API objects
+	namespace = "openshift-machine-api"
+	// userDataSecretName is the name of the secret holding the user data the Windows instances are created with, which
+	// is referenced by the provider spec of the generated MachineSets
+	userDataSecretName = "windows-user-data"
+	// windowsLabel is the label of the Machines of the Windows MachineSets
+	windowsLabel = "machine.openshift.io/os-id"
+	// CreatedByLabel is the label of the MachineSets created by the provider, identifying the MachineSets that are
+	// destroyed
+	CreatedByLabel = "windows-machine-config-bootstrapper.openshift.io/windows-node-installer"
+	// pollInterval is the interval at which the Machines of a MachineSet are checked
+	pollInterval = 10 * time.Second
+)
+
+// Instance is a Windows instance created as a Machine
+type Instance struct {
+	// MachineName is the name of the Machine of the instance
+	MachineName string
+	// InstanceID is the ID of the instance in the cloud provider, taken from the provider ID of the Machine
+	InstanceID string
+	// IPAddress is the internal address of the instance
+	IPAddress string
+}
+
+// Provider creates and destroys the Windows instances of a cluster through the Machine API
+type Provider struct {
+	// cloudProvider generates the Windows MachineSets and the user data of the platform of the cluster
+	cloudProvider providers.CloudProvider
+	// machineClient is the client of the Machine API objects
+	machineClient *machine.MachineV1beta1Client
+	// kubeClient is the client the user data secret is created with
+	kubeClient kubernetes.Interface
+}
+
+// NewProvider returns a provider managing the Windows instances of the cluster of the given config, whose MachineSets
+// and user data are generated by the given cloud provider
+func NewProvider(restConfig *rest.Config, cloudProvider providers.CloudProvider) (*Provider, error) {
+	if cloudProvider == nil {
+		return nil, fmt.Errorf("nil cloud provider")
+	}
+	machineClient, err := machine.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to instantiate the machine api client: %v", err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to instantiate the kubernetes client: %v", err)
+	}
+	return &Provider{
+		cloudProvider: cloudProvider,
+		machineClient: machineClient,
+		kubeClient:    kubeClient,
+	}, nil
+}
+
+// EnsureUserDataSecret creates the secret holding the user data of the Windows instances, which enables ssh authorizing
+// the given public key and WinRM over HTTPS on the instances, or updates it if it already exists. The instances
+// created before the update keep their user data.
+func (p *Provider) EnsureUserDataSecret(ctx context.Context, authorizedKey string) error {
+	secret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      userDataSecretName,
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			"userData": p.cloudProvider.GenerateUserData(authorizedKey),
+		},
+	}
+	secrets := p.kubeClient.CoreV1().Secrets(namespace)
+	existing, err := secrets.Get(ctx, secret.Name, metav1.GetOptions{})
+	if k8sapierrors.IsNotFound(err) {
+		if _, err = secrets.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("error creating secret %s: %v", secret.Name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error getting secret %s: %v", secret.Name, err)
+	}
+	existing.Data = secret.Data
+	if _, err = secrets.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("error updating secret %s: %v", secret.Name, err)
+	}
+	return nil
+}
+
+// CreateWindowsMachineSet creates a Windows MachineSet with the given number of replicas, labeled with CreatedByLabel
+// and the given labels, and returns it. WaitForInstances waits for its Machines to be provisioned.
+func (p *Provider) CreateWindowsMachineSet(ctx context.Context, replicas int32,
+	labels map[string]string) (*mapi.MachineSet, error) {
+	machineSet, err := p.cloudProvider.GenerateMachineSet(true, replicas)
+	if err != nil {
+		return nil, fmt.Errorf("error generating Windows MachineSet: %v", err)
+	}
+	return p.createWindowsMachineSet(ctx, machineSet, labels)
+}
+
+// CreateZonalWindowsMachineSets creates a Windows MachineSet per zone of the worker MachineSets of the cluster, with
+// the given number of replicas spread round-robin across the zones, labeled like CreateWindowsMachineSet does, and
+// returns them. The zones left without replicas get no MachineSet. WaitForInstances waits for the Machines of each
+// MachineSet to be provisioned.
+func (p *Provider) CreateZonalWindowsMachineSets(ctx context.Context, replicas int32,
+	labels map[string]string) ([]*mapi.MachineSet, error) {
+	zonalProvider, ok := p.cloudProvider.(providers.ZonalCloudProvider)
+	if !ok {
+		return nil, fmt.Errorf("spreading the Windows instances across zones is not supported by the cloud provider")
+	}
+	zonalMachineSets, err := zonalProvider.GenerateZonalMachineSets(true)
+	if err != nil {
+		return nil, fmt.Errorf("error generating Windows MachineSets: %v", err)
+	}
+	var created []*mapi.MachineSet
+	for _, machineSet := range providers.SpreadReplicas(zonalMachineSets, replicas) {
+		machineSet, err = p.createWindowsMachineSet(ctx, machineSet, labels)
+		if err != nil {
+			return created, err
+		}
+		created = append(created, machineSet)
+	}
+	return created, nil
+}
+
+// createWindowsMachineSet creates the given Windows MachineSet, labeled with CreatedByLabel and the given labels, and
+// returns it
+func (p *Provider) createWindowsMachineSet(ctx context.Context, machineSet *mapi.MachineSet,
+	labels map[string]string) (*mapi.MachineSet, error) {
+	if machineSet.Labels == nil {
+		machineSet.Labels = make(map[string]string)
+	}
+	for key, value := range labels {
+		machineSet.Labels[key] = value
+	}
+	machineSet.Labels[CreatedByLabel] = "true"
+	machineSet, err := CreateMachineSet(ctx, p.machineClient.MachineSets(namespace), machineSet)
+	if err != nil {
+		return nil, fmt.Errorf("error creating MachineSet: %v", err)
+	}
+	log.Printf("created MachineSet %s with %d replicas", machineSet.Name, *machineSet.Spec.Replicas)
+	return machineSet, nil
+}
+
+// ScaleWindowsMachineSet scales the existing Windows MachineSet with the given name to the given number of replicas
+// and returns it, along with its number of replicas before it was scaled, so that it can be scaled back.
+// WaitForInstances waits for its Machines to be provisioned.
+func (p *Provider) ScaleWindowsMachineSet(ctx context.Context, name string, replicas int32) (*mapi.MachineSet, int32,
+	error) {
+	machineSets := p.machineClient.MachineSets(namespace)
+	machineSet, err := machineSets.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting MachineSet %s: %v", name, err)
+	}
+	if !IsWindowsMachineSet(machineSet) {
+		return nil, 0, fmt.Errorf("MachineSet %s is not a Windows MachineSet, its Machines are not labeled %s=Windows",
+			name, windowsLabel)
+	}
+	var previous int32
+	if machineSet.Spec.Replicas != nil {
+		previous = *machineSet.Spec.Replicas
+	}
+	machineSet.Spec.Replicas = &replicas
+	if machineSet, err = machineSets.Update(ctx, machineSet, metav1.UpdateOptions{}); err != nil {
+		return nil, 0, fmt.Errorf("error scaling MachineSet %s: %v", name, err)
+	}
+	log.Printf("scaled MachineSet %s from %d to %d replicas", name, previous, replicas)
+	return machineSet, previous, nil
+}
+
+// WaitForInstances waits for the given number of Machines of the MachineSet with the given name to be provisioned,
+// with an internal address, and returns their instances. A Machine that fails is reported right away.
+func (p *Provider) WaitForInstances(ctx context.Context, machineSetName string, replicas int32) ([]Instance, error) {
+	for {
+		var machines *mapi.MachineList
+		err := windows.KubeAPIRetryPolicy.Do(ctx, "listing Machines", func() error {
+			var err error
+			machines, err = p.machineClient.Machines(namespace).List(ctx, metav1.ListOptions{LabelSelector: windowsLabel})
+			return err
+		}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error listing the Machines of MachineSet %s: %v", machineSetName, err)
+		}
+		var instances []Instance
+		for _, m := range machines.Items {
+			// The selector of the generated MachineSets can match the Machines of other MachineSets
+			if m.DeletionTimestamp != nil || !OwnedBy(m, machineSetName) {
+				continue
+			}
+			if m.Status.ErrorMessage != nil {
+				return nil, fmt.Errorf("machine %s failed: %s", m.Name, *m.Status.ErrorMessage)
+			}
+			if instance, ok := provisionedInstance(m); ok {
+				instances = append(instances, instance)
+			}
+		}
+		if len(instances) >= int(replicas) {
+			return instances, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%d of the %d Machines of MachineSet %s are provisioned: %v", len(instances),
+				replicas, machineSetName, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// IsWindowsMachineSet returns true if the Machines of the given MachineSet are labeled as Windows Machines
+func IsWindowsMachineSet(machineSet *mapi.MachineSet) bool {
+	return machineSet.Spec.Template.Labels[windowsLabel] == "Windows"
+}
+
+// OwnedBy returns true if the given Machine belongs to the MachineSet with the given name
+func OwnedBy(m mapi.Machine, machineSetName string) bool {
+	for _, owner := range m.OwnerReferences {
+		if owner.Kind == "MachineSet" && owner.Name == machineSetName {
+			return true
+		}
+	}
+	return false
+}
+
+// InstanceID returns the ID of the instance in the cloud provider of the given provider ID of a Machine, which is the
+// last element of the provider ID, e.g. i-078285fdadccb2eaa for aws:///us-east-1e/i-078285fdadccb2eaa
+func InstanceID(providerID string) string {
+	providerTokens := strings.Split(providerID, "/")
+	return providerTokens[len(providerTokens)-1]
+}
+
+// provisionedInstance returns the instance of the given Machine, and false if the Machine is not provisioned yet
+func provisionedInstance(m mapi.Machine) (Instance, bool) {
+	if m.Status.Phase == nil || (*m.Status.Phase != "Provisioned" && *m.Status.Phase != "Running") ||
+		m.Spec.ProviderID == nil {
+		return Instance{}, false
+	}
+	instance := Instance{MachineName: m.Name, InstanceID: InstanceID(*m.Spec.ProviderID)}
+	for _, address := range m.Status.Addresses {
+		if address.Type == core.NodeInternalIP {
+			instance.IPAddress = address.Address
+		}
+	}
+	if instance.InstanceID == "" || instance.IPAddress == "" {
+		return Instance{}, false
+	}
+	return instance, true
+}
+
+// DestroyWindowsMachineSets deletes the MachineSets labeled with CreatedByLabel, the Machine API deleting their
+// instances, and returns the names of the deleted MachineSets. The Windows MachineSets scaled by the provider are not
+// deleted.
+func (p *Provider) DestroyWindowsMachineSets(ctx context.Context) ([]string, error) {
+	machineSets := p.machineClient.MachineSets(namespace)
+	var list *mapi.MachineSetList
+	err := windows.KubeAPIRetryPolicy.Do(ctx, "listing MachineSets", func() error {
+		var err error
+		list, err = machineSets.List(ctx, metav1.ListOptions{LabelSelector: CreatedByLabel})
+		return err
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error listing MachineSets: %v", err)
+	}
+	var destroyed []string
+	var errs []error
+	for _, machineSet := range list.Items {
+		if err := DeleteMachineSet(ctx, machineSets, machineSet.Name); err != nil {
+			errs = append(errs, fmt.Errorf("error deleting MachineSet %s: %v", machineSet.Name, err))
+			continue
+		}
+		destroyed = append(destroyed, machineSet.Name)
+	}
+	return destroyed, utilerrors.NewAggregate(errs)
+}
+
+// CreateMachineSet creates the given MachineSet with the given client, retrying as per the Kubernetes API retry policy,
+// and returns the created MachineSet. A MachineSet found to exist when retrying was created by the failed attempt.
+func CreateMachineSet(ctx context.Context, machineSets machine.MachineSetInterface,
+	machineSet *mapi.MachineSet) (*mapi.MachineSet, error) {
+	var created *mapi.MachineSet
+	attempt := 0
+	err := windows.KubeAPIRetryPolicy.Do(ctx, "creating MachineSet "+machineSet.Name, func() error {
+		attempt++
+		var err error
+		created, err = machineSets.Create(ctx, machineSet, metav1.CreateOptions{})
+		if attempt > 1 && k8sapierrors.IsAlreadyExists(err) {
+			created, err = machineSets.Get(ctx, machineSet.Name, metav1.GetOptions{})
+		}
+		return err
+	}, nil)
+	return created, err
+}
+
+// DeleteMachineSet deletes the MachineSet with the given name with the given client, if it still exists, retrying as
+// per the Kubernetes API retry policy
+func DeleteMachineSet(ctx context.Context, machineSets machine.MachineSetInterface, name string) error {
+	return windows.KubeAPIRetryPolicy.Do(ctx, "deleting MachineSet "+name, func() error {
+		err := machineSets.Delete(ctx, name, metav1.DeleteOptions{})
+		if k8sapierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}, nil)
+}
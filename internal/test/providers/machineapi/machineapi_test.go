@@ -0,0 +1,111 @@
+package machineapi
+
+import (
+	"testing"
+
+	mapi "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newMachine returns a Machine of the MachineSet with the given name in the given phase, with the given provider ID
+// and internal address if they are not empty
+func newMachine(machineSetName, phase, providerID, address string) mapi.Machine {
+	m := mapi.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            machineSetName + "-abcde",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "MachineSet", Name: machineSetName}},
+		},
+	}
+	if phase != "" {
+		m.Status.Phase = &phase
+	}
+	if providerID != "" {
+		m.Spec.ProviderID = &providerID
+	}
+	if address != "" {
+		m.Status.Addresses = []core.NodeAddress{
+			{Type: core.NodeHostName, Address: "windows-host"},
+			{Type: core.NodeInternalIP, Address: address},
+		}
+	}
+	return m
+}
+
+func TestInstanceID(t *testing.T) {
+	tests := []struct {
+		providerID string
+		expected   string
+	}{
+		{"aws:///us-east-1e/i-078285fdadccb2eaa", "i-078285fdadccb2eaa"},
+		{"azure:///subscriptions/s/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/winvm", "winvm"},
+		{"i-078285fdadccb2eaa", "i-078285fdadccb2eaa"},
+		{"aws:///us-east-1e/", ""},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, InstanceID(test.providerID), test.providerID)
+	}
+}
+
+func TestOwnedBy(t *testing.T) {
+	m := newMachine("windows", "Running", "", "")
+	assert.True(t, OwnedBy(m, "windows"))
+	assert.False(t, OwnedBy(m, "linux"))
+	m.OwnerReferences[0].Kind = "Deployment"
+	assert.False(t, OwnedBy(m, "windows"), "only MachineSets own Machines")
+}
+
+func TestIsWindowsMachineSet(t *testing.T) {
+	machineSet := &mapi.MachineSet{}
+	assert.False(t, IsWindowsMachineSet(machineSet))
+	machineSet.Spec.Template.Labels = map[string]string{windowsLabel: "Windows"}
+	assert.True(t, IsWindowsMachineSet(machineSet))
+	machineSet.Spec.Template.Labels[windowsLabel] = "rhcos"
+	assert.False(t, IsWindowsMachineSet(machineSet))
+}
+
+func TestProvisionedInstance(t *testing.T) {
+	tests := []struct {
+		name     string
+		machine  mapi.Machine
+		expected Instance
+		ok       bool
+	}{
+		{
+			name:     "provisioned",
+			machine:  newMachine("windows", "Provisioned", "aws:///us-east-1e/i-0123", "10.0.1.10"),
+			expected: Instance{MachineName: "windows-abcde", InstanceID: "i-0123", IPAddress: "10.0.1.10"},
+			ok:       true,
+		},
+		{
+			name:     "running",
+			machine:  newMachine("windows", "Running", "aws:///us-east-1e/i-0123", "10.0.1.10"),
+			expected: Instance{MachineName: "windows-abcde", InstanceID: "i-0123", IPAddress: "10.0.1.10"},
+			ok:       true,
+		},
+		{
+			name:    "provisioning",
+			machine: newMachine("windows", "Provisioning", "aws:///us-east-1e/i-0123", "10.0.1.10"),
+		},
+		{
+			name:    "no phase",
+			machine: newMachine("windows", "", "aws:///us-east-1e/i-0123", "10.0.1.10"),
+		},
+		{
+			name:    "no provider ID",
+			machine: newMachine("windows", "Provisioned", "", "10.0.1.10"),
+		},
+		{
+			name:    "no internal address",
+			machine: newMachine("windows", "Provisioned", "aws:///us-east-1e/i-0123", ""),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance, ok := provisionedInstance(test.machine)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.expected, instance)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+// Package userdata generates the user data the Windows VMs are created with, which prepares them to be accessed by the
+// tests, so that the images the VMs are created from do not need to have ssh or WinRM enabled
+package userdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// setupScript is the PowerShell script preparing a Windows VM to be accessed by the tests. The sshd service is started
+// to create the default sshd_config file. This file is modified for enabling publicKey auth and the service is
+// restarted for the changes to take effect. WinRM listens over HTTPS with a self signed certificate, which is accepted
+// by the WinRM transport. %s is replaced by the authorized ssh public key.
+const setupScript = `Add-WindowsCapability -Online -Name OpenSSH.Server~~~~0.0.1.0
+$firewallRuleName = "ContainerLogsPort"
+$containerLogsPort = "10250"
+New-NetFirewallRule -DisplayName $firewallRuleName -Direction Inbound -Action Allow -Protocol TCP -LocalPort $containerLogsPort -EdgeTraversalPolicy Allow
+if (-not (Get-NetFirewallRule -Name OpenSSH-Server-In-TCP -ErrorAction SilentlyContinue)) {
+	New-NetFirewallRule -Name OpenSSH-Server-In-TCP -DisplayName "OpenSSH Server (sshd)" -Direction Inbound -Action Allow -Protocol TCP -LocalPort 22
+}
+Install-PackageProvider -Name NuGet -MinimumVersion 2.8.5.201 -Force
+Install-Module -Force OpenSSHUtils
+Set-Service -Name ssh-agent -StartupType 'Automatic'
+Set-Service -Name sshd -StartupType 'Automatic'
+Start-Service ssh-agent
+Start-Service sshd
+$pubKeyConf = (Get-Content -path C:\ProgramData\ssh\sshd_config) -replace '#PubkeyAuthentication yes','PubkeyAuthentication yes'
+$pubKeyConf | Set-Content -Path C:\ProgramData\ssh\sshd_config
+$passwordConf = (Get-Content -path C:\ProgramData\ssh\sshd_config) -replace '#PasswordAuthentication yes','PasswordAuthentication yes'
+$passwordConf | Set-Content -Path C:\ProgramData\ssh\sshd_config
+$authFileConf = (Get-Content -path C:\ProgramData\ssh\sshd_config) -replace 'AuthorizedKeysFile __PROGRAMDATA__/ssh/administrators_authorized_keys','#AuthorizedKeysFile __PROGRAMDATA__/ssh/administrators_authorized_keys'
+$authFileConf | Set-Content -Path C:\ProgramData\ssh\sshd_config
+$pubKeyLocationConf = (Get-Content -path C:\ProgramData\ssh\sshd_config) -replace 'Match Group administrators','#Match Group administrators'
+$pubKeyLocationConf | Set-Content -Path C:\ProgramData\ssh\sshd_config
+Restart-Service sshd
+New-item -Path $env:USERPROFILE -Name .ssh -ItemType Directory -force
+echo "%s"| Out-File $env:USERPROFILE\.ssh\authorized_keys -Encoding ascii
+Set-Service -Name WinRM -StartupType 'Automatic'
+Start-Service WinRM
+if (-not (Get-ChildItem -Path WSMan:\localhost\Listener | Where-Object { $_.Keys -contains 'Transport=HTTPS' })) {
+	$cert = New-SelfSignedCertificate -DnsName $env:COMPUTERNAME -CertStoreLocation Cert:\LocalMachine\My
+	New-Item -Path WSMan:\localhost\Listener -Transport HTTPS -Address * -CertificateThumbPrint $cert.Thumbprint -Force
+}
+if (-not (Get-NetFirewallRule -DisplayName WinRMHTTPS -ErrorAction SilentlyContinue)) {
+	New-NetFirewallRule -DisplayName WinRMHTTPS -Direction Inbound -Action Allow -Protocol TCP -LocalPort 5986
+}
+`
+
+// SetupScript returns the PowerShell script that installs and enables the OpenSSH server authorizing the given public
+// key, configures WinRM over HTTPS and opens the ports required by the tests
+func SetupScript(authorizedKey string) string {
+	return fmt.Sprintf(setupScript, strings.TrimSpace(authorizedKey))
+}
+
+// PowerShellUserData returns the given PowerShell script as user data run by the EC2Launch agent of the Windows AMIs.
+// The script is run at every boot, so that the VM can be accessed even if the first run failed.
+func PowerShellUserData(script string) []byte {
+	return []byte("<powershell>\n" + script + "</powershell>\n<persist>true</persist>")
+}
+
+// CloudbaseInitUserData returns the given PowerShell script as user data run by cloudbase-init, which the Windows
+// images of IBM Cloud VPC and OpenStack boot with. The script is run once, when the VM is first booted.
+func CloudbaseInitUserData(script string) []byte {
+	return []byte("#ps1_sysnative\n" + script)
+}
@@ -0,0 +1,215 @@
+package windows
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/masterzen/winrm"
+	"golang.org/x/crypto/ssh"
+)
+
+// Command is a command run on the Windows VM with RunCommand
+type Command struct {
+	// Command is the command line that is run
+	Command string
+	// PowerShell indicates that the command is run in PowerShell rather than in cmd.exe
+	PowerShell bool
+	// Env holds the environment variables the command is run with, on top of the environment of the remote user. In
+	// cmd.exe, the variables are visible to the programs run by the command but are not expanded in the command line.
+	Env map[string]string
+	// Timeout is the time after which the command is aborted. The command is only bounded by the context if it is zero.
+	Timeout time.Duration
+	// Output, if set, receives the standard output of the command as it is produced over ssh, and once the command
+	// completes over WinRM. It receives the output of every attempt if the command is retried.
+	Output io.Writer
+	// Idempotent indicates that running the command more than once has the same effect as running it once, in which
+	// case it is run again if the connection to the Windows VM fails while it is running. Other commands, for example
+	// bootstrapping the node, are not retried as they may have run before the connection failed.
+	Idempotent bool
+}
+
+// CommandResult is the outcome of a command that ran on the Windows VM
+type CommandResult struct {
+	// Stdout is the standard output of the command
+	Stdout string
+	// Stderr is the standard error of the command
+	Stderr string
+	// ExitCode is the exit code of the command
+	ExitCode int
+}
+
+// ExitError is returned by Run when a command exits with a non zero exit code
+type ExitError struct {
+	// ExitCode is the exit code of the command
+	ExitCode int
+	// Stderr is the standard error of the command
+	Stderr string
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("command exited with code %d: %s", e.ExitCode, e.Stderr)
+}
+
+// commandLine returns the command line that runs the given command with its environment variables. The environment
+// variables are set in a sorted order, so that the command line is deterministic.
+func commandLine(c Command) (string, error) {
+	names := make([]string, 0, len(c.Env))
+	for name := range c.Env {
+		if name == "" || strings.ContainsAny(name, "=\"' ") {
+			return "", fmt.Errorf("invalid environment variable name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		if c.PowerShell {
+			// Single quoted strings are not expanded by PowerShell, and quotes are escaped by doubling them
+			fmt.Fprintf(&b, "$env:%s='%s'; ", name, strings.ReplaceAll(c.Env[name], "'", "''"))
+		} else {
+			// The quotes prevent trailing spaces from being added to the value
+			fmt.Fprintf(&b, "set \"%s=%s\" && ", name, c.Env[name])
+		}
+	}
+	b.WriteString(c.Command)
+	return b.String(), nil
+}
+
+// RunCommand runs the given command on the Windows VM and returns its stdout, stderr and exit code. A command that ran
+// and exited with a non zero exit code is not an error, the exit code is returned in the result. An error is returned
+// only if the command could not be run or did not complete, for example because the connection to the Windows VM
+// failed or the context was done. Idempotent commands are retried on transient errors. Over ssh, the command is
+// terminated if the context is done or the timeout of the command expires while it is running. Over WinRM, the
+// command keeps running on the Windows VM.
+func (w *Windows) RunCommand(ctx context.Context, c Command) (*CommandResult, error) {
+	cmd, err := commandLine(c)
+	if err != nil {
+		return nil, err
+	}
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var result *CommandResult
+	run := func() error {
+		var err error
+		result, err = w.runCommand(ctx, cmd, c.PowerShell, c.Output)
+		return err
+	}
+	if c.Idempotent {
+		err = w.withRetry(ctx, "running command", run)
+	} else {
+		err = run()
+	}
+	if err != nil && c.Timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("command timed out after %v: %v", c.Timeout, err)
+	}
+	return result, err
+}
+
+// runCommand runs the given command line on the Windows VM over the transport, writing its standard output to the
+// given writer if it is not nil
+func (w *Windows) runCommand(ctx context.Context, cmd string, psCmd bool, output io.Writer) (*CommandResult, error) {
+	if w.Transport == WinRMTransport {
+		result, err := w.runCommandWinRM(ctx, cmd, psCmd)
+		if err == nil && output != nil {
+			io.WriteString(output, result.Stdout)
+		}
+		return result, err
+	}
+	return w.runCommandSSH(ctx, cmd, psCmd, output)
+}
+
+// runCommandSSH runs the given command line on the Windows VM over ssh, terminating it once the context is done
+func (w *Windows) runCommandSSH(ctx context.Context, cmd string, psCmd bool, output io.Writer) (*CommandResult,
+	error) {
+	if w.SSHClient == nil {
+		return nil, fmt.Errorf("commands cannot be run without a ssh client")
+	}
+
+	session, err := w.SSHClient.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	// Closing the session terminates the command
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.Close()
+		case <-done:
+		}
+	}()
+
+	if psCmd {
+		cmd = remotePowerShellCmdPrefix + cmd
+	}
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	if output != nil {
+		session.Stdout = io.MultiWriter(&stdout, output)
+	}
+	session.Stderr = &stderr
+	err = session.Run(cmd)
+	// The error of a command terminated by closing the session is not meaningful
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	result := &CommandResult{Stdout: stdout.String(), Stderr: stderr.String()}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitStatus()
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// runCommandWinRM runs the given command line on the Windows VM over WinRM. The WinRM client cannot abort a command,
+// so the command is left running on the Windows VM if the context is done before it completes.
+func (w *Windows) runCommandWinRM(ctx context.Context, cmd string, psCmd bool) (*CommandResult, error) {
+	if w.WinRMClient == nil {
+		return nil, fmt.Errorf("commands cannot be run without a WinRM client")
+	}
+
+	if psCmd {
+		cmd = winrm.Powershell(cmd)
+	}
+
+	type outcome struct {
+		result *CommandResult
+		err    error
+	}
+	// The channel is buffered so that the goroutine does not leak if the context is done first
+	outcomes := make(chan outcome, 1)
+	go func() {
+		stdout, stderr, exitCode, err := w.WinRMClient.RunWithString(cmd, "")
+		if err != nil {
+			outcomes <- outcome{err: err}
+			return
+		}
+		outcomes <- outcome{result: &CommandResult{Stdout: stdout, Stderr: stderr, ExitCode: exitCode}}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case o := <-outcomes:
+		return o.result, o.err
+	}
+}
@@ -0,0 +1,224 @@
+package windows
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+)
+
+// maxParallelCopies is the maximum number of files that are copied to the Windows VM concurrently over sftp
+const maxParallelCopies = 4
+
+// fileCopy is a local file along with the remote directory it is copied to
+type fileCopy struct {
+	// localPath is the path of the file on the local host
+	localPath string
+	// remoteDir is the directory on the Windows VM the file is copied to
+	remoteDir string
+}
+
+// copyPlan holds the remote directories to create and the files to copy to the Windows VM
+type copyPlan struct {
+	// dirs are the directories on the Windows VM that are created before copying the files, including the empty ones.
+	// A directory can be listed more than once.
+	dirs []string
+	// files are the files that are copied
+	files []fileCopy
+}
+
+// add adds the given local file or directory, along with the files and directories nested in it, to the plan. Their
+// path relative to the given base directory is preserved in the remote directory.
+func (p *copyPlan) add(localPath, baseDir, remoteDir string) error {
+	return filepath.Walk(localPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			dir, err := remoteSubDir(remoteDir, baseDir, path)
+			if err != nil {
+				return err
+			}
+			p.dirs = append(p.dirs, dir)
+			return nil
+		}
+		dir, err := remoteSubDir(remoteDir, baseDir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		p.dirs = append(p.dirs, dir)
+		p.files = append(p.files, fileCopy{localPath: path, remoteDir: dir})
+		return nil
+	})
+}
+
+// remoteSubDir returns the directory in the given remote directory that corresponds to the given local directory
+// relative to the given base directory
+func remoteSubDir(remoteDir, baseDir, localDir string) (string, error) {
+	relDir, err := filepath.Rel(baseDir, localDir)
+	if err != nil {
+		return "", err
+	}
+	if relDir == "." {
+		return remoteDir, nil
+	}
+	return remoteJoin(remoteDir, strings.ReplaceAll(filepath.ToSlash(relDir), "/", "\\")), nil
+}
+
+// globBase returns the directory of the given glob pattern up to its first element containing a pattern
+func globBase(pattern string) string {
+	base := filepath.Dir(pattern)
+	for strings.ContainsAny(base, "*?[") {
+		base = filepath.Dir(base)
+	}
+	return base
+}
+
+func (w *Windows) CopyDirectoryRecursive(localDir, remoteDir string) error {
+	return w.CopyDirectoryRecursiveContext(context.Background(), localDir, remoteDir)
+}
+
+// CopyDirectoryRecursiveContext copies the files and nested directories from the local directory to the remote
+// directory in the Windows VM, preserving the directory structure. Over ssh the files are copied concurrently. The
+// copy is retried on transient errors until the context is done, skipping the files that have already been copied.
+func (w *Windows) CopyDirectoryRecursiveContext(ctx context.Context, localDir, remoteDir string) error {
+	log.Printf("Copying %s directory recursively to Windows VM: %s", localDir, remoteDir)
+	plan := &copyPlan{}
+	if err := plan.add(localDir, localDir, remoteDir); err != nil {
+		return fmt.Errorf("error reading local directory %s: %v", localDir, err)
+	}
+	return w.copyPlanContext(ctx, plan)
+}
+
+func (w *Windows) CopyGlob(pattern, remoteDir string) error {
+	return w.CopyGlobContext(context.Background(), pattern, remoteDir)
+}
+
+// CopyGlobContext copies the local files and directories matching the given glob pattern, along with the contents of
+// the matching directories, to the remote directory in the Windows VM. The path of the matches relative to the
+// directory the pattern starts matching in is preserved, for example copying /payload/*/*.exe to C:\k copies
+// /payload/cni/host-local.exe to C:\k\cni\host-local.exe. Over ssh the files are copied concurrently. The copy is
+// retried on transient errors until the context is done, skipping the files that have already been copied.
+func (w *Windows) CopyGlobContext(ctx context.Context, pattern, remoteDir string) error {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern %s: %v", pattern, err)
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("no files match %s", pattern)
+	}
+	log.Printf("Copying %d matches of %s to Windows VM: %s", len(matches), pattern, remoteDir)
+	plan := &copyPlan{}
+	baseDir := globBase(pattern)
+	for _, match := range matches {
+		if err = plan.add(match, baseDir, remoteDir); err != nil {
+			return fmt.Errorf("error reading %s: %v", match, err)
+		}
+	}
+	return w.copyPlanContext(ctx, plan)
+}
+
+// copyPlanContext creates the directories and copies the files of the plan to the Windows VM, retrying on transient
+// errors until the context is done. The files that have been copied are not copied again when retrying.
+func (w *Windows) copyPlanContext(ctx context.Context, plan *copyPlan) error {
+	copied := make([]bool, len(plan.files))
+	return w.withRetry(ctx, fmt.Sprintf("copying %d files", len(plan.files)), func() error {
+		return w.copyPlan(ctx, plan, copied)
+	})
+}
+
+// copyPlan creates the directories of the plan and copies the files of the plan that have not been copied yet to the
+// Windows VM, marking them as copied. The files are copied sequentially over WinRM and concurrently over ssh, using one
+// sftp client with up to maxParallelCopies streams.
+func (w *Windows) copyPlan(ctx context.Context, plan *copyPlan, copied []bool) error {
+	// The directories are created upfront, as creating the same directory concurrently fails
+	var dirs []string
+	created := make(map[string]bool)
+	for _, dir := range plan.dirs {
+		if !created[dir] {
+			dirs = append(dirs, dir)
+			created[dir] = true
+		}
+	}
+
+	// The files are copied with sftp over WinRM too if there is a SSH client, as it is faster
+	if w.Transport == WinRMTransport && w.SSHClient == nil {
+		for _, dir := range dirs {
+			if err := w.mkdirAllWinRM(dir); err != nil {
+				return fmt.Errorf("error creating remote directory %s: %v", dir, err)
+			}
+		}
+		for i, file := range plan.files {
+			if copied[i] {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := w.copyFileWinRM(file.localPath, file.remoteDir); err != nil {
+				return err
+			}
+			copied[i] = true
+		}
+		return nil
+	}
+	if w.SSHClient == nil {
+		return fmt.Errorf("files cannot be copied without a SSH client")
+	}
+
+	ftp, err := sftp.NewClient(w.SSHClient)
+	if err != nil {
+		return err
+	}
+	defer ftp.Close()
+	for _, dir := range dirs {
+		if err = ftp.MkdirAll(dir); err != nil {
+			return fmt.Errorf("error creating remote directory %s: %v", dir, err)
+		}
+	}
+
+	indices := make(chan int)
+	// Every stream stops after its first error
+	errs := make(chan error, maxParallelCopies)
+	var wg sync.WaitGroup
+	for n := 0; n < maxParallelCopies; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				if err := copyFileSFTP(ftp, plan.files[i].localPath, plan.files[i].remoteDir); err != nil {
+					errs <- err
+					return
+				}
+				copied[i] = true
+			}
+		}()
+	}
+
+feed:
+	for i := range plan.files {
+		if copied[i] {
+			continue
+		}
+		select {
+		case indices <- i:
+		case err = <-errs:
+			break feed
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		}
+	}
+	close(indices)
+	wg.Wait()
+	close(errs)
+	if err != nil {
+		return err
+	}
+	return <-errs
+}
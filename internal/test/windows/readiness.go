@@ -0,0 +1,78 @@
+package windows
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// readinessDialTimeout is the timeout of the connection attempts checking that the port of the transport is open
+const readinessDialTimeout = 5 * time.Second
+
+// ReadinessProbe checks whether a Windows VM has reached a stage of its setup, for example that it has booted or that
+// its ssh server accepts connections
+type ReadinessProbe struct {
+	// Name describes the stage the probe checks
+	Name string
+	// Check returns nil if the Windows VM has reached the stage. The error describing why it has not is retried until
+	// the stage is reached.
+	Check func(context.Context) error
+}
+
+// WaitForReadiness runs the given probes in order, polling each probe at the given interval until it succeeds before
+// running the next one. An error describing the last failure of the pending probe is returned if the context is done
+// before every probe succeeds.
+func WaitForReadiness(ctx context.Context, interval time.Duration, probes ...ReadinessProbe) error {
+	start := time.Now()
+	for _, probe := range probes {
+		for {
+			err := probe.Check(ctx)
+			if err == nil {
+				log.Printf("%s after %v", probe.Name, time.Since(start).Round(time.Second))
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("waiting for %s: %v: %v", probe.Name, ctx.Err(), err)
+			case <-time.After(interval):
+			}
+		}
+	}
+	return nil
+}
+
+// PortProbe returns the probe checking that the port of the transport accepts TCP connections, which is cheaper than
+// a full ssh or WinRM handshake while the Windows VM boots
+func (w *Windows) PortProbe() ReadinessProbe {
+	port := 22
+	if w.transport() == WinRMTransport {
+		port = winRMPort
+	}
+	return ReadinessProbe{
+		Name: fmt.Sprintf("%s:%d is listening", w.Credentials.IPAddress(), port),
+		Check: func(ctx context.Context) error {
+			return w.dialPort(ctx, port)
+		},
+	}
+}
+
+// TransportProbe returns the probe checking that the client of the transport can connect to the Windows VM. Errors
+// that are not transient are retried as well, as the credentials are only authorized once the user data of a new
+// Windows VM has run.
+func (w *Windows) TransportProbe() ReadinessProbe {
+	return ReadinessProbe{
+		Name: fmt.Sprintf("%s accepts %s connections", w.Credentials.IPAddress(), w.transport()),
+		Check: func(ctx context.Context) error {
+			if err := w.connect(); err != nil {
+				return err
+			}
+			if w.transport() != WinRMTransport {
+				return nil
+			}
+			// The WinRM client only connects to the Windows VM when running a command
+			_, err := w.runCommandWinRM(ctx, "echo ready", false)
+			return err
+		},
+	}
+}
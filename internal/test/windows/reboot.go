@@ -0,0 +1,107 @@
+package windows
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	// rebootTimeout is the time the Windows VM has to go down and accept connections again once rebooted, if the
+	// context of the reboot has no deadline
+	rebootTimeout = 15 * time.Minute
+	// rebootInterval is the interval at which the ports of a rebooting Windows VM are checked
+	rebootInterval = 5 * time.Second
+	// rebootCommand restarts the Windows VM after a short delay, so that the command returns before the connection
+	// it runs over is closed
+	rebootCommand = "shutdown /r /f /t 5"
+)
+
+// rebootPorts are the ports of the transports that are checked to go down and come back up when the Windows VM is
+// rebooted
+var rebootPorts = []int{22, winRMPort}
+
+// Reboot restarts the Windows VM, through RebootFunc if it is set or by running shutdown /r on it otherwise. It then
+// waits for the ssh and WinRM ports that accepted connections before the reboot to stop accepting connections, and
+// to accept them again, before re-creating the client of the transport. Waiting is aborted once the context is done,
+// or after 15 minutes if the context has no deadline.
+func (w *Windows) Reboot(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rebootTimeout)
+		defer cancel()
+	}
+	var openPorts []int
+	for _, port := range rebootPorts {
+		if w.dialPort(ctx, port) == nil {
+			openPorts = append(openPorts, port)
+		}
+	}
+	if len(openPorts) == 0 {
+		return fmt.Errorf("neither the ssh nor the WinRM port of %s accept connections before the reboot",
+			w.Credentials.IPAddress())
+	}
+
+	log.Printf("rebooting %s", w.Credentials.IPAddress())
+	if w.RebootFunc != nil {
+		if err := w.RebootFunc(ctx); err != nil {
+			return fmt.Errorf("error rebooting %s: %v", w.Credentials.IPAddress(), err)
+		}
+	} else if _, err := w.run(ctx, rebootCommand, false); err != nil {
+		// The connection can be closed by the shutdown before the exit code of the command is returned
+		if !IsTransientError(err) {
+			return fmt.Errorf("error running %s: %v", rebootCommand, err)
+		}
+	}
+
+	var probes []ReadinessProbe
+	for _, port := range openPorts {
+		probes = append(probes, w.portDownProbe(port))
+	}
+	for _, port := range openPorts {
+		probes = append(probes, w.portUpProbe(port))
+	}
+	// The transport probe re-creates the client of the transport, the connection of the previous one being closed
+	probes = append(probes, w.TransportProbe())
+	if err := WaitForReadiness(ctx, rebootInterval, probes...); err != nil {
+		return fmt.Errorf("error waiting for %s to reboot: %v", w.Credentials.IPAddress(), err)
+	}
+	return nil
+}
+
+// dialPort returns nil if the given port of the Windows VM accepts TCP connections
+func (w *Windows) dialPort(ctx context.Context, port int) error {
+	dialer := net.Dialer{Timeout: readinessDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(w.Credentials.IPAddress(), strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// portDownProbe returns the probe checking that the given port of the Windows VM no longer accepts connections, which
+// signals that the Windows VM is shutting down
+func (w *Windows) portDownProbe(port int) ReadinessProbe {
+	return ReadinessProbe{
+		Name: fmt.Sprintf("port %d of %s is down", port, w.Credentials.IPAddress()),
+		Check: func(ctx context.Context) error {
+			if w.dialPort(ctx, port) == nil {
+				return fmt.Errorf("port %d still accepts connections", port)
+			}
+			return nil
+		},
+	}
+}
+
+// portUpProbe returns the probe checking that the given port of the Windows VM accepts connections again
+func (w *Windows) portUpProbe(port int) ReadinessProbe {
+	return ReadinessProbe{
+		Name: fmt.Sprintf("port %d of %s is up", port, w.Credentials.IPAddress()),
+		Check: func(ctx context.Context) error {
+			return w.dialPort(ctx, port)
+		},
+	}
+}
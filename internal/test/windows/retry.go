@@ -0,0 +1,183 @@
+package windows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// RetryPolicy configures how the remote operations on the Windows VM are retried on transient errors
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of an operation, including the first one
+	MaxAttempts int
+	// Backoff is the wait before the first retry, which is doubled after every failed attempt
+	Backoff time.Duration
+	// MaxBackoff is the maximum wait between two attempts
+	MaxBackoff time.Duration
+	// IsRetryable classifies the errors that are retried. IsTransientError is used if it is not set.
+	IsRetryable func(error) bool
+}
+
+// DefaultRetryPolicy is the retry policy used if the Windows VM does not have one
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	Backoff:     2 * time.Second,
+	MaxBackoff:  30 * time.Second,
+}
+
+// KubeAPIRetryPolicy is the retry policy of the calls to the Kubernetes API of the cluster creating, listing and
+// deleting the Machine API objects the Windows VMs are created from
+var KubeAPIRetryPolicy = RetryPolicy{
+	MaxAttempts: DefaultRetryPolicy.MaxAttempts,
+	Backoff:     DefaultRetryPolicy.Backoff,
+	MaxBackoff:  DefaultRetryPolicy.MaxBackoff,
+	IsRetryable: IsRetryableKubeAPIError,
+}
+
+// transientErrorMessages are the messages of the errors returned by the ssh, sftp and WinRM clients when the connection
+// to the Windows VM is interrupted, which are not returned as typed errors
+var transientErrorMessages = []string{
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"i/o timeout",
+	"use of closed network connection",
+	"handshake failed",
+	"no route to host",
+	"connection lost",
+}
+
+// IsTransientError returns true if the given error is caused by an interrupted or failed connection to the Windows VM,
+// rather than by the operation itself failing, for example a command exiting with a non zero exit code
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	// The command ran and failed, and the output of the command could match the transient error messages
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	for _, transient := range transientErrorMessages {
+		if strings.Contains(message, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRetryableKubeAPIError returns true if the given error of a call to the Kubernetes API is worth retrying, because
+// the API server throttled the call, was unavailable or timed out, or could not be reached
+func IsRetryableKubeAPIError(err error) bool {
+	return apierrors.IsTooManyRequests(err) || apierrors.IsServiceUnavailable(err) || apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) || apierrors.IsInternalError(err) || IsTransientError(err)
+}
+
+// IsRetryableHTTPError returns true if a request with the given HTTP method to a cloud provider API is worth retrying
+// after failing with the given error and status code, which is 0 if no response was received. Throttled requests and
+// refused connections are always retried. The server and transient errors are only retried for the idempotent methods,
+// as a POST request creating a resource may have been processed.
+func IsRetryableHTTPError(method string, statusCode int, err error) bool {
+	if statusCode == http.StatusTooManyRequests || (statusCode == 0 && errors.Is(err, syscall.ECONNREFUSED)) {
+		return true
+	}
+	if method == http.MethodPost {
+		return false
+	}
+	switch statusCode {
+	case 0:
+		return IsTransientError(err)
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Delay returns the wait before the given retry, the first retry being 0
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	delay := p.Backoff
+	for i := 0; i < retry; i++ {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return delay
+}
+
+// Do runs the given operation until it succeeds, fails with an error that is not retryable, the maximum number of
+// attempts is reached or the context is done. beforeRetry, if not nil, is called before every retry, for example to
+// reconnect to the Windows VM.
+func (p RetryPolicy) Do(ctx context.Context, operation string, fn func() error, beforeRetry func()) error {
+	isRetryable := p.IsRetryable
+	if isRetryable == nil {
+		isRetryable = IsTransientError
+	}
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = ctx.Err(); err != nil {
+			return fmt.Errorf("%s: %v", operation, err)
+		}
+		if err = fn(); err == nil || !isRetryable(err) {
+			return err
+		}
+		if attempt >= maxAttempts {
+			return fmt.Errorf("%s failed after %d attempts: %v", operation, attempt, err)
+		}
+		delay := p.Delay(attempt - 1)
+		log.Printf("%s failed with a transient error, retrying in %v: %v", operation, delay, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %v: %v", operation, ctx.Err(), err)
+		case <-time.After(delay):
+		}
+		if beforeRetry != nil {
+			beforeRetry()
+		}
+	}
+}
+
+// retryPolicy returns the retry policy of the Windows VM
+func (w *Windows) retryPolicy() RetryPolicy {
+	if w.RetryPolicy == nil {
+		return DefaultRetryPolicy
+	}
+	return *w.RetryPolicy
+}
+
+// withRetry runs the given operation on the Windows VM as per the retry policy, reconnecting to the Windows VM before
+// every retry as the connection is likely broken. It is only used for the operations that can safely be repeated:
+// setting up sessions, transferring files and running idempotent commands.
+func (w *Windows) withRetry(ctx context.Context, operation string, fn func() error) error {
+	return w.retryPolicy().Do(ctx, operation, fn, func() {
+		if err := w.connect(); err != nil {
+			log.Printf("error reconnecting to the Windows VM: %v", err)
+		}
+	})
+}
@@ -0,0 +1,196 @@
+package windows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// timeoutError is a net.Error timing out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o deadline reached" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{name: "nil", err: nil, transient: false},
+		{name: "EOF", err: io.EOF, transient: true},
+		{name: "wrapped unexpected EOF", err: fmt.Errorf("sftp: %w", io.ErrUnexpectedEOF), transient: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, transient: true},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, transient: true},
+		{name: "network timeout", err: &net.OpError{Op: "read", Err: timeoutError{}}, transient: true},
+		{name: "untyped ssh error", err: errors.New("ssh: handshake failed: EOF"), transient: true},
+		{name: "untyped broken pipe", err: errors.New("write: Broken Pipe"), transient: true},
+		{name: "exit error", err: &ExitError{ExitCode: 1, Stderr: "connection reset"}, transient: false},
+		{name: "wrapped exit error", err: fmt.Errorf("running command: %w", &ExitError{ExitCode: 2}),
+			transient: false},
+		{name: "context canceled", err: context.Canceled, transient: false},
+		{name: "context deadline", err: fmt.Errorf("copying: %w", context.DeadlineExceeded), transient: false},
+		{name: "other error", err: errors.New("permission denied"), transient: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.transient, IsTransientError(test.err))
+		})
+	}
+}
+
+func TestIsRetryableHTTPError(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}
+	reset := &net.OpError{Op: "read", Err: syscall.ECONNRESET}
+	failed := errors.New("request failed")
+	tests := []struct {
+		name       string
+		method     string
+		statusCode int
+		err        error
+		retryable  bool
+	}{
+		{name: "throttled GET", method: http.MethodGet, statusCode: http.StatusTooManyRequests, err: failed,
+			retryable: true},
+		{name: "throttled POST", method: http.MethodPost, statusCode: http.StatusTooManyRequests, err: failed,
+			retryable: true},
+		{name: "refused POST", method: http.MethodPost, err: refused, retryable: true},
+		{name: "reset GET", method: http.MethodGet, err: reset, retryable: true},
+		{name: "reset POST", method: http.MethodPost, err: reset, retryable: false},
+		{name: "unavailable DELETE", method: http.MethodDelete, statusCode: http.StatusServiceUnavailable,
+			err: failed, retryable: true},
+		{name: "unavailable POST", method: http.MethodPost, statusCode: http.StatusServiceUnavailable, err: failed,
+			retryable: false},
+		{name: "not found GET", method: http.MethodGet, statusCode: http.StatusNotFound, err: failed,
+			retryable: false},
+		{name: "forbidden DELETE", method: http.MethodDelete, statusCode: http.StatusForbidden, err: failed,
+			retryable: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.retryable, IsRetryableHTTPError(test.method, test.statusCode, test.err))
+		})
+	}
+}
+
+func TestIsRetryableKubeAPIError(t *testing.T) {
+	resource := schema.GroupResource{Group: "machine.openshift.io", Resource: "machinesets"}
+	assert.True(t, IsRetryableKubeAPIError(apierrors.NewTooManyRequests("throttled", 1)))
+	assert.True(t, IsRetryableKubeAPIError(apierrors.NewServiceUnavailable("unavailable")))
+	assert.True(t, IsRetryableKubeAPIError(apierrors.NewServerTimeout(resource, "create", 1)))
+	assert.True(t, IsRetryableKubeAPIError(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}))
+	assert.False(t, IsRetryableKubeAPIError(apierrors.NewNotFound(resource, "windows")))
+	assert.False(t, IsRetryableKubeAPIError(apierrors.NewAlreadyExists(resource, "windows")))
+	assert.False(t, IsRetryableKubeAPIError(apierrors.NewForbidden(resource, "windows", errors.New("denied"))))
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, policy.Delay(0))
+	assert.Equal(t, 2*time.Second, policy.Delay(1))
+	assert.Equal(t, 4*time.Second, policy.Delay(2))
+	assert.Equal(t, 5*time.Second, policy.Delay(3), "expected the delay to be capped")
+	assert.Equal(t, 5*time.Second, policy.Delay(40), "expected the delay to be capped")
+	assert.Equal(t, 8*time.Second, RetryPolicy{Backoff: time.Second}.Delay(3), "expected no cap without a max")
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	transient := &net.OpError{Op: "read", Err: syscall.ECONNRESET}
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	t.Run("success after transient errors", func(t *testing.T) {
+		attempts, retries := 0, 0
+		err := policy.Do(context.Background(), "operation", func() error {
+			attempts++
+			if attempts < 3 {
+				return transient
+			}
+			return nil
+		}, func() { retries++ })
+		assert.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+		assert.Equal(t, 2, retries, "expected beforeRetry to be called before every retry")
+	})
+
+	t.Run("error that is not retryable", func(t *testing.T) {
+		attempts := 0
+		exitErr := &ExitError{ExitCode: 1}
+		err := policy.Do(context.Background(), "operation", func() error {
+			attempts++
+			return exitErr
+		}, nil)
+		assert.Equal(t, exitErr, err, "expected the error to be returned as is")
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		attempts := 0
+		err := policy.Do(context.Background(), "operation", func() error {
+			attempts++
+			return transient
+		}, nil)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "operation failed after 3 attempts")
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("single attempt without max attempts", func(t *testing.T) {
+		attempts := 0
+		err := RetryPolicy{}.Do(context.Background(), "operation", func() error {
+			attempts++
+			return transient
+		}, nil)
+		assert.Error(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("custom classification", func(t *testing.T) {
+		attempts := 0
+		custom := policy
+		custom.IsRetryable = func(err error) bool { return err == io.ErrNoProgress }
+		err := custom.Do(context.Background(), "operation", func() error {
+			attempts++
+			if attempts == 1 {
+				return io.ErrNoProgress
+			}
+			return transient
+		}, nil)
+		assert.Equal(t, transient, err, "expected the transient error not to be retried")
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		attempts := 0
+		slow := RetryPolicy{MaxAttempts: 3, Backoff: time.Hour}
+		err := slow.Do(ctx, "operation", func() error {
+			attempts++
+			cancel()
+			return transient
+		}, nil)
+		assert.Error(t, err)
+		assert.True(t, errors.Is(ctx.Err(), context.Canceled))
+		assert.Contains(t, err.Error(), context.Canceled.Error())
+		assert.Equal(t, 1, attempts, "expected no retry once the context is done")
+
+		attempts = 0
+		err = policy.Do(ctx, "operation", func() error {
+			attempts++
+			return nil
+		}, nil)
+		assert.Error(t, err)
+		assert.Equal(t, 0, attempts, "expected no attempt with a done context")
+	})
+}
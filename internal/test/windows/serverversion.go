@@ -0,0 +1,109 @@
+package windows
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultPauseImage is the pause image WMCB configures the kubelet with on the Windows builds that do not require a
+	// specific one
+	defaultPauseImage = "mcr.microsoft.com/oss/kubernetes/pause:3.4.1"
+	// buildNumberCmd is the PowerShell command printing the build number of the Windows VM
+	buildNumberCmd = "(Get-ItemProperty 'HKLM:\\SOFTWARE\\Microsoft\\Windows NT\\CurrentVersion').CurrentBuildNumber"
+)
+
+// ServerVersion describes a Windows Server version the tests can be run against, along with the behavior of WMCB that
+// depends on its build
+type ServerVersion struct {
+	// Name is the name the version is selected by, like 2019
+	Name string
+	// Build is the build number of the version, which WMCB selects the build specific behavior by
+	Build int
+	// PauseImage is the pause image WMCB configures the kubelet with on the version, as the Windows container images
+	// need to match the build of the host
+	PauseImage string
+	// ServerCoreImage is the Windows Server Core image matching the build of the version, which the test workloads are
+	// run with
+	ServerCoreImage string
+}
+
+// serverVersions are the Windows Server versions the tests can be run against, by name
+var serverVersions = map[string]ServerVersion{
+	"2019": {Name: "2019", Build: 17763, PauseImage: defaultPauseImage,
+		ServerCoreImage: "mcr.microsoft.com/windows/servercore:ltsc2019"},
+	"2004": {Name: "2004", Build: 19041, PauseImage: defaultPauseImage,
+		ServerCoreImage: "mcr.microsoft.com/windows/servercore:2004"},
+	"20H2": {Name: "20H2", Build: 19042, PauseImage: defaultPauseImage,
+		ServerCoreImage: "mcr.microsoft.com/windows/servercore:20H2"},
+	"2022": {Name: "2022", Build: 20348, PauseImage: "mcr.microsoft.com/oss/kubernetes/pause:3.6",
+		ServerCoreImage: "mcr.microsoft.com/windows/servercore:ltsc2022"},
+}
+
+// ServerVersionNames returns the sorted names of the Windows Server versions the tests can be run against
+func ServerVersionNames() []string {
+	names := make([]string, 0, len(serverVersions))
+	for name := range serverVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupServerVersion returns the Windows Server version with the given name
+func LookupServerVersion(name string) (ServerVersion, error) {
+	version, ok := serverVersions[name]
+	if !ok {
+		return ServerVersion{}, fmt.Errorf("unsupported Windows Server version %s, supported versions are %s", name,
+			strings.Join(ServerVersionNames(), ", "))
+	}
+	return version, nil
+}
+
+// LookupServerVersionByBuild returns the Windows Server version with the given build number
+func LookupServerVersionByBuild(build int) (ServerVersion, error) {
+	for _, version := range serverVersions {
+		if version.Build == build {
+			return version, nil
+		}
+	}
+	return ServerVersion{}, fmt.Errorf("unsupported Windows build %d", build)
+}
+
+// ParseServerVersions returns the Windows Server versions of the given comma separated list of names, in order and
+// without duplicates
+func ParseServerVersions(list string) ([]ServerVersion, error) {
+	var versions []ServerVersion
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		version, err := LookupServerVersion(name)
+		if err != nil {
+			return nil, err
+		}
+		seen[name] = true
+		versions = append(versions, version)
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no Windows Server version given")
+	}
+	return versions, nil
+}
+
+// GetBuildNumber returns the build number of the Windows VM
+func GetBuildNumber(vm WindowsVM) (int, error) {
+	out, err := vm.Run(buildNumberCmd, true)
+	if err != nil {
+		return 0, fmt.Errorf("error getting build number: %v", err)
+	}
+	build, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return 0, fmt.Errorf("invalid build number %q: %v", strings.TrimSpace(out), err)
+	}
+	return build, nil
+}
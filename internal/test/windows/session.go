@@ -0,0 +1,269 @@
+package windows
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// powerShellSessionCmd starts a PowerShell process that runs the commands read from its standard input
+	powerShellSessionCmd = "powershell.exe -NoLogo -NoProfile -NonInteractive -ExecutionPolicy Bypass -Command -"
+	// powerShellSessionInit is run when the session starts so that the output of the commands is encoded in UTF-8
+	powerShellSessionInit = "[Console]::OutputEncoding = [Text.Encoding]::UTF8"
+)
+
+// PowerShellSession is a PowerShell process running on the Windows VM, in which commands are run one after the other.
+// The commands share the state of the session, such as the variables, functions, imported modules and current
+// directory, and do not pay the cost of opening a ssh session and starting PowerShell every time. A session is not
+// re-established when the connection to the Windows VM fails, as its state would be lost. Instead, every following
+// command fails and a new session needs to be started.
+type PowerShellSession struct {
+	// session is the ssh session running the PowerShell process
+	session *ssh.Session
+	// stdin is the standard input of the PowerShell process the commands are written to
+	stdin io.WriteCloser
+	// stdout holds the lines written to the standard output of the PowerShell process
+	stdout <-chan string
+	// stderr holds the lines written to the standard error of the PowerShell process
+	stderr <-chan string
+	// token identifies the session in the markers written after every command, so that the markers cannot be
+	// mistaken for the output of a command
+	token string
+	// count is the number of commands run in the session
+	count int
+	// err is the error that broke the session, after which no command can be run
+	err error
+	// done is closed once the session is broken or closed, so that the output of the PowerShell process is no longer
+	// read
+	done chan struct{}
+	// mutex serializes the commands run in the session
+	mutex sync.Mutex
+}
+
+// readLines sends the lines read from the given reader to the returned channel, which is closed once the reader is
+// exhausted or the given channel is closed. The line endings are preserved.
+func readLines(r io.Reader, done <-chan struct{}) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		reader := bufio.NewReader(r)
+		for {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				select {
+				case lines <- line:
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return lines
+}
+
+// NewPowerShellSession starts a PowerShell session on the Windows VM over a new ssh session. The session needs to be
+// closed once it is no longer needed. PowerShell sessions are not supported over WinRM.
+func (w *Windows) NewPowerShellSession(ctx context.Context) (*PowerShellSession, error) {
+	if w.Transport == WinRMTransport {
+		return nil, fmt.Errorf("PowerShell sessions are not supported over WinRM")
+	}
+	var s *PowerShellSession
+	err := w.withRetry(ctx, "starting PowerShell session", func() error {
+		var err error
+		s, err = w.startPowerShellSession(ctx)
+		return err
+	})
+	return s, err
+}
+
+// startPowerShellSession starts the PowerShell process of a new session and waits for it to be ready
+func (w *Windows) startPowerShellSession(ctx context.Context) (*PowerShellSession, error) {
+	if w.SSHClient == nil {
+		return nil, fmt.Errorf("PowerShell session cannot be started without a ssh client")
+	}
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("error generating PowerShell session token: %v", err)
+	}
+
+	session, err := w.SSHClient.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if err = session.Start(powerShellSessionCmd); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	s := &PowerShellSession{session: session, stdin: stdin, stdout: readLines(stdout, done),
+		stderr: readLines(stderr, done), token: hex.EncodeToString(token), done: done}
+	result, err := s.Run(ctx, powerShellSessionInit)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		s.Close()
+		return nil, fmt.Errorf("error initializing PowerShell session: %s", result.Stderr)
+	}
+	return s, nil
+}
+
+// sessionMarker returns the marker written after the command with the given number in the session with the given token
+func sessionMarker(token string, count int) string {
+	return fmt.Sprintf("wmcb-%s-%d", token, count)
+}
+
+// sessionCommand returns the line that runs the given command in the session and then writes the marker of the command
+// followed by the exit code of the command to stdout, and the marker to stderr. The marker is assembled by PowerShell so
+// that it is not matched if the line is echoed. The command is base64 encoded, so that it can span multiple lines and
+// contain any character. The exit code is the exit code of the last native command run if it is not zero, 1 if the
+// command failed otherwise and 0 if it succeeded.
+func sessionCommand(cmd, token string, count int) string {
+	// PowerShell strings are UTF-16
+	var encoded []byte
+	for _, c := range utf16.Encode([]rune(cmd)) {
+		encoded = append(encoded, byte(c), byte(c>>8))
+	}
+	return fmt.Sprintf("$wmcbMarker = 'wmcb-{0}-{1}' -f '%s', %d; ", token, count) +
+		"$global:LASTEXITCODE = 0; $wmcbSucceeded = $false; " +
+		"try { $wmcbOutput = Invoke-Expression ([Text.Encoding]::Unicode.GetString([Convert]::FromBase64String('" +
+		base64.StdEncoding.EncodeToString(encoded) + "'))); $wmcbSucceeded = $?; " +
+		"$wmcbOutput | Out-String -Stream -Width 4096 | ForEach-Object { [Console]::Out.WriteLine($_) } } " +
+		"catch { [Console]::Error.WriteLine($_) }; " +
+		"$wmcbExitCode = if ($LASTEXITCODE) { $LASTEXITCODE } elseif ($wmcbSucceeded) { 0 } else { 1 }; " +
+		"[Console]::Out.WriteLine($wmcbMarker + ' ' + $wmcbExitCode); [Console]::Error.WriteLine($wmcbMarker)\n"
+}
+
+// readUntilMarker returns the lines read from the given channel up to the line containing the given marker, along
+// with the rest of the line following the marker. An error is returned if the channel is closed before the marker is
+// read.
+func readUntilMarker(ctx context.Context, lines <-chan string, marker string) (string, string, error) {
+	var out strings.Builder
+	for {
+		select {
+		case <-ctx.Done():
+			return "", "", ctx.Err()
+		case line, ok := <-lines:
+			if !ok {
+				return "", "", fmt.Errorf("PowerShell session ended unexpectedly")
+			}
+			// The output of a command that is not terminated by a new line precedes the marker
+			if i := strings.Index(line, marker); i >= 0 {
+				out.WriteString(line[:i])
+				return out.String(), strings.TrimSpace(line[i+len(marker):]), nil
+			}
+			out.WriteString(line)
+		}
+	}
+}
+
+// Run runs the given PowerShell command in the session and returns its stdout, stderr and exit code, waiting for the
+// previous commands to complete. The output of the command is formatted as strings before being returned. A command
+// that ran and exited with a non zero exit code is not an error. The session is closed if the context is done while
+// the command is running, as the command cannot be interrupted otherwise.
+func (s *PowerShellSession) Run(ctx context.Context, cmd string) (*CommandResult, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return nil, fmt.Errorf("PowerShell session is broken: %v", s.err)
+	}
+
+	s.count++
+	result, err := s.run(ctx, cmd)
+	if err != nil {
+		s.shutdown(err)
+		return nil, err
+	}
+	return result, nil
+}
+
+// run runs the given command in the session and reads its output up to its marker
+func (s *PowerShellSession) run(ctx context.Context, cmd string) (*CommandResult, error) {
+	if _, err := io.WriteString(s.stdin, sessionCommand(cmd, s.token, s.count)); err != nil {
+		return nil, fmt.Errorf("error sending command to PowerShell session: %v", err)
+	}
+
+	// stderr is read concurrently, as the command blocks once the buffer of either stream is full
+	type output struct {
+		out string
+		err error
+	}
+	marker := sessionMarker(s.token, s.count)
+	stderrOutput := make(chan output, 1)
+	go func() {
+		out, _, err := readUntilMarker(ctx, s.stderr, marker)
+		stderrOutput <- output{out, err}
+	}()
+	stdout, exitCode, err := readUntilMarker(ctx, s.stdout, marker)
+	if err != nil {
+		// Closing the session ends stderr as well
+		s.session.Close()
+		<-stderrOutput
+		return nil, err
+	}
+	stderr := <-stderrOutput
+	if stderr.err != nil {
+		return nil, stderr.err
+	}
+
+	code, err := strconv.Atoi(exitCode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exit code %q in PowerShell session: %v", exitCode, err)
+	}
+	return &CommandResult{Stdout: stdout, Stderr: stderr.out, ExitCode: code}, nil
+}
+
+// shutdown marks the session as broken by the given error and closes the ssh session, which terminates the PowerShell
+// process
+func (s *PowerShellSession) shutdown(err error) error {
+	s.err = err
+	close(s.done)
+	err = s.session.Close()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// Close ends the PowerShell process and closes the ssh session. No command can be run in the session afterwards.
+func (s *PowerShellSession) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return nil
+	}
+	// Closing stdin makes PowerShell exit before the session is closed
+	s.stdin.Close()
+	return s.shutdown(fmt.Errorf("session closed"))
+}
@@ -0,0 +1,159 @@
+package windows
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// tailPollInterval is the interval at which a tailed remote file is checked for new lines
+const tailPollInterval = time.Second
+
+// remoteFileTail tracks the progress of tailing a file on the Windows VM, so that the file is resumed from where it
+// was left off after reconnecting to the Windows VM
+type remoteFileTail struct {
+	// path is the path of the tailed file on the Windows VM
+	path string
+	// out is the writer the lines of the file are written to
+	out io.Writer
+	// client is the sftp client the file is read with. It is recreated after an error, as the connection is likely
+	// broken.
+	client *sftp.Client
+	// offset is the offset in the file up to which it has been read
+	offset int64
+	// started is set once the offset the file is tailed from is known
+	started bool
+	// partial is the last line read from the file, which has not been terminated yet
+	partial []byte
+}
+
+// Write writes the complete lines of the given content to the writer of the tail and holds back the last line until it
+// is terminated, so that lines are not interleaved with other output
+func (t *remoteFileTail) Write(content []byte) (int, error) {
+	t.partial = append(t.partial, content...)
+	if end := bytes.LastIndexByte(t.partial, '\n'); end >= 0 {
+		if _, err := t.out.Write(t.partial[:end+1]); err != nil {
+			return 0, err
+		}
+		t.partial = append([]byte(nil), t.partial[end+1:]...)
+	}
+	return len(content), nil
+}
+
+// flush writes the last line read from the file to the writer of the tail even though it has not been terminated
+func (t *remoteFileTail) flush() {
+	if len(t.partial) == 0 {
+		return
+	}
+	if _, err := t.out.Write(append(t.partial, '\n')); err != nil {
+		log.Printf("error writing the last line of %s: %v", t.path, err)
+	}
+	t.partial = nil
+}
+
+// close closes the sftp client of the tail
+func (t *remoteFileTail) close() {
+	if t.client != nil {
+		t.client.Close()
+		t.client = nil
+	}
+}
+
+// read writes the lines that have been appended to the file since it was last read to the writer of the tail. The file
+// is read from the start if it has been truncated or rotated, and the first read only records the current size of the
+// file unless the file does not exist yet. The errors of the sftp client are returned as is, so that the transient ones
+// can be retried.
+func (t *remoteFileTail) read(w *Windows) (err error) {
+	defer func() {
+		if err != nil {
+			t.close()
+		}
+	}()
+	if t.client == nil {
+		if w.SSHClient == nil {
+			return fmt.Errorf("cannot tail remote file without a ssh client")
+		}
+		if t.client, err = sftp.NewClient(w.SSHClient); err != nil {
+			return err
+		}
+	}
+
+	info, err := t.client.Stat(t.path)
+	if os.IsNotExist(err) {
+		// The lines of a file that is created later are all new
+		t.started = true
+		t.offset = 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !t.started {
+		t.started = true
+		t.offset = info.Size()
+		return nil
+	}
+	if info.Size() < t.offset {
+		t.offset = 0
+	}
+	if info.Size() == t.offset {
+		return nil
+	}
+
+	file, err := t.client.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = file.Seek(t.offset, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := io.Copy(t, file)
+	t.offset += n
+	return err
+}
+
+// TailFile streams the lines appended to the given file on the Windows VM to the given writer until the context is
+// done, starting from the current end of the file, or from its start if it does not exist yet. The file is read from
+// the start again if it is truncated or rotated. The file is tailed over a dedicated ssh connection, so that it can be
+// called concurrently with the other operations on the Windows VM. The connection is re-established on transient errors
+// as per the retry policy, resuming the file from where it was left off. An error is returned if the file cannot be
+// read, otherwise nil is returned once the context is done. Tailing files is not supported over WinRM.
+func (w *Windows) TailFile(ctx context.Context, remotePath string, out io.Writer) error {
+	if w.Transport == WinRMTransport {
+		return fmt.Errorf("tailing files is not supported over WinRM")
+	}
+	conn := &Windows{Credentials: w.Credentials, Transport: w.Transport, RetryPolicy: w.RetryPolicy}
+	if err := conn.ConnectContext(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("unable to connect to the Windows VM to tail %s: %v", remotePath, err)
+	}
+	// The ssh client is replaced when reconnecting
+	defer func() { conn.SSHClient.Close() }()
+
+	tail := &remoteFileTail{path: remotePath, out: out}
+	defer tail.close()
+	defer tail.flush()
+	for {
+		err := conn.withRetry(ctx, "tailing "+remotePath, func() error { return tail.read(conn) })
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error tailing %s: %v", remotePath, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(tailPollInterval):
+		}
+	}
+}
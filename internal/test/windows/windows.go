@@ -1,15 +1,19 @@
 package windows
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/masterzen/winrm"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 
 	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/credentials"
 )
@@ -20,12 +24,33 @@ const (
 	remotePowerShellCmdPrefix = "powershell.exe -NonInteractive -ExecutionPolicy Bypass "
 )
 
+// Transport is the protocol used to run commands and copy files to the Windows VM
+type Transport string
+
+const (
+	// SSHTransport uses ssh to run commands and sftp to copy files. This is the default transport.
+	SSHTransport Transport = "ssh"
+	// WinRMTransport uses WinRM over HTTPS to run commands and copy files. It is meant for images that have WinRM
+	// enabled but no sshd. Retrieving files from the Windows VM is not supported over WinRM.
+	WinRMTransport Transport = "winrm"
+)
+
 // Windows represents a Windows host.
 type Windows struct {
 	// Credentials is used for storing the credentials for Windows VMs created
 	Credentials *credentials.Credentials
 	// SSHClient contains the ssh client information to access the Windows VM via ssh
 	SSHClient *ssh.Client
+	// WinRMClient contains the WinRM client information to access the Windows VM via WinRM
+	WinRMClient *winrm.Client
+	// Transport is the protocol used to interact with the Windows VM. SSHTransport is used if it is empty.
+	Transport Transport
+	// RetryPolicy configures how the operations on the Windows VM are retried on transient errors. DefaultRetryPolicy
+	// is used if it is nil.
+	RetryPolicy *RetryPolicy
+	// RebootFunc reboots the Windows VM through the API of the cloud provider. Reboot runs shutdown /r on the Windows
+	// VM if it is nil.
+	RebootFunc func(context.Context) error
 }
 
 // WindowsVM is the interface for interacting with a Windows object created by the cloud provider
@@ -33,22 +58,77 @@ type WindowsVM interface {
 	// CopyDirectory copies the files from the directory on the local host to the directory on the remote Windows VM
 	// This does not copy nested directories
 	CopyDirectory(string, string) error
+	// CopyDirectoryContext is CopyDirectory, aborting the copy once the context is done
+	CopyDirectoryContext(context.Context, string, string) error
+	// CopyDirectoryRecursive copies the files and nested directories from the directory on the local host to the
+	// directory on the remote Windows VM, preserving the directory structure
+	CopyDirectoryRecursive(string, string) error
+	// CopyDirectoryRecursiveContext is CopyDirectoryRecursive, aborting the copy once the context is done
+	CopyDirectoryRecursiveContext(context.Context, string, string) error
+	// CopyGlob copies the files and directories on the local host matching the glob pattern to the directory on the
+	// remote Windows VM, preserving their structure below the directory the pattern starts matching in
+	CopyGlob(string, string) error
+	// CopyGlobContext is CopyGlob, aborting the copy once the context is done
+	CopyGlobContext(context.Context, string, string) error
 	// CopyFile copies the given file to the remote directory in the Windows VM. The remote directory is created if it
 	// does not exist
 	CopyFile(string, string) error
-	// Run executes the given command remotely on the Windows VM over a ssh connection and returns the combined output
+	// CopyFileContext is CopyFile, aborting the copy once the context is done
+	CopyFileContext(context.Context, string, string) error
+	// Run executes the given command remotely on the Windows VM over the transport and returns the combined output
 	// of stdout and stderr. If the bool is set, it implies that the cmd is to be execute in PowerShell. This function
 	// should be used in scenarios where you want to execute a command that runs in the background. In these cases we
 	// have observed that Run() returns before the command completes and as a result killing the process.
 	Run(string, bool) (string, error)
+	// RunContext is Run, aborting the command once the context is done
+	RunContext(context.Context, string, bool) (string, error)
+	// RunCommand runs the command on the Windows VM and returns its stdout, stderr and exit code. An error is returned
+	// only if the command could not be run or did not complete, not if it exited with a non zero exit code.
+	RunCommand(context.Context, Command) (*CommandResult, error)
+	// NewPowerShellSession starts a PowerShell session on the Windows VM in which a sequence of commands can be run
+	// sharing the same state. It is not supported over WinRM.
+	NewPowerShellSession(context.Context) (*PowerShellSession, error)
 	// GetCredentials returns the interface for accessing the VM credentials. It is up to the caller to check if non-nil
 	// Credentials are returned before usage.
 	GetCredentials() *credentials.Credentials
-	// Reinitialize re-initializes the Windows VM. Presently only the ssh client is reinitialized.
+	// Reinitialize re-initializes the Windows VM. Presently only the client of the transport is reinitialized.
 	Reinitialize() error
+	// Reboot restarts the Windows VM, waits for it to go down and to accept connections again, and re-creates the
+	// client of the transport
+	Reboot(context.Context) error
+	// RetrieveFile copies the given file from the remote Windows VM to the directory on the local host. It is not
+	// supported over WinRM.
+	RetrieveFile(string, string) error
+	// RetrieveFileContext is RetrieveFile, aborting the copy once the context is done
+	RetrieveFileContext(context.Context, string, string) error
+	// RetrieveDirectory recursively copies the files and directories from the directory on the remote Windows VM to
+	// the directory on the local host. It is not supported over WinRM.
+	RetrieveDirectory(string, string) error
+	// RetrieveDirectoryContext is RetrieveDirectory, aborting the copy once the context is done
+	RetrieveDirectoryContext(context.Context, string, string) error
+	// TailFile streams the lines appended to the given file on the Windows VM to the writer until the context is done,
+	// reconnecting to the Windows VM on transient errors. It is not supported over WinRM.
+	TailFile(context.Context, string, io.Writer) error
 }
 
 func (w *Windows) CopyFile(filePath, remoteDir string) error {
+	return w.CopyFileContext(context.Background(), filePath, remoteDir)
+}
+
+// CopyFileContext copies the given file to the remote directory in the Windows VM, retrying the copy on transient
+// errors until the context is done
+func (w *Windows) CopyFileContext(ctx context.Context, filePath, remoteDir string) error {
+	return w.withRetry(ctx, "copying "+filePath, func() error {
+		return w.copyFile(filePath, remoteDir)
+	})
+}
+
+// copyFile copies the given file to the remote directory in the Windows VM over the transport. Over WinRM, the file
+// is copied with sftp if there is a SSH client, as it is faster.
+func (w *Windows) copyFile(filePath, remoteDir string) error {
+	if w.Transport == WinRMTransport && w.SSHClient == nil {
+		return w.copyFileWinRM(filePath, remoteDir)
+	}
 	if w.SSHClient == nil {
 		return fmt.Errorf("CopyFile cannot be called without a SSH client")
 	}
@@ -58,7 +138,11 @@ func (w *Windows) CopyFile(filePath, remoteDir string) error {
 		return fmt.Errorf("sftp client initialization failed: %v", err)
 	}
 	defer ftp.Close()
+	return copyFileSFTP(ftp, filePath, remoteDir)
+}
 
+// copyFileSFTP copies the given file to the remote directory in the Windows VM using the given sftp client
+func copyFileSFTP(ftp *sftp.Client, filePath, remoteDir string) error {
 	log.Printf("Copying %s file to Windows VM: %v", filePath, remoteDir)
 
 	f, err := os.Open(filePath)
@@ -88,20 +172,17 @@ func (w *Windows) CopyFile(filePath, remoteDir string) error {
 }
 
 func (w *Windows) CopyDirectory(localDir string, remoteDir string) error {
-	if w.SSHClient == nil {
-		return fmt.Errorf("CopyDirectory cannot be called without a SSH client")
-	}
-
-	sftp, err := sftp.NewClient(w.SSHClient)
-	if err != nil {
-		return fmt.Errorf("sftp initialization failed: %v", err)
-	}
-	defer sftp.Close()
+	return w.CopyDirectoryContext(context.Background(), localDir, remoteDir)
+}
 
+// CopyDirectoryContext copies the files from the local directory to the remote directory in the Windows VM, retrying
+// each operation on transient errors until the context is done
+func (w *Windows) CopyDirectoryContext(ctx context.Context, localDir string, remoteDir string) error {
 	log.Printf("Copying %s directory to Windows VM: %s", localDir, remoteDir)
 
 	// creating a remote directory to store the files.
-	if err = sftp.MkdirAll(remoteDir); err != nil {
+	err := w.withRetry(ctx, "creating "+remoteDir, func() error { return w.mkdirAll(remoteDir) })
+	if err != nil {
 		return fmt.Errorf("could not create %s: %v", remoteDir, err)
 	}
 
@@ -118,33 +199,52 @@ func (w *Windows) CopyDirectory(localDir string, remoteDir string) error {
 			log.Printf("Skipping %s directory", localPath)
 			continue
 		}
-		if err = w.CopyFile(localPath, remoteDir); err != nil {
+		if err = w.CopyFileContext(ctx, localPath, remoteDir); err != nil {
 			return fmt.Errorf("error while copying %s file to Windows VM: %v", localPath, err)
 		}
 	}
 	return nil
 }
 
-func (w *Windows) Run(cmd string, psCmd bool) (string, error) {
+// mkdirAll creates the remote directory along with its parents if it does not exist
+func (w *Windows) mkdirAll(remoteDir string) error {
+	if w.Transport == WinRMTransport {
+		return w.mkdirAllWinRM(remoteDir)
+	}
 	if w.SSHClient == nil {
-		return "", fmt.Errorf("Run cannot be called without a ssh client")
+		return fmt.Errorf("mkdirAll cannot be called without a SSH client")
 	}
 
-	session, err := w.SSHClient.NewSession()
+	sftp, err := sftp.NewClient(w.SSHClient)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("sftp initialization failed: %v", err)
 	}
-	defer session.Close()
+	defer sftp.Close()
+	return sftp.MkdirAll(remoteDir)
+}
 
-	if psCmd {
-		cmd = remotePowerShellCmdPrefix + cmd
-	}
+func (w *Windows) Run(cmd string, psCmd bool) (string, error) {
+	return w.RunContext(context.Background(), cmd, psCmd)
+}
 
-	out, err := session.CombinedOutput(cmd)
+// RunContext executes the given command remotely on the Windows VM. The command is not retried if the connection to
+// the Windows VM fails, as it may not be idempotent: RunCommand retries the commands marked as idempotent. Over ssh,
+// the command is terminated if the context is done while it is running.
+func (w *Windows) RunContext(ctx context.Context, cmd string, psCmd bool) (string, error) {
+	return w.run(ctx, cmd, psCmd)
+}
+
+// run executes the given command remotely on the Windows VM over the transport and returns the output of stdout
+// followed by the output of stderr. An ExitError is returned if the command exits with a non zero exit code.
+func (w *Windows) run(ctx context.Context, cmd string, psCmd bool) (string, error) {
+	result, err := w.runCommand(ctx, cmd, psCmd, nil)
 	if err != nil {
 		return "", err
 	}
-	return string(out), nil
+	if result.ExitCode != 0 {
+		return "", &ExitError{ExitCode: result.ExitCode, Stderr: result.Stderr}
+	}
+	return result.Stdout + result.Stderr, nil
 }
 
 func (w *Windows) GetCredentials() *credentials.Credentials {
@@ -160,9 +260,22 @@ func (w *Windows) GetSSHClient() error {
 		}
 	}
 
+	// Prefer the key based authentication and fall back to the password based one, as some images disable either of
+	// them
+	var authMethods []ssh.AuthMethod
+	if w.Credentials.SSHKey() != nil {
+		authMethods = append(authMethods, ssh.PublicKeys(w.Credentials.SSHKey()))
+	}
+	if w.Credentials.Password() != "" {
+		authMethods = append(authMethods, ssh.Password(w.Credentials.Password()))
+	}
+	if len(authMethods) == 0 {
+		return fmt.Errorf("no ssh key or password present to authenticate with")
+	}
+
 	config := &ssh.ClientConfig{
-		User:            w.Credentials.UserName(), //TODO: Change this to make sure that this works for Azure.
-		Auth:            []ssh.AuthMethod{ssh.PublicKeys(w.Credentials.SSHKey())},
+		User:            w.Credentials.UserName(),
+		Auth:            authMethods,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
 
@@ -174,81 +287,143 @@ func (w *Windows) GetSSHClient() error {
 	return nil
 }
 
+// Connect initializes the client of the transport used to interact with the Windows VM
+func (w *Windows) Connect() error {
+	return w.ConnectContext(context.Background())
+}
+
+// ConnectContext initializes the client of the transport used to interact with the Windows VM, retrying on transient
+// errors, for example while the ssh server of a new Windows VM is starting up, until the context is done
+func (w *Windows) ConnectContext(ctx context.Context) error {
+	return w.retryPolicy().Do(ctx, "connecting to the Windows VM", w.connect, nil)
+}
+
+// connect initializes the client of the transport used to interact with the Windows VM
+func (w *Windows) connect() error {
+	switch w.transport() {
+	case SSHTransport:
+		return w.GetSSHClient()
+	case WinRMTransport:
+		return w.GetWinRMClient(false)
+	default:
+		return fmt.Errorf("unsupported transport %s", w.Transport)
+	}
+}
+
 func (w *Windows) Reinitialize() error {
-	if err := w.GetSSHClient(); err != nil {
-		return fmt.Errorf("failed to reinitialize ssh client: %v", err)
+	if err := w.Connect(); err != nil {
+		return fmt.Errorf("failed to reinitialize %s client: %v", w.transport(), err)
 	}
 	return nil
 }
 
-// RetrieveDirectories recursively copies the files and directories from the directory in the remote Windows VM
-// to the given directory on the local host.
-func (w *Windows) RetrieveDirectories(remoteDir string, localDir string) error {
-	if w.SSHClient == nil {
-		return fmt.Errorf("cannot retrieve remote directory without a ssh client")
+// transport returns the transport used to interact with the Windows VM
+func (w *Windows) transport() Transport {
+	if w.Transport == "" {
+		return SSHTransport
 	}
+	return w.Transport
+}
 
-	// creating a local directory to store the files and directories from remote directory.
-	err := os.MkdirAll(localDir, os.ModePerm)
-	if err != nil {
+func (w *Windows) RetrieveFile(remotePath, localDir string) error {
+	return w.RetrieveFileContext(context.Background(), remotePath, localDir)
+}
+
+// RetrieveFileContext copies the given file from the Windows VM to the local directory, retrying the copy on transient
+// errors until the context is done
+func (w *Windows) RetrieveFileContext(ctx context.Context, remotePath, localDir string) error {
+	return w.withRetry(ctx, "retrieving "+remotePath, func() error {
+		return w.withSFTP(func(client *sftp.Client) error {
+			return copyFileFrom(client, remotePath, filepath.Join(localDir, remoteBase(remotePath)))
+		})
+	})
+}
+
+func (w *Windows) RetrieveDirectory(remoteDir, localDir string) error {
+	return w.RetrieveDirectoryContext(context.Background(), remoteDir, localDir)
+}
+
+// RetrieveDirectoryContext recursively copies the files and directories from the directory on the Windows VM to the
+// local directory, retrying each copy on transient errors until the context is done. The copy is best effort: the
+// files that cannot be copied are skipped and the errors are returned once the rest have been copied.
+func (w *Windows) RetrieveDirectoryContext(ctx context.Context, remoteDir, localDir string) error {
+	if err := os.MkdirAll(localDir, os.ModePerm); err != nil {
 		return fmt.Errorf("could not create %s: %v", localDir, err)
 	}
 
-	sftp, err := sftp.NewClient(w.SSHClient)
-	if err != nil {
-		return fmt.Errorf("sftp initialization failed: %v", err)
-	}
-	defer sftp.Close()
-
-	// Get the list of all files in the directory
-	remoteFiles, err := sftp.ReadDir(remoteDir)
+	var remoteFiles []os.FileInfo
+	err := w.withRetry(ctx, "listing "+remoteDir, func() error {
+		return w.withSFTP(func(client *sftp.Client) error {
+			var err error
+			remoteFiles, err = client.ReadDir(remoteDir)
+			return err
+		})
+	})
 	if err != nil {
 		return fmt.Errorf("error opening remote directory %s: %v", remoteDir, err)
 	}
 
+	var errs []error
 	for _, remoteFile := range remoteFiles {
-		remotePath := filepath.Join(remoteDir, remoteFile.Name())
-		localPath := filepath.Join(localDir, remoteFile.Name())
-		// check if it is a directory, call itself again
+		remotePath := remoteJoin(remoteDir, remoteFile.Name())
 		if remoteFile.IsDir() {
-			if err = w.RetrieveDirectories(remotePath, localPath); err != nil {
-				log.Printf("error while retrieving %s directory from Windows : %v", remotePath, err)
-			}
+			err = w.RetrieveDirectoryContext(ctx, remotePath, filepath.Join(localDir, remoteFile.Name()))
 		} else {
-			// logging errors as a best effort to retrieve files from remote directory
-			if err = w.copyFileFrom(sftp, remotePath, localPath); err != nil {
-				log.Printf("error while retrieving %s file from Windows : %v", remotePath, err)
+			err = w.RetrieveFileContext(ctx, remotePath, localDir)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
 			}
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	return utilerrors.NewAggregate(errs)
 }
 
-// copyFileFrom copies a file from remote directory to the local directory.
-func (w *Windows) copyFileFrom(sftp *sftp.Client, remotePath, localPath string) error {
-	localFile, err := os.Create(localPath)
-	if err != nil {
-		return fmt.Errorf("error creating file locally: %v", err)
+// withSFTP runs the given operation with a sftp client of the Windows VM
+func (w *Windows) withSFTP(fn func(*sftp.Client) error) error {
+	if w.Transport == WinRMTransport {
+		return fmt.Errorf("retrieving files is not supported over WinRM")
 	}
-	// TODO: Check if there is some performance implication of multiple Open calls.
-	remoteFile, err := sftp.Open(remotePath)
-	if err != nil {
-		return fmt.Errorf("error while opening remote file on the Windows VM: %v", err)
+	if w.SSHClient == nil {
+		return fmt.Errorf("cannot retrieve remote files without a ssh client")
 	}
-	// logging the errors instead of returning to allow closing of files
-	_, err = io.Copy(localFile, remoteFile)
+	client, err := sftp.NewClient(w.SSHClient)
 	if err != nil {
-		log.Printf("error retrieving file %v from Windows VM: %v", localPath, err)
+		return err
 	}
-	// flush memory
-	if err = localFile.Sync(); err != nil {
-		log.Printf("error flusing memory: %v", err)
+	defer client.Close()
+	return fn(client)
+}
+
+// copyFileFrom copies the file on the Windows VM to the local path. The errors of the sftp client are returned as is,
+// so that the transient ones can be retried.
+func copyFileFrom(client *sftp.Client, remotePath, localPath string) error {
+	remoteFile, err := client.Open(remotePath)
+	if err != nil {
+		return err
 	}
-	if err := remoteFile.Close(); err != nil {
-		log.Printf("error closing file on the remote host %s", localPath)
+	defer remoteFile.Close()
+
+	localFile, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %v", localPath, err)
 	}
-	if err := localFile.Close(); err != nil {
-		log.Printf("error closing file %s locally", localPath)
+	if _, err = io.Copy(localFile, remoteFile); err != nil {
+		localFile.Close()
+		return err
 	}
-	return nil
+	return localFile.Close()
+}
+
+// remoteJoin joins the given directory and name on the Windows VM
+func remoteJoin(remoteDir, name string) string {
+	return strings.TrimRight(remoteDir, "\\/") + "\\" + name
+}
+
+// remoteBase returns the last element of the given path on the Windows VM
+func remoteBase(remotePath string) string {
+	remotePath = strings.TrimRight(remotePath, "\\/")
+	return remotePath[strings.LastIndexAny(remotePath, "\\/")+1:]
 }
@@ -0,0 +1,177 @@
+package windows
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/masterzen/winrm"
+)
+
+const (
+	// winRMPort is the HTTPS port on which WinRM listens
+	winRMPort = 5986
+	// winRMChunkSize is the size of the chunks in which files are copied over WinRM. Each chunk is sent base64 encoded
+	// as a line of the stdin of the decoding command, in a WinRM request of its own which needs to fit in the default
+	// 150KB envelope once encoded again by the WinRM client. It is a multiple of 3 so that every line can be decoded
+	// on its own.
+	winRMChunkSize = 72 * 1024
+)
+
+// GetWinRMClient gets the WinRM client associated with the Windows VM created. The client connects over HTTPS and
+// authenticates using NTLM, falling back to basic authentication if useBasicAuth is set.
+func (w *Windows) GetWinRMClient(useBasicAuth bool) error {
+	if w.Credentials.Password() == "" {
+		return fmt.Errorf("password is required to authenticate over WinRM")
+	}
+
+	// The VMs use self signed certificates for the WinRM HTTPS listener
+	endpoint := winrm.NewEndpoint(w.Credentials.IPAddress(), winRMPort, true, true, nil, nil, nil, 0)
+	params := winrm.DefaultParameters
+	if !useBasicAuth {
+		params.TransportDecorator = func() winrm.Transporter { return &winrm.ClientNTLM{} }
+	}
+
+	winRMClient, err := winrm.NewClientWithParameters(endpoint, w.Credentials.UserName(), w.Credentials.Password(),
+		params)
+	if err != nil {
+		return fmt.Errorf("failed to create WinRM client: %v", err)
+	}
+	w.WinRMClient = winRMClient
+	return nil
+}
+
+// runWinRM executes the given command remotely on the Windows VM over WinRM and returns the combined output of stdout
+// and stderr
+func (w *Windows) runWinRM(cmd string, psCmd bool) (string, error) {
+	result, err := w.runCommandWinRM(context.Background(), cmd, psCmd)
+	if err != nil {
+		return "", err
+	}
+	if result.ExitCode != 0 {
+		return "", &ExitError{ExitCode: result.ExitCode, Stderr: result.Stderr}
+	}
+	return result.Stdout + result.Stderr, nil
+}
+
+// copyFileWinRM copies the given file to the remote directory in the Windows VM over WinRM. As WinRM has no file
+// transfer capability, the file is streamed as base64 encoded lines to the stdin of a PowerShell command that decodes
+// them into the remote file. This is slower than sftp and should be used only when ssh is not available.
+func (w *Windows) copyFileWinRM(filePath, remoteDir string) error {
+	if w.WinRMClient == nil {
+		return fmt.Errorf("files cannot be copied without a WinRM client")
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("error opening %s file to be transferred: %v", filePath, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading %s file to be transferred: %v", filePath, err)
+	}
+
+	log.Printf("Copying %s file to Windows VM over WinRM: %v", filePath, remoteDir)
+
+	if err = w.mkdirAllWinRM(remoteDir); err != nil {
+		return fmt.Errorf("error creating remote directory %s: %v", remoteDir, err)
+	}
+
+	// The size of the remote file is checked as the WinRM client closes the stdin of the command early on errors
+	remoteFile := remoteDir + "\\" + filepath.Base(filePath)
+	decodeCmd := fmt.Sprintf("$ErrorActionPreference = 'Stop'; $file = [IO.File]::Create('%s'); "+
+		"try { while (($line = [Console]::In.ReadLine()) -ne $null) { "+
+		"$bytes = [Convert]::FromBase64String($line); $file.Write($bytes, 0, $bytes.Length) } } "+
+		"finally { $file.Close() }; "+
+		"if ((Get-Item -Path '%s').Length -ne %d) { throw 'incomplete transfer' }",
+		remoteFile, remoteFile, info.Size())
+	stdin := &base64LineReader{file: f, chunk: make([]byte, winRMChunkSize)}
+	var stderr strings.Builder
+	exitCode, err := w.WinRMClient.RunWithInput(winrm.Powershell(decodeCmd), ioutil.Discard, &stderr, stdin)
+	if err == nil {
+		err = stdin.err
+	}
+	if err == nil && exitCode != 0 {
+		err = &ExitError{ExitCode: exitCode, Stderr: stderr.String()}
+	}
+	if err != nil {
+		return fmt.Errorf("error copying %s to the Windows VM: %w", filePath, err)
+	}
+	return nil
+}
+
+// base64LineReader reads a file as lines of base64 encoded chunks, each of which can be decoded on its own
+type base64LineReader struct {
+	// file is the file that is read
+	file io.Reader
+	// chunk is the buffer the chunks of the file are read into
+	chunk []byte
+	// line holds the part of the current line that has not been read yet
+	line []byte
+	// err is the error reading the file, if any
+	err error
+}
+
+// Read reads the lines into the given buffer
+func (r *base64LineReader) Read(p []byte) (int, error) {
+	if len(r.line) == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.line)
+	r.line = r.line[n:]
+	return n, nil
+}
+
+// WriteTo writes every line to the given writer in a single write, so that the WinRM client sends it in a single
+// request rather than in the 32KB chunks of io.Copy
+func (r *base64LineReader) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for {
+		if len(r.line) == 0 {
+			if err := r.next(); err == io.EOF {
+				return written, nil
+			} else if err != nil {
+				return written, err
+			}
+		}
+		n, err := w.Write(r.line)
+		written += int64(n)
+		r.line = r.line[n:]
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// next reads the next chunk of the file and encodes it into the current line. io.EOF is returned once the whole file
+// has been read.
+func (r *base64LineReader) next() error {
+	n, err := io.ReadFull(r.file, r.chunk)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+		if n == 0 {
+			return io.EOF
+		}
+	}
+	if err != nil {
+		r.err = fmt.Errorf("error reading the file to be transferred: %v", err)
+		return r.err
+	}
+	r.line = []byte(base64.StdEncoding.EncodeToString(r.chunk[:n]) + "\r\n")
+	return nil
+}
+
+// mkdirAllWinRM creates the remote directory along with its parents over WinRM if it does not exist
+func (w *Windows) mkdirAllWinRM(remoteDir string) error {
+	_, err := w.runWinRM("New-Item -ItemType Directory -Force -Path '"+strings.TrimSuffix(remoteDir, "\\")+"'",
+		true)
+	return err
+}
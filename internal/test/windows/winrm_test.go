@@ -0,0 +1,84 @@
+package windows
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingWriter records the writes made to it
+type recordingWriter struct {
+	writes [][]byte
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+// failingReader returns its content and then the given error
+type failingReader struct {
+	content io.Reader
+	err     error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.content.Read(p)
+	if err == io.EOF {
+		return n, r.err
+	}
+	return n, err
+}
+
+// decodeLines decodes every base64 line of the given output on its own and returns the concatenated content
+func decodeLines(t *testing.T, output string) []byte {
+	var content []byte
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\r\n"), "\r\n") {
+		if line == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(line)
+		require.NoError(t, err, "expected every line to be decoded on its own")
+		content = append(content, decoded...)
+	}
+	return content
+}
+
+func TestBase64LineReader(t *testing.T) {
+	const chunkSize = 6
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 5*chunkSize + 2} {
+		content := bytes.Repeat([]byte{0xfb, 'a', 0x00}, size)[:size]
+		lines := (size + chunkSize - 1) / chunkSize
+
+		read, err := ioutil.ReadAll(&base64LineReader{file: bytes.NewReader(content), chunk: make([]byte, chunkSize)})
+		require.NoError(t, err)
+		assert.Equal(t, lines, strings.Count(string(read), "\r\n"))
+		assert.Equal(t, string(content), string(decodeLines(t, string(read))), "size %d", size)
+
+		writer := &recordingWriter{}
+		reader := &base64LineReader{file: bytes.NewReader(content), chunk: make([]byte, chunkSize)}
+		written, err := io.Copy(writer, reader)
+		require.NoError(t, err)
+		assert.Len(t, writer.writes, lines, "expected a single write per line")
+		assert.Equal(t, string(read), string(bytes.Join(writer.writes, nil)))
+		assert.Equal(t, int64(len(read)), written)
+		assert.NoError(t, reader.err)
+	}
+}
+
+func TestBase64LineReaderError(t *testing.T) {
+	readErr := errors.New("disk failure")
+	reader := &base64LineReader{file: &failingReader{content: strings.NewReader("content"), err: readErr},
+		chunk: make([]byte, 4)}
+	_, err := io.Copy(ioutil.Discard, reader)
+	require.Error(t, err)
+	assert.Equal(t, err, reader.err, "expected the error to be kept for the WinRM client which ignores it")
+	assert.Contains(t, err.Error(), readErr.Error())
+}
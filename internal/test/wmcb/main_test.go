@@ -5,32 +5,80 @@ import (
 	"log"
 	"os"
 	"testing"
+	"time"
+
+	e2ef "github.com/openshift/windows-machine-config-bootstrapper/internal/test/framework"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/providers"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/windows"
 )
 
 // framework holds the instantiation of test suite being executed. As of now, temp dir is hardcoded.
 var (
 	// Initialize wmcbFramework which specializes TestFramework by adding some properties specific to WMCB tests
 	framework = wmcbFramework{}
-	// TODO: expose this to the end user as a command line flag
-	// vmCount is the number of VMs the test suite requires
-	vmCount = 1
+	// staleMachineSetAge is the age after which the MachineSets left behind by previous test runs are destroyed
+	staleMachineSetAge time.Duration
+	// reuseMachineSet indicates that the MachineSet left behind by a previous test run is reused and kept after the tests
+	reuseMachineSet bool
+	// spreadZones indicates that the Windows VMs are spread across the zones of the worker MachineSets of the cluster
+	spreadZones bool
+	// remediateNetwork indicates that the rules missing for the nodes to reach the Windows VMs are added to the network
+	remediateNetwork bool
+	// snapshotImage is the name of the image created from the first Windows VM once the test binaries are staged
+	snapshotImage string
+	// skipVMSetup indicates that the existing Windows VMs are used instead of creating a MachineSet
+	skipVMSetup bool
+	// vmCount is the number of VMs the test suite requires for each Windows Server version
+	vmCount int
+	// windowsVersions are the Windows Server versions the tests are run against, in turn
+	windowsVersions []windows.ServerVersion
+	// resources tracks the resources created by the tests across the Windows Server versions
+	resources *e2ef.Resources
 )
 
 func TestMain(m *testing.M) {
-	var skipVMSetup bool
+	var windowsServerVersions string
+	var skipCleanup bool
 
 	flag.BoolVar(&skipVMSetup, "skipVMSetup", false, "Option to disable setup in the VMs")
+	flag.IntVar(&vmCount, "vmCount", 1, "Number of Windows VMs the tests are run on")
+	flag.DurationVar(&staleMachineSetAge, "destroyStaleMachineSets", 0,
+		"Destroy the MachineSets left behind by previous test runs that are older than the given duration")
+	flag.BoolVar(&reuseMachineSet, "reuseMachineSet", false,
+		"Reuse the MachineSet left behind by a previous test run and keep the MachineSet after the tests")
+	flag.BoolVar(&spreadZones, "spreadZones", false,
+		"Spread the Windows VMs across the zones of the worker MachineSets of the cluster, with a MachineSet per zone")
+	flag.BoolVar(&remediateNetwork, "remediateNetwork", false,
+		"Add the rules missing for the nodes of the cluster to reach the Windows VMs to the network of the cluster")
+	flag.StringVar(&snapshotImage, "snapshotImage", "",
+		"Create an image with the given name from the first Windows VM once the test binaries are staged. The "+
+			"Windows Server version is appended to the name if more than one version is tested")
+	flag.StringVar(&windowsServerVersions, "windowsServerVersions", providers.DefaultWindowsVersion(),
+		"Comma separated Windows Server versions the tests are run against in turn, each on its own Windows VMs")
+	flag.BoolVar(&skipCleanup, "skipCleanup", false,
+		"Keep the resources created by the tests, such as the MachineSets, for debugging. The kept resources are logged")
 	flag.Parse()
 
-	err := framework.Setup(vmCount, skipVMSetup)
-	if err != nil {
-		framework.TearDown()
+	var err error
+	if windowsVersions, err = windows.ParseServerVersions(windowsServerVersions); err != nil {
 		log.Fatal(err)
 	}
-	testStatus := m.Run()
-	// Retrieve artifacts after running the test
-	framework.RetrieveArtifacts()
-	// TODO: Add one more check to remove lingering cloud resources
-	framework.TearDown()
-	os.Exit(testStatus)
+	resources = e2ef.NewResources(skipCleanup)
+	resources.DestroyOnExit(testTimeout())
+	code := m.Run()
+	// The resources are destroyed by the tests, this catches the ones left behind by a test that could not clean up
+	if err = resources.DestroyAll(); err != nil {
+		log.Printf("failed to destroy the resources of the test run: %v", err)
+	}
+	os.Exit(code)
+}
+
+// testTimeout returns the timeout of the test binary, as given by the -test.timeout flag
+func testTimeout() time.Duration {
+	timeoutFlag := flag.Lookup("test.timeout")
+	if timeoutFlag == nil {
+		return 0
+	}
+	timeout, _ := timeoutFlag.Value.(flag.Getter).Get().(time.Duration)
+	return timeout
 }
@@ -5,11 +5,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
-	"os"
 	"path/filepath"
 	"strings"
 	"testing"
-	"text/template"
 	"time"
 
 	"github.com/stretchr/testify/assert"
@@ -18,7 +16,13 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/openshift/windows-machine-config-bootstrapper/internal/test"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/csr"
 	e2ef "github.com/openshift/windows-machine-config-bootstrapper/internal/test/framework"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/gotest"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/windows"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/workload"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/wsu"
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/ignition"
 )
 
 const (
@@ -36,8 +40,6 @@ const (
 	winCNIConfigPath = "C:\\Windows\\Temp\\cni\\config\\"
 	// logDir is the remote kubernetes log director
 	kLog = "C:\\k\\log\\"
-	// cniConfigTemplate is the location of the cni.conf template file
-	cniConfigTemplate = "templates/cni.template"
 	// wgetIgnoreCertCmd is the remote location of the wget-ignore-cert.ps1 script
 	wgetIgnoreCertCmd = remoteDir + "wget-ignore-cert.ps1"
 	// e2eExecutable is the remote location of the WMCB e2e test binary
@@ -62,6 +64,8 @@ var (
 // wmcbVM is a wrapper for the WindowsVM interface that associates it with WMCB specific testing
 type wmcbVM struct {
 	e2ef.TestWindowsVM
+	// serverVersion is the Windows Server version the VM was created with
+	serverVersion windows.ServerVersion
 }
 
 type wmcbFramework struct {
@@ -69,9 +73,10 @@ type wmcbFramework struct {
 	*e2ef.TestFramework
 }
 
-// Setup initializes the wsuFramework.
-func (f *wmcbFramework) Setup(vmCount int, skipVMSetup bool) error {
-	f.TestFramework = &e2ef.TestFramework{}
+// Setup initializes the wsuFramework with Windows VMs running the given Windows Server version.
+func (f *wmcbFramework) Setup(vmCount int, skipVMSetup bool, windowsVersion string) error {
+	f.TestFramework = &e2ef.TestFramework{StaleMachineSetAge: staleMachineSetAge, ReuseMachineSet: reuseMachineSet,
+		SpreadZones: spreadZones, RemediateNetwork: remediateNetwork, WindowsVersion: windowsVersion, Resources: resources}
 	// Set up the framework
 	err := f.TestFramework.Setup(vmCount, skipVMSetup)
 	if err != nil {
@@ -80,57 +85,130 @@ func (f *wmcbFramework) Setup(vmCount int, skipVMSetup bool) error {
 	return nil
 }
 
-// TestWMCB runs the unit and e2e tests for WMCB on the remote VMs
+// TestWMCB runs the unit and e2e tests for WMCB on the remote VMs of each Windows Server version, in turn
 func TestWMCB(t *testing.T) {
+	for _, version := range windowsVersions {
+		version := version
+		t.Run("Windows Server "+version.Name, func(t *testing.T) {
+			err := framework.Setup(vmCount, skipVMSetup, version.Name)
+			// The VMs are destroyed once the tests of the version are done, even if a test panics, or if they could
+			// not all be set up
+			t.Cleanup(framework.TearDown)
+			require.NoError(t, err)
+			// Retrieve artifacts after running the test, before the VMs are destroyed
+			t.Cleanup(framework.RetrieveArtifacts)
+			testWMCBVMs(t, version)
+		})
+	}
+}
+
+// testWMCBVMs runs the unit and e2e tests for WMCB on the remote VMs of the framework, which run the given Windows
+// Server version
+func testWMCBVMs(t *testing.T, version windows.ServerVersion) {
 	srcDestPairs := map[string]string{
 		payloadDirectory: remoteDir,
 		cniDirectory:     winCNIDir,
 	}
 
-	for _, vm := range framework.WinVMs {
-		log.Printf("Testing VM: %s", vm.GetCredentials().InstanceId())
-		wVM := &wmcbVM{vm}
-		for src, dest := range srcDestPairs {
-			err := wVM.CopyDirectory(src, dest)
-			require.NoError(t, err, "error copying %s to the Windows VM", src)
-		}
-		t.Run("Unit", func(t *testing.T) {
-			assert.NoError(t, wVM.runTest(unitExecutable+" --test.v"), "WMCB unit test failed")
+	for i := range framework.WinVMs {
+		first := i == 0
+		t.Run(fmt.Sprintf("VM %d", i), func(t *testing.T) {
+			testWMCBVM(t, framework.LeaseVM(t), version, srcDestPairs, first)
 		})
-		t.Run("E2E", func(t *testing.T) {
-			wVM.runE2ETestSuite(t)
-		})
-		t.Run("WMCB cluster tests", testWMCBCluster)
 	}
 }
 
+// testWMCBVM runs the unit and e2e tests for WMCB on the given VM, which runs the given Windows Server version, after
+// copying the given local directories to the remote ones. An image of the VM is created if first is true and an image
+// name was given.
+func testWMCBVM(t *testing.T, vm e2ef.TestWindowsVM, version windows.ServerVersion, srcDestPairs map[string]string,
+	first bool) {
+	log.Printf("Testing VM: %s", vm.GetCredentials().InstanceId())
+	wVM := &wmcbVM{vm, version}
+	t.Run("Windows Server version", func(t *testing.T) {
+		build, err := windows.GetBuildNumber(vm)
+		require.NoError(t, err, "error getting build number of the Windows VM")
+		assert.Equal(t, version.Build, build, "expected the Windows VM to run Windows Server %s", version.Name)
+	})
+	for src, dest := range srcDestPairs {
+		err := wVM.CopyDirectory(src, dest)
+		require.NoError(t, err, "error copying %s to the Windows VM", src)
+	}
+	// The image is created before the VM is configured as a node
+	if first && snapshotImage != "" {
+		imageName := snapshotImage
+		if len(windowsVersions) > 1 {
+			imageName += "-" + strings.ToLower(version.Name)
+		}
+		imageID, err := framework.CreateImage(vm, imageName)
+		require.NoError(t, err, "error creating image of the Windows VM")
+		log.Printf("Creating image %s of VM %s, set AWS_WINDOWS_AMI_ID=%s to create the VMs from it", imageID,
+			vm.GetCredentials().InstanceId(), imageID)
+	}
+	t.Run("Unit", func(t *testing.T) {
+		assert.NoError(t, wVM.runTest("wmcb_unit", unitExecutable, ""), "WMCB unit test failed")
+	})
+	// The CSRs of the node need to be approved for the node to join the cluster
+	stopCh, err := wVM.startCSRApprover()
+	require.NoError(t, err, "error starting CSR approver")
+	defer close(stopCh)
+	t.Run("E2E", func(t *testing.T) {
+		wVM.runE2ETestSuite(t)
+	})
+	t.Run("WMCB cluster tests", testWMCBCluster)
+}
+
+// startCSRApprover starts approving the CSRs of the node associated with the VM in the background. The returned channel
+// needs to be closed to stop the approval.
+func (vm *wmcbVM) startCSRApprover() (chan struct{}, error) {
+	hostname, err := vm.Run("hostname", false)
+	if err != nil {
+		return nil, fmt.Errorf("error getting hostname of the VM: %v", err)
+	}
+	approver, err := csr.NewApprover(framework.K8sclientset, strings.TrimSpace(hostname))
+	if err != nil {
+		return nil, fmt.Errorf("error creating CSR approver: %v", err)
+	}
+	stopCh := make(chan struct{})
+	go approver.Run(e2ef.RetryInterval, stopCh)
+	return stopCh, nil
+}
+
 // runE2ETestSuite runs the WmCB e2e tests suite on the VM
 func (vm *wmcbVM) runE2ETestSuite(t *testing.T) {
 	vm.runTestBootstrapper(t)
 
 	vm.runTestConfigureCNI(t)
 
+	vm.runTestPauseImage(t)
+
+	vm.runTestConnectivity(t)
+
 	// Run this test only after TestBoostrapper() to ensure kubelet service is present.
 	vm.runTestKubeletUninstall(t)
 }
 
-// runTest runs the testCmd in the given VM
-func (vm *wmcbVM) runTest(testCmd string) error {
-	output, err := vm.Run(testCmd, true)
-
-	// Logging the output so that it is visible on the CI page
-	log.Printf("\n%s\n", output)
-
+// runTest runs the tests of the given test binary on the VM that match the given regular expression, as the test suite
+// with the given name. The output of the tests is logged as it is produced, so that it is visible on the CI page, and
+// their results are written to the artifact directory as a JUnit report and as go test2json events.
+func (vm *wmcbVM) runTest(name, binary, run string) error {
+	suiteName := name + "_" + vm.serverVersion.Name + "_" + vm.GetCredentials().InstanceId()
+	suite, err := gotest.Run(context.Background(), vm, suiteName, binary, run, log.Writer())
 	if err != nil {
-		return fmt.Errorf("error running test: %v: %s", err, output)
+		return fmt.Errorf("error running test: %v", err)
 	}
-	if strings.Contains(output, "FAIL") {
-		return fmt.Errorf("test output showed a failure")
+	// The reports are nice to have, failing to write them does not fail the tests
+	if report, err := gotest.JUnit(suite); err != nil {
+		log.Printf("error generating JUnit report of %s: %v", suiteName, err)
+	} else if err = framework.WriteToArtifactDir(report, "junit", "junit_"+suiteName+".xml"); err != nil {
+		log.Printf("error writing JUnit report of %s: %v", suiteName, err)
 	}
-	if strings.Contains(output, "panic") {
-		return fmt.Errorf("test output showed panic")
+	if events, err := gotest.JSON(suite); err != nil {
+		log.Printf("error generating test2json events of %s: %v", suiteName, err)
+	} else if err = framework.WriteToArtifactDir(events, "test2json", suiteName+".json"); err != nil {
+		log.Printf("error writing test2json events of %s: %v", suiteName, err)
 	}
-	return nil
+	return suite.Err()
 }
 
 // runTestBootstrapper runs the initialize-kubelet tests
@@ -138,7 +216,7 @@ func (vm *wmcbVM) runTestBootstrapper(t *testing.T) {
 	err := vm.initializeTestBootstrapperFiles()
 	require.NoError(t, err, "error initializing files required for TestBootstrapper")
 
-	err = vm.runTest(e2eExecutable + " --test.run TestBootstrapper --test.v")
+	err = vm.runTest("wmcb_e2e_bootstrapper", e2eExecutable, "^TestBootstrapper$")
 	require.NoError(t, err, "TestBootstrapper failed")
 }
 
@@ -155,7 +233,7 @@ func (vm *wmcbVM) runTestConfigureCNI(t *testing.T) {
 	err = vm.initializeTestConfigureCNIFiles(hybridOverlayAnnotation)
 	require.NoError(t, err, "error initializing files required for TestConfigureCNI")
 
-	err = vm.runTest(e2eExecutable + " --test.run TestConfigureCNI --test.v")
+	err = vm.runTest("wmcb_e2e_configure_cni", e2eExecutable, "^TestConfigureCNI$")
 	require.NoError(t, err, "TestConfigureCNI failed")
 }
 
@@ -173,8 +251,8 @@ func (vm *wmcbVM) initializeTestBootstrapperFiles() error {
 		return fmt.Errorf("unable to copy kubelet.exe to %s: %v", winTemp, err)
 	}
 
-	// Ignition v2.3.0 maps to Ignition config spec v3.1.0.
-	ignitionAcceptHeaderSpec := "application/vnd.coreos.ignition+json`;version=3.1.0"
+	// Ignition v2.3.0 maps to Ignition config spec v3.1.0. The semicolon of the header is escaped for PowerShell.
+	ignitionAcceptHeaderSpec := strings.ReplaceAll(ignition.AcceptHeader, ";", "`;")
 	// Download the worker ignition to C:\Windows\Tenp\ using the script that ignores the server cert
 	output, err := vm.Run(wgetIgnoreCertCmd+" -server https://"+framework.ClusterAddress+":22623/config/worker"+
 		" -output "+winTemp+"worker.ign"+" -acceptHeader "+ignitionAcceptHeaderSpec, true)
@@ -290,8 +368,36 @@ func (vm *wmcbVM) waitForHybridOverlayToRun() error {
 	return fmt.Errorf("timeout waiting for hybrid-overlay-node: %v", err)
 }
 
+// runTestPauseImage checks that the kubelet is configured with the pause image matching the Windows Server version of
+// the VM, as the pause container cannot run on a host of a different build
+func (vm *wmcbVM) runTestPauseImage(t *testing.T) {
+	// The buffer size is given so that the whole command line of the kubelet is printed
+	output, err := vm.Run("sc.exe qc kubelet 8192", false)
+	require.NoError(t, err, "error querying the kubelet service")
+	assert.Contains(t, output, "--pod-infra-container-image="+vm.serverVersion.PauseImage,
+		"expected the kubelet to use the pause image of Windows Server %s", vm.serverVersion.Name)
+}
+
+// runTestConnectivity checks the traffic between Windows and Linux pods, from Windows pods to services and from Windows
+// pods to outside of the cluster, which requires the CNI to be configured. The packet level diagnostics of the node
+// are written to the artifact directory for each check that fails.
+func (vm *wmcbVM) runTestConnectivity(t *testing.T) {
+	nodeName, err := framework.GetNodeName(vm.GetCredentials().IPAddress())
+	require.NoError(t, err, "error getting node of the Windows VM")
+	options := workload.Options{VM: vm, DiagnosticsDir: framework.ArtifactPath("nodes", nodeName, "network")}
+	results, err := workload.CheckConnectivity(context.Background(), framework.K8sclientset, nodeName,
+		vm.serverVersion.ServerCoreImage, options)
+	require.NoError(t, err, "error deploying the web servers")
+	for _, result := range results {
+		result := result
+		t.Run(result.Name, func(t *testing.T) {
+			assert.NoError(t, result.Err)
+		})
+	}
+}
+
 func (vm *wmcbVM) runTestKubeletUninstall(t *testing.T) {
-	err := vm.runTest(e2eExecutable + " --test.run TestKubeletUninstall --test.v")
+	err := vm.runTest("wmcb_e2e_kubelet_uninstall", e2eExecutable, "^TestKubeletUninstall$")
 	require.NoError(t, err, "TestKubeletUninstall failed")
 }
 
@@ -333,25 +439,9 @@ func getServiceNetworkCIDR() (string, error) {
 // generateCNIConf generates the cni.conf file, based on the input OVN host subnet and service network CIDR, and
 // returns the its path
 func generateCNIConf(ovnHostSubnet, serviceNetworkCIDR string) (string, error) {
-	// cniConf is used in replacing the template values in templates/cni.template
-	type cniConf struct {
-		OvnHostSubnet      string
-		ServiceNetworkCIDR string
-	}
-	confData := cniConf{ovnHostSubnet, serviceNetworkCIDR}
-
-	// Read the contents of the template file
-	content, err := ioutil.ReadFile(cniConfigTemplate)
+	config, err := wsu.CNIConfig(ovnHostSubnet, serviceNetworkCIDR)
 	if err != nil {
-		return "", fmt.Errorf("error reading CNI config template: %v", err)
-	}
-
-	cniConfTmpl := template.New("CNI")
-
-	// Parse the template
-	cniConfTmpl, err = cniConfTmpl.Parse(string(content))
-	if err != nil {
-		return "", fmt.Errorf("error parsing CNI config template: %v", err)
+		return "", err
 	}
 
 	// Create a temp file to hold the config
@@ -360,21 +450,11 @@ func generateCNIConf(ovnHostSubnet, serviceNetworkCIDR string) (string, error) {
 		return "", fmt.Errorf("error creating local temp CNI directory: %v", err)
 	}
 
-	cniConfigPath, err := os.Create(filepath.Join(tmpCniDir, "cni.conf"))
-	if err != nil {
+	cniConfigPath := filepath.Join(tmpCniDir, "cni.conf")
+	if err = ioutil.WriteFile(cniConfigPath, config, 0644); err != nil {
 		return "", fmt.Errorf("error creating local cni.conf: %v", err)
 	}
-
-	// Take the data values, replace it in the template and write the result out to a file
-	if err = cniConfTmpl.Execute(cniConfigPath, confData); err != nil {
-		return "", fmt.Errorf("error applying data to CNI config template: %v", err)
-	}
-
-	if err = cniConfigPath.Close(); err != nil {
-		return "", fmt.Errorf("error closing %s: %v", cniConfigPath.Name(), err)
-	}
-
-	return cniConfigPath.Name(), nil
+	return cniConfigPath, nil
 }
 
 // waitForHybridOverlayAnnotation waits for the hybrid overlay subnet annotation to be present on the node until the
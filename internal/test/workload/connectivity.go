@@ -0,0 +1,130 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/windows"
+)
+
+const (
+	// DefaultExternalURL is the URL outside of the cluster the Windows pods reach if the options do not specify one
+	DefaultExternalURL = "https://www.redhat.com"
+	// linuxServerName is the name of the Linux web server pod, of its service and of its app label
+	linuxServerName = "linux-webserver"
+	// linuxServerPort is the port the Linux web server listens on, which does not require privileges
+	linuxServerPort = 8080
+	// linuxServerContent is the content served by the Linux web server, which lists the root directory of the pod
+	linuxServerContent = "Directory listing"
+)
+
+// Options configure the connectivity checks
+type Options struct {
+	// ExternalURL is the URL outside of the cluster the Windows pods reach. DefaultExternalURL is used if it is not set.
+	ExternalURL string
+	// VM is the Windows VM of the node, which the packet level diagnostics are collected from when a check fails. The
+	// diagnostics are not collected if it is nil.
+	VM windows.WindowsVM
+	// DiagnosticsDir is the local directory the diagnostics are written to, in a directory for each failed check
+	DiagnosticsDir string
+}
+
+// Result is the outcome of a connectivity check
+type Result struct {
+	// Name is the name of the check, like "Windows pod to Linux pod"
+	Name string
+	// Err is the reason the check failed, nil if it passed
+	Err error
+}
+
+// connectivityCheck is a connectivity check, run by a client pod
+type connectivityCheck struct {
+	// name is the name of the check
+	name string
+	// client is the client pod, which succeeds once it reaches its URL
+	client *v1.Pod
+}
+
+// CheckConnectivity checks the traffic of the pods of the Windows node with the given name, as most Windows node bugs
+// manifest in networking: it deploys a web server pod running the given Windows image to the node and a Linux web
+// server pod, and checks that
+//   - a Windows pod reaches the Linux pod
+//   - a Linux pod reaches the Windows pod
+//   - a Windows pod reaches the ClusterIP service of the Linux pod
+//   - a Linux pod reaches the ClusterIP service of the Windows pod
+//   - a Windows pod reaches the external URL
+//
+// The outcome of every check is returned, along with an error if the web servers could not be deployed. The packet
+// level diagnostics of the node are collected for each check that fails, if the options give the VM of the node. The
+// resources are created in a namespace of their own, which is deleted once the checks are done.
+func CheckConnectivity(ctx context.Context, client kubernetes.Interface, nodeName, image string,
+	options Options) ([]Result, error) {
+	if options.ExternalURL == "" {
+		options.ExternalURL = DefaultExternalURL
+	}
+	namespace, deleteNamespace, err := createNamespace(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	defer deleteNamespace()
+
+	log.Printf("deploying web servers for node %s in namespace %s", nodeName, namespace)
+	windowsServer, err := deployWindowsWebServer(ctx, client, namespace, nodeName, image)
+	if err != nil {
+		return nil, err
+	}
+	linuxServer := linuxPod(linuxServerName,
+		fmt.Sprintf("/usr/libexec/platform-python -m http.server %d --directory /", linuxServerPort))
+	linuxServer.Labels = map[string]string{"app": linuxServerName}
+	linuxServer.Spec.RestartPolicy = v1.RestartPolicyAlways
+	linuxServer.Spec.Containers[0].Ports = []v1.ContainerPort{{ContainerPort: linuxServerPort,
+		Protocol: v1.ProtocolTCP}}
+	if linuxServer, err = deployServer(ctx, client, namespace, linuxServer, linuxServerPort); err != nil {
+		return nil, err
+	}
+
+	checks := []connectivityCheck{
+		{name: "Windows pod to Linux pod", client: windowsClientPod("windows-to-linux-pod", nodeName, image,
+			podURL(linuxServer, linuxServerPort), linuxServerContent)},
+		{name: "Linux pod to Windows pod", client: linuxClientPod("linux-to-windows-pod",
+			podURL(windowsServer, webServerPort), webServerContent)},
+		{name: "Windows pod to ClusterIP service", client: windowsClientPod("windows-to-service", nodeName, image,
+			serviceURL(linuxServerName, namespace, linuxServerPort), linuxServerContent)},
+		{name: "Linux pod to ClusterIP service", client: linuxClientPod("linux-to-service",
+			serviceURL(webServerName, namespace, webServerPort), webServerContent)},
+		// Any page served by the external URL will do
+		{name: "Windows pod to external URL", client: windowsClientPod("windows-to-external", nodeName, image,
+			options.ExternalURL, "")},
+	}
+
+	var diag *diagnostics
+	if options.VM != nil {
+		diag = &diagnostics{vm: options.VM, dir: options.DiagnosticsDir}
+		if err = diag.start(ctx); err != nil {
+			log.Printf("unable to capture packets on node %s, no packet level diagnostics are collected: %v",
+				nodeName, err)
+			diag = nil
+		} else {
+			defer diag.stop(context.Background())
+		}
+	}
+	results := make([]Result, 0, len(checks))
+	for _, check := range checks {
+		log.Printf("checking connectivity: %s", check.name)
+		err := runClient(ctx, client, namespace, check.client)
+		if err != nil && diag != nil {
+			diag.collect(ctx, check.client.Name)
+		}
+		results = append(results, Result{Name: check.name, Err: err})
+	}
+	return results, nil
+}
+
+// podURL returns the URL of the given port of the given pod, by IP
+func podURL(pod *v1.Pod, port int32) string {
+	return fmt.Sprintf("http://%s:%d", pod.Status.PodIP, port)
+}
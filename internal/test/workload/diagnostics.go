@@ -0,0 +1,98 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/windows"
+)
+
+const (
+	// remoteDiagnosticsDir is the directory on the Windows VM the diagnostics are written to
+	remoteDiagnosticsDir = "C:\\k\\diagnostics\\"
+	// captureFile is the packet capture of pktmon on the Windows VM
+	captureFile = remoteDiagnosticsDir + "pktmon.etl"
+	// captureTextFile is the packet capture of pktmon formatted as text on the Windows VM
+	captureTextFile = remoteDiagnosticsDir + "pktmon.txt"
+)
+
+// capturedPorts are the ports the packets of which are captured: the web servers, DNS and HTTPS for the external URL
+var capturedPorts = []int{webServerPort, linuxServerPort, 53, 443}
+
+// diagnosticCommands are the PowerShell commands describing the network of the Windows VM, by the name of the file
+// their output is written to
+var diagnosticCommands = map[string]string{
+	"hns.txt":      "hnsdiag list all",
+	"ipconfig.txt": "ipconfig /all",
+	"routes.txt":   "route print",
+	"firewall.txt": "netsh advfirewall show allprofiles",
+	"vfp.txt":      "vfpctrl /list-vmswitch-port",
+}
+
+// diagnostics collects the packet level diagnostics of a Windows VM when a connectivity check fails, which are the
+// packets captured with pktmon while the check ran and the state of the host network. The diagnostics are best
+// effort, failing to collect them does not fail the checks.
+type diagnostics struct {
+	// vm is the Windows VM the diagnostics are collected from
+	vm windows.WindowsVM
+	// dir is the local directory the diagnostics are written to
+	dir string
+}
+
+// start starts capturing the packets of capturedPorts on the Windows VM, replacing the capture filters of pktmon
+func (d *diagnostics) start(ctx context.Context) error {
+	cmd := "New-Item -ItemType Directory -Force -Path " + remoteDiagnosticsDir + " | Out-Null; pktmon stop | Out-Null; " +
+		"pktmon filter remove | Out-Null"
+	for _, port := range capturedPorts {
+		cmd += fmt.Sprintf("; pktmon filter add -p %d | Out-Null", port)
+	}
+	cmd += "; pktmon start --etw -p 0 -f " + captureFile
+	if _, err := d.vm.RunContext(ctx, cmd, true); err != nil {
+		return fmt.Errorf("error starting pktmon: %v", err)
+	}
+	return nil
+}
+
+// stop stops capturing packets on the Windows VM
+func (d *diagnostics) stop(ctx context.Context) {
+	if _, err := d.vm.RunContext(ctx, "pktmon stop; pktmon filter remove", true); err != nil {
+		log.Printf("error stopping pktmon: %v", err)
+	}
+}
+
+// collect writes the packets captured since the capture started, as text, and the state of the host network of the
+// Windows VM to the directory with the given name of the local directory, then starts capturing packets again
+func (d *diagnostics) collect(ctx context.Context, name string) {
+	dir := filepath.Join(d.dir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("error creating diagnostics directory %s: %v", dir, err)
+		return
+	}
+	log.Printf("collecting the network diagnostics of %s to %s", d.vm.GetCredentials().InstanceId(), dir)
+	_, err := d.vm.RunContext(ctx, "pktmon stop | Out-Null; pktmon format "+captureFile+" -o "+captureTextFile, true)
+	if err != nil {
+		log.Printf("error formatting packet capture: %v", err)
+	} else {
+		for _, file := range []string{captureFile, captureTextFile} {
+			if err = d.vm.RetrieveFileContext(ctx, file, dir); err != nil {
+				log.Printf("error retrieving %s: %v", file, err)
+			}
+		}
+	}
+	for fileName, cmd := range diagnosticCommands {
+		out, err := d.vm.RunContext(ctx, cmd, true)
+		if err != nil {
+			out += fmt.Sprintf("\nerror running %s: %v\n", cmd, err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, fileName), []byte(out), 0644); err != nil {
+			log.Printf("error writing %s: %v", fileName, err)
+		}
+	}
+	if err = d.start(ctx); err != nil {
+		log.Printf("error restarting packet capture: %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// webServerName is the name of the Windows web server pod, of its service and of its app label
+	webServerName = "win-webserver"
+	// webServerPort is the port the Windows web server listens on
+	webServerPort = 80
+	// webServerContent is the content served by the Windows web server, which the clients check for
+	webServerContent = "Windows Container Web Server"
+	// linuxImage is the image of the Linux pods, which has curl and python
+	linuxImage = "registry.access.redhat.com/ubi8/ubi:latest"
+	// runningTimeout is the time a pod has to be Running, which includes pulling its image. The Windows images take
+	// minutes to pull.
+	runningTimeout = 20 * time.Minute
+	// clientTimeout is the time a client pod has to reach its URL, pulling its image is not included
+	clientTimeout = 5 * time.Minute
+	// pollInterval is the interval the pods are checked at and the clients retry at
+	pollInterval = 5 * time.Second
+)
+
+// webServerScript is the PowerShell script the Windows web server pod runs, serving webServerContent
+var webServerScript = "$listener = New-Object System.Net.HttpListener; " +
+	fmt.Sprintf("$listener.Prefixes.Add('http://*:%d/'); ", webServerPort) +
+	"$listener.Start(); " +
+	"while ($listener.IsListening) { " +
+	"$context = $listener.GetContext(); " +
+	"$buffer = [System.Text.Encoding]::UTF8.GetBytes('<html><body><h1>" + webServerContent + "</h1></body></html>'); " +
+	"$context.Response.ContentLength64 = $buffer.Length; " +
+	"$context.Response.OutputStream.Write($buffer, 0, $buffer.Length); " +
+	"$context.Response.Close() }"
+
+// Check checks that the Windows node with the given name runs workloads: it deploys a web server pod running the given
+// Windows image to the node, waits for it to be Running and checks that a Linux pod reaches it through a service,
+// which validates the CNI and the service proxy of the node end to end rather than just the node being Ready. The
+// image needs to match the Windows build of the node. The resources are created in a namespace of their own, which is
+// deleted once the check is done.
+func Check(ctx context.Context, client kubernetes.Interface, nodeName, image string) error {
+	namespace, deleteNamespace, err := createNamespace(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer deleteNamespace()
+
+	log.Printf("deploying Windows web server to node %s in namespace %s", nodeName, namespace)
+	if _, err = deployWindowsWebServer(ctx, client, namespace, nodeName, image); err != nil {
+		return err
+	}
+	url := serviceURL(webServerName, namespace, webServerPort)
+	log.Printf("reaching Windows web server at %s from a Linux pod", url)
+	if err = runClient(ctx, client, namespace, linuxClientPod("linux-client", url, webServerContent)); err != nil {
+		return fmt.Errorf("error reaching the Windows web server from a Linux pod: %v", err)
+	}
+	return nil
+}
+
+// createNamespace creates a namespace for the workloads and returns its name along with a function deleting it
+func createNamespace(ctx context.Context, client kubernetes.Interface) (string, func(), error) {
+	namespace, err := client.CoreV1().Namespaces().Create(ctx, &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "windows-workload-"},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", nil, fmt.Errorf("error creating namespace: %v", err)
+	}
+	return namespace.Name, func() {
+		err := client.CoreV1().Namespaces().Delete(context.Background(), namespace.Name, metav1.DeleteOptions{})
+		if err != nil {
+			log.Printf("error deleting namespace %s: %v", namespace.Name, err)
+		}
+	}, nil
+}
+
+// deployWindowsWebServer deploys the Windows web server pod running the given image to the Windows node with the given
+// name, along with its service, and returns the pod once it is Running
+func deployWindowsWebServer(ctx context.Context, client kubernetes.Interface, namespace, nodeName,
+	image string) (*v1.Pod, error) {
+	pod := windowsPod(webServerName, nodeName, image, webServerScript)
+	pod.Labels = map[string]string{"app": webServerName}
+	pod.Spec.RestartPolicy = v1.RestartPolicyAlways
+	pod.Spec.Containers[0].Ports = []v1.ContainerPort{{ContainerPort: webServerPort, Protocol: v1.ProtocolTCP}}
+	return deployServer(ctx, client, namespace, pod, webServerPort)
+}
+
+// deployServer creates the given server pod along with a service exposing the given port of the pod, which has the
+// name of the pod and selects it by its app label, and returns the pod once it is Running
+func deployServer(ctx context.Context, client kubernetes.Interface, namespace string, pod *v1.Pod,
+	port int32) (*v1.Pod, error) {
+	if _, err := client.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		return nil, fmt.Errorf("error creating pod %s: %v", pod.Name, err)
+	}
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name},
+		Spec: v1.ServiceSpec{
+			Selector: map[string]string{"app": pod.Labels["app"]},
+			Ports: []v1.ServicePort{{
+				Port:       port,
+				TargetPort: intstr.FromInt(int(port)),
+				Protocol:   v1.ProtocolTCP,
+			}},
+		},
+	}
+	if _, err := client.CoreV1().Services(namespace).Create(ctx, service, metav1.CreateOptions{}); err != nil {
+		return nil, fmt.Errorf("error creating service %s: %v", service.Name, err)
+	}
+	running, err := waitForRunning(ctx, client, namespace, pod.Name)
+	if err != nil {
+		return nil, err
+	}
+	if running.Status.Phase != v1.PodRunning {
+		return nil, fmt.Errorf("pod %s is %s%s", pod.Name, running.Status.Phase, podLogs(client, namespace, pod.Name))
+	}
+	return running, nil
+}
+
+// windowsPod returns a pod running the given PowerShell script with the given Windows image, which is scheduled to the
+// Windows node with the given name and is not restarted
+func windowsPod(name, nodeName, image, script string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1.PodSpec{
+			NodeSelector: map[string]string{"kubernetes.io/os": "windows"},
+			// The node is selected by the scheduler, as the other workloads are
+			Affinity: &v1.Affinity{
+				NodeAffinity: &v1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+						NodeSelectorTerms: []v1.NodeSelectorTerm{{
+							MatchFields: []v1.NodeSelectorRequirement{{
+								Key:      "metadata.name",
+								Operator: v1.NodeSelectorOpIn,
+								Values:   []string{nodeName},
+							}},
+						}},
+					},
+				},
+			},
+			// The Windows nodes are tainted so that only the workloads meant for them are scheduled to them
+			Tolerations: []v1.Toleration{{
+				Key:      "os",
+				Operator: v1.TolerationOpEqual,
+				Value:    "Windows",
+				Effect:   v1.TaintEffectNoSchedule,
+			}},
+			RestartPolicy: v1.RestartPolicyNever,
+			Containers: []v1.Container{{
+				Name:    name,
+				Image:   image,
+				Command: []string{"powershell.exe", "-command", script},
+			}},
+		},
+	}
+}
+
+// linuxPod returns a pod running the given shell script, which is scheduled to a Linux node and is not restarted
+func linuxPod(name, script string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1.PodSpec{
+			NodeSelector:  map[string]string{"kubernetes.io/os": "linux"},
+			RestartPolicy: v1.RestartPolicyNever,
+			Containers: []v1.Container{{
+				Name:    name,
+				Image:   linuxImage,
+				Command: []string{"/bin/sh", "-c", script},
+			}},
+		},
+	}
+}
+
+// linuxClientPod returns a Linux pod fetching the given URL until the response contains the given content, for
+// clientTimeout at most
+func linuxClientPod(name, url, content string) *v1.Pod {
+	script := fmt.Sprintf("for i in $(seq %d); do curl --silent --show-error --location --max-time 5 '%s' | "+
+		"grep -q '%s' && exit 0; sleep %d; done; exit 1", int(clientTimeout/pollInterval), url, content,
+		int(pollInterval.Seconds()))
+	return linuxPod(name, script)
+}
+
+// windowsClientPod returns a Windows pod running the given image on the given node, fetching the given URL until the
+// response contains the given content, for clientTimeout at most
+func windowsClientPod(name, nodeName, image, url, content string) *v1.Pod {
+	script := fmt.Sprintf("for ($i = 0; $i -lt %d; $i++) { try { "+
+		"$response = Invoke-WebRequest -UseBasicParsing -TimeoutSec 5 -Uri '%s'; "+
+		"if ($response.Content -match '%s') { exit 0 } } catch { Write-Output $_.Exception.Message }; "+
+		"Start-Sleep -Seconds %d }; exit 1", int(clientTimeout/pollInterval), url, content,
+		int(pollInterval.Seconds()))
+	return windowsPod(name, nodeName, image, script)
+}
+
+// serviceURL returns the URL of the given port of the service with the given name
+func serviceURL(name, namespace string, port int32) string {
+	return fmt.Sprintf("http://%s.%s.svc.cluster.local:%d", name, namespace, port)
+}
+
+// runClient runs the given client pod until it completes, returning its logs if it fails
+func runClient(ctx context.Context, client kubernetes.Interface, namespace string, pod *v1.Pod) error {
+	if _, err := client.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("error creating pod %s: %v", pod.Name, err)
+	}
+	if _, err := waitForRunning(ctx, client, namespace, pod.Name); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*clientTimeout)
+	defer cancel()
+	err := wait.PollImmediateUntil(pollInterval, func() (bool, error) {
+		current, err := client.CoreV1().Pods(namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			log.Printf("error getting pod %s: %v", pod.Name, err)
+			return false, nil
+		}
+		switch current.Status.Phase {
+		case v1.PodSucceeded:
+			return true, nil
+		case v1.PodFailed:
+			return false, fmt.Errorf("pod %s failed", pod.Name)
+		}
+		return false, nil
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("%v%s", err, podLogs(client, namespace, pod.Name))
+	}
+	return nil
+}
+
+// waitForRunning waits for the pod with the given name to be Running, or to be done, for runningTimeout at most, and
+// returns it
+func waitForRunning(ctx context.Context, client kubernetes.Interface, namespace, name string) (*v1.Pod, error) {
+	ctx, cancel := context.WithTimeout(ctx, runningTimeout)
+	defer cancel()
+	var pod *v1.Pod
+	err := wait.PollImmediateUntil(pollInterval, func() (bool, error) {
+		var err error
+		pod, err = client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			log.Printf("error getting pod %s: %v", name, err)
+			return false, nil
+		}
+		return pod.Status.Phase != v1.PodPending, nil
+	}, ctx.Done())
+	if err != nil {
+		return nil, fmt.Errorf("error waiting for pod %s to be Running: %v%s", name, err, podStatus(pod))
+	}
+	return pod, nil
+}
+
+// podStatus returns the reasons the containers of the given pod are waiting for, if any, to be appended to an error
+func podStatus(pod *v1.Pod) string {
+	if pod == nil {
+		return ""
+	}
+	var reasons []string
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil {
+			reasons = append(reasons, status.State.Waiting.Reason+": "+status.State.Waiting.Message)
+		}
+	}
+	if len(reasons) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(reasons, ", ") + ")"
+}
+
+// podLogs returns the logs of the pod with the given name, to be appended to an error
+func podLogs(client kubernetes.Interface, namespace, name string) string {
+	out, err := client.CoreV1().Pods(namespace).GetLogs(name, &v1.PodLogOptions{}).DoRaw(context.Background())
+	if err != nil || len(strings.TrimSpace(string(out))) == 0 {
+		return ""
+	}
+	return ": " + strings.TrimSpace(string(out))
+}
@@ -0,0 +1,73 @@
+package wsu
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultParallelism is the number of Windows instances of a batch bootstrapped at the same time if it is not set
+const DefaultParallelism = 5
+
+// HostResult is the outcome of the bootstrap of one of the Windows instances of a batch
+type HostResult struct {
+	// InstanceID identifies the instance
+	InstanceID string `json:"instanceID"`
+	// IPAddress is the address the instance is accessed at
+	IPAddress string `json:"ipAddress"`
+	// Duration is the time the bootstrap of the instance took
+	Duration time.Duration `json:"duration"`
+	// Err is the error the bootstrap of the instance failed with, nil if the instance became a Ready node
+	Err error `json:"-"`
+	// Error is the message of Err, for the JSON report
+	Error string `json:"error,omitempty"`
+}
+
+// RunBatch bootstraps the given Windows instances into nodes with the given config, which is used for every instance
+// in place of its Instance. Up to parallelism instances are bootstrapped at the same time, DefaultParallelism if it is
+// not positive. A failed instance does not stop the bootstrap of the others, and the results are returned in the order
+// of the instances once all of them are done or the context is done.
+func RunBatch(ctx context.Context, config Config, instances []Instance, parallelism int) []HostResult {
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+	results := make([]HostResult, len(instances))
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i := range instances {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			instance := instances[i]
+			start := time.Now()
+			err := ctx.Err()
+			if err == nil {
+				err = runInstance(ctx, config, instance)
+			}
+			results[i] = HostResult{InstanceID: instance.InstanceID, IPAddress: instance.IPAddress,
+				Duration: time.Since(start).Round(time.Second), Err: err}
+			if err != nil {
+				results[i].Error = err.Error()
+				log.Printf("error bootstrapping instance %s: %v", instance.InstanceID, err)
+			} else {
+				log.Printf("bootstrapped instance %s", instance.InstanceID)
+			}
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// runInstance bootstraps the given Windows instance with the given config
+func runInstance(ctx context.Context, config Config, instance Instance) error {
+	config.Instance = instance
+	w, err := New(config)
+	if err != nil {
+		return err
+	}
+	return w.Run(ctx)
+}
@@ -0,0 +1,61 @@
+package wsu
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// cniConfigTemplate is the template of the CNI config of the OVN hybrid overlay
+const cniConfigTemplate = `{
+    "cniVersion":"0.2.0",
+    "name":"OpenShiftNetwork",
+    "type":"win-overlay",
+    "capabilities": {
+        "dns": true
+    },
+    "ipam": {
+        "type": "host-local",
+        "subnet": "{{ .OvnHostSubnet }}"
+    },
+    "policies":[
+        {
+            "name":"EndpointPolicy",
+            "value":{
+                "Type":"OutBoundNAT",
+                "ExceptionList":[
+                    "{{ .ServiceNetworkCIDR }}"
+                ]
+            }
+        },
+        {
+            "name":"EndpointPolicy",
+            "value":{
+                "Type":"ROUTE",
+                "DestinationPrefix":"{{ .ServiceNetworkCIDR }}",
+                "NeedEncap":true
+            }
+        }
+    ]
+}
+`
+
+// CNIConfig returns the CNI config of a node with the given OVN hybrid overlay host subnet, in a cluster with the given
+// service network CIDR
+func CNIConfig(ovnHostSubnet, serviceNetworkCIDR string) ([]byte, error) {
+	// cniConf is used in replacing the template values in cniConfigTemplate
+	type cniConf struct {
+		OvnHostSubnet      string
+		ServiceNetworkCIDR string
+	}
+
+	cniConfTmpl, err := template.New("CNI").Parse(cniConfigTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CNI config template: %v", err)
+	}
+	var config bytes.Buffer
+	if err = cniConfTmpl.Execute(&config, cniConf{ovnHostSubnet, serviceNetworkCIDR}); err != nil {
+		return nil, fmt.Errorf("error applying data to CNI config template: %v", err)
+	}
+	return config.Bytes(), nil
+}
@@ -0,0 +1,110 @@
+package wsu
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/ignition"
+)
+
+const (
+	// userDataNamespace is the namespace of the worker user data secret
+	userDataNamespace = "openshift-machine-api"
+	// workerUserDataSecret is the secret holding the stub ignition config the worker machines are created with, which
+	// points to the worker ignition config served by the machine config server
+	workerUserDataSecret = "worker-user-data"
+)
+
+// ignitionSource is a resource referenced by an ignition config
+type ignitionSource struct {
+	Source string `json:"source"`
+}
+
+// stubIgnition holds the fields of the worker stub ignition config required to fetch the worker ignition config
+type stubIgnition struct {
+	Ignition struct {
+		Config struct {
+			// Merge is used by the Ignition config spec v3 and Append by v2
+			Merge  []ignitionSource `json:"merge"`
+			Append []ignitionSource `json:"append"`
+		} `json:"config"`
+		Security struct {
+			TLS struct {
+				CertificateAuthorities []ignitionSource `json:"certificateAuthorities"`
+			} `json:"tls"`
+		} `json:"security"`
+	} `json:"ignition"`
+}
+
+// decodeDataURL returns the contents of the given data URL
+func decodeDataURL(dataURL string) ([]byte, error) {
+	if !strings.HasPrefix(dataURL, "data:") {
+		return nil, fmt.Errorf("expected a data URL")
+	}
+	mediaTypeData := strings.SplitN(strings.TrimPrefix(dataURL, "data:"), ",", 2)
+	if len(mediaTypeData) != 2 {
+		return nil, fmt.Errorf("invalid data URL")
+	}
+	if strings.HasSuffix(mediaTypeData[0], ";base64") {
+		return base64.StdEncoding.DecodeString(mediaTypeData[1])
+	}
+	data, err := url.PathUnescape(mediaTypeData[1])
+	return []byte(data), err
+}
+
+// fetchWorkerIgnition returns the worker ignition config served by the machine config server. The location of the
+// machine config server and its CA are taken from the worker stub ignition config.
+func (w *WSU) fetchWorkerIgnition(ctx context.Context) ([]byte, error) {
+	secret, err := w.client.CoreV1().Secrets(userDataNamespace).Get(ctx, workerUserDataSecret, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s secret: %v", workerUserDataSecret, err)
+	}
+	var stub stubIgnition
+	if err = json.Unmarshal(secret.Data["userData"], &stub); err != nil {
+		return nil, fmt.Errorf("error parsing worker stub ignition: %v", err)
+	}
+
+	sources := append(stub.Ignition.Config.Merge, stub.Ignition.Config.Append...)
+	if len(sources) != 1 {
+		return nil, fmt.Errorf("expected one worker ignition source but got %d", len(sources))
+	}
+	rootCAs := x509.NewCertPool()
+	for _, ca := range stub.Ignition.Security.TLS.CertificateAuthorities {
+		caPEM, err := decodeDataURL(ca.Source)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding machine config server CA: %v", err)
+		}
+		if !rootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("invalid machine config server CA")
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sources[0].Source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ignition.AcceptHeader)
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{RootCAs: rootCAs},
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error getting worker ignition: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting worker ignition from %s: %s", sources[0].Source, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
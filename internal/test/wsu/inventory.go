@@ -0,0 +1,67 @@
+package wsu
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// inventoryVars are the variables of the win group of the Ansible inventory the WSU playbook is run with, other than
+// the cluster address
+var inventoryVars = []string{
+	"ansible_connection=winrm",
+	"ansible_ssh_port=5986",
+	"ansible_winrm_server_cert_validation=ignore",
+}
+
+// ClusterAddress returns the cluster address the WSU playbook takes, which is the API server host of the given API
+// server URL without its api. prefix, like cluster.example.com for https://api.cluster.example.com:6443
+func ClusterAddress(apiServerURL string) (string, error) {
+	u, err := url.Parse(apiServerURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid API server URL %s: %v", apiServerURL, err)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("invalid API server URL %s: no host", apiServerURL)
+	}
+	return strings.TrimPrefix(u.Hostname(), "api."), nil
+}
+
+// WriteInventory writes the Ansible inventory the WSU playbook is run with to inventoryPath, with the Windows instances
+// of the given windows-node-installer.json file in the win group and the given cluster address. The inventory holds
+// the passwords of the instances that have one, which the WinRM connection of the playbook requires.
+func WriteInventory(instancesPath, inventoryPath, clusterAddress string) error {
+	instances, err := LoadInstances(instancesPath)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(inventoryPath, []byte(inventory(instances, clusterAddress)), 0600); err != nil {
+		return fmt.Errorf("error writing %s: %v", inventoryPath, err)
+	}
+	return nil
+}
+
+// inventory returns the Ansible inventory in the INI format with the given instances in the win group and the given
+// cluster address
+func inventory(instances []Instance, clusterAddress string) string {
+	var b strings.Builder
+	b.WriteString("[win]\n")
+	for _, instance := range instances {
+		b.WriteString(instance.IPAddress)
+		if instance.Username != "" {
+			b.WriteString(" ansible_user=" + instance.Username)
+		}
+		if instance.Password != "" {
+			// The password is single quoted, with its single quotes escaped, as it can contain spaces
+			b.WriteString(" ansible_password='" + strings.ReplaceAll(instance.Password, "'", `\'`) + "'")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n[win:vars]\n")
+	b.WriteString("cluster_address=" + clusterAddress + "\n")
+	for _, v := range inventoryVars {
+		b.WriteString(v + "\n")
+	}
+	return b.String()
+}
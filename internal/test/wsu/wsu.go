@@ -0,0 +1,472 @@
+package wsu
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	configclient "github.com/openshift/client-go/config/clientset/versioned"
+	"golang.org/x/crypto/ssh"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/clusterinfo"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/credentials"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/csr"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/windows"
+	"github.com/openshift/windows-machine-config-bootstrapper/internal/test/workload"
+)
+
+const (
+	// remoteDir is the directory on the Windows VM the payload is copied to
+	remoteDir = "C:\\Windows\\Temp\\wsu\\"
+	// remoteCNIDir is the directory on the Windows VM the CNI plugins are copied to
+	remoteCNIDir = remoteDir + "cni\\"
+	// wmcbExe is the name of the WMCB executable in the payload
+	wmcbExe = "wmcb.exe"
+	// kubeletExe is the name of the kubelet executable in the payload
+	kubeletExe = "kubelet.exe"
+	// hybridOverlayExe is the name of the hybrid-overlay-node executable in the payload
+	hybridOverlayExe = "hybrid-overlay-node.exe"
+	// cniDirName is the name of the directory in the payload containing the CNI plugins
+	cniDirName = "cni"
+	// ignitionFileName is the name of the worker ignition file copied to the Windows VM
+	ignitionFileName = "worker.ign"
+	// cniConfigFileName is the name of the CNI config file copied to the Windows VM
+	cniConfigFileName = "cni.conf"
+	// remoteKubeletLog is the log file WMCB configures the kubelet to write to on the Windows VM
+	remoteKubeletLog = "C:\\var\\log\\kubelet\\kubelet.log"
+	// pollInterval is the interval the CSRs are approved and the node is checked at
+	pollInterval = 5 * time.Second
+	// DefaultNodeReadyTimeout is the time to wait for the node to be Ready if the config does not specify it
+	DefaultNodeReadyTimeout = 10 * time.Minute
+)
+
+// Instance is an entry of the windows-node-installer.json file, which describes a Windows instance a node is
+// bootstrapped on
+type Instance struct {
+	// InstanceID uniquely identifies the instance
+	InstanceID string `json:"instanceID"`
+	// IPAddress is the address the instance is accessed at
+	IPAddress string `json:"ipAddress"`
+	// Username is the user the instance is accessed as. The default username of the Windows instances created on the
+	// platform of the cluster is used if it is not set.
+	Username string `json:"username,omitempty"`
+	// Password is used in addition to the private key if set, and is required with the WinRM transport
+	Password string `json:"password,omitempty"`
+	// Provider is the tool that created the instance, like gcp-windows, which destroys it from the file
+	Provider string `json:"provider,omitempty"`
+	// Scope identifies where the provider created the instance, like the project and label of the cluster or the
+	// hypervisor, so that the provider only destroys the instances of its own scope
+	Scope string `json:"scope,omitempty"`
+	// CreationTime is the time the instance was created at
+	CreationTime *time.Time `json:"creationTime,omitempty"`
+	// SecurityGroups are the security groups or firewall rules opening the ports of the instance
+	SecurityGroups []string `json:"securityGroups,omitempty"`
+	// KeyPair is the cloud key pair the instance was created with
+	KeyPair string `json:"keyPair,omitempty"`
+}
+
+// instancesFile is the format of the windows-node-installer.json file
+type instancesFile struct {
+	Instances []Instance `json:"instances"`
+}
+
+// LoadInstance returns the instance with the given ID from the given windows-node-installer.json file. If id is empty,
+// the file needs to have exactly one instance.
+func LoadInstance(path, id string) (*Instance, error) {
+	instances, err := LoadInstances(path)
+	if err != nil {
+		return nil, err
+	}
+	file := instancesFile{Instances: instances}
+	if id == "" {
+		if len(file.Instances) != 1 {
+			return nil, fmt.Errorf("expected one instance in %s but got %d, the instance ID needs to be given",
+				path, len(file.Instances))
+		}
+		return &file.Instances[0], nil
+	}
+	for i := range file.Instances {
+		if file.Instances[i].InstanceID == id {
+			return &file.Instances[i], nil
+		}
+	}
+	return nil, fmt.Errorf("instance %s not found in %s", id, path)
+}
+
+// LoadInstances returns the instances of the given windows-node-installer.json file
+func LoadInstances(path string) ([]Instance, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	var file instancesFile
+	if err = json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	return file.Instances, nil
+}
+
+// SaveInstance adds the given instance to the given windows-node-installer.json file, replacing the instance with the
+// same ID if any. The file is created if it does not exist.
+func SaveInstance(path string, instance Instance) error {
+	file, err := readInstancesFile(path)
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range file.Instances {
+		if file.Instances[i].InstanceID == instance.InstanceID {
+			file.Instances[i] = instance
+			replaced = true
+		}
+	}
+	if !replaced {
+		file.Instances = append(file.Instances, instance)
+	}
+	return writeInstancesFile(path, file)
+}
+
+// RemoveInstances removes the instances with the given IDs from the given windows-node-installer.json file
+func RemoveInstances(path string, ids []string) error {
+	file, err := readInstancesFile(path)
+	if err != nil {
+		return err
+	}
+	removed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		removed[id] = true
+	}
+	kept := file.Instances[:0]
+	for _, instance := range file.Instances {
+		if !removed[instance.InstanceID] {
+			kept = append(kept, instance)
+		}
+	}
+	file.Instances = kept
+	return writeInstancesFile(path, file)
+}
+
+// readInstancesFile reads the given windows-node-installer.json file, which is empty if it does not exist
+func readInstancesFile(path string) (*instancesFile, error) {
+	var file instancesFile
+	content, err := ioutil.ReadFile(path)
+	if err == nil {
+		if err = json.Unmarshal(content, &file); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return &file, nil
+}
+
+// writeInstancesFile writes the given windows-node-installer.json file
+func writeInstancesFile(path string, file *instancesFile) error {
+	content, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding %s: %v", path, err)
+	}
+	// The file can hold the passwords of the instances
+	if err = ioutil.WriteFile(path, append(content, '\n'), 0600); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	return nil
+}
+
+// Config holds the inputs of the Windows node bootstrap
+type Config struct {
+	// Kubeconfig is the kubeconfig of the cluster the node joins, which needs to be able to approve CSRs
+	Kubeconfig string
+	// Instance is the Windows instance the node is bootstrapped on
+	Instance Instance
+	// Signer is the private key the instance is accessed with
+	Signer ssh.Signer
+	// Transport is the transport used to access the instance. SSH is used if it is not set.
+	Transport windows.Transport
+	// PayloadDir is the local directory containing wmcb.exe, kubelet.exe, hybrid-overlay-node.exe and the cni
+	// directory with the CNI plugins
+	PayloadDir string
+	// NodeReadyTimeout is the time to wait for the node to be Ready. DefaultNodeReadyTimeout is used if it is not set.
+	NodeReadyTimeout time.Duration
+	// KubeletLog is the writer the kubelet log is streamed to while the node is bootstrapped. The kubelet log is not
+	// streamed if it is nil, or with the WinRM transport.
+	KubeletLog io.Writer
+	// CheckWorkload checks that the node runs workloads once it is Ready, by deploying a Windows web server pod to it
+	// and reaching the pod through a service from a Linux pod
+	CheckWorkload bool
+}
+
+// WSU bootstraps a Windows instance into a Windows node of an OpenShift cluster, doing what the WSU Ansible playbook
+// does: it copies the payload to the instance, runs WMCB on it, approves the CSRs of the node and waits for the node to
+// be Ready. The cluster needs to use the OVNKubernetes network type with the hybrid overlay enabled.
+type WSU struct {
+	// config holds the inputs of the bootstrap
+	config Config
+	// client is the client of the cluster the node joins
+	client kubernetes.Interface
+	// configClient is the OpenShift config client of the cluster the node joins
+	configClient configclient.Interface
+	// vm is the Windows instance the node is bootstrapped on
+	vm *windows.Windows
+}
+
+// New returns a WSU for the given config after checking that the payload is complete
+func New(config Config) (*WSU, error) {
+	for _, name := range []string{wmcbExe, kubeletExe, hybridOverlayExe, cniDirName} {
+		if _, err := os.Stat(filepath.Join(config.PayloadDir, name)); err != nil {
+			return nil, fmt.Errorf("unable to find %s in the payload: %v", name, err)
+		}
+	}
+	if config.Instance.IPAddress == "" {
+		return nil, fmt.Errorf("instance %s has no IP address", config.Instance.InstanceID)
+	}
+	if config.NodeReadyTimeout == 0 {
+		config.NodeReadyTimeout = DefaultNodeReadyTimeout
+	}
+
+	restConfig, err := clientcmd.BuildConfigFromFlags("", config.Kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build config from kubeconfig: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get kube client: %v", err)
+	}
+	configClient, err := configclient.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get OpenShift config client: %v", err)
+	}
+
+	username := config.Instance.Username
+	if username == "" {
+		// The default username depends on the platform the instance was created on, which is the one of the cluster
+		platform, err := clusterinfo.GetPlatformStatus(context.TODO(), configClient)
+		if err != nil {
+			return nil, fmt.Errorf("unable to detect the platform of the cluster: %v", err)
+		}
+		username = credentials.DefaultUsername(platform.Type)
+		log.Printf("detected %s platform, accessing instance %s as %s", platform.Type,
+			config.Instance.InstanceID, username)
+	}
+	vm := &windows.Windows{
+		Credentials: credentials.NewCredentials(config.Instance.InstanceID, config.Instance.IPAddress, username),
+		Transport:   config.Transport,
+	}
+	vm.Credentials.SetSSHKey(config.Signer)
+	vm.Credentials.SetPassword(config.Instance.Password)
+	return &WSU{config: config, client: client, configClient: configClient, vm: vm}, nil
+}
+
+// Run bootstraps the Windows instance into a node and returns once the node is Ready or the context is done
+func (w *WSU) Run(ctx context.Context) error {
+	serviceNetworkCIDR, err := w.serviceNetworkCIDR(ctx)
+	if err != nil {
+		return err
+	}
+	ignition, err := w.fetchWorkerIgnition(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("connecting to instance %s at %s", w.config.Instance.InstanceID, w.config.Instance.IPAddress)
+	if err = w.vm.ConnectContext(ctx); err != nil {
+		return fmt.Errorf("unable to connect to instance %s: %v", w.config.Instance.InstanceID, err)
+	}
+	if err = w.copyPayload(ctx, ignition); err != nil {
+		return err
+	}
+	if w.config.KubeletLog != nil && w.vm.Transport != windows.WinRMTransport {
+		tailCtx, stopTail := context.WithCancel(ctx)
+		defer stopTail()
+		go func() {
+			if err := w.vm.TailFile(tailCtx, remoteKubeletLog, w.config.KubeletLog); err != nil {
+				log.Printf("error streaming kubelet log of instance %s: %v", w.config.Instance.InstanceID, err)
+			}
+		}()
+	}
+	hostname, err := w.vm.RunContext(ctx, "hostname", false)
+	if err != nil {
+		return fmt.Errorf("error getting hostname of instance %s: %v", w.config.Instance.InstanceID, err)
+	}
+	nodeName := strings.ToLower(strings.TrimSpace(hostname))
+
+	// The CSRs of the node need to be approved for the node to join the cluster
+	approver, err := csr.NewApprover(w.client, nodeName)
+	if err != nil {
+		return fmt.Errorf("error creating CSR approver: %v", err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go approver.Run(pollInterval, stopCh)
+
+	log.Printf("initializing kubelet of node %s", nodeName)
+	err = w.runWMCB(ctx, "initialize-kubelet --ignition-file "+remoteDir+ignitionFileName+" --kubelet-path "+
+		remoteDir+kubeletExe, "Bootstrapping completed successfully")
+	if err != nil {
+		return err
+	}
+
+	hostSubnet, err := w.waitForHybridOverlaySubnet(ctx, nodeName)
+	if err != nil {
+		return err
+	}
+	cniConfig, err := CNIConfig(hostSubnet, serviceNetworkCIDR)
+	if err != nil {
+		return err
+	}
+	if err = w.copyContents(ctx, cniConfigFileName, cniConfig); err != nil {
+		return err
+	}
+	log.Printf("configuring CNI of node %s", nodeName)
+	err = w.runWMCB(ctx, "configure-cni --cni-dir "+remoteCNIDir+" --cni-config "+remoteDir+cniConfigFileName+
+		" --hybrid-overlay-path "+remoteDir+hybridOverlayExe+" --node-name "+nodeName,
+		"CNI configuration completed successfully")
+	if err != nil {
+		return err
+	}
+
+	if err = w.waitForNodeReady(ctx, nodeName); err != nil {
+		return err
+	}
+	log.Printf("node %s is Ready", nodeName)
+	if w.config.CheckWorkload {
+		return w.checkWorkload(ctx, nodeName)
+	}
+	return nil
+}
+
+// checkWorkload checks that the node with the given name runs a workload with the Windows Server Core image matching
+// the build of the instance
+func (w *WSU) checkWorkload(ctx context.Context, nodeName string) error {
+	build, err := windows.GetBuildNumber(w.vm)
+	if err != nil {
+		return fmt.Errorf("error checking workload of node %s: %v", nodeName, err)
+	}
+	version, err := windows.LookupServerVersionByBuild(build)
+	if err != nil {
+		return fmt.Errorf("error checking workload of node %s: %v", nodeName, err)
+	}
+	if err = workload.Check(ctx, w.client, nodeName, version.ServerCoreImage); err != nil {
+		return fmt.Errorf("error checking workload of node %s: %v", nodeName, err)
+	}
+	log.Printf("node %s runs workloads", nodeName)
+	return nil
+}
+
+// serviceNetworkCIDR returns the service network CIDR of the cluster, after checking that the cluster uses the
+// OVNKubernetes network type the hybrid overlay is part of
+func (w *WSU) serviceNetworkCIDR(ctx context.Context) (string, error) {
+	network, err := w.configClient.ConfigV1().Networks().Get(ctx, "cluster", metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error getting cluster network object: %v", err)
+	}
+	if network.Status.NetworkType != "OVNKubernetes" {
+		return "", fmt.Errorf("the hybrid overlay requires the OVNKubernetes network type, cluster uses %s",
+			network.Status.NetworkType)
+	}
+	if len(network.Spec.ServiceNetwork) != 1 {
+		return "", fmt.Errorf("expected one service network but got %d", len(network.Spec.ServiceNetwork))
+	}
+	return network.Spec.ServiceNetwork[0], nil
+}
+
+// copyPayload copies the payload and the given worker ignition config to the Windows instance
+func (w *WSU) copyPayload(ctx context.Context, ignition []byte) error {
+	// The CNI plugins in the cni directory of the payload are copied to remoteCNIDir
+	if err := w.vm.CopyDirectoryRecursiveContext(ctx, w.config.PayloadDir, remoteDir); err != nil {
+		return fmt.Errorf("error copying payload to instance %s: %v", w.config.Instance.InstanceID, err)
+	}
+	return w.copyContents(ctx, ignitionFileName, ignition)
+}
+
+// copyContents copies the given contents to the file with the given name in the remote directory
+func (w *WSU) copyContents(ctx context.Context, name string, contents []byte) error {
+	tmpDir, err := ioutil.TempDir("", "wsu")
+	if err != nil {
+		return fmt.Errorf("error creating local temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	localPath := filepath.Join(tmpDir, name)
+	if err = ioutil.WriteFile(localPath, contents, 0600); err != nil {
+		return fmt.Errorf("error writing %s: %v", localPath, err)
+	}
+	if err = w.vm.CopyFileContext(ctx, localPath, remoteDir); err != nil {
+		return fmt.Errorf("error copying %s to instance %s: %v", name, w.config.Instance.InstanceID, err)
+	}
+	return nil
+}
+
+// runWMCB runs WMCB with the given arguments on the Windows instance, and returns an error if it exits with a non zero
+// exit code or its output does not contain the given success message
+func (w *WSU) runWMCB(ctx context.Context, args, successMessage string) error {
+	result, err := w.vm.RunCommand(ctx, windows.Command{Command: remoteDir + wmcbExe + " " + args})
+	if err != nil {
+		return fmt.Errorf("error running wmcb %s: %v", args, err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("wmcb %s exited with code %d: %s%s", args, result.ExitCode, result.Stdout, result.Stderr)
+	}
+	if !strings.Contains(result.Stdout, successMessage) {
+		return fmt.Errorf("wmcb %s failed: %s%s", args, result.Stdout, result.Stderr)
+	}
+	return nil
+}
+
+// waitForHybridOverlaySubnet waits for the node to be registered with the hybrid overlay subnet annotation, which is
+// set by OVNKubernetes, and returns the subnet
+func (w *WSU) waitForHybridOverlaySubnet(ctx context.Context, nodeName string) (string, error) {
+	var subnet string
+	err := w.pollNode(ctx, nodeName, func(node *v1.Node) bool {
+		subnet = node.Annotations[test.HybridOverlaySubnet]
+		return subnet != ""
+	})
+	if err != nil {
+		return "", fmt.Errorf("error waiting for %s annotation on node %s: %v", test.HybridOverlaySubnet, nodeName,
+			err)
+	}
+	return subnet, nil
+}
+
+// waitForNodeReady waits for the node to be Ready
+func (w *WSU) waitForNodeReady(ctx context.Context, nodeName string) error {
+	err := w.pollNode(ctx, nodeName, func(node *v1.Node) bool {
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == v1.NodeReady {
+				return condition.Status == v1.ConditionTrue
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return fmt.Errorf("error waiting for node %s to be Ready: %v", nodeName, err)
+	}
+	return nil
+}
+
+// pollNode polls the node until the given condition is met, the node ready timeout is reached or the context is done.
+// The node not being registered yet is not an error.
+func (w *WSU) pollNode(ctx context.Context, nodeName string, condition func(*v1.Node) bool) error {
+	ctx, cancel := context.WithTimeout(ctx, w.config.NodeReadyTimeout)
+	defer cancel()
+	return wait.PollImmediateUntil(pollInterval, func() (bool, error) {
+		node, err := w.client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			log.Printf("error getting node %s: %v", nodeName, err)
+			return false, nil
+		}
+		return condition(node), nil
+	}, ctx.Done())
+}
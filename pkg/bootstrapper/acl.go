@@ -0,0 +1,175 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// restrictedDirSDDL is the DACL of the install directory, which only grants access to SYSTEM and the
+	// Administrators, and is inherited by its files and subdirectories. The DACL is protected from the inheritable
+	// entries of the parent directory, which grant read access to the Users on C:\.
+	restrictedDirSDDL = "D:PAI(A;OICI;FA;;;SY)(A;OICI;FA;;;BA)"
+	// restrictedFileSDDL is the DACL of the credentials, which only grants access to SYSTEM and the Administrators on
+	// top of the entries inherited from the restricted install directory, so that the services run as a service
+	// account can read them
+	restrictedFileSDDL = "D:AI(A;;FA;;;SY)(A;;FA;;;BA)"
+	// serviceSIDPrefix is the prefix of the SIDs of the Windows services, which are granted access to the directories
+	// of the services run as a service account
+	serviceSIDPrefix = "S-1-5-80-"
+)
+
+var (
+	// aceRegex matches the access control entries of a DACL in the SDDL format, capturing their fields
+	aceRegex = regexp.MustCompile(`\(([^()]*)\)`)
+	// restrictedPrincipals are the SIDs, in the SDDL format, of the principals allowed to access the install directory
+	// and the credentials
+	restrictedPrincipals = []string{"SY", "BA", "S-1-5-18", "S-1-5-32-544"}
+)
+
+// restrictedPaths returns the install directory along with the credentials the kubelet is given, which are only
+// accessible by SYSTEM and the Administrators once restricted
+func (wmcb *winNodeBootstrapper) restrictedPaths() []string {
+	paths := []string{wmcb.installDir, filepath.Join(wmcb.installDir, bootstrapKubeconfigName),
+		filepath.Join(wmcb.installDir, kubeletCAName), filepath.Join(wmcb.installDir, cloudConfigSecretsName)}
+	if cloudConfig, ok := wmcb.kubeletArgs[cloudConfigOption]; ok {
+		paths = append(paths, cloudConfig)
+	}
+	return paths
+}
+
+// restrictAccess restricts the access to the install directory and the credentials to SYSTEM and the Administrators,
+// replacing the permissions they inherited. The access previously granted to other principals is logged. The services
+// run as a service account keep the access granted to them, and the credentials that are not present are skipped. The
+// changes are only recorded in dry-run mode.
+func (wmcb *winNodeBootstrapper) restrictAccess() error {
+	for i, path := range wmcb.restrictedPaths() {
+		if wmcb.dryRun != nil {
+			wmcb.dryRun.addAction("restrict access to %s to SYSTEM and the Administrators", path)
+			continue
+		}
+		if _, err := wmcb.fileSystem().Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		sddl, err := daclSDDL(path)
+		if err != nil {
+			return err
+		}
+		if entries := permissiveEntries(sddl); len(entries) > 0 {
+			wmcb.log.Info("restricting permissive ACL", "path", path, "entries", entries)
+		}
+
+		if i == 0 {
+			err = setDACL(path, restrictedDirSDDL+strings.Join(serviceEntries(sddl), ""), true)
+		} else {
+			err = setDACL(path, restrictedFileSDDL, false)
+		}
+		if err != nil {
+			return fmt.Errorf("error restricting access to %s: %w", path, err)
+		}
+		// The DACL is read back, as a DACL that cannot be applied as a whole would leave the path accessible
+		if sddl, err = daclSDDL(path); err != nil {
+			return err
+		}
+		if entries := permissiveEntries(sddl); len(entries) > 0 {
+			return fmt.Errorf("%s is still accessible through %s", path, strings.Join(entries, ""))
+		}
+	}
+	return nil
+}
+
+// checkACLs returns an error listing the access control entries of the install directory and the credentials that
+// grant access to principals other than SYSTEM and the Administrators. The paths that are not present are skipped.
+func (wmcb *winNodeBootstrapper) checkACLs() error {
+	var permissive []string
+	for _, path := range wmcb.restrictedPaths() {
+		if _, err := wmcb.fileSystem().Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		sddl, err := daclSDDL(path)
+		if err != nil {
+			return err
+		}
+		if entries := permissiveEntries(sddl); len(entries) > 0 {
+			permissive = append(permissive, path+" grants "+strings.Join(entries, ""))
+		}
+	}
+	if len(permissive) > 0 {
+		return fmt.Errorf("%s", strings.Join(permissive, ", "))
+	}
+	return nil
+}
+
+// accessEntry is an access control entry of a DACL
+type accessEntry struct {
+	// aceType is the type of the entry, like A for the entries allowing access
+	aceType string
+	// flags are the inheritance flags of the entry, like ID for the inherited entries
+	flags string
+	// principal is the SID the entry applies to, or its SDDL alias
+	principal string
+	// sddl is the entry in the SDDL format
+	sddl string
+}
+
+// allows returns true if the entry grants access, rather than denying or auditing it
+func (e accessEntry) allows() bool {
+	// The entries allowing access are of the A type, or of its object, callback and callback object variants
+	return e.aceType == "A" || e.aceType == "OA" || e.aceType == "XA" || e.aceType == "ZA"
+}
+
+// service returns true if the entry applies to a Windows service
+func (e accessEntry) service() bool {
+	return strings.HasPrefix(e.principal, serviceSIDPrefix)
+}
+
+// accessEntries returns the access control entries of the given DACL in the SDDL format
+func accessEntries(sddl string) []accessEntry {
+	var entries []accessEntry
+	for _, match := range aceRegex.FindAllStringSubmatch(sddl, -1) {
+		// The fields are the type, flags, rights, object GUID, inherited object GUID and principal of the entry
+		fields := strings.Split(match[1], ";")
+		if len(fields) < 6 {
+			continue
+		}
+		entries = append(entries, accessEntry{aceType: fields[0], flags: fields[1], principal: fields[5],
+			sddl: match[0]})
+	}
+	return entries
+}
+
+// permissiveEntries returns the access control entries of the given DACL in the SDDL format that grant access to
+// principals other than SYSTEM, the Administrators and the Windows services
+func permissiveEntries(sddl string) []string {
+	var permissive []string
+	for _, entry := range accessEntries(sddl) {
+		if !entry.allows() || entry.service() {
+			continue
+		}
+		restricted := false
+		for _, principal := range restrictedPrincipals {
+			if strings.EqualFold(entry.principal, principal) {
+				restricted = true
+			}
+		}
+		if !restricted {
+			permissive = append(permissive, entry.sddl)
+		}
+	}
+	return permissive
+}
+
+// serviceEntries returns the access control entries of the given DACL in the SDDL format that grant access to the
+// Windows services and are not inherited
+func serviceEntries(sddl string) []string {
+	var entries []string
+	for _, entry := range accessEntries(sddl) {
+		if entry.allows() && entry.service() && !strings.Contains(entry.flags, "ID") {
+			entries = append(entries, entry.sddl)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package bootstrapper
+
+// daclSDDL returns errUnsupportedPlatform as there are no Windows ACLs
+func daclSDDL(path string) (string, error) {
+	return "", errUnsupportedPlatform
+}
+
+// setDACL returns errUnsupportedPlatform as there are no Windows ACLs
+func setDACL(path, sddl string, protected bool) error {
+	return errUnsupportedPlatform
+}
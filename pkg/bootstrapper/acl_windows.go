@@ -0,0 +1,36 @@
+package bootstrapper
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// daclSDDL returns the DACL of the given file or directory in the SDDL format
+func daclSDDL(path string) (string, error) {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return "", fmt.Errorf("error getting ACL of %s: %w", path, err)
+	}
+	return sd.String(), nil
+}
+
+// setDACL replaces the DACL of the given file or directory with the given DACL in the SDDL format, which is protected
+// from the inheritable entries of the parent directory if protected is true. The DACL is propagated to the files and
+// subdirectories that inherit it.
+func setDACL(path, sddl string, protected bool) error {
+	sd, err := windows.SecurityDescriptorFromString(sddl)
+	if err != nil {
+		return fmt.Errorf("invalid DACL %s: %w", sddl, err)
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	var protection windows.SECURITY_INFORMATION = windows.UNPROTECTED_DACL_SECURITY_INFORMATION
+	if protected {
+		protection = windows.PROTECTED_DACL_SECURITY_INFORMATION
+	}
+	return windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION|protection,
+		nil, nil, dacl, nil)
+}
@@ -0,0 +1,217 @@
+package bootstrapper
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// artifactsManifestName is the name of the manifest in the artifacts dir that lists the SHA256 checksum of every
+	// artifact, in the format generated by sha256sum
+	artifactsManifestName = "SHA256SUMS"
+	// kubeletArtifact is the path of the kubelet executable within the artifacts dir
+	kubeletArtifact = "kubelet.exe"
+	// cniArtifactsDir is the path of the directory containing the CNI plugins within the artifacts dir
+	cniArtifactsDir = "cni"
+	// containerdArtifactsDir is the path of the directory containing the containerd binaries within the artifacts dir
+	containerdArtifactsDir = "containerd"
+	// hybridOverlayArtifact is the path of the hybrid-overlay-node executable within the artifacts dir
+	hybridOverlayArtifact = "hybrid-overlay-node.exe"
+	// pauseImageArtifact is the path of the pause image tarball within the artifacts dir
+	pauseImageArtifact = "pause.tar"
+)
+
+// Artifacts is a local bundle of the binaries and images required to bootstrap a Windows node without network access.
+// Every artifact in the bundle is verified against the checksums in the manifest of the bundle.
+type Artifacts struct {
+	// dir is the directory containing the artifacts and the manifest
+	dir string
+	// checksums maps the paths of the artifacts, relative to dir, to their SHA256 checksums
+	checksums map[string]string
+}
+
+// NewArtifacts returns the artifacts bundle in the given directory after verifying the checksum of every artifact
+// listed in the manifest, and that every artifact in the bundle is listed in the manifest. The bundle needs to
+// contain at least the kubelet.
+func NewArtifacts(dir string) (*Artifacts, error) {
+	return newArtifacts(osFileSystem, dir)
+}
+
+// newArtifacts returns the verified artifacts bundle in the given directory of the given file system
+func newArtifacts(fs FileSystem, dir string) (*Artifacts, error) {
+	checksums, err := parseArtifactsManifest(fs, filepath.Join(dir, artifactsManifestName))
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := checksums[kubeletArtifact]; !ok {
+		return nil, fmt.Errorf("%s is not listed in the artifacts manifest", kubeletArtifact)
+	}
+
+	err = walk(fs, dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if relPath == artifactsManifestName {
+			return nil
+		}
+		if _, ok := checksums[relPath]; !ok {
+			return fmt.Errorf("%s is not listed in the artifacts manifest", relPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error verifying artifacts: %v", err)
+	}
+
+	for relPath, checksum := range checksums {
+		path := filepath.Join(dir, filepath.FromSlash(relPath))
+		actual, err := sha256File(fs, path)
+		if err != nil {
+			return nil, fmt.Errorf("error verifying %s: %v", relPath, err)
+		}
+		if actual != checksum {
+			return nil, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", relPath, checksum, actual)
+		}
+	}
+	return &Artifacts{dir: dir, checksums: checksums}, nil
+}
+
+// parseArtifactsManifest parses the given sha256sum formatted manifest of the given file system into a map of the relative artifact paths to
+// their checksums
+func parseArtifactsManifest(fs FileSystem, manifestPath string) (map[string]string, error) {
+	manifest, err := fs.Open(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening artifacts manifest: %v", err)
+	}
+	defer manifest.Close()
+
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(manifest)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			return nil, fmt.Errorf("invalid artifacts manifest entry %q", line)
+		}
+		// sha256sum prefixes the path with a * in binary mode
+		relPath := filepath.ToSlash(filepath.Clean(strings.TrimPrefix(fields[1], "*")))
+		relPath = strings.TrimPrefix(relPath, "./")
+		if filepath.IsAbs(relPath) || relPath == ".." || strings.HasPrefix(relPath, "../") {
+			return nil, fmt.Errorf("artifact %s is outside the artifacts dir", fields[1])
+		}
+		checksums[relPath] = strings.ToLower(fields[0])
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading artifacts manifest: %v", err)
+	}
+	return checksums, nil
+}
+
+// sha256File returns the hex encoded SHA256 checksum of the given file of the given file system
+func sha256File(fs FileSystem, path string) (string, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// path returns the path of the given artifact if it is part of the bundle, else an empty string
+func (a *Artifacts) path(artifact string) string {
+	if _, ok := a.checksums[artifact]; !ok {
+		return ""
+	}
+	return filepath.Join(a.dir, filepath.FromSlash(artifact))
+}
+
+// dirPath returns the path of the given directory of artifacts if it has any artifacts, else an empty string
+func (a *Artifacts) dirPath(dir string) string {
+	for artifact := range a.checksums {
+		if strings.HasPrefix(artifact, dir+"/") {
+			return filepath.Join(a.dir, dir)
+		}
+	}
+	return ""
+}
+
+// KubeletPath returns the path of the kubelet executable in the bundle
+func (a *Artifacts) KubeletPath() string {
+	return a.path(kubeletArtifact)
+}
+
+// CNIDir returns the path of the directory containing the CNI plugins, or an empty string if the bundle does not
+// have any CNI plugins
+func (a *Artifacts) CNIDir() string {
+	return a.dirPath(cniArtifactsDir)
+}
+
+// ContainerdDir returns the path of the directory containing the containerd binaries, or an empty string if the
+// bundle does not have containerd
+func (a *Artifacts) ContainerdDir() string {
+	return a.dirPath(containerdArtifactsDir)
+}
+
+// HybridOverlayPath returns the path of the hybrid-overlay-node executable, or an empty string if the bundle does not
+// have the hybrid-overlay-node
+func (a *Artifacts) HybridOverlayPath() string {
+	return a.path(hybridOverlayArtifact)
+}
+
+// PauseImagePath returns the path of the pause image tarball, or an empty string if the bundle does not have the
+// pause image
+func (a *Artifacts) PauseImagePath() string {
+	return a.path(pauseImageArtifact)
+}
+
+// SetPauseImageArchive sets the tarball the pause image is loaded from into the container runtime, so that the
+// kubelet does not need to pull it. This needs to be called before InitializeKubelet for the image to be loaded.
+func (wmcb *winNodeBootstrapper) SetPauseImageArchive(path string) error {
+	if _, err := wmcb.fileSystem().Stat(path); err != nil {
+		return fmt.Errorf("unable to find pause image archive at %s: %v", path, err)
+	}
+	wmcb.pauseImageArchive = path
+	return nil
+}
+
+// loadPauseImage loads the pause image from the pause image archive into the container runtime. This assumes that the
+// container runtime is running. The load is aborted if the context is done.
+func (wmcb *winNodeBootstrapper) loadPauseImage(ctx context.Context) error {
+	var cmd *exec.Cmd
+	if wmcb.containerRuntime == containerdRuntime {
+		// The images used by the kubelet are in the k8s.io namespace
+		cmd = exec.CommandContext(ctx, filepath.Join(wmcb.containerdInstallDir(), "ctr.exe"), "--address",
+			containerdPipeAddress, "--namespace", "k8s.io", "images", "import", wmcb.pauseImageArchive)
+	} else {
+		cmd = exec.CommandContext(ctx, "docker", "load", "--input", wmcb.pauseImageArchive)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error loading pause image from %s: %v: %s", wmcb.pauseImageArchive, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package bootstrapper
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// bootstrapKubeconfigName is the name of the bootstrap kubeconfig in the install dir
+	bootstrapKubeconfigName = "bootstrap-kubeconfig"
+	// kubeletCAName is the name of the CA in the install dir the kubelet authenticates client certificates with
+	kubeletCAName = "kubelet-ca.crt"
+)
+
+// bootstrapCredentials holds the inputs of the bootstrap kubeconfig generated by WMCB
+type bootstrapCredentials struct {
+	// apiServer is the URL of the API server
+	apiServer string
+	// caBundle is the PEM encoded CA bundle the API server serving certificate is verified with
+	caBundle []byte
+	// token is the bootstrap or service account token the kubelet authenticates with to request its client
+	// certificate
+	token string
+	// kubeletCA is the PEM encoded CA the kubelet authenticates the client certificates of the API server with
+	kubeletCA []byte
+}
+
+// kubeconfig is the subset of a kubeconfig required for the kubelet to bootstrap with a token
+type kubeconfig struct {
+	APIVersion     string                 `json:"apiVersion"`
+	Kind           string                 `json:"kind"`
+	Clusters       []kubeconfigCluster    `json:"clusters"`
+	Users          []kubeconfigUser       `json:"users"`
+	Contexts       []kubeconfigContext    `json:"contexts"`
+	CurrentContext string                 `json:"current-context"`
+	Preferences    map[string]interface{} `json:"preferences"`
+}
+
+// kubeconfigCluster is a named cluster of a kubeconfig
+type kubeconfigCluster struct {
+	Name    string `json:"name"`
+	Cluster struct {
+		Server                   string `json:"server"`
+		CertificateAuthorityData []byte `json:"certificate-authority-data"`
+	} `json:"cluster"`
+}
+
+// kubeconfigUser is a named user of a kubeconfig
+type kubeconfigUser struct {
+	Name string `json:"name"`
+	User struct {
+		Token string `json:"token"`
+	} `json:"user"`
+}
+
+// kubeconfigContext is a named context of a kubeconfig
+type kubeconfigContext struct {
+	Name    string `json:"name"`
+	Context struct {
+		Cluster string `json:"cluster"`
+		User    string `json:"user"`
+	} `json:"context"`
+}
+
+// readCABundle reads the PEM encoded certificates from the given file of the given file system
+func readCABundle(fs FileSystem, path string) ([]byte, error) {
+	caBundle, err := readFile(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(caBundle) {
+		return nil, fmt.Errorf("%s does not contain any PEM encoded certificates", path)
+	}
+	return caBundle, nil
+}
+
+// SetBootstrapToken sets the API server URL, the CA bundle of the API server and the token the bootstrap kubeconfig
+// is generated with, instead of taking it from the ignition file. This allows bootstrapping nodes that cannot reach
+// the machine config server. The token can be a bootstrap token or the token of a service account that is allowed to
+// create node CSRs. kubeletCAPath is the CA the kubelet authenticates the client certificates of the API server with,
+// for example for logs and exec; the CA bundle is used if it is empty. This needs to be called before
+// InitializeKubelet to take effect.
+func (wmcb *winNodeBootstrapper) SetBootstrapToken(apiServerURL, caBundlePath, token, kubeletCAPath string) error {
+	apiServer, err := url.Parse(apiServerURL)
+	if err != nil {
+		return fmt.Errorf("invalid API server URL %s: %v", apiServerURL, err)
+	}
+	if apiServer.Scheme != "https" || apiServer.Host == "" {
+		return fmt.Errorf("invalid API server URL %s: expected an HTTPS URL", apiServerURL)
+	}
+	if token == "" || strings.ContainsAny(token, " \t\r\n") {
+		return fmt.Errorf("invalid bootstrap token: expected a non empty token without whitespace")
+	}
+	caBundle, err := readCABundle(wmcb.fileSystem(), caBundlePath)
+	if err != nil {
+		return fmt.Errorf("invalid CA bundle: %v", err)
+	}
+	kubeletCA := caBundle
+	if kubeletCAPath != "" {
+		if kubeletCA, err = readCABundle(wmcb.fileSystem(), kubeletCAPath); err != nil {
+			return fmt.Errorf("invalid kubelet CA: %v", err)
+		}
+	}
+
+	wmcb.bootstrapCredentials = &bootstrapCredentials{
+		apiServer: apiServerURL,
+		caBundle:  caBundle,
+		token:     token,
+		kubeletCA: kubeletCA,
+	}
+	return nil
+}
+
+// createBootstrapKubeconfig returns the bootstrap kubeconfig generated from the bootstrap credentials
+func (wmcb *winNodeBootstrapper) createBootstrapKubeconfig() ([]byte, error) {
+	const name = "bootstrap"
+	config := kubeconfig{
+		APIVersion:     "v1",
+		Kind:           "Config",
+		CurrentContext: name,
+		Preferences:    map[string]interface{}{},
+	}
+	cluster := kubeconfigCluster{Name: name}
+	cluster.Cluster.Server = wmcb.bootstrapCredentials.apiServer
+	cluster.Cluster.CertificateAuthorityData = wmcb.bootstrapCredentials.caBundle
+	user := kubeconfigUser{Name: name}
+	user.User.Token = wmcb.bootstrapCredentials.token
+	kubeContext := kubeconfigContext{Name: name}
+	kubeContext.Context.Cluster = name
+	kubeContext.Context.User = name
+	config.Clusters = []kubeconfigCluster{cluster}
+	config.Users = []kubeconfigUser{user}
+	config.Contexts = []kubeconfigContext{kubeContext}
+	return json.Marshal(config)
+}
+
+// writeBootstrapCredentials writes the bootstrap kubeconfig and the kubelet CA generated from the bootstrap credentials
+// to the install dir
+func (wmcb *winNodeBootstrapper) writeBootstrapCredentials() error {
+	kubeconfig, err := wmcb.createBootstrapKubeconfig()
+	if err != nil {
+		return fmt.Errorf("error generating bootstrap kubeconfig: %v", err)
+	}
+	if err = wmcb.writeKubeletFile(filepath.Join(wmcb.installDir, bootstrapKubeconfigName), kubeconfig); err != nil {
+		return fmt.Errorf("could not write bootstrap kubeconfig: %v", err)
+	}
+	err = wmcb.writeKubeletFile(filepath.Join(wmcb.installDir, kubeletCAName), wmcb.bootstrapCredentials.kubeletCA)
+	if err != nil {
+		return fmt.Errorf("could not write kubelet CA: %v", err)
+	}
+	return nil
+}
@@ -1,12 +1,16 @@
 package bootstrapper
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"text/template"
 	"time"
@@ -14,12 +18,16 @@ import (
 	ignitionCfgv24tov31 "github.com/coreos/ign-converter/translate/v24tov31"
 	ignitionCfgv2_4 "github.com/coreos/ignition/config/v2_4"
 	ignitionCfgv2_4Types "github.com/coreos/ignition/config/v2_4/types"
-	ignitionCfgError "github.com/coreos/ignition/v2/config/shared/errors"
+	ignitionCfgv3_0 "github.com/coreos/ignition/v2/config/v3_0"
 	ignitionCfgv3 "github.com/coreos/ignition/v2/config/v3_1"
+	ignitionCfgv30tov31 "github.com/coreos/ignition/v2/config/v3_1/translate"
 	ignitionCfgv3Types "github.com/coreos/ignition/v2/config/v3_1/types"
+	"github.com/go-logr/logr"
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/hns"
 	"github.com/pkg/errors"
 	"github.com/vincent-petithory/dataurl"
-	"golang.org/x/sys/windows/svc/mgr"
+	"k8s.io/apimachinery/pkg/util/clock"
+	logger "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 /*
@@ -41,7 +49,8 @@ const (
 	// kubeletSystemdName is the name of the systemd service that the kubelet runs under,
 	// this is used to parse the kubelet args
 	kubeletSystemdName = "kubelet.service"
-	// kubeletPauseContainerImage is the location of the image we will use for the kubelet pause container
+	// kubeletPauseContainerImage is the location of the image we will use for the kubelet pause container, unless a
+	// different image is required by the Windows build
 	kubeletPauseContainerImage = "mcr.microsoft.com/oss/kubernetes/pause:3.4.1"
 	// serviceWaitTime is amount of wait time required for the Windows service API to complete stop requests
 	serviceWaitTime = time.Second * 20
@@ -91,6 +100,11 @@ const (
 	cniBinDirOption = "--cni-bin-dir"
 	// cniConfDirOption is to specify the CNI conf directory
 	cniConfDirOption = "--cni-conf-dir"
+
+	// containerLogsPortRuleName is the display name of the firewall rule that opens the kubelet port on the Windows node
+	containerLogsPortRuleName = "ContainerLogsPort"
+	// powerShellExe is the PowerShell executable used to run commands on the Windows node
+	powerShellExe = "powershell.exe"
 )
 
 // These regex are global, so that we only need to compile them once
@@ -115,13 +129,16 @@ type winNodeBootstrapper struct {
 	// ignitionFilePath is the path to the ignition file which is used to set up worker nodes
 	// https://github.com/coreos/ignition/blob/spec2x/doc/getting-started.md
 	ignitionFilePath string
+	// ignitionEndpoint is the machine config server endpoint the worker ignition is fetched from instead of the
+	// ignition file, if it has been set using SetIgnitionURL
+	ignitionEndpoint *ignitionEndpoint
 	//initialKubeletPath is the path to the kubelet that we'll be using to bootstrap this node
 	initialKubeletPath string
 	// TODO: When more services are added consider decomposing the services to a separate Service struct with common functions
 	// kubeletSVC is a pointer to the kubeletService struct
 	kubeletSVC *kubeletService
 	// svcMgr is used to interact with the Windows service API
-	svcMgr *mgr.Mgr
+	svcMgr serviceManager
 	// installDir is the directory the the kubelet service will be installed
 	installDir string
 	// logDir is the directory that captures log outputs of Kubelet
@@ -131,6 +148,102 @@ type winNodeBootstrapper struct {
 	kubeletArgs map[string]string
 	// cni holds all the CNI specific information
 	cni *cniOptions
+	// containerRuntime is the container runtime the kubelet is configured to use
+	containerRuntime string
+	// containerdDir is the input dir where the containerd binaries are present
+	containerdDir string
+	// kubeletArgOverrides is a map of user provided kubelet arguments that take precedence over the arguments WMCB
+	// passes to the kubelet
+	kubeletArgOverrides map[string]string
+	// kubeletConfigOverrides holds the user provided KubeletConfiguration fields that are merged into the generated
+	// kubelet configuration
+	kubeletConfigOverrides map[string]interface{}
+	// nodeLabels are the user provided labels the node is registered with, in addition to the Windows node label
+	nodeLabels map[string]string
+	// nodeTaints are the user provided taints the node is registered with, in addition to the Windows taint
+	nodeTaints []string
+	// credentialProviders are the paths of the image credential provider plugins the kubelet is configured with
+	credentialProviders []string
+	// gmsa holds the options of the GMSA support. GMSA is not supported if it is nil.
+	gmsa *gmsaOptions
+	// dns holds the DNS settings of the kubelet and the Windows node. The defaults are used if it is nil.
+	dns *DNSOptions
+	// ignitionFiles are the names of the allowlisted files extracted from the ignition file in addition to the kubelet
+	// files
+	ignitionFiles []string
+	// timeServers are the NTP servers the clock of the node is synchronized with, taking precedence over
+	// chronyTimeServers
+	timeServers []string
+	// chronyTimeServers are the NTP servers of the chrony configuration of the ignition file. It is populated only if
+	// the chrony configuration is extracted from the ignition file.
+	chronyTimeServers []string
+	// hybridOverlay holds the hybrid-overlay-node specific information. It is populated only if the hybrid overlay
+	// has been enabled.
+	hybridOverlay *hybridOverlayOptions
+	// hnsNetwork describes the HNS network the CNI config is attached to. It is populated only if the network is
+	// managed by WMCB instead of being created by someone else.
+	hnsNetwork *hns.NetworkConfig
+	// certDir is the directory in which the kubelet stores its client and serving certificates
+	certDir string
+	// kubeletRestartRequired is set when a file the kubelet reads on start up has been modified, so that the kubelet
+	// is restarted only if its configuration has changed
+	kubeletRestartRequired bool
+	// phaseStart is the time at which the bootstrap phase that is being run started, which its duration is recorded
+	// from in the status file
+	phaseStart time.Time
+	// proxy holds the proxy settings the kubelet and the container runtime are configured with. Settings that have not
+	// been set using SetProxy are taken from the ignition file.
+	proxy proxyConfig
+	// windowsBuild holds the defaults of the Windows build the bootstrapper is running on. It is populated once the
+	// build has been detected.
+	windowsBuild *windowsBuild
+	// pauseImageArchive is the tarball the pause image is loaded from into the container runtime. The pause image is
+	// pulled by the kubelet if it is not set.
+	pauseImageArchive string
+	// bootstrapCredentials are the credentials the bootstrap kubeconfig is generated with. The bootstrap kubeconfig
+	// and the kubelet CA are taken from the ignition file if they are not set.
+	bootstrapCredentials *bootstrapCredentials
+	// dryRun records the changes that would be made to the Windows node instead of making them. It is populated only
+	// in dry-run mode.
+	dryRun *DryRunPlan
+	// journal records the changes made to the Windows node, so that they can be rolled back if the bootstrap fails. It
+	// is nil outside of InitializeKubelet, Configure and ConfigureKubeProxy, and in dry-run mode.
+	journal *journal
+	// hooks are the hooks registered by library consumers, by the point of the bootstrap they are run at
+	hooks map[HookPoint][]HookFunc
+	// serviceRecovery holds the settings the SCM uses to recover the Windows services created by WMCB when they fail
+	serviceRecovery serviceRecovery
+	// serviceAccount is the account the kubelet and kube-proxy services are run as, either VirtualServiceAccount or a
+	// group managed service account. The services are run as LocalSystem if it is empty.
+	serviceAccount string
+	// cloudConfigManagedIdentity indicates that the Azure cloud config is rewritten to use the managed identity of the
+	// VM, with the user assigned identity with the userAssignedIdentityID ID if it is not empty
+	cloudConfigManagedIdentity bool
+	userAssignedIdentityID     string
+	// skipVersionCheck disables the validation of the kubelet version against the supported versions and the version
+	// of the API server
+	skipVersionCheck bool
+	// log is the logger used by the bootstrapper. It defaults to the controller-runtime logger and can be replaced by
+	// library consumers using SetLogger.
+	log logr.Logger
+	// clock is the clock the bootstrap phases are timed and the kubelet certificates are checked with. The system
+	// clock is used if it is nil.
+	clock clock.PassiveClock
+	// fs is the file system the files of the node are read and written through. The file system of the host is used if
+	// it is nil.
+	fs FileSystem
+	// eviction holds the eviction and image GC thresholds of the kubelet. The Windows defaults are used if it is nil.
+	eviction *EvictionOptions
+	// nodeIP holds the user provided inputs the IP address of the node is selected with
+	nodeIP nodeIPOptions
+	// nodeIPArg is the IP address the kubelet registers the node with. The kubelet picks the address if it is empty.
+	nodeIPArg string
+	// externalCloudProvider indicates that the kubelet is run with --cloud-provider=external whatever the cloud
+	// provider of the ignition file
+	externalCloudProvider bool
+	// cloudNodeManager holds the options of the cloud node manager Windows service. The service is not installed if it
+	// is nil.
+	cloudNodeManager *cloudNodeManagerOptions
 }
 
 // cniOptions is responsible for reconfiguring the kubelet service with CNI configuration
@@ -139,49 +252,112 @@ type cniOptions struct {
 	k8sInstallDir string
 	// dir is the input dir where the CNI binaries are present
 	dir string
-	// config is the input CNI configuration file
+	// config is the input CNI configuration file, or the directory of CNI configuration files
 	config string
-	// binDir is the directory where the CNI binaries will be placed
-	binDir string
-	// confDir is the directory where the CNI config will be placed
-	confDir string
+	// layout is the location of the CNI binaries and configs in the install directory for the container runtime
+	layout cniLayout
+	// networkName is the name of the HNS network the CNI config is set to use. The name in the input CNI config is
+	// used if it is empty.
+	networkName string
+	// values holds the cluster specific values substituted into the CNI config templates
+	values cniConfigValues
+	// journal records the files written and removed, so that they can be rolled back. Nothing is recorded if it is
+	// nil.
+	journal *journal
+	// resolvConf is the resolv.conf the kubelet passes to the pods. An empty file is passed if it is empty.
+	resolvConf string
+	// fs is the file system the CNI files are read and written through. The file system of the host is used if it is
+	// nil.
+	fs FileSystem
 }
 
 // NewWinNodeBootstrapper takes the dir to install the kubelet to, and paths to the ignition and kubelet files along
 // with the CNI options as inputs, and generates the winNodeBootstrapper object. The CNI options are populated only in
 // the configure-cni command. The inputs to NewWinNodeBootstrapper are ignored while using the uninstall kubelet functionality.
+// The bootstrapper uses the docker runtime, the container runtime is selected with the WithContainerRuntime option of
+// New.
+//
+// Deprecated: use New or NewFromOptions, which take the inputs by name.
 func NewWinNodeBootstrapper(k8sInstallDir, ignitionFile, kubeletPath string, cniDir string,
 	cniConfig string) (*winNodeBootstrapper, error) {
+	return NewFromOptions(Options{
+		InstallDir:   k8sInstallDir,
+		IgnitionFile: ignitionFile,
+		KubeletPath:  kubeletPath,
+		CNIDir:       cniDir,
+		CNIConfig:    cniConfig,
+	})
+}
+
+// NewFromOptions returns a bootstrapper created with the given options. An *OptionError is returned if an option is
+// invalid. The bootstrapper is connected to the Windows SCM until Disconnect is called.
+func NewFromOptions(options Options) (*WinNodeBootstrapper, error) {
 	// Check if cniDir or cniConfig is empty when the other is not
-	if (cniDir == "" && cniConfig != "") || (cniDir != "" && cniConfig == "") {
-		return nil, fmt.Errorf("both cniDir and cniConfig need to be populated")
+	if (options.CNIDir == "") != (options.CNIConfig == "") {
+		return nil, &OptionError{Option: "CNIDir", Err: fmt.Errorf("both cniDir and cniConfig need to be populated")}
+	}
+
+	switch options.ContainerRuntime {
+	case "":
+		options.ContainerRuntime = dockerRuntime
+	case dockerRuntime, containerdRuntime:
+	default:
+		return nil, &OptionError{Option: "ContainerRuntime",
+			Err: fmt.Errorf("unsupported container runtime %s", options.ContainerRuntime)}
+	}
+	if options.ContainerdDir != "" && options.ContainerRuntime != containerdRuntime {
+		return nil, &OptionError{Option: "ContainerdDir",
+			Err: fmt.Errorf("containerdDir can only be used with the %s runtime", containerdRuntime)}
+	}
+	if options.KubeconfigPath == "" {
+		options.KubeconfigPath = filepath.Join(options.InstallDir, "kubeconfig")
+	}
+	if options.Logger == nil {
+		options.Logger = logger.Log.WithName("bootstrapper")
+	}
+	if options.Clock == nil {
+		options.Clock = clock.RealClock{}
+	}
+	if options.FS == nil {
+		options.FS = osFileSystem
 	}
 
-	svcMgr, err := mgr.Connect()
+	svcMgr, err := connectServiceManager()
 	if err != nil {
-		return nil, fmt.Errorf("could not connect to Windows SCM: %s", err)
+		return nil, fmt.Errorf("could not connect to Windows SCM: %w", err)
 	}
 	bootstrapper := winNodeBootstrapper{
-		kubeconfigPath:     filepath.Join(k8sInstallDir, "kubeconfig"),
-		kubeletConfPath:    filepath.Join(k8sInstallDir, "kubelet.conf"),
-		ignitionFilePath:   ignitionFile,
-		installDir:         k8sInstallDir,
-		logDir:             "C:\\var\\log\\kubelet",
-		initialKubeletPath: kubeletPath,
-		svcMgr:             svcMgr,
-		kubeletArgs:        make(map[string]string),
+		kubeconfigPath:      options.KubeconfigPath,
+		kubeletConfPath:     filepath.Join(options.InstallDir, "kubelet.conf"),
+		ignitionFilePath:    options.IgnitionFile,
+		installDir:          options.InstallDir,
+		logDir:              "C:\\var\\log\\kubelet",
+		initialKubeletPath:  options.KubeletPath,
+		svcMgr:              svcMgr,
+		kubeletArgs:         make(map[string]string),
+		containerRuntime:    options.ContainerRuntime,
+		containerdDir:       options.ContainerdDir,
+		kubeletArgOverrides: make(map[string]string),
+		certDir:             certDirectory,
+		serviceRecovery:     defaultServiceRecovery(),
+		log:                 options.Logger,
+		clock:               options.Clock,
+		fs:                  options.FS,
 	}
 	// populate the CNI struct if CNI options are present
-	if cniDir != "" && cniConfig != "" {
-		bootstrapper.cni, err = newCNIOptions(k8sInstallDir, cniDir, cniConfig)
+	if options.CNIDir != "" && options.CNIConfig != "" {
+		bootstrapper.cni, err = newCNIOptions(options.FS, options.InstallDir, options.ContainerRuntime, options.CNIDir,
+			options.CNIConfig)
 		if err != nil {
-			return nil, fmt.Errorf("could not initialize cniOptions: %v", err)
+			svcMgr.Disconnect()
+			return nil, &OptionError{Option: "CNIConfig", Err: fmt.Errorf("could not initialize cniOptions: %w", err)}
 		}
 	}
 
 	// If there is already a kubelet service running, find and assign it
 	bootstrapper.kubeletSVC, err = assignExistingKubelet(svcMgr)
 	if err != nil {
+		svcMgr.Disconnect()
 		return nil, fmt.Errorf("could not assign existing kubelet service: %v", err)
 	}
 	return &bootstrapper, nil
@@ -189,11 +365,11 @@ func NewWinNodeBootstrapper(k8sInstallDir, ignitionFile, kubeletPath string, cni
 
 // assignExistingKubelet finds the existing kubelet service from the Windows Service Manager,
 // assigns its value to the kubeletService struct and returns it.
-func assignExistingKubelet(svcMgr *mgr.Mgr) (*kubeletService, error) {
+func assignExistingKubelet(svcMgr serviceManager) (*kubeletService, error) {
 	ksvc, err := svcMgr.OpenService(KubeletServiceName)
 	if err != nil {
 		// Do not return error if the service is not installed.
-		if !strings.Contains(err.Error(), "service does not exist") {
+		if !errors.Is(err, errServiceDoesNotExist) {
 			return nil, fmt.Errorf("error getting existing kubelet service %v", err)
 		}
 		return nil, nil
@@ -209,19 +385,19 @@ func assignExistingKubelet(svcMgr *mgr.Mgr) (*kubeletService, error) {
 	return kubeletSVC, nil
 }
 
-// newCNIOptions takes the paths to the kubelet installation and the CNI files as input and returns the cniOptions
-// object
-func newCNIOptions(k8sInstallDir, dir, config string) (*cniOptions, error) {
-	if err := checkCNIInputs(k8sInstallDir, dir, config); err != nil {
-		return nil, err
+// newCNIOptions takes the file system along with the paths to the kubelet installation and the CNI files as input and
+// returns the cniOptions object
+func newCNIOptions(fs FileSystem, k8sInstallDir, runtime, dir, config string) (*cniOptions, error) {
+	if err := checkCNIInputs(fs, k8sInstallDir, dir, config); err != nil {
+		return nil, newError(ErrCNIInvalid, err)
 	}
 
 	return &cniOptions{
+		fs:            fs,
 		k8sInstallDir: k8sInstallDir,
 		dir:           dir,
 		config:        config,
-		binDir:        filepath.Join(k8sInstallDir, cniDirName),
-		confDir:       filepath.Join(k8sInstallDir, cniConfigDirName),
+		layout:        newCNILayout(k8sInstallDir, runtime),
 	}, nil
 }
 
@@ -238,6 +414,12 @@ type fileTranslation struct {
 type kubeletConf struct {
 	// ClientCAFile specifies location to client certificate
 	ClientCAFile string
+	// EvictionHard is the JSON object of the hard eviction thresholds
+	EvictionHard string
+	// ImageGCHighThresholdPercent is the disk usage above which the unused images are garbage collected
+	ImageGCHighThresholdPercent int
+	// ImageGCLowThresholdPercent is the disk usage the unused images are garbage collected down to
+	ImageGCLowThresholdPercent int
 }
 
 // createKubeletConf creates config file for kubelet, with Windows specific configuration
@@ -257,26 +439,34 @@ func (wmcb *winNodeBootstrapper) createKubeletConf() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	evictionHard, err := wmcb.evictionHardJSON()
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling eviction thresholds: %v", err)
+	}
+	eviction := wmcb.evictionOptions()
 	// Fill up the config file, using kubeletConf struct
 	variableFields := kubeletConf{
-		ClientCAFile: strings.Join(append(strings.Split(wmcb.installDir, `\`), `kubelet-ca.crt`), `\\`),
+		ClientCAFile:                strings.Join(append(strings.Split(wmcb.installDir, `\`), kubeletCAName), `\\`),
+		EvictionHard:                evictionHard,
+		ImageGCHighThresholdPercent: eviction.ImageGCHighThresholdPercent,
+		ImageGCLowThresholdPercent:  eviction.ImageGCLowThresholdPercent,
 	}
 	// Create kubelet.conf file
 	kubeletConfPath := filepath.Join(wmcb.installDir, "kubelet.conf")
-	kubeletConfFile, err := os.Create(kubeletConfPath)
+	var kubeletConfData bytes.Buffer
+	err = kubeletConfTmpl.Execute(&kubeletConfData, variableFields)
 	if err != nil {
-		return nil, fmt.Errorf("error creating %s: %v", kubeletConfPath, err)
+		return nil, fmt.Errorf("error generating %s: %v", kubeletConfPath, err)
 	}
-	err = kubeletConfTmpl.Execute(kubeletConfFile, variableFields)
+	// The user provided overrides take precedence over the Windows specific configuration
+	kubeletConfContents, err := wmcb.applyKubeletConfigOverrides(kubeletConfData.Bytes())
 	if err != nil {
-		return nil, fmt.Errorf("error writing data to %v file: %v", kubeletConfPath, err)
+		return nil, err
 	}
-
-	kubeletConfData, err := ioutil.ReadFile(kubeletConfFile.Name())
-	if err != nil {
-		return nil, fmt.Errorf("error reading data from %v file: %v", kubeletConfPath, err)
+	if err = wmcb.writeKubeletFile(kubeletConfPath, kubeletConfContents); err != nil {
+		return nil, fmt.Errorf("error writing data to %v file: %v", kubeletConfPath, err)
 	}
-	return kubeletConfData, nil
+	return kubeletConfContents, nil
 }
 
 // translateFile decodes an ignition "Storage.Files.Contents.Source" field and transforms it via the function provided.
@@ -314,24 +504,59 @@ func convertIgnition2to3(ign2config ignitionCfgv2_4Types.Config) (ignitionCfgv3T
 	return ign3_1config, nil
 }
 
+// ignitionSpecVersion is used to peek at the spec version of an ignition config before choosing the parser for it
+type ignitionSpecVersion struct {
+	Ignition struct {
+		Version string `json:"version"`
+	} `json:"ignition"`
+}
+
+// parseIgnitionConfig detects the spec version of the given ignition file contents and returns it as an Ignition spec
+// v3.1 config. Spec v2.x and v3.0 configs are translated to v3.1, so that the rest of the bootstrapper only needs to
+// deal with a single layout of storage.files and systemd.units.
+func parseIgnitionConfig(ignitionFileContents []byte) (ignitionCfgv3Types.Config, error) {
+	var specVersion ignitionSpecVersion
+	if err := json.Unmarshal(ignitionFileContents, &specVersion); err != nil {
+		return ignitionCfgv3Types.Config{}, errors.Errorf("unable to determine Ign spec version: %v", err)
+	}
+
+	version := specVersion.Ignition.Version
+	switch {
+	// Spec v1 configs do not have the ignition.version field, and are handled by the v2 parser
+	case version == "" || strings.HasPrefix(version, "2."):
+		// the Ignition config spec v2.4 parser supports parsing all spec versions up to 2.4
+		configV2, report, err := ignitionCfgv2_4.Parse(ignitionFileContents)
+		if err != nil || report.IsFatal() {
+			return ignitionCfgv3Types.Config{}, errors.Errorf("failed to parse Ign spec v2 config: %v\nReport: %v",
+				err, report)
+		}
+		return convertIgnition2to3(configV2)
+	case strings.HasPrefix(version, "3.0."):
+		configV3_0, report, err := ignitionCfgv3_0.Parse(ignitionFileContents)
+		if err != nil || report.IsFatal() {
+			return ignitionCfgv3Types.Config{}, errors.Errorf("failed to parse Ign spec v3.0 config: %v\nReport: %v",
+				err, report)
+		}
+		return ignitionCfgv30tov31.Translate(configV3_0), nil
+	case strings.HasPrefix(version, "3.1."):
+		configuration, report, err := ignitionCfgv3.Parse(ignitionFileContents)
+		if err != nil || report.IsFatal() {
+			return ignitionCfgv3Types.Config{}, errors.Errorf("failed to parse Ign spec v3.1 config: %v\nReport: %v",
+				err, report)
+		}
+		return configuration, nil
+	default:
+		return ignitionCfgv3Types.Config{}, errors.Errorf("unsupported Ign spec version %s", version)
+	}
+}
+
 // parseIgnitionFileContents parses the ignition file contents and writes the contents of the described files to the k8s
 // installation directory
 func (wmcb *winNodeBootstrapper) parseIgnitionFileContents(ignitionFileContents []byte,
 	filesToTranslate map[string]fileTranslation) error {
-	// Parse raw file contents for Ignition spec v3.1 config
-	configuration, report, err := ignitionCfgv3.Parse(ignitionFileContents)
-	if err != nil && err.Error() == ignitionCfgError.ErrUnknownVersion.Error() {
-		// the Ignition config spec v2.4 parser supports parsing all spec versions up to 2.4
-		configV2, reportV2, errV2 := ignitionCfgv2_4.Parse(ignitionFileContents)
-		if errV2 != nil || reportV2.IsFatal() {
-			return errors.Errorf("failed to parse Ign spec v2 config: %v\nReport: %v", errV2, reportV2)
-		}
-		configuration, err = convertIgnition2to3(configV2)
-		if err != nil {
-			return err
-		}
-	} else if err != nil || report.IsFatal() {
-		return errors.Errorf("failed to parse Ign spec v3.1 config: %v\nReport: %v", err, report)
+	configuration, err := parseIgnitionConfig(ignitionFileContents)
+	if err != nil {
+		return newError(ErrIgnitionParse, err)
 	}
 
 	// Find the kubelet systemd service specified in the ignition file and grab the variable arguments
@@ -342,29 +567,38 @@ func (wmcb *winNodeBootstrapper) parseIgnitionFileContents(ignitionFileContents
 		}
 
 		if unit.Contents == nil {
-			return fmt.Errorf("could not process %s: Unit is empty", unit.Name)
+			return newError(ErrIgnitionParse, fmt.Errorf("could not process %s: Unit is empty", unit.Name))
 		}
 
 		results := cloudProviderRegex.FindStringSubmatch(*unit.Contents)
 		if len(results) == 2 {
 			wmcb.kubeletArgs["cloud-provider"] = results[1]
 		}
+		if wmcb.externalCloudProvider {
+			wmcb.kubeletArgs["cloud-provider"] = externalCloudProvider
+		}
 
 		// Check for the presence of "--cloud-config" option and if it is present append the value to
 		// filesToTranslate. This option is only present for Azure and hence we cannot assume it as a file that
-		// requires translation across clouds.
+		// requires translation across clouds. With an external cloud provider, the kubelet does not use it.
 		results = cloudConfigRegex.FindStringSubmatch(*unit.Contents)
-		if len(results) == 2 {
+		if len(results) == 2 && wmcb.cloudConfigRequired() {
 			cloudConfFilename := filepath.Base(results[1])
 
 			// Check if we were able to get a valid filename. Read filepath.Base() godoc for explanation.
 			if cloudConfFilename == "." || os.IsPathSeparator(cloudConfFilename[0]) {
-				return fmt.Errorf("could not get cloud config filename from %s", results[0])
+				return newError(ErrIgnitionParse, fmt.Errorf("could not get cloud config filename from %s", results[0]))
 			}
 
 			filesToTranslate[results[1]] = fileTranslation{
 				dest: filepath.Join(wmcb.installDir, cloudConfFilename),
 			}
+			if wmcb.cloudConfigManagedIdentity {
+				filesToTranslate[results[1]] = fileTranslation{
+					dest:            filepath.Join(wmcb.installDir, cloudConfFilename),
+					translationFunc: useManagedIdentity,
+				}
+			}
 
 			// Set the --cloud-config option value
 			wmcb.kubeletArgs[cloudConfigOption] = filepath.Join(wmcb.installDir, cloudConfFilename)
@@ -383,80 +617,140 @@ func (wmcb *winNodeBootstrapper) parseIgnitionFileContents(ignitionFileContents
 
 	// For each new file in the ignition file check if is a file we are interested in, if so, decode, transform,
 	// and write it to the destination path
+	extraFiles := make(map[string][]byte)
 	for _, ignFile := range configuration.Storage.Files {
+		// The cluster-wide proxy settings are not written to a file, but are used to configure the services
+		if ignFile.Node.Path == proxyEnvFile && ignFile.Contents.Source != nil {
+			proxyEnv, err := wmcb.translateFile(*ignFile.Contents.Source, nil)
+			if err != nil {
+				return newError(ErrIgnitionParse, fmt.Errorf("could not process %s: %s", ignFile.Node.Path, err))
+			}
+			wmcb.proxy.merge(parseProxyEnv(proxyEnv))
+			continue
+		}
+		// The trusted CA bundle and the enabled extra files are installed once they have all been found, as some of
+		// them refer to the others
+		if (ignFile.Node.Path == userCABundlePath || wmcb.isIgnitionFileEnabled(ignFile.Node.Path)) &&
+			ignFile.Contents.Source != nil {
+			contents, err := wmcb.translateFile(*ignFile.Contents.Source, nil)
+			if err != nil {
+				return newError(ErrIgnitionParse, fmt.Errorf("could not process %s: %s", ignFile.Node.Path, err))
+			}
+			extraFiles[ignFile.Node.Path] = contents
+			continue
+		}
 		if filePair, ok := filesToTranslate[ignFile.Node.Path]; ok {
 			if ignFile.Contents.Source == nil {
-				return fmt.Errorf("could not process %s: File is empty", ignFile.Node.Path)
+				return newError(ErrIgnitionParse, fmt.Errorf("could not process %s: File is empty", ignFile.Node.Path))
 			}
 
 			newContents, err := wmcb.translateFile(*ignFile.Contents.Source, filePair.translationFunc)
 			if err != nil {
-				return fmt.Errorf("could not process %s: %s", ignFile.Node.Path, err)
+				return newError(ErrIgnitionParse, fmt.Errorf("could not process %s: %s", ignFile.Node.Path, err))
 			}
-			if err = ioutil.WriteFile(filePair.dest, newContents, 0644); err != nil {
-				return fmt.Errorf("could not write to %s: %s", filePair.dest, err)
+			if err = wmcb.writeKubeletFile(filePair.dest, newContents); err != nil {
+				return fmt.Errorf("could not write to %s: %w", filePair.dest, err)
 			}
 		}
 	}
 
-	return nil
+	return wmcb.installIgnitionFiles(extraFiles)
 }
 
 // initializeKubeletFiles initializes the files required by the kubelet
-func (wmcb *winNodeBootstrapper) initializeKubeletFiles() error {
+func (wmcb *winNodeBootstrapper) initializeKubeletFiles(ctx context.Context) error {
 	filesToTranslate := map[string]fileTranslation{
 		"/etc/kubernetes/kubeconfig": {
-			dest: filepath.Join(wmcb.installDir, "bootstrap-kubeconfig"),
+			dest: filepath.Join(wmcb.installDir, bootstrapKubeconfigName),
 		},
 		"/etc/kubernetes/kubelet-ca.crt": {
-			dest: filepath.Join(wmcb.installDir, "kubelet-ca.crt"),
+			dest: filepath.Join(wmcb.installDir, kubeletCAName),
 		},
 	}
+	// The generated bootstrap kubeconfig and kubelet CA take precedence over the ones in the ignition file
+	if wmcb.bootstrapCredentials != nil {
+		delete(filesToTranslate, "/etc/kubernetes/kubeconfig")
+		delete(filesToTranslate, "/etc/kubernetes/kubelet-ca.crt")
+	}
 
 	// Create the manifest directory needed by kubelet for the static pods, we shouldn't override if the pod manifest
 	// directory already exists
 	podManifestDirectory := filepath.Join(wmcb.installDir, "etc", "kubernetes", "manifests")
-	if _, err := os.Stat(podManifestDirectory); os.IsNotExist(err) {
-		err := os.MkdirAll(podManifestDirectory, os.ModeDir)
+	if _, err := wmcb.fileSystem().Stat(podManifestDirectory); os.IsNotExist(err) {
+		err := wmcb.mkdirAll(podManifestDirectory)
 		if err != nil {
-			return fmt.Errorf("could not make pod manifest directory: %s", err)
+			return fmt.Errorf("could not make pod manifest directory: %w", err)
 		}
 	}
 
-	err := os.MkdirAll(wmcb.installDir, os.ModeDir)
+	err := wmcb.mkdirAll(wmcb.installDir)
 	if err != nil {
-		return fmt.Errorf("could not make install directory: %s", err)
+		return fmt.Errorf("could not make install directory: %w", err)
 	}
 
 	_, err = wmcb.createKubeletConf()
 	if err != nil {
-		return fmt.Errorf("error creating kubelet configuration %v", err)
+		return fmt.Errorf("error creating kubelet configuration: %w", err)
 	}
 
 	if wmcb.initialKubeletPath != "" {
-		err = copyFile(wmcb.initialKubeletPath, filepath.Join(wmcb.installDir, "kubelet.exe"))
+		kubeletContents, err := readFile(wmcb.fileSystem(), wmcb.initialKubeletPath)
 		if err != nil {
-			return fmt.Errorf("could not copy kubelet: %s", err)
+			return fmt.Errorf("could not read kubelet: %w", err)
+		}
+		kubeletExePath := filepath.Join(wmcb.installDir, "kubelet.exe")
+		// kubelet.exe cannot be replaced while the kubelet is running, without getting 'The process cannot access the
+		// file because it is being used by another process.' error
+		if !fileContentsEqual(wmcb.fileSystem(), kubeletExePath, kubeletContents) && wmcb.kubeletSVC != nil &&
+			wmcb.dryRun == nil {
+			if err = wmcb.kubeletSVC.stop(); err != nil {
+				return fmt.Errorf("failed to stop kubelet service: %w", err)
+			}
+		}
+		if err = wmcb.writeKubeletFile(kubeletExePath, kubeletContents); err != nil {
+			return fmt.Errorf("could not copy kubelet: %w", err)
 		}
 	}
 
 	// Create log directory
-	err = os.MkdirAll(wmcb.logDir, os.ModeDir)
+	err = wmcb.mkdirAll(wmcb.logDir)
 	if err != nil {
-		return fmt.Errorf("could not make %s directory: %v", wmcb.logDir, err)
+		return fmt.Errorf("could not make %s directory: %w", wmcb.logDir, err)
 	}
 
 	// Populate destination directory with the files we need
-	if wmcb.ignitionFilePath != "" {
-		ignitionFileContents, err := ioutil.ReadFile(wmcb.ignitionFilePath)
+	if wmcb.ignitionFilePath != "" || wmcb.ignitionEndpoint != nil {
+		ignitionFileContents, err := wmcb.readIgnition(ctx)
 		if err != nil {
-			return fmt.Errorf("could not read ignition file: %s", err)
+			return wmcb.recordPhase(PhaseIgnitionParsed, err)
 		}
 
 		err = wmcb.parseIgnitionFileContents(ignitionFileContents, filesToTranslate)
 		if err != nil {
-			return fmt.Errorf("could not parse ignition file: %s", err)
+			return wmcb.recordPhase(PhaseIgnitionParsed, fmt.Errorf("could not parse ignition file: %w", err))
 		}
+		wmcb.recordPhase(PhaseIgnitionParsed, nil)
+	}
+	if wmcb.bootstrapCredentials != nil {
+		if err = wmcb.writeBootstrapCredentials(); err != nil {
+			return err
+		}
+	}
+	// The clock is synchronized before the kubelet is started, as it requests its certificates right away
+	if err = wmcb.configureTimeSync(ctx); err != nil {
+		return fmt.Errorf("could not synchronize the clock: %w", err)
+	}
+
+	// The plugins are configured after parsing the ignition file, as some of them need the cloud config
+	if err = wmcb.installCredentialProviders(); err != nil {
+		return fmt.Errorf("could not install image credential providers: %w", err)
+	}
+	if err = wmcb.configureGMSA(ctx); err != nil {
+		return fmt.Errorf("could not configure GMSA support: %w", err)
+	}
+	// The access is restricted once the credentials are written, as they are written with the inherited permissions
+	if err = wmcb.restrictAccess(); err != nil {
+		return fmt.Errorf("could not restrict access to the install directory: %w", err)
 	}
 	return nil
 }
@@ -470,10 +764,10 @@ func (wmcb *winNodeBootstrapper) getInitialKubeletArgs() []string {
 	// expect users to execute WMCB directly.
 	kubeletArgs := []string{
 		"--config=" + wmcb.kubeletConfPath,
-		"--bootstrap-kubeconfig=" + filepath.Join(wmcb.installDir, "bootstrap-kubeconfig"),
+		"--bootstrap-kubeconfig=" + filepath.Join(wmcb.installDir, bootstrapKubeconfigName),
 		"--kubeconfig=" + wmcb.kubeconfigPath,
-		"--pod-infra-container-image=" + kubeletPauseContainerImage,
-		"--cert-dir=" + certDirectory,
+		"--pod-infra-container-image=" + wmcb.pauseImage(),
+		"--cert-dir=" + wmcb.certDir,
 		"--windows-service",
 		"--logtostderr=false",
 		"--log-file=" + filepath.Join(wmcb.logDir, "kubelet.log"),
@@ -481,13 +775,18 @@ func (wmcb *winNodeBootstrapper) getInitialKubeletArgs() []string {
 		// Windows nodes.
 		// TODO: Write a `against the cluster` e2e test which checks for the Windows node object created
 		// and check for taint.
-		"--register-with-taints=" + windowsTaints,
-		// Label that WMCB uses
-		"--node-labels=" + nodeLabel,
+		"--register-with-taints=" + wmcb.taintsArg(),
+		// Label that WMCB uses, along with the user provided labels
+		"--node-labels=" + wmcb.nodeLabelsArg(),
+	}
+	if wmcb.containerRuntime == containerdRuntime {
+		kubeletArgs = append(kubeletArgs, "--container-runtime=remote",
+			"--container-runtime-endpoint="+containerdEndpoint)
+	} else {
 		// Added to allow pulling of large base Windows images. Note that this only works with the Docker runtime and is
 		// not available for ContainerD yet. Addition of this option is tracked by
 		// https://github.com/containerd/containerd/issues/4984
-		"--image-pull-progress-deadline=30m",
+		kubeletArgs = append(kubeletArgs, "--image-pull-progress-deadline=30m")
 	}
 	if cloudProvider, ok := wmcb.kubeletArgs["cloud-provider"]; ok {
 		kubeletArgs = append(kubeletArgs, "--cloud-provider="+cloudProvider)
@@ -495,58 +794,121 @@ func (wmcb *winNodeBootstrapper) getInitialKubeletArgs() []string {
 	if v, ok := wmcb.kubeletArgs["v"]; ok {
 		kubeletArgs = append(kubeletArgs, "--v="+v)
 	}
-	if cloudConfigValue, ok := wmcb.kubeletArgs[cloudConfigOption]; ok {
+	if cloudConfigValue, ok := wmcb.kubeletArgs[cloudConfigOption]; ok && !wmcb.isExternalCloudProvider() {
 		kubeletArgs = append(kubeletArgs, "--"+cloudConfigOption+"="+cloudConfigValue)
 	}
 	if nodeWorkerLabel, ok := wmcb.kubeletArgs["node-labels"]; ok {
 		kubeletArgs = append(kubeletArgs, "--"+"node-labels"+"="+nodeWorkerLabel)
 	}
+	if wmcb.nodeIPArg != "" {
+		kubeletArgs = append(kubeletArgs, "--"+nodeIPArg+"="+wmcb.nodeIPArg)
+	}
+	kubeletArgs = append(kubeletArgs, wmcb.credentialProviderArgs()...)
+	return wmcb.applyKubeletArgOverrides(kubeletArgs)
+}
+
+// SetLogger sets the logger the bootstrapper logs its progress with, allowing library consumers to route the logs
+func (wmcb *winNodeBootstrapper) SetLogger(log logr.Logger) error {
+	if log == nil {
+		return fmt.Errorf("logger cannot be nil")
+	}
+	wmcb.log = log
+	return nil
+}
+
+// SetKubeletArgs sets kubelet arguments that override the arguments WMCB would otherwise pass to the kubelet, or are
+// added to them if WMCB does not set them. The keys are the argument names with or without the leading "--", for
+// example "max-pods". This needs to be called before InitializeKubelet for the arguments to take effect.
+func (wmcb *winNodeBootstrapper) SetKubeletArgs(args map[string]string) error {
+	for key, value := range args {
+		name := strings.TrimLeft(key, "-")
+		if name == "" || strings.ContainsAny(name, "= ") {
+			return fmt.Errorf("invalid kubelet argument name %q", key)
+		}
+		if strings.Contains(value, " ") {
+			return fmt.Errorf("invalid value %q for kubelet argument %s: spaces are not allowed", value, name)
+		}
+		wmcb.kubeletArgOverrides[name] = value
+	}
+	return nil
+}
+
+// applyKubeletArgOverrides replaces the arguments in the given kubelet args with the user provided overrides, and
+// appends the overrides that are not present
+func (wmcb *winNodeBootstrapper) applyKubeletArgOverrides(kubeletArgs []string) []string {
+	if len(wmcb.kubeletArgOverrides) == 0 {
+		return kubeletArgs
+	}
+	applied := make(map[string]bool)
+	for i, arg := range kubeletArgs {
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if value, ok := wmcb.kubeletArgOverrides[name]; ok {
+			kubeletArgs[i] = "--" + name + "=" + value
+			applied[name] = true
+		}
+	}
+
+	// Append the remaining overrides in a deterministic order
+	var names []string
+	for name := range wmcb.kubeletArgOverrides {
+		if !applied[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		kubeletArgs = append(kubeletArgs, "--"+name+"="+wmcb.kubeletArgOverrides[name])
+	}
 	return kubeletArgs
 }
 
 // ensureKubeletService creates a new kubelet service to our specifications if it is not already present, else
 // it updates the existing kubelet service with our specifications.
 func (wmcb *winNodeBootstrapper) ensureKubeletService() error {
-	// Mostly default values here
-	c := mgr.Config{
-		ServiceType: 0,
-		// StartAutomatic will start the service again if the node restarts
-		StartType:      mgr.StartAutomatic,
-		ErrorControl:   0,
-		LoadOrderGroup: "",
-		TagId:          0,
-		// set dependency on docker
-		Dependencies:     []string{"docker"},
-		ServiceStartName: "",
-		DisplayName:      "",
-		Password:         "",
-		Description:      "OpenShift Kubelet",
-	}
+	c := wmcb.kubeletServiceConfig()
 	// Get kubelet args
 	kubeletArgs := wmcb.getInitialKubeletArgs()
+	wmcb.log.V(1).Info("kubelet arguments", "args", kubeletArgs)
 
 	if wmcb.kubeletSVC == nil {
 		if err := wmcb.createKubeletService(c, kubeletArgs); err != nil {
 			return fmt.Errorf("failed to create kubelet service : %v ", err)
 		}
+		wmcb.kubeletRestartRequired = true
 	} else {
-		if err := wmcb.updateKubeletService(c, kubeletArgs); err != nil {
+		updated, err := wmcb.updateKubeletService(c, kubeletArgs)
+		if err != nil {
 			return fmt.Errorf("failed to update kubelet service : %v ", err)
 		}
+		if updated {
+			wmcb.kubeletRestartRequired = true
+		}
 	}
 
-	if err := wmcb.kubeletSVC.setRecoveryActions(); err != nil {
+	if err := wmcb.kubeletSVC.setRecoveryActions(wmcb.serviceRecovery); err != nil {
 		return fmt.Errorf("failed to set recovery actions for Windows service %s : %v", KubeletServiceName, err)
 	}
-	return nil
+	return wmcb.configureServiceAccount(wmcb.kubeletSVC.obj, KubeletServiceName, wmcb.kubeletAccountRights())
+}
+
+// runtimeServiceName returns the name of the Windows service of the container runtime the kubelet is configured to use
+func (wmcb *winNodeBootstrapper) runtimeServiceName() string {
+	if wmcb.containerRuntime == containerdRuntime {
+		return containerdServiceName
+	}
+	return dockerRuntime
 }
 
 // createKubeletService creates a new kubelet service to our specifications
-func (wmcb *winNodeBootstrapper) createKubeletService(c mgr.Config, kubeletArgs []string) error {
-	ksvc, err := wmcb.svcMgr.CreateService(KubeletServiceName, filepath.Join(wmcb.installDir, "kubelet.exe"), c, kubeletArgs...)
+func (wmcb *winNodeBootstrapper) createKubeletService(c serviceConfig, kubeletArgs []string) error {
+	ksvc, err := wmcb.svcMgr.CreateService(KubeletServiceName, wmcb.kubeletExePath(), c, kubeletArgs...)
 	if err != nil {
 		return err
 	}
+	if err = wmcb.journal.serviceCreated(KubeletServiceName); err != nil {
+		ksvc.Close()
+		return err
+	}
 
 	wmcb.kubeletSVC, err = newKubeletService(ksvc, nil)
 	if err != nil {
@@ -555,79 +917,190 @@ func (wmcb *winNodeBootstrapper) createKubeletService(c mgr.Config, kubeletArgs
 	return nil
 }
 
-// updateKubeletService updates an existing kubelet service with our specifications
-func (wmcb *winNodeBootstrapper) updateKubeletService(config mgr.Config, kubeletArgs []string) error {
+// updateKubeletService updates an existing kubelet service with our specifications. The service is left untouched if
+// it already matches the specifications, else it is stopped and updated. Returns true if the service was updated.
+func (wmcb *winNodeBootstrapper) updateKubeletService(config serviceConfig, kubeletArgs []string) (bool, error) {
 	// Get existing config
 	existingConfig, err := wmcb.kubeletSVC.config()
 	if err != nil {
-		return fmt.Errorf("no existing config found")
+		return false, fmt.Errorf("no existing config found")
+	}
+
+	// Create kubelet command to populate config.BinaryPathName
+	// Add a space after kubelet.exe followed by the stand alone args
+	kubeletcmd := wmcb.kubeletExePath() + " "
+	// Add rest of the args
+	for _, args := range kubeletArgs {
+		kubeletcmd += args + " "
+	}
+	kubeletcmd = strings.TrimSpace(kubeletcmd)
+
+	if existingConfig.BinaryPathName == kubeletcmd && existingConfig.StartType == config.StartType &&
+		existingConfig.DisplayName == config.DisplayName &&
+		strings.Join(existingConfig.Dependencies, ",") == strings.Join(config.Dependencies, ",") &&
+		(config.ServiceStartName == "" || strings.EqualFold(existingConfig.ServiceStartName, config.ServiceStartName)) {
+		return false, nil
+	}
+	if err = wmcb.journal.serviceUpdated(KubeletServiceName, existingConfig); err != nil {
+		return false, err
 	}
 
 	// Stop the kubelet service as there could be open file handles from kubelet.exe on the plugin files
 	if err := wmcb.kubeletSVC.stop(); err != nil {
-		return fmt.Errorf("unable to stop kubelet service: %v", err)
+		return false, fmt.Errorf("unable to stop kubelet service: %v", err)
 	}
 	// Populate existing config with non default values from desired config.
 	existingConfig.Dependencies = config.Dependencies
 	existingConfig.DisplayName = config.DisplayName
 	existingConfig.StartType = config.StartType
-
-	// Create kubelet command to populate config.BinaryPathName
-	// Add a space after kubelet.exe followed by the stand alone args
-	kubeletcmd := filepath.Join(wmcb.installDir, "kubelet.exe") + " "
-	// Add rest of the args
-	for _, args := range kubeletArgs {
-		kubeletcmd += args + " "
+	existingConfig.BinaryPathName = kubeletcmd
+	if config.ServiceStartName != "" {
+		existingConfig.ServiceStartName = config.ServiceStartName
+		existingConfig.SidType = config.SidType
 	}
-	existingConfig.BinaryPathName = strings.TrimSpace(kubeletcmd)
 
-	// Update service config and restart
-	if err := wmcb.kubeletSVC.refresh(existingConfig); err != nil {
-		return fmt.Errorf("unable to refresh kubelet service: %v", err)
+	// Update service config, the service is started by the caller
+	if err := wmcb.kubeletSVC.updateConfig(existingConfig); err != nil {
+		return false, fmt.Errorf("unable to update kubelet service: %v", err)
 	}
 
 	// Update dependents field if there is any change
 	dependents, err := updateKubeletDependents(wmcb.svcMgr)
 	if err != nil {
-		return fmt.Errorf("error updating kubelet dependents field %v", err)
+		return false, fmt.Errorf("error updating kubelet dependents field %v", err)
 	}
 	wmcb.kubeletSVC.dependents = dependents
 
-	return nil
+	return true, nil
 }
 
 // InitializeKubelet performs the initial kubelet configuration. It sets up the install directory, creates the kubelet
-// service, and then starts the kubelet service
-func (wmcb *winNodeBootstrapper) InitializeKubelet() error {
+// service, and then starts the kubelet service. It can be re-run on a node that has already been initialized, for
+// example after a partial failure, in which case the existing files and kubelet service are reconciled with the inputs.
+// Only the files and service configuration that differ are rewritten and the kubelet is restarted only if any of them
+// changed. The initialization is aborted between its phases, and while waiting on external commands, once the context
+// is done.
+func (wmcb *winNodeBootstrapper) InitializeKubelet(ctx context.Context) error {
 	var err error
+	wmcb.log.Info("initializing kubelet", "installDir", wmcb.installDir, "containerRuntime", wmcb.containerRuntime)
+	// The phases of a previous bootstrap, including the CNI configuration, are no longer applicable
+	wmcb.resetStatus()
+	wmcb.startPhase()
+	wmcb.kubeletRestartRequired = false
+	if wmcb.dryRun == nil {
+		// The changes of a previous bootstrap are no longer rolled back, as the node is initialized again
+		if wmcb.journal, err = newJournal(wmcb.fileSystem(), wmcb.installDir); err != nil {
+			return fmt.Errorf("unable to bootstrap Windows node: %w", err)
+		}
+	}
 
-	if wmcb.kubeletSVC != nil {
-		// Stop kubelet service if it is in Running state. This is required to access kubelet files
-		// without getting 'The process cannot access the file because it is being used by another process.' error
-		err := wmcb.kubeletSVC.stop()
+	if err = wmcb.detectWindowsBuild(); err != nil {
+		return fmt.Errorf("unable to bootstrap Windows node: %w", err)
+	}
+	// The kubelet version is checked before the files are written, as a different kubelet.exe stops the kubelet
+	kubeletVersion, err := wmcb.initialKubeletVersion()
+	if err != nil {
+		return wmcb.recordPhase(PhaseFilesWritten, fmt.Errorf("unable to bootstrap Windows node: %w", err))
+	}
+	if err = wmcb.runHooks(ctx, HookPreKubelet); err != nil {
+		return fmt.Errorf("unable to bootstrap Windows node: %w", err)
+	}
+
+	err = wmcb.initializeKubeletFiles(ctx)
+	if err != nil {
+		return wmcb.recordPhase(PhaseFilesWritten, fmt.Errorf("failed to initialize kubelet: %w", err))
+	}
+	wmcb.recordPhase(PhaseFilesWritten, nil)
+
+	if err = ctx.Err(); err != nil {
+		return wmcb.recordPhase(PhaseServiceCreated, fmt.Errorf("kubelet initialization interrupted: %w", err))
+	}
+	// The node IP is selected once the bootstrap kubeconfig, which the API server is reached through, is written
+	if err = wmcb.resolveNodeIP(); err != nil {
+		return wmcb.recordPhase(PhaseServiceCreated, fmt.Errorf("failed to select node IP: %w", err))
+	}
+	if kubeletVersion != nil && wmcb.dryRun == nil {
+		err = wmcb.checkAPIServerVersion(ctx, kubeletVersion, filepath.Join(wmcb.installDir, bootstrapKubeconfigName))
 		if err != nil {
-			return fmt.Errorf("failed to stop kubelet service: %v", err)
+			return wmcb.recordPhase(PhaseServiceCreated, fmt.Errorf("failed to validate kubelet version: %w", err))
+		}
+	}
+	if wmcb.dryRun != nil {
+		if err = wmcb.planKubeletServices(); err != nil {
+			return err
 		}
+		return wmcb.runHooks(ctx, HookPostKubelet)
 	}
 
-	err = wmcb.initializeKubeletFiles()
-	if err != nil {
-		return fmt.Errorf("failed to initialize kubelet: %v", err)
+	if wmcb.containerRuntime == containerdRuntime {
+		wmcb.log.Info("ensuring containerd service", "containerdDir", wmcb.containerdDir)
+		if err = wmcb.ensureContainerdService(); err != nil {
+			return wmcb.recordPhase(PhaseServiceCreated, newError(ErrServiceCreate,
+				fmt.Errorf("failed to ensure that containerd windows service is running: %w", err)))
+		}
 	}
 
 	err = wmcb.ensureKubeletService()
 	if err != nil {
-		return fmt.Errorf("failed to ensure that kubelet windows service is present: %v", err)
+		return wmcb.recordPhase(PhaseServiceCreated, newError(ErrServiceCreate,
+			fmt.Errorf("failed to ensure that kubelet windows service is present: %w", err)))
+	}
+	if wmcb.cloudNodeManager != nil {
+		wmcb.log.Info("ensuring cloud-node-manager service", "nodeName", wmcb.cloudNodeManager.nodeName)
+		if err = wmcb.ensureCloudNodeManagerService(); err != nil {
+			return wmcb.recordPhase(PhaseServiceCreated, newError(ErrServiceCreate,
+				fmt.Errorf("failed to ensure that cloud-node-manager windows service is present: %w", err)))
+		}
 	}
+	if err = wmcb.configureProxy(ctx); err != nil {
+		return wmcb.recordPhase(PhaseServiceCreated, fmt.Errorf("failed to configure proxy: %w", err))
+	}
+	if err = wmcb.updateKubeletUnit(); err != nil {
+		return wmcb.recordPhase(PhaseServiceCreated, err)
+	}
+	wmcb.recordPhase(PhaseServiceCreated, nil)
+
+	if err = ctx.Err(); err != nil {
+		return wmcb.recordPhase(PhaseKubeletStarted, fmt.Errorf("kubelet initialization interrupted: %w", err))
+	}
+	if wmcb.pauseImageArchive != "" {
+		wmcb.log.Info("loading pause image", "archive", wmcb.pauseImageArchive)
+		if err = wmcb.loadPauseImage(ctx); err != nil {
+			return wmcb.recordPhase(PhaseKubeletStarted, err)
+		}
+	}
+	if wmcb.kubeletRestartRequired {
+		wmcb.log.Info("kubelet configuration changed, restarting kubelet service")
+		if err = wmcb.kubeletSVC.stop(); err != nil {
+			return wmcb.recordPhase(PhaseKubeletStarted, fmt.Errorf("failed to stop kubelet service: %w", err))
+		}
+	}
+	// This is a no-op if the kubelet is already running with the desired configuration
 	err = wmcb.kubeletSVC.start()
 	if err != nil {
-		return fmt.Errorf("failed to start kubelet windows service: %v", err)
+		return wmcb.recordPhase(PhaseKubeletStarted, fmt.Errorf("failed to start kubelet windows service: %w", err))
 	}
-	return nil
+	if wmcb.kubeletRestartRequired {
+		wmcb.recordServiceRestart(KubeletServiceName)
+	}
+	if wmcb.cloudNodeManager != nil {
+		if err = wmcb.startCloudNodeManagerService(); err != nil {
+			return wmcb.recordPhase(PhaseKubeletStarted,
+				fmt.Errorf("failed to start cloud-node-manager windows service: %w", err))
+		}
+	}
+	wmcb.recordPhase(PhaseKubeletStarted, nil)
+	wmcb.log.Info("kubelet service started")
+	return wmcb.runHooks(ctx, HookPostKubelet)
 }
 
-// Configure configures the kubelet service for plugins like CNI
-func (wmcb *winNodeBootstrapper) Configure() error {
+// Configure configures the kubelet service for plugins like CNI. If the hybrid overlay has been enabled, it also
+// installs the hybrid-overlay-node service, which is started along with the kubelet service. Waiting for the kubelet
+// service to run is aborted once the context is done.
+func (wmcb *winNodeBootstrapper) Configure(ctx context.Context) (err error) {
+	wmcb.startPhase()
+	defer func() { wmcb.recordPhase(PhaseCNIConfigured, err) }()
+
 	// TODO: add && wmcb.csi == null check here when we add CSI support
 	if wmcb.cni == nil {
 		return fmt.Errorf("cannot configure without required plugin inputs")
@@ -638,27 +1111,76 @@ func (wmcb *winNodeBootstrapper) Configure() error {
 	if wmcb.kubeletSVC == nil {
 		return fmt.Errorf("kubelet service is not present")
 	}
+	if err = wmcb.runHooks(ctx, HookPreCNI); err != nil {
+		return err
+	}
+	if wmcb.dryRun != nil {
+		if err = wmcb.planConfigure(); err != nil {
+			return err
+		}
+		return wmcb.runHooks(ctx, HookPostCNI)
+	}
+	if wmcb.journal, err = loadJournal(wmcb.fileSystem(), wmcb.installDir); err != nil {
+		return err
+	}
+	wmcb.cni.journal = wmcb.journal
 
 	// Stop the kubelet service as there could be open file handles from kubelet.exe on the plugin files
 	if err := wmcb.kubeletSVC.stop(); err != nil {
-		return fmt.Errorf("unable to stop kubelet service: %v", err)
+		return fmt.Errorf("unable to stop kubelet service: %w", err)
 	}
 
 	config, err := wmcb.kubeletSVC.config()
 	if err != nil {
-		return fmt.Errorf("error getting kubelet service config: %v", err)
+		return fmt.Errorf("error getting kubelet service config: %w", err)
+	}
+	// The config is recorded before the CNI args are added to it
+	if err = wmcb.journal.serviceUpdated(KubeletServiceName, config); err != nil {
+		return err
 	}
 
 	// TODO: add wmcb.cni != null check here when we add CSI support as this function will be called in both cases
+	if err = wmcb.setCNIConfigDefaults(); err != nil {
+		return fmt.Errorf("error getting CNI config values: %w", err)
+	}
+	if wmcb.hnsNetwork != nil {
+		if err = wmcb.ensureHNSNetwork(ctx); err != nil {
+			return fmt.Errorf("error ensuring HNS network: %w", err)
+		}
+	}
+	if err = wmcb.configureHostDNS(); err != nil {
+		return fmt.Errorf("error configuring DNS: %w", err)
+	}
+	wmcb.setCNILayout(config.BinaryPathName)
+	wmcb.log.Info("configuring kubelet for CNI", "cniDir", wmcb.cni.dir, "cniConfig", wmcb.cni.config,
+		"containerRuntime", wmcb.containerRuntime)
 	if err = wmcb.cni.configure(&config.BinaryPathName); err != nil {
-		return fmt.Errorf("error configuring kubelet service for CNI: %v", err)
+		return fmt.Errorf("error configuring kubelet service for CNI: %w", err)
 	}
 
-	if err = wmcb.kubeletSVC.refresh(config); err != nil {
-		return fmt.Errorf("unable to refresh kubelet service: %v", err)
+	if wmcb.hybridOverlay != nil {
+		wmcb.log.Info("ensuring hybrid-overlay-node service", "node", wmcb.hybridOverlay.nodeName)
+		if err = wmcb.ensureHybridOverlayService(); err != nil {
+			return newError(ErrServiceCreate, fmt.Errorf("error configuring hybrid-overlay-node service: %w", err))
+		}
+		// Update the dependents so that the hybrid-overlay-node service is started along with the kubelet
+		if wmcb.kubeletSVC.dependents, err = updateKubeletDependents(wmcb.svcMgr); err != nil {
+			return fmt.Errorf("error updating kubelet dependents field: %w", err)
+		}
 	}
 
-	return nil
+	if err = ctx.Err(); err != nil {
+		return fmt.Errorf("CNI configuration interrupted: %w", err)
+	}
+	if err = wmcb.kubeletSVC.refresh(ctx, config); err != nil {
+		return fmt.Errorf("unable to refresh kubelet service: %w", err)
+	}
+	if err = wmcb.updateKubeletUnit(); err != nil {
+		return err
+	}
+	wmcb.recordServiceRestart(KubeletServiceName)
+	wmcb.log.Info("kubelet service configured and restarted")
+	return wmcb.runHooks(ctx, HookPostCNI)
 }
 
 // Disconnect removes all connections to the Windows service manager api, and allows services to be deleted
@@ -684,14 +1206,70 @@ func (wmcb *winNodeBootstrapper) UninstallKubelet() error {
 	return nil
 }
 
-func copyFile(src, dest string) error {
-	from, err := os.Open(src)
+// Uninstall reverts the configuration performed by the bootstrapper on the Windows node. It stops and removes the
+// kube-proxy, hybrid-overlay-node, cloud-node-manager and kubelet services, removes the firewall rules and Windows
+// Defender exclusions added by ConfigureHostSecurity, including the ContainerLogsPort firewall rule, and deletes the
+// install directory, which includes the CNI directories. Unlike UninstallKubelet, it does not fail if the kubelet
+// service is not present, so that it can be used to clean up after a partially failed bootstrap.
+func (wmcb *winNodeBootstrapper) Uninstall() error {
+	// The kube-proxy, hybrid-overlay-node and cloud-node-manager services depend on the kubelet service and need to be
+	// removed first
+	for _, dependentSvcName := range []string{kubeProxyServiceName, kubeletDependentSvc, cloudNodeManagerServiceName} {
+		wmcb.log.Info("removing service", "service", dependentSvcName)
+		if err := removeService(wmcb.svcMgr, dependentSvcName); err != nil {
+			return fmt.Errorf("failed to stop and remove %s service: %v", dependentSvcName, err)
+		}
+	}
+	if wmcb.kubeletSVC != nil {
+		wmcb.log.Info("removing service", "service", KubeletServiceName)
+		if err := wmcb.kubeletSVC.stopAndRemove(); err != nil {
+			return fmt.Errorf("failed to stop and remove kubelet service: %v", err)
+		}
+	}
+
+	wmcb.log.Info("removing firewall rules and Windows Defender exclusions")
+	if err := wmcb.removeHostSecurity(); err != nil {
+		return err
+	}
+
+	if wmcb.installDir == "" {
+		return fmt.Errorf("install directory cannot be empty")
+	}
+	// The CNI binaries and configuration are placed within the install directory
+	wmcb.log.Info("removing install directory", "installDir", wmcb.installDir)
+	if err := wmcb.fileSystem().RemoveAll(wmcb.installDir); err != nil {
+		return fmt.Errorf("failed to remove install directory %s: %v", wmcb.installDir, err)
+	}
+	return nil
+}
+
+// runPowerShell runs the given command in a non interactive PowerShell session and returns the combined output
+func runPowerShell(command string) (string, error) {
+	out, err := exec.Command(powerShellExe, "-NonInteractive", "-ExecutionPolicy", "Bypass", "-Command",
+		command).CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("error running %s: %v: %s", command, err, string(out))
+	}
+	return string(out), nil
+}
+
+// removeFirewallRule removes the firewall rules with the given display name. It does not return an error if the rule
+// is not present.
+func removeFirewallRule(displayName string) error {
+	_, err := runPowerShell("Get-NetFirewallRule -DisplayName " + displayName +
+		" -ErrorAction SilentlyContinue | Remove-NetFirewallRule")
+	return err
+}
+
+// copyFile copies the src file of the given file system to dest, replacing dest if it exists
+func copyFile(fs FileSystem, src, dest string) error {
+	from, err := fs.Open(src)
 	if err != nil {
 		return err
 	}
 	defer from.Close()
 
-	to, err := os.OpenFile(dest, os.O_RDWR|os.O_CREATE, 0666)
+	to, err := createFile(fs, dest)
 	if err != nil {
 		return err
 	}
@@ -701,17 +1279,61 @@ func copyFile(src, dest string) error {
 	return err
 }
 
-// checkCNIInputs checks if there are any issues with the CNI inputs to WMCB and returns an error if there is
-func checkCNIInputs(k8sInstallDir string, cniDir string, cniConfig string) error {
+// fileContentsEqual returns true if the file at the given path of the given file system exists and has the given
+// contents
+func fileContentsEqual(fs FileSystem, path string, contents []byte) bool {
+	existingContents, err := readFile(fs, path)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(existingContents, contents)
+}
+
+// writeKubeletFile writes the contents to the given kubelet file if the file does not already have the same contents,
+// and marks the kubelet for a restart if the file was written. The file is only recorded in dry-run mode.
+func (wmcb *winNodeBootstrapper) writeKubeletFile(path string, contents []byte) error {
+	written, err := wmcb.writeFile(path, contents)
+	if err != nil {
+		return err
+	}
+	if written {
+		wmcb.kubeletRestartRequired = true
+	}
+	return nil
+}
+
+// writeFile writes the contents to the given file if the file does not already have the same contents, and returns
+// true if the file was written. The file is only recorded in dry-run mode.
+func (wmcb *winNodeBootstrapper) writeFile(path string, contents []byte) (bool, error) {
+	if wmcb.dryRun != nil {
+		wmcb.dryRun.addFile(path, "", contents)
+		return false, nil
+	}
+	if fileContentsEqual(wmcb.fileSystem(), path, contents) {
+		return false, nil
+	}
+	if err := wmcb.journal.fileWritten(path); err != nil {
+		return false, err
+	}
+	if err := writeFile(wmcb.fileSystem(), path, contents, 0644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkCNIInputs checks if there are any issues with the CNI inputs to WMCB on the given file system and returns an
+// error if there is
+func checkCNIInputs(fs FileSystem, k8sInstallDir string, cniDir string, cniConfig string) error {
+	fs = orOSFileSystem(fs)
 	// Check if there are any issues accessing the installation directory. We don't want to proceed on any error as it
 	// could cause issues further down the line when copying the files.
-	if _, err := os.Stat(k8sInstallDir); err != nil {
+	if _, err := fs.Stat(k8sInstallDir); err != nil {
 		return fmt.Errorf("error accessing install directory %s: %v", k8sInstallDir, err)
 	}
 
 	// Check if there are any issues accessing the CNI dir. We don't want to proceed on any error as it could cause
 	// issues further down the line when copying the files.
-	cniPathInfo, err := os.Stat(cniDir)
+	cniPathInfo, err := fs.Stat(cniDir)
 	if err != nil {
 		return fmt.Errorf("error accessing CNI dir %s: %v", cniDir, err)
 	}
@@ -720,7 +1342,7 @@ func checkCNIInputs(k8sInstallDir string, cniDir string, cniConfig string) error
 	}
 
 	// Check if there are files present in the CNI directory
-	files, err := ioutil.ReadDir(cniDir)
+	files, err := fs.ReadDir(cniDir)
 	if err != nil {
 		return fmt.Errorf("error reading CNI dir %s: %v", cniDir, err)
 	}
@@ -728,23 +1350,27 @@ func checkCNIInputs(k8sInstallDir string, cniDir string, cniConfig string) error
 		return fmt.Errorf("no files present in CNI dir %s", cniDir)
 	}
 
-	// Check if there are any issues accessing the CNI configuration file. We don't want to proceed on any error as it
-	// could cause issues further down the line when copying the files.
-	cniConfigInfo, err := os.Stat(cniConfig)
+	// Check if there are any issues accessing the CNI configuration file or directory. We don't want to proceed on any
+	// error as it could cause issues further down the line when copying the files. The contents of the configs are
+	// validated when they are copied.
+	cniConfigInfo, err := fs.Stat(cniConfig)
 	if err != nil {
 		return fmt.Errorf("error accessing CNI config %s: %v", cniConfig, err)
 	}
 	if cniConfigInfo.IsDir() {
-		return fmt.Errorf("CNI config cannot be a directory")
+		if _, err = cniConfigPaths(fs, cniConfig); err != nil {
+			return fmt.Errorf("invalid CNI config directory: %v", err)
+		}
 	}
 
 	return nil
 }
 
-// copyFiles() copies the CNI binaries and config to the installation directory
+// copyFiles() copies the CNI binaries and configs to the installation directory. The CNI config values are substituted
+// into the configs, which are then validated, and the configs of a previous configuration are removed.
 func (cni *cniOptions) copyFiles() error {
 	// Read C:\source\cni\
-	files, err := ioutil.ReadDir(cni.dir)
+	files, err := cni.fileSystem().ReadDir(cni.dir)
 	if err != nil {
 		return fmt.Errorf("error reading CNI dir %s: %v", cni.dir, err)
 	}
@@ -760,28 +1386,60 @@ func (cni *cniOptions) copyFiles() error {
 		// C:\source\cni\filename
 		src := filepath.Join(cni.dir, file.Name())
 		// C:\k\cni\filename
-		dest := filepath.Join(cni.binDir, file.Name())
-		if err = copyFile(src, dest); err != nil {
+		dest := filepath.Join(cni.layout.binDir, file.Name())
+		if err = cni.journal.fileWritten(dest); err != nil {
+			return err
+		}
+		if err = copyFile(cni.fileSystem(), src, dest); err != nil {
 			return fmt.Errorf("error copying %s --> %s: %v", src, dest, err)
 		}
 	}
 
-	// Copy the CNI config to the CNI configuration directory. Example: C:\k\cni\config\cni.conf
-	cniConfigDest := filepath.Join(cni.confDir, filepath.Base(cni.config))
-	if err = copyFile(cni.config, cniConfigDest); err != nil {
-		return fmt.Errorf("error copying CNI config %s --> %s: %v", cni.config, cniConfigDest, err)
+	data, err := cni.templateData()
+	if err != nil {
+		return fmt.Errorf("error getting CNI config values: %v", err)
+	}
+	configs, err := loadCNIConfigs(cni.fileSystem(), cni.config, data)
+	if err != nil {
+		return newError(ErrCNIInvalid, err)
+	}
+	if err = removeStaleCNIConfigs(cni.fileSystem(), cni.journal, cni.layout.confDir, configs); err != nil {
+		return err
+	}
+	// Write the CNI configs to the CNI configuration directory. Example: C:\k\cni\config\cni.conf
+	for i, config := range configs {
+		cniConfigDest := filepath.Join(cni.layout.confDir, filepath.Base(config.path))
+		if err = cni.journal.fileWritten(cniConfigDest); err != nil {
+			return err
+		}
+		// The kubelet only uses the primary config, which is the first one
+		if i == 0 && cni.networkName != "" {
+			if err = writeCNIConfigWithNetwork(cni.fileSystem(), config.contents, cniConfigDest,
+				cni.networkName); err != nil {
+				return fmt.Errorf("error writing CNI config %s --> %s: %v", config.path, cniConfigDest, err)
+			}
+			continue
+		}
+		if err = writeFile(cni.fileSystem(), cniConfigDest, config.contents, 0644); err != nil {
+			return fmt.Errorf("error writing CNI config %s --> %s: %v", config.path, cniConfigDest, err)
+		}
 	}
 	return nil
 }
 
+// fileSystem returns the file system the CNI files are read and written through
+func (cni *cniOptions) fileSystem() FileSystem {
+	return orOSFileSystem(cni.fs)
+}
+
 // ensureDirIsPresent ensures that CNI parent and child directories are present on the system
 func (cni *cniOptions) ensureDirIsPresent() error {
 	// By checking for the config directory, we can ensure both parent and child directories are present
-	configDir := filepath.Join(cni.k8sInstallDir, cniConfigDirName)
-	if _, err := os.Stat(configDir); err != nil {
+	configDir := cni.layout.confDir
+	if _, err := cni.fileSystem().Stat(configDir); err != nil {
 		if os.IsNotExist(err) {
 			// 0700 == Only user has access
-			if err = os.MkdirAll(configDir, 0700); err != nil {
+			if err = cni.fileSystem().MkdirAll(configDir, 0700); err != nil {
 				return err
 			}
 		} else {
@@ -863,7 +1521,8 @@ func reconstructKubeletCmd(kubeletKeyValueArgs map[string]string) (string, error
 	return kubeletCmd, nil
 }
 
-// updateKubeletArgs updates the given kubelet command with the CNI args.
+// updateKubeletArgs updates the given kubelet command with the CNI args. The resolv.conf set using SetDNS replaces the
+// empty one.
 // Example: --resolv-conf="" --network-plugin=cni --cni-bin-dir=C:\k\cni --cni-conf-dir=c:\k\cni\config
 func (cni *cniOptions) updateKubeletArgs(kubeletCmd *string) error {
 	if kubeletCmd == nil {
@@ -877,9 +1536,19 @@ func (cni *cniOptions) updateKubeletArgs(kubeletCmd *string) error {
 
 	// Add or replace the CNI CLI args
 	kubeletKeyValueArgs[resolvOption] = resolvValue
-	kubeletKeyValueArgs[networkPluginOption] = networkPluginValue
-	kubeletKeyValueArgs[cniBinDirOption] = cni.binDir
-	kubeletKeyValueArgs[cniConfDirOption] = cni.confDir
+	if cni.resolvConf != "" {
+		kubeletKeyValueArgs[resolvOption] = cni.resolvConf
+	}
+	if cni.layout.kubeletFlags {
+		kubeletKeyValueArgs[networkPluginOption] = networkPluginValue
+		kubeletKeyValueArgs[cniBinDirOption] = cni.layout.binDir
+		kubeletKeyValueArgs[cniConfDirOption] = cni.layout.confDir
+	} else {
+		// The CNI directories are read from the containerd config, and the flags may remain from the dockershim
+		for _, option := range []string{networkPluginOption, cniBinDirOption, cniConfDirOption} {
+			delete(kubeletKeyValueArgs, option)
+		}
+	}
 
 	if *kubeletCmd, err = reconstructKubeletCmd(kubeletKeyValueArgs); err != nil {
 		return fmt.Errorf("unable to reconstruct kubelet command %v: %v", kubeletKeyValueArgs, err)
@@ -892,11 +1561,11 @@ func (cni *cniOptions) updateKubeletArgs(kubeletCmd *string) error {
 // arguments. Updating and restarting the kubelet service is outside of its purview.
 func (cni *cniOptions) configure(kubeletCmd *string) error {
 	if err := cni.ensureDirIsPresent(); err != nil {
-		return fmt.Errorf("unable to create CNI directory %s: %v", filepath.Join(cni.dir, cniConfigDirName), err)
+		return fmt.Errorf("unable to create CNI directory %s: %v", cni.layout.confDir, err)
 	}
 
 	if err := cni.copyFiles(); err != nil {
-		return fmt.Errorf("unable to copy CNI files: %v", err)
+		return fmt.Errorf("unable to copy CNI files: %w", err)
 	}
 
 	if err := cni.updateKubeletArgs(kubeletCmd); err != nil {
@@ -908,18 +1577,20 @@ func (cni *cniOptions) configure(kubeletCmd *string) error {
 
 // updateKubeletDependents updates the dependents field of the kubeletService struct
 // to reflect current list of dependent services. This function assumes that the kubelet service is running
-func updateKubeletDependents(svcMgr *mgr.Mgr) ([]*mgr.Service, error) {
-	var dependents []*mgr.Service
-	// If there is already a kubelet service running, find it
-	dependentSvc, err := svcMgr.OpenService(kubeletDependentSvc)
-	if err != nil {
-		// Do not return error if the services are not installed.
-		if !strings.Contains(err.Error(), "service does not exist") {
-			return nil, fmt.Errorf("error getting dependent services for kubelet %v", err)
+func updateKubeletDependents(svcMgr serviceManager) ([]service, error) {
+	var dependents []service
+	for _, dependentSvcName := range []string{kubeletDependentSvc, kubeProxyServiceName, cloudNodeManagerServiceName} {
+		// If there is already a dependent service running, find it
+		dependentSvc, err := svcMgr.OpenService(dependentSvcName)
+		if err != nil {
+			// Do not return error if the services are not installed.
+			if !errors.Is(err, errServiceDoesNotExist) {
+				return nil, fmt.Errorf("error getting dependent services for kubelet %v", err)
+			}
+		}
+		if dependentSvc != nil {
+			dependents = append(dependents, dependentSvc)
 		}
-	}
-	if dependentSvc != nil {
-		dependents = append(dependents, dependentSvc)
 	}
 	return dependents, nil
 }
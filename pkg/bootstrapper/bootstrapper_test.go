@@ -1,15 +1,41 @@
 package bootstrapper
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	logger "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/ignition"
 )
 
 //cniTest holds the location of the directories and files required for running some of the CNI tests
@@ -34,7 +60,7 @@ func initCNITestFramework() error {
 		return fmt.Errorf("error creating temp directories and files: %v", err)
 	}
 
-	cniTest.cni, err = newCNIOptions(cniTest.k8sInstallDir, cniTest.dir, cniTest.config)
+	cniTest.cni, err = newCNIOptions(osFileSystem, cniTest.k8sInstallDir, "", cniTest.dir, cniTest.config)
 	if err != nil {
 		return fmt.Errorf("error initializing CNI options: %v", err)
 	}
@@ -70,12 +96,13 @@ func createFilesAndDirsRequiredForTests() error {
 		return fmt.Errorf("error creating temp CNI config directory: %v", err)
 	}
 
-	// Create temp CNI config file
-	cniConfig, err := ioutil.TempFile(cniConfigPath, "cni.conf")
+	// Create CNI config file
+	cniTest.config = filepath.Join(cniConfigPath, "cni.conf")
+	err = ioutil.WriteFile(cniTest.config, []byte(`{"cniVersion":"0.2.0","name":"OpenShiftNetwork",`+
+		`"type":"win-overlay","ipam":{"type":"host-local","subnet":"10.132.1.0/24"}}`), 0644)
 	if err != nil {
 		return fmt.Errorf("error creating CNI config: %v", err)
 	}
-	cniTest.config = cniConfig.Name()
 	return nil
 }
 
@@ -161,7 +188,8 @@ func TestCreateKubeletConf(t *testing.T) {
 		in []byte
 	}
 	instDir := `C:\k`
-	err := os.MkdirAll(instDir, 0755)
+	fs := newMemFS()
+	err := fs.MkdirAll(instDir, 0755)
 	require.NoError(t, err, "error creating install directory")
 
 	tests := []struct {
@@ -171,12 +199,12 @@ func TestCreateKubeletConf(t *testing.T) {
 	}{
 		{
 			name: "Base case",
-			want: []byte(`{"kind":"KubeletConfiguration","apiVersion":"kubelet.config.k8s.io/v1beta1","rotateCertificates":true,"serverTLSBootstrap":true,"authentication":{"x509":{"clientCAFile":"C:\\k\\kubelet-ca.crt "},"anonymous":{"enabled":false}},"clusterDomain":"cluster.local","clusterDNS":["172.30.0.10"],"cgroupsPerQOS":false,"runtimeRequestTimeout":"10m0s","maxPods":250,"kubeAPIQPS":50,"kubeAPIBurst":100,"serializeImagePulls":false,"featureGates":{"LegacyNodeRoleBehavior":false,"NodeDisruptionExclusion":true,"RotateKubeletServerCertificate":true,"SCTPSupport":true,"ServiceNodeExclusion":true,"SupportPodPidsLimit":true},"containerLogMaxSize":"50Mi","systemReserved":{"cpu":"500m","ephemeral-storage":"1Gi","memory":"1Gi"},"enforceNodeAllocatable":[]}`),
+			want: []byte(`{"kind":"KubeletConfiguration","apiVersion":"kubelet.config.k8s.io/v1beta1","rotateCertificates":true,"serverTLSBootstrap":true,"authentication":{"x509":{"clientCAFile":"C:\\k\\kubelet-ca.crt "},"anonymous":{"enabled":false}},"clusterDomain":"cluster.local","clusterDNS":["172.30.0.10"],"cgroupsPerQOS":false,"runtimeRequestTimeout":"10m0s","maxPods":250,"kubeAPIQPS":50,"kubeAPIBurst":100,"serializeImagePulls":false,"featureGates":{"LegacyNodeRoleBehavior":false,"NodeDisruptionExclusion":true,"RotateKubeletServerCertificate":true,"SCTPSupport":true,"ServiceNodeExclusion":true,"SupportPodPidsLimit":true},"containerLogMaxSize":"50Mi","evictionHard":{"imagefs.available":"10%","memory.available":"500Mi","nodefs.available":"10%"},"imageGCHighThresholdPercent":75,"imageGCLowThresholdPercent":65,"systemReserved":{"cpu":"500m","ephemeral-storage":"1Gi","memory":"1Gi"},"enforceNodeAllocatable":[]}`),
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			bs := winNodeBootstrapper{installDir: instDir}
+			bs := winNodeBootstrapper{installDir: instDir, fs: fs}
 			got, err := bs.createKubeletConf()
 			assert.NoError(t, err)
 			assert.Equalf(t, tt.want, got, "got = %v, want %v", string(got), string(tt.want))
@@ -184,6 +212,41 @@ func TestCreateKubeletConf(t *testing.T) {
 	}
 }
 
+// TestKubeletConfigOverrides tests that the user provided overrides are merged into the generated kubelet
+// configuration
+func TestKubeletConfigOverrides(t *testing.T) {
+	_, err := parseKubeletConfigOverrides([]byte("- maxPods"))
+	require.Error(t, err, "no error thrown for overrides that are not an object")
+	_, err = parseKubeletConfigOverrides([]byte("kind: KubeProxyConfiguration"))
+	require.Error(t, err, "no error thrown for overriding the kind")
+
+	overrides, err := parseKubeletConfigOverrides([]byte(`
+maxPods: 100
+evictionHard:
+  memory.available: 500Mi
+systemReserved:
+  memory: 2Gi
+`))
+	require.NoError(t, err, "error parsing valid overrides")
+	instDir := `C:\k`
+	fs := newMemFS()
+	require.NoError(t, fs.MkdirAll(instDir, 0755), "error creating install directory")
+	bs := winNodeBootstrapper{installDir: instDir, kubeletConfigOverrides: overrides, fs: fs}
+	got, err := bs.createKubeletConf()
+	require.NoError(t, err, "error creating kubelet configuration")
+
+	var config map[string]interface{}
+	require.NoError(t, json.Unmarshal(got, &config), "error parsing kubelet configuration")
+	assert.Equal(t, "KubeletConfiguration", config["kind"])
+	assert.Equal(t, float64(100), config["maxPods"])
+	// The fields of the overridden objects that are not overridden are expected to be retained
+	assert.Equal(t, map[string]interface{}{"imagefs.available": "10%", "memory.available": "500Mi",
+		"nodefs.available": "10%"}, config["evictionHard"])
+	assert.Equal(t, map[string]interface{}{"cpu": "500m", "ephemeral-storage": "1Gi", "memory": "2Gi"},
+		config["systemReserved"])
+	assert.Equal(t, false, config["cgroupsPerQOS"])
+}
+
 // TestCloudConfExtraction tests if parseIgnitionFileContents can extract the cloud.conf present in a worker ignition
 // file contents and the resulting file is in the expected format with a set of key value pairs.
 // It also confirms the "--cloud-config" option constructed by WMCB is as expected. Example cloud.conf:
@@ -339,6 +402,53 @@ func TestCloudConfInvalidNames(t *testing.T) {
 	assert.Error(t, err, "error not thrown on encountering invalid --cloud-config option")
 }
 
+// TestParseIgnitionConfig tests that parseIgnitionConfig can parse ignition files of the supported spec versions into
+// the same v3.1 layout, and returns an error for the unsupported ones
+func TestParseIgnitionConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		wantErr  bool
+	}{
+		{
+			name:     "spec v2.2",
+			contents: `{"ignition":{"version":"2.2.0"},"storage":{"files":[{"filesystem":"root","path":"/etc/kubernetes/kubelet-ca.crt","contents":{"source":"data:,dummy"},"mode":420}]}}`,
+		},
+		{
+			name:     "spec v3.0",
+			contents: `{"ignition":{"version":"3.0.0"},"storage":{"files":[{"path":"/etc/kubernetes/kubelet-ca.crt","contents":{"source":"data:,dummy"},"mode":420}]}}`,
+		},
+		{
+			name:     "spec v3.1",
+			contents: `{"ignition":{"version":"3.1.0"},"storage":{"files":[{"path":"/etc/kubernetes/kubelet-ca.crt","contents":{"source":"data:,dummy"},"mode":420}]}}`,
+		},
+		{
+			name:     "unsupported spec version",
+			contents: `{"ignition":{"version":"4.0.0"}}`,
+			wantErr:  true,
+		},
+		{
+			name:     "invalid json",
+			contents: `{"ignition":`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parseIgnitionConfig([]byte(tt.contents))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, config.Storage.Files, 1)
+			assert.Equal(t, "/etc/kubernetes/kubelet-ca.crt", config.Storage.Files[0].Node.Path)
+			require.NotNil(t, config.Storage.Files[0].Contents.Source)
+			assert.Equal(t, "data:,dummy", *config.Storage.Files[0].Contents.Source)
+		})
+	}
+}
+
 // TestNewWinNodeBootstrapperWithInvalidCNIInputs tests if NewWinNodeBootstrapper returns the expected error on passing
 // invalid CNI inputs
 func TestNewWinNodeBootstrapperWithInvalidCNIInputs(t *testing.T) {
@@ -351,14 +461,183 @@ func TestNewWinNodeBootstrapperWithInvalidCNIInputs(t *testing.T) {
 	assert.Contains(t, err.Error(), "both cniDir and cniConfig need to be populated", "incorrect error thrown")
 }
 
-// TestWinNodeBootstrapperConfigureWithInvalidInputs tests if Configure returns the expected error when CNI inputs
-// are not present
-func TestWinNodeBootstrapperConfigureWithInvalidInputs(t *testing.T) {
-	wnb, err := NewWinNodeBootstrapper("", "", "", "", "")
-	require.NoError(t, err, "error instantiating bootstrapper")
-	err = wnb.Configure()
-	require.Error(t, err, "no error thrown when Configure is called with no CNI inputs")
-	assert.Contains(t, err.Error(), "cannot configure without required plugin inputs")
+// TestNewWithInvalidContainerRuntimeInputs tests if New returns the expected error on passing invalid container
+// runtime inputs
+func TestNewWithInvalidContainerRuntimeInputs(t *testing.T) {
+	_, err := New(WithContainerRuntime("cri-o", ""))
+	require.Error(t, err, "no error thrown when an unsupported container runtime is given")
+	assert.Contains(t, err.Error(), "unsupported container runtime", "incorrect error thrown")
+
+	_, err = New(WithContainerRuntime(dockerRuntime, "C:\\something"))
+	require.Error(t, err, "no error thrown when containerdDir is given with the docker runtime")
+	assert.Contains(t, err.Error(), "containerdDir can only be used with the containerd runtime",
+		"incorrect error thrown")
+}
+
+// TestGetInitialKubeletArgs tests that the kubelet args are set according to the configured container runtime
+func TestGetInitialKubeletArgs(t *testing.T) {
+	tests := []struct {
+		name         string
+		runtime      string
+		wantArgs     []string
+		unwantedArgs []string
+	}{
+		{
+			name:         "docker runtime",
+			runtime:      dockerRuntime,
+			wantArgs:     []string{"--image-pull-progress-deadline=30m"},
+			unwantedArgs: []string{"--container-runtime=remote", "--container-runtime-endpoint=" + containerdEndpoint},
+		},
+		{
+			name:         "containerd runtime",
+			runtime:      containerdRuntime,
+			wantArgs:     []string{"--container-runtime=remote", "--container-runtime-endpoint=" + containerdEndpoint},
+			unwantedArgs: []string{"--image-pull-progress-deadline=30m"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wmcb := winNodeBootstrapper{installDir: "C:\\k", containerRuntime: tt.runtime}
+			args := wmcb.getInitialKubeletArgs()
+			for _, arg := range tt.wantArgs {
+				assert.Contains(t, args, arg)
+			}
+			for _, arg := range tt.unwantedArgs {
+				assert.NotContains(t, args, arg)
+			}
+		})
+	}
+}
+
+// TestSetKubeletArgs tests that the kubelet arguments set by the user override or are added to the kubelet args
+func TestSetKubeletArgs(t *testing.T) {
+	wmcb := winNodeBootstrapper{installDir: "C:\\k", kubeletArgs: map[string]string{"v": "3"},
+		kubeletArgOverrides: make(map[string]string)}
+
+	err := wmcb.SetKubeletArgs(map[string]string{"--v": "5", "max-pods": "100", "node-ip": "10.0.0.1"})
+	require.NoError(t, err, "error setting valid kubelet args")
+	args := wmcb.getInitialKubeletArgs()
+	assert.Contains(t, args, "--v=5")
+	assert.NotContains(t, args, "--v=3")
+	// The appended arguments are expected to be sorted and at the end of the args
+	assert.Equal(t, []string{"--max-pods=100", "--node-ip=10.0.0.1"}, args[len(args)-2:])
+
+	err = wmcb.SetKubeletArgs(map[string]string{"--": "value"})
+	require.Error(t, err, "no error thrown for an empty kubelet arg name")
+	assert.Contains(t, err.Error(), "invalid kubelet argument name")
+
+	err = wmcb.SetKubeletArgs(map[string]string{"node-labels": "a=b c=d"})
+	require.Error(t, err, "no error thrown for a kubelet arg value with spaces")
+	assert.Contains(t, err.Error(), "spaces are not allowed")
+}
+
+// TestSetNodeLabelsAndTaints tests that the user provided node labels and taints are validated and added to the
+// Windows node label and taint in the kubelet args
+func TestSetNodeLabelsAndTaints(t *testing.T) {
+	wmcb := winNodeBootstrapper{installDir: "C:\\k"}
+
+	err := wmcb.SetNodeLabels(map[string]string{"topology.kubernetes.io/zone": "us-east-1a", "pool": "win"})
+	require.NoError(t, err, "error setting valid node labels")
+	err = wmcb.SetNodeTaints([]string{"dedicated=gpu:NoSchedule", "spot:PreferNoSchedule"})
+	require.NoError(t, err, "error setting valid node taints")
+	args := wmcb.getInitialKubeletArgs()
+	assert.Contains(t, args, "--node-labels="+nodeLabel+",pool=win,topology.kubernetes.io/zone=us-east-1a")
+	assert.Contains(t, args, "--register-with-taints="+windowsTaints+",dedicated=gpu:NoSchedule,spot:PreferNoSchedule")
+
+	invalidLabels := []map[string]string{
+		{"invalid key": "value"},
+		{"key": "invalid/value"},
+		{"/key": "value"},
+	}
+	for _, labels := range invalidLabels {
+		assert.Error(t, wmcb.SetNodeLabels(labels), "no error thrown for invalid node labels %v", labels)
+	}
+
+	invalidTaints := []string{"key=value", "key=value:Invalid", "invalid key=value:NoSchedule",
+		"key=invalid/value:NoExecute", "key=value:NoSchedule:NoExecute"}
+	for _, taint := range invalidTaints {
+		assert.Error(t, wmcb.SetNodeTaints([]string{taint}), "no error thrown for invalid node taint %s", taint)
+	}
+}
+
+// TestCredentialProviders tests that the image credential provider plugins are validated and configured
+func TestCredentialProviders(t *testing.T) {
+	pluginDir, err := ioutil.TempDir("", "plugins")
+	require.NoError(t, err, "error creating plugin directory")
+	defer os.RemoveAll(pluginDir)
+	ecrPlugin := filepath.Join(pluginDir, "ecr-credential-provider.exe")
+	acrPlugin := filepath.Join(pluginDir, "acr-credential-provider.exe")
+	unsupportedPlugin := filepath.Join(pluginDir, "foo-credential-provider.exe")
+	for _, plugin := range []string{ecrPlugin, acrPlugin, unsupportedPlugin} {
+		require.NoError(t, ioutil.WriteFile(plugin, []byte("plugin"), 0644), "error creating plugin")
+	}
+
+	wmcb := winNodeBootstrapper{installDir: "C:\\k", kubeletArgs: make(map[string]string)}
+	assert.Empty(t, wmcb.credentialProviderArgs(), "credential provider args set without any plugins")
+
+	err = wmcb.SetImageCredentialProviders([]string{unsupportedPlugin})
+	require.Error(t, err, "no error thrown for an unsupported plugin")
+	assert.Contains(t, err.Error(), "unsupported image credential provider foo-credential-provider")
+	err = wmcb.SetImageCredentialProviders([]string{filepath.Join(pluginDir, "gcr-credential-provider.exe")})
+	require.Error(t, err, "no error thrown for a missing plugin")
+
+	require.NoError(t, wmcb.SetImageCredentialProviders([]string{ecrPlugin, acrPlugin}),
+		"error setting valid plugins")
+	_, err = wmcb.createCredentialProviderConf()
+	require.Error(t, err, "no error thrown for acr-credential-provider without the cloud config")
+
+	wmcb.kubeletArgs[cloudConfigOption] = "C:\\k\\cloud.conf"
+	got, err := wmcb.createCredentialProviderConf()
+	require.NoError(t, err, "error creating credential provider configuration")
+	var config credentialProviderConfig
+	require.NoError(t, json.Unmarshal(got, &config), "error parsing credential provider configuration")
+	assert.Equal(t, "CredentialProviderConfig", config.Kind)
+	require.Len(t, config.Providers, 2)
+	assert.Equal(t, "ecr-credential-provider", config.Providers[0].Name)
+	assert.Contains(t, config.Providers[0].MatchImages, "*.dkr.ecr.*.amazonaws.com")
+	assert.Empty(t, config.Providers[0].Args)
+	assert.Equal(t, "acr-credential-provider", config.Providers[1].Name)
+	assert.Equal(t, []string{"C:\\k\\cloud.conf"}, config.Providers[1].Args)
+
+	args := wmcb.getInitialKubeletArgs()
+	assert.Contains(t, args, "--image-credential-provider-config="+filepath.Join("C:\\k", credentialProviderConfigName))
+	assert.Contains(t, args, "--image-credential-provider-bin-dir="+filepath.Join("C:\\k", credentialProviderDirName))
+	assert.Contains(t, args, "--feature-gates=KubeletCredentialProviders=true")
+}
+
+// TestHostSecurityCommands tests that the host security commands open the required firewall ports and exclude the
+// node components from Windows Defender
+func TestHostSecurityCommands(t *testing.T) {
+	wmcb := winNodeBootstrapper{installDir: "C:\\k", logDir: "C:\\var\\log\\kubelet"}
+	commands, err := wmcb.ConfigureHostSecurity(true)
+	require.NoError(t, err, "error getting dry run commands")
+	require.Len(t, commands, len(hostFirewallRules)+1)
+	assert.Contains(t, commands[0], "-DisplayName ContainerLogsPort")
+	assert.Contains(t, commands[0], "-Protocol TCP -LocalPort 10250")
+	assert.Contains(t, commands[1], "-Protocol UDP -LocalPort 4789")
+	assert.Contains(t, commands[2], "-Protocol TCP -LocalPort 10256")
+	assert.Contains(t, commands[3], "-ExclusionPath 'C:\\k','C:\\var\\log\\kubelet'")
+	assert.Contains(t, commands[3], "'"+filepath.Join("C:\\k", "kubelet.exe")+"'")
+
+	assert.Equal(t, "'it''s'", psList([]string{"it's"}), "single quotes are not escaped")
+}
+
+// TestCreateContainerdConf tests that the containerd configuration is populated with the WMCB specific values
+func TestCreateContainerdConf(t *testing.T) {
+	installDir, err := ioutil.TempDir("", "containerd")
+	require.NoError(t, err, "error creating install directory")
+	defer os.RemoveAll(installDir)
+
+	wmcb := winNodeBootstrapper{installDir: installDir}
+	require.NoError(t, os.MkdirAll(wmcb.containerdInstallDir(), 0755), "error creating containerd directory")
+
+	got, err := wmcb.createContainerdConf()
+	require.NoError(t, err, "error creating containerd configuration")
+	conf := string(got)
+	assert.Contains(t, conf, "sandbox_image = '"+kubeletPauseContainerImage+"'")
+	assert.Contains(t, conf, "address = '"+containerdPipeAddress+"'")
+	assert.Contains(t, conf, "bin_dir = '"+filepath.Join(installDir, cniDirName)+"'")
+	assert.Contains(t, conf, "conf_dir = '"+filepath.Join(installDir, cniConfigDirName)+"'")
 }
 
 // TestDeconstructKubeletCmd tests deconstructKubeletCmd() with valid and invalid inputs
@@ -456,39 +735,44 @@ func testCNIEnsureDirIsPresent(t *testing.T) {
 // testCheckCNIInputs tests if checkCNIInputs returns the expected errors on passing invalid inputs
 func testCheckCNIInputs(t *testing.T) {
 	t.Run("bad install dir", func(t *testing.T) {
-		err := checkCNIInputs("C:\\DoesNotExist", "", "")
+		err := checkCNIInputs(osFileSystem, "C:\\DoesNotExist", "", "")
 		assert.Error(t, err, "no error on passing bad install dir")
 		assert.Contains(t, err.Error(), "error accessing install directory", "incorrect error thrown")
 	})
 
 	t.Run("bad CNI dir", func(t *testing.T) {
-		err := checkCNIInputs(cniTest.k8sInstallDir, "C:\\DoesNotExist", "")
+		err := checkCNIInputs(osFileSystem, cniTest.k8sInstallDir, "C:\\DoesNotExist", "")
 		assert.Error(t, err, "no error on passing bad CNI dir")
 		assert.Contains(t, err.Error(), "error accessing CNI dir", "incorrect error thrown")
 	})
 
 	// We are using the test config file here instead of creating a new file.
 	t.Run("CNI dir as file", func(t *testing.T) {
-		err := checkCNIInputs(cniTest.k8sInstallDir, cniTest.config, "")
+		err := checkCNIInputs(osFileSystem, cniTest.k8sInstallDir, cniTest.config, "")
 		assert.Error(t, err, "no error on passing file as CNI dir")
 		assert.Contains(t, err.Error(), "CNI dir cannot be a file", "incorrect error thrown")
 	})
 
 	t.Run("bad CNI config", func(t *testing.T) {
-		err := checkCNIInputs(cniTest.k8sInstallDir, cniTest.dir, "C:\\DoesNotExist.conf")
+		err := checkCNIInputs(osFileSystem, cniTest.k8sInstallDir, cniTest.dir, "C:\\DoesNotExist.conf")
 		assert.Error(t, err, "no error on passing bad CNI config")
 		assert.Contains(t, err.Error(), "error accessing CNI config", "incorrect error thrown")
 	})
 
-	t.Run("CNI config as directory", func(t *testing.T) {
-		err := checkCNIInputs(cniTest.k8sInstallDir, cniTest.dir, cniTest.dir)
-		assert.Error(t, err, "no error on passing dir as CNI config")
-		assert.Contains(t, err.Error(), "CNI config cannot be a directory", "incorrect error thrown")
+	t.Run("CNI config directory without CNI configs", func(t *testing.T) {
+		err := checkCNIInputs(osFileSystem, cniTest.k8sInstallDir, cniTest.dir, cniTest.dir)
+		assert.Error(t, err, "no error on passing dir without CNI configs as CNI config")
+		assert.Contains(t, err.Error(), "no CNI config files", "incorrect error thrown")
+	})
+
+	t.Run("CNI config directory", func(t *testing.T) {
+		err := checkCNIInputs(osFileSystem, cniTest.k8sInstallDir, cniTest.dir, filepath.Dir(cniTest.config))
+		assert.NoError(t, err, "error on passing dir with a CNI config as CNI config")
 	})
 
 	t.Run("no files in CNI directory", func(t *testing.T) {
 		emptyCNIDir, err := ioutil.TempDir(cniTest.k8sInstallDir, "cni")
-		err = checkCNIInputs(cniTest.k8sInstallDir, emptyCNIDir, cniTest.config)
+		err = checkCNIInputs(osFileSystem, cniTest.k8sInstallDir, emptyCNIDir, cniTest.config)
 		assert.Error(t, err, "no error on passing empty CNI dir")
 		assert.Contains(t, err.Error(), "no files present", "incorrect error thrown")
 	})
@@ -508,9 +792,9 @@ func checkKubeletCmd(t *testing.T, kubeletCmd string, cni *cniOptions) {
 	assert.True(t, strings.HasPrefix(kubeletCmd, "c:\\k\\kubelet.exe"), "kubelet.exe missing in kubelet args")
 	assert.Contains(t, kubeletCmd, " --resolv-conf=\"\"", "--resolv-conf missing in kubelet args")
 	assert.Contains(t, kubeletCmd, " --network-plugin=cni", "--network-plugin missing in kubelet args")
-	assert.Contains(t, kubeletCmd, " --cni-bin-dir="+cni.binDir, "--cni-bin-dir missing in kubelet args")
-	assert.Contains(t, kubeletCmd, " --cni-conf-dir="+cni.confDir, "--cni-conf-dir missing in kubelet args")
-	assert.NotContains(t, kubeletCmd, " --cni-conf-dir="+cni.confDir+"cni.conf", "cni.conf present in kubelet args")
+	assert.Contains(t, kubeletCmd, " --cni-bin-dir="+cni.layout.binDir, "--cni-bin-dir missing in kubelet args")
+	assert.Contains(t, kubeletCmd, " --cni-conf-dir="+cni.layout.confDir, "--cni-conf-dir missing in kubelet args")
+	assert.NotContains(t, kubeletCmd, " --cni-conf-dir="+cni.layout.confDir+"cni.conf", "cni.conf present in kubelet args")
 }
 
 // testCNIUpdateKubeletArgs tests if updateKubeletArgsForCNI() updates the kubelet arguments correctly
@@ -555,24 +839,1465 @@ func testCNIUpdateKubeletArgs(t *testing.T) {
 	})
 }
 
-// TestKubeletDirectoriesCreation tests if the directories needed for Kubelet are initialized as required
-func TestKubeletDirectoriesCreation(t *testing.T) {
-	// Create a temp directory with wmcb prefix
+// TestNewKubeProxyOptions tests that newKubeProxyOptions validates the kube-proxy inputs
+func TestNewKubeProxyOptions(t *testing.T) {
+	kubeProxy, err := ioutil.TempFile("", "kube-proxy*.exe")
+	require.NoError(t, err, "error creating kube-proxy file")
+	kubeProxy.Close()
+	defer os.Remove(kubeProxy.Name())
+
+	tests := []struct {
+		name        string
+		path        string
+		clusterCIDR string
+		networkName string
+		sourceVIP   string
+		wantErr     string
+	}{
+		{"valid inputs", kubeProxy.Name(), "10.132.0.0/14", "OVNKubernetesHybridOverlayNetwork", "10.132.1.2", ""},
+		{"valid inputs without source VIP", kubeProxy.Name(), "10.132.0.0/14", "l2bridge", "", ""},
+		{"kube-proxy not present", "C:\\DoesNotExist.exe", "10.132.0.0/14", "l2bridge", "", "unable to find kube-proxy"},
+		{"invalid cluster CIDR", kubeProxy.Name(), "10.132.0.0", "l2bridge", "", "invalid cluster CIDR"},
+		{"empty network name", kubeProxy.Name(), "10.132.0.0/14", "", "", "network name cannot be empty"},
+		{"invalid source VIP", kubeProxy.Name(), "10.132.0.0/14", "l2bridge", "10.132.1", "invalid source VIP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newKubeProxyOptions(osFileSystem, tt.path, tt.clusterCIDR, tt.networkName, tt.sourceVIP)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+// TestCreateKubeProxyConf tests that the kube-proxy configuration is populated with the WMCB specific values
+func TestCreateKubeProxyConf(t *testing.T) {
+	installDir, err := ioutil.TempDir("", "kube-proxy")
+	require.NoError(t, err, "error creating install directory")
+	defer os.RemoveAll(installDir)
+
+	wmcb := winNodeBootstrapper{installDir: installDir, kubeconfigPath: "C:\\k\\kubeconfig"}
+	got, err := wmcb.createKubeProxyConf(&kubeProxyOptions{clusterCIDR: "10.132.0.0/14", networkName: "l2bridge",
+		sourceVIP: "10.132.1.2"})
+	require.NoError(t, err, "error creating kube-proxy configuration")
+	assert.Equal(t, `{"kind":"KubeProxyConfiguration","apiVersion":"kubeproxy.config.k8s.io/v1alpha1",`+
+		`"clientConnection":{"kubeconfig":"C:\\k\\kubeconfig"},"clusterCIDR":"10.132.0.0/14","mode":"kernelspace",`+
+		`"winkernel":{"networkName":"l2bridge","sourceVip":"10.132.1.2","enableDSR":false},`+
+		`"featureGates":{"WinDSR":false,"WinOverlay":true}}`, string(got))
+
+	// DSR is enabled on the Windows builds that support it
+	wmcb.windowsBuild, err = getWindowsBuild(20348)
+	require.NoError(t, err, "error getting Windows build")
+	got, err = wmcb.createKubeProxyConf(&kubeProxyOptions{clusterCIDR: "10.132.0.0/14", networkName: "l2bridge"})
+	require.NoError(t, err, "error creating kube-proxy configuration")
+	assert.Contains(t, string(got), `"enableDSR":true},"featureGates":{"WinDSR":true,"WinOverlay":true}}`)
+}
+
+// TestLoadCNIConfigs tests that the CNI configs in a directory are validated and returned in lexical order, and that
+// conflicting configs are rejected
+func TestLoadCNIConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cni")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(dir)
+
+	writeConfigs := func(configs map[string]string) string {
+		configDir, err := ioutil.TempDir(dir, "config")
+		require.NoError(t, err, "error creating CNI config directory")
+		for name, contents := range configs {
+			require.NoError(t, ioutil.WriteFile(filepath.Join(configDir, name), []byte(contents), 0644))
+		}
+		return configDir
+	}
+	overlay := `{"cniVersion":"0.2.0","name":"OpenShiftNetwork","type":"win-overlay",` +
+		`"ipam":{"type":"host-local","subnet":"10.132.1.0/24"},` +
+		`"policies":[{"name":"EndpointPolicy","value":{"Type":"OutBoundNAT"}}]}`
+	bridgeList := `{"cniVersion":"0.3.1","name":"l2bridge","plugins":[` +
+		`{"type":"win-bridge","ipam":{"type":"host-local","subnet":"10.132.2.0/24"}},{"type":"portmap"}]}`
+
+	configDir := writeConfigs(map[string]string{"20-bridge.conflist": bridgeList, "10-overlay.conf": overlay,
+		"README.md": "not a CNI config"})
+	configs, err := loadCNIConfigs(osFileSystem, configDir, nil)
+	require.NoError(t, err, "error loading CNI configs")
+	assert.Equal(t, []cniConfigFile{
+		{path: filepath.Join(configDir, "10-overlay.conf"), contents: []byte(overlay), networkName: "OpenShiftNetwork"},
+		{path: filepath.Join(configDir, "20-bridge.conflist"), contents: []byte(bridgeList), networkName: "l2bridge"},
+	}, configs)
+
+	tests := []struct {
+		name    string
+		configs map[string]string
+		err     string
+	}{
+		{"no CNI configs", map[string]string{"README.md": "not a CNI config"}, "no CNI config files"},
+		{"invalid JSON", map[string]string{"cni.conf": "{"}, "error parsing JSON"},
+		{"missing cniVersion", map[string]string{"cni.conf": `{"name":"net","type":"win-overlay"}`},
+			"cniVersion is missing"},
+		{"missing IPAM", map[string]string{"cni.conf": `{"cniVersion":"0.2.0","name":"net","type":"win-overlay"}`},
+			"IPAM type of the win-overlay plugin is missing"},
+		{"config list without plugins", map[string]string{"cni.conflist": `{"cniVersion":"0.3.1","name":"net"}`},
+			"config list has no plugins"},
+		{"policy without value", map[string]string{"cni.conf": `{"cniVersion":"0.2.0","name":"net",` +
+			`"type":"win-bridge","ipam":{"type":"host-local"},"policies":[{"name":"EndpointPolicy"}]}`},
+			"policy without a name or value"},
+		{"same network", map[string]string{"10-overlay.conf": overlay, "20-overlay.json": overlay},
+			"both are for the OpenShiftNetwork network"},
+		{"same name", map[string]string{"cni.conf": overlay, "cni.conflist": bridgeList},
+			"names only differ in the extension"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadCNIConfigs(osFileSystem, writeConfigs(tt.configs), nil)
+			require.Error(t, err, "no error thrown")
+			assert.Contains(t, err.Error(), tt.err, "incorrect error thrown")
+		})
+	}
+
+	// A single CNI config needs an extension the kubelet loads it with
+	configPath := filepath.Join(dir, "cni.config")
+	require.NoError(t, ioutil.WriteFile(configPath, []byte(overlay), 0644))
+	_, err = loadCNIConfigs(osFileSystem, configPath, nil)
+	assert.Error(t, err, "no error thrown for a CNI config without a CNI config extension")
+
+	// The CNI configs of a previous configuration are removed
+	confDir := writeConfigs(map[string]string{"00-stale.conf": overlay, "10-overlay.conf": overlay,
+		"cni.log": "not a CNI config"})
+	require.NoError(t, removeStaleCNIConfigs(osFileSystem, nil, confDir, configs))
+	assert.NoFileExists(t, filepath.Join(confDir, "00-stale.conf"), "stale CNI config was not removed")
+	assert.FileExists(t, filepath.Join(confDir, "10-overlay.conf"), "current CNI config was removed")
+	assert.FileExists(t, filepath.Join(confDir, "cni.log"), "file that is not a CNI config was removed")
+}
+
+// TestWriteCNIConfigWithNetwork tests that the network name in the CNI config is replaced with the given network name
+// while the rest of the config is retained
+func TestWriteCNIConfigWithNetwork(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cni")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(dir)
+
+	config := []byte(`{"cniVersion":"0.2.0","name":"OpenShiftNetwork","type":"win-overlay",` +
+		`"ipam":{"type":"host-local","subnet":"10.132.1.0/24"}}`)
+	dest := filepath.Join(dir, "cni-dest.conf")
+	require.NoError(t, writeCNIConfigWithNetwork(osFileSystem, config, dest, hybridOverlayNetworkName))
+
+	content, err := ioutil.ReadFile(dest)
+	require.NoError(t, err, "error reading CNI config")
+	var cniConfig map[string]interface{}
+	require.NoError(t, json.Unmarshal(content, &cniConfig), "error parsing CNI config")
+	assert.Equal(t, hybridOverlayNetworkName, cniConfig["name"])
+	assert.Equal(t, "win-overlay", cniConfig["type"])
+	assert.Equal(t, map[string]interface{}{"type": "host-local", "subnet": "10.132.1.0/24"}, cniConfig["ipam"])
+
+	err = writeCNIConfigWithNetwork(osFileSystem, []byte("{"), dest, hybridOverlayNetworkName)
+	assert.Error(t, err, "no error thrown when the CNI config is invalid")
+}
+
+// TestCNIConfigTemplate tests that the CNI config values are substituted into the CNI config templates, and that a
+// template referring to a value that is not known fails to render
+func TestCNIConfigTemplate(t *testing.T) {
+	wmcb := &winNodeBootstrapper{}
+	assert.Error(t, wmcb.SetCNIConfigValues("", "", "", "", ""), "no error thrown without CNI")
+
+	wmcb.cni = &cniOptions{networkName: hybridOverlayNetworkName}
+	assert.Error(t, wmcb.SetCNIConfigValues("172.30.0.0", "", "", "", ""), "no error thrown for an invalid CIDR")
+	assert.Error(t, wmcb.SetCNIConfigValues("", "", "10.132.1.", "", ""), "no error thrown for an invalid IP")
+	require.NoError(t, wmcb.SetCNIConfigValues("172.30.0.0/16", "10.132.0.0/14", "10.132.1.2", "", ""))
+
+	dir, err := ioutil.TempDir("", "cni")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(dir)
+	wmcb.kubeletConfPath = filepath.Join(dir, "kubelet.conf")
+	require.NoError(t, wmcb.setCNIConfigDefaults(), "error when the kubelet configuration is not present")
+	assert.Empty(t, wmcb.cni.values.dnsServerIP, "DNS server IP set without the kubelet configuration")
+	require.NoError(t, ioutil.WriteFile(wmcb.kubeletConfPath,
+		[]byte(`{"kind":"KubeletConfiguration","clusterDNS":["172.30.0.10"]}`), 0644))
+	require.NoError(t, wmcb.setCNIConfigDefaults())
+	assert.Equal(t, "172.30.0.10", wmcb.cni.values.dnsServerIP, "DNS server IP not taken from the kubelet config")
+
+	data, err := wmcb.cni.templateData()
+	require.NoError(t, err)
+	config, err := renderCNIConfig("cni.conf", []byte(`{"name":"{{.NetworkName}}","dns":{"Nameservers":`+
+		`["{{.DNSServerIP}}"]},"policies":[{"name":"EndpointPolicy","value":{"Type":"OutBoundNAT","ExceptionList":`+
+		`["{{.ClusterCIDR}}","{{.ServiceCIDR}}"]}},{"name":"EndpointPolicy","value":{"Type":"ROUTE",`+
+		`"DestinationPrefix":"{{.ServiceCIDR}}","NeedEncap":true}}],"sourceVip":"{{.SourceVIP}}"}`), data)
+	require.NoError(t, err, "error rendering CNI config template")
+	assert.Equal(t, `{"name":"`+hybridOverlayNetworkName+`","dns":{"Nameservers":["172.30.0.10"]},"policies":`+
+		`[{"name":"EndpointPolicy","value":{"Type":"OutBoundNAT","ExceptionList":["10.132.0.0/14","172.30.0.0/16"]}},`+
+		`{"name":"EndpointPolicy","value":{"Type":"ROUTE","DestinationPrefix":"172.30.0.0/16","NeedEncap":true}}],`+
+		`"sourceVip":"10.132.1.2"}`, string(config))
+
+	// The network name is escaped as it is not validated
+	require.NoError(t, wmcb.SetCNIConfigValues("", "", "", "172.30.0.10", `my "network"`))
+	data, err = wmcb.cni.templateData()
+	require.NoError(t, err)
+	config, err = renderCNIConfig("cni.conf", []byte(`{"name":"{{.NetworkName}}"}`), data)
+	require.NoError(t, err, "error rendering CNI config template")
+	assert.Equal(t, `{"name":"my \"network\""}`, string(config))
+
+	_, err = renderCNIConfig("cni.conf", []byte(`{"subnet":"{{.ServiceCIDR}}"}`), data)
+	assert.Error(t, err, "no error thrown for a value that is not known")
+	_, err = renderCNIConfig("cni.conf", []byte(`{"subnet":"{{.ServiceCIDR"}`), data)
+	assert.Error(t, err, "no error thrown for an invalid template")
+}
+
+// TestKubeletServerCertExpiry tests that the expiry of the kubelet serving certificate is read from the file containing
+// both the certificate and the private key, and that the kubelet certificates are issued once both the client and
+// serving certificates are present
+func TestKubeletServerCertExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pki")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(dir)
+
+	_, err = kubeletServerCertExpiry(osFileSystem, dir)
+	assert.Error(t, err, "no error thrown when the certificate does not exist")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err, "error generating private key")
+	notAfter := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "system:node:winnode"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err, "error creating certificate")
+
+	certPath := filepath.Join(dir, kubeletServerCertName)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, ioutil.WriteFile(certPath, keyPEM, 0600), "error writing key")
+	_, err = kubeletServerCertExpiry(osFileSystem, dir)
+	assert.Error(t, err, "no error thrown when the file does not contain a certificate")
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
+	require.NoError(t, ioutil.WriteFile(certPath, append(certPEM, keyPEM...), 0600), "error writing certificate")
+	expiry, err := kubeletServerCertExpiry(osFileSystem, dir)
+	require.NoError(t, err)
+	assert.True(t, notAfter.Equal(expiry), "expected expiry %v, got %v", notAfter, expiry)
+
+	// Both the client and serving certificates need to be issued for the kubelet certificates to be ready
+	assert.False(t, kubeletCertsIssued(osFileSystem, dir, time.Now()), "kubelet certificates issued without a client certificate")
+	clientCertPath := filepath.Join(dir, kubeletClientCertName)
+	require.NoError(t, ioutil.WriteFile(clientCertPath, append(certPEM, keyPEM...), 0600), "error writing certificate")
+	assert.True(t, kubeletCertsIssued(osFileSystem, dir, time.Now()), "kubelet certificates not issued")
+}
+
+// TestStatus tests that the outcome of the latest attempt of each phase is written to and read from the status file
+func TestStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "status")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(dir)
+
+	_, err = ReadStatus(dir)
+	assert.Error(t, err, "no error thrown when the status file does not exist")
+
+	status := &Status{}
+	now := time.Now().UTC().Truncate(time.Second)
+	status.record(PhaseFilesWritten, now, 2*time.Second, nil)
+	status.record(PhaseServiceCreated, now, time.Second, fmt.Errorf("access denied"))
+	status.recordRestart(KubeletServiceName)
+	require.NoError(t, writeStatus(osFileSystem, dir, status), "error writing status")
+
+	status, err = ReadStatus(dir)
+	require.NoError(t, err, "error reading status")
+	assert.Equal(t, []PhaseStatus{
+		{Phase: PhaseFilesWritten, Timestamp: now, Succeeded: true, Attempts: 1, DurationSeconds: 2},
+		{Phase: PhaseServiceCreated, Timestamp: now, Succeeded: false, Error: "access denied", Attempts: 1,
+			DurationSeconds: 1},
+	}, status.Phases)
+	assert.Equal(t, map[string]int{KubeletServiceName: 1}, status.ServiceRestarts)
+
+	// A retried phase replaces the previous attempt without changing the order of the phases
+	later := now.Add(time.Minute)
+	status.record(PhaseServiceCreated, later, 3*time.Second, nil)
+	status.record(PhaseKubeletStarted, later, 0, nil)
+	status.recordRestart(KubeletServiceName)
+	assert.Equal(t, []PhaseStatus{
+		{Phase: PhaseFilesWritten, Timestamp: now, Succeeded: true, Attempts: 1, DurationSeconds: 2},
+		{Phase: PhaseServiceCreated, Timestamp: later, Succeeded: true, Attempts: 2, DurationSeconds: 3},
+		{Phase: PhaseKubeletStarted, Timestamp: later, Succeeded: true, Attempts: 1},
+	}, status.Phases)
+	assert.Equal(t, map[string]int{KubeletServiceName: 2}, status.ServiceRestarts)
+}
+
+// TestMetrics tests that the status is written as Prometheus metrics in the text format
+func TestMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(dir)
+
+	assert.Error(t, SetMetricsFile(filepath.Join(dir, "wmcb.txt")), "no error thrown for a file without .prom extension")
+
+	status := &Status{}
+	status.record(PhaseFilesWritten, time.Unix(100, 0), 1500*time.Millisecond, nil)
+	status.record(PhaseServiceCreated, time.Unix(200, 0), time.Second, fmt.Errorf("access denied"))
+	status.record(PhaseServiceCreated, time.Unix(300, 0), time.Second, fmt.Errorf("access denied"))
+	status.recordRestart(kubeProxyServiceName)
+	status.recordRestart(KubeletServiceName)
+
+	path := filepath.Join(dir, "textfile_inputs", "wmcb.prom")
+	require.NoError(t, writeMetrics(osFileSystem, path, status), "error writing metrics")
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err, "error reading metrics")
+	assert.Equal(t, `# HELP wmcb_phase_duration_seconds Time the latest attempt of the phase took.
+# TYPE wmcb_phase_duration_seconds gauge
+wmcb_phase_duration_seconds{phase="FilesWritten"} 1.5
+wmcb_phase_duration_seconds{phase="ServiceCreated"} 1
+# HELP wmcb_phase_attempts Number of times the phase has been attempted.
+# TYPE wmcb_phase_attempts gauge
+wmcb_phase_attempts{phase="FilesWritten"} 1
+wmcb_phase_attempts{phase="ServiceCreated"} 2
+# HELP wmcb_phase_succeeded Whether the latest attempt of the phase succeeded.
+# TYPE wmcb_phase_succeeded gauge
+wmcb_phase_succeeded{phase="FilesWritten"} 1
+wmcb_phase_succeeded{phase="ServiceCreated"} 0
+# HELP wmcb_phase_timestamp_seconds Time at which the latest attempt of the phase completed or failed.
+# TYPE wmcb_phase_timestamp_seconds gauge
+wmcb_phase_timestamp_seconds{phase="FilesWritten"} 100
+wmcb_phase_timestamp_seconds{phase="ServiceCreated"} 300
+# HELP wmcb_service_restarts Number of times the Windows service has been restarted by WMCB.
+# TYPE wmcb_service_restarts gauge
+wmcb_service_restarts{service="kube-proxy"} 1
+wmcb_service_restarts{service="kubelet"} 1
+# HELP wmcb_bootstrap_succeeded Whether every phase of the latest bootstrap succeeded.
+# TYPE wmcb_bootstrap_succeeded gauge
+wmcb_bootstrap_succeeded 0
+# HELP wmcb_bootstrap_timestamp_seconds Time at which the latest bootstrap phase completed or failed.
+# TYPE wmcb_bootstrap_timestamp_seconds gauge
+wmcb_bootstrap_timestamp_seconds 300
+`, string(contents))
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err), "temporary metrics file not removed")
+}
+
+// TestWriteKubeletFile tests that kubelet files are only rewritten, and the kubelet marked for a restart, if their
+// contents differ
+func TestWriteKubeletFile(t *testing.T) {
 	dir, err := ioutil.TempDir("", "wmcb")
 	require.NoError(t, err, "error creating temp directory")
-	// Ignore the return error as there is not much we can do if the temporary directory is not deleted
 	defer os.RemoveAll(dir)
-	// podManifestDirectory which has to be created by wmcb.
-	podManifestDirectory := filepath.Join(dir, "etc", "kubernetes", "manifests")
-	// logDirectory which has to be created by wmcb
-	logDirectory := filepath.Join(dir, "log")
-	wnb := winNodeBootstrapper{
-		installDir:  dir,
-		logDir:      logDirectory,
-		kubeletArgs: make(map[string]string),
+
+	path := filepath.Join(dir, "kubelet.conf")
+	wnb := winNodeBootstrapper{installDir: dir}
+	require.NoError(t, wnb.writeKubeletFile(path, []byte("maxPods: 250")))
+	assert.True(t, wnb.kubeletRestartRequired, "restart not required after creating the file")
+
+	wnb.kubeletRestartRequired = false
+	require.NoError(t, wnb.writeKubeletFile(path, []byte("maxPods: 250")))
+	assert.False(t, wnb.kubeletRestartRequired, "restart required when the contents did not change")
+
+	require.NoError(t, wnb.writeKubeletFile(path, []byte("maxPods: 100")))
+	assert.True(t, wnb.kubeletRestartRequired, "restart not required after the contents changed")
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err, "error reading file")
+	assert.Equal(t, "maxPods: 100", string(contents))
+}
+
+// TestParseProxyEnv tests that the proxy settings are parsed from the systemd configuration in the ignition file
+func TestParseProxyEnv(t *testing.T) {
+	contents := "[Manager]\nDefaultEnvironment=HTTP_PROXY=http://proxy.example.com:3128 " +
+		"\"HTTPS_PROXY=http://proxy.example.com:3129\" NO_PROXY=.cluster.local,10.0.0.0/16\n"
+	proxy := parseProxyEnv([]byte(contents))
+	assert.Equal(t, proxyConfig{
+		httpProxy:  "http://proxy.example.com:3128",
+		httpsProxy: "http://proxy.example.com:3129",
+		noProxy:    ".cluster.local,10.0.0.0/16",
+	}, proxy)
+	assert.Equal(t, []string{"HTTP_PROXY=http://proxy.example.com:3128", "HTTPS_PROXY=http://proxy.example.com:3129",
+		"NO_PROXY=.cluster.local,10.0.0.0/16"}, proxy.environment())
+	assert.Equal(t, []string{"winhttp", "set", "proxy",
+		"proxy-server=http=proxy.example.com:3128;https=proxy.example.com:3129",
+		"bypass-list=.cluster.local;10.0.0.0/16"}, proxy.winHTTPProxyArgs())
+
+	// Settings given to the bootstrapper take precedence over the ones in the ignition file
+	override := proxyConfig{httpProxy: "http://other.example.com:8080"}
+	override.merge(proxy)
+	assert.Equal(t, "http://other.example.com:8080", override.httpProxy)
+	assert.Equal(t, "http://proxy.example.com:3129", override.httpsProxy)
+
+	assert.True(t, parseProxyEnv([]byte("[Manager]\n")).isEmpty(), "proxy found in config without proxy settings")
+}
+
+// TestGetWindowsBuild tests that the build specific defaults are returned for the supported Windows builds only
+func TestGetWindowsBuild(t *testing.T) {
+	build, err := getWindowsBuild(17763)
+	require.NoError(t, err)
+	assert.Equal(t, "1809", build.name)
+	assert.Equal(t, kubeletPauseContainerImage, build.pauseImage)
+	assert.False(t, build.enableDSR, "DSR enabled on 1809")
+
+	build, err = getWindowsBuild(20348)
+	require.NoError(t, err)
+	assert.Equal(t, "2022", build.name)
+	assert.Equal(t, "mcr.microsoft.com/oss/kubernetes/pause:3.6", build.pauseImage)
+
+	_, err = getWindowsBuild(14393)
+	assert.Error(t, err, "no error thrown for unsupported Windows Server 2016 build")
+
+	// The default pause image is used if the build has not been detected
+	wnb := winNodeBootstrapper{}
+	assert.Equal(t, kubeletPauseContainerImage, wnb.pauseImage())
+	wnb.windowsBuild = build
+	assert.Equal(t, "mcr.microsoft.com/oss/kubernetes/pause:3.6", wnb.pauseImage())
+}
+
+// TestNewArtifacts tests that the artifacts bundle is verified against its manifest
+func TestNewArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "cni"), os.ModePerm), "error creating cni directory")
+	files := map[string]string{"kubelet.exe": "kubelet", "cni/win-overlay.exe": "win-overlay"}
+	manifest := ""
+	for name, contents := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(contents), 0644))
+		sum := sha256.Sum256([]byte(contents))
+		manifest += hex.EncodeToString(sum[:]) + "  " + name + "\n"
+	}
+	writeManifest := func(contents string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, artifactsManifestName), []byte(contents), 0644))
 	}
-	err = wnb.initializeKubeletFiles()
-	assert.NoError(t, err, "error initializing kubelet files")
-	assert.DirExists(t, podManifestDirectory, "pod manifest directory was not created")
-	assert.DirExists(t, logDirectory, "log directory was not created")
+
+	_, err = NewArtifacts(dir)
+	assert.Error(t, err, "no error thrown when the manifest is missing")
+
+	writeManifest(manifest)
+	artifacts, err := NewArtifacts(dir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "kubelet.exe"), artifacts.KubeletPath())
+	assert.Equal(t, filepath.Join(dir, "cni"), artifacts.CNIDir())
+	assert.Equal(t, "", artifacts.HybridOverlayPath())
+	assert.Equal(t, "", artifacts.PauseImagePath())
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "pause.tar"), []byte("pause"), 0644))
+	_, err = NewArtifacts(dir)
+	assert.Error(t, err, "no error thrown for an artifact that is not listed in the manifest")
+	require.NoError(t, os.Remove(filepath.Join(dir, "pause.tar")))
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "kubelet.exe"), []byte("tampered"), 0644))
+	_, err = NewArtifacts(dir)
+	assert.Error(t, err, "no error thrown for a checksum mismatch")
+
+	writeManifest(strings.Repeat("0", 64) + "  ../kubelet.exe\n")
+	_, err = NewArtifacts(dir)
+	assert.Error(t, err, "no error thrown for an artifact outside the artifacts dir")
+}
+
+func TestFetcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fetcher")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(dir)
+
+	var archive bytes.Buffer
+	gzipWriter := gzip.NewWriter(&archive)
+	tarWriter := tar.NewWriter(gzipWriter)
+	plugin := []byte("win-overlay")
+	require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: "./win-overlay.exe", Mode: 0755,
+		Size: int64(len(plugin)), Typeflag: tar.TypeReg}))
+	_, err = tarWriter.Write(plugin)
+	require.NoError(t, err)
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, gzipWriter.Close())
+
+	files := map[string][]byte{"/release/kubelet.exe": []byte("kubelet"), "/release/cni.tgz": archive.Bytes()}
+	var ranges []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contents, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(contents))
+	}))
+	defer server.Close()
+	checksum := func(contents []byte) string {
+		sum := sha256.Sum256(contents)
+		return hex.EncodeToString(sum[:])
+	}
+
+	_, err = NewFetcher(dir, "", []string{"http://mirror.example.com"})
+	assert.Error(t, err, "no error thrown for a mirror that does not use HTTPS")
+
+	// The mirror does not have the artifacts, so they are downloaded from the original URL
+	fetcher, err := NewFetcher(dir, "", []string{server.URL + "/mirror"})
+	require.NoError(t, err)
+	fetcher.client = server.Client()
+
+	_, err = fetcher.Fetch(context.Background(), "http"+strings.TrimPrefix(server.URL, "https")+
+		"/release/kubelet.exe", checksum(files["/release/kubelet.exe"]))
+	assert.Error(t, err, "no error thrown for a URL that does not use HTTPS")
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/release/kubelet.exe", "invalid")
+	assert.Error(t, err, "no error thrown for an invalid checksum")
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/release/kubelet.exe", strings.Repeat("0", 64))
+	assert.Error(t, err, "no error thrown for a checksum mismatch")
+
+	// An interrupted download is resumed
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "kubelet.exe"+partialDownloadSuffix), []byte("kube"), 0644))
+	ranges = nil
+	kubeletPath, err := fetcher.Fetch(context.Background(), server.URL+"/release/kubelet.exe",
+		checksum(files["/release/kubelet.exe"]))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "kubelet.exe"), kubeletPath)
+	assert.Equal(t, []string{"bytes=4-"}, ranges)
+	contents, err := ioutil.ReadFile(kubeletPath)
+	require.NoError(t, err)
+	assert.Equal(t, files["/release/kubelet.exe"], contents)
+
+	// An artifact that is already downloaded is not downloaded again
+	ranges = nil
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/release/kubelet.exe",
+		checksum(files["/release/kubelet.exe"]))
+	require.NoError(t, err)
+	assert.Empty(t, ranges)
+
+	cniDir, err := fetcher.FetchArchive(context.Background(), server.URL+"/release/cni.tgz",
+		checksum(files["/release/cni.tgz"]))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "cni"), cniDir)
+	contents, err = ioutil.ReadFile(filepath.Join(cniDir, "win-overlay.exe"))
+	require.NoError(t, err)
+	assert.Equal(t, plugin, contents)
+
+	_, err = archiveEntryPath(dir, "../kubelet.exe")
+	assert.Error(t, err, "no error thrown for an archive entry outside the archive")
+}
+
+// TestBootstrapToken tests that the bootstrap kubeconfig is generated from the API server URL, CA bundle and token
+func TestBootstrapToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bootstrap")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err, "error generating private key")
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kube-apiserver-lb-signer"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err, "error creating certificate")
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
+	caBundlePath := filepath.Join(dir, "ca.crt")
+	require.NoError(t, ioutil.WriteFile(caBundlePath, caBundle, 0644))
+	invalidCAPath := filepath.Join(dir, "invalid.crt")
+	require.NoError(t, ioutil.WriteFile(invalidCAPath, []byte("invalid"), 0644))
+
+	apiServer := "https://api-int.example.com:6443"
+	token := "abcdef.0123456789abcdef"
+	wmcb := winNodeBootstrapper{installDir: dir}
+	assert.Error(t, wmcb.SetBootstrapToken("http://api-int.example.com:6443", caBundlePath, token, ""),
+		"no error thrown for an API server URL that does not use HTTPS")
+	assert.Error(t, wmcb.SetBootstrapToken(apiServer, caBundlePath, "", ""), "no error thrown for an empty token")
+	assert.Error(t, wmcb.SetBootstrapToken(apiServer, invalidCAPath, token, ""), "no error thrown for an invalid CA")
+	assert.Error(t, wmcb.SetBootstrapToken(apiServer, caBundlePath, token, invalidCAPath),
+		"no error thrown for an invalid kubelet CA")
+	assert.Nil(t, wmcb.bootstrapCredentials, "bootstrap credentials set despite the invalid inputs")
+
+	require.NoError(t, wmcb.SetBootstrapToken(apiServer, caBundlePath, token, ""))
+	assert.Equal(t, caBundle, wmcb.bootstrapCredentials.kubeletCA, "CA bundle not used as the kubelet CA")
+	contents, err := wmcb.createBootstrapKubeconfig()
+	require.NoError(t, err, "error generating bootstrap kubeconfig")
+	var config kubeconfig
+	require.NoError(t, json.Unmarshal(contents, &config), "error parsing bootstrap kubeconfig")
+	require.Len(t, config.Clusters, 1)
+	assert.Equal(t, apiServer, config.Clusters[0].Cluster.Server)
+	assert.Equal(t, caBundle, config.Clusters[0].Cluster.CertificateAuthorityData)
+	require.Len(t, config.Users, 1)
+	assert.Equal(t, token, config.Users[0].User.Token)
+	require.Len(t, config.Contexts, 1)
+	assert.Equal(t, config.CurrentContext, config.Contexts[0].Name)
+}
+
+// TestIgnitionURL tests that the worker ignition is fetched from the machine config server if its URL is set or if the
+// ignition file is a stub ignition, and that other ignition files are read as is
+func TestIgnitionURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ignition")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(dir)
+
+	workerIgnition := []byte(`{"ignition":{"version":"3.1.0"},"storage":{"files":[` +
+		`{"path":"/etc/kubernetes/kubelet-ca.crt","contents":{"source":"data:,kubelet-ca"}}]}}`)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config/worker" || r.Header.Get("Accept") != ignition.AcceptHeader {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(workerIgnition)
+	}))
+	defer server.Close()
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	caBundlePath := filepath.Join(dir, "root-ca.crt")
+	require.NoError(t, ioutil.WriteFile(caBundlePath, caBundle, 0644))
+	ignitionURL := server.URL + "/config/worker"
+
+	wmcb := winNodeBootstrapper{}
+	assert.Error(t, wmcb.SetIgnitionURL("http://api-int.example.com:22623/config/worker", caBundlePath),
+		"no error thrown for an ignition URL that does not use HTTPS")
+	assert.Error(t, wmcb.SetIgnitionURL(ignitionURL, filepath.Join(dir, "missing.crt")),
+		"no error thrown for a missing CA bundle")
+	require.NoError(t, wmcb.SetIgnitionURL(ignitionURL, caBundlePath))
+	contents, err := wmcb.readIgnition(context.Background())
+	require.NoError(t, err, "error fetching ignition")
+	assert.Equal(t, workerIgnition, contents)
+
+	stub := fmt.Sprintf(`{"ignition":{"version":"3.1.0","config":{"merge":[{"source":%q}]},`+
+		`"security":{"tls":{"certificateAuthorities":[{"source":"data:text/plain;charset=utf-8;base64,%s"}]}}}}`,
+		ignitionURL, base64.StdEncoding.EncodeToString(caBundle))
+	stubPath := filepath.Join(dir, "worker-stub.ign")
+	require.NoError(t, ioutil.WriteFile(stubPath, []byte(stub), 0644))
+	wmcb = winNodeBootstrapper{ignitionFilePath: stubPath}
+	contents, err = wmcb.readIgnition(context.Background())
+	require.NoError(t, err, "error fetching ignition referenced by stub ignition")
+	assert.Equal(t, workerIgnition, contents)
+
+	// The stub ignition needs to embed the machine config server CA
+	stub = fmt.Sprintf(`{"ignition":{"version":"3.1.0","config":{"merge":[{"source":%q}]}}}`, ignitionURL)
+	require.NoError(t, ioutil.WriteFile(stubPath, []byte(stub), 0644))
+	_, err = wmcb.readIgnition(context.Background())
+	assert.Error(t, err, "no error thrown for a stub ignition without a CA")
+
+	ignitionPath := filepath.Join(dir, "worker.ign")
+	require.NoError(t, ioutil.WriteFile(ignitionPath, workerIgnition, 0644))
+	wmcb = winNodeBootstrapper{ignitionFilePath: ignitionPath}
+	contents, err = wmcb.readIgnition(context.Background())
+	require.NoError(t, err, "error reading ignition file")
+	assert.Equal(t, workerIgnition, contents)
+}
+
+// TestServiceRecovery tests that SetServiceRecovery validates the recovery settings and that the services are restarted
+// on failure only if it is enabled
+func TestServiceRecovery(t *testing.T) {
+	recovery := defaultServiceRecovery()
+	assert.Equal(t, []recoveryAction{{Type: serviceRestart, Delay: 5 * time.Second}}, recovery.actions())
+
+	wmcb := winNodeBootstrapper{serviceRecovery: recovery}
+	assert.Error(t, wmcb.SetServiceRecovery(true, -time.Second, time.Minute), "no error thrown for a negative delay")
+	assert.Error(t, wmcb.SetServiceRecovery(true, time.Second, 0), "no error thrown for an empty reset period")
+	assert.Equal(t, recovery, wmcb.serviceRecovery, "recovery settings changed by invalid settings")
+
+	require.NoError(t, wmcb.SetServiceRecovery(true, 30*time.Second, time.Hour))
+	assert.Equal(t, []recoveryAction{{Type: serviceRestart, Delay: 30 * time.Second}},
+		wmcb.serviceRecovery.actions())
+	assert.Equal(t, time.Hour, wmcb.serviceRecovery.resetPeriod)
+
+	require.NoError(t, wmcb.SetServiceRecovery(false, 30*time.Second, time.Hour))
+	assert.Empty(t, wmcb.serviceRecovery.actions(), "recovery actions set with restart on failure disabled")
+
+	assert.Error(t, wmcb.UpdateServiceRecovery([]string{"containerd"}), "no error thrown for an unsupported service")
+}
+
+// TestIsEvictable tests that the mirror and DaemonSet pods are not evicted when the node is drained
+func TestIsEvictable(t *testing.T) {
+	controller := true
+	tests := []struct {
+		name      string
+		pod       corev1.Pod
+		evictable bool
+	}{
+		{"pod without owner", corev1.Pod{}, true},
+		{"ReplicaSet pod", corev1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{
+			{Kind: "ReplicaSet", Name: "app", Controller: &controller}}}}, true},
+		{"DaemonSet pod", corev1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{
+			{Kind: "DaemonSet", Name: "node-exporter", Controller: &controller}}}}, false},
+		{"DaemonSet that is not the controller", corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet", Name: "node-exporter"}}}}, true},
+		{"mirror pod", corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{mirrorPodAnnotation: "hash"}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.evictable, isEvictable(&tt.pod))
+		})
+	}
+}
+
+// TestMustGatherArchive tests that the collected diagnostics are written to the archive and that the diagnostics that
+// could not be collected are listed in it
+func TestMustGatherArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "must-gather")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(dir)
+
+	logDir := filepath.Join(dir, "log", "kubelet")
+	require.NoError(t, os.MkdirAll(filepath.Join(logDir, "old"), os.ModePerm))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(logDir, "kubelet.log"), []byte("started"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(logDir, "old", "kubelet.log"), []byte("stopped"), 0644))
+
+	dest := filepath.Join(dir, "must-gather.zip")
+	file, err := os.Create(dest)
+	require.NoError(t, err, "error creating archive")
+	archive := &mustGatherArchive{fs: osFileSystem, zip: zip.NewWriter(file)}
+	archive.addDir("logs/kubelet", logDir)
+	// The logs of services that have not been configured are skipped
+	archive.addDir("logs/kube-proxy", filepath.Join(dir, "log", "kube-proxy"))
+	archive.addFile("config/kubelet.conf", filepath.Join(dir, "kubelet.conf"))
+	archive.addJSON("hns/networks.json", []string{"OVNKubernetesHybridOverlayNetwork"}, nil)
+	archive.addJSON("hns/endpoints.json", nil, fmt.Errorf("access denied"))
+	require.NoError(t, archive.close(), "error closing archive")
+	require.NoError(t, file.Close())
+
+	reader, err := zip.OpenReader(dest)
+	require.NoError(t, err, "error opening archive")
+	defer reader.Close()
+	contents := make(map[string]string)
+	for _, f := range reader.File {
+		r, err := f.Open()
+		require.NoError(t, err, "error opening %s", f.Name)
+		content, err := ioutil.ReadAll(r)
+		r.Close()
+		require.NoError(t, err, "error reading %s", f.Name)
+		contents[f.Name] = string(content)
+	}
+	require.Len(t, contents, 4)
+	assert.Equal(t, "started", contents["logs/kubelet/kubelet.log"])
+	assert.Equal(t, "stopped", contents["logs/kubelet/old/kubelet.log"])
+	assert.JSONEq(t, `["OVNKubernetesHybridOverlayNetwork"]`, contents["hns/networks.json"])
+	errors := strings.Split(strings.TrimSpace(contents[mustGatherErrorsFile]), "\n")
+	require.Len(t, errors, 2)
+	assert.True(t, strings.HasPrefix(errors[0], "config/kubelet.conf: "), "unexpected error %s", errors[0])
+	assert.Equal(t, "hns/endpoints.json: access denied", errors[1])
+}
+
+// TestPreflightEndpoints tests that the API server checked by the preflight checks defaults to the host of the machine
+// config server, and that the no proxy list is matched against it
+func TestPreflightEndpoints(t *testing.T) {
+	wmcb := winNodeBootstrapper{}
+	mcsURL, apiServerURL, err := wmcb.preflightEndpoints("")
+	require.NoError(t, err)
+	assert.Nil(t, mcsURL, "machine config server found without ignition")
+	assert.Nil(t, apiServerURL, "API server found without ignition")
+
+	wmcb.ignitionEndpoint = &ignitionEndpoint{url: "https://api-int.cluster.example.com:22623/config/worker"}
+	mcsURL, apiServerURL, err = wmcb.preflightEndpoints("")
+	require.NoError(t, err)
+	assert.Equal(t, "api-int.cluster.example.com:22623", mcsURL.Host)
+	assert.Equal(t, "https://api-int.cluster.example.com:6443", apiServerURL.String())
+
+	_, apiServerURL, err = wmcb.preflightEndpoints("https://api.cluster.example.com:6443")
+	require.NoError(t, err)
+	assert.Equal(t, "api.cluster.example.com:6443", apiServerURL.Host, "given API server not used")
+	_, _, err = wmcb.preflightEndpoints("http://api.cluster.example.com:6443")
+	assert.Error(t, err, "no error thrown for an HTTP API server")
+
+	proxy := proxyConfig{noProxy: ".cluster.example.com,10.0.0.0/16,internal:8080"}
+	assert.True(t, proxy.bypasses("api-int.cluster.example.com"), "subdomain proxied")
+	assert.True(t, proxy.bypasses("10.0.3.4"), "address in CIDR proxied")
+	assert.True(t, proxy.bypasses("internal"), "host with port proxied")
+	assert.False(t, proxy.bypasses("api-int.other.example.com"), "other domain not proxied")
+	assert.False(t, proxy.bypasses("10.1.3.4"), "address outside CIDR not proxied")
+	assert.True(t, proxyConfig{noProxy: "*"}.bypasses("api-int.other.example.com"), "wildcard proxied")
+}
+
+// TestDryRun tests that the files are recorded instead of being written in dry-run mode
+func TestDryRun(t *testing.T) {
+	installDir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(installDir)
+	cniDir, err := ioutil.TempDir("", "cni")
+	require.NoError(t, err, "error creating temp CNI directory")
+	defer os.RemoveAll(cniDir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(cniDir, "win-overlay.exe"), []byte("plugin"), 0644))
+	cniConfig := filepath.Join(installDir, "cni.conf")
+	require.NoError(t, ioutil.WriteFile(cniConfig, []byte(`{"cniVersion":"0.2.0","name":"OpenShiftNetwork",`+
+		`"type":"win-overlay","ipam":{"type":"host-local","subnet":"10.132.1.0/24"}}`), 0644))
+
+	wmcb := winNodeBootstrapper{installDir: installDir}
+	assert.Nil(t, wmcb.DryRunPlan(), "plan returned without the dry-run mode")
+	wmcb.SetDryRun()
+	kubeletConf := filepath.Join(installDir, "kubelet.conf")
+	require.NoError(t, wmcb.writeKubeletFile(kubeletConf, []byte("{}")))
+	assert.NoFileExists(t, kubeletConf, "file written in dry-run mode")
+	require.NoError(t, ioutil.WriteFile(kubeletConf, []byte("{}"), 0644))
+	require.NoError(t, wmcb.writeKubeletFile(kubeletConf, []byte("{}")))
+	require.NoError(t, wmcb.mkdirAll(filepath.Join(installDir, "log")))
+	assert.NoDirExists(t, filepath.Join(installDir, "log"), "directory made in dry-run mode")
+	assert.False(t, wmcb.kubeletRestartRequired, "kubelet restart required in dry-run mode")
+
+	wmcb.cni, err = newCNIOptions(osFileSystem, installDir, "", cniDir, cniConfig)
+	require.NoError(t, err)
+	wmcb.cni.networkName = hybridOverlayNetworkName
+	require.NoError(t, wmcb.cni.plan(wmcb.DryRunPlan()))
+	assert.NoDirExists(t, wmcb.cni.layout.binDir, "CNI dir made in dry-run mode")
+
+	plan := wmcb.DryRunPlan()
+	require.Len(t, plan.Files, 4)
+	assert.Equal(t, PlannedFile{Path: kubeletConf, Size: 2}, plan.Files[0])
+	assert.True(t, plan.Files[1].Unchanged, "file with the same contents not reported as unchanged")
+	assert.Equal(t, PlannedFile{Path: filepath.Join(wmcb.cni.layout.binDir, "win-overlay.exe"),
+		Source: filepath.Join(cniDir, "win-overlay.exe"), Size: 6}, plan.Files[2])
+	assert.Equal(t, filepath.Join(wmcb.cni.layout.confDir, "cni.conf"), plan.Files[3].Path)
+	primary, err := cniConfigWithNetwork([]byte(`{"cniVersion":"0.2.0","name":"OpenShiftNetwork",`+
+		`"type":"win-overlay","ipam":{"type":"host-local","subnet":"10.132.1.0/24"}}`), hybridOverlayNetworkName)
+	require.NoError(t, err)
+	assert.Equal(t, len(primary), plan.Files[3].Size, "network name not set in the primary CNI config")
+	assert.Empty(t, plan.Actions, "stale CNI configs removed without a CNI conf dir")
+}
+
+// TestJournal tests that the files written and removed are recorded in the journal, and restored when rolled back
+func TestJournal(t *testing.T) {
+	installDir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(installDir)
+
+	existing := filepath.Join(installDir, "kubelet.conf")
+	require.NoError(t, ioutil.WriteFile(existing, []byte("maxPods: 250"), 0644))
+	created := filepath.Join(installDir, "kubelet.exe")
+	stale := filepath.Join(installDir, "stale.conf")
+	require.NoError(t, ioutil.WriteFile(stale, []byte("{}"), 0644))
+
+	wmcb := winNodeBootstrapper{installDir: installDir}
+	require.NoError(t, wmcb.writeKubeletFile(existing, []byte("maxPods: 100")), "error writing without a journal")
+	assert.NoFileExists(t, journalFilePath(installDir), "change recorded without a journal")
+
+	wmcb.journal, err = newJournal(osFileSystem, installDir)
+	require.NoError(t, err)
+	require.NoError(t, wmcb.writeKubeletFile(existing, []byte("maxPods: 50")))
+	require.NoError(t, wmcb.writeKubeletFile(existing, []byte("maxPods: 10")))
+	require.NoError(t, wmcb.writeKubeletFile(created, []byte("kubelet")))
+	require.NoError(t, wmcb.journal.fileRemoved(stale))
+	require.NoError(t, os.Remove(stale))
+
+	j, err := loadJournal(osFileSystem, installDir)
+	require.NoError(t, err)
+	require.Len(t, j.Entries, 3, "file recorded more than once")
+	assert.Equal(t, journalEntry{Type: fileWritten, Path: created}, j.Entries[1])
+	for i := len(j.Entries) - 1; i >= 0; i-- {
+		require.NoError(t, wmcb.rollbackEntry(j.Entries[i]))
+	}
+	contents, err := ioutil.ReadFile(existing)
+	require.NoError(t, err, "error reading file")
+	assert.Equal(t, "maxPods: 100", string(contents), "file not restored to its contents before the journal")
+	assert.NoFileExists(t, created, "created file not removed")
+	assert.FileExists(t, stale, "removed file not restored")
+
+	require.NoError(t, j.remove())
+	assert.NoFileExists(t, journalFilePath(installDir), "journal not removed")
+	assert.NoDirExists(t, filepath.Join(installDir, journalBackupDirName), "journal backups not removed")
+}
+
+// TestHooks tests that the registered hooks are run in order, and that the hook scripts are only recorded in dry-run
+// mode
+func TestHooks(t *testing.T) {
+	installDir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(installDir)
+
+	wmcb := winNodeBootstrapper{installDir: installDir, log: logger.Log}
+	assert.Error(t, wmcb.AddHook("pre-install", func(context.Context) error { return nil }), "unknown point accepted")
+	assert.Error(t, wmcb.AddHook(HookPreKubelet, nil), "nil hook accepted")
+
+	var run []string
+	require.NoError(t, wmcb.AddHook(HookPreKubelet, func(context.Context) error {
+		run = append(run, "first")
+		return nil
+	}))
+	require.NoError(t, wmcb.AddHook(HookPreKubelet, func(context.Context) error {
+		run = append(run, "second")
+		return fmt.Errorf("domain join failed")
+	}))
+	require.NoError(t, wmcb.runHooks(context.Background(), HookPostCNI), "error running a point without hooks")
+	err = wmcb.runHooks(context.Background(), HookPreKubelet)
+	assert.EqualError(t, err, "pre-kubelet hook 1 failed: domain join failed")
+	assert.Equal(t, []string{"first", "second"}, run, "hooks not run in the order they were registered")
+
+	scriptsDir := wmcb.hookScriptsDir(HookPreKubelet)
+	assert.Equal(t, filepath.Join(installDir, "hooks", "pre-kubelet.d"), scriptsDir)
+	require.NoError(t, os.MkdirAll(scriptsDir, os.ModeDir|0755))
+	for _, name := range []string{"20-install-ccg.ps1", "10-join-domain.cmd", "README.md"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(scriptsDir, name), []byte(""), 0644))
+	}
+	wmcb.SetDryRun()
+	require.NoError(t, wmcb.runHooks(context.Background(), HookPreKubelet))
+	assert.Equal(t, []string{"run the 2 registered pre-kubelet hooks",
+		"run the pre-kubelet hook " + filepath.Join(scriptsDir, "10-join-domain.cmd"),
+		"run the pre-kubelet hook " + filepath.Join(scriptsDir, "20-install-ccg.ps1")}, wmcb.DryRunPlan().Actions)
+	assert.Len(t, run, 2, "hooks run in dry-run mode")
+}
+
+// TestSetGMSA tests that the GMSA options are validated and that the node is labeled when GMSA is enabled
+func TestSetGMSA(t *testing.T) {
+	wmcb := winNodeBootstrapper{}
+	assert.Equal(t, nodeLabel, wmcb.nodeLabelsArg(), "GMSA label applied without GMSA support")
+
+	assert.Error(t, wmcb.SetGMSA("", "{01234567-89AB-CDEF-0123-456789ABCDEF}"), "CLSID accepted without a plugin")
+	assert.Error(t, wmcb.SetGMSA(`C:\ccg\plugin.exe`, "{01234567-89AB-CDEF-0123-456789ABCDEF}"),
+		"executable accepted as CCG plugin")
+	assert.Error(t, wmcb.SetGMSA(`C:\ccg\plugin.dll`, "01234567-89AB-CDEF-0123-456789ABCDEF"),
+		"CLSID without braces accepted")
+	assert.Nil(t, wmcb.gmsa, "GMSA enabled with invalid options")
+
+	require.NoError(t, wmcb.SetGMSA(`C:\ccg\plugin.DLL`, "{01234567-89ab-cdef-0123-456789ABCDEF}"))
+	assert.Equal(t, "{01234567-89ab-cdef-0123-456789ABCDEF}", wmcb.gmsa.ccgPluginCLSID)
+	require.NoError(t, wmcb.SetGMSA("", ""), "error enabling GMSA on a domain joined node")
+	assert.Empty(t, wmcb.gmsa.ccgPluginPath)
+	assert.Equal(t, nodeLabel+","+gmsaNodeLabel, wmcb.nodeLabelsArg())
+}
+
+// TestDNS tests the parsing of the cluster domain, the detection of the NRPT rules conflicting with the cluster DNS,
+// the merging of the DNS suffix search list and the resolv.conf override
+func TestDNS(t *testing.T) {
+	domain, err := kubeletClusterDomain([]byte(`{"clusterDomain":"cluster.example.com.","clusterDNS":["172.30.0.10"]}`))
+	require.NoError(t, err)
+	assert.Equal(t, "cluster.example.com", domain)
+	domain, err = kubeletClusterDomain([]byte(`{"clusterDNS":["172.30.0.10"]}`))
+	require.NoError(t, err)
+	assert.Equal(t, defaultClusterDomain, domain, "default cluster domain not used")
+
+	assert.True(t, dnsNamespaceOverlaps(".", "cluster.local"), "catch-all rule not detected")
+	assert.True(t, dnsNamespaceOverlaps(".Cluster.Local", "cluster.local"), "rule of the domain not detected")
+	assert.True(t, dnsNamespaceOverlaps(".local", "cluster.local"), "rule of a parent domain not detected")
+	assert.True(t, dnsNamespaceOverlaps(".svc.cluster.local", "cluster.local"), "rule of a subdomain not detected")
+	assert.False(t, dnsNamespaceOverlaps(".corp.example.com", "cluster.local"), "rule of another domain detected")
+	assert.False(t, dnsNamespaceOverlaps(".mycluster.local", "cluster.local"), "rule of a sibling domain detected")
+
+	assert.Equal(t, "corp.example.com,svc.cluster.local,cluster.local",
+		mergeSearchList("corp.example.com", []string{"svc.cluster.local", "cluster.local"}))
+	assert.Equal(t, "svc.cluster.local,cluster.local",
+		mergeSearchList("", []string{"svc.cluster.local", "cluster.local"}))
+	assert.Equal(t, "Cluster.Local,corp.example.com",
+		mergeSearchList("Cluster.Local, corp.example.com", []string{"cluster.local"}), "duplicate suffix added")
+
+	cni := &cniOptions{layout: newCNILayout(`c:\k`, ""), resolvConf: `c:\k\resolv.conf`}
+	kubeletCmd := `c:\k\kubelet.exe --windows-service --resolv-conf=""`
+	require.NoError(t, cni.updateKubeletArgs(&kubeletCmd))
+	assert.Contains(t, kubeletCmd, ` --resolv-conf=c:\k\resolv.conf`, "resolv.conf not overridden")
+}
+
+// TestIgnitionFiles tests the selection of the extra files of the ignition file and their translation for Windows,
+// including the translation of the repository mirrors of the ImageContentSourcePolicies to registry mirrors
+func TestIgnitionFiles(t *testing.T) {
+	wmcb := winNodeBootstrapper{}
+	assert.Error(t, wmcb.SetIgnitionFiles([]string{"chrony", "kubelet"}), "unsupported ignition file accepted")
+	assert.False(t, wmcb.isIgnitionFileEnabled(chronyConfPath), "ignition file enabled by default")
+	require.NoError(t, wmcb.SetIgnitionFiles([]string{ignitionRegistryCAs, ignitionRegistriesConf}))
+	assert.True(t, wmcb.isIgnitionFileEnabled("/etc/docker/certs.d/registry.example.com:5000/ca.crt"))
+	assert.True(t, wmcb.isIgnitionFileEnabled(registriesConfPath))
+	assert.False(t, wmcb.isIgnitionFileEnabled("/etc/docker/certs.d/ca.crt"), "CA of no registry enabled")
+	assert.False(t, wmcb.isIgnitionFileEnabled(chronyConfPath), "file that was not enabled extracted")
+	assert.Equal(t, "registry.example.com5000", registryHostDir("registry.example.com:5000"))
+
+	registriesConf := `unqualified-search-registries = ["registry.access.redhat.com"]
+
+[[registry]]
+  prefix = ""
+  location = "quay.io"
+  mirror-by-digest-only = true
+
+  [[registry.mirror]]
+    location = "mirror.example.com:5000/quay"
+
+  [[registry.mirror]]
+    location = 'insecure.example.com'
+    insecure = true
+
+[[registry]]
+  location = "registry.redhat.io/ubi8"
+  mirror-by-digest-only = true
+
+  [[registry.mirror]]
+    location = "mirror.example.com:5000/redhat/ubi8"
+
+[[registry]]
+  location = "quay.io/windows/pause"
+  mirror-by-digest-only = true
+
+  [[registry.mirror]]
+    location = "mirror.example.com:5000/quay/windows/pause"
+
+  [[registry.mirror]]
+    location = "mirror.example.com:5000/ocp4/pause"
+
+[[registry]]
+  location = "docker.io"
+`
+	registries, unsupported, err := parseRegistriesConf([]byte(registriesConf))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"quay.io/windows/pause mirrored by mirror.example.com:5000/ocp4/pause"}, unsupported,
+		"mirror renaming the repository not reported")
+	require.Len(t, registries, 2)
+	assert.Equal(t, "quay.io", registries[0].host)
+	assert.Equal(t, []string{"mirror.example.com:5000/quay", "insecure.example.com"}, registries[0].mirrors,
+		"repository mirror not merged with the registry mirror")
+	assert.Equal(t, "registry.redhat.io", registries[1].host)
+	assert.Equal(t, []string{"mirror.example.com:5000/redhat"}, registries[1].mirrors)
+
+	expected := `server = "https://quay.io"
+
+[host."https://mirror.example.com:5000/v2/quay"]
+  capabilities = ["pull", "resolve"]
+  override_path = true
+  ca = 'C:\k\containerd\certs.d\mirror.example.com5000\ca.crt'
+
+[host."https://insecure.example.com"]
+  capabilities = ["pull", "resolve"]
+  skip_verify = true
+`
+	assert.Equal(t, expected, string(containerdHostsConfig(registries[0],
+		map[string]string{"mirror.example.com:5000": `C:\k\containerd\certs.d\mirror.example.com5000\ca.crt`})))
+
+	assert.Equal(t, []string{"0.rhel.pool.ntp.org", "time.example.com"},
+		parseTimeServers([]byte("# Use public servers\npool 0.rhel.pool.ntp.org iburst\nserver time.example.com "+
+			"iburst\ndriftfile /var/lib/chrony/drift\n")))
+}
+
+// TestTrustedCABundle tests that the trusted CA bundle is validated, and that its installation is planned in dry-run
+// mode
+func TestTrustedCABundle(t *testing.T) {
+	installDir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(installDir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err, "error generating private key")
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "proxy-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err, "error creating certificate")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	bundle := append(keyPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})...)
+
+	_, err = parseCertificates(keyPEM)
+	assert.Error(t, err, "bundle without certificates accepted")
+	certs, err := parseCertificates(bundle)
+	require.NoError(t, err)
+	require.Len(t, certs, 1)
+	assert.Equal(t, "proxy-ca", certs[0].Subject.CommonName)
+
+	wmcb := winNodeBootstrapper{installDir: installDir, log: logger.Log}
+	wmcb.SetDryRun()
+	assert.Error(t, wmcb.installTrustedCABundle([]byte("invalid")), "invalid trusted CA bundle accepted")
+	require.NoError(t, wmcb.installTrustedCABundle(bundle))
+	path := filepath.Join(installDir, certsDirName, userCABundleName)
+	assert.NoFileExists(t, path, "trusted CA bundle written in dry-run mode")
+	require.Len(t, wmcb.DryRunPlan().Files, 1)
+	assert.Equal(t, path, wmcb.DryRunPlan().Files[0].Path)
+	assert.Equal(t, []string{"add the 1 certificates of the trusted CA bundle to the trusted root certificates"},
+		wmcb.DryRunPlan().Actions)
+}
+
+// TestTimeSync tests that the time servers are validated, and that the servers given take precedence over the ones of
+// the chrony configuration of the ignition file
+func TestTimeSync(t *testing.T) {
+	wmcb := winNodeBootstrapper{log: logger.Log}
+	assert.Error(t, wmcb.SetTimeServers([]string{"time.example.com", "ntp server"}), "invalid time server accepted")
+	assert.Nil(t, wmcb.timeServers, "time servers set with an invalid one")
+
+	wmcb.SetDryRun()
+	require.NoError(t, wmcb.configureTimeSync(context.Background()))
+	assert.Empty(t, wmcb.DryRunPlan().Actions, "clock synchronized without time servers")
+
+	wmcb.chronyTimeServers = parseTimeServers([]byte("pool 0.rhel.pool.ntp.org iburst\n"))
+	require.NoError(t, wmcb.SetTimeServers([]string{"time.example.com", "10.0.0.1"}))
+	require.NoError(t, wmcb.configureTimeSync(context.Background()))
+	assert.Equal(t, []string{"start the W32Time service automatically",
+		"run w32tm /config /manualpeerlist:time.example.com,0x8 10.0.0.1,0x8 /syncfromflags:manual /update",
+		"run w32tm /resync /rediscover"}, wmcb.DryRunPlan().Actions)
+}
+
+// TestErrorKinds tests that the bootstrap failures can be matched by their kind, through the errors wrapping them
+func TestErrorKinds(t *testing.T) {
+	installDir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(installDir)
+
+	_, err = newCNIOptions(osFileSystem, installDir, "", filepath.Join(installDir, "cni"),
+		filepath.Join(installDir, "cni.conf"))
+	require.Error(t, err)
+	assert.True(t, errors.Is(fmt.Errorf("wrapped: %w", err), ErrCNIInvalid), "missing CNI dir not an ErrCNIInvalid")
+	assert.False(t, errors.Is(err, ErrIgnitionParse))
+	assert.Contains(t, err.Error(), "error accessing CNI dir", "error message not kept")
+
+	cniDir := filepath.Join(installDir, "cni")
+	require.NoError(t, os.Mkdir(cniDir, os.ModeDir))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(cniDir, "win-overlay.exe"), []byte("plugin"), 0644))
+	cniConfig := filepath.Join(installDir, "cni.conf")
+	require.NoError(t, ioutil.WriteFile(cniConfig, []byte(`{"name":"OpenShiftNetwork"}`), 0644))
+	wmcb := winNodeBootstrapper{installDir: installDir}
+	wmcb.SetDryRun()
+	wmcb.cni, err = newCNIOptions(osFileSystem, installDir, "", cniDir, cniConfig)
+	require.NoError(t, err)
+	assert.True(t, errors.Is(wmcb.cni.plan(wmcb.DryRunPlan()), ErrCNIInvalid), "invalid CNI config not an ErrCNIInvalid")
+
+	wmcb = winNodeBootstrapper{installDir: installDir, kubeletArgs: make(map[string]string), log: logger.Log}
+	err = wmcb.parseIgnitionFileContents([]byte("{"), nil)
+	assert.True(t, errors.Is(err, ErrIgnitionParse), "invalid ignition file not an ErrIgnitionParse")
+
+	assert.Nil(t, newError(ErrServiceCreate, nil))
+}
+
+// TestTransientErrors tests that the failures that can be retried are classified as transient, and that the status
+// reports the completed phases
+func TestTransientErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fetcher")
+	require.NoError(t, err, "error creating temp directory")
+	defer os.RemoveAll(dir)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/unavailable/kubelet.exe" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	fetcher, err := NewFetcher(dir, "", nil)
+	require.NoError(t, err)
+	fetcher.client = server.Client()
+
+	checksum := strings.Repeat("0", 64)
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/unavailable/kubelet.exe", checksum)
+	assert.True(t, errors.Is(err, ErrTransient), "unavailable server not reported as transient")
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/missing/kubelet.exe", checksum)
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrTransient), "missing artifact reported as transient")
+
+	assert.True(t, errors.Is(transientError(fmt.Errorf("error draining node: %w", context.DeadlineExceeded)),
+		ErrTransient), "timeout not reported as transient")
+	assert.False(t, errors.Is(transientError(fmt.Errorf("error draining node")), ErrTransient))
+
+	status := &Status{}
+	status.record(PhaseFilesWritten, time.Now(), time.Second, nil)
+	status.record(PhaseServiceCreated, time.Now(), time.Second, fmt.Errorf("failed"))
+	assert.True(t, status.Succeeded(PhaseFilesWritten))
+	assert.False(t, status.Succeeded(PhaseFilesWritten, PhaseServiceCreated), "failed phase reported as succeeded")
+	assert.False(t, status.Succeeded(PhaseKubeletStarted), "missing phase reported as succeeded")
+}
+
+// TestEventLog tests that the event log source is validated and that no event is written in dry-run mode
+func TestEventLog(t *testing.T) {
+	assert.Error(t, SetEventLog(""), "no error thrown for an empty event log source")
+
+	wmcb := winNodeBootstrapper{log: logger.Log}
+	wmcb.SetDryRun()
+	// No event log has been set, and none would be written to in dry-run mode
+	wmcb.writeEvent(eventIDPhaseSucceeded, false, "Bootstrap phase FilesWritten succeeded")
+	assert.Nil(t, eventLog)
+}
+
+// TestServiceAccount tests that SetServiceAccount validates the account and that the services are configured to run
+// as it
+func TestServiceAccount(t *testing.T) {
+	wmcb := winNodeBootstrapper{log: logger.Log, installDir: "C:\\k", logDir: "C:\\var\\log\\kubelet",
+		certDir: "C:\\var\\lib\\kubelet\\pki\\"}
+	c := serviceConfig{}
+	wmcb.setServiceAccountConfig(KubeletServiceName, &c)
+	assert.Empty(t, c.ServiceStartName, "service account set by default")
+
+	assert.Error(t, wmcb.SetServiceAccount("wmcb"), "no error thrown for an account without a domain")
+	assert.Error(t, wmcb.SetServiceAccount("CONTOSO\\wmcb"), "no error thrown for an account that is not a gMSA")
+	require.NoError(t, wmcb.SetServiceAccount("CONTOSO\\wmcb$"))
+	assert.Equal(t, "CONTOSO\\wmcb$", wmcb.serviceStartName(kubeProxyServiceName))
+
+	require.NoError(t, wmcb.SetServiceAccount(VirtualServiceAccount))
+	wmcb.setServiceAccountConfig(KubeletServiceName, &c)
+	assert.Equal(t, "NT SERVICE\\kubelet", c.ServiceStartName)
+	assert.Equal(t, serviceSIDTypeUnrestricted, c.SidType)
+	// The certificates are in the kubelet root directory, which is already granted access to
+	assert.Equal(t, []string{"C:\\k", "C:\\var\\log\\kubelet", kubeletRootDir},
+		wmcb.kubeletAccountRights().modifyDirs)
+
+	wmcb.SetDryRun()
+	require.NoError(t, wmcb.configureServiceAccount(nil, kubeProxyServiceName,
+		wmcb.kubeProxyAccountRights("C:\\var\\log\\kube-proxy")))
+	assert.Contains(t, wmcb.DryRunPlan().Actions, "grant NT SERVICE\\kube-proxy read access to C:\\k")
+}
+
+// TestPermissiveEntries tests that the access control entries granting access to principals other than SYSTEM, the
+// Administrators and the Windows services are reported
+func TestPermissiveEntries(t *testing.T) {
+	sddl := "D:AI(A;OICIID;FA;;;SY)(A;OICIID;FA;;;BA)(A;OICIID;0x1200a9;;;BU)(D;;FA;;;WD)" +
+		"(A;OICI;0x1301bf;;;S-1-5-80-1234)(A;OICIID;FA;;;S-1-5-80-5678)"
+	assert.Equal(t, []string{"(A;OICIID;0x1200a9;;;BU)"}, permissiveEntries(sddl))
+	assert.Empty(t, permissiveEntries(restrictedDirSDDL))
+	assert.Empty(t, permissiveEntries(restrictedFileSDDL))
+	// Only the service entries that are not inherited are kept when the install directory is restricted
+	assert.Equal(t, []string{"(A;OICI;0x1301bf;;;S-1-5-80-1234)"}, serviceEntries(sddl))
+
+	wmcb := winNodeBootstrapper{log: logger.Log, installDir: "C:\\k",
+		kubeletArgs: map[string]string{cloudConfigOption: "C:\\k\\cloud.conf"}}
+	wmcb.SetDryRun()
+	require.NoError(t, wmcb.restrictAccess())
+	assert.Contains(t, wmcb.DryRunPlan().Actions,
+		"restrict access to C:\\k\\cloud.conf to SYSTEM and the Administrators")
+}
+
+// TestNodeStage tests that the stage a registered node is stuck at in joining the cluster is reported
+func TestNodeStage(t *testing.T) {
+	node := &corev1.Node{}
+	stage, ready := nodeStage(node)
+	assert.False(t, ready)
+	assert.Equal(t, "the kubelet has not reported the node status", stage)
+
+	node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionFalse,
+		Reason: "KubeletNotReady", Message: "runtime network not ready: NetworkReady=false reason:NetworkPluginNotReady " +
+			"message:docker: network plugin is not ready: cni config uninitialized"}}
+	stage, ready = nodeStage(node)
+	assert.False(t, ready)
+	assert.Contains(t, stage, "the CNI plugin is not ready")
+
+	node.Spec.Taints = []corev1.Taint{{Key: uninitializedTaint, Effect: corev1.TaintEffectNoSchedule}}
+	stage, _ = nodeStage(node)
+	assert.Equal(t, "the cloud provider has not initialized the node", stage)
+
+	node.Spec.Taints = nil
+	node.Status.Conditions[0].Status = corev1.ConditionTrue
+	_, ready = nodeStage(node)
+	assert.True(t, ready)
+
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "kubelet.log")
+	cloudErr := `E0101 00:00:00.000000 1234 server.go:273] failed to run Kubelet: could not init cloud provider "azure"`
+	require.NoError(t, ioutil.WriteFile(logPath, []byte("I0101 00:00:00.000000 1234 server.go:416] Version: v1.20.0\n"+
+		cloudErr+"\n"), 0644))
+	assert.Equal(t, cloudErr, lastCloudProviderError(osFileSystem, logPath))
+	assert.Empty(t, lastCloudProviderError(osFileSystem, filepath.Join(dir, "missing.log")))
+}
+
+// TestMigrateKubeletCommand tests that the kubelet arguments removed by a kubelet version are dropped from the kubelet
+// service command line when upgrading to that version
+func TestMigrateKubeletCommand(t *testing.T) {
+	command := "c:\\k\\kubelet.exe --windows-service --network-plugin=cni --cni-bin-dir=c:\\k\\cni " +
+		"--container-runtime=remote --hostname-override=node"
+
+	migrated, removed := migrateKubeletCommand(command, version.MustParseGeneric("1.21.1"))
+	assert.Equal(t, command, migrated)
+	assert.Empty(t, removed)
+
+	migrated, removed = migrateKubeletCommand(command, version.MustParseSemantic("v1.24.0"))
+	assert.Equal(t, "c:\\k\\kubelet.exe --windows-service --container-runtime=remote --hostname-override=node", migrated)
+	assert.Equal(t, []string{"--network-plugin=cni", "--cni-bin-dir=c:\\k\\cni"}, removed)
+
+	migrated, removed = migrateKubeletCommand(command, version.MustParseSemantic("v1.27.3"))
+	assert.Equal(t, "c:\\k\\kubelet.exe --windows-service --hostname-override=node", migrated)
+	assert.Len(t, removed, 3)
+}
+
+// TestVersionSkew tests that the kubelet versions outside of the supported range, and the ones newer than the API
+// server or too old for it, are rejected
+func TestVersionSkew(t *testing.T) {
+	assert.NoError(t, checkSupportedKubelet(version.MustParseGeneric("v1.20.0+bafe72f")))
+	assert.NoError(t, checkSupportedKubelet(version.MustParseGeneric("v1.27.9")))
+	assert.Error(t, checkSupportedKubelet(version.MustParseGeneric("v1.19.4")))
+	assert.Error(t, checkSupportedKubelet(version.MustParseGeneric("v1.28.0")))
+
+	apiServer := version.MustParseGeneric("v1.22.3")
+	assert.NoError(t, checkVersionSkew(version.MustParseGeneric("v1.22.0"), apiServer))
+	assert.NoError(t, checkVersionSkew(version.MustParseGeneric("v1.20.5"), apiServer))
+	assert.EqualError(t, checkVersionSkew(version.MustParseGeneric("v1.23.0"), apiServer),
+		"kubelet 1.23.0 is newer than the API server 1.22.3")
+	assert.EqualError(t, checkVersionSkew(version.MustParseGeneric("v1.19.0"), apiServer),
+		"kubelet 1.19.0 is more than 2 minor versions older than the API server 1.22.3")
+}
+
+// TestIncompatibilities tests that the incompatibilities between the versions of the node components are reported
+func TestIncompatibilities(t *testing.T) {
+	kubelet := version.MustParseGeneric("v1.26.1")
+	assert.Empty(t, incompatibilities(kubelet, version.MustParseGeneric("v1.6.8"), version.MustParseGeneric("v1.26.0"),
+		containerdRuntime))
+	assert.Empty(t, incompatibilities(nil, nil, nil, dockerRuntime))
+	assert.Equal(t, []string{"kubelet 1.26.1 does not support the docker runtime it is configured with"},
+		incompatibilities(kubelet, nil, nil, dockerRuntime))
+	assert.Equal(t, []string{"kubelet 1.26.1 requires containerd 1.6.0 or later, containerd 1.5.2 is installed"},
+		incompatibilities(kubelet, version.MustParseGeneric("v1.5.2"), nil, containerdRuntime))
+	assert.Len(t, incompatibilities(kubelet, nil, version.MustParseGeneric("v1.25.4"), containerdRuntime), 2)
+
+	assert.Equal(t, `C:\Program Files\csi-proxy\csi-proxy.exe`,
+		serviceExecutable(`"C:\Program Files\csi-proxy\csi-proxy.exe" -windows-service`))
+	assert.Equal(t, `c:\k\csi-proxy.exe`, serviceExecutable(`c:\k\csi-proxy.exe -windows-service`))
+}
+
+// TestWindowsUpdates tests the checks of the Windows updates and that the patch level is kept on reset
+func TestWindowsUpdates(t *testing.T) {
+	t.Run("Normalize knowledge base IDs", func(t *testing.T) {
+		kbs, err := normalizeKBs([]string{"kb5005701", " KB5005568", "5005701"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"KB5005568", "KB5005701"}, kbs)
+		_, err = normalizeKBs([]string{"KB5005568;Restart-Computer"})
+		assert.Error(t, err, "no error thrown for an invalid knowledge base ID")
+	})
+
+	t.Run("Missing updates", func(t *testing.T) {
+		result := &windowsUpdateResult{Applied: []string{"KB5005701"}, Installed: []string{"kb5005568", "KB4589208"}}
+		assert.Empty(t, missingKBs([]string{"KB5005568", "KB5005701"}, result))
+		assert.Equal(t, []string{"KB5006672"}, missingKBs([]string{"KB5005568", "KB5006672"}, result))
+	})
+
+	t.Run("Dry-run", func(t *testing.T) {
+		wmcb := winNodeBootstrapper{installDir: `C:\k`, log: logger.Log}
+		wmcb.SetDryRun()
+		patchLevel, err := wmcb.ApplyWindowsUpdates(context.Background(), []string{"KB5005568"})
+		require.NoError(t, err)
+		assert.Nil(t, patchLevel)
+		assert.Equal(t, []string{"install the Windows updates KB5005568"}, wmcb.DryRunPlan().Actions)
+	})
+
+	t.Run("Patch level kept on reset", func(t *testing.T) {
+		installDir, err := ioutil.TempDir("", "wmcb")
+		require.NoError(t, err, "error creating temp directory")
+		defer os.RemoveAll(installDir)
+
+		wmcb := winNodeBootstrapper{installDir: installDir, log: logger.Log}
+		patchLevel := &PatchLevel{Build: "17763.2237", InstalledKBs: []string{"KB5005568"},
+			Timestamp: time.Now().UTC().Truncate(time.Second)}
+		wmcb.updateStatus(func(status *Status) {
+			status.PatchLevel = patchLevel
+			status.record(PhaseUpdatesApplied, patchLevel.Timestamp, time.Second, nil)
+		})
+		wmcb.resetStatus()
+		status, err := ReadStatus(installDir)
+		require.NoError(t, err, "error reading status")
+		assert.Empty(t, status.Phases, "phases kept on reset")
+		assert.Equal(t, patchLevel, status.PatchLevel)
+	})
+}
+
+// TestContainerServices tests that the container services are checked, and enabled when disabled
+func TestContainerServices(t *testing.T) {
+	assert.Equal(t, []string{"Containers"}, requiredWindowsFeatures(false))
+	assert.Equal(t, []string{"Containers", "Microsoft-Hyper-V"}, requiredWindowsFeatures(true))
+
+	svcMgr := newFakeServiceManager()
+	wmcb := winNodeBootstrapper{svcMgr: svcMgr, log: logger.Log}
+	assert.Error(t, wmcb.checkContainerService("hns"), "no error thrown for a missing service")
+	assert.Error(t, wmcb.enableContainerService("hns"), "no error thrown enabling a missing service")
+
+	_, err := svcMgr.CreateService("hns", `C:\Windows\system32\svchost.exe`, serviceConfig{StartType: startDisabled})
+	require.NoError(t, err)
+	assert.Error(t, wmcb.checkContainerService("hns"), "no error thrown for a disabled service")
+	require.NoError(t, wmcb.enableContainerService("hns"))
+	assert.NoError(t, wmcb.checkContainerService("hns"))
+	config, err := svcMgr.services["hns"].Config()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(startManual), config.StartType)
+	running, err := isServiceRunning(svcMgr.services["hns"])
+	require.NoError(t, err)
+	assert.True(t, running, "service not started")
+}
+
+// TestSecuritySoftware tests the detection of the security software and of its node directory scanning
+func TestSecuritySoftware(t *testing.T) {
+	t.Run("Filter drivers", func(t *testing.T) {
+		drivers := parseAdapterBindings("vEthernet (Ethernet 2)|fortinet_wfp|Fortinet NDIS Filter\r\n\r\n")
+		require.Len(t, drivers, 1)
+		assert.Equal(t, "Fortinet NDIS Filter", drivers[0].Name)
+		assert.Equal(t, "fortinet_wfp on vEthernet (Ethernet 2)", drivers[0].Component)
+		assert.NotEmpty(t, drivers[0].Incompatible)
+		assert.Empty(t, parseAdapterBindings(""))
+	})
+
+	t.Run("Installed software", func(t *testing.T) {
+		svcMgr := newFakeServiceManager()
+		wmcb := winNodeBootstrapper{installDir: `C:\k`, svcMgr: svcMgr, log: logger.Log}
+		assert.Empty(t, wmcb.installedSecuritySoftware())
+		for _, name := range []string{"CSFalconService", "vpnagent", "PanGPS"} {
+			s, err := svcMgr.CreateService(name, `C:\Program Files\agent.exe`, serviceConfig{})
+			require.NoError(t, err)
+			if name != "PanGPS" {
+				require.NoError(t, s.Start())
+			}
+		}
+		report := &SecurityReport{Software: wmcb.installedSecuritySoftware()}
+		require.Len(t, report.Software, 3)
+		assert.Equal(t, EDR, report.Software[0].Kind)
+		assert.Len(t, report.Software[0].Exclusions, 4)
+		for _, state := range report.Software[0].Exclusions {
+			assert.Equal(t, ExclusionUnknown, state)
+		}
+		assert.Len(t, report.Incompatibilities(), 1, "only the running VPN client is incompatible")
+		assert.Error(t, checkSecuritySoftware(report))
+
+		message, err := checkScanExclusions(report)
+		assert.NoError(t, err)
+		assert.Contains(t, message, "CrowdStrike Falcon need to be checked")
+	})
+
+	t.Run("Defender scanning the node directories", func(t *testing.T) {
+		message, err := checkScanExclusions(&SecurityReport{})
+		assert.NoError(t, err)
+		assert.Empty(t, message, "agents reported when none is running")
+
+		report := &SecurityReport{Software: []SecuritySoftware{{Name: "Microsoft Defender Antivirus", Kind: Antivirus,
+			Running: true, Exclusions: map[string]ExclusionState{`C:\k`: Excluded, `C:\k\cni`: NotExcluded}}}}
+		_, err = checkScanExclusions(report)
+		assert.EqualError(t, err, `Microsoft Defender Antivirus scans C:\k\cni, run configure-host-security or `+
+			`exclude them in the agent`)
+		report.Software[0].Exclusions[`C:\k\cni`] = Excluded
+		message, err = checkScanExclusions(report)
+		assert.NoError(t, err)
+		assert.Equal(t, "the node directories are excluded from the scanning of Microsoft Defender Antivirus", message)
+	})
+}
+
+// TestCNILayout tests that the CNI directories and kubelet flags follow the container runtime of the node
+func TestCNILayout(t *testing.T) {
+	binDir := filepath.Join("C:/k", "cni")
+	confDir := filepath.Join("C:/k", "cni", "config")
+	docker := newCNILayout("C:/k", "")
+	assert.Equal(t, cniLayout{binDir: binDir, confDir: confDir, kubeletFlags: true}, docker)
+	containerd := newCNILayout("C:/k", containerdRuntime)
+	assert.Equal(t, cniLayout{binDir: binDir, confDir: confDir}, containerd)
+
+	assert.Equal(t, dockerRuntime, kubeletCommandRuntime(`C:\k\kubelet.exe --windows-service`))
+	assert.Equal(t, containerdRuntime, kubeletCommandRuntime(`C:\k\kubelet.exe --windows-service `+
+		`--container-runtime=remote --container-runtime-endpoint=npipe://./pipe/containerd-containerd`))
+
+	// The dockershim CNI flags of a node moved to containerd are removed
+	cni := &cniOptions{layout: containerd}
+	kubeletCmd := `C:\k\kubelet.exe --windows-service --container-runtime=remote --network-plugin=cni ` +
+		`--cni-bin-dir=C:\k\cni --cni-conf-dir=C:\k\cni\config`
+	require.NoError(t, cni.updateKubeletArgs(&kubeletCmd))
+	assert.NotContains(t, kubeletCmd, "--network-plugin")
+	assert.NotContains(t, kubeletCmd, "--cni-bin-dir")
+	assert.NotContains(t, kubeletCmd, "--cni-conf-dir")
+	assert.Contains(t, kubeletCmd, "--container-runtime=remote")
+
+	// containerd reads the CNI directories from its config instead
+	wmcb := winNodeBootstrapper{installDir: "C:/k", containerRuntime: containerdRuntime}
+	config, err := wmcb.renderContainerdConf()
+	require.NoError(t, err)
+	assert.Contains(t, string(config), "bin_dir = '"+binDir+"'")
+	assert.Contains(t, string(config), "conf_dir = '"+confDir+"'")
+}
+
+// TestKubeletUnit tests that the kubelet unit is rendered from the kubelet service and recorded in dry-run mode
+func TestKubeletUnit(t *testing.T) {
+	wmcb := winNodeBootstrapper{installDir: "C:/k", certDir: "C:/var/lib/kubelet/pki", logDir: "C:/var/log/kubelet",
+		containerRuntime: containerdRuntime, proxy: proxyConfig{httpsProxy: "http://proxy:3128"}, fs: newMemFS()}
+	wmcb.SetDryRun()
+	require.NoError(t, wmcb.writeKubeletUnit(wmcb.renderKubeletUnit()))
+
+	unit := wmcb.KubeletUnit()
+	require.NotNil(t, unit, "kubelet unit not recorded in dry-run mode")
+	assert.Equal(t, filepath.Join("C:/k", "kubelet.exe"), unit.Executable)
+	assert.True(t, sort.StringsAreSorted(unit.Args), "kubelet args not sorted")
+	assert.Contains(t, unit.Args, "--container-runtime=remote")
+	assert.Equal(t, []string{containerdServiceName}, unit.Dependencies)
+	assert.Equal(t, []string{"HTTPS_PROXY=http://proxy:3128"}, unit.Environment)
+	assert.Equal(t, "Automatic", unit.StartType)
+	assert.Empty(t, unit.Account, "kubelet run as an account without a service account")
+
+	require.Len(t, wmcb.DryRunPlan().Files, 1)
+	assert.Equal(t, filepath.Join("C:/k", kubeletUnitFileName), wmcb.DryRunPlan().Files[0].Path)
+
+	// The args of a command updated for CNI are sorted
+	unit = newKubeletUnit(serviceConfig{BinaryPathName: `C:\k\kubelet.exe --windows-service --cni-bin-dir=C:\k\cni`,
+		StartType: startManual}, `C:\k\kubelet.exe`, nil)
+	assert.Equal(t, []string{`--cni-bin-dir=C:\k\cni`, "--windows-service"}, unit.Args)
+	assert.Equal(t, "Manual", unit.StartType)
 }
@@ -0,0 +1,132 @@
+package bootstrapper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/clock"
+	logger "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// TestAccessDenied tests that the access denied errors of the Windows APIs are matched by ErrPermissions
+func TestAccessDenied(t *testing.T) {
+	err := fmt.Errorf("could not make install directory: %w",
+		&os.PathError{Op: "mkdir", Path: `C:\k`, Err: syscall.ERROR_ACCESS_DENIED})
+	assert.True(t, errors.Is(err, ErrPermissions), "access denied error not an ErrPermissions")
+	assert.True(t, errors.Is(newError(ErrServiceCreate, err), ErrPermissions), "wrapped access denied not matched")
+}
+
+// TestWinNodeBootstrapperConfigureWithInvalidInputs tests if Configure returns the expected error when CNI inputs
+// are not present
+func TestWinNodeBootstrapperConfigureWithInvalidInputs(t *testing.T) {
+	wnb, err := NewWinNodeBootstrapper("", "", "", "", "")
+	require.NoError(t, err, "error instantiating bootstrapper")
+	err = wnb.Configure(context.Background())
+	require.Error(t, err, "no error thrown when Configure is called with no CNI inputs")
+	assert.Contains(t, err.Error(), "cannot configure without required plugin inputs")
+}
+
+// TestKubeletDirectoriesCreation tests if the directories needed for Kubelet are initialized as required
+func TestKubeletDirectoriesCreation(t *testing.T) {
+	// Create a temp directory with wmcb prefix
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err, "error creating temp directory")
+	// Ignore the return error as there is not much we can do if the temporary directory is not deleted
+	defer os.RemoveAll(dir)
+	// podManifestDirectory which has to be created by wmcb.
+	podManifestDirectory := filepath.Join(dir, "etc", "kubernetes", "manifests")
+	// logDirectory which has to be created by wmcb
+	logDirectory := filepath.Join(dir, "log")
+	wnb := winNodeBootstrapper{
+		installDir:  dir,
+		logDir:      logDirectory,
+		kubeletArgs: make(map[string]string),
+	}
+	err = wnb.initializeKubeletFiles(context.Background())
+	assert.NoError(t, err, "error initializing kubelet files")
+	assert.DirExists(t, podManifestDirectory, "pod manifest directory was not created")
+	assert.DirExists(t, logDirectory, "log directory was not created")
+}
+
+// TestNewWithOptions tests that the bootstrapper is created with the given options and their defaults, and that the
+// invalid options are reported as OptionErrors
+func TestNewWithOptions(t *testing.T) {
+	_, err := New(WithCNI("C:\\something", ""))
+	var optionErr *OptionError
+	require.True(t, errors.As(err, &optionErr), "invalid CNI options not reported as an OptionError")
+	assert.Equal(t, "CNIDir", optionErr.Option)
+	_, err = NewFromOptions(Options{ContainerRuntime: dockerRuntime, ContainerdDir: "C:\\containerd"})
+	require.True(t, errors.As(err, &optionErr), "containerd dir with docker not reported as an OptionError")
+	assert.Equal(t, "ContainerdDir", optionErr.Option)
+
+	wmcb, err := New(WithInstallDir("C:\\k"))
+	require.NoError(t, err)
+	assert.Equal(t, "C:\\k\\kubeconfig", wmcb.kubeconfigPath, "default kubeconfig path not used")
+	assert.Equal(t, dockerRuntime, wmcb.containerRuntime, "default container runtime not used")
+	assert.NotNil(t, wmcb.log, "default logger not used")
+	require.NoError(t, wmcb.Disconnect())
+
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	wmcb, err = New(WithInstallDir("C:\\k"), WithKubeconfigPath("C:\\k\\node.kubeconfig"),
+		WithContainerRuntime(containerdRuntime, "C:\\containerd"), WithClock(clock.NewFakePassiveClock(now)))
+	require.NoError(t, err)
+	defer wmcb.Disconnect()
+	assert.Equal(t, "C:\\k\\node.kubeconfig", wmcb.kubeconfigPath)
+	assert.Equal(t, "C:\\containerd", wmcb.containerdDir)
+	assert.Equal(t, now, wmcb.now(), "clock not used")
+}
+
+// TestUseManagedIdentity tests that the Azure cloud config is rewritten to use the managed identity of the VM, and that
+// its secrets are encrypted with DPAPI
+func TestUseManagedIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmcb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wmcb := winNodeBootstrapper{log: logger.Log, installDir: dir}
+	wmcb.SetCloudConfigManagedIdentity("identity")
+	wmcb.SetDryRun()
+	cloudConfig := `{"cloud": "AzurePublicCloud", "aadClientId": "client", "aadClientSecret": "secret", ` +
+		`"useManagedIdentityExtension": false}`
+	contents, err := useManagedIdentity(&wmcb, []byte(cloudConfig))
+	require.NoError(t, err)
+	var config map[string]interface{}
+	require.NoError(t, json.Unmarshal(contents, &config))
+	assert.Equal(t, "", config["aadClientSecret"], "secret kept in the cloud config")
+	assert.Equal(t, "client", config["aadClientId"])
+	assert.Equal(t, true, config["useManagedIdentityExtension"])
+	assert.Equal(t, "identity", config["userAssignedIdentityID"])
+	require.Len(t, wmcb.DryRunPlan().Files, 1)
+	assert.Equal(t, filepath.Join(dir, cloudConfigSecretsName), wmcb.DryRunPlan().Files[0].Path)
+
+	_, err = useManagedIdentity(&wmcb, []byte("[global]"))
+	assert.Error(t, err, "no error thrown for a cloud config that is not JSON")
+
+	encrypted, err := dpapiProtect([]byte(`{"aadClientSecret":"secret"}`))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, cloudConfigSecretsName), encrypted, 0644))
+	secrets, err := CloudConfigSecrets(dir)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"aadClientSecret": "secret"}, secrets)
+}
+
+// TestScanExclusions tests that the node directories are matched against the paths excluded from the scanning of the
+// security software as Windows paths
+func TestScanExclusions(t *testing.T) {
+	dirs := []string{`C:\k`, `C:\k\containerd`, `C:\k\cni`, `D:\containers`}
+	assert.Equal(t, map[string]ExclusionState{`C:\k`: Excluded, `C:\k\containerd`: Excluded,
+		`C:\k\cni`: Excluded, `D:\containers`: NotExcluded}, dirExclusions(dirs, []string{`c:\K\`, `D:\other`}))
+	assert.Equal(t, map[string]ExclusionState{`C:\k`: NotExcluded, `C:\k\containerd`: Excluded,
+		`C:\k\cni`: NotExcluded, `D:\containers`: NotExcluded},
+		dirExclusions(dirs, []string{`C:\k\containerd`, `C:\k\cn`}))
+}
@@ -0,0 +1,164 @@
+package bootstrapper
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// kubeletServerCertName is the name of the file in the cert dir that links to the current kubelet serving
+	// certificate and key
+	kubeletServerCertName = "kubelet-server-current.pem"
+	// kubeletClientCertName is the name of the file in the cert dir that links to the current kubelet client
+	// certificate and key
+	kubeletClientCertName = "kubelet-client-current.pem"
+	// kubeletServerCertPattern matches all the kubelet serving certificate files in the cert dir
+	kubeletServerCertPattern = "kubelet-server-*.pem"
+	// certPollInterval is the interval at which we poll for the kubelet serving certificate to be issued
+	certPollInterval = 10 * time.Second
+	// certRenewalTaskName is the name of the Windows scheduled task that renews the kubelet serving certificate
+	certRenewalTaskName = "wmcb-renew-certs"
+)
+
+// SetCertDir sets the directory in which the kubelet stores its client and serving certificates. This needs to be
+// called before InitializeKubelet for the directory to be passed to the kubelet.
+func (wmcb *winNodeBootstrapper) SetCertDir(certDir string) error {
+	if certDir == "" {
+		return fmt.Errorf("cert dir cannot be empty")
+	}
+	wmcb.certDir = certDir
+	return nil
+}
+
+// kubeletServerCertExpiry returns the expiry time of the current kubelet serving certificate in the given cert dir of
+// the given file system
+func kubeletServerCertExpiry(fs FileSystem, certDir string) (time.Time, error) {
+	certPath := filepath.Join(certDir, kubeletServerCertName)
+	content, err := readFile(fs, certPath)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error reading %s: %v", certPath, err)
+	}
+	// The file contains both the certificate and the private key
+	for block, rest := pem.Decode(content); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error parsing certificate in %s: %v", certPath, err)
+		}
+		return cert.NotAfter, nil
+	}
+	return time.Time{}, fmt.Errorf("no certificate found in %s", certPath)
+}
+
+// kubeletCertsIssued returns true if the kubelet client and serving certificates in the given cert dir of the given
+// file system have been issued and have not expired at the given time
+func kubeletCertsIssued(fs FileSystem, certDir string, now time.Time) bool {
+	if _, err := fs.Stat(filepath.Join(certDir, kubeletClientCertName)); err != nil {
+		return false
+	}
+	expiry, err := kubeletServerCertExpiry(fs, certDir)
+	return err == nil && expiry.After(now)
+}
+
+// WaitForKubeletCertificates waits until the CSRs the kubelet creates for its client and serving certificates have
+// been approved and the certificates have been written to the cert dir, the timeout is reached or the context is done.
+// The client certificate CSR is created on start up with the bootstrap kubeconfig, and the serving certificate CSR
+// once the node has registered. The CSRs are not approved by WMCB, they need to be approved by an external approver,
+// like the cluster machine approver.
+func (wmcb *winNodeBootstrapper) WaitForKubeletCertificates(ctx context.Context, timeout time.Duration) (err error) {
+	wmcb.startPhase()
+	defer func() { wmcb.recordPhase(PhaseCertificatesIssued, err) }()
+
+	if wmcb.kubeletSVC == nil {
+		return fmt.Errorf("kubelet service is not present")
+	}
+	wmcb.log.Info("waiting for kubelet certificates", "certDir", wmcb.certDir)
+	err = pollWithContext(ctx, certPollInterval, timeout, func() (bool, error) {
+		return kubeletCertsIssued(wmcb.fileSystem(), wmcb.certDir, wmcb.now()), nil
+	})
+	if err != nil {
+		return newError(ErrTransient, fmt.Errorf("kubelet certificates were not issued, check if the "+
+			"node-bootstrapper and kubelet-serving CSRs for the node have been approved: %w", err))
+	}
+	wmcb.log.Info("kubelet certificates issued")
+	return nil
+}
+
+// RenewKubeletServerCert forces the kubelet to request a new serving certificate by removing the existing one and
+// restarting the kubelet. The kubelet creates a CSR for the serving certificate on start up, and this waits until the
+// CSR has been approved and the signed certificate has been written to the cert dir, the timeout is reached or the
+// context is done. If renewWithin is non zero, the certificate is renewed only if it expires within that duration.
+func (wmcb *winNodeBootstrapper) RenewKubeletServerCert(ctx context.Context, renewWithin, timeout time.Duration) error {
+	if wmcb.kubeletSVC == nil {
+		return fmt.Errorf("kubelet service is not present")
+	}
+
+	if renewWithin != 0 {
+		expiry, err := kubeletServerCertExpiry(wmcb.fileSystem(), wmcb.certDir)
+		// Renew the certificate if it cannot be read as it is either missing or corrupt
+		if err == nil && time.Until(expiry) > renewWithin {
+			wmcb.log.Info("kubelet serving certificate is not due for renewal", "expiry", expiry)
+			return nil
+		}
+	}
+
+	wmcb.log.Info("renewing kubelet serving certificate", "certDir", wmcb.certDir)
+
+	if err := wmcb.kubeletSVC.stop(); err != nil {
+		return fmt.Errorf("unable to stop kubelet service: %v", err)
+	}
+	certFiles, err := glob(wmcb.fileSystem(), filepath.Join(wmcb.certDir, kubeletServerCertPattern))
+	if err != nil {
+		return fmt.Errorf("error finding kubelet serving certificates: %v", err)
+	}
+	for _, certFile := range certFiles {
+		if err := wmcb.fileSystem().Remove(certFile); err != nil {
+			return fmt.Errorf("error removing %s: %v", certFile, err)
+		}
+	}
+	if err := wmcb.kubeletSVC.start(); err != nil {
+		return fmt.Errorf("unable to start kubelet service: %v", err)
+	}
+
+	err = pollWithContext(ctx, certPollInterval, timeout, func() (bool, error) {
+		expiry, err := kubeletServerCertExpiry(wmcb.fileSystem(), wmcb.certDir)
+		if err != nil {
+			return false, nil
+		}
+		return expiry.After(wmcb.now()), nil
+	})
+	if err != nil {
+		return newError(ErrTransient, fmt.Errorf("kubelet serving certificate was not issued, check if the "+
+			"kubelet-serving CSR for the node has been approved: %w", err))
+	}
+	wmcb.log.Info("kubelet serving certificate renewed")
+	return nil
+}
+
+// ScheduleKubeletServerCertRenewal registers a Windows scheduled task that runs the renew-certs command of the given
+// wmcb executable daily, renewing the kubelet serving certificate if it expires within renewWithin. An existing task
+// is replaced.
+func (wmcb *winNodeBootstrapper) ScheduleKubeletServerCertRenewal(wmcbPath string, renewWithin time.Duration) error {
+	if renewWithin <= 0 {
+		return fmt.Errorf("renewWithin needs to be greater than zero for the scheduled renewal")
+	}
+	if _, err := wmcb.fileSystem().Stat(wmcbPath); err != nil {
+		return fmt.Errorf("unable to find wmcb at %s: %v", wmcbPath, err)
+	}
+
+	args := fmt.Sprintf("renew-certs --cert-dir '%s' --renew-within %s", wmcb.certDir, renewWithin)
+	cmd := fmt.Sprintf("$action = New-ScheduledTaskAction -Execute '%s' -Argument \"%s\"; "+
+		"$trigger = New-ScheduledTaskTrigger -Daily -At 3am; "+
+		"Register-ScheduledTask -TaskName '%s' -Action $action -Trigger $trigger -User 'SYSTEM' -RunLevel Highest "+
+		"-Force", wmcbPath, args, certRenewalTaskName)
+	if _, err := runPowerShell(cmd); err != nil {
+		return fmt.Errorf("error registering scheduled task %s: %v", certRenewalTaskName, err)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package bootstrapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	policyv1beta1client "k8s.io/client-go/kubernetes/typed/policy/v1beta1"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// mirrorPodAnnotation is set on the mirror pods of the static pods, which cannot be evicted
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+	// drainPollInterval is the interval at which the eviction of a pod blocked by a PodDisruptionBudget is retried and
+	// the deletion of the evicted pods is checked
+	drainPollInterval = 5 * time.Second
+)
+
+// nodeClient is used to drain and delete the node object of the Windows node
+type nodeClient struct {
+	// core is the client of the core API group
+	core corev1client.CoreV1Interface
+	// policy is the client of the policy API group, which pods are evicted with
+	policy policyv1beta1client.PolicyV1beta1Interface
+}
+
+// newNodeClient returns a nodeClient that talks to the API server using the given kubeconfig
+func newNodeClient(kubeconfigPath string) (*nodeClient, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("error loading kubeconfig %s: %v", kubeconfigPath, err)
+	}
+	core, err := corev1client.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := policyv1beta1client.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &nodeClient{core: core, policy: policy}, nil
+}
+
+// hostNodeName returns the lower case hostname, which is the name the kubelet registers the node with
+func hostNodeName() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("unable to get hostname: %v", err)
+	}
+	return strings.ToLower(hostname), nil
+}
+
+// isEvictable returns true if the given pod is evicted when the node is drained. The mirror pods of the static pods and
+// the pods of DaemonSets are not evicted, as they would be recreated on the node.
+func isEvictable(pod *corev1.Pod) bool {
+	if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+		return false
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "DaemonSet" {
+		return false
+	}
+	return true
+}
+
+// cordon marks the node with the given name as unschedulable
+func (c *nodeClient) cordon(ctx context.Context, nodeName string) error {
+	_, err := c.core.Nodes().Patch(ctx, nodeName, types.StrategicMergePatchType,
+		[]byte(`{"spec":{"unschedulable":true}}`), metav1.PatchOptions{})
+	return err
+}
+
+// evict evicts the given pod, retrying while the eviction is blocked by a PodDisruptionBudget until the context is done
+func (c *nodeClient) evict(ctx context.Context, pod *corev1.Pod) error {
+	eviction := &policyv1beta1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+	return wait.PollImmediateUntil(drainPollInterval, func() (bool, error) {
+		err := c.policy.Evictions(pod.Namespace).Evict(ctx, eviction)
+		if apierrors.IsTooManyRequests(err) {
+			return false, nil
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			return false, err
+		}
+		return true, nil
+	}, ctx.Done())
+}
+
+// drain evicts the pods on the node with the given name, other than the ones that are not evictable, and waits for
+// them to be deleted. Draining is aborted once the timeout elapses or the context is done.
+func (c *nodeClient) drain(ctx context.Context, nodeName string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	pods, err := c.core.Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("error listing pods: %w", err)
+	}
+
+	var evicted []*corev1.Pod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !isEvictable(pod) {
+			continue
+		}
+		if err = c.evict(ctx, pod); err != nil {
+			return fmt.Errorf("error evicting pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+		evicted = append(evicted, pod)
+	}
+
+	for _, pod := range evicted {
+		err = wait.PollImmediateUntil(drainPollInterval, func() (bool, error) {
+			current, err := c.core.Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return true, nil
+			}
+			if err != nil {
+				return false, err
+			}
+			// A pod with the same name may have been recreated by its controller
+			return current.UID != pod.UID, nil
+		}, ctx.Done())
+		if err != nil {
+			return fmt.Errorf("error waiting for pod %s/%s to be deleted: %w", pod.Namespace, pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// stopServices stops the kube-proxy, hybrid-overlay-node, cloud-node-manager and kubelet services that are installed.
+// The services depending on the kubelet service are stopped first.
+func (wmcb *winNodeBootstrapper) stopServices() error {
+	for _, name := range []string{kubeProxyServiceName, kubeletDependentSvc, cloudNodeManagerServiceName,
+		KubeletServiceName} {
+		service, err := wmcb.svcMgr.OpenService(name)
+		if err != nil {
+			if errors.Is(err, errServiceDoesNotExist) {
+				continue
+			}
+			return fmt.Errorf("error getting %s service: %v", name, err)
+		}
+		wmcb.log.Info("stopping service", "service", name)
+		err = stopService(service)
+		service.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CleanupNode deconfigures the Windows node so that it can be removed from the cluster. The node object with the given
+// name is cordoned and drained using the given kubeconfig, which needs to be allowed to evict the pods of the node.
+// The kube-proxy, hybrid-overlay-node and kubelet services are then stopped and the HNS networks used by CNI are
+// deleted along with the endpoints of the pods. The node object is deleted if deleteNode is set, after the kubelet has
+// been stopped so that it does not register the node again. If nodeName is empty, the lower case hostname is used.
+// Draining is aborted once drainTimeout elapses or the context is done. The services are not removed, which is done by
+// Uninstall.
+func (wmcb *winNodeBootstrapper) CleanupNode(ctx context.Context, kubeconfigPath, nodeName string,
+	drainTimeout time.Duration, deleteNode bool) error {
+	client, err := newNodeClient(kubeconfigPath)
+	if err != nil {
+		return err
+	}
+	if nodeName == "" {
+		if nodeName, err = hostNodeName(); err != nil {
+			return err
+		}
+	}
+
+	// The node object may already have been deleted, in which case only the node itself is cleaned up
+	nodeExists := true
+	wmcb.log.Info("cordoning node", "node", nodeName)
+	if err = client.cordon(ctx, nodeName); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return transientError(fmt.Errorf("error cordoning node %s: %w", nodeName, err))
+		}
+		wmcb.log.Info("node object not found, skipping drain", "node", nodeName)
+		nodeExists = false
+	}
+	if nodeExists {
+		wmcb.log.Info("draining node", "node", nodeName, "timeout", drainTimeout)
+		if err = client.drain(ctx, nodeName, drainTimeout); err != nil {
+			return transientError(fmt.Errorf("error draining node %s: %w", nodeName, err))
+		}
+	}
+
+	if err = wmcb.stopServices(); err != nil {
+		return err
+	}
+	networks, err := deleteHNSNetworks()
+	if err != nil {
+		return err
+	}
+	wmcb.log.Info("deleted HNS networks", "networks", networks)
+
+	if deleteNode && nodeExists {
+		wmcb.log.Info("deleting node object", "node", nodeName)
+		err = client.core.Nodes().Delete(ctx, nodeName, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return transientError(fmt.Errorf("error deleting node %s: %w", nodeName, err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package bootstrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+)
+
+// cloudConfigSecretsName is the name of the file in the install dir the secrets removed from the cloud config are kept
+// in, encrypted with DPAPI
+const cloudConfigSecretsName = "cloud-config-secrets.dpapi"
+
+// cloudConfigSecretFields are the fields of the Azure cloud config holding the credentials of the service principal,
+// which are not needed by the kubelet once it uses the managed identity of the VM
+var cloudConfigSecretFields = []string{"aadClientSecret", "aadClientCertPassword"}
+
+// SetCloudConfigManagedIdentity configures InitializeKubelet to rewrite the Azure cloud config of the ignition file so
+// that the kubelet authenticates with the managed identity of the VM: the user assigned identity with the given ID,
+// or the system assigned one if the ID is empty. The service principal secrets of the cloud config are removed from
+// it and kept in the install dir, encrypted with DPAPI for the local machine, where they can be read with
+// CloudConfigSecrets. This needs to be called before InitializeKubelet to take effect.
+func (wmcb *winNodeBootstrapper) SetCloudConfigManagedIdentity(identityID string) {
+	wmcb.cloudConfigManagedIdentity = true
+	wmcb.userAssignedIdentityID = identityID
+}
+
+// CloudConfigSecrets returns the service principal secrets removed from the Azure cloud config in the given install
+// dir when the kubelet was configured to use the managed identity of the VM, by the name of their cloud config field
+func CloudConfigSecrets(installDir string) (map[string]string, error) {
+	return cloudConfigSecrets(osFileSystem, installDir)
+}
+
+// cloudConfigSecrets returns the service principal secrets kept in the given install dir of the given file system
+func cloudConfigSecrets(fs FileSystem, installDir string) (map[string]string, error) {
+	encrypted, err := readFile(fs, filepath.Join(installDir, cloudConfigSecretsName))
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := dpapiUnprotect(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting cloud config secrets: %w", err)
+	}
+	secrets := make(map[string]string)
+	if err = json.Unmarshal(decrypted, &secrets); err != nil {
+		return nil, fmt.Errorf("error parsing cloud config secrets: %w", err)
+	}
+	return secrets, nil
+}
+
+// useManagedIdentity is a translationFunc rewriting the given Azure cloud config to use the managed identity of the
+// VM. The service principal secrets of the cloud config are written to the install dir, encrypted with DPAPI. They are
+// only written if they changed, as the encryption is not deterministic.
+func useManagedIdentity(wmcb *winNodeBootstrapper, contents []byte) ([]byte, error) {
+	var config map[string]interface{}
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return nil, fmt.Errorf("cloud config is not an Azure cloud config: %w", err)
+	}
+	secrets := make(map[string]string)
+	for _, field := range cloudConfigSecretFields {
+		if secret, ok := config[field].(string); ok && secret != "" {
+			secrets[field] = secret
+			config[field] = ""
+		}
+	}
+	config["useManagedIdentityExtension"] = true
+	if wmcb.userAssignedIdentityID != "" {
+		config["userAssignedIdentityID"] = wmcb.userAssignedIdentityID
+	}
+
+	if len(secrets) > 0 {
+		existing, err := cloudConfigSecrets(wmcb.fileSystem(), wmcb.installDir)
+		if err != nil || !reflect.DeepEqual(existing, secrets) {
+			if err = wmcb.writeSecrets(secrets); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return json.MarshalIndent(config, "", "\t")
+}
+
+// writeSecrets writes the given cloud config secrets to the install dir, encrypted with DPAPI for the local machine
+func (wmcb *winNodeBootstrapper) writeSecrets(secrets map[string]string) error {
+	decrypted, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	encrypted, err := dpapiProtect(decrypted)
+	if err != nil {
+		return fmt.Errorf("error encrypting cloud config secrets: %w", err)
+	}
+	path := filepath.Join(wmcb.installDir, cloudConfigSecretsName)
+	if _, err = wmcb.writeFile(path, encrypted); err != nil {
+		return fmt.Errorf("could not write to %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package bootstrapper
+
+// dpapiProtect returns errUnsupportedPlatform as DPAPI is not available
+func dpapiProtect(data []byte) ([]byte, error) {
+	return nil, errUnsupportedPlatform
+}
+
+// dpapiUnprotect returns errUnsupportedPlatform as DPAPI is not available
+func dpapiUnprotect(data []byte) ([]byte, error) {
+	return nil, errUnsupportedPlatform
+}
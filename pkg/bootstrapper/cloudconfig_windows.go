@@ -0,0 +1,65 @@
+package bootstrapper
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// cryptProtectLocalMachine encrypts the data for the local machine, so that any process of the node can decrypt it
+	// rather than only the processes of the user that encrypted it
+	cryptProtectLocalMachine = 0x4
+	// cryptProtectUIForbidden fails the encryption and decryption instead of prompting the user
+	cryptProtectUIForbidden = 0x1
+)
+
+var (
+	crypt32                = windows.NewLazySystemDLL("crypt32.dll")
+	procCryptProtectData   = crypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+)
+
+// dataBlob is the DATA_BLOB the DPAPI functions take and return data as
+type dataBlob struct {
+	size uint32
+	data *byte
+}
+
+// newDataBlob returns the given data as a DATA_BLOB
+func newDataBlob(data []byte) *dataBlob {
+	if len(data) == 0 {
+		return &dataBlob{}
+	}
+	return &dataBlob{size: uint32(len(data)), data: &data[0]}
+}
+
+// bytes copies the data of the DATA_BLOB returned by a DPAPI function and frees it
+func (b *dataBlob) bytes() []byte {
+	data := make([]byte, b.size)
+	copy(data, (*[1 << 30]byte)(unsafe.Pointer(b.data))[:b.size:b.size])
+	windows.LocalFree(windows.Handle(unsafe.Pointer(b.data)))
+	return data
+}
+
+// dpapiProtect encrypts the given data with DPAPI for the local machine
+func dpapiProtect(data []byte) ([]byte, error) {
+	var out dataBlob
+	r, _, err := procCryptProtectData.Call(uintptr(unsafe.Pointer(newDataBlob(data))), 0, 0, 0, 0,
+		cryptProtectLocalMachine|cryptProtectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	return out.bytes(), nil
+}
+
+// dpapiUnprotect decrypts the given data encrypted with DPAPI
+func dpapiUnprotect(data []byte) ([]byte, error) {
+	var out dataBlob
+	r, _, err := procCryptUnprotectData.Call(uintptr(unsafe.Pointer(newDataBlob(data))), 0, 0, 0, 0,
+		cryptProtectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	return out.bytes(), nil
+}
@@ -0,0 +1,156 @@
+package bootstrapper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// externalCloudProvider is the cloud-provider kubelet argument of the clusters running the cloud controller manager
+	// of their cloud provider, where the node is initialized by the cloud node manager rather than by the kubelet
+	externalCloudProvider = "external"
+	// cloudNodeManagerServiceName is the name of the cloud node manager Windows service
+	cloudNodeManagerServiceName = "cloud-node-manager"
+	// cloudNodeManagerExe is the name the cloud node manager executable is installed with
+	cloudNodeManagerExe = "cloud-node-manager.exe"
+)
+
+// cloudNodeManagerOptions holds the cloud node manager specific information
+type cloudNodeManagerOptions struct {
+	// path is the location where the cloud node manager, for example azure-cloud-node-manager.exe, has been
+	// downloaded to
+	path string
+	// nodeName is the name of the node object of this Windows node
+	nodeName string
+}
+
+// SetExternalCloudProvider configures InitializeKubelet to run the kubelet with --cloud-provider=external, as in the
+// clusters where the cloud controller manager of the cloud provider initializes the nodes, whatever the cloud provider
+// of the kubelet of the ignition file. The kubelet is then not given the cloud config of the ignition file, which is
+// only installed if an image credential provider requires it. If cloudNodeManagerPath is given, the cloud node manager,
+// for example azure-cloud-node-manager.exe, is installed and run as a Windows service for the given node, so that the
+// node is initialized without running the cloud node manager as a pod.
+// If nodeName is empty, the lower case hostname is used as that is the name the kubelet registers the node with.
+// The ignition files of the clusters using an external cloud provider are detected without calling this.
+func (wmcb *winNodeBootstrapper) SetExternalCloudProvider(cloudNodeManagerPath, nodeName string) error {
+	wmcb.externalCloudProvider = true
+	wmcb.cloudNodeManager = nil
+	if cloudNodeManagerPath == "" {
+		return nil
+	}
+	if _, err := wmcb.fileSystem().Stat(cloudNodeManagerPath); err != nil {
+		return fmt.Errorf("unable to find cloud node manager at %s: %v", cloudNodeManagerPath, err)
+	}
+	if nodeName == "" {
+		var err error
+		if nodeName, err = hostNodeName(); err != nil {
+			return err
+		}
+	}
+	wmcb.cloudNodeManager = &cloudNodeManagerOptions{
+		path:     cloudNodeManagerPath,
+		nodeName: nodeName,
+	}
+	return nil
+}
+
+// isExternalCloudProvider returns true if the kubelet is run with --cloud-provider=external. This assumes that the
+// ignition file has been parsed.
+func (wmcb *winNodeBootstrapper) isExternalCloudProvider() bool {
+	return wmcb.kubeletArgs["cloud-provider"] == externalCloudProvider
+}
+
+// cloudConfigRequired returns true if the cloud config of the ignition file needs to be installed. It is passed to
+// the kubelet unless the cloud provider is external, in which case it is only required by the image credential
+// providers taking it as an argument.
+func (wmcb *winNodeBootstrapper) cloudConfigRequired() bool {
+	if !wmcb.isExternalCloudProvider() {
+		return true
+	}
+	for _, pluginPath := range wmcb.credentialProviders {
+		if supportedCredentialProviders[credentialProviderName(pluginPath)].cloudConfigArg {
+			return true
+		}
+	}
+	return false
+}
+
+// cloudNodeManagerLogDir returns the directory the cloud node manager logs to
+func (wmcb *winNodeBootstrapper) cloudNodeManagerLogDir() string {
+	return filepath.Join(filepath.Dir(wmcb.logDir), cloudNodeManagerServiceName)
+}
+
+// getCloudNodeManagerArgs returns the arguments the cloud node manager Windows service is run with
+func (wmcb *winNodeBootstrapper) getCloudNodeManagerArgs() []string {
+	return []string{
+		"--windows-service",
+		"--node-name=" + wmcb.cloudNodeManager.nodeName,
+		"--kubeconfig=" + wmcb.kubeconfigPath,
+		// The routes of the Windows nodes are not managed by the cloud provider
+		"--wait-routes=false",
+		"--logtostderr=false",
+		"--log-file=" + filepath.Join(wmcb.cloudNodeManagerLogDir(), "cloud-node-manager.log"),
+	}
+}
+
+// ensureCloudNodeManagerService copies the cloud node manager to the install directory and creates the
+// cloud-node-manager Windows service if it is not already present, else updates the existing service. The service
+// depends on the kubelet service and is started along with it.
+func (wmcb *winNodeBootstrapper) ensureCloudNodeManagerService() error {
+	if err := wmcb.fileSystem().MkdirAll(wmcb.cloudNodeManagerLogDir(), os.ModeDir); err != nil {
+		return fmt.Errorf("could not make %s directory: %v", wmcb.cloudNodeManagerLogDir(), err)
+	}
+
+	cloudNodeManagerService, err := wmcb.svcMgr.OpenService(cloudNodeManagerServiceName)
+	if err != nil && !errors.Is(err, errServiceDoesNotExist) {
+		return fmt.Errorf("error getting existing cloud-node-manager service: %v", err)
+	}
+	if cloudNodeManagerService != nil {
+		defer cloudNodeManagerService.Close()
+		// The executable cannot be replaced while the service is running
+		if err := stopService(cloudNodeManagerService); err != nil {
+			return fmt.Errorf("unable to stop cloud-node-manager service: %v", err)
+		}
+	}
+
+	cloudNodeManagerPath := filepath.Join(wmcb.installDir, cloudNodeManagerExe)
+	if err := wmcb.journal.fileWritten(cloudNodeManagerPath); err != nil {
+		return err
+	}
+	if err := copyFile(wmcb.fileSystem(), wmcb.cloudNodeManager.path, cloudNodeManagerPath); err != nil {
+		return fmt.Errorf("error copying %s --> %s: %v", wmcb.cloudNodeManager.path, cloudNodeManagerPath, err)
+	}
+
+	c := serviceConfig{
+		// StartAutomatic will start the service again if the node restarts
+		StartType: startAutomatic,
+		// The cloud node manager initializes the node the kubelet registers
+		Dependencies: []string{KubeletServiceName},
+		Description:  "Kubernetes cloud node manager",
+	}
+	service, err := createOrUpdateService(wmcb.svcMgr, wmcb.journal, cloudNodeManagerService,
+		cloudNodeManagerServiceName, cloudNodeManagerPath, c, wmcb.getCloudNodeManagerArgs())
+	if err != nil {
+		return err
+	}
+	if cloudNodeManagerService == nil {
+		defer service.Close()
+	}
+	if err := wmcb.serviceRecovery.apply(service); err != nil {
+		return fmt.Errorf("failed to set recovery actions for Windows service %s: %v", cloudNodeManagerServiceName,
+			err)
+	}
+	return nil
+}
+
+// startCloudNodeManagerService starts the cloud-node-manager Windows service if it is not running
+func (wmcb *winNodeBootstrapper) startCloudNodeManagerService() error {
+	service, err := wmcb.svcMgr.OpenService(cloudNodeManagerServiceName)
+	if err != nil {
+		return fmt.Errorf("error getting cloud-node-manager service: %v", err)
+	}
+	defer service.Close()
+	return startService(service)
+}
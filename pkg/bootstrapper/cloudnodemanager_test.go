@@ -0,0 +1,84 @@
+package bootstrapper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestExternalCloudProvider tests that the kubelet is run with --cloud-provider=external without the cloud config, and
+// that the cloud node manager is installed as a Windows service depending on the kubelet
+func TestExternalCloudProvider(t *testing.T) {
+	ignitionContents := `{"ignition":{"version":"3.1.0"},"storage":{"files":[{"path":"/etc/kubernetes/cloud.conf",` +
+		`"contents":{"source":"data:,%7B%7D"},"mode":420}]},"systemd":{"units":[{"name":"kubelet.service",` +
+		`"contents":"ExecStart=/usr/bin/hyperkube kubelet --cloud-provider=PROVIDER ` +
+		`--cloud-config=/etc/kubernetes/cloud.conf"}]}}`
+	ignition := func(cloudProvider string) []byte {
+		return []byte(strings.Replace(ignitionContents, "PROVIDER", cloudProvider, 1))
+	}
+	newBootstrapper := func() (*winNodeBootstrapper, FileSystem) {
+		fs := newMemFS()
+		require.NoError(t, fs.MkdirAll(`C:\k`, 0755))
+		require.NoError(t, fs.MkdirAll(`C:\var\log\kubelet`, 0755))
+		return &winNodeBootstrapper{installDir: "C:/k", logDir: "C:/var/log/kubelet",
+			kubeconfigPath: `C:\k\kubeconfig`, kubeletArgs: make(map[string]string), fs: fs}, fs
+	}
+
+	t.Run("In-tree cloud provider", func(t *testing.T) {
+		wmcb, fs := newBootstrapper()
+		require.NoError(t, wmcb.parseIgnitionFileContents(ignition("azure"), map[string]fileTranslation{}))
+		assert.False(t, wmcb.isExternalCloudProvider())
+		assert.Contains(t, wmcb.getInitialKubeletArgs(), "--cloud-config="+filepath.Join("C:/k", "cloud.conf"))
+		_, err := fs.Stat(`C:\k\cloud.conf`)
+		assert.NoError(t, err, "expected the cloud config to be installed")
+	})
+
+	t.Run("External cloud provider of the ignition file", func(t *testing.T) {
+		wmcb, fs := newBootstrapper()
+		require.NoError(t, wmcb.parseIgnitionFileContents(ignition("external"), map[string]fileTranslation{}))
+		assert.True(t, wmcb.isExternalCloudProvider())
+		args := wmcb.getInitialKubeletArgs()
+		assert.Contains(t, args, "--cloud-provider=external")
+		for _, arg := range args {
+			assert.False(t, strings.HasPrefix(arg, "--cloud-config"), "unexpected kubelet arg %s", arg)
+		}
+		_, err := fs.Stat(`C:\k\cloud.conf`)
+		assert.True(t, os.IsNotExist(err), "expected the cloud config not to be installed")
+	})
+
+	t.Run("Forced external cloud provider with cloud node manager", func(t *testing.T) {
+		wmcb, fs := newBootstrapper()
+		assert.Error(t, wmcb.SetExternalCloudProvider("C:/missing/azure-cloud-node-manager.exe", "node"))
+		require.NoError(t, fs.MkdirAll(`C:\source`, 0755))
+		require.NoError(t, writeFile(fs, `C:\source\azure-cloud-node-manager.exe`, []byte("binary"), 0644))
+		require.NoError(t, wmcb.SetExternalCloudProvider("C:/source/azure-cloud-node-manager.exe", "winnode"))
+		// The cloud config is kept for the image credential provider requiring it, but not given to the kubelet
+		wmcb.credentialProviders = []string{"C:/source/acr-credential-provider.exe"}
+		require.NoError(t, wmcb.parseIgnitionFileContents(ignition("azure"), map[string]fileTranslation{}))
+		args := wmcb.getInitialKubeletArgs()
+		assert.Contains(t, args, "--cloud-provider=external")
+		assert.NotContains(t, args, "--cloud-config="+filepath.Join("C:/k", "cloud.conf"))
+		_, err := fs.Stat(`C:\k\cloud.conf`)
+		assert.NoError(t, err, "expected the cloud config to be installed for the image credential provider")
+
+		svcMgr := newFakeServiceManager()
+		wmcb.svcMgr = svcMgr
+		require.NoError(t, wmcb.ensureCloudNodeManagerService())
+		contents, err := readFile(fs, `C:\k\cloud-node-manager.exe`)
+		require.NoError(t, err)
+		assert.Equal(t, "binary", string(contents))
+		config, err := svcMgr.services[cloudNodeManagerServiceName].Config()
+		require.NoError(t, err)
+		assert.Equal(t, []string{KubeletServiceName}, config.Dependencies)
+		assert.Contains(t, config.BinaryPathName, "--node-name=winnode")
+		assert.Contains(t, config.BinaryPathName, `--kubeconfig=C:\k\kubeconfig`)
+		require.NoError(t, wmcb.startCloudNodeManagerService())
+		running, err := isServiceRunning(svcMgr.services[cloudNodeManagerServiceName])
+		require.NoError(t, err)
+		assert.True(t, running)
+	})
+}
@@ -0,0 +1,226 @@
+package bootstrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// cniConfigExtensions are the extensions of the files the kubelet loads the CNI configs from in its CNI conf dir
+	cniConfigExtensions = []string{".conf", ".conflist", ".json"}
+	// hnsPluginTypes are the CNI plugins that attach the pods to HNS networks, which require an IPAM config
+	hnsPluginTypes = []string{"win-bridge", "win-overlay", "sdnbridge", "sdnoverlay"}
+)
+
+// cniConfigFile is a validated CNI config, or CNI config list, file
+type cniConfigFile struct {
+	// path is the location of the file
+	path string
+	// contents are the contents of the file with the template fields substituted
+	contents []byte
+	// networkName is the name of the network the config is for
+	networkName string
+}
+
+// cniPlugin holds the fields of a CNI plugin config that are validated
+type cniPlugin struct {
+	// Type is the name of the plugin binary
+	Type string `json:"type"`
+	// IPAM is the config of the IPAM plugin used by the plugin
+	IPAM *struct {
+		Type string `json:"type"`
+	} `json:"ipam"`
+	// Policies are the HNS endpoint policies applied by the Windows plugins
+	Policies []struct {
+		Name  string          `json:"name"`
+		Value json.RawMessage `json:"value"`
+	} `json:"policies"`
+}
+
+// isCNIConfigFile returns true if the kubelet loads CNI configs from a file with the given name
+func isCNIConfigFile(name string) bool {
+	for _, extension := range cniConfigExtensions {
+		if filepath.Ext(name) == extension {
+			return true
+		}
+	}
+	return false
+}
+
+// isHNSPlugin returns true if the given CNI plugin attaches the pods to an HNS network
+func isHNSPlugin(pluginType string) bool {
+	for _, hnsPluginType := range hnsPluginTypes {
+		if pluginType == hnsPluginType {
+			return true
+		}
+	}
+	return false
+}
+
+// cniConfigPaths returns the paths of the CNI config files at the given path of the given file system, which is
+// either a single CNI config file or a directory of CNI config files. The files in a directory are returned in lexical
+// order, which is the order the kubelet loads them in.
+func cniConfigPaths(fs FileSystem, config string) ([]string, error) {
+	info, err := fs.Stat(config)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{config}, nil
+	}
+
+	// ReadDir returns the files sorted by name
+	files, err := fs.ReadDir(config)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, file := range files {
+		if !file.IsDir() && isCNIConfigFile(file.Name()) {
+			paths = append(paths, filepath.Join(config, file.Name()))
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no CNI config files with the %s extensions present in %s",
+			strings.Join(cniConfigExtensions, ", "), config)
+	}
+	return paths, nil
+}
+
+// parseCNIConfig validates that the given contents are a CNI config, or a CNI config list if isList is set, whose HNS
+// plugins have the fields required on Windows, and returns the name of the network of the config
+func parseCNIConfig(contents []byte, isList bool) (string, error) {
+	var config struct {
+		CNIVersion string `json:"cniVersion"`
+		Name       string `json:"name"`
+		cniPlugin
+		Plugins []cniPlugin `json:"plugins"`
+	}
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return "", fmt.Errorf("error parsing JSON: %v", err)
+	}
+	if config.CNIVersion == "" {
+		return "", fmt.Errorf("cniVersion is missing")
+	}
+	if config.Name == "" {
+		return "", fmt.Errorf("network name is missing")
+	}
+
+	plugins := []cniPlugin{config.cniPlugin}
+	if isList {
+		if len(config.Plugins) == 0 {
+			return "", fmt.Errorf("config list has no plugins")
+		}
+		plugins = config.Plugins
+	}
+	for i, plugin := range plugins {
+		if plugin.Type == "" {
+			return "", fmt.Errorf("type of plugin %d is missing", i)
+		}
+		if !isHNSPlugin(plugin.Type) {
+			continue
+		}
+		if plugin.IPAM == nil || plugin.IPAM.Type == "" {
+			return "", fmt.Errorf("IPAM type of the %s plugin is missing", plugin.Type)
+		}
+		for _, policy := range plugin.Policies {
+			if policy.Name == "" || len(policy.Value) == 0 {
+				return "", fmt.Errorf("%s plugin has a policy without a name or value", plugin.Type)
+			}
+		}
+	}
+	return config.Name, nil
+}
+
+// loadCNIConfigs returns the validated CNI config files at the given path of the given file system, which is either a
+// single CNI config file or a directory of CNI config files, in the order the kubelet loads them in. The configs are
+// treated as templates and the given values are substituted into them before they are validated. The first config is
+// the primary config, which is the one used by the kubelet. An error is returned if the configs conflict with each
+// other.
+func loadCNIConfigs(fs FileSystem, config string, data map[string]string) ([]cniConfigFile, error) {
+	paths, err := cniConfigPaths(fs, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []cniConfigFile
+	// networks holds the config file of each network and names holds the config file of each file name without its
+	// extension, to detect the conflicting configs
+	networks := make(map[string]string)
+	names := make(map[string]string)
+	for _, path := range paths {
+		fileName := filepath.Base(path)
+		if !isCNIConfigFile(fileName) {
+			return nil, fmt.Errorf("CNI config %s needs one of the %s extensions to be loaded by the kubelet", path,
+				strings.Join(cniConfigExtensions, ", "))
+		}
+		contents, err := readFile(fs, path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CNI config %s: %v", path, err)
+		}
+		if contents, err = renderCNIConfig(fileName, contents, data); err != nil {
+			return nil, fmt.Errorf("invalid CNI config template %s: %v", path, err)
+		}
+		networkName, err := parseCNIConfig(contents, filepath.Ext(fileName) == ".conflist")
+		if err != nil {
+			return nil, fmt.Errorf("invalid CNI config %s: %v", path, err)
+		}
+
+		if other, ok := networks[networkName]; ok {
+			return nil, fmt.Errorf("CNI configs %s and %s conflict as both are for the %s network", other, path,
+				networkName)
+		}
+		networks[networkName] = path
+		name := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("CNI configs %s and %s conflict as their names only differ in the extension", other,
+				path)
+		}
+		names[name] = path
+
+		configs = append(configs, cniConfigFile{path: path, contents: contents, networkName: networkName})
+	}
+	return configs, nil
+}
+
+// removeStaleCNIConfigs removes the CNI config files in the given CNI conf dir of the given file system that are not in
+// the given configs, so that the kubelet does not load the configs of a previous configuration. The removals are
+// recorded in the given journal.
+func removeStaleCNIConfigs(fs FileSystem, j *journal, confDir string, configs []cniConfigFile) error {
+	stale, err := staleCNIConfigs(fs, confDir, configs)
+	if err != nil {
+		return err
+	}
+	for _, path := range stale {
+		if err := j.fileRemoved(path); err != nil {
+			return err
+		}
+		if err := fs.Remove(path); err != nil {
+			return fmt.Errorf("error removing stale CNI config: %v", err)
+		}
+	}
+	return nil
+}
+
+// staleCNIConfigs returns the paths of the CNI config files in the given CNI conf dir of the given file system that are
+// not in the given configs
+func staleCNIConfigs(fs FileSystem, confDir string, configs []cniConfigFile) ([]string, error) {
+	current := make(map[string]bool)
+	for _, config := range configs {
+		current[filepath.Base(config.path)] = true
+	}
+	files, err := fs.ReadDir(confDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CNI conf dir %s: %v", confDir, err)
+	}
+	var stale []string
+	for _, file := range files {
+		if file.IsDir() || !isCNIConfigFile(file.Name()) || current[file.Name()] {
+			continue
+		}
+		stale = append(stale, filepath.Join(confDir, file.Name()))
+	}
+	return stale, nil
+}
@@ -0,0 +1,57 @@
+package bootstrapper
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// remoteRuntimeArg is the kubelet argument of a node using a CRI container runtime, like containerd, rather than the
+// dockershim
+const remoteRuntimeArg = "--container-runtime=remote"
+
+// cniLayout is the location of the CNI plugins and configs in the install directory, and how it is given to the
+// container runtime. It is the single source of the CNI paths of the kubelet arguments, the containerd config and the
+// other components reading the CNI directories.
+type cniLayout struct {
+	// binDir is the directory the CNI plugins are installed to
+	binDir string
+	// confDir is the directory the CNI configs are written to
+	confDir string
+	// kubeletFlags indicates that the CNI directories are given to the kubelet with the dockershim CNI flags. With
+	// containerd, they are only read from the CRI plugin config of containerd, and the dockershim flags, which the
+	// kubelet no longer accepts from 1.24, are not set.
+	kubeletFlags bool
+}
+
+// newCNILayout returns the layout of the CNI directories in the given install directory for the given container
+// runtime, the docker one if it is empty
+func newCNILayout(installDir, runtime string) cniLayout {
+	return cniLayout{
+		binDir:       filepath.Join(installDir, cniDirName),
+		confDir:      filepath.Join(installDir, cniConfigDirName),
+		kubeletFlags: runtime != containerdRuntime,
+	}
+}
+
+// cniLayout returns the layout of the CNI directories for the container runtime the kubelet is configured with
+func (wmcb *winNodeBootstrapper) cniLayout() cniLayout {
+	return newCNILayout(wmcb.installDir, wmcb.containerRuntime)
+}
+
+// kubeletCommandRuntime returns the container runtime the given kubelet command is configured with
+func kubeletCommandRuntime(kubeletCmd string) string {
+	if strings.Contains(kubeletCmd, remoteRuntimeArg) {
+		return containerdRuntime
+	}
+	return dockerRuntime
+}
+
+// setCNILayout sets the layout of the CNI directories of the CNI options for the container runtime the kubelet is
+// configured with. The runtime is taken from the given command of the kubelet service if it was not set, as the
+// kubelet is configured for CNI by another invocation than the one that initialized it.
+func (wmcb *winNodeBootstrapper) setCNILayout(kubeletCmd string) {
+	if wmcb.containerRuntime == "" {
+		wmcb.containerRuntime = kubeletCommandRuntime(kubeletCmd)
+	}
+	wmcb.cni.layout = wmcb.cniLayout()
+}
@@ -0,0 +1,136 @@
+package bootstrapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"text/template"
+)
+
+// cniConfigValues holds the cluster specific values substituted into the CNI config templates
+type cniConfigValues struct {
+	// serviceCIDR is the CIDR range of the services in the cluster
+	serviceCIDR string
+	// clusterCIDR is the CIDR range of the pods in the cluster
+	clusterCIDR string
+	// sourceVIP is the IP address used as the source of the load balanced traffic on the node
+	sourceVIP string
+	// dnsServerIP is the IP address of the cluster DNS service
+	dnsServerIP string
+	// networkName is the name of the HNS network the pods are attached to
+	networkName string
+}
+
+// SetCNIConfigValues sets the cluster specific values substituted for the {{.ServiceCIDR}}, {{.ClusterCIDR}},
+// {{.SourceVIP}}, {{.DNSServerIP}} and {{.NetworkName}} fields of the CNI config templates, so that the same CNI config
+// can be used across clusters. Empty values are not substituted, and a CNI config referring to a value that is not
+// known fails to be configured. If dnsServerIP is empty, the first cluster DNS IP of the kubelet configuration is used.
+// If networkName is empty, the HNS network of the hybrid overlay is used when it has been enabled. This needs to be
+// called before Configure to take effect.
+func (wmcb *winNodeBootstrapper) SetCNIConfigValues(serviceCIDR, clusterCIDR, sourceVIP, dnsServerIP,
+	networkName string) error {
+	if wmcb.cni == nil {
+		return fmt.Errorf("CNI config values can only be set along with CNI")
+	}
+	for _, cidr := range []string{serviceCIDR, clusterCIDR} {
+		if cidr == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid CIDR %s: %v", cidr, err)
+		}
+	}
+	for _, ip := range []string{sourceVIP, dnsServerIP} {
+		if ip != "" && net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid IP address %s", ip)
+		}
+	}
+	wmcb.cni.values = cniConfigValues{
+		serviceCIDR: serviceCIDR,
+		clusterCIDR: clusterCIDR,
+		sourceVIP:   sourceVIP,
+		dnsServerIP: dnsServerIP,
+		networkName: networkName,
+	}
+	return nil
+}
+
+// setCNIConfigDefaults sets the CNI config values that have not been given to the values taken from the kubelet
+// configuration, if it is present
+func (wmcb *winNodeBootstrapper) setCNIConfigDefaults() error {
+	if wmcb.cni.values.dnsServerIP != "" {
+		return nil
+	}
+	content, err := readFile(wmcb.fileSystem(), wmcb.kubeletConfPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error reading kubelet configuration: %v", err)
+	}
+	dnsServerIP, err := kubeletClusterDNSIP(content)
+	if err != nil {
+		return fmt.Errorf("error parsing kubelet configuration %s: %v", wmcb.kubeletConfPath, err)
+	}
+	wmcb.cni.values.dnsServerIP = dnsServerIP
+	return nil
+}
+
+// kubeletClusterDNSIP returns the first cluster DNS IP of the given kubelet configuration, or an empty string if it
+// has none
+func kubeletClusterDNSIP(kubeletConf []byte) (string, error) {
+	var config struct {
+		ClusterDNS []string `json:"clusterDNS"`
+	}
+	if err := json.Unmarshal(kubeletConf, &config); err != nil {
+		return "", err
+	}
+	if len(config.ClusterDNS) == 0 {
+		return "", nil
+	}
+	return config.ClusterDNS[0], nil
+}
+
+// templateData returns the values substituted into the CNI config templates. Only the values that are known are
+// present, so that rendering a template referring to an unknown value fails.
+func (cni *cniOptions) templateData() (map[string]string, error) {
+	networkName := cni.values.networkName
+	if networkName == "" {
+		networkName = cni.networkName
+	}
+	// The network name is the only value that is not validated, so it is escaped to be placed in a JSON string
+	escapedNetworkName, err := json.Marshal(networkName)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{
+		"ServiceCIDR": cni.values.serviceCIDR,
+		"ClusterCIDR": cni.values.clusterCIDR,
+		"SourceVIP":   cni.values.sourceVIP,
+		"DNSServerIP": cni.values.dnsServerIP,
+		"NetworkName": string(escapedNetworkName[1 : len(escapedNetworkName)-1]),
+	}
+	data := make(map[string]string)
+	for key, value := range values {
+		if value != "" {
+			data[key] = value
+		}
+	}
+	return data, nil
+}
+
+// renderCNIConfig returns the given CNI config with the template fields substituted with the given values. An error is
+// returned if the config refers to a value that is not present.
+func renderCNIConfig(name string, contents []byte, data map[string]string) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template: %v", err)
+	}
+	var rendered bytes.Buffer
+	if err = tmpl.Execute(&rendered, data); err != nil {
+		return nil, fmt.Errorf("error substituting values: %v", err)
+	}
+	return rendered.Bytes(), nil
+}
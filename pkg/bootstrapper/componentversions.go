@@ -0,0 +1,217 @@
+package bootstrapper
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+const (
+	// csiProxyServiceName is the name of the Windows service of the CSI proxy, which is installed by the cluster
+	// administrator rather than by WMCB
+	csiProxyServiceName = "csiproxy"
+	// csiProxyExe is the name of the CSI proxy executable
+	csiProxyExe = "csi-proxy.exe"
+	// versionCommandTimeout is the time the executables are given to print their version
+	versionCommandTimeout = 10 * time.Second
+)
+
+var (
+	// versionRegex matches the first version printed by an executable, like v1.20.0 in Kubernetes v1.20.0
+	versionRegex = regexp.MustCompile(`v?[0-9]+\.[0-9]+(\.[0-9]+)?[-+.0-9A-Za-z]*`)
+	// criV1Kubelet is the first kubelet version only supporting the v1 CRI API, which containerd serves since 1.6
+	criV1Kubelet = version.MustParseGeneric("1.26.0")
+	// criV1Containerd is the first containerd version serving the v1 CRI API
+	criV1Containerd = version.MustParseGeneric("1.6.0")
+)
+
+// ComponentVersion is the version of a component of the Windows node
+type ComponentVersion struct {
+	// Name is the name of the component
+	Name string `json:"name"`
+	// Path is the location of the executable of the component
+	Path string `json:"path,omitempty"`
+	// Version is the version of the component. It is empty if the component is not installed or its version could not
+	// be determined.
+	Version string `json:"version,omitempty"`
+	// Error describes why the version is empty
+	Error string `json:"error,omitempty"`
+}
+
+// VersionReport holds the versions of the components installed on the Windows node, along with the incompatibilities
+// found between them
+type VersionReport struct {
+	// Components are the versions of the kubelet, containerd, CNI plugins, hybrid-overlay-node and CSI proxy, and of
+	// the API server if it can be reached
+	Components []ComponentVersion `json:"components"`
+	// Incompatibilities describe the components that do not work together or are not supported by WMCB
+	Incompatibilities []string `json:"incompatibilities,omitempty"`
+}
+
+// Compatible returns true if no incompatibility was found between the components
+func (r *VersionReport) Compatible() bool {
+	return len(r.Incompatibilities) == 0
+}
+
+// add adds the version of the given component to the report, and returns it parsed if it could be determined
+func (r *VersionReport) add(name, path, printed string, err error) *version.Version {
+	component := ComponentVersion{Name: name, Path: path, Version: printed}
+	if err != nil {
+		component.Error = err.Error()
+	}
+	r.Components = append(r.Components, component)
+	if printed == "" {
+		return nil
+	}
+	parsed, err := version.ParseGeneric(printed)
+	if err != nil {
+		return nil
+	}
+	return parsed
+}
+
+// Versions returns the versions of the components installed on the Windows node, as reported by their executables,
+// and checks them for incompatibilities: a kubelet version not supported by WMCB or not compatible with the API
+// server, a kubelet without the dockershim run with the docker runtime, and a containerd too old for the kubelet. The
+// API server is only reached if the kubelet kubeconfig is present.
+func (wmcb *winNodeBootstrapper) Versions(ctx context.Context) *VersionReport {
+	report := &VersionReport{}
+
+	kubeletPath := filepath.Join(wmcb.installDir, "kubelet.exe")
+	printed, err := wmcb.executableVersion(ctx, kubeletPath, "--version")
+	kubelet := report.add("kubelet", kubeletPath, printed, err)
+
+	containerdPath := filepath.Join(wmcb.containerdInstallDir(), containerdExe)
+	printed, err = wmcb.executableVersion(ctx, containerdPath, "--version")
+	containerd := report.add("containerd", containerdPath, printed, err)
+
+	cniDir := wmcb.cniLayout().binDir
+	if files, err := wmcb.fileSystem().ReadDir(cniDir); err != nil {
+		report.add("cni", cniDir, "", fmt.Errorf("not installed"))
+	} else {
+		for _, file := range files {
+			if file.IsDir() || !strings.EqualFold(filepath.Ext(file.Name()), ".exe") {
+				continue
+			}
+			// The CNI plugins print their version when they are run without a CNI command
+			path := filepath.Join(cniDir, file.Name())
+			printed, err = wmcb.executableVersion(ctx, path)
+			report.add("cni/"+strings.TrimSuffix(file.Name(), filepath.Ext(file.Name())), path, printed, err)
+		}
+	}
+
+	hybridOverlayPath := filepath.Join(wmcb.installDir, hybridOverlayExe)
+	printed, err = wmcb.executableVersion(ctx, hybridOverlayPath, "--version")
+	report.add("hybrid-overlay-node", hybridOverlayPath, printed, err)
+
+	csiProxyPath := filepath.Join(wmcb.installDir, csiProxyExe)
+	if service, err := wmcb.svcMgr.OpenService(csiProxyServiceName); err == nil {
+		if config, err := service.Config(); err == nil {
+			csiProxyPath = serviceExecutable(config.BinaryPathName)
+		}
+		service.Close()
+	}
+	printed, err = wmcb.executableVersion(ctx, csiProxyPath, "--version")
+	report.add("csi-proxy", csiProxyPath, printed, err)
+
+	runtime := ""
+	if wmcb.kubeletSVC != nil {
+		if config, err := wmcb.kubeletSVC.config(); err == nil {
+			runtime = dockerRuntime
+			if strings.Contains(config.BinaryPathName, "--container-runtime=remote") {
+				runtime = containerdRuntime
+			}
+		}
+	}
+	var apiServer *version.Version
+	if _, err = wmcb.fileSystem().Stat(wmcb.kubeconfigPath); err == nil {
+		apiServer, err = apiServerVersion(ctx, wmcb.kubeconfigPath)
+		printed = ""
+		if apiServer != nil {
+			printed = apiServer.String()
+		}
+		report.add("kube-apiserver", "", printed, err)
+	}
+	report.Incompatibilities = incompatibilities(kubelet, containerd, apiServer, runtime)
+	return report
+}
+
+// incompatibilities returns the incompatibilities between the given versions of the kubelet, containerd and the API
+// server, which are nil if they are unknown, and the container runtime the kubelet is configured with, which is empty
+// if it is unknown
+func incompatibilities(kubelet, containerd, apiServer *version.Version, runtime string) []string {
+	var found []string
+	if kubelet == nil {
+		return found
+	}
+	if err := checkSupportedKubelet(kubelet); err != nil {
+		found = append(found, err.Error())
+	}
+	if apiServer != nil {
+		if err := checkVersionSkew(kubelet, apiServer); err != nil {
+			found = append(found, err.Error())
+		}
+	}
+	switch runtime {
+	case dockerRuntime:
+		if kubelet.AtLeast(dockershimRemoval) {
+			found = append(found, fmt.Sprintf("kubelet %s does not support the docker runtime it is configured with",
+				kubelet))
+		}
+	case containerdRuntime:
+		if containerd == nil {
+			found = append(found, "the kubelet is configured with the containerd runtime, which is not installed")
+		} else if kubelet.AtLeast(criV1Kubelet) && containerd.LessThan(criV1Containerd) {
+			found = append(found, fmt.Sprintf("kubelet %s requires containerd %s or later, containerd %s is installed",
+				kubelet, criV1Containerd, containerd))
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+// executableVersion returns the first version printed by the given executable when run with the given arguments. The
+// executable is not required to exit successfully, as some only print their version along with their usage.
+func (wmcb *winNodeBootstrapper) executableVersion(ctx context.Context, path string,
+	args ...string) (string, error) {
+	if _, err := wmcb.fileSystem().Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("not installed")
+		}
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, versionCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Env = append(os.Environ(), "CNI_COMMAND=")
+	out, err := cmd.CombinedOutput()
+	if printed := versionRegex.FindString(string(out)); printed != "" {
+		return printed, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error running %s: %w", filepath.Base(path), err)
+	}
+	return "", fmt.Errorf("no version printed by %s", filepath.Base(path))
+}
+
+// serviceExecutable returns the path of the executable of the given Windows service command line
+func serviceExecutable(command string) string {
+	command = strings.TrimSpace(command)
+	if strings.HasPrefix(command, `"`) {
+		if end := strings.Index(command[1:], `"`); end >= 0 {
+			return command[1 : end+1]
+		}
+	}
+	if fields := strings.Fields(command); len(fields) > 0 {
+		return fields[0]
+	}
+	return command
+}
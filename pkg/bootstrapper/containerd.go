@@ -0,0 +1,187 @@
+package bootstrapper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+const (
+	// dockerRuntime is the docker container runtime, which is the default runtime used by the kubelet
+	dockerRuntime = "docker"
+	// containerdRuntime is the containerd container runtime
+	containerdRuntime = "containerd"
+	// containerdServiceName is the name of the containerd Windows service
+	containerdServiceName = "containerd"
+	// containerdDirName is the directory within the install dir where the containerd binaries and config are placed
+	containerdDirName = "containerd"
+	// containerdExe is the name of the containerd executable
+	containerdExe = "containerd.exe"
+	// containerdConfigName is the name of the containerd configuration file
+	containerdConfigName = "config.toml"
+	// containerdRootDir is the directory where containerd stores its persistent data
+	containerdRootDir = "C:\\ProgramData\\containerd\\root"
+	// containerdStateDir is the directory where containerd stores its transient data
+	containerdStateDir = "C:\\ProgramData\\containerd\\state"
+	// containerdPipeAddress is the named pipe on which containerd serves its GRPC API
+	containerdPipeAddress = "\\\\.\\pipe\\containerd-containerd"
+	// containerdEndpoint is the containerd endpoint given to the kubelet
+	containerdEndpoint = "npipe://./pipe/containerd-containerd"
+)
+
+// containerdConf defines fields of the containerd config.toml file that are defined by WMCB variables
+type containerdConf struct {
+	// RootDir is the directory where containerd stores its persistent data
+	RootDir string
+	// StateDir is the directory where containerd stores its transient data
+	StateDir string
+	// PipeAddress is the named pipe on which containerd serves its GRPC API
+	PipeAddress string
+	// SandboxImage is the image used for the pod sandbox
+	SandboxImage string
+	// CNIBinDir is the directory where the CNI binaries are placed
+	CNIBinDir string
+	// CNIConfDir is the directory where the CNI config is placed
+	CNIConfDir string
+	// RegistryConfigDir is the directory holding the hosts.toml and CA certificates of the registries
+	RegistryConfigDir string
+}
+
+// containerdInstallDir returns the directory where the containerd binaries and config are placed
+func (wmcb *winNodeBootstrapper) containerdInstallDir() string {
+	return filepath.Join(wmcb.installDir, containerdDirName)
+}
+
+// createContainerdConf creates the config file for containerd, with Windows specific configuration
+func (wmcb *winNodeBootstrapper) createContainerdConf() ([]byte, error) {
+	containerdConfData, err := wmcb.renderContainerdConf()
+	if err != nil {
+		return nil, err
+	}
+	containerdConfPath := filepath.Join(wmcb.containerdInstallDir(), containerdConfigName)
+	if err = wmcb.journal.fileWritten(containerdConfPath); err != nil {
+		return nil, err
+	}
+	if err = writeFile(wmcb.fileSystem(), containerdConfPath, containerdConfData, 0644); err != nil {
+		return nil, fmt.Errorf("error writing data to %v file: %v", containerdConfPath, err)
+	}
+	return containerdConfData, nil
+}
+
+// renderContainerdConf returns the contents of the config file for containerd
+func (wmcb *winNodeBootstrapper) renderContainerdConf() ([]byte, error) {
+	// get config file content using bindata.go
+	content, err := Asset("templates/containerd_config.toml")
+	if err != nil {
+		return nil, fmt.Errorf("error reading containerd config template: %v", err)
+	}
+
+	containerdConfTmpl, err := template.New("containerdconf").Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+	layout := wmcb.cniLayout()
+	variableFields := containerdConf{
+		RootDir:      containerdRootDir,
+		StateDir:     containerdStateDir,
+		PipeAddress:  containerdPipeAddress,
+		SandboxImage: wmcb.pauseImage(),
+		CNIBinDir:    layout.binDir,
+		CNIConfDir:   layout.confDir,
+		// The registry config dir is populated from the ignition file
+		RegistryConfigDir: wmcb.containerdRegistryConfigDir(),
+	}
+	var containerdConfData bytes.Buffer
+	if err = containerdConfTmpl.Execute(&containerdConfData, variableFields); err != nil {
+		return nil, fmt.Errorf("error generating containerd config: %v", err)
+	}
+	return containerdConfData.Bytes(), nil
+}
+
+// copyContainerdFiles copies the containerd binaries from the input containerd dir to the containerd install directory
+func (wmcb *winNodeBootstrapper) copyContainerdFiles() error {
+	files, err := wmcb.fileSystem().ReadDir(wmcb.containerdDir)
+	if err != nil {
+		return fmt.Errorf("error reading containerd dir %s: %v", wmcb.containerdDir, err)
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		src := filepath.Join(wmcb.containerdDir, file.Name())
+		dest := filepath.Join(wmcb.containerdInstallDir(), file.Name())
+		if err = wmcb.journal.fileWritten(dest); err != nil {
+			return err
+		}
+		if err = copyFile(wmcb.fileSystem(), src, dest); err != nil {
+			return fmt.Errorf("error copying %s --> %s: %v", src, dest, err)
+		}
+	}
+	return nil
+}
+
+// getContainerdArgs returns the arguments the containerd Windows service is run with
+func (wmcb *winNodeBootstrapper) getContainerdArgs() []string {
+	return []string{
+		"--config", filepath.Join(wmcb.containerdInstallDir(), containerdConfigName),
+		"--log-file", filepath.Join(filepath.Dir(wmcb.logDir), containerdServiceName, "containerd.log"),
+		// Required for containerd to report its status to the Windows service manager
+		"--run-service",
+	}
+}
+
+// ensureContainerdService installs the containerd binaries and config, creates the containerd Windows service if it is
+// not already present, else updates the existing service, and then starts it. This assumes that the kubelet service,
+// which depends on containerd, has been stopped.
+func (wmcb *winNodeBootstrapper) ensureContainerdService() error {
+	if err := wmcb.fileSystem().MkdirAll(wmcb.containerdInstallDir(), os.ModeDir); err != nil {
+		return fmt.Errorf("could not make containerd directory: %v", err)
+	}
+	containerdLogDir := filepath.Join(filepath.Dir(wmcb.logDir), containerdServiceName)
+	if err := wmcb.fileSystem().MkdirAll(containerdLogDir, os.ModeDir); err != nil {
+		return fmt.Errorf("could not make %s directory: %v", containerdLogDir, err)
+	}
+
+	containerdService, err := wmcb.svcMgr.OpenService(containerdServiceName)
+	if err != nil && !errors.Is(err, errServiceDoesNotExist) {
+		return fmt.Errorf("error getting existing containerd service: %v", err)
+	}
+	if containerdService != nil {
+		defer containerdService.Close()
+		// Stop the containerd service as there could be open file handles on the containerd binaries
+		if err := stopService(containerdService); err != nil {
+			return fmt.Errorf("unable to stop containerd service: %v", err)
+		}
+	}
+
+	if wmcb.containerdDir != "" {
+		if err := wmcb.copyContainerdFiles(); err != nil {
+			return fmt.Errorf("could not copy containerd files: %v", err)
+		}
+	}
+	if _, err := wmcb.createContainerdConf(); err != nil {
+		return fmt.Errorf("error creating containerd configuration: %v", err)
+	}
+
+	c := serviceConfig{
+		// StartAutomatic will start the service again if the node restarts
+		StartType:   startAutomatic,
+		Description: "containerd container runtime",
+	}
+	service, err := createOrUpdateService(wmcb.svcMgr, wmcb.journal, containerdService, containerdServiceName,
+		filepath.Join(wmcb.containerdInstallDir(), containerdExe), c, wmcb.getContainerdArgs())
+	if err != nil {
+		return err
+	}
+	if containerdService == nil {
+		defer service.Close()
+	}
+
+	if err := startService(service); err != nil {
+		return fmt.Errorf("failed to start containerd service: %v", err)
+	}
+	return nil
+}
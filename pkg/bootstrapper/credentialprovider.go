@@ -0,0 +1,170 @@
+package bootstrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// credentialProviderDirName is the directory in the install dir the image credential provider plugins are
+	// installed to
+	credentialProviderDirName = "credential-providers"
+	// credentialProviderConfigName is the name of the CredentialProviderConfig file in the install dir
+	credentialProviderConfigName = "credential-provider-config.json"
+)
+
+// credentialProviderDefaults holds the defaults of a supported image credential provider plugin
+type credentialProviderDefaults struct {
+	// matchImages are the patterns of the images the plugin provides credentials for
+	matchImages []string
+	// defaultCacheDuration is the duration the credentials are cached for if the plugin does not specify it
+	defaultCacheDuration string
+	// cloudConfigArg indicates if the plugin expects the cloud config file as its argument
+	cloudConfigArg bool
+}
+
+// supportedCredentialProviders maps the names of the supported image credential provider plugins to their defaults
+var supportedCredentialProviders = map[string]credentialProviderDefaults{
+	"ecr-credential-provider": {
+		matchImages: []string{"*.dkr.ecr.*.amazonaws.com", "*.dkr.ecr.*.amazonaws.com.cn",
+			"*.dkr.ecr-fips.*.amazonaws.com"},
+		defaultCacheDuration: "12h",
+	},
+	"acr-credential-provider": {
+		matchImages:          []string{"*.azurecr.io", "*.azurecr.cn", "*.azurecr.de", "*.azurecr.us"},
+		defaultCacheDuration: "10m",
+		cloudConfigArg:       true,
+	},
+	"gcr-credential-provider": {
+		matchImages:          []string{"container.cloud.google.com", "gcr.io", "*.gcr.io", "*.pkg.dev"},
+		defaultCacheDuration: "1m",
+	},
+}
+
+// credentialProviderConfig is the kubelet CredentialProviderConfig
+type credentialProviderConfig struct {
+	Kind       string               `json:"kind"`
+	APIVersion string               `json:"apiVersion"`
+	Providers  []credentialProvider `json:"providers"`
+}
+
+// credentialProvider is the configuration of an image credential provider plugin in the CredentialProviderConfig
+type credentialProvider struct {
+	Name                 string   `json:"name"`
+	MatchImages          []string `json:"matchImages"`
+	DefaultCacheDuration string   `json:"defaultCacheDuration"`
+	APIVersion           string   `json:"apiVersion"`
+	Args                 []string `json:"args,omitempty"`
+}
+
+// credentialProviderName returns the name of the image credential provider plugin at the given path
+func credentialProviderName(pluginPath string) string {
+	return strings.TrimSuffix(filepath.Base(pluginPath), ".exe")
+}
+
+// SetImageCredentialProviders sets the image credential provider plugins the kubelet uses to fetch the credentials of
+// the private cloud registries, for example ecr-credential-provider.exe. The plugins are installed and configured with
+// the registries of their cloud. This needs to be called before InitializeKubelet for the plugins to take effect.
+func (wmcb *winNodeBootstrapper) SetImageCredentialProviders(pluginPaths []string) error {
+	for _, pluginPath := range pluginPaths {
+		name := credentialProviderName(pluginPath)
+		if _, ok := supportedCredentialProviders[name]; !ok {
+			var supported []string
+			for provider := range supportedCredentialProviders {
+				supported = append(supported, provider)
+			}
+			sort.Strings(supported)
+			return fmt.Errorf("unsupported image credential provider %s, supported providers are %s", name,
+				strings.Join(supported, ", "))
+		}
+		if _, err := wmcb.fileSystem().Stat(pluginPath); err != nil {
+			return fmt.Errorf("unable to find image credential provider at %s: %v", pluginPath, err)
+		}
+	}
+	wmcb.credentialProviders = pluginPaths
+	return nil
+}
+
+// credentialProviderDir returns the directory the image credential provider plugins are installed to
+func (wmcb *winNodeBootstrapper) credentialProviderDir() string {
+	return filepath.Join(wmcb.installDir, credentialProviderDirName)
+}
+
+// credentialProviderConfigPath returns the path of the CredentialProviderConfig file
+func (wmcb *winNodeBootstrapper) credentialProviderConfigPath() string {
+	return filepath.Join(wmcb.installDir, credentialProviderConfigName)
+}
+
+// createCredentialProviderConf returns the CredentialProviderConfig of the image credential provider plugins
+func (wmcb *winNodeBootstrapper) createCredentialProviderConf() ([]byte, error) {
+	config := credentialProviderConfig{
+		Kind:       "CredentialProviderConfig",
+		APIVersion: "kubelet.config.k8s.io/v1alpha1",
+	}
+	for _, pluginPath := range wmcb.credentialProviders {
+		name := credentialProviderName(pluginPath)
+		defaults := supportedCredentialProviders[name]
+		provider := credentialProvider{
+			Name:                 name,
+			MatchImages:          defaults.matchImages,
+			DefaultCacheDuration: defaults.defaultCacheDuration,
+			APIVersion:           "credentialprovider.kubelet.k8s.io/v1alpha1",
+		}
+		if defaults.cloudConfigArg {
+			cloudConfig, ok := wmcb.kubeletArgs[cloudConfigOption]
+			if !ok {
+				return nil, fmt.Errorf("%s requires the cloud config, which is not present in the ignition file", name)
+			}
+			provider.Args = []string{cloudConfig}
+		}
+		config.Providers = append(config.Providers, provider)
+	}
+	return json.Marshal(config)
+}
+
+// installCredentialProviders copies the image credential provider plugins to the install dir and writes their
+// CredentialProviderConfig
+func (wmcb *winNodeBootstrapper) installCredentialProviders() error {
+	if len(wmcb.credentialProviders) == 0 {
+		return nil
+	}
+	if err := wmcb.mkdirAll(wmcb.credentialProviderDir()); err != nil {
+		return fmt.Errorf("could not make %s directory: %v", wmcb.credentialProviderDir(), err)
+	}
+	for _, pluginPath := range wmcb.credentialProviders {
+		contents, err := readFile(wmcb.fileSystem(), pluginPath)
+		if err != nil {
+			return fmt.Errorf("could not read image credential provider: %v", err)
+		}
+		// The kubelet looks up the plugins by their name
+		dest := filepath.Join(wmcb.credentialProviderDir(), credentialProviderName(pluginPath)+".exe")
+		if err = wmcb.writeKubeletFile(dest, contents); err != nil {
+			return fmt.Errorf("could not copy image credential provider: %v", err)
+		}
+	}
+
+	config, err := wmcb.createCredentialProviderConf()
+	if err != nil {
+		return err
+	}
+	if err = wmcb.writeKubeletFile(wmcb.credentialProviderConfigPath(), config); err != nil {
+		return fmt.Errorf("could not write image credential provider configuration: %v", err)
+	}
+	return nil
+}
+
+// credentialProviderArgs returns the kubelet args that enable the image credential provider plugins
+func (wmcb *winNodeBootstrapper) credentialProviderArgs() []string {
+	if len(wmcb.credentialProviders) == 0 {
+		return nil
+	}
+	return []string{
+		"--image-credential-provider-config=" + wmcb.credentialProviderConfigPath(),
+		"--image-credential-provider-bin-dir=" + wmcb.credentialProviderDir(),
+		// The plugins are an alpha feature
+		"--feature-gates=KubeletCredentialProviders=true",
+	}
+}
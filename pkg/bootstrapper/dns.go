@@ -0,0 +1,162 @@
+package bootstrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// tcpipParametersKey is the registry key holding the DNS suffix search list of the Windows node
+	tcpipParametersKey = `SYSTEM\CurrentControlSet\Services\Tcpip\Parameters`
+	// nrptKey is the registry key holding the local Name Resolution Policy Table rules, which are also the ones added
+	// by the VPN clients
+	nrptKey = `SYSTEM\CurrentControlSet\Services\Dnscache\Parameters\DnsPolicyConfig`
+	// nrptPolicyKey is the registry key holding the Name Resolution Policy Table rules set by group policy
+	nrptPolicyKey = `SOFTWARE\Policies\Microsoft\Windows NT\DNSClient\DnsPolicyConfig`
+	// clusterDNSRuleName is the name of the NRPT rule sending the queries of the cluster domain to the cluster DNS
+	clusterDNSRuleName = "wmcb-cluster-dns"
+	// nrptGenericDNSServers is the NRPT rule config option indicating that the rule sets the DNS servers
+	nrptGenericDNSServers = 0x8
+	// defaultClusterDomain is the cluster domain used when the kubelet configuration does not set it
+	defaultClusterDomain = "cluster.local"
+)
+
+// DNSOptions holds the DNS settings of the kubelet and the Windows node
+type DNSOptions struct {
+	// ResolvConf is the resolv.conf the kubelet passes to the pods, an empty file by default, which makes the pods use
+	// the DNS settings of their CNI config
+	ResolvConf string
+	// ManageHostDNS configures the Windows node to resolve the names of the cluster domain through the cluster DNS,
+	// with a Name Resolution Policy Table rule for the cluster domain and the cluster DNS suffixes added to its search
+	// list
+	ManageHostDNS bool
+	// SearchSuffixes are the DNS suffixes added to the search list of the Windows node. Defaults to
+	// svc.<cluster domain> and <cluster domain>. Only used with ManageHostDNS.
+	SearchSuffixes []string
+	// IgnoreConflicts configures the Windows node even if its existing rules, for example the ones of a VPN client,
+	// send the queries of the cluster domain to other DNS servers. Only used with ManageHostDNS.
+	IgnoreConflicts bool
+}
+
+// SetDNS sets the DNS settings of the kubelet and the Windows node, which are applied by Configure. The cluster domain
+// and the cluster DNS are taken from the kubelet configuration, unless the DNS server IP is set using
+// SetCNIConfigValues. This needs to be called before Configure to take effect.
+func (wmcb *winNodeBootstrapper) SetDNS(options DNSOptions) error {
+	if wmcb.cni == nil {
+		return fmt.Errorf("DNS settings can only be set along with CNI")
+	}
+	if options.ResolvConf != "" {
+		// The kubelet args are separated by spaces in the kubelet service command line
+		if strings.Contains(options.ResolvConf, " ") {
+			return fmt.Errorf("resolv.conf path %q cannot contain spaces", options.ResolvConf)
+		}
+		if _, err := wmcb.fileSystem().Stat(options.ResolvConf); err != nil {
+			return fmt.Errorf("error accessing resolv.conf: %v", err)
+		}
+	}
+	for _, suffix := range options.SearchSuffixes {
+		if errs := validation.IsDNS1123Subdomain(suffix); len(errs) > 0 {
+			return fmt.Errorf("invalid DNS search suffix %q: %s", suffix, strings.Join(errs, "; "))
+		}
+	}
+	wmcb.cni.resolvConf = options.ResolvConf
+	wmcb.dns = &options
+	return nil
+}
+
+// kubeletClusterDomain returns the cluster domain of the given kubelet configuration
+func kubeletClusterDomain(kubeletConf []byte) (string, error) {
+	var config struct {
+		ClusterDomain string `json:"clusterDomain"`
+	}
+	if err := json.Unmarshal(kubeletConf, &config); err != nil {
+		return "", err
+	}
+	if config.ClusterDomain == "" {
+		return defaultClusterDomain, nil
+	}
+	return strings.TrimSuffix(config.ClusterDomain, "."), nil
+}
+
+// dnsNamespaceOverlaps returns true if the given NRPT rule namespace applies to names of the given domain. A namespace
+// starting with a dot matches the domain and its subdomains, and a single dot matches every name.
+func dnsNamespaceOverlaps(namespace, domain string) bool {
+	namespace = strings.ToLower(namespace)
+	domain = strings.ToLower(domain)
+	if namespace == "." {
+		return true
+	}
+	name := strings.TrimPrefix(namespace, ".")
+	return name == domain || strings.HasSuffix(domain, "."+name) || strings.HasSuffix(name, "."+domain)
+}
+
+// mergeSearchList returns the given comma separated DNS suffix search list with the given suffixes appended, unless
+// already present
+func mergeSearchList(searchList string, suffixes []string) string {
+	var merged []string
+	present := make(map[string]bool)
+	for _, suffix := range append(strings.Split(searchList, ","), suffixes...) {
+		suffix = strings.TrimSpace(suffix)
+		if suffix == "" || present[strings.ToLower(suffix)] {
+			continue
+		}
+		present[strings.ToLower(suffix)] = true
+		merged = append(merged, suffix)
+	}
+	return strings.Join(merged, ",")
+}
+
+// configureHostDNS configures the Windows node to resolve the names of the cluster domain through the cluster DNS, if
+// enabled. An error is returned if the existing NRPT rules conflict with it, unless the conflicts are ignored. The
+// changes are only recorded in dry-run mode.
+func (wmcb *winNodeBootstrapper) configureHostDNS() error {
+	if wmcb.dns == nil || !wmcb.dns.ManageHostDNS {
+		return nil
+	}
+	dnsServerIP := wmcb.cni.values.dnsServerIP
+	if dnsServerIP == "" {
+		return fmt.Errorf("cluster DNS IP is not known, it needs to be given or set in the kubelet configuration")
+	}
+	domain := defaultClusterDomain
+	content, err := readFile(wmcb.fileSystem(), wmcb.kubeletConfPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading kubelet configuration: %v", err)
+	}
+	if err == nil {
+		if domain, err = kubeletClusterDomain(content); err != nil {
+			return fmt.Errorf("error parsing kubelet configuration %s: %v", wmcb.kubeletConfPath, err)
+		}
+	}
+	suffixes := wmcb.dns.SearchSuffixes
+	if len(suffixes) == 0 {
+		suffixes = []string{"svc." + domain, domain}
+	}
+
+	conflicts, err := dnsConflicts(domain, dnsServerIP)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		if !wmcb.dns.IgnoreConflicts {
+			return fmt.Errorf("the DNS settings of the Windows node conflict with the cluster DNS %s for %s: %s",
+				dnsServerIP, domain, strings.Join(conflicts, "; "))
+		}
+		wmcb.log.Info("ignoring DNS conflicts", "domain", domain, "conflicts", conflicts)
+	}
+	if wmcb.dryRun != nil {
+		wmcb.dryRun.addAction("send the DNS queries of %s to %s with the %s NRPT rule", domain, dnsServerIP,
+			clusterDNSRuleName)
+		wmcb.dryRun.addAction("add %s to the DNS suffix search list", strings.Join(suffixes, ","))
+		return nil
+	}
+
+	wmcb.log.Info("configuring host DNS", "domain", domain, "dnsServerIP", dnsServerIP, "searchSuffixes", suffixes)
+	if err = setClusterDNSRule(domain, dnsServerIP); err != nil {
+		return err
+	}
+	return setSearchList(suffixes)
+}
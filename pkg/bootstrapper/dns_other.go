@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package bootstrapper
+
+// dnsConflicts returns errUnsupportedPlatform as there are no NRPT rules
+func dnsConflicts(domain, dnsServerIP string) ([]string, error) {
+	return nil, errUnsupportedPlatform
+}
+
+// setSearchList returns errUnsupportedPlatform as there is no DNS suffix search list
+func setSearchList(suffixes []string) error {
+	return errUnsupportedPlatform
+}
+
+// setClusterDNSRule returns errUnsupportedPlatform as there are no NRPT rules
+func setClusterDNSRule(domain, dnsServerIP string) error {
+	return errUnsupportedPlatform
+}
@@ -0,0 +1,92 @@
+package bootstrapper
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// dnsConflicts returns the NRPT rules of the Windows node, other than the one of WMCB, that send the queries of the
+// given domain to DNS servers other than the given one
+func dnsConflicts(domain, dnsServerIP string) ([]string, error) {
+	var conflicts []string
+	for _, keyPath := range []string{nrptKey, nrptPolicyKey} {
+		key, err := registry.OpenKey(registry.LOCAL_MACHINE, keyPath, registry.ENUMERATE_SUB_KEYS)
+		if err != nil {
+			if err == registry.ErrNotExist {
+				continue
+			}
+			return nil, fmt.Errorf("error opening NRPT registry key %s: %v", keyPath, err)
+		}
+		rules, err := key.ReadSubKeyNames(-1)
+		key.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading NRPT rules: %v", err)
+		}
+		for _, rule := range rules {
+			if rule == clusterDNSRuleName {
+				continue
+			}
+			ruleKey, err := registry.OpenKey(registry.LOCAL_MACHINE, keyPath+`\`+rule, registry.QUERY_VALUE)
+			if err != nil {
+				return nil, fmt.Errorf("error opening NRPT rule %s: %v", rule, err)
+			}
+			namespaces, _, _ := ruleKey.GetStringsValue("Name")
+			servers, _, _ := ruleKey.GetStringValue("GenericDNSServers")
+			ruleKey.Close()
+			if servers == "" || servers == dnsServerIP {
+				continue
+			}
+			for _, namespace := range namespaces {
+				if dnsNamespaceOverlaps(namespace, domain) {
+					conflicts = append(conflicts, fmt.Sprintf("rule %s sends %s to %s", rule, namespace, servers))
+					break
+				}
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// setSearchList adds the given suffixes to the DNS suffix search list of the Windows node
+func setSearchList(suffixes []string) error {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, tcpipParametersKey, registry.QUERY_VALUE|registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("error opening TCP/IP registry key: %v", err)
+	}
+	defer key.Close()
+	searchList, _, err := key.GetStringValue("SearchList")
+	if err != nil && err != registry.ErrNotExist {
+		return fmt.Errorf("error reading DNS suffix search list: %v", err)
+	}
+	merged := mergeSearchList(searchList, suffixes)
+	if merged == searchList {
+		return nil
+	}
+	if err = key.SetStringValue("SearchList", merged); err != nil {
+		return fmt.Errorf("error setting DNS suffix search list: %v", err)
+	}
+	return nil
+}
+
+// setClusterDNSRule creates or updates the NRPT rule sending the queries of the given domain to the given DNS server
+func setClusterDNSRule(domain, dnsServerIP string) error {
+	key, _, err := registry.CreateKey(registry.LOCAL_MACHINE, nrptKey+`\`+clusterDNSRuleName, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("error creating NRPT rule: %v", err)
+	}
+	defer key.Close()
+	if err = key.SetStringsValue("Name", []string{"." + domain}); err != nil {
+		return fmt.Errorf("error setting NRPT rule namespace: %v", err)
+	}
+	if err = key.SetStringValue("GenericDNSServers", dnsServerIP); err != nil {
+		return fmt.Errorf("error setting NRPT rule DNS server: %v", err)
+	}
+	if err = key.SetDWordValue("ConfigOptions", nrptGenericDNSServers); err != nil {
+		return fmt.Errorf("error setting NRPT rule options: %v", err)
+	}
+	if err = key.SetDWordValue("Version", 2); err != nil {
+		return fmt.Errorf("error setting NRPT rule version: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DryRunPlan describes the changes InitializeKubelet or Configure would make to the Windows node, which are recorded
+// instead of being made in dry-run mode
+type DryRunPlan struct {
+	// Files are the files that would be written
+	Files []PlannedFile `json:"files"`
+	// Services are the Windows services that would be created or updated
+	Services []PlannedService `json:"services"`
+	// Actions are the other changes that would be made, like configuring the proxy or removing stale files
+	Actions []string `json:"actions,omitempty"`
+	// KubeletUnit is the unit the kubelet service would be created or updated with
+	KubeletUnit *KubeletUnit `json:"kubeletUnit,omitempty"`
+	// fs is the file system the planned files are compared with and copied from
+	fs FileSystem
+}
+
+// PlannedFile is a file that would be written in dry-run mode
+type PlannedFile struct {
+	// Path is the path of the file
+	Path string `json:"path"`
+	// Source is the file its contents would be taken from, or empty if they are generated
+	Source string `json:"source,omitempty"`
+	// Size is the size of the file in bytes
+	Size int `json:"size"`
+	// Unchanged indicates that the file already has the contents, in which case it would not be rewritten
+	Unchanged bool `json:"unchanged,omitempty"`
+}
+
+// PlannedService is a Windows service that would be created or updated in dry-run mode
+type PlannedService struct {
+	// Name is the name of the service
+	Name string `json:"name"`
+	// Command is the command line the service would be registered with
+	Command string `json:"command"`
+	// Dependencies are the services the service would depend on
+	Dependencies []string `json:"dependencies,omitempty"`
+	// Exists indicates that the service already exists and would be updated rather than created
+	Exists bool `json:"exists"`
+}
+
+// SetDryRun enables the dry-run mode, in which InitializeKubelet and Configure parse and validate their inputs and
+// record the changes they would make to the Windows node in the plan returned by DryRunPlan, without making them. The
+// ignition is still fetched from the machine config server if needed, as the files depend on it. The files of the node
+// are read as usual, while the files written or removed are kept in memory. This needs to be called before
+// InitializeKubelet or Configure.
+func (wmcb *winNodeBootstrapper) SetDryRun() {
+	wmcb.fs = newOverlayFS(wmcb.fileSystem())
+	if wmcb.cni != nil {
+		wmcb.cni.fs = wmcb.fs
+	}
+	wmcb.dryRun = &DryRunPlan{fs: wmcb.fs}
+}
+
+// DryRunPlan returns the changes recorded in dry-run mode, or nil if the dry-run mode is not enabled
+func (wmcb *winNodeBootstrapper) DryRunPlan() *DryRunPlan {
+	return wmcb.dryRun
+}
+
+// addFile records that the given contents would be written to the given path, copied from the given source if it is
+// not empty
+func (p *DryRunPlan) addFile(path, source string, contents []byte) {
+	p.Files = append(p.Files, PlannedFile{Path: path, Source: source, Size: len(contents),
+		Unchanged: fileContentsEqual(orOSFileSystem(p.fs), path, contents)})
+}
+
+// addCopy records that the given source file would be copied to the given destination
+func (p *DryRunPlan) addCopy(src, dest string) error {
+	contents, err := readFile(orOSFileSystem(p.fs), src)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", src, err)
+	}
+	p.addFile(dest, src, contents)
+	return nil
+}
+
+// addCopies records that the files of the given source dir would be copied to the given destination dir
+func (p *DryRunPlan) addCopies(srcDir, destDir string) error {
+	files, err := orOSFileSystem(p.fs).ReadDir(srcDir)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", srcDir, err)
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if err = p.addCopy(filepath.Join(srcDir, file.Name()), filepath.Join(destDir, file.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addService records that the Windows service with the given name would be created or updated to run the given
+// executable with the given args and dependencies
+func (p *DryRunPlan) addService(name, exePath string, args []string, dependencies []string, exists bool) {
+	p.Services = append(p.Services, PlannedService{Name: name,
+		Command: strings.TrimSpace(exePath + " " + strings.Join(args, " ")), Dependencies: dependencies, Exists: exists})
+}
+
+// addAction records a change that is neither a file nor a service
+func (p *DryRunPlan) addAction(format string, args ...interface{}) {
+	p.Actions = append(p.Actions, fmt.Sprintf(format, args...))
+}
+
+// mkdirAll creates the given directory along with its parents, in memory in dry-run mode
+func (wmcb *winNodeBootstrapper) mkdirAll(dir string) error {
+	return wmcb.fileSystem().MkdirAll(dir, os.ModeDir)
+}
+
+// serviceExists returns true if the Windows service with the given name exists
+func (wmcb *winNodeBootstrapper) serviceExists(name string) bool {
+	service, err := wmcb.svcMgr.OpenService(name)
+	if err != nil {
+		return false
+	}
+	service.Close()
+	return true
+}
+
+// planKubeletServices records the services InitializeKubelet would create or update in dry-run mode, along with the
+// containerd files and the proxy and pause image changes
+func (wmcb *winNodeBootstrapper) planKubeletServices() error {
+	if wmcb.containerRuntime == containerdRuntime {
+		if wmcb.containerdDir != "" {
+			if err := wmcb.dryRun.addCopies(wmcb.containerdDir, wmcb.containerdInstallDir()); err != nil {
+				return fmt.Errorf("could not plan containerd files: %v", err)
+			}
+		}
+		containerdConf, err := wmcb.renderContainerdConf()
+		if err != nil {
+			return fmt.Errorf("error creating containerd configuration: %v", err)
+		}
+		wmcb.dryRun.addFile(filepath.Join(wmcb.containerdInstallDir(), containerdConfigName), "", containerdConf)
+		wmcb.dryRun.addService(containerdServiceName, filepath.Join(wmcb.containerdInstallDir(), containerdExe),
+			wmcb.getContainerdArgs(), nil, wmcb.serviceExists(containerdServiceName))
+	}
+
+	wmcb.dryRun.addService(KubeletServiceName, wmcb.kubeletExePath(), wmcb.getInitialKubeletArgs(),
+		[]string{wmcb.runtimeServiceName()}, wmcb.kubeletSVC != nil)
+	if err := wmcb.writeKubeletUnit(wmcb.renderKubeletUnit()); err != nil {
+		return err
+	}
+	if err := wmcb.configureServiceAccount(nil, KubeletServiceName, wmcb.kubeletAccountRights()); err != nil {
+		return err
+	}
+	if wmcb.cloudNodeManager != nil {
+		cloudNodeManagerPath := filepath.Join(wmcb.installDir, cloudNodeManagerExe)
+		if err := wmcb.dryRun.addCopy(wmcb.cloudNodeManager.path, cloudNodeManagerPath); err != nil {
+			return fmt.Errorf("could not plan cloud-node-manager service: %v", err)
+		}
+		wmcb.dryRun.addService(cloudNodeManagerServiceName, cloudNodeManagerPath, wmcb.getCloudNodeManagerArgs(),
+			[]string{KubeletServiceName}, wmcb.serviceExists(cloudNodeManagerServiceName))
+	}
+
+	if !wmcb.proxy.isEmpty() {
+		wmcb.dryRun.addAction("run netsh %s", strings.Join(wmcb.proxy.winHTTPProxyArgs(), " "))
+		wmcb.dryRun.addAction("set the environment of the %s and %s services to %s", KubeletServiceName,
+			wmcb.runtimeServiceName(), strings.Join(wmcb.proxy.environment(), " "))
+	}
+	if wmcb.pauseImageArchive != "" {
+		wmcb.dryRun.addAction("load the pause image from %s", wmcb.pauseImageArchive)
+	}
+	return nil
+}
+
+// planConfigure records the files and services Configure would write, create or update in dry-run mode
+func (wmcb *winNodeBootstrapper) planConfigure() error {
+	config, err := wmcb.kubeletSVC.config()
+	if err != nil {
+		return fmt.Errorf("error getting kubelet service config: %v", err)
+	}
+	if err = wmcb.setCNIConfigDefaults(); err != nil {
+		return fmt.Errorf("error getting CNI config values: %v", err)
+	}
+	if wmcb.hnsNetwork != nil {
+		if wmcb.hybridOverlay != nil {
+			return fmt.Errorf("HNS network cannot be set along with the hybrid overlay")
+		}
+		wmcb.dryRun.addAction("ensure the %s HNS network %s with subnet %s and gateway %s", wmcb.hnsNetwork.Type,
+			wmcb.hnsNetwork.Name, wmcb.hnsNetwork.AddressPrefix, wmcb.hnsNetwork.Gateway)
+	}
+	if err = wmcb.configureHostDNS(); err != nil {
+		return fmt.Errorf("error configuring DNS: %v", err)
+	}
+	wmcb.setCNILayout(config.BinaryPathName)
+	if err = wmcb.cni.plan(wmcb.dryRun); err != nil {
+		return fmt.Errorf("error configuring kubelet service for CNI: %v", err)
+	}
+	if err = wmcb.cni.updateKubeletArgs(&config.BinaryPathName); err != nil {
+		return fmt.Errorf("error configuring kubelet service for CNI: %v", err)
+	}
+	wmcb.dryRun.Services = append(wmcb.dryRun.Services, PlannedService{Name: KubeletServiceName,
+		Command: config.BinaryPathName, Dependencies: config.Dependencies, Exists: true})
+	unit, err := wmcb.installedKubeletUnit(config)
+	if err != nil {
+		return err
+	}
+	if err = wmcb.writeKubeletUnit(unit); err != nil {
+		return err
+	}
+
+	if wmcb.hybridOverlay != nil {
+		hybridOverlayPath := filepath.Join(wmcb.installDir, hybridOverlayExe)
+		if err = wmcb.dryRun.addCopy(wmcb.hybridOverlay.path, hybridOverlayPath); err != nil {
+			return fmt.Errorf("error configuring hybrid-overlay-node service: %v", err)
+		}
+		wmcb.dryRun.addService(kubeletDependentSvc, hybridOverlayPath, wmcb.getHybridOverlayArgs(),
+			[]string{KubeletServiceName}, wmcb.serviceExists(kubeletDependentSvc))
+	}
+	return nil
+}
+
+// plan records the CNI binaries and configs copyFiles would write in dry-run mode, and the stale configs it would
+// remove
+func (cni *cniOptions) plan(plan *DryRunPlan) error {
+	if err := plan.addCopies(cni.dir, cni.layout.binDir); err != nil {
+		return fmt.Errorf("unable to copy CNI files: %v", err)
+	}
+	data, err := cni.templateData()
+	if err != nil {
+		return fmt.Errorf("error getting CNI config values: %v", err)
+	}
+	configs, err := loadCNIConfigs(cni.fileSystem(), cni.config, data)
+	if err != nil {
+		return newError(ErrCNIInvalid, err)
+	}
+	if _, err = cni.fileSystem().Stat(cni.layout.confDir); err == nil {
+		stale, err := staleCNIConfigs(cni.fileSystem(), cni.layout.confDir, configs)
+		if err != nil {
+			return err
+		}
+		for _, path := range stale {
+			plan.addAction("remove the stale CNI config %s", path)
+		}
+	}
+	for i, config := range configs {
+		contents := config.contents
+		// The kubelet only uses the primary config, which is the first one
+		if i == 0 && cni.networkName != "" {
+			if contents, err = cniConfigWithNetwork(contents, cni.networkName); err != nil {
+				return fmt.Errorf("error writing CNI config %s: %v", config.path, err)
+			}
+		}
+		plan.addFile(filepath.Join(cni.layout.confDir, filepath.Base(config.path)), config.path, contents)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package bootstrapper
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+var (
+	// ErrIgnitionParse is matched by the errors returned when the ignition file, or the worker ignition of the machine
+	// config server, cannot be parsed or is missing the kubelet files
+	ErrIgnitionParse = errors.New("ignition parse error")
+	// ErrServiceCreate is matched by the errors returned when a Windows service of the node components cannot be
+	// created or updated
+	ErrServiceCreate = errors.New("service creation error")
+	// ErrCNIInvalid is matched by the errors returned when the CNI plugin directory or the CNI configs are invalid
+	ErrCNIInvalid = errors.New("invalid CNI configuration")
+	// ErrPermissions is matched by the errors returned when a file, directory, registry key or service cannot be
+	// accessed, which is usually because the bootstrapper is not run as an Administrator. It is os.ErrPermission, which
+	// the access denied errors of Windows match.
+	ErrPermissions = os.ErrPermission
+	// ErrTransient is matched by the errors returned when a remote endpoint cannot be reached, fails with a server
+	// error or does not complete in time. The operation can be retried once the endpoint is available.
+	ErrTransient = errors.New("transient error")
+	// ErrVersionSkew is matched by the errors returned when the kubelet version is not supported by WMCB or is not
+	// compatible with the version of the API server
+	ErrVersionSkew = errors.New("unsupported kubelet version")
+	// ErrRebootRequired is matched by the errors returned when the node needs to be rebooted for the changes made to
+	// it to take effect, before it can be bootstrapped
+	ErrRebootRequired = errors.New("reboot required")
+	// errUnsupportedPlatform is returned by the operations calling the APIs of the Windows host when WMCB is built for
+	// another platform, which is only done to unit test the platform independent logic
+	errUnsupportedPlatform = errors.New("unsupported platform, the Windows APIs are not available")
+)
+
+// bootstrapError classifies the error it wraps as one of the bootstrap error kinds, so that callers can match it using
+// errors.Is without depending on its message
+type bootstrapError struct {
+	// kind is one of the Err sentinel errors
+	kind error
+	// err is the wrapped error, whose message is kept
+	err error
+}
+
+// newError returns the given error classified as the given kind, or nil if the error is nil
+func newError(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &bootstrapError{kind: kind, err: err}
+}
+
+// Error returns the message of the wrapped error
+func (e *bootstrapError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (e *bootstrapError) Unwrap() error {
+	return e.err
+}
+
+// Is returns true if the target is the kind of the error
+func (e *bootstrapError) Is(target error) bool {
+	return target == e.kind
+}
+
+// isTransientStatus returns true if the given HTTP status code is returned by a server that is temporarily unavailable
+func isTransientStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// transientError returns the given error classified as an ErrTransient if it was caused by a timeout, a network error
+// or an API server that is temporarily unavailable, or the given error otherwise
+func transientError(err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, wait.ErrWaitTimeout) || errors.As(err, &netErr) ||
+		apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) || apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err) || apierrors.IsInternalError(err) {
+		return newError(ErrTransient, err)
+	}
+	return err
+}
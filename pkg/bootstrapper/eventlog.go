@@ -0,0 +1,64 @@
+package bootstrapper
+
+import "fmt"
+
+const (
+	// EventLogSource is the source the wmcb commands write the bootstrap events to the Application event log with
+	EventLogSource = "wmcb"
+	// eventIDPhaseSucceeded is the ID of the events recording that a bootstrap phase succeeded
+	eventIDPhaseSucceeded uint32 = 1
+	// eventIDPhaseFailed is the ID of the events recording that a bootstrap phase failed
+	eventIDPhaseFailed uint32 = 2
+	// eventIDServiceRestarted is the ID of the events recording that a Windows service has been restarted
+	eventIDServiceRestarted uint32 = 3
+)
+
+// eventWriter writes events to the Application event log
+type eventWriter interface {
+	// Info writes an information event with the given ID and message
+	Info(id uint32, message string) error
+	// Error writes an error event with the given ID and message
+	Error(id uint32, message string) error
+	// Close closes the event log
+	Close() error
+}
+
+// eventLog is the Application event log the bootstrap events are written to. No events are written if it is nil.
+var eventLog eventWriter
+
+// SetEventLog configures the bootstrapper to write the outcome of the bootstrap phases and the restarts of the Windows
+// services, like the kubelet, to the Application event log with the given source, so that they are picked up by the
+// Windows monitoring agents along with the other events of the node. The source is registered if it is not already,
+// which requires Administrator privileges. This needs to be called before the bootstrap phases are run to take effect.
+func SetEventLog(source string) error {
+	if source == "" {
+		return fmt.Errorf("event log source cannot be empty")
+	}
+	log, err := openEventLog(source)
+	if err != nil {
+		return err
+	}
+	if eventLog != nil {
+		eventLog.Close()
+	}
+	eventLog = log
+	return nil
+}
+
+// writeEvent writes an event with the given ID and message to the event log set using SetEventLog, as an error if
+// failed is true, or as information otherwise. Failing to write the event is logged and does not fail the bootstrap.
+// No event is written in dry-run mode.
+func (wmcb *winNodeBootstrapper) writeEvent(id uint32, failed bool, message string) {
+	if eventLog == nil || wmcb.dryRun != nil {
+		return
+	}
+	var err error
+	if failed {
+		err = eventLog.Error(id, message)
+	} else {
+		err = eventLog.Info(id, message)
+	}
+	if err != nil {
+		wmcb.log.Error(err, "unable to write event", "eventID", id)
+	}
+}
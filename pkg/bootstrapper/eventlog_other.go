@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package bootstrapper
+
+// openEventLog returns errUnsupportedPlatform as there is no Application event log
+func openEventLog(source string) (eventWriter, error) {
+	return nil, errUnsupportedPlatform
+}
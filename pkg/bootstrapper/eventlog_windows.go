@@ -0,0 +1,22 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// openEventLog opens the Application event log with the given source, registering the source if it is not already
+func openEventLog(source string) (eventWriter, error) {
+	// The source uses the message file of eventcreate.exe, whose messages are the strings the events are written with
+	err := eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil && !strings.Contains(err.Error(), "registry key already exists") {
+		return nil, fmt.Errorf("error registering event source %s: %w", source, err)
+	}
+	log, err := eventlog.Open(source)
+	if err != nil {
+		return nil, fmt.Errorf("error opening event log with source %s: %w", source, err)
+	}
+	return log, nil
+}
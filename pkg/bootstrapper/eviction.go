@@ -0,0 +1,176 @@
+package bootstrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// defaultImageGCHighThresholdPercent is the disk usage of the install volume above which the kubelet garbage
+	// collects the unused images. It is lower than the default of 85 on Linux so that the large Windows images are
+	// collected well before the pods are evicted for lack of disk space.
+	defaultImageGCHighThresholdPercent = 75
+	// defaultImageGCLowThresholdPercent is the disk usage of the install volume the kubelet garbage collects the
+	// unused images down to
+	defaultImageGCLowThresholdPercent = 65
+	// nodeFSAvailableSignal is the eviction signal of the space available on the volume of the kubelet root directory
+	nodeFSAvailableSignal = "nodefs.available"
+)
+
+// defaultEvictionHard are the hard eviction thresholds of the kubelet on Windows. The kubelet defaults are meant for
+// Linux: they evict pods with 100Mi of memory available, which is not enough for Windows itself, and check inodes,
+// which do not exist on Windows. Setting them replaces all the kubelet defaults.
+var defaultEvictionHard = map[string]string{
+	"memory.available":    "500Mi",
+	nodeFSAvailableSignal: "10%",
+	"imagefs.available":   "10%",
+}
+
+// windowsEvictionSignals are the eviction signals the kubelet supports on Windows
+var windowsEvictionSignals = map[string]bool{
+	"memory.available":            true,
+	"allocatableMemory.available": true,
+	nodeFSAvailableSignal:         true,
+	"imagefs.available":           true,
+}
+
+// EvictionOptions are the thresholds at which the kubelet evicts the pods and garbage collects the unused images. The
+// zero value of a field selects the Windows default.
+type EvictionOptions struct {
+	// EvictionHard are the hard eviction thresholds by eviction signal, either a quantity like 500Mi or a percentage
+	// of the capacity like 10%. They are merged with the Windows defaults, which are memory.available=500Mi,
+	// nodefs.available=10% and imagefs.available=10%.
+	EvictionHard map[string]string
+	// ImageGCHighThresholdPercent is the disk usage above which the unused images are garbage collected. It defaults
+	// to 75.
+	ImageGCHighThresholdPercent int
+	// ImageGCLowThresholdPercent is the disk usage the unused images are garbage collected down to. It defaults to
+	// 65.
+	ImageGCLowThresholdPercent int
+}
+
+// withDefaults returns the options with the Windows defaults set for the fields that are not set
+func (o EvictionOptions) withDefaults() EvictionOptions {
+	evictionHard := make(map[string]string)
+	for signal, threshold := range defaultEvictionHard {
+		evictionHard[signal] = threshold
+	}
+	for signal, threshold := range o.EvictionHard {
+		evictionHard[signal] = threshold
+	}
+	o.EvictionHard = evictionHard
+	if o.ImageGCHighThresholdPercent == 0 {
+		o.ImageGCHighThresholdPercent = defaultImageGCHighThresholdPercent
+	}
+	if o.ImageGCLowThresholdPercent == 0 {
+		o.ImageGCLowThresholdPercent = defaultImageGCLowThresholdPercent
+	}
+	return o
+}
+
+// validate returns an error if the options, with the defaults set, are invalid
+func (o EvictionOptions) validate() error {
+	signals := make([]string, 0, len(o.EvictionHard))
+	for signal := range o.EvictionHard {
+		signals = append(signals, signal)
+	}
+	sort.Strings(signals)
+	for _, signal := range signals {
+		if !windowsEvictionSignals[signal] {
+			return fmt.Errorf("eviction signal %s is not supported on Windows", signal)
+		}
+		if _, _, err := parseEvictionThreshold(o.EvictionHard[signal]); err != nil {
+			return fmt.Errorf("invalid %s eviction threshold: %v", signal, err)
+		}
+	}
+	if o.ImageGCHighThresholdPercent < 0 || o.ImageGCHighThresholdPercent > 100 {
+		return fmt.Errorf("image GC high threshold needs to be between 0 and 100")
+	}
+	if o.ImageGCLowThresholdPercent < 0 || o.ImageGCLowThresholdPercent >= o.ImageGCHighThresholdPercent {
+		return fmt.Errorf("image GC low threshold needs to be between 0 and the high threshold %d",
+			o.ImageGCHighThresholdPercent)
+	}
+	return nil
+}
+
+// parseEvictionThreshold parses the given eviction threshold, which is either a percentage, returned along with
+// isPercentage set, or a quantity, returned in bytes
+func parseEvictionThreshold(threshold string) (value float64, isPercentage bool, err error) {
+	if strings.HasSuffix(threshold, "%") {
+		percentage, err := strconv.ParseFloat(strings.TrimSuffix(threshold, "%"), 64)
+		if err != nil || percentage <= 0 || percentage >= 100 {
+			return 0, false, fmt.Errorf("percentage %s needs to be between 0%% and 100%%", threshold)
+		}
+		return percentage, true, nil
+	}
+	quantity, err := resource.ParseQuantity(threshold)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s is neither a quantity nor a percentage: %v", threshold, err)
+	}
+	if quantity.Sign() <= 0 {
+		return 0, false, fmt.Errorf("quantity %s needs to be greater than zero", threshold)
+	}
+	return float64(quantity.Value()), false, nil
+}
+
+// SetEviction sets the thresholds at which the kubelet evicts the pods and garbage collects the unused images, which
+// are merged with the Windows defaults. The fields set with SetKubeletConfigOverrides take precedence. This needs to be
+// called before InitializeKubelet for the thresholds to take effect.
+func (wmcb *winNodeBootstrapper) SetEviction(options EvictionOptions) error {
+	options = options.withDefaults()
+	if err := options.validate(); err != nil {
+		return err
+	}
+	wmcb.eviction = &options
+	return nil
+}
+
+// evictionOptions returns the eviction options of the kubelet, the Windows defaults if none have been set
+func (wmcb *winNodeBootstrapper) evictionOptions() EvictionOptions {
+	if wmcb.eviction == nil {
+		return EvictionOptions{}.withDefaults()
+	}
+	return *wmcb.eviction
+}
+
+// evictionHardJSON returns the hard eviction thresholds of the kubelet as a JSON object
+func (wmcb *winNodeBootstrapper) evictionHardJSON() (string, error) {
+	content, err := json.Marshal(wmcb.evictionOptions().EvictionHard)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// checkEvictionThresholds returns an error if the kubelet, with the given eviction options, would evict the pods right
+// away given the free and total space of the install volume, or would evict them before it garbage collects the
+// unused images
+func checkEvictionThresholds(options EvictionOptions, free, total uint64) error {
+	threshold, ok := options.EvictionHard[nodeFSAvailableSignal]
+	if !ok || total == 0 {
+		return nil
+	}
+	value, isPercentage, err := parseEvictionThreshold(threshold)
+	if err != nil {
+		return err
+	}
+	minFree := value
+	if isPercentage {
+		minFree = value / 100 * float64(total)
+	}
+	if float64(free) <= minFree {
+		return fmt.Errorf("%d MiB free is below the %s eviction threshold of %s, the kubelet would evict the pods "+
+			"right away", free>>20, nodeFSAvailableSignal, threshold)
+	}
+	if gcFree := float64(100-options.ImageGCHighThresholdPercent) / 100 * float64(total); gcFree <= minFree {
+		return fmt.Errorf("the image GC high threshold of %d%% leaves %d MiB free, below the %s eviction threshold "+
+			"of %s, the kubelet would evict the pods before it garbage collects the unused images",
+			options.ImageGCHighThresholdPercent, uint64(gcFree)>>20, nodeFSAvailableSignal, threshold)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package bootstrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEviction tests that the eviction thresholds are merged with the Windows defaults, validated, written to the
+// kubelet configuration and checked against the size of the install volume
+func TestEviction(t *testing.T) {
+	wmcb := winNodeBootstrapper{}
+	assert.Equal(t, defaultEvictionHard, wmcb.evictionOptions().EvictionHard)
+
+	t.Run("Invalid options", func(t *testing.T) {
+		for _, options := range []EvictionOptions{
+			{EvictionHard: map[string]string{"pid.available": "10%"}},
+			{EvictionHard: map[string]string{"nodefs.inodesFree": "5%"}},
+			{EvictionHard: map[string]string{"nodefs.available": "150%"}},
+			{EvictionHard: map[string]string{"memory.available": "lots"}},
+			{EvictionHard: map[string]string{"memory.available": "0"}},
+			{ImageGCHighThresholdPercent: 101},
+			{ImageGCHighThresholdPercent: 60},
+			{ImageGCHighThresholdPercent: 80, ImageGCLowThresholdPercent: 80},
+		} {
+			assert.Error(t, wmcb.SetEviction(options), "expected %v to be invalid", options)
+		}
+		assert.Nil(t, wmcb.eviction)
+	})
+
+	t.Run("Overrides merged with the defaults", func(t *testing.T) {
+		require.NoError(t, wmcb.SetEviction(EvictionOptions{
+			EvictionHard:                map[string]string{"nodefs.available": "5Gi", "memory.available": "1Gi"},
+			ImageGCHighThresholdPercent: 80,
+		}))
+		options := wmcb.evictionOptions()
+		assert.Equal(t, map[string]string{"memory.available": "1Gi", "nodefs.available": "5Gi",
+			"imagefs.available": "10%"}, options.EvictionHard)
+		assert.Equal(t, 80, options.ImageGCHighThresholdPercent)
+		assert.Equal(t, defaultImageGCLowThresholdPercent, options.ImageGCLowThresholdPercent)
+
+		evictionHard, err := wmcb.evictionHardJSON()
+		require.NoError(t, err)
+		assert.Equal(t, `{"imagefs.available":"10%","memory.available":"1Gi","nodefs.available":"5Gi"}`,
+			evictionHard)
+	})
+
+	t.Run("Thresholds checked against the volume", func(t *testing.T) {
+		const gib = uint64(1) << 30
+		defaults := EvictionOptions{}.withDefaults()
+		assert.NoError(t, checkEvictionThresholds(defaults, 40*gib, 100*gib))
+		assert.Error(t, checkEvictionThresholds(defaults, 8*gib, 100*gib),
+			"expected the pods to be evicted right away")
+		absolute := EvictionOptions{EvictionHard: map[string]string{"nodefs.available": "20Gi"}}.withDefaults()
+		assert.NoError(t, checkEvictionThresholds(absolute, 40*gib, 200*gib))
+		assert.Error(t, checkEvictionThresholds(absolute, 15*gib, 200*gib),
+			"expected the pods to be evicted right away")
+		assert.Error(t, checkEvictionThresholds(absolute, 30*gib, 60*gib),
+			"expected the pods to be evicted before the images are garbage collected")
+	})
+}
@@ -0,0 +1,353 @@
+package bootstrapper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	logger "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// partialDownloadSuffix is the suffix of the files being downloaded, which are resumed on the next fetch if the
+	// download is interrupted
+	partialDownloadSuffix = ".partial"
+	// fetchResponseTimeout is the time to wait for the response headers of a download
+	fetchResponseTimeout = time.Minute
+)
+
+// Fetcher downloads the artifacts required to bootstrap a Windows node over HTTPS, so that they do not need to be
+// staged on the node beforehand. Every download is verified against its SHA256 checksum.
+type Fetcher struct {
+	// dir is the directory the artifacts are downloaded to
+	dir string
+	// mirrors are the base URLs tried, in order, before the original URL of an artifact
+	mirrors []*url.URL
+	// client is the HTTP client the artifacts are downloaded with
+	client *http.Client
+	// fs is the file system the artifacts are downloaded to
+	fs FileSystem
+	// log is the logger the downloads are logged with
+	log logr.Logger
+}
+
+// NewFetcher returns a fetcher that downloads the artifacts to the given directory. If proxy is empty, the proxy is
+// taken from the HTTPS_PROXY and NO_PROXY environment variables. The path of an artifact URL is appended to each of the
+// mirrors, which are tried in order before the original URL, for example to download from a registry mirror in a
+// disconnected cluster. The FS option of the given options sets the file system the artifacts are downloaded to, which
+// defaults to the file system of the host, and the Logger option the logger the downloads are logged with, which
+// defaults to the logger of the bootstrapper.
+func NewFetcher(dir, proxy string, mirrors []string, opts ...Option) (*Fetcher, error) {
+	var options Options
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.Logger == nil {
+		options.Logger = logger.Log.WithName("bootstrapper")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = fetchResponseTimeout
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %s: %v", proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	fetcher := &Fetcher{
+		dir:    dir,
+		client: &http.Client{Transport: transport},
+		fs:     orOSFileSystem(options.FS),
+		log:    options.Logger.WithName("fetcher"),
+	}
+	for _, mirror := range mirrors {
+		mirrorURL, err := parseHTTPSURL(mirror)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mirror: %v", err)
+		}
+		fetcher.mirrors = append(fetcher.mirrors, mirrorURL)
+	}
+	if err := fetcher.fs.MkdirAll(dir, os.ModeDir); err != nil {
+		return nil, fmt.Errorf("could not make %s directory: %v", dir, err)
+	}
+	return fetcher, nil
+}
+
+// parseHTTPSURL parses the given URL and returns an error if it does not use HTTPS
+func parseHTTPSURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("%s is not an HTTPS URL", rawURL)
+	}
+	return u, nil
+}
+
+// sources returns the URLs the given artifact is downloaded from, with the mirrors first
+func (f *Fetcher) sources(artifactURL *url.URL) []string {
+	var sources []string
+	for _, mirror := range f.mirrors {
+		source := *mirror
+		source.Path = path.Join(mirror.Path, artifactURL.Path)
+		source.RawQuery = artifactURL.RawQuery
+		sources = append(sources, source.String())
+	}
+	return append(sources, artifactURL.String())
+}
+
+// Fetch downloads the artifact at the given HTTPS URL and returns its local path. The artifact is not downloaded again
+// if it is already present with the given SHA256 checksum, and an interrupted download is resumed if the server
+// supports range requests. The download is aborted once the context is done.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL, checksum string) (string, error) {
+	artifactURL, err := parseHTTPSURL(rawURL)
+	if err != nil {
+		return "", err
+	}
+	checksum = strings.ToLower(checksum)
+	if decoded, err := hex.DecodeString(checksum); err != nil || len(decoded) != 32 {
+		return "", fmt.Errorf("invalid SHA256 checksum %q for %s", checksum, rawURL)
+	}
+	name := path.Base(artifactURL.Path)
+	if name == "." || name == "/" {
+		return "", fmt.Errorf("%s does not point to a file", rawURL)
+	}
+
+	dest := filepath.Join(f.dir, name)
+	if actual, err := sha256File(f.fs, dest); err == nil && actual == checksum {
+		f.log.Info("artifact already downloaded", "path", dest)
+		return dest, nil
+	}
+
+	partial := dest + partialDownloadSuffix
+	var errs []string
+	// transient indicates that a source could not be downloaded from because of a transient error, so the download
+	// can be retried
+	transient := false
+	for _, source := range f.sources(artifactURL) {
+		f.log.Info("downloading artifact", "url", source, "path", dest)
+		if err = f.download(ctx, source, partial); err != nil {
+			if ctx.Err() != nil {
+				return "", fmt.Errorf("download of %s interrupted: %v", rawURL, ctx.Err())
+			}
+			errs = append(errs, err.Error())
+			transient = transient || errors.Is(err, ErrTransient)
+			continue
+		}
+		actual, err := sha256File(f.fs, partial)
+		if err != nil {
+			return "", fmt.Errorf("error verifying %s: %v", partial, err)
+		}
+		if actual != checksum {
+			// The partial download cannot be resumed from another source
+			f.fs.Remove(partial)
+			errs = append(errs, fmt.Sprintf("checksum mismatch for %s: expected %s, got %s", source, checksum, actual))
+			continue
+		}
+		if err = f.fs.Rename(partial, dest); err != nil {
+			return "", fmt.Errorf("error moving %s to %s: %v", partial, dest, err)
+		}
+		return dest, nil
+	}
+	err = fmt.Errorf("unable to download %s: %s", rawURL, strings.Join(errs, "; "))
+	if transient {
+		return "", newError(ErrTransient, err)
+	}
+	return "", err
+}
+
+// download downloads the given URL to the given file, resuming the download from the current size of the file
+func (f *Fetcher) download(ctx context.Context, source, dest string) error {
+	var offset int64
+	if info, err := f.fs.Stat(dest); err == nil {
+		offset = info.Size()
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error accessing %s: %v", dest, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return newError(ErrTransient, err)
+	}
+	defer resp.Body.Close()
+
+	// The download is appended to the partial file when it is resumed
+	flag := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		f.log.V(1).Info("resuming download", "url", source, "offset", offset)
+	case http.StatusOK:
+		// The server does not support range requests, so the download starts over
+		flag = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The previous download completed before it could be moved into place
+		return nil
+	default:
+		err = fmt.Errorf("error downloading %s: %s", source, resp.Status)
+		if isTransientStatus(resp.StatusCode) {
+			return newError(ErrTransient, err)
+		}
+		return err
+	}
+	file, err := f.fs.OpenFile(dest, flag, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", dest, err)
+	}
+	defer file.Close()
+	if _, err = io.Copy(file, resp.Body); err != nil {
+		return newError(ErrTransient, fmt.Errorf("error downloading %s: %v", source, err))
+	}
+	return nil
+}
+
+// FetchArchive downloads the .zip, .tar.gz or .tgz archive at the given HTTPS URL, as Fetch does, and returns the
+// directory it is extracted to
+func (f *Fetcher) FetchArchive(ctx context.Context, rawURL, checksum string) (string, error) {
+	archive, err := f.Fetch(ctx, rawURL, checksum)
+	if err != nil {
+		return "", err
+	}
+	var extract func(FileSystem, string, string) error
+	var dir string
+	switch {
+	case strings.HasSuffix(archive, ".zip"):
+		extract, dir = extractZip, strings.TrimSuffix(archive, ".zip")
+	case strings.HasSuffix(archive, ".tar.gz"):
+		extract, dir = extractTarGz, strings.TrimSuffix(archive, ".tar.gz")
+	case strings.HasSuffix(archive, ".tgz"):
+		extract, dir = extractTarGz, strings.TrimSuffix(archive, ".tgz")
+	default:
+		return "", fmt.Errorf("unsupported archive %s, expected .zip, .tar.gz or .tgz", archive)
+	}
+	// A previous extraction may have been interrupted
+	if err = f.fs.RemoveAll(dir); err != nil {
+		return "", fmt.Errorf("error removing %s: %v", dir, err)
+	}
+	if err = extract(f.fs, archive, dir); err != nil {
+		return "", fmt.Errorf("error extracting %s: %v", archive, err)
+	}
+	return dir, nil
+}
+
+// archiveEntryPath returns the path the given archive entry is extracted to within the given directory, or an error if
+// the entry is outside of the directory
+func archiveEntryPath(dir, name string) (string, error) {
+	dest := filepath.Join(dir, filepath.FromSlash(name))
+	if dest != dir && !strings.HasPrefix(dest, filepath.Clean(dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry %s is outside the archive", name)
+	}
+	return dest, nil
+}
+
+// extractFile writes the contents read from the given reader to the given path of the given file system, creating its
+// parent directories
+func extractFile(fs FileSystem, dest string, contents io.Reader) error {
+	if err := fs.MkdirAll(filepath.Dir(dest), os.ModeDir); err != nil {
+		return err
+	}
+	file, err := createFile(fs, dest)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(file, contents)
+	return err
+}
+
+// extractZip extracts the files and directories of the given zip archive to the given directory of the given file
+// system
+func extractZip(fs FileSystem, archive, dir string) error {
+	// The archive is read in memory as the files of the file system cannot be read at random offsets
+	content, err := readFile(fs, archive)
+	if err != nil {
+		return err
+	}
+	reader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return err
+	}
+	for _, entry := range reader.File {
+		dest, err := archiveEntryPath(dir, entry.Name)
+		if err != nil {
+			return err
+		}
+		if entry.FileInfo().IsDir() {
+			if err = fs.MkdirAll(dest, os.ModeDir); err != nil {
+				return err
+			}
+			continue
+		}
+		contents, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		err = extractFile(fs, dest, contents)
+		contents.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractTarGz extracts the regular files and directories of the given gzipped tar archive to the given directory of
+// the given file system. Any other entries, for example symlinks, are skipped.
+func extractTarGz(fs FileSystem, archive, dir string) error {
+	file, err := fs.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	reader := tar.NewReader(gzipReader)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		dest, err := archiveEntryPath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = fs.MkdirAll(dest, os.ModeDir)
+		case tar.TypeReg:
+			err = extractFile(fs, dest, reader)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,660 @@
+package bootstrapper
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileSystem is the file system the bootstrapper reads and writes the files of the Windows node through. It abstracts
+// the file system of the host so that the logic translating and copying the files can be unit tested, on any platform,
+// against an in-memory file system, and so that the dry-run mode keeps the changes in memory.
+type FileSystem interface {
+	// Open opens the named file for reading
+	Open(name string) (File, error)
+	// OpenFile opens the named file with the given flags, like os.O_RDONLY, and the given permissions if it is created
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	// Stat returns the FileInfo of the named file
+	Stat(name string) (os.FileInfo, error)
+	// ReadDir returns the FileInfo of the entries of the named directory, sorted by name
+	ReadDir(name string) ([]os.FileInfo, error)
+	// MkdirAll creates the named directory along with its parents. It does nothing if the directory already exists.
+	MkdirAll(name string, perm os.FileMode) error
+	// Remove removes the named file or empty directory
+	Remove(name string) error
+	// RemoveAll removes the named file or directory along with its children. It does nothing if it does not exist.
+	RemoveAll(name string) error
+	// Rename moves the named file to the new path, replacing the file at the new path if there is one
+	Rename(oldName, newName string) error
+}
+
+// File is a file opened through a FileSystem
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+	// Name returns the name the file was opened with
+	Name() string
+}
+
+// osFileSystem is the file system of the host, which the bootstrapper uses unless it is given another one
+var osFileSystem FileSystem = osFS{}
+
+// OSFileSystem returns the file system of the host
+func OSFileSystem() FileSystem {
+	return osFileSystem
+}
+
+// orOSFileSystem returns the given file system, or the file system of the host if it is nil
+func orOSFileSystem(fs FileSystem) FileSystem {
+	if fs == nil {
+		return osFileSystem
+	}
+	return fs
+}
+
+// readFile returns the contents of the named file of the given file system
+func readFile(fs FileSystem, name string) ([]byte, error) {
+	file, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+// writeFile writes the given contents to the named file of the given file system, creating it with the given
+// permissions or truncating it
+func writeFile(fs FileSystem, name string, contents []byte, perm os.FileMode) error {
+	file, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(contents)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// createFile creates or truncates the named file of the given file system and opens it for writing
+func createFile(fs FileSystem, name string) (File, error) {
+	return fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// walk walks the file tree of the given file system rooted at the given path as filepath.Walk does, calling fn for
+// each file and directory of the tree in lexical order
+func walk(fs FileSystem, root string, fn filepath.WalkFunc) error {
+	info, err := fs.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkEntry(fs, root, info, fn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walkEntry calls fn for the given file or directory and, for a directory, walks its entries
+func walkEntry(fs FileSystem, name string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(name, info, nil)
+	}
+	entries, err := fs.ReadDir(name)
+	if fnErr := fn(name, info, err); err != nil || fnErr != nil {
+		return fnErr
+	}
+	for _, entry := range entries {
+		err = walkEntry(fs, filepath.Join(name, entry.Name()), entry, fn)
+		if err != nil && (!entry.IsDir() || err != filepath.SkipDir) {
+			return err
+		}
+	}
+	return nil
+}
+
+// glob returns the names of the files of the given file system matching the given pattern, as filepath.Glob does. Only
+// the last element of the pattern can contain wildcards.
+func glob(fs FileSystem, pattern string) ([]string, error) {
+	dir, filePattern := filepath.Split(pattern)
+	if _, err := filepath.Match(filePattern, ""); err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		// Like filepath.Glob, a missing directory has no matches
+		return nil, nil
+	}
+	var matches []string
+	for _, entry := range entries {
+		if matched, _ := filepath.Match(filePattern, entry.Name()); matched {
+			matches = append(matches, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return matches, nil
+}
+
+// osFS is the FileSystem of the host
+type osFS struct{}
+
+// Open opens the named file for reading
+func (osFS) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+// OpenFile opens the named file with the given flags and permissions
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+// Stat returns the FileInfo of the named file
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// ReadDir returns the FileInfo of the entries of the named directory, sorted by name
+func (osFS) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(name)
+}
+
+// MkdirAll creates the named directory along with its parents
+func (osFS) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+// Remove removes the named file or empty directory
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// RemoveAll removes the named file or directory along with its children
+func (osFS) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+// Rename moves the named file to the new path
+func (osFS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+// memEntry is a file or a directory of a memFS
+type memEntry struct {
+	// name is the base name of the entry, in the case it was created with
+	name string
+	// dir indicates that the entry is a directory
+	dir bool
+	// mode holds the permissions of the entry
+	mode os.FileMode
+	// modTime is the last time the entry was written
+	modTime time.Time
+	// contents are the contents of the file, nil for a directory
+	contents []byte
+}
+
+// memFS is an in-memory FileSystem following the Windows path rules: both \ and / separate the elements of a path, and
+// the paths are case insensitive, so that C:\k\kubelet.exe and c:/K/Kubelet.exe name the same file. The drive letter
+// and the root directory always exist.
+type memFS struct {
+	// lock guards entries
+	lock sync.RWMutex
+	// entries are the files and directories by their normalized path
+	entries map[string]*memEntry
+}
+
+// NewMemFileSystem returns an empty in-memory file system following the Windows path rules, which the bootstrapper can
+// be created with to unit test it without touching the file system of the host
+func NewMemFileSystem() FileSystem {
+	return newMemFS()
+}
+
+// newMemFS returns an empty memFS
+func newMemFS() *memFS {
+	return &memFS{entries: make(map[string]*memEntry)}
+}
+
+// normalizePath returns the key of the given Windows or slash separated path, which is cleaned, slash separated and
+// lower case
+func normalizePath(name string) string {
+	return strings.ToLower(path.Clean(strings.ReplaceAll(name, "\\", "/")))
+}
+
+// isRootPath returns true if the given normalized path is a drive or a root directory, which always exist
+func isRootPath(key string) bool {
+	return key == "/" || key == "." || (len(key) == 2 && key[1] == ':') || (len(key) == 3 && key[1:] == ":/")
+}
+
+// parentPath returns the normalized path of the parent directory of the given normalized path
+func parentPath(key string) string {
+	parent := path.Dir(key)
+	// path.Dir does not know about drives, c:/k is a child of c:
+	if len(parent) == 3 && parent[1:] == ":/" {
+		return parent[:2]
+	}
+	return parent
+}
+
+// pathError returns the error of the given operation on the named file, which os.IsNotExist and os.IsExist recognize
+func pathError(op, name string, err error) error {
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// isDir returns true if the given normalized path is a directory. The lock needs to be held.
+func (m *memFS) isDir(key string) bool {
+	if isRootPath(key) {
+		return true
+	}
+	entry, ok := m.entries[key]
+	return ok && entry.dir
+}
+
+// Open opens the named file for reading
+func (m *memFS) Open(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the named file with the given flags, creating it with the given permissions if os.O_CREATE is given
+func (m *memFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := normalizePath(name)
+	entry, ok := m.entries[key]
+	switch {
+	case ok && entry.dir, !ok && isRootPath(key):
+		if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+			return nil, pathError("open", name, fmt.Errorf("is a directory"))
+		}
+		return &memFile{name: name}, nil
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, pathError("open", name, os.ErrExist)
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, pathError("open", name, os.ErrNotExist)
+	case !ok:
+		if !m.isDir(parentPath(key)) {
+			return nil, pathError("open", name, os.ErrNotExist)
+		}
+		entry = &memEntry{name: path.Base(strings.ReplaceAll(name, "\\", "/")), mode: perm, modTime: time.Now()}
+		m.entries[key] = entry
+	}
+	if flag&os.O_TRUNC != 0 {
+		entry.contents = nil
+	}
+	file := &memFile{fs: m, name: name, entry: entry, writable: flag&(os.O_WRONLY|os.O_RDWR) != 0}
+	if flag&os.O_APPEND != 0 {
+		file.offset = len(entry.contents)
+	}
+	return file, nil
+}
+
+// Stat returns the FileInfo of the named file
+func (m *memFS) Stat(name string) (os.FileInfo, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	key := normalizePath(name)
+	if entry, ok := m.entries[key]; ok {
+		return entry.info(), nil
+	}
+	if isRootPath(key) {
+		return &memFileInfo{name: key, dir: true, mode: os.ModeDir | 0755}, nil
+	}
+	return nil, pathError("stat", name, os.ErrNotExist)
+}
+
+// ReadDir returns the FileInfo of the entries of the named directory, sorted by name
+func (m *memFS) ReadDir(name string) ([]os.FileInfo, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	key := normalizePath(name)
+	if !m.isDir(key) {
+		return nil, pathError("readdir", name, os.ErrNotExist)
+	}
+	var infos []os.FileInfo
+	for entryKey, entry := range m.entries {
+		if entryKey != key && parentPath(entryKey) == key {
+			infos = append(infos, entry.info())
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// MkdirAll creates the named directory along with its parents
+func (m *memFS) MkdirAll(name string, perm os.FileMode) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var missing []string
+	for key := normalizePath(name); !m.isDir(key); key = parentPath(key) {
+		if _, ok := m.entries[key]; ok {
+			return pathError("mkdir", name, fmt.Errorf("not a directory"))
+		}
+		missing = append(missing, key)
+	}
+	// The names of the directories are only known from the given path, in their case
+	elems := strings.Split(path.Clean(strings.ReplaceAll(name, "\\", "/")), "/")
+	for i, key := range missing {
+		m.entries[key] = &memEntry{name: elems[len(elems)-1-i], dir: true, mode: os.ModeDir | perm.Perm(),
+			modTime: time.Now()}
+	}
+	return nil
+}
+
+// Remove removes the named file or empty directory
+func (m *memFS) Remove(name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := normalizePath(name)
+	entry, ok := m.entries[key]
+	if !ok {
+		return pathError("remove", name, os.ErrNotExist)
+	}
+	if entry.dir {
+		for entryKey := range m.entries {
+			if parentPath(entryKey) == key {
+				return pathError("remove", name, fmt.Errorf("directory not empty"))
+			}
+		}
+	}
+	delete(m.entries, key)
+	return nil
+}
+
+// RemoveAll removes the named file or directory along with its children
+func (m *memFS) RemoveAll(name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := normalizePath(name)
+	for entryKey := range m.entries {
+		if entryKey == key || strings.HasPrefix(entryKey, strings.TrimSuffix(key, "/")+"/") {
+			delete(m.entries, entryKey)
+		}
+	}
+	return nil
+}
+
+// Rename moves the named file to the new path, replacing the file at the new path if there is one. Directories cannot
+// be renamed.
+func (m *memFS) Rename(oldName, newName string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	oldKey, newKey := normalizePath(oldName), normalizePath(newName)
+	entry, ok := m.entries[oldKey]
+	if !ok {
+		return pathError("rename", oldName, os.ErrNotExist)
+	}
+	if entry.dir {
+		return pathError("rename", oldName, fmt.Errorf("directories cannot be renamed"))
+	}
+	if !m.isDir(parentPath(newKey)) || m.isDir(newKey) {
+		return pathError("rename", newName, os.ErrNotExist)
+	}
+	delete(m.entries, oldKey)
+	entry.name = path.Base(strings.ReplaceAll(newName, "\\", "/"))
+	m.entries[newKey] = entry
+	return nil
+}
+
+// info returns the FileInfo of the entry
+func (e *memEntry) info() os.FileInfo {
+	return &memFileInfo{name: e.name, size: int64(len(e.contents)), mode: e.mode, modTime: e.modTime, dir: e.dir}
+}
+
+// memFile is a file opened through a memFS. Its writes are visible to the other opened files right away.
+type memFile struct {
+	// fs is the file system the file belongs to, nil for a directory
+	fs *memFS
+	// name is the name the file was opened with
+	name string
+	// entry is the opened file, nil for a directory
+	entry *memEntry
+	// writable indicates that the file was opened for writing
+	writable bool
+	// offset is the position the file is read from and written to
+	offset int
+	// closed indicates that the file has been closed
+	closed bool
+}
+
+// Name returns the name the file was opened with
+func (f *memFile) Name() string {
+	return f.name
+}
+
+// Read reads the contents of the file from the current offset
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, pathError("read", f.name, os.ErrClosed)
+	}
+	if f.entry == nil {
+		return 0, pathError("read", f.name, fmt.Errorf("is a directory"))
+	}
+	f.fs.lock.RLock()
+	defer f.fs.lock.RUnlock()
+	if f.offset >= len(f.entry.contents) {
+		return 0, io.EOF
+	}
+	n, err := bytes.NewReader(f.entry.contents[f.offset:]).Read(p)
+	f.offset += n
+	return n, err
+}
+
+// Write writes to the file at the current offset, extending the file if needed
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, pathError("write", f.name, os.ErrClosed)
+	}
+	if !f.writable {
+		return 0, pathError("write", f.name, os.ErrPermission)
+	}
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+	if end := f.offset + len(p); end > len(f.entry.contents) {
+		f.entry.contents = append(f.entry.contents, make([]byte, end-len(f.entry.contents))...)
+	}
+	copy(f.entry.contents[f.offset:], p)
+	f.offset += len(p)
+	f.entry.modTime = time.Now()
+	return len(p), nil
+}
+
+// Close closes the file
+func (f *memFile) Close() error {
+	if f.closed {
+		return pathError("close", f.name, os.ErrClosed)
+	}
+	f.closed = true
+	return nil
+}
+
+// memFileInfo is the FileInfo of a memEntry
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	dir     bool
+}
+
+// Name returns the base name of the file
+func (i *memFileInfo) Name() string { return i.name }
+
+// Size returns the size of the file in bytes
+func (i *memFileInfo) Size() int64 { return i.size }
+
+// Mode returns the mode of the file
+func (i *memFileInfo) Mode() os.FileMode { return i.mode }
+
+// ModTime returns the last time the file was written
+func (i *memFileInfo) ModTime() time.Time { return i.modTime }
+
+// IsDir returns true if the file is a directory
+func (i *memFileInfo) IsDir() bool { return i.dir }
+
+// Sys returns nil, as the file has no underlying data source
+func (i *memFileInfo) Sys() interface{} { return nil }
+
+// overlayFS is a FileSystem reading the files of a base FileSystem, and keeping the files written or removed in
+// memory rather than changing the base file system. It backs the dry-run mode, so that the changes the bootstrapper
+// would make are visible to it without touching the Windows node.
+type overlayFS struct {
+	// base is the file system the files are read from until they are written or removed
+	base FileSystem
+	// mem holds the files written through the overlay
+	mem *memFS
+	// lock guards removed
+	lock sync.RWMutex
+	// removed are the normalized paths removed through the overlay, the base files of which are hidden along with
+	// their children
+	removed map[string]bool
+}
+
+// newOverlayFS returns an overlayFS over the given base file system
+func newOverlayFS(base FileSystem) *overlayFS {
+	return &overlayFS{base: base, mem: newMemFS(), removed: make(map[string]bool)}
+}
+
+// inBase returns true if the given path is taken from the base file system, as it has neither been written nor
+// removed through the overlay
+func (o *overlayFS) inBase(name string) bool {
+	if _, err := o.mem.Stat(name); err == nil {
+		return false
+	}
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	for key := normalizePath(name); ; key = parentPath(key) {
+		if o.removed[key] {
+			return false
+		}
+		if isRootPath(key) || parentPath(key) == key {
+			return true
+		}
+	}
+}
+
+// Open opens the named file for reading
+func (o *overlayFS) Open(name string) (File, error) {
+	if o.inBase(name) {
+		return o.base.Open(name)
+	}
+	return o.mem.Open(name)
+}
+
+// OpenFile opens the named file with the given flags and permissions. The file is copied to memory before it is
+// opened for writing, unless it is truncated.
+func (o *overlayFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return o.Open(name)
+	}
+	if err := o.copyUp(name, flag&os.O_TRUNC == 0); err != nil {
+		return nil, err
+	}
+	return o.mem.OpenFile(name, flag, perm)
+}
+
+// copyUp creates the parent directories of the named file in memory if they exist in the base file system, and
+// copies the file itself to memory if withContents is true
+func (o *overlayFS) copyUp(name string, withContents bool) error {
+	parent := parentPath(normalizePath(name))
+	if info, err := o.Stat(parent); err == nil && info.IsDir() {
+		if err = o.mem.MkdirAll(parent, 0755); err != nil {
+			return err
+		}
+	}
+	if !withContents || !o.inBase(name) {
+		return nil
+	}
+	contents, err := readFile(o.base, name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return writeFile(o.mem, name, contents, 0644)
+}
+
+// Stat returns the FileInfo of the named file
+func (o *overlayFS) Stat(name string) (os.FileInfo, error) {
+	if o.inBase(name) {
+		return o.base.Stat(name)
+	}
+	return o.mem.Stat(name)
+}
+
+// ReadDir returns the FileInfo of the entries of the named directory, in memory and in the base file system, sorted
+// by name
+func (o *overlayFS) ReadDir(name string) ([]os.FileInfo, error) {
+	infos, memErr := o.mem.ReadDir(name)
+	names := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		names[strings.ToLower(info.Name())] = true
+	}
+	baseInfos, baseErr := o.base.ReadDir(name)
+	if memErr != nil && (baseErr != nil || !o.inBase(name)) {
+		return nil, memErr
+	}
+	for _, info := range baseInfos {
+		if !names[strings.ToLower(info.Name())] && o.inBase(path.Join(normalizePath(name), info.Name())) {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// MkdirAll creates the named directory along with its parents in memory
+func (o *overlayFS) MkdirAll(name string, perm os.FileMode) error {
+	return o.mem.MkdirAll(name, perm)
+}
+
+// Remove removes the named file or empty directory from memory, and hides it in the base file system
+func (o *overlayFS) Remove(name string) error {
+	if _, err := o.Stat(name); err != nil {
+		return err
+	}
+	if err := o.mem.RemoveAll(name); err != nil {
+		return err
+	}
+	o.hide(name)
+	return nil
+}
+
+// RemoveAll removes the named file or directory along with its children from memory, and hides them in the base file
+// system
+func (o *overlayFS) RemoveAll(name string) error {
+	if err := o.mem.RemoveAll(name); err != nil {
+		return err
+	}
+	o.hide(name)
+	return nil
+}
+
+// hide hides the given path of the base file system
+func (o *overlayFS) hide(name string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.removed[normalizePath(name)] = true
+}
+
+// Rename moves the named file to the new path in memory, and hides it in the base file system
+func (o *overlayFS) Rename(oldName, newName string) error {
+	if err := o.copyUp(oldName, true); err != nil {
+		return err
+	}
+	if err := o.copyUp(newName, false); err != nil {
+		return err
+	}
+	if err := o.mem.Rename(oldName, newName); err != nil {
+		return err
+	}
+	o.hide(oldName)
+	return nil
+}
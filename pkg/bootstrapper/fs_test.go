@@ -0,0 +1,105 @@
+package bootstrapper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWalkAndGlob tests that the file trees of a FileSystem are walked and matched as filepath.Walk and filepath.Glob
+// do on the host
+func TestWalkAndGlob(t *testing.T) {
+	fs := newMemFS()
+	require.NoError(t, fs.MkdirAll("C:/k/logs/kubelet", 0755))
+	require.NoError(t, fs.MkdirAll("C:/k/skipped", 0755))
+	for _, name := range []string{"C:/k/kubelet.exe", "C:/k/logs/kubelet/kubelet.log", "C:/k/skipped/file",
+		"C:/k/kubelet-server-2021.pem", "C:/k/kubelet-server-current.pem"} {
+		require.NoError(t, writeFile(fs, name, []byte("content"), 0644))
+	}
+
+	var walked []string
+	err := walk(fs, "C:/k", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == "skipped" {
+			return filepath.SkipDir
+		}
+		walked = append(walked, filepath.ToSlash(path))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"C:/k", "C:/k/kubelet-server-2021.pem", "C:/k/kubelet-server-current.pem",
+		"C:/k/kubelet.exe", "C:/k/logs", "C:/k/logs/kubelet", "C:/k/logs/kubelet/kubelet.log"}, walked)
+	err = walk(fs, "C:/missing", func(_ string, _ os.FileInfo, err error) error {
+		return err
+	})
+	assert.True(t, os.IsNotExist(err), "expected walking a missing root to report it")
+
+	matches, err := glob(fs, "C:/k/kubelet-server-*.pem")
+	require.NoError(t, err)
+	for i := range matches {
+		matches[i] = filepath.ToSlash(matches[i])
+	}
+	assert.Equal(t, []string{"C:/k/kubelet-server-2021.pem", "C:/k/kubelet-server-current.pem"}, matches)
+	matches, err = glob(fs, "C:/missing/*.pem")
+	assert.NoError(t, err)
+	assert.Empty(t, matches, "expected a missing directory to have no matches")
+	_, err = glob(fs, "C:/k/[")
+	assert.Error(t, err, "expected a malformed pattern to be rejected")
+}
+
+// TestFileSystem tests the Windows path handling of the in-memory file system, the CNI file copying against it, and
+// the overlay keeping the changes of the dry-run mode in memory
+func TestFileSystem(t *testing.T) {
+	fs := newMemFS()
+	overlay := `{"cniVersion":"0.2.0","name":"OpenShiftNetwork","type":"win-overlay",` +
+		`"ipam":{"type":"host-local","subnet":"10.132.1.0/24"}}`
+	require.NoError(t, fs.MkdirAll(`C:\source\cni`, 0755))
+	require.NoError(t, fs.MkdirAll(`C:\k\cni\config`, 0755))
+	require.NoError(t, writeFile(fs, `C:\source\cni\win-overlay.exe`, []byte("binary"), 0644))
+	require.NoError(t, writeFile(fs, `C:\source\cni.conf`, []byte(overlay), 0644))
+	require.NoError(t, writeFile(fs, `C:\k\cni\config\stale.conf`, []byte("{}"), 0644))
+
+	info, err := fs.Stat("c:/SOURCE/cni/WIN-OVERLAY.exe")
+	require.NoError(t, err, "expected the paths to be case insensitive and to accept both separators")
+	assert.Equal(t, "win-overlay.exe", info.Name())
+	_, err = fs.Open(`C:\source\missing.exe`)
+	assert.True(t, os.IsNotExist(err), "expected a missing file to be reported as not existing")
+	assert.Error(t, writeFile(fs, `C:\missing\file`, nil, 0644), "expected writing to a missing directory to fail")
+	assert.Error(t, fs.Remove(`C:\source`), "expected removing a directory that is not empty to fail")
+
+	cni, err := newCNIOptions(fs, "C:/k", "", "C:/source/cni", "C:/source/cni.conf")
+	require.NoError(t, err)
+	require.NoError(t, cni.ensureDirIsPresent())
+	require.NoError(t, cni.copyFiles())
+	contents, err := readFile(fs, filepath.Join(cni.layout.binDir, "win-overlay.exe"))
+	require.NoError(t, err)
+	assert.Equal(t, "binary", string(contents))
+	contents, err = readFile(fs, filepath.Join(cni.layout.confDir, "cni.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, overlay, string(contents))
+	_, err = fs.Stat(`C:\k\cni\config\stale.conf`)
+	assert.True(t, os.IsNotExist(err), "expected the stale CNI config to be removed")
+
+	dryRun := newOverlayFS(fs)
+	require.NoError(t, writeFile(dryRun, `C:\k\cni\config\cni.conf`, []byte("{}"), 0644))
+	require.NoError(t, dryRun.Remove(`C:\source\cni.conf`))
+	contents, err = readFile(dryRun, `C:\k\cni\config\cni.conf`)
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(contents), "expected the written file to be read from memory")
+	contents, err = readFile(fs, `C:\k\cni\config\cni.conf`)
+	require.NoError(t, err)
+	assert.Equal(t, overlay, string(contents), "expected the base file system to be left untouched")
+	_, err = dryRun.Stat(`C:\source\cni.conf`)
+	assert.True(t, os.IsNotExist(err), "expected the removed file to be hidden")
+	_, err = fs.Stat(`C:\source\cni.conf`)
+	assert.NoError(t, err, "expected the removed file to be kept in the base file system")
+	files, err := dryRun.ReadDir(`C:\source`)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "cni", files[0].Name())
+}
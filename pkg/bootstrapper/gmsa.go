@@ -0,0 +1,105 @@
+package bootstrapper
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// gmsaDirName is the directory in the install dir the CCG plugin is installed to
+	gmsaDirName = "gmsa"
+	// ccgCOMClassesKey is the registry key under which the COM classes of the CCG plugins allowed to retrieve the GMSA
+	// credentials are registered
+	ccgCOMClassesKey = `SYSTEM\CurrentControlSet\Control\CCG\COMClasses\`
+	// gmsaNodeLabel is the label applied to the nodes with GMSA support, so that the workloads using GMSA credential
+	// specs can be scheduled onto them
+	gmsaNodeLabel = "node.openshift.io/gmsa=true"
+)
+
+// clsidRegex matches a COM class ID, for example {01234567-89AB-CDEF-0123-456789ABCDEF}
+var clsidRegex = regexp.MustCompile(`^\{[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}\}$`)
+
+// gmsaOptions holds the options of the GMSA support
+type gmsaOptions struct {
+	// ccgPluginPath is the DLL of the CCG plugin retrieving the GMSA credentials on a node that is not domain joined.
+	// The node needs to be domain joined if it is empty.
+	ccgPluginPath string
+	// ccgPluginCLSID is the COM class ID the CCG plugin is registered with
+	ccgPluginCLSID string
+}
+
+// SetGMSA enables the support of the workloads using Group Managed Service Accounts. On a domain joined node the GMSA
+// credentials are retrieved by the node itself, otherwise a Container Credential Guard (CCG) plugin is required to
+// retrieve them. ccgPluginPath is the DLL of the CCG plugin, which is installed and registered as the COM class with
+// the given ID, for example {01234567-89AB-CDEF-0123-456789ABCDEF}. The node needs to be domain joined if it is empty.
+// The node is labeled with node.openshift.io/gmsa=true. This needs to be called before InitializeKubelet for the GMSA
+// support to take effect.
+func (wmcb *winNodeBootstrapper) SetGMSA(ccgPluginPath, ccgPluginCLSID string) error {
+	if ccgPluginPath == "" {
+		if ccgPluginCLSID != "" {
+			return fmt.Errorf("CCG plugin CLSID given without a CCG plugin")
+		}
+		wmcb.gmsa = &gmsaOptions{}
+		return nil
+	}
+	if !strings.EqualFold(filepath.Ext(ccgPluginPath), ".dll") {
+		return fmt.Errorf("CCG plugin %s is not a DLL", ccgPluginPath)
+	}
+	if !clsidRegex.MatchString(ccgPluginCLSID) {
+		return fmt.Errorf("invalid CCG plugin CLSID %q, expected {xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx}",
+			ccgPluginCLSID)
+	}
+	wmcb.gmsa = &gmsaOptions{ccgPluginPath: ccgPluginPath, ccgPluginCLSID: ccgPluginCLSID}
+	return nil
+}
+
+// configureGMSA validates that the GMSA credentials can be retrieved on the Windows node, installing and registering
+// the CCG plugin if given
+func (wmcb *winNodeBootstrapper) configureGMSA(ctx context.Context) error {
+	if wmcb.gmsa == nil {
+		return nil
+	}
+	domain, err := domainName()
+	if err != nil {
+		return err
+	}
+	if wmcb.gmsa.ccgPluginPath == "" {
+		if domain == "" {
+			return fmt.Errorf("the Windows node needs to be joined to a domain, or a CCG plugin given, for GMSA")
+		}
+		wmcb.log.Info("GMSA credentials retrieved through the domain", "domain", domain)
+		return nil
+	}
+
+	gmsaDir := filepath.Join(wmcb.installDir, gmsaDirName)
+	if err = wmcb.mkdirAll(gmsaDir); err != nil {
+		return fmt.Errorf("could not make %s directory: %v", gmsaDir, err)
+	}
+	contents, err := readFile(wmcb.fileSystem(), wmcb.gmsa.ccgPluginPath)
+	if err != nil {
+		return fmt.Errorf("could not read CCG plugin: %v", err)
+	}
+	pluginPath := filepath.Join(gmsaDir, filepath.Base(wmcb.gmsa.ccgPluginPath))
+	if err = wmcb.writeKubeletFile(pluginPath, contents); err != nil {
+		return fmt.Errorf("could not copy CCG plugin: %v", err)
+	}
+	if wmcb.dryRun != nil {
+		wmcb.dryRun.addAction("register the CCG plugin %s as the COM class %s", pluginPath, wmcb.gmsa.ccgPluginCLSID)
+		return nil
+	}
+
+	wmcb.log.Info("registering CCG plugin", "path", pluginPath, "clsid", wmcb.gmsa.ccgPluginCLSID)
+	if out, err := exec.CommandContext(ctx, "regsvr32.exe", "/s", pluginPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("error registering CCG plugin %s: %v: %s", pluginPath, err, out)
+	}
+	// CCG only loads the plugins whose COM class is registered under its key
+	if err = registerCCGCOMClass(wmcb.gmsa.ccgPluginCLSID); err != nil {
+		return fmt.Errorf("error registering the COM class of the CCG plugin, the CCG registry key needs to be "+
+			"writable by the Administrators: %v", err)
+	}
+	return nil
+}
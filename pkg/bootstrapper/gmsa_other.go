@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package bootstrapper
+
+// domainName returns errUnsupportedPlatform as there is no Windows domain to be joined to
+func domainName() (string, error) {
+	return "", errUnsupportedPlatform
+}
+
+// registerCCGCOMClass returns errUnsupportedPlatform as there is no CCG
+func registerCCGCOMClass(clsid string) error {
+	return errUnsupportedPlatform
+}
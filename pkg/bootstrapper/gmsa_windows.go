@@ -0,0 +1,34 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// domainName returns the name of the domain the Windows node is joined to, or an empty name if it is not domain
+// joined
+func domainName() (string, error) {
+	var name *uint16
+	var joinStatus uint32
+	if err := windows.NetGetJoinInformation(nil, &name, &joinStatus); err != nil {
+		return "", fmt.Errorf("error getting domain join status: %v", err)
+	}
+	defer windows.NetApiBufferFree((*byte)(unsafe.Pointer(name)))
+	if joinStatus != windows.NetSetupDomainName {
+		return "", nil
+	}
+	return windows.UTF16PtrToString(name), nil
+}
+
+// registerCCGCOMClass registers the COM class with the given ID under the CCG key, which allows CCG to load the
+// plugin implementing it
+func registerCCGCOMClass(clsid string) error {
+	key, _, err := registry.CreateKey(registry.LOCAL_MACHINE, ccgCOMClassesKey+clsid, registry.QUERY_VALUE)
+	if err != nil {
+		return err
+	}
+	return key.Close()
+}
@@ -0,0 +1,27 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/hns"
+)
+
+// hnsNetworkTimeout is the time to wait for the HNS network to become available once it has been created
+const hnsNetworkTimeout = 2 * time.Minute
+
+// SetHNSNetwork configures Configure to ensure that the given HNS network is present before configuring CNI, creating
+// it if it does not exist and recreating it if it does not match the given config, and to attach the primary CNI config
+// to it. The network cannot be managed along with the hybrid overlay, which creates its own network. This needs to be
+// called before Configure to take effect.
+func (wmcb *winNodeBootstrapper) SetHNSNetwork(config hns.NetworkConfig) error {
+	if wmcb.cni == nil {
+		return fmt.Errorf("HNS network can only be set along with CNI")
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid HNS network: %v", err)
+	}
+	wmcb.hnsNetwork = &config
+	wmcb.cni.networkName = config.Name
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package bootstrapper
+
+import "context"
+
+// ensureHNSNetwork returns errUnsupportedPlatform as there is no HNS
+func (wmcb *winNodeBootstrapper) ensureHNSNetwork(ctx context.Context) error {
+	return errUnsupportedPlatform
+}
+
+// deleteHNSNetworks returns errUnsupportedPlatform as there is no HNS
+func deleteHNSNetworks() ([]string, error) {
+	return nil, errUnsupportedPlatform
+}
+
+// addHNSState records errUnsupportedPlatform in the archive as there is no HNS
+func (a *mustGatherArchive) addHNSState() {
+	a.recordError("hns", errUnsupportedPlatform)
+}
@@ -0,0 +1,39 @@
+package bootstrapper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/hns"
+)
+
+// ensureHNSNetwork ensures that the HNS network the CNI config is attached to is present and available
+func (wmcb *winNodeBootstrapper) ensureHNSNetwork(ctx context.Context) error {
+	if wmcb.hybridOverlay != nil {
+		return fmt.Errorf("HNS network cannot be set along with the hybrid overlay")
+	}
+	wmcb.log.Info("ensuring HNS network", "name", wmcb.hnsNetwork.Name, "type", wmcb.hnsNetwork.Type)
+	network, mismatches, err := hns.EnsureNetwork(ctx, *wmcb.hnsNetwork, hnsNetworkTimeout)
+	if len(mismatches) != 0 {
+		wmcb.log.Info("recreated HNS network as it did not match", "name", wmcb.hnsNetwork.Name, "mismatches",
+			mismatches)
+	}
+	if err != nil {
+		return err
+	}
+	wmcb.log.Info("HNS network available", "name", network.Name, "id", network.Id)
+	return nil
+}
+
+// deleteHNSNetworks deletes the HNS networks of the Windows node and returns their names
+func deleteHNSNetworks() ([]string, error) {
+	return hns.DeleteNetworks()
+}
+
+// addHNSState adds the HNS networks and endpoints of the Windows node to the archive
+func (a *mustGatherArchive) addHNSState() {
+	networks, err := hns.ListNetworks()
+	a.addJSON("hns/networks.json", networks, err)
+	endpoints, err := hns.ListEndpoints()
+	a.addJSON("hns/endpoints.json", endpoints, err)
+}
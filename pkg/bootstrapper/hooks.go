@@ -0,0 +1,156 @@
+package bootstrapper
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// HookPoint is a point of the bootstrap at which hooks are run
+type HookPoint string
+
+const (
+	// HookPreKubelet is run by InitializeKubelet before the kubelet files are written
+	HookPreKubelet HookPoint = "pre-kubelet"
+	// HookPostKubelet is run by InitializeKubelet once the kubelet service has been started
+	HookPostKubelet HookPoint = "post-kubelet"
+	// HookPreCNI is run by Configure before the kubelet is stopped to be configured for CNI
+	HookPreCNI HookPoint = "pre-cni"
+	// HookPostCNI is run by Configure once the kubelet service has been restarted with the CNI configuration
+	HookPostCNI HookPoint = "post-cni"
+	// HookPreKubeProxy is run by ConfigureKubeProxy before kube-proxy is installed
+	HookPreKubeProxy HookPoint = "pre-kube-proxy"
+	// HookPostKubeProxy is run by ConfigureKubeProxy once the kube-proxy service has been started
+	HookPostKubeProxy HookPoint = "post-kube-proxy"
+
+	// hooksDirName is the name of the directory in the install directory holding the hook script directories
+	hooksDirName = "hooks"
+)
+
+// hookPoints are the valid hook points
+var hookPoints = []HookPoint{HookPreKubelet, HookPostKubelet, HookPreCNI, HookPostCNI, HookPreKubeProxy,
+	HookPostKubeProxy}
+
+// HookFunc is a hook registered using AddHook. Returning an error fails the bootstrap step the hook is run by.
+type HookFunc func(ctx context.Context) error
+
+// AddHook registers a hook to be run at the given point of the bootstrap, allowing library consumers to inject custom
+// steps, like joining a domain or installing a plugin, without forking WMCB. The hooks registered for a point are run
+// in the order they were registered, before the hook scripts of the point.
+func (wmcb *winNodeBootstrapper) AddHook(point HookPoint, hook HookFunc) error {
+	if hook == nil {
+		return fmt.Errorf("hook cannot be nil")
+	}
+	if !isHookPoint(point) {
+		return fmt.Errorf("unknown hook point %s, expected one of %s", point, hookPointNames())
+	}
+	if wmcb.hooks == nil {
+		wmcb.hooks = make(map[HookPoint][]HookFunc)
+	}
+	wmcb.hooks[point] = append(wmcb.hooks[point], hook)
+	return nil
+}
+
+// isHookPoint returns true if the given point is a valid hook point
+func isHookPoint(point HookPoint) bool {
+	for _, p := range hookPoints {
+		if p == point {
+			return true
+		}
+	}
+	return false
+}
+
+// hookPointNames returns the comma separated names of the valid hook points
+func hookPointNames() string {
+	var names []string
+	for _, point := range hookPoints {
+		names = append(names, string(point))
+	}
+	return strings.Join(names, ", ")
+}
+
+// hookScriptsDir returns the directory the hook scripts of the given point are read from, for example
+// C:\k\hooks\pre-kubelet.d
+func (wmcb *winNodeBootstrapper) hookScriptsDir(point HookPoint) string {
+	return filepath.Join(wmcb.installDir, hooksDirName, string(point)+".d")
+}
+
+// hookScripts returns the paths of the hook scripts of the given point in lexical order. PowerShell scripts, batch
+// files and executables are run, the other files are ignored.
+func (wmcb *winNodeBootstrapper) hookScripts(point HookPoint) ([]string, error) {
+	dir := wmcb.hookScriptsDir(point)
+	files, err := wmcb.fileSystem().ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading hooks dir %s: %v", dir, err)
+	}
+	var scripts []string
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(file.Name())) {
+		case ".ps1", ".cmd", ".bat", ".exe":
+			scripts = append(scripts, filepath.Join(dir, file.Name()))
+		default:
+			wmcb.log.V(1).Info("ignoring hook file", "path", filepath.Join(dir, file.Name()))
+		}
+	}
+	return scripts, nil
+}
+
+// hookScriptCommand returns the command running the given hook script of the given point. The point and the install
+// directory are passed to the script in the WMCB_HOOK and WMCB_INSTALL_DIR environment variables.
+func (wmcb *winNodeBootstrapper) hookScriptCommand(ctx context.Context, point HookPoint, script string) *exec.Cmd {
+	var cmd *exec.Cmd
+	switch strings.ToLower(filepath.Ext(script)) {
+	case ".ps1":
+		cmd = exec.CommandContext(ctx, powerShellExe, "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", script)
+	case ".cmd", ".bat":
+		cmd = exec.CommandContext(ctx, "cmd.exe", "/c", script)
+	default:
+		cmd = exec.CommandContext(ctx, script)
+	}
+	cmd.Env = append(os.Environ(), "WMCB_HOOK="+string(point), "WMCB_INSTALL_DIR="+wmcb.installDir)
+	return cmd
+}
+
+// runHooks runs the hooks registered for the given point, followed by its hook scripts. The first hook failing
+// aborts the run and its error is returned. The hooks are only recorded in dry-run mode.
+func (wmcb *winNodeBootstrapper) runHooks(ctx context.Context, point HookPoint) error {
+	scripts, err := wmcb.hookScripts(point)
+	if err != nil {
+		return err
+	}
+	if wmcb.dryRun != nil {
+		if len(wmcb.hooks[point]) > 0 {
+			wmcb.dryRun.addAction("run the %d registered %s hooks", len(wmcb.hooks[point]), point)
+		}
+		for _, script := range scripts {
+			wmcb.dryRun.addAction("run the %s hook %s", point, script)
+		}
+		return nil
+	}
+
+	for i, hook := range wmcb.hooks[point] {
+		wmcb.log.Info("running hook", "point", point, "index", i)
+		if err = hook(ctx); err != nil {
+			return fmt.Errorf("%s hook %d failed: %v", point, i, err)
+		}
+	}
+	for _, script := range scripts {
+		wmcb.log.Info("running hook script", "point", point, "path", script)
+		out, err := wmcb.hookScriptCommand(ctx, point, script).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s hook %s failed: %v: %s", point, script, err, out)
+		}
+		wmcb.log.V(1).Info("hook script completed", "path", script, "output", string(out))
+	}
+	return nil
+}
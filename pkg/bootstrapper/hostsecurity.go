@@ -0,0 +1,99 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// firewallRule is an inbound Windows Firewall rule required by the node components
+type firewallRule struct {
+	// name is the display name of the rule
+	name string
+	// protocol is the protocol of the traffic allowed by the rule, either TCP or UDP
+	protocol string
+	// port is the local port the traffic is allowed on
+	port string
+}
+
+// hostFirewallRules are the inbound firewall rules required by the node components
+var hostFirewallRules = []firewallRule{
+	// The kubelet port, used for the container logs, exec and metrics
+	{name: containerLogsPortRuleName, protocol: "TCP", port: "10250"},
+	// The VXLAN port used by the hybrid overlay
+	{name: "HybridOverlayVXLAN", protocol: "UDP", port: "4789"},
+	// The kube-proxy health check port, used by the load balancers of the cloud provider
+	{name: "KubeProxyHealthz", protocol: "TCP", port: "10256"},
+}
+
+// defenderExclusions returns the paths and processes of the node components that are excluded from the Windows
+// Defender real-time scanning, which otherwise slows down container start up and image pulls
+func (wmcb *winNodeBootstrapper) defenderExclusions() ([]string, []string) {
+	// The install directory contains the CNI and containerd directories
+	paths := []string{wmcb.installDir, wmcb.logDir}
+	processes := []string{
+		filepath.Join(wmcb.installDir, "kubelet.exe"),
+		filepath.Join(wmcb.containerdInstallDir(), containerdExe),
+		filepath.Join(wmcb.installDir, kubeProxyExe),
+		filepath.Join(wmcb.installDir, hybridOverlayExe),
+	}
+	return paths, processes
+}
+
+// psList returns the given values as a PowerShell array of quoted strings
+func psList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	return strings.Join(quoted, ",")
+}
+
+// hostSecurityCommands returns the PowerShell commands that create the firewall rules and add the Windows Defender
+// exclusions. The commands are idempotent, and the Windows Defender exclusions are skipped if Windows Defender is not
+// installed.
+func (wmcb *winNodeBootstrapper) hostSecurityCommands() []string {
+	var commands []string
+	for _, rule := range hostFirewallRules {
+		commands = append(commands, fmt.Sprintf("if (-not (Get-NetFirewallRule -DisplayName %s "+
+			"-ErrorAction SilentlyContinue)) { New-NetFirewallRule -DisplayName %s -Direction Inbound -Action Allow "+
+			"-Protocol %s -LocalPort %s -EdgeTraversalPolicy Allow }", rule.name, rule.name, rule.protocol, rule.port))
+	}
+	paths, processes := wmcb.defenderExclusions()
+	commands = append(commands, fmt.Sprintf("if (Get-Command Add-MpPreference -ErrorAction SilentlyContinue) "+
+		"{ Add-MpPreference -ExclusionPath %s -ExclusionProcess %s }", psList(paths), psList(processes)))
+	return commands
+}
+
+// ConfigureHostSecurity creates the Windows Firewall rules required by the node components and excludes the node
+// components from the Windows Defender real-time scanning. The PowerShell commands are returned, and are not run if
+// dryRun is set.
+func (wmcb *winNodeBootstrapper) ConfigureHostSecurity(dryRun bool) ([]string, error) {
+	commands := wmcb.hostSecurityCommands()
+	if dryRun {
+		return commands, nil
+	}
+	for _, command := range commands {
+		wmcb.log.V(1).Info("configuring host security", "command", command)
+		if _, err := runPowerShell(command); err != nil {
+			return nil, err
+		}
+	}
+	return commands, nil
+}
+
+// removeHostSecurity removes the firewall rules and the Windows Defender exclusions added by ConfigureHostSecurity
+func (wmcb *winNodeBootstrapper) removeHostSecurity() error {
+	for _, rule := range hostFirewallRules {
+		if err := removeFirewallRule(rule.name); err != nil {
+			return fmt.Errorf("failed to remove %s firewall rule: %v", rule.name, err)
+		}
+	}
+	paths, processes := wmcb.defenderExclusions()
+	_, err := runPowerShell(fmt.Sprintf("if (Get-Command Remove-MpPreference -ErrorAction SilentlyContinue) "+
+		"{ Remove-MpPreference -ExclusionPath %s -ExclusionProcess %s }", psList(paths), psList(processes)))
+	if err != nil {
+		return fmt.Errorf("failed to remove Windows Defender exclusions: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package bootstrapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// hybridOverlayExe is the name of the hybrid-overlay-node executable
+	hybridOverlayExe = "hybrid-overlay-node.exe"
+	// hybridOverlayNetworkName is the name of the HNS network created by the hybrid-overlay-node. The CNI config needs
+	// to refer to this network for the pods to be attached to it.
+	hybridOverlayNetworkName = "OVNKubernetesHybridOverlayNetwork"
+)
+
+// hybridOverlayOptions holds the hybrid-overlay-node specific information
+type hybridOverlayOptions struct {
+	// path is the location where hybrid-overlay-node.exe has been downloaded to
+	path string
+	// nodeName is the name of the node object of this Windows node
+	nodeName string
+}
+
+// EnableHybridOverlay configures Configure to install the OVN hybrid-overlay-node from hybridOverlayPath and run it as a
+// Windows service for the given node, and to attach the primary CNI config to the HNS network the hybrid-overlay-node
+// creates.
+// If nodeName is empty, the lower case hostname is used as that is the name the kubelet registers the node with.
+func (wmcb *winNodeBootstrapper) EnableHybridOverlay(hybridOverlayPath, nodeName string) error {
+	if wmcb.cni == nil {
+		return fmt.Errorf("hybrid-overlay can only be enabled along with CNI")
+	}
+	if _, err := wmcb.fileSystem().Stat(hybridOverlayPath); err != nil {
+		return fmt.Errorf("unable to find hybrid-overlay-node at %s: %v", hybridOverlayPath, err)
+	}
+	if nodeName == "" {
+		var err error
+		if nodeName, err = hostNodeName(); err != nil {
+			return err
+		}
+	}
+
+	wmcb.hybridOverlay = &hybridOverlayOptions{
+		path:     hybridOverlayPath,
+		nodeName: nodeName,
+	}
+	wmcb.cni.networkName = hybridOverlayNetworkName
+	return nil
+}
+
+// getHybridOverlayArgs returns the arguments the hybrid-overlay-node Windows service is run with
+func (wmcb *winNodeBootstrapper) getHybridOverlayArgs() []string {
+	return []string{
+		"--node", wmcb.hybridOverlay.nodeName,
+		"--k8s-kubeconfig", wmcb.kubeconfigPath,
+		"--windows-service",
+		"--logfile", filepath.Join(filepath.Dir(wmcb.logDir), kubeletDependentSvc, "hybrid-overlay.log"),
+	}
+}
+
+// ensureHybridOverlayService copies the hybrid-overlay-node to the install directory and creates the
+// hybrid-overlay-node Windows service if it is not already present, else updates the existing service. The service
+// depends on the kubelet service and is started along with it. This assumes that the kubelet service has been stopped.
+func (wmcb *winNodeBootstrapper) ensureHybridOverlayService() error {
+	hybridOverlayLogDir := filepath.Join(filepath.Dir(wmcb.logDir), kubeletDependentSvc)
+	if err := wmcb.fileSystem().MkdirAll(hybridOverlayLogDir, os.ModeDir); err != nil {
+		return fmt.Errorf("could not make %s directory: %v", hybridOverlayLogDir, err)
+	}
+
+	hybridOverlayService, err := wmcb.svcMgr.OpenService(kubeletDependentSvc)
+	if err != nil && !errors.Is(err, errServiceDoesNotExist) {
+		return fmt.Errorf("error getting existing hybrid-overlay-node service: %v", err)
+	}
+	if hybridOverlayService != nil {
+		defer hybridOverlayService.Close()
+		// The hybrid-overlay-node may not have been stopped along with the kubelet if it was started outside of WMCB
+		if err := stopService(hybridOverlayService); err != nil {
+			return fmt.Errorf("unable to stop hybrid-overlay-node service: %v", err)
+		}
+	}
+
+	hybridOverlayPath := filepath.Join(wmcb.installDir, hybridOverlayExe)
+	if err := wmcb.journal.fileWritten(hybridOverlayPath); err != nil {
+		return err
+	}
+	if err := copyFile(wmcb.fileSystem(), wmcb.hybridOverlay.path, hybridOverlayPath); err != nil {
+		return fmt.Errorf("error copying %s --> %s: %v", wmcb.hybridOverlay.path, hybridOverlayPath, err)
+	}
+
+	c := serviceConfig{
+		// StartAutomatic will start the service again if the node restarts
+		StartType: startAutomatic,
+		// The hybrid-overlay-node needs the kubelet to have registered the node
+		Dependencies: []string{KubeletServiceName},
+		Description:  "OpenShift OVN hybrid-overlay-node",
+	}
+	service, err := createOrUpdateService(wmcb.svcMgr, wmcb.journal, hybridOverlayService, kubeletDependentSvc,
+		hybridOverlayPath, c, wmcb.getHybridOverlayArgs())
+	if err != nil {
+		return err
+	}
+	if hybridOverlayService == nil {
+		defer service.Close()
+	}
+	if err := wmcb.serviceRecovery.apply(service); err != nil {
+		return fmt.Errorf("failed to set recovery actions for Windows service %s: %v", kubeletDependentSvc, err)
+	}
+	return nil
+}
+
+// writeCNIConfigWithNetwork writes the given CNI config to dest of the given file system with the name of the network
+// set to the given network name
+func writeCNIConfigWithNetwork(fs FileSystem, content []byte, dest, networkName string) error {
+	content, err := cniConfigWithNetwork(content, networkName)
+	if err != nil {
+		return err
+	}
+	return writeFile(fs, dest, content, 0644)
+}
+
+// cniConfigWithNetwork returns the given CNI config with the name of the network set to the given network name
+func cniConfigWithNetwork(content []byte, networkName string) ([]byte, error) {
+	var cniConfig map[string]interface{}
+	if err := json.Unmarshal(content, &cniConfig); err != nil {
+		return nil, fmt.Errorf("error parsing CNI config: %v", err)
+	}
+	cniConfig["name"] = networkName
+	content, err := json.MarshalIndent(cniConfig, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling CNI config: %v", err)
+	}
+	return content, nil
+}
@@ -0,0 +1,345 @@
+package bootstrapper
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ignitionRegistryCAs extracts the CA certificates of the image registries
+	ignitionRegistryCAs = "registry-cas"
+	// ignitionRegistriesConf extracts the registry mirrors
+	ignitionRegistriesConf = "registries-conf"
+	// ignitionChrony extracts the time servers
+	ignitionChrony = "chrony"
+
+	// registryCertsDir is the directory of the ignition file holding the CA certificates of the image registries, in
+	// a directory per registry host
+	registryCertsDir = "/etc/docker/certs.d/"
+	// registriesConfPath is the path of the containers registries configuration in the ignition file
+	registriesConfPath = "/etc/containers/registries.conf"
+	// chronyConfPath is the path of the chrony configuration in the ignition file
+	chronyConfPath = "/etc/chrony.conf"
+
+	// containerdRegistryConfigDirName is the directory in the containerd install dir holding the registry hosts
+	// configuration
+	containerdRegistryConfigDirName = "certs.d"
+)
+
+// ignitionFileMatchers are the allowlisted files of the ignition file that can be extracted in addition to the kubelet
+// files, by the name they are enabled with, and the function matching their paths in the ignition file
+var ignitionFileMatchers = map[string]func(path string) bool{
+	ignitionRegistryCAs: func(path string) bool {
+		host := strings.TrimPrefix(path, registryCertsDir)
+		return strings.HasPrefix(path, registryCertsDir) && strings.Count(host, "/") == 1 &&
+			strings.HasSuffix(path, ".crt")
+	},
+	ignitionRegistriesConf: func(path string) bool { return path == registriesConfPath },
+	ignitionChrony:         func(path string) bool { return path == chronyConfPath },
+}
+
+// tomlKeyValueRegex matches a key value pair of a TOML file
+var tomlKeyValueRegex = regexp.MustCompile(`^([A-Za-z0-9_-]+)\s*=\s*(.*)$`)
+
+// registryMirrors holds the mirrors of an image registry from the registries configuration
+type registryMirrors struct {
+	// host is the host of the registry
+	host string
+	// mirrors are the mirror locations, as a host optionally followed by a path
+	mirrors []string
+	// insecure indicates the mirrors that are not verified, by their location
+	insecure map[string]bool
+}
+
+// SetIgnitionFiles sets the allowlisted files that are extracted from the ignition file and translated for Windows, in
+// addition to the bootstrap kubeconfig, the kubelet CA, the cloud config and the trusted CA bundle:
+// - registry-cas: the CA certificates of the image registries in /etc/docker/certs.d, written to the certs.d directory
+// of the container runtime
+// - registries-conf: the registry mirrors of /etc/containers/registries.conf, written as containerd hosts.toml files.
+// The mirrors of repositories are only supported if their path ends with the repository, and the mirrors are only
+// supported with the containerd runtime.
+// - chrony: the time servers of /etc/chrony.conf, configured as the peers of the Windows Time service
+// This needs to be called before InitializeKubelet for the files to be extracted.
+func (wmcb *winNodeBootstrapper) SetIgnitionFiles(names []string) error {
+	for _, name := range names {
+		if _, ok := ignitionFileMatchers[name]; !ok {
+			var supported []string
+			for supportedName := range ignitionFileMatchers {
+				supported = append(supported, supportedName)
+			}
+			sort.Strings(supported)
+			return fmt.Errorf("unsupported ignition file %s, expected one of %s", name, strings.Join(supported, ", "))
+		}
+	}
+	wmcb.ignitionFiles = names
+	return nil
+}
+
+// isIgnitionFileEnabled returns true if the file at the given path of the ignition file needs to be extracted
+func (wmcb *winNodeBootstrapper) isIgnitionFileEnabled(path string) bool {
+	for _, name := range wmcb.ignitionFiles {
+		if ignitionFileMatchers[name](path) {
+			return true
+		}
+	}
+	return false
+}
+
+// containerdRegistryConfigDir returns the directory holding the hosts.toml and CA certificates of the registries
+func (wmcb *winNodeBootstrapper) containerdRegistryConfigDir() string {
+	return filepath.Join(wmcb.containerdInstallDir(), containerdRegistryConfigDirName)
+}
+
+// registryConfigDir returns the directory the container runtime reads the CA certificates of the registries from
+func (wmcb *winNodeBootstrapper) registryConfigDir() string {
+	if wmcb.containerRuntime == containerdRuntime {
+		return wmcb.containerdRegistryConfigDir()
+	}
+	return filepath.Join(os.Getenv("ProgramData"), "docker", "certs.d")
+}
+
+// registryHostDir returns the name of the directory of the given registry host. The colon of the port is removed, as
+// it is not allowed in Windows paths.
+func registryHostDir(host string) string {
+	return strings.ReplaceAll(host, ":", "")
+}
+
+// installIgnitionFiles translates the extracted files of the ignition file, given by their path in the ignition file,
+// and installs them on the Windows node
+func (wmcb *winNodeBootstrapper) installIgnitionFiles(files map[string][]byte) error {
+	// The trusted CA bundle is installed first, so that the registries and the proxy it is the CA of are trusted
+	if contents, ok := files[userCABundlePath]; ok && len(bytes.TrimSpace(contents)) > 0 {
+		if err := wmcb.installTrustedCABundle(contents); err != nil {
+			return fmt.Errorf("could not install trusted CA bundle: %w", err)
+		}
+	}
+	caPaths, err := wmcb.installRegistryCAs(files)
+	if err != nil {
+		return fmt.Errorf("could not install registry CA certificates: %w", err)
+	}
+	if contents, ok := files[registriesConfPath]; ok {
+		if err = wmcb.installRegistryMirrors(contents, caPaths); err != nil {
+			return fmt.Errorf("could not install registry mirrors: %w", err)
+		}
+	}
+	// The time servers are configured by configureTimeSync, unless others are given using SetTimeServers
+	if contents, ok := files[chronyConfPath]; ok {
+		wmcb.chronyTimeServers = parseTimeServers(contents)
+	}
+	return nil
+}
+
+// installRegistryCAs writes the registry CA certificates among the given files to the registry config dir of the
+// container runtime, and returns the paths they were written to by registry host
+func (wmcb *winNodeBootstrapper) installRegistryCAs(files map[string][]byte) (map[string]string, error) {
+	caPaths := make(map[string]string)
+	for path, contents := range files {
+		if !ignitionFileMatchers[ignitionRegistryCAs](path) {
+			continue
+		}
+		host := filepath.Dir(strings.TrimPrefix(path, registryCertsDir))
+		dir := filepath.Join(wmcb.registryConfigDir(), registryHostDir(host))
+		if err := wmcb.mkdirAll(dir); err != nil {
+			return nil, fmt.Errorf("could not make %s directory: %v", dir, err)
+		}
+		dest := filepath.Join(dir, filepath.Base(path))
+		if _, err := wmcb.writeFile(dest, contents); err != nil {
+			return nil, fmt.Errorf("could not write %s: %v", dest, err)
+		}
+		caPaths[host] = dest
+	}
+	return caPaths, nil
+}
+
+// parseTOMLString returns the given TOML basic or literal string
+func parseTOMLString(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") && len(value) > 1 {
+		return value[1 : len(value)-1], nil
+	}
+	return strconv.Unquote(value)
+}
+
+// parseRegistriesConf returns the mirrors of the registries of the given registries configuration, in the v2 format
+// the machine config operator renders the ImageContentSourcePolicies to. The mirrors of the repositories are turned
+// into mirrors of their registries where possible, and the ones that cannot be, as well as the wildcard prefixes, are
+// returned as unsupported.
+func parseRegistriesConf(contents []byte) ([]registryMirrors, []string, error) {
+	var registries []*registryMirrors
+	var locations []string
+	var registry *registryMirrors
+	inMirror := false
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case line == "[[registry]]":
+			registry = &registryMirrors{insecure: make(map[string]bool)}
+			registries = append(registries, registry)
+			locations = append(locations, "")
+			inMirror = false
+		case line == "[[registry.mirror]]":
+			if registry == nil {
+				return nil, nil, fmt.Errorf("mirror outside of a registry")
+			}
+			registry.mirrors = append(registry.mirrors, "")
+			inMirror = true
+		case strings.HasPrefix(line, "["):
+			// Other tables, like the v1 [registries.search] one, do not configure mirrors
+			registry = nil
+			inMirror = false
+		case registry != nil:
+			results := tomlKeyValueRegex.FindStringSubmatch(line)
+			if len(results) != 3 {
+				return nil, nil, fmt.Errorf("invalid line %q", line)
+			}
+			key, value := results[1], results[2]
+			switch {
+			case inMirror && key == "location":
+				location, err := parseTOMLString(value)
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid mirror location %s: %v", value, err)
+				}
+				registry.mirrors[len(registry.mirrors)-1] = location
+			case inMirror && key == "insecure":
+				registry.insecure[registry.mirrors[len(registry.mirrors)-1]] = value == "true"
+			case !inMirror && (key == "location" || key == "prefix"):
+				location, err := parseTOMLString(value)
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid registry %s %s: %v", key, value, err)
+				}
+				// The prefix takes precedence over the location
+				if key == "prefix" && location != "" || locations[len(locations)-1] == "" {
+					locations[len(locations)-1] = location
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// The mirrors are merged by registry host, as containerd configures the mirrors of a whole registry
+	var mirrored []*registryMirrors
+	byHost := make(map[string]*registryMirrors)
+	var unsupported []string
+	for i, registry := range registries {
+		if len(registry.mirrors) == 0 {
+			continue
+		}
+		host := strings.SplitN(locations[i], "/", 2)[0]
+		repository := strings.TrimPrefix(strings.TrimPrefix(locations[i], host), "/")
+		if host == "" || strings.Contains(host, "*") {
+			unsupported = append(unsupported, locations[i])
+			continue
+		}
+		for _, mirror := range registry.mirrors {
+			location, ok := registryMirrorLocation(repository, mirror)
+			if !ok {
+				unsupported = append(unsupported, locations[i]+" mirrored by "+mirror)
+				continue
+			}
+			merged, ok := byHost[host]
+			if !ok {
+				merged = &registryMirrors{host: host, insecure: make(map[string]bool)}
+				byHost[host] = merged
+				mirrored = append(mirrored, merged)
+			}
+			if _, ok = merged.insecure[location]; !ok {
+				merged.mirrors = append(merged.mirrors, location)
+			}
+			merged.insecure[location] = merged.insecure[location] || registry.insecure[mirror]
+		}
+	}
+	var result []registryMirrors
+	for _, registry := range mirrored {
+		result = append(result, *registry)
+	}
+	return result, unsupported, nil
+}
+
+// registryMirrorLocation returns the location mirroring the whole registry of the given repository, given a location
+// mirroring the repository. containerd appends the repository to the location of the mirrors, so a repository can only
+// be mirrored by a location ending with the repository, like the ones the ImageContentSourcePolicies mirroring
+// registry.redhat.io/ubi8 to mirror.example.com/redhat/ubi8 generate. An empty repository mirrors the whole registry.
+func registryMirrorLocation(repository, mirror string) (string, bool) {
+	if repository == "" {
+		return mirror, true
+	}
+	mirrorHost := strings.SplitN(mirror, "/", 2)[0]
+	mirrorPath := strings.TrimPrefix(strings.TrimPrefix(mirror, mirrorHost), "/")
+	if mirrorPath == repository {
+		return mirrorHost, true
+	}
+	if strings.HasSuffix(mirrorPath, "/"+repository) {
+		return mirrorHost + "/" + strings.TrimSuffix(mirrorPath, "/"+repository), true
+	}
+	return "", false
+}
+
+// containerdHostsConfig returns the containerd hosts.toml of the given registry, with the CA certificates of the
+// registry and its mirrors given by registry host
+func containerdHostsConfig(registry registryMirrors, caPaths map[string]string) []byte {
+	var config bytes.Buffer
+	fmt.Fprintf(&config, "server = \"https://%s\"\n", registry.host)
+	if caPath, ok := caPaths[registry.host]; ok {
+		fmt.Fprintf(&config, "ca = '%s'\n", caPath)
+	}
+	for _, mirror := range registry.mirrors {
+		host := strings.SplitN(mirror, "/", 2)[0]
+		url := "https://" + host
+		if path := strings.TrimPrefix(mirror, host); path != "" {
+			url += "/v2" + path
+		}
+		fmt.Fprintf(&config, "\n[host.\"%s\"]\n  capabilities = [\"pull\", \"resolve\"]\n", url)
+		if url != "https://"+host {
+			config.WriteString("  override_path = true\n")
+		}
+		if caPath, ok := caPaths[host]; ok {
+			fmt.Fprintf(&config, "  ca = '%s'\n", caPath)
+		}
+		if registry.insecure[mirror] {
+			config.WriteString("  skip_verify = true\n")
+		}
+	}
+	return config.Bytes()
+}
+
+// installRegistryMirrors writes the containerd hosts.toml of the registries with mirrors in the given registries
+// configuration
+func (wmcb *winNodeBootstrapper) installRegistryMirrors(contents []byte, caPaths map[string]string) error {
+	registries, unsupported, err := parseRegistriesConf(contents)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %v", registriesConfPath, err)
+	}
+	if len(unsupported) > 0 {
+		wmcb.log.Info("ignoring the mirrors that cannot be configured, the path of the mirror of a repository "+
+			"needs to end with the repository", "mirrors", unsupported)
+	}
+	if len(registries) == 0 {
+		return nil
+	}
+	if wmcb.containerRuntime != containerdRuntime {
+		wmcb.log.Info("ignoring registry mirrors, they are only supported with the containerd runtime")
+		return nil
+	}
+	for _, registry := range registries {
+		dir := filepath.Join(wmcb.containerdRegistryConfigDir(), registryHostDir(registry.host))
+		if err = wmcb.mkdirAll(dir); err != nil {
+			return fmt.Errorf("could not make %s directory: %v", dir, err)
+		}
+		dest := filepath.Join(dir, "hosts.toml")
+		if _, err = wmcb.writeFile(dest, containerdHostsConfig(registry, caPaths)); err != nil {
+			return fmt.Errorf("could not write %s: %v", dest, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package bootstrapper
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/vincent-petithory/dataurl"
+
+	"github.com/openshift/windows-machine-config-bootstrapper/pkg/ignition"
+)
+
+// ignitionEndpoint is the machine config server endpoint the worker ignition is fetched from
+type ignitionEndpoint struct {
+	// url is the HTTPS URL of the worker ignition, for example https://api-int.<cluster>:22623/config/worker
+	url string
+	// caBundle is the PEM encoded CA bundle the machine config server serving certificate is verified with
+	caBundle []byte
+}
+
+// SetIgnitionURL sets the machine config server URL the worker ignition is fetched from, instead of reading it from
+// the ignition file. caBundlePath is the CA bundle the machine config server serving certificate is verified with,
+// which is the root CA of the cluster. This needs to be called before InitializeKubelet to take effect.
+func (wmcb *winNodeBootstrapper) SetIgnitionURL(ignitionURL, caBundlePath string) error {
+	if _, err := parseHTTPSURL(ignitionURL); err != nil {
+		return fmt.Errorf("invalid ignition URL: %v", err)
+	}
+	caBundle, err := readCABundle(wmcb.fileSystem(), caBundlePath)
+	if err != nil {
+		return fmt.Errorf("invalid ignition CA bundle: %v", err)
+	}
+	wmcb.ignitionEndpoint = &ignitionEndpoint{url: ignitionURL, caBundle: caBundle}
+	return nil
+}
+
+// readIgnition returns the contents of the worker ignition. It is fetched from the machine config server if an
+// ignition URL has been set, or if the ignition file is the stub ignition the worker machines of the cluster are
+// created with, in which case the machine config server URL and CA are taken from the stub. Otherwise the contents of
+// the ignition file are returned.
+func (wmcb *winNodeBootstrapper) readIgnition(ctx context.Context) ([]byte, error) {
+	if wmcb.ignitionEndpoint != nil {
+		return fetchIgnition(ctx, wmcb.ignitionEndpoint)
+	}
+	ignitionFileContents, err := readFile(wmcb.fileSystem(), wmcb.ignitionFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read ignition file: %s", err)
+	}
+	endpoint, err := stubIgnitionEndpoint(ignitionFileContents)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse ignition file: %s", err)
+	}
+	if endpoint == nil {
+		return ignitionFileContents, nil
+	}
+	return fetchIgnition(ctx, endpoint)
+}
+
+// stubIgnitionEndpoint returns the machine config server endpoint referenced by the given stub ignition, or nil if the
+// ignition is not a stub. A stub ignition does not describe any files or units and merges in a single remote config.
+func stubIgnitionEndpoint(ignitionFileContents []byte) (*ignitionEndpoint, error) {
+	configuration, err := parseIgnitionConfig(ignitionFileContents)
+	if err != nil {
+		return nil, err
+	}
+	if len(configuration.Storage.Files) != 0 || len(configuration.Systemd.Units) != 0 ||
+		len(configuration.Ignition.Config.Merge) != 1 || configuration.Ignition.Config.Merge[0].Source == nil {
+		return nil, nil
+	}
+
+	endpoint := &ignitionEndpoint{url: *configuration.Ignition.Config.Merge[0].Source}
+	if _, err = parseHTTPSURL(endpoint.url); err != nil {
+		return nil, fmt.Errorf("invalid ignition URL in stub ignition: %v", err)
+	}
+	for _, ca := range configuration.Ignition.Security.TLS.CertificateAuthorities {
+		if ca.Source == nil {
+			continue
+		}
+		// The machine config server CA is embedded in the stub ignition as a data URL
+		caPEM, err := dataurl.DecodeString(*ca.Source)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode machine config server CA in stub ignition: %v", err)
+		}
+		endpoint.caBundle = append(endpoint.caBundle, caPEM.Data...)
+	}
+	return endpoint, nil
+}
+
+// fetchIgnition returns the worker ignition served by the given machine config server endpoint. The proxy is taken
+// from the HTTPS_PROXY and NO_PROXY environment variables.
+func fetchIgnition(ctx context.Context, endpoint *ignitionEndpoint) ([]byte, error) {
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(endpoint.caBundle) {
+		return nil, fmt.Errorf("no machine config server CA to verify %s with", endpoint.url)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = fetchResponseTimeout
+	transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+	client := &http.Client{Transport: transport}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ignition.AcceptHeader)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, newError(ErrTransient, fmt.Errorf("could not fetch ignition: %w", err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("could not fetch ignition from %s: %s", endpoint.url, resp.Status)
+		if isTransientStatus(resp.StatusCode) {
+			return nil, newError(ErrTransient, err)
+		}
+		return nil, err
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,315 @@
+package bootstrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	// journalFileName is the name of the file in the install directory that records the changes made by the bootstrap
+	journalFileName = "bootstrap-journal.json"
+	// journalBackupDirName is the name of the directory in the install directory that holds the contents the files had
+	// before the bootstrap changed them
+	journalBackupDirName = "bootstrap-journal"
+)
+
+// journalEntryType is the type of a change recorded in the journal
+type journalEntryType string
+
+const (
+	// fileWritten records that a file was written. The file is restored from its backup when rolled back, or removed
+	// if it did not exist.
+	fileWritten journalEntryType = "FileWritten"
+	// fileRemoved records that a file was removed. The file is restored from its backup when rolled back.
+	fileRemoved journalEntryType = "FileRemoved"
+	// serviceCreated records that a Windows service was created. The service is removed when rolled back.
+	serviceCreated journalEntryType = "ServiceCreated"
+	// serviceUpdated records that the config of a Windows service was updated. The previous config is restored when
+	// rolled back.
+	serviceUpdated journalEntryType = "ServiceUpdated"
+	// serviceEnvironmentSet records that the environment of a Windows service was set. The previous environment is
+	// restored when rolled back.
+	serviceEnvironmentSet journalEntryType = "ServiceEnvironmentSet"
+)
+
+// journalEntry is a change made by the bootstrap
+type journalEntry struct {
+	// Type is the type of the change
+	Type journalEntryType `json:"type"`
+	// Path is the path of the file that was written or removed
+	Path string `json:"path,omitempty"`
+	// Backup is the path of the copy of the file before it was written or removed, empty if it did not exist
+	Backup string `json:"backup,omitempty"`
+	// Service is the name of the Windows service that was created or updated
+	Service string `json:"service,omitempty"`
+	// Config is the config of the Windows service before it was updated
+	Config *serviceConfig `json:"config,omitempty"`
+	// Environment is the environment of the Windows service before it was set
+	Environment []string `json:"environment,omitempty"`
+}
+
+// journal records the changes made to the Windows node by InitializeKubelet, Configure and ConfigureKubeProxy, so that
+// they can be rolled back if the bootstrap fails. The journal is saved to the install directory after every change, so
+// that it survives a failure of WMCB itself. A nil journal records nothing.
+type journal struct {
+	// installDir is the install directory the journal is saved to
+	installDir string
+	// fs is the file system the journal, the backups and the recorded files are on. The file system of the host is
+	// used if it is nil.
+	fs FileSystem
+	// Entries are the changes in the order they were made
+	Entries []journalEntry `json:"entries"`
+}
+
+// journalFilePath returns the path of the journal file in the given install directory
+func journalFilePath(installDir string) string {
+	return filepath.Join(installDir, journalFileName)
+}
+
+// newJournal removes the journal of a previous bootstrap from the given install directory of the given file system and
+// returns an empty one
+func newJournal(fs FileSystem, installDir string) (*journal, error) {
+	j := &journal{installDir: installDir, fs: fs}
+	if err := j.remove(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// loadJournal returns the journal saved in the given install directory of the given file system, or an empty one if
+// there is none
+func loadJournal(fs FileSystem, installDir string) (*journal, error) {
+	j := &journal{installDir: installDir, fs: fs}
+	content, err := readFile(j.fileSystem(), journalFilePath(installDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return j, nil
+		}
+		return nil, fmt.Errorf("error reading bootstrap journal: %w", err)
+	}
+	if err = json.Unmarshal(content, j); err != nil {
+		return nil, fmt.Errorf("error parsing bootstrap journal: %w", err)
+	}
+	return j, nil
+}
+
+// fileSystem returns the file system the journal is on
+func (j *journal) fileSystem() FileSystem {
+	return orOSFileSystem(j.fs)
+}
+
+// save writes the journal to the install directory
+func (j *journal) save() error {
+	content, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling bootstrap journal: %w", err)
+	}
+	if err = j.fileSystem().MkdirAll(j.installDir, os.ModeDir); err != nil {
+		return fmt.Errorf("could not make install directory: %w", err)
+	}
+	if err = writeFile(j.fileSystem(), journalFilePath(j.installDir), content, 0644); err != nil {
+		return fmt.Errorf("error writing bootstrap journal: %w", err)
+	}
+	return nil
+}
+
+// remove removes the journal and the file backups from the install directory
+func (j *journal) remove() error {
+	if err := j.fileSystem().RemoveAll(filepath.Join(j.installDir, journalBackupDirName)); err != nil {
+		return fmt.Errorf("error removing bootstrap journal backups: %w", err)
+	}
+	if err := j.fileSystem().Remove(journalFilePath(j.installDir)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing bootstrap journal: %w", err)
+	}
+	return nil
+}
+
+// add appends the given entry to the journal and saves it
+func (j *journal) add(entry journalEntry) error {
+	j.Entries = append(j.Entries, entry)
+	return j.save()
+}
+
+// recorded returns true if a change of the given file or service has already been recorded, in which case the state
+// it is rolled back to has already been saved
+func (j *journal) recorded(path, service string) bool {
+	for _, entry := range j.Entries {
+		if (path != "" && strings.EqualFold(entry.Path, path)) || (service != "" && entry.Service == service) {
+			return true
+		}
+	}
+	return false
+}
+
+// backup copies the given file to the backup directory and returns the path of the copy, or an empty path if the file
+// does not exist
+func (j *journal) backup(path string) (string, error) {
+	if _, err := j.fileSystem().Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("error accessing %s: %w", path, err)
+	}
+	backupDir := filepath.Join(j.installDir, journalBackupDirName)
+	if err := j.fileSystem().MkdirAll(backupDir, os.ModeDir); err != nil {
+		return "", fmt.Errorf("could not make %s directory: %w", backupDir, err)
+	}
+	// The index keeps the backups of files with the same name apart
+	backup := filepath.Join(backupDir, fmt.Sprintf("%d-%s", len(j.Entries), filepath.Base(path)))
+	if err := copyFile(j.fileSystem(), path, backup); err != nil {
+		return "", fmt.Errorf("error backing up %s: %w", path, err)
+	}
+	return backup, nil
+}
+
+// recordFile records that the given file is about to be written or removed, backing up its current contents. Nothing
+// is recorded if the file has already been recorded, as its contents before the bootstrap have already been saved.
+func (j *journal) recordFile(entryType journalEntryType, path string) error {
+	if j == nil || j.recorded(path, "") {
+		return nil
+	}
+	backup, err := j.backup(path)
+	if err != nil {
+		return err
+	}
+	return j.add(journalEntry{Type: entryType, Path: path, Backup: backup})
+}
+
+// fileWritten records that the given file is about to be written
+func (j *journal) fileWritten(path string) error {
+	return j.recordFile(fileWritten, path)
+}
+
+// fileRemoved records that the given file is about to be removed
+func (j *journal) fileRemoved(path string) error {
+	return j.recordFile(fileRemoved, path)
+}
+
+// serviceCreated records that the Windows service with the given name has been created
+func (j *journal) serviceCreated(name string) error {
+	if j == nil || j.recorded("", name) {
+		return nil
+	}
+	return j.add(journalEntry{Type: serviceCreated, Service: name})
+}
+
+// serviceUpdated records that the Windows service with the given name is about to be updated from the given config.
+// Nothing is recorded if the service has already been recorded, as it is rolled back to its state before the
+// bootstrap.
+func (j *journal) serviceUpdated(name string, config serviceConfig) error {
+	if j == nil || j.recorded("", name) {
+		return nil
+	}
+	return j.add(journalEntry{Type: serviceUpdated, Service: name, Config: &config})
+}
+
+// serviceEnvironmentSet records that the environment of the Windows service with the given name is about to be changed
+// from the given one
+func (j *journal) serviceEnvironmentSet(name string, env []string) error {
+	if j == nil {
+		return nil
+	}
+	for _, entry := range j.Entries {
+		if entry.Type == serviceEnvironmentSet && entry.Service == name {
+			return nil
+		}
+	}
+	return j.add(journalEntry{Type: serviceEnvironmentSet, Service: name, Environment: env})
+}
+
+// Rollback reverts the changes recorded in the journal of the install directory by InitializeKubelet, Configure and
+// ConfigureKubeProxy since the kubelet was last initialized, in reverse order: the Windows services created are
+// removed, the ones updated get their previous config and environment back, and the files written or removed are
+// restored, or removed if they did not exist. This leaves the Windows node as it was before a failed bootstrap. The
+// changes that could not be rolled back are kept in the journal, so that Rollback can be retried, and are returned as
+// an error. Nothing is done if no change has been recorded. The WinHTTP proxy and the HNS networks are not rolled
+// back.
+func (wmcb *winNodeBootstrapper) Rollback() error {
+	j, err := loadJournal(wmcb.fileSystem(), wmcb.installDir)
+	if err != nil {
+		return err
+	}
+	if len(j.Entries) == 0 {
+		wmcb.log.Info("no bootstrap changes to roll back")
+		return nil
+	}
+	wmcb.log.Info("rolling back bootstrap", "changes", len(j.Entries))
+
+	var failed []journalEntry
+	var errs []error
+	for i := len(j.Entries) - 1; i >= 0; i-- {
+		entry := j.Entries[i]
+		if err := wmcb.rollbackEntry(entry); err != nil {
+			errs = append(errs, err)
+			failed = append([]journalEntry{entry}, failed...)
+			continue
+		}
+		wmcb.log.V(1).Info("rolled back", "type", entry.Type, "path", entry.Path, "service", entry.Service)
+	}
+	if len(errs) > 0 {
+		j.Entries = failed
+		if err := j.save(); err != nil {
+			errs = append(errs, err)
+		}
+		return fmt.Errorf("error rolling back bootstrap: %v", utilerrors.NewAggregate(errs))
+	}
+	if err := j.remove(); err != nil {
+		return err
+	}
+	wmcb.log.Info("bootstrap rolled back")
+	return nil
+}
+
+// rollbackEntry reverts the given change
+func (wmcb *winNodeBootstrapper) rollbackEntry(entry journalEntry) error {
+	switch entry.Type {
+	case fileWritten, fileRemoved:
+		if entry.Backup == "" {
+			if err := wmcb.fileSystem().Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("error removing %s: %w", entry.Path, err)
+			}
+			return nil
+		}
+		if err := copyFile(wmcb.fileSystem(), entry.Backup, entry.Path); err != nil {
+			return fmt.Errorf("error restoring %s: %w", entry.Path, err)
+		}
+	case serviceCreated:
+		if entry.Service == KubeletServiceName && wmcb.kubeletSVC != nil {
+			// The kubelet service is deleted once the handle held by the bootstrapper is closed
+			if err := wmcb.kubeletSVC.stop(); err != nil {
+				return fmt.Errorf("error stopping %s service: %w", entry.Service, err)
+			}
+		}
+		if err := removeService(wmcb.svcMgr, entry.Service); err != nil {
+			return fmt.Errorf("error removing %s service: %w", entry.Service, err)
+		}
+	case serviceUpdated:
+		service, err := wmcb.svcMgr.OpenService(entry.Service)
+		if err != nil {
+			return fmt.Errorf("error opening %s service: %w", entry.Service, err)
+		}
+		defer service.Close()
+		if err = stopService(service); err != nil {
+			return fmt.Errorf("error stopping %s service: %w", entry.Service, err)
+		}
+		if err = service.UpdateConfig(*entry.Config); err != nil {
+			return fmt.Errorf("error restoring %s service config: %w", entry.Service, err)
+		}
+	case serviceEnvironmentSet:
+		// The environment is gone along with the service if it has been removed
+		if !wmcb.serviceExists(entry.Service) {
+			return nil
+		}
+		if _, err := setServiceEnvironment(nil, entry.Service, entry.Environment); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown bootstrap journal entry type %s", entry.Type)
+	}
+	return nil
+}
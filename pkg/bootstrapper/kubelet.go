@@ -1,11 +1,12 @@
 package bootstrapper
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
-	"golang.org/x/sys/windows/svc"
-	"golang.org/x/sys/windows/svc/mgr"
 	"k8s.io/apimachinery/pkg/util/wait"
 )
 
@@ -19,13 +20,13 @@ const (
 // kubeletService struct contains the kubelet specific service information
 type kubeletService struct {
 	// obj is a pointer to the Windows service object
-	obj *mgr.Service
+	obj service
 	// dependents contains a list of services dependent on the current service
-	dependents []*mgr.Service
+	dependents []service
 }
 
 // newKubeletService creates and returns a new kubeletService object
-func newKubeletService(ksvc *mgr.Service, dependents []*mgr.Service) (*kubeletService, error) {
+func newKubeletService(ksvc service, dependents []service) (*kubeletService, error) {
 	if ksvc == nil {
 		return nil, fmt.Errorf("service object should not be nil")
 	}
@@ -36,10 +37,10 @@ func newKubeletService(ksvc *mgr.Service, dependents []*mgr.Service) (*kubeletSe
 }
 
 // config retrieves service config from service object Config()
-func (k *kubeletService) config() (mgr.Config, error) {
+func (k *kubeletService) config() (serviceConfig, error) {
 	config, err := k.obj.Config()
 	if err != nil {
-		return mgr.Config{}, err
+		return serviceConfig{}, err
 	}
 	return config, nil
 }
@@ -67,14 +68,14 @@ func (k *kubeletService) start() error {
 	for _, dependent := range k.dependents {
 		err := startService(dependent)
 		if err != nil {
-			return fmt.Errorf("failed to start dependent service %s", dependent.Name)
+			return fmt.Errorf("failed to start dependent service %s", dependent.Name())
 		}
 	}
 	return nil
 }
 
 // control sends a signal to the service and waits until it changes state in response to the signal
-func (k *kubeletService) control(cmd svc.Cmd, desiredState svc.State) error {
+func (k *kubeletService) control(cmd serviceCmd, desiredState serviceState) error {
 	status, err := k.obj.Control(cmd)
 	if err != nil {
 		return err
@@ -108,25 +109,31 @@ func (k *kubeletService) stop() error {
 	if len(k.dependents) != 0 {
 		for _, dependent := range k.dependents {
 			if err := stopService(dependent); err != nil {
-				return fmt.Errorf("failed to stop dependent service %s", dependent.Name)
+				return fmt.Errorf("failed to stop dependent service %s", dependent.Name())
 			}
 		}
 	}
 
-	if err := k.control(svc.Stop, svc.Stopped); err != nil {
+	if err := k.control(serviceStop, serviceStopped); err != nil {
 		return fmt.Errorf("unable to stop Windows Service %s", KubeletServiceName)
 	}
 
 	return nil
 }
 
-// refresh updates the kubelet service with the given config and restarts the service
-func (k *kubeletService) refresh(config mgr.Config) error {
+// updateConfig updates the kubelet service with the given config. The service needs to be stopped before calling this.
+func (k *kubeletService) updateConfig(config serviceConfig) error {
+	return k.obj.UpdateConfig(config)
+}
+
+// refresh updates the kubelet service with the given config and restarts the service. Waiting for the service to
+// run is aborted if the context is done.
+func (k *kubeletService) refresh(ctx context.Context, config serviceConfig) error {
 	if err := k.stop(); err != nil {
 		return fmt.Errorf("error stopping kubelet service: %v", err)
 	}
 
-	if err := k.obj.UpdateConfig(config); err != nil {
+	if err := k.updateConfig(config); err != nil {
 		return fmt.Errorf("error updating kubelet service: %v", err)
 	}
 
@@ -134,7 +141,7 @@ func (k *kubeletService) refresh(config mgr.Config) error {
 		return fmt.Errorf("error starting kubelet service: %v", err)
 	}
 	// Wait for service to go to Running state
-	err := wait.Poll(svcPollInterval, svcRunTimeout, func() (done bool, err error) {
+	err := pollWithContext(ctx, svcPollInterval, svcRunTimeout, func() (done bool, err error) {
 		isKubeletRunning, err := k.isRunning()
 		if err != nil {
 			return false, nil
@@ -148,18 +155,26 @@ func (k *kubeletService) refresh(config mgr.Config) error {
 	return nil
 }
 
+// pollWithContext polls the condition at the given interval until it returns true, the timeout is reached or the
+// context is done
+func pollWithContext(ctx context.Context, interval, timeout time.Duration, condition wait.ConditionFunc) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return wait.PollUntil(interval, condition, ctx.Done())
+}
+
 // isRunning returns true if the kubelet service is running
 func (k *kubeletService) isRunning() (bool, error) {
 	status, err := k.obj.Query()
 	if err != nil {
 		return false, err
 	}
-	return status.State == svc.Running, nil
+	return status.State == serviceRunning, nil
 }
 
 // disconnect removes all connections to the Windows service svcMgr api, and allows services to be deleted
 func (k *kubeletService) disconnect() error {
-	if k.obj == nil {
+	if k == nil || k.obj == nil {
 		return nil
 	}
 	err := k.obj.Close()
@@ -170,17 +185,11 @@ func (k *kubeletService) disconnect() error {
 }
 
 // setRecoveryActions sets the recovery actions for service on a failure
-func (k *kubeletService) setRecoveryActions() error {
+func (k *kubeletService) setRecoveryActions(recovery serviceRecovery) error {
 	if k.obj == nil {
 		return fmt.Errorf("kubelet service object should not be nil")
 	}
-	err := k.obj.SetRecoveryActions([]mgr.RecoveryAction{
-		{Type: mgr.ServiceRestart, Delay: 5},
-	}, 600)
-	if err != nil {
-		return err
-	}
-	return nil
+	return recovery.apply(k.obj)
 }
 
 // stopAndRemove stops and removes the kubelet service
@@ -195,7 +204,7 @@ func (k *kubeletService) stopAndRemove() error {
 }
 
 // startService is a helper to start a given service
-func startService(serviceObj *mgr.Service) error {
+func startService(serviceObj service) error {
 	if serviceObj == nil {
 		return fmt.Errorf("service object should not be nil")
 	}
@@ -213,7 +222,7 @@ func startService(serviceObj *mgr.Service) error {
 }
 
 // controlService is a helper to send control signal to a given service
-func controlService(serviceObj *mgr.Service, cmd svc.Cmd, desiredState svc.State) error {
+func controlService(serviceObj service, cmd serviceCmd, desiredState serviceState) error {
 	if serviceObj == nil {
 		return fmt.Errorf("service object should not be nil")
 	}
@@ -238,7 +247,7 @@ func controlService(serviceObj *mgr.Service, cmd svc.Cmd, desiredState svc.State
 }
 
 // stopService is a helper to stop a given service
-func stopService(serviceObj *mgr.Service) error {
+func stopService(serviceObj service) error {
 	if serviceObj == nil {
 		return fmt.Errorf("service object should not be nil")
 	}
@@ -247,16 +256,16 @@ func stopService(serviceObj *mgr.Service) error {
 		return fmt.Errorf("unable to check if service is running: %v", err)
 	}
 	if isServiceRunning {
-		err := controlService(serviceObj, svc.Stop, svc.Stopped)
+		err := controlService(serviceObj, serviceStop, serviceStopped)
 		if err != nil {
-			return fmt.Errorf("unable to stop %s service", serviceObj.Name)
+			return fmt.Errorf("unable to stop %s service", serviceObj.Name())
 		}
 	}
 	return nil
 }
 
 // isServiceRunning returns true if the given service is running
-func isServiceRunning(serviceObj *mgr.Service) (bool, error) {
+func isServiceRunning(serviceObj service) (bool, error) {
 	if serviceObj == nil {
 		return false, fmt.Errorf("service object should not be nil")
 	}
@@ -264,5 +273,60 @@ func isServiceRunning(serviceObj *mgr.Service) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	return status.State == svc.Running, nil
+	return status.State == serviceRunning, nil
+}
+
+// createOrUpdateService creates the service with the given name, executable, config and arguments if existingService
+// is nil, else updates the config of existingService with them, and records the change in the given journal. The
+// caller is responsible for closing the returned service object if it was newly created.
+func createOrUpdateService(svcMgr serviceManager, j *journal, existingService service, name, exePath string,
+	c serviceConfig, args []string) (service, error) {
+	if existingService == nil {
+		service, err := svcMgr.CreateService(name, exePath, c, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s service: %w", name, err)
+		}
+		if err = j.serviceCreated(name); err != nil {
+			service.Close()
+			return nil, err
+		}
+		return service, nil
+	}
+
+	config, err := existingService.Config()
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s service config: %w", name, err)
+	}
+	if err = j.serviceUpdated(name, config); err != nil {
+		return nil, err
+	}
+	config.BinaryPathName = strings.TrimSpace(exePath + " " + strings.Join(args, " "))
+	config.Dependencies = c.Dependencies
+	config.StartType = c.StartType
+	config.Description = c.Description
+	if c.ServiceStartName != "" {
+		config.ServiceStartName = c.ServiceStartName
+		config.SidType = c.SidType
+	}
+	if err := existingService.UpdateConfig(config); err != nil {
+		return nil, fmt.Errorf("error updating %s service: %w", name, err)
+	}
+	return existingService, nil
+}
+
+// removeService stops and removes the service with the given name if it is present
+func removeService(svcMgr serviceManager, name string) error {
+	service, err := svcMgr.OpenService(name)
+	if err != nil {
+		// Do not return error if the service is not installed.
+		if !errors.Is(err, errServiceDoesNotExist) {
+			return fmt.Errorf("error getting existing %s service: %v", name, err)
+		}
+		return nil
+	}
+	defer service.Close()
+	if err := stopService(service); err != nil {
+		return err
+	}
+	return service.Delete()
 }
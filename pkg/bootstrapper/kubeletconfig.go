@@ -0,0 +1,71 @@
+package bootstrapper
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"sigs.k8s.io/yaml"
+)
+
+// SetKubeletConfigOverrides sets the file with the KubeletConfiguration fields that override the kubelet configuration
+// WMCB generates, for example evictionHard, maxPods or systemReserved. The file can be in JSON or YAML format. Objects
+// are merged field by field with the generated configuration, while any other value replaces the generated one. This
+// needs to be called before InitializeKubelet for the overrides to take effect.
+func (wmcb *winNodeBootstrapper) SetKubeletConfigOverrides(path string) error {
+	content, err := readFile(wmcb.fileSystem(), path)
+	if err != nil {
+		return fmt.Errorf("error reading kubelet config overrides: %v", err)
+	}
+	overrides, err := parseKubeletConfigOverrides(content)
+	if err != nil {
+		return fmt.Errorf("error parsing kubelet config overrides %s: %v", path, err)
+	}
+	wmcb.kubeletConfigOverrides = overrides
+	return nil
+}
+
+// parseKubeletConfigOverrides parses the given JSON or YAML kubelet config overrides
+func parseKubeletConfigOverrides(content []byte) (map[string]interface{}, error) {
+	jsonContent, err := yaml.YAMLToJSON(content)
+	if err != nil {
+		return nil, err
+	}
+	var overrides map[string]interface{}
+	if err = json.Unmarshal(jsonContent, &overrides); err != nil {
+		return nil, fmt.Errorf("expected an object of KubeletConfiguration fields: %v", err)
+	}
+	// The overrides apply to the KubeletConfiguration generated by WMCB, which cannot be changed to another kind
+	for _, field := range []string{"kind", "apiVersion"} {
+		if _, ok := overrides[field]; ok {
+			return nil, fmt.Errorf("%s cannot be overridden", field)
+		}
+	}
+	return overrides, nil
+}
+
+// applyKubeletConfigOverrides returns the given kubelet configuration with the user provided overrides merged into it
+func (wmcb *winNodeBootstrapper) applyKubeletConfigOverrides(kubeletConf []byte) ([]byte, error) {
+	if len(wmcb.kubeletConfigOverrides) == 0 {
+		return kubeletConf, nil
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(kubeletConf, &config); err != nil {
+		return nil, fmt.Errorf("error parsing kubelet configuration: %v", err)
+	}
+	mergeConfig(config, wmcb.kubeletConfigOverrides)
+	return json.Marshal(config)
+}
+
+// mergeConfig merges the overrides into the given config. Objects present in both are merged recursively, while the
+// other overrides replace the values in the config.
+func mergeConfig(config, overrides map[string]interface{}) {
+	for key, override := range overrides {
+		overrideObject, overrideIsObject := override.(map[string]interface{})
+		configObject, configIsObject := config[key].(map[string]interface{})
+		if overrideIsObject && configIsObject {
+			mergeConfig(configObject, overrideObject)
+			continue
+		}
+		config[key] = override
+	}
+}
@@ -0,0 +1,150 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// kubeletUnitFileName is the name of the file in the install directory the kubelet unit is written to
+const kubeletUnitFileName = "kubelet-unit.yaml"
+
+// KubeletUnit is the Windows service the kubelet is run as, as registered in the Windows service database. It is
+// written to the install directory every time the kubelet service is created or updated, so that the command line
+// generated by WMCB can be reviewed and diffed across versions.
+type KubeletUnit struct {
+	// Name is the name of the Windows service
+	Name string `json:"name"`
+	// Description is the description of the Windows service
+	Description string `json:"description,omitempty"`
+	// Executable is the path of the kubelet.exe
+	Executable string `json:"executable"`
+	// Args are the arguments of the kubelet, sorted so that the units of different versions can be diffed, as the
+	// command line is not generated in a stable order once it has been updated for CNI
+	Args []string `json:"args"`
+	// Command is the command line the Windows service is registered with
+	Command string `json:"command"`
+	// Environment are the environment variables of the Windows service in the key=value format
+	Environment []string `json:"environment,omitempty"`
+	// Dependencies are the Windows services the kubelet service depends on
+	Dependencies []string `json:"dependencies,omitempty"`
+	// StartType is the start type of the Windows service, one of Automatic, Manual or Disabled
+	StartType string `json:"startType"`
+	// Account is the account the Windows service is run as, or empty for LocalSystem
+	Account string `json:"account,omitempty"`
+}
+
+// startTypeName returns the name of the given start type of a Windows service
+func startTypeName(startType uint32) string {
+	switch startType {
+	case startAutomatic:
+		return "Automatic"
+	case startManual:
+		return "Manual"
+	case startDisabled:
+		return "Disabled"
+	}
+	return fmt.Sprintf("%d", startType)
+}
+
+// newKubeletUnit returns the unit of the kubelet service registered with the given config and environment, given the
+// path of the kubelet.exe
+func newKubeletUnit(config serviceConfig, exePath string, env []string) *KubeletUnit {
+	args := strings.Fields(strings.TrimPrefix(config.BinaryPathName, exePath))
+	sort.Strings(args)
+	return &KubeletUnit{
+		Name:         KubeletServiceName,
+		Description:  config.Description,
+		Executable:   exePath,
+		Args:         args,
+		Command:      config.BinaryPathName,
+		Environment:  env,
+		Dependencies: config.Dependencies,
+		StartType:    startTypeName(config.StartType),
+		Account:      config.ServiceStartName,
+	}
+}
+
+// kubeletServiceConfig returns the config the kubelet service is created or updated with, apart from its command line
+func (wmcb *winNodeBootstrapper) kubeletServiceConfig() serviceConfig {
+	// Mostly default values here
+	c := serviceConfig{
+		ServiceType: 0,
+		// StartAutomatic will start the service again if the node restarts
+		StartType:      startAutomatic,
+		ErrorControl:   0,
+		LoadOrderGroup: "",
+		TagId:          0,
+		// set dependency on the container runtime the kubelet is configured to use
+		Dependencies:     []string{wmcb.runtimeServiceName()},
+		ServiceStartName: "",
+		DisplayName:      "",
+		Password:         "",
+		Description:      "OpenShift Kubelet",
+	}
+	wmcb.setServiceAccountConfig(KubeletServiceName, &c)
+	return c
+}
+
+// kubeletExePath returns the path of the kubelet.exe in the install directory
+func (wmcb *winNodeBootstrapper) kubeletExePath() string {
+	return filepath.Join(wmcb.installDir, "kubelet.exe")
+}
+
+// renderKubeletUnit returns the unit the kubelet service would be created with by InitializeKubelet
+func (wmcb *winNodeBootstrapper) renderKubeletUnit() *KubeletUnit {
+	config := wmcb.kubeletServiceConfig()
+	config.BinaryPathName = strings.TrimSpace(wmcb.kubeletExePath() + " " +
+		strings.Join(wmcb.getInitialKubeletArgs(), " "))
+	return newKubeletUnit(config, wmcb.kubeletExePath(), wmcb.proxy.environment())
+}
+
+// installedKubeletUnit returns the unit of the kubelet service given its config, with the environment it is
+// registered with
+func (wmcb *winNodeBootstrapper) installedKubeletUnit(config serviceConfig) (*KubeletUnit, error) {
+	env, err := serviceEnvironment(KubeletServiceName)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering kubelet unit: %v", err)
+	}
+	return newKubeletUnit(config, wmcb.kubeletExePath(), env), nil
+}
+
+// updateKubeletUnit writes the unit of the kubelet service, as it is registered in the Windows service database, to
+// the install directory
+func (wmcb *winNodeBootstrapper) updateKubeletUnit() error {
+	config, err := wmcb.kubeletSVC.config()
+	if err != nil {
+		return fmt.Errorf("error getting kubelet service config: %v", err)
+	}
+	unit, err := wmcb.installedKubeletUnit(config)
+	if err != nil {
+		return err
+	}
+	return wmcb.writeKubeletUnit(unit)
+}
+
+// KubeletUnit returns the unit of the kubelet service rendered in dry-run mode, or nil if it was not rendered
+func (wmcb *winNodeBootstrapper) KubeletUnit() *KubeletUnit {
+	if wmcb.dryRun == nil {
+		return nil
+	}
+	return wmcb.dryRun.KubeletUnit
+}
+
+// writeKubeletUnit writes the given kubelet unit to the install directory, and records it in the plan in dry-run mode
+func (wmcb *winNodeBootstrapper) writeKubeletUnit(unit *KubeletUnit) error {
+	contents, err := yaml.Marshal(unit)
+	if err != nil {
+		return fmt.Errorf("error marshalling kubelet unit: %v", err)
+	}
+	if wmcb.dryRun != nil {
+		wmcb.dryRun.KubeletUnit = unit
+	}
+	if _, err = wmcb.writeFile(filepath.Join(wmcb.installDir, kubeletUnitFileName), contents); err != nil {
+		return fmt.Errorf("error writing kubelet unit: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package bootstrapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	// kubeProxyServiceName is the name of the kube-proxy Windows service
+	kubeProxyServiceName = "kube-proxy"
+	// kubeProxyExe is the name of the kube-proxy executable
+	kubeProxyExe = "kube-proxy.exe"
+	// kubeProxyConfigName is the name of the kube-proxy configuration file
+	kubeProxyConfigName = "kube-proxy.conf"
+)
+
+// kubeProxyConf defines fields of the kube-proxy configuration file that are defined by WMCB variables
+type kubeProxyConf struct {
+	// Kubeconfig is the path to the kubeconfig kube-proxy uses to talk to the API server
+	Kubeconfig string
+	// ClusterCIDR is the CIDR range of the pods in the cluster
+	ClusterCIDR string
+	// NetworkName is the name of the HNS network kube-proxy programs the load balancers on
+	NetworkName string
+	// SourceVIP is the IP address used as the source of the load balanced traffic on the node
+	SourceVIP string
+	// EnableDSR indicates if the load balancers use Direct Server Return
+	EnableDSR bool
+}
+
+// kubeProxyOptions holds the kube-proxy specific information
+type kubeProxyOptions struct {
+	// path is the location where kube-proxy.exe has been downloaded to
+	path string
+	// clusterCIDR is the CIDR range of the pods in the cluster
+	clusterCIDR string
+	// networkName is the name of the HNS network kube-proxy programs the load balancers on
+	networkName string
+	// sourceVIP is the IP address used as the source of the load balanced traffic on the node
+	sourceVIP string
+}
+
+// newKubeProxyOptions validates the given kube-proxy inputs against the given file system and returns the
+// kubeProxyOptions object
+func newKubeProxyOptions(fs FileSystem, path, clusterCIDR, networkName, sourceVIP string) (*kubeProxyOptions, error) {
+	if _, err := fs.Stat(path); err != nil {
+		return nil, fmt.Errorf("unable to find kube-proxy at %s: %v", path, err)
+	}
+	if _, _, err := net.ParseCIDR(clusterCIDR); err != nil {
+		return nil, fmt.Errorf("invalid cluster CIDR %s: %v", clusterCIDR, err)
+	}
+	if networkName == "" {
+		return nil, fmt.Errorf("network name cannot be empty")
+	}
+	if sourceVIP != "" && net.ParseIP(sourceVIP) == nil {
+		return nil, fmt.Errorf("invalid source VIP %s", sourceVIP)
+	}
+	return &kubeProxyOptions{
+		path:        path,
+		clusterCIDR: clusterCIDR,
+		networkName: networkName,
+		sourceVIP:   sourceVIP,
+	}, nil
+}
+
+// createKubeProxyConf creates the config file for kube-proxy, with Windows winkernel specific configuration
+func (wmcb *winNodeBootstrapper) createKubeProxyConf(opts *kubeProxyOptions) ([]byte, error) {
+	// get config file content using bindata.go
+	content, err := Asset("templates/kube_proxy_config.json")
+	if err != nil {
+		return nil, fmt.Errorf("error reading kube-proxy config template: %v", err)
+	}
+
+	kubeProxyConfTmpl, err := template.New("kubeproxyconf").Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+	variableFields := kubeProxyConf{
+		// Escape the backslashes in the Windows path as the config is in the JSON format
+		Kubeconfig:  strings.ReplaceAll(wmcb.kubeconfigPath, `\`, `\\`),
+		ClusterCIDR: opts.clusterCIDR,
+		NetworkName: opts.networkName,
+		SourceVIP:   opts.sourceVIP,
+		EnableDSR:   wmcb.enableDSR(),
+	}
+
+	kubeProxyConfPath := filepath.Join(wmcb.installDir, kubeProxyConfigName)
+	if err := wmcb.journal.fileWritten(kubeProxyConfPath); err != nil {
+		return nil, err
+	}
+	kubeProxyConfFile, err := createFile(wmcb.fileSystem(), kubeProxyConfPath)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %v", kubeProxyConfPath, err)
+	}
+	defer kubeProxyConfFile.Close()
+	if err = kubeProxyConfTmpl.Execute(kubeProxyConfFile, variableFields); err != nil {
+		return nil, fmt.Errorf("error writing data to %v file: %v", kubeProxyConfPath, err)
+	}
+
+	kubeProxyConfData, err := readFile(wmcb.fileSystem(), kubeProxyConfPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading data from %v file: %v", kubeProxyConfPath, err)
+	}
+	return kubeProxyConfData, nil
+}
+
+// getKubeProxyArgs returns the arguments the kube-proxy Windows service is run with
+func (wmcb *winNodeBootstrapper) getKubeProxyArgs() []string {
+	return []string{
+		"--config=" + filepath.Join(wmcb.installDir, kubeProxyConfigName),
+		"--windows-service",
+		"--logtostderr=false",
+		"--log-file=" + filepath.Join(filepath.Dir(wmcb.logDir), kubeProxyServiceName, "kube-proxy.log"),
+		"--v=3",
+	}
+}
+
+// ConfigureKubeProxy copies kube-proxy.exe from kubeProxyPath to the install directory, generates the kube-proxy
+// configuration for the given cluster CIDR and HNS network, and registers kube-proxy as a Windows service that depends
+// on the kubelet service. sourceVIP is optional and is only required for overlay networks. This needs to be executed
+// after the kubelet has been initialized. The configuration is aborted before the kube-proxy service is updated if the
+// context is done.
+func (wmcb *winNodeBootstrapper) ConfigureKubeProxy(ctx context.Context, kubeProxyPath, clusterCIDR, networkName,
+	sourceVIP string) (err error) {
+	wmcb.startPhase()
+	defer func() { wmcb.recordPhase(PhaseKubeProxyConfigured, err) }()
+
+	if wmcb.kubeletSVC == nil {
+		return fmt.Errorf("kubelet service is not present, kube-proxy can only be configured after the kubelet")
+	}
+	if err = wmcb.detectWindowsBuild(); err != nil {
+		return fmt.Errorf("unable to configure kube-proxy: %w", err)
+	}
+	opts, err := newKubeProxyOptions(wmcb.fileSystem(), kubeProxyPath, clusterCIDR, networkName, sourceVIP)
+	if err != nil {
+		return fmt.Errorf("invalid kube-proxy inputs: %w", err)
+	}
+	if wmcb.journal, err = loadJournal(wmcb.fileSystem(), wmcb.installDir); err != nil {
+		return err
+	}
+	if err = wmcb.runHooks(ctx, HookPreKubeProxy); err != nil {
+		return err
+	}
+
+	wmcb.log.Info("configuring kube-proxy", "clusterCIDR", clusterCIDR, "networkName", networkName)
+	kubeProxyLogDir := filepath.Join(filepath.Dir(wmcb.logDir), kubeProxyServiceName)
+	if err := wmcb.fileSystem().MkdirAll(kubeProxyLogDir, os.ModeDir); err != nil {
+		return fmt.Errorf("could not make %s directory: %w", kubeProxyLogDir, err)
+	}
+
+	if err = ctx.Err(); err != nil {
+		return fmt.Errorf("kube-proxy configuration interrupted: %w", err)
+	}
+	kubeProxyService, err := wmcb.svcMgr.OpenService(kubeProxyServiceName)
+	if err != nil && !errors.Is(err, errServiceDoesNotExist) {
+		return fmt.Errorf("error getting existing kube-proxy service: %w", err)
+	}
+	if kubeProxyService != nil {
+		defer kubeProxyService.Close()
+		// Stop the kube-proxy service as there could be open file handles on kube-proxy.exe
+		if err := stopService(kubeProxyService); err != nil {
+			return fmt.Errorf("unable to stop kube-proxy service: %w", err)
+		}
+	}
+
+	kubeProxyExePath := filepath.Join(wmcb.installDir, kubeProxyExe)
+	if err := wmcb.journal.fileWritten(kubeProxyExePath); err != nil {
+		return err
+	}
+	if err := copyFile(wmcb.fileSystem(), opts.path, kubeProxyExePath); err != nil {
+		return fmt.Errorf("error copying %s --> %s: %w", opts.path, kubeProxyExePath, err)
+	}
+	if _, err := wmcb.createKubeProxyConf(opts); err != nil {
+		return fmt.Errorf("error creating kube-proxy configuration: %w", err)
+	}
+
+	c := serviceConfig{
+		// StartAutomatic will start the service again if the node restarts
+		StartType: startAutomatic,
+		// kube-proxy needs the kubelet to be running to be able to program the load balancers for the pods
+		Dependencies: []string{KubeletServiceName},
+		Description:  "OpenShift kube-proxy",
+	}
+	wmcb.setServiceAccountConfig(kubeProxyServiceName, &c)
+	service, err := createOrUpdateService(wmcb.svcMgr, wmcb.journal, kubeProxyService, kubeProxyServiceName,
+		kubeProxyExePath, c, wmcb.getKubeProxyArgs())
+	if err != nil {
+		return newError(ErrServiceCreate, err)
+	}
+	if kubeProxyService == nil {
+		defer service.Close()
+	}
+	if err := wmcb.serviceRecovery.apply(service); err != nil {
+		return fmt.Errorf("failed to set recovery actions for Windows service %s: %w", kubeProxyServiceName, err)
+	}
+	if err := wmcb.configureServiceAccount(service, kubeProxyServiceName,
+		wmcb.kubeProxyAccountRights(kubeProxyLogDir)); err != nil {
+		return fmt.Errorf("unable to configure the kube-proxy service account: %w", err)
+	}
+
+	if err := startService(service); err != nil {
+		return fmt.Errorf("failed to start kube-proxy service: %w", err)
+	}
+	if kubeProxyService != nil {
+		wmcb.recordServiceRestart(kubeProxyServiceName)
+	}
+	wmcb.log.Info("kube-proxy service started")
+	return wmcb.runHooks(ctx, HookPostKubeProxy)
+}
@@ -0,0 +1,164 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// logRotationTaskName is the name of the Windows scheduled task that rotates the logs of the node components
+	logRotationTaskName = "wmcb-rotate-logs"
+	// rotatedLogTimeFormat is the format of the time a log file was rotated at, which is part of the name of the
+	// rotated file, for example kubelet-20210102T150405.log
+	rotatedLogTimeFormat = "20060102T150405"
+)
+
+// LogRotationOptions are the limits the logs of the node components are rotated and retained with
+type LogRotationOptions struct {
+	// MaxSizeMB is the size in megabytes above which a log file is rotated
+	MaxSizeMB int
+	// MaxAge is the age after which a rotated log file is removed. The rotated files are not removed by age if it is 0.
+	MaxAge time.Duration
+	// MaxFiles is the number of rotated files kept for each log file, the oldest ones being removed. The rotated
+	// files are not removed by number if it is 0.
+	MaxFiles int
+}
+
+// validate returns an error if the options are invalid
+func (o LogRotationOptions) validate() error {
+	if o.MaxSizeMB <= 0 {
+		return fmt.Errorf("max size of the logs needs to be greater than zero")
+	}
+	if o.MaxAge < 0 {
+		return fmt.Errorf("max age of the logs cannot be negative")
+	}
+	if o.MaxFiles < 0 {
+		return fmt.Errorf("max number of rotated log files cannot be negative")
+	}
+	return nil
+}
+
+// serviceLogFiles returns the log files of the kubelet and of the Windows services WMCB configures
+func (wmcb *winNodeBootstrapper) serviceLogFiles() []string {
+	serviceLogDir := filepath.Dir(wmcb.logDir)
+	return []string{
+		filepath.Join(wmcb.logDir, "kubelet.log"),
+		filepath.Join(serviceLogDir, kubeProxyServiceName, "kube-proxy.log"),
+		filepath.Join(serviceLogDir, kubeletDependentSvc, "hybrid-overlay.log"),
+		filepath.Join(serviceLogDir, cloudNodeManagerServiceName, "cloud-node-manager.log"),
+		filepath.Join(serviceLogDir, containerdServiceName, "containerd.log"),
+	}
+}
+
+// RotateLogs rotates the log files of the kubelet and of the other Windows services that are larger than the given
+// max size, and removes the rotated files that exceed the given retention. A log file is rotated by copying it next to
+// itself, with the time of the rotation in its name, then truncating it: the services keep their log files open, so
+// the files cannot be renamed, and they append to them, so they keep writing at the start of the truncated file. The
+// log files that do not exist are ignored.
+func (wmcb *winNodeBootstrapper) RotateLogs(options LogRotationOptions) error {
+	if err := options.validate(); err != nil {
+		return err
+	}
+	fs := wmcb.fileSystem()
+	now := wmcb.now()
+	for _, logFile := range wmcb.serviceLogFiles() {
+		info, err := fs.Stat(logFile)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("error accessing %s: %w", logFile, err)
+		}
+		if info.Size() > int64(options.MaxSizeMB)<<20 {
+			rotated := rotatedLogPath(logFile, now)
+			wmcb.log.Info("rotating log file", "logFile", logFile, "rotatedFile", rotated, "size", info.Size())
+			if err = copyFile(fs, logFile, rotated); err != nil {
+				return fmt.Errorf("error copying %s --> %s: %w", logFile, rotated, err)
+			}
+			if err = writeFile(fs, logFile, nil, 0644); err != nil {
+				return fmt.Errorf("error truncating %s: %w", logFile, err)
+			}
+		}
+		if err = wmcb.pruneRotatedLogs(logFile, now, options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotatedLogPath returns the path the given log file is rotated to at the given time
+func rotatedLogPath(logFile string, at time.Time) string {
+	ext := filepath.Ext(logFile)
+	return strings.TrimSuffix(logFile, ext) + "-" + at.UTC().Format(rotatedLogTimeFormat) + ext
+}
+
+// pruneRotatedLogs removes the rotated files of the given log file that are older than the max age of the options, or
+// in excess of their max number of files, as of the given time
+func (wmcb *winNodeBootstrapper) pruneRotatedLogs(logFile string, now time.Time, options LogRotationOptions) error {
+	fs := wmcb.fileSystem()
+	dir := filepath.Dir(logFile)
+	ext := filepath.Ext(logFile)
+	prefix := strings.TrimSuffix(filepath.Base(logFile), ext) + "-"
+	files, err := fs.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", dir, err)
+	}
+
+	type rotatedLog struct {
+		path string
+		at   time.Time
+	}
+	var rotated []rotatedLog
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		at, err := time.Parse(rotatedLogTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext))
+		if err != nil {
+			// Not a rotated file of the log file
+			continue
+		}
+		rotated = append(rotated, rotatedLog{path: filepath.Join(dir, name), at: at})
+	}
+	// Newest first, so that the files in excess of the max number of files are the last ones
+	sort.Slice(rotated, func(i, j int) bool { return rotated[i].at.After(rotated[j].at) })
+	for i, file := range rotated {
+		expired := options.MaxAge != 0 && now.Sub(file.at) > options.MaxAge
+		if !expired && (options.MaxFiles == 0 || i < options.MaxFiles) {
+			continue
+		}
+		wmcb.log.Info("removing rotated log file", "rotatedFile", file.path)
+		if err = fs.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing %s: %w", file.path, err)
+		}
+	}
+	return nil
+}
+
+// ScheduleLogRotation registers a Windows scheduled task that runs the rotate-logs command of the given wmcb
+// executable hourly with the given options, so that the logs of the node components do not fill the disk of the node.
+// An existing task is replaced.
+func (wmcb *winNodeBootstrapper) ScheduleLogRotation(wmcbPath string, options LogRotationOptions) error {
+	if err := options.validate(); err != nil {
+		return err
+	}
+	if _, err := wmcb.fileSystem().Stat(wmcbPath); err != nil {
+		return fmt.Errorf("unable to find wmcb at %s: %v", wmcbPath, err)
+	}
+
+	args := fmt.Sprintf("rotate-logs --max-size %d --max-age %s --max-files %d", options.MaxSizeMB, options.MaxAge,
+		options.MaxFiles)
+	cmd := fmt.Sprintf("$action = New-ScheduledTaskAction -Execute '%s' -Argument \"%s\"; "+
+		"$trigger = New-ScheduledTaskTrigger -Once -At (Get-Date) -RepetitionInterval (New-TimeSpan -Hours 1); "+
+		"Register-ScheduledTask -TaskName '%s' -Action $action -Trigger $trigger -User 'SYSTEM' -RunLevel Highest "+
+		"-Force", wmcbPath, args, logRotationTaskName)
+	if _, err := runPowerShell(cmd); err != nil {
+		return fmt.Errorf("error registering scheduled task %s: %v", logRotationTaskName, err)
+	}
+	return nil
+}
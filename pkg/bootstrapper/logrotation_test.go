@@ -0,0 +1,52 @@
+package bootstrapper
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/clock"
+	logger "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// TestRotateLogs tests that the log files larger than the max size are rotated, and that the rotated files in excess
+// of the retention are removed
+func TestRotateLogs(t *testing.T) {
+	fs := newMemFS()
+	now := time.Date(2021, 6, 15, 12, 0, 0, 0, time.UTC)
+	wmcb := winNodeBootstrapper{logDir: `C:\var\log\kubelet`, fs: fs, clock: clock.NewFakePassiveClock(now),
+		log: logger.Log}
+	kubeletLog := `C:\var\log\kubelet\kubelet.log`
+	kubeProxyLog := `C:\var\log\kube-proxy\kube-proxy.log`
+	require.NoError(t, fs.MkdirAll(`C:\var\log\kubelet`, 0755))
+	require.NoError(t, fs.MkdirAll(`C:\var\log\kube-proxy`, 0755))
+	require.NoError(t, writeFile(fs, kubeletLog, bytes.Repeat([]byte("a"), 2<<20), 0644))
+	require.NoError(t, writeFile(fs, kubeProxyLog, []byte("small"), 0644))
+	for _, at := range []time.Time{now.Add(-time.Hour), now.Add(-2 * time.Hour), now.Add(-30 * 24 * time.Hour)} {
+		require.NoError(t, writeFile(fs, rotatedLogPath(kubeletLog, at), []byte("old"), 0644))
+	}
+	require.NoError(t, writeFile(fs, `C:\var\log\kubelet\kubelet-notes.log`, []byte("kept"), 0644))
+
+	assert.Error(t, wmcb.RotateLogs(LogRotationOptions{}), "expected the max size to be required")
+	require.NoError(t, wmcb.RotateLogs(LogRotationOptions{MaxSizeMB: 1, MaxAge: 7 * 24 * time.Hour, MaxFiles: 2}))
+
+	info, err := fs.Stat(kubeletLog)
+	require.NoError(t, err)
+	assert.Zero(t, info.Size(), "expected the kubelet log to be truncated")
+	info, err = fs.Stat(`C:\var\log\kubelet\kubelet-20210615T120000.log`)
+	require.NoError(t, err, "expected the kubelet log to be rotated")
+	assert.Equal(t, int64(2<<20), info.Size())
+	files, err := fs.ReadDir(`C:\var\log\kubelet`)
+	require.NoError(t, err)
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	assert.ElementsMatch(t, []string{"kubelet.log", "kubelet-20210615T120000.log", "kubelet-20210615T110000.log",
+		"kubelet-notes.log"}, names, "expected the oldest rotated files to be removed")
+	contents, err := readFile(fs, kubeProxyLog)
+	require.NoError(t, err)
+	assert.Equal(t, "small", string(contents), "expected the log under the max size not to be rotated")
+}
@@ -0,0 +1,135 @@
+package bootstrapper
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// metricsFileExtension is the extension of the files the textfile collector of the Windows exporter reads
+const metricsFileExtension = ".prom"
+
+// metricsFile is the file the bootstrap metrics are written to every time the status file is updated. No metrics are
+// written if it is empty.
+var metricsFile string
+
+// SetMetricsFile configures the bootstrapper to write the bootstrap status as Prometheus metrics to the given file,
+// which is expected to be in the directory the textfile collector of the Windows exporter reads from. This needs to be
+// called before the bootstrap phases are run to take effect.
+func SetMetricsFile(path string) error {
+	if filepath.Ext(path) != metricsFileExtension {
+		return fmt.Errorf("metrics file %s needs to have the %s extension to be read by the textfile collector", path,
+			metricsFileExtension)
+	}
+	metricsFile = path
+	return nil
+}
+
+// metric is a Prometheus gauge along with its samples
+type metric struct {
+	// name is the name of the metric
+	name string
+	// help describes the metric
+	help string
+	// samples are the values of the metric
+	samples []sample
+}
+
+// sample is a value of a metric for the given label
+type sample struct {
+	// label is the label of the sample in the name="value" form. The sample has no label if it is empty.
+	label string
+	// value is the value of the sample
+	value float64
+}
+
+// metrics returns the Prometheus metrics describing the status
+func (s *Status) metrics() []metric {
+	duration := metric{name: "wmcb_phase_duration_seconds", help: "Time the latest attempt of the phase took."}
+	attempts := metric{name: "wmcb_phase_attempts", help: "Number of times the phase has been attempted."}
+	succeeded := metric{name: "wmcb_phase_succeeded", help: "Whether the latest attempt of the phase succeeded."}
+	timestamp := metric{name: "wmcb_phase_timestamp_seconds",
+		help: "Time at which the latest attempt of the phase completed or failed."}
+	bootstrapSucceeded := boolValue(len(s.Phases) != 0)
+	var lastTimestamp float64
+	for _, phase := range s.Phases {
+		label := fmt.Sprintf("phase=%q", phase.Phase)
+		phaseTimestamp := float64(phase.Timestamp.Unix())
+		duration.samples = append(duration.samples, sample{label, phase.DurationSeconds})
+		attempts.samples = append(attempts.samples, sample{label, float64(phase.Attempts)})
+		succeeded.samples = append(succeeded.samples, sample{label, boolValue(phase.Succeeded)})
+		timestamp.samples = append(timestamp.samples, sample{label, phaseTimestamp})
+		if !phase.Succeeded {
+			bootstrapSucceeded = 0
+		}
+		if phaseTimestamp > lastTimestamp {
+			lastTimestamp = phaseTimestamp
+		}
+	}
+
+	restarts := metric{name: "wmcb_service_restarts",
+		help: "Number of times the Windows service has been restarted by WMCB."}
+	services := make([]string, 0, len(s.ServiceRestarts))
+	for service := range s.ServiceRestarts {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	for _, service := range services {
+		restarts.samples = append(restarts.samples,
+			sample{fmt.Sprintf("service=%q", service), float64(s.ServiceRestarts[service])})
+	}
+
+	return []metric{duration, attempts, succeeded, timestamp, restarts,
+		{name: "wmcb_bootstrap_succeeded", help: "Whether every phase of the latest bootstrap succeeded.",
+			samples: []sample{{value: bootstrapSucceeded}}},
+		{name: "wmcb_bootstrap_timestamp_seconds",
+			help: "Time at which the latest bootstrap phase completed or failed.", samples: []sample{{value: lastTimestamp}}},
+	}
+}
+
+// boolValue returns 1 if the given value is true and 0 otherwise
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// formatMetrics returns the given metrics in the Prometheus text format
+func formatMetrics(metrics []metric) []byte {
+	var b bytes.Buffer
+	for _, m := range metrics {
+		if len(m.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for _, s := range m.samples {
+			name := m.name
+			if s.label != "" {
+				name += "{" + s.label + "}"
+			}
+			fmt.Fprintf(&b, "%s %s\n", name, strconv.FormatFloat(s.value, 'g', -1, 64))
+		}
+	}
+	return b.Bytes()
+}
+
+// writeMetrics writes the given status as Prometheus metrics to the given file of the given file system. The metrics are written to a temporary
+// file that is then renamed, so that the textfile collector never reads a partially written file.
+func writeMetrics(fs FileSystem, path string, status *Status) error {
+	if err := fs.MkdirAll(filepath.Dir(path), os.ModeDir); err != nil {
+		return fmt.Errorf("could not make metrics directory: %v", err)
+	}
+	// The temporary file does not have the extension read by the textfile collector
+	tmpPath := path + ".tmp"
+	if err := writeFile(fs, tmpPath, formatMetrics(status.metrics()), 0644); err != nil {
+		return fmt.Errorf("error writing metrics: %v", err)
+	}
+	if err := fs.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error renaming %s to %s: %v", tmpPath, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package bootstrapper
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// mustGatherErrorsFile is the file in the must-gather archive listing the diagnostics that could not be collected
+	mustGatherErrorsFile = "errors.txt"
+	// dockerServiceName is the name of the Windows service of the docker container runtime
+	dockerServiceName = "docker"
+)
+
+// mustGatherEventLogs are the Windows event logs whose recent entries are collected by MustGather
+var mustGatherEventLogs = []string{"System", "Application"}
+
+// mustGatherArchive writes the diagnostics of the Windows node to a zip archive. Diagnostics that cannot be collected
+// are skipped, and the errors are written to the archive instead, so that a partially broken node can still be
+// diagnosed.
+type mustGatherArchive struct {
+	// fs is the file system the diagnostics are read from
+	fs FileSystem
+	// zip is the writer of the archive
+	zip *zip.Writer
+	// errors are the errors encountered while collecting the diagnostics
+	errors []string
+}
+
+// recordError records that the diagnostics with the given name in the archive could not be collected
+func (a *mustGatherArchive) recordError(name string, err error) {
+	a.errors = append(a.errors, fmt.Sprintf("%s: %v", name, err))
+}
+
+// addContent writes the given content to the file with the given name in the archive
+func (a *mustGatherArchive) addContent(name string, content []byte) {
+	w, err := a.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err == nil {
+		_, err = w.Write(content)
+	}
+	if err != nil {
+		a.recordError(name, err)
+	}
+}
+
+// addFile copies the file at the given path to the file with the given name in the archive
+func (a *mustGatherArchive) addFile(name, filePath string) {
+	if err := a.copyFile(name, filePath); err != nil {
+		a.recordError(name, err)
+	}
+}
+
+// copyFile copies the file at the given path to the file with the given name in the archive
+func (a *mustGatherArchive) copyFile(name, filePath string) error {
+	info, err := a.fs.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	// The log files are still being written to by the services, which only allows reading them
+	file, err := a.fs.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	w, err := a.zip.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// addDir copies the files in the given directory and its subdirectories to the directory with the given name in the
+// archive. A directory that does not exist is skipped, as the service writing to it may not have been configured.
+func (a *mustGatherArchive) addDir(name, dir string) {
+	err := walk(a.fs, dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && filePath == dir {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		a.addFile(path.Join(name, filepath.ToSlash(relPath)), filePath)
+		return nil
+	})
+	if err != nil {
+		a.recordError(name, err)
+	}
+}
+
+// addJSON writes the given object in the JSON format to the file with the given name in the archive, unless it could
+// not be retrieved
+func (a *mustGatherArchive) addJSON(name string, obj interface{}, err error) {
+	if err != nil {
+		a.recordError(name, err)
+		return
+	}
+	content, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		a.recordError(name, err)
+		return
+	}
+	a.addContent(name, content)
+}
+
+// addPowerShellOutput writes the output of the given PowerShell command to the file with the given name in the archive
+func (a *mustGatherArchive) addPowerShellOutput(name, command string) {
+	out, err := runPowerShell(command)
+	if err != nil {
+		a.recordError(name, err)
+		return
+	}
+	a.addContent(name, []byte(out))
+}
+
+// close writes the errors encountered while collecting the diagnostics to the archive, if any, and closes it
+func (a *mustGatherArchive) close() error {
+	if len(a.errors) != 0 {
+		a.addContent(mustGatherErrorsFile, []byte(strings.Join(a.errors, "\n")+"\n"))
+	}
+	return a.zip.Close()
+}
+
+// serviceStatusCommand returns the PowerShell command that prints the status and configuration of the given services
+func serviceStatusCommand(services []string) string {
+	filters := make([]string, 0, len(services))
+	for _, service := range services {
+		filters = append(filters, "Name='"+service+"'")
+	}
+	return "Get-CimInstance Win32_Service -Filter \"" + strings.Join(filters, " OR ") + "\" | " +
+		"Format-List Name,State,Status,StartMode,ProcessId,ExitCode,PathName | Out-String -Width 4096"
+}
+
+// eventLogCommand returns the PowerShell command that prints the entries of the given Windows event log that were
+// written within the given duration
+func eventLogCommand(logName string, since time.Duration) string {
+	return fmt.Sprintf("Get-WinEvent -FilterHashtable @{LogName='%s'; StartTime=(Get-Date).AddSeconds(-%d)} "+
+		"-ErrorAction SilentlyContinue | Format-List TimeCreated,ProviderName,Id,LevelDisplayName,Message | "+
+		"Out-String -Width 4096", logName, int64(since.Seconds()))
+}
+
+// MustGather collects the diagnostics of the Windows node required by support cases into a zip archive written to
+// dest. The archive contains the logs of the kubelet, kube-proxy, hybrid-overlay-node, cloud-node-manager and
+// containerd services, the bootstrap status and kubelet configuration, the versions of the node components, the HNS
+// networks and endpoints, the status of the Windows services managed by WMCB and the entries of the System and
+// Application event logs written within the given duration. The kubeconfigs and certificates are not collected.
+// Diagnostics that cannot be collected are listed in errors.txt in the archive. The collection is aborted once the
+// context is done.
+func (wmcb *winNodeBootstrapper) MustGather(ctx context.Context, dest string, since time.Duration) error {
+	file, err := createFile(wmcb.fileSystem(), dest)
+	if err != nil {
+		return fmt.Errorf("error creating must-gather archive: %v", err)
+	}
+	defer file.Close()
+	archive := &mustGatherArchive{fs: wmcb.fileSystem(), zip: zip.NewWriter(file)}
+
+	logRoot := filepath.Dir(wmcb.logDir)
+	services := []string{KubeletServiceName, kubeProxyServiceName, kubeletDependentSvc, cloudNodeManagerServiceName,
+		containerdServiceName}
+	steps := []func(){
+		func() {
+			for _, service := range services {
+				wmcb.log.Info("collecting logs", "service", service)
+				archive.addDir(path.Join("logs", service), filepath.Join(logRoot, service))
+			}
+		},
+		func() {
+			wmcb.log.Info("collecting bootstrap status and kubelet configuration")
+			archive.addFile(path.Join("config", statusFileName), statusFilePath(wmcb.installDir))
+			archive.addFile(path.Join("config", filepath.Base(wmcb.kubeletConfPath)), wmcb.kubeletConfPath)
+		},
+		func() {
+			wmcb.log.Info("collecting component versions")
+			archive.addJSON("versions.json", wmcb.Versions(ctx), nil)
+		},
+		func() {
+			wmcb.log.Info("collecting HNS state")
+			archive.addHNSState()
+		},
+		func() {
+			wmcb.log.Info("collecting service status")
+			archive.addPowerShellOutput("services.txt",
+				serviceStatusCommand(append(services, dockerServiceName)))
+		},
+		func() {
+			for _, logName := range mustGatherEventLogs {
+				wmcb.log.Info("collecting event log", "log", logName, "since", since)
+				archive.addPowerShellOutput(path.Join("events", strings.ToLower(logName)+".txt"),
+					eventLogCommand(logName, since))
+			}
+		},
+	}
+	for _, step := range steps {
+		if err = ctx.Err(); err != nil {
+			archive.close()
+			return fmt.Errorf("must-gather interrupted: %v", err)
+		}
+		step()
+	}
+
+	if err = archive.close(); err != nil {
+		return fmt.Errorf("error writing must-gather archive: %v", err)
+	}
+	if len(archive.errors) != 0 {
+		wmcb.log.Info("some diagnostics could not be collected", "errors", archive.errors)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// nodeIPArg is the kubelet argument of the IP address the node is registered with
+	nodeIPArg = "node-ip"
+	// natAdapterName is the name of the adapter of the NAT network of the container runtime, the address of which is
+	// not reachable from the cluster
+	natAdapterName = "vEthernet (nat)"
+)
+
+// nodeIPOptions are the user provided inputs the IP address of the node is selected with
+type nodeIPOptions struct {
+	// ip is the IP address the node is registered with
+	ip string
+	// iface is the name of the network adapter the IP address of the node is taken from
+	iface string
+}
+
+// nodeAddress is a unicast address of a network adapter of the node
+type nodeAddress struct {
+	// iface is the name of the network adapter, for example Ethernet 2
+	iface string
+	// ip is the address
+	ip net.IP
+}
+
+// SetNodeIP sets the IP address the node is registered with, or the name of the network adapter it is taken from, for
+// example "Ethernet 2", when the Windows node has several network adapters. Only one of them can be given. If neither
+// is given and the node has several candidate addresses, the address of the adapter the API server is reached through
+// is used. This needs to be called before InitializeKubelet for the address to take effect.
+func (wmcb *winNodeBootstrapper) SetNodeIP(ip, iface string) error {
+	if ip != "" && iface != "" {
+		return fmt.Errorf("node IP %s and node IP interface %s cannot both be given", ip, iface)
+	}
+	if ip != "" && net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid node IP %q", ip)
+	}
+	wmcb.nodeIP = nodeIPOptions{ip: ip, iface: iface}
+	return nil
+}
+
+// resolveNodeIP selects the IP address the node is registered with among the addresses of its network adapters, and
+// stores it for the kubelet arguments. No address is selected if the node-ip kubelet argument is given, or if the
+// kubelet can pick the address on its own.
+func (wmcb *winNodeBootstrapper) resolveNodeIP() error {
+	wmcb.nodeIPArg = ""
+	if _, ok := wmcb.kubeletArgOverrides[nodeIPArg]; ok {
+		return nil
+	}
+	addresses, err := hostAddresses()
+	if err != nil {
+		return fmt.Errorf("could not list the addresses of the network adapters: %w", err)
+	}
+	multiple := len(candidateNodeAddresses(addresses)) > 1
+	var routeIP net.IP
+	if wmcb.nodeIP.ip == "" && wmcb.nodeIP.iface == "" && multiple {
+		routeIP, err = wmcb.apiServerRouteIP()
+		if err != nil {
+			wmcb.log.Info("unable to find the network adapter the API server is reached through", "error",
+				err.Error())
+		}
+	}
+	ip, err := selectNodeIP(addresses, wmcb.nodeIP, routeIP)
+	if err != nil {
+		return err
+	}
+	if ip != "" {
+		wmcb.log.Info("selected node IP", "nodeIP", ip)
+	} else if multiple {
+		wmcb.log.Info("unable to select the node IP among several network adapters, the kubelet picks it")
+	}
+	wmcb.nodeIPArg = ip
+	return nil
+}
+
+// selectNodeIP returns the IP address the node is registered with among the given addresses of its network adapters,
+// given the user provided options and the local address of the route to the API server, if known. An empty address is
+// returned if the kubelet is to pick the address on its own, which it does correctly when the node has a single
+// candidate address.
+func selectNodeIP(addresses []nodeAddress, options nodeIPOptions, routeIP net.IP) (string, error) {
+	switch {
+	case options.ip != "":
+		ip := net.ParseIP(options.ip)
+		for _, address := range addresses {
+			if address.ip.Equal(ip) {
+				return ip.String(), nil
+			}
+		}
+		return "", fmt.Errorf("node IP %s is not assigned to any network adapter", options.ip)
+	case options.iface != "":
+		// Once an HNS network is created on an adapter, its address moves to the vEthernet (<adapter>) virtual adapter
+		var found []net.IP
+		for _, address := range addresses {
+			if strings.EqualFold(address.iface, options.iface) ||
+				strings.EqualFold(address.iface, "vEthernet ("+options.iface+")") {
+				found = append(found, address.ip)
+			}
+		}
+		if len(found) == 0 {
+			return "", fmt.Errorf("network adapter %s has no usable address", options.iface)
+		}
+		// IPv4 addresses are preferred, as the Windows nodes are single stack IPv4
+		for _, ip := range found {
+			if ip.To4() != nil {
+				return ip.String(), nil
+			}
+		}
+		return found[0].String(), nil
+	}
+	candidates := candidateNodeAddresses(addresses)
+	if len(candidates) <= 1 || routeIP == nil {
+		return "", nil
+	}
+	for _, address := range candidates {
+		if address.ip.Equal(routeIP) {
+			return address.ip.String(), nil
+		}
+	}
+	return "", nil
+}
+
+// candidateNodeAddresses returns the IPv4 addresses of the given addresses the node can be registered with, excluding
+// the NAT network of the container runtime
+func candidateNodeAddresses(addresses []nodeAddress) []nodeAddress {
+	var candidates []nodeAddress
+	for _, address := range addresses {
+		if address.ip.To4() != nil && !strings.EqualFold(address.iface, natAdapterName) {
+			candidates = append(candidates, address)
+		}
+	}
+	return candidates
+}
+
+// hostAddresses returns the unicast addresses of the network adapters of the node that are up, excluding the loopback
+// and link-local addresses
+func hostAddresses() ([]nodeAddress, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var addresses []nodeAddress
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("error getting the addresses of %s: %w", iface.Name, err)
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || !ipNet.IP.IsGlobalUnicast() || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			addresses = append(addresses, nodeAddress{iface: iface.Name, ip: ipNet.IP})
+		}
+	}
+	return addresses, nil
+}
+
+// apiServerRouteIP returns the local address of the route to the API server of the bootstrap kubeconfig, which is the
+// address of the network adapter the cluster is reached through
+func (wmcb *winNodeBootstrapper) apiServerRouteIP() (net.IP, error) {
+	kubeconfigPath := filepath.Join(wmcb.installDir, bootstrapKubeconfigName)
+	contents, err := readFile(wmcb.fileSystem(), kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	config, err := clientcmd.Load(contents)
+	if err != nil {
+		return nil, fmt.Errorf("error loading kubeconfig %s: %w", kubeconfigPath, err)
+	}
+	kubeContext, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig %s has no current context", kubeconfigPath)
+	}
+	cluster, ok := config.Clusters[kubeContext.Cluster]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig %s has no cluster %s", kubeconfigPath, kubeContext.Cluster)
+	}
+	server, err := url.Parse(cluster.Server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API server URL %s: %w", cluster.Server, err)
+	}
+	port := server.Port()
+	if port == "" {
+		port = "443"
+	}
+	// No packet is sent, dialing UDP only selects the route and thereby the local address
+	conn, err := net.Dial("udp", net.JoinHostPort(server.Hostname(), port))
+	if err != nil {
+		return nil, fmt.Errorf("error finding the route to %s: %w", server.Host, err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -0,0 +1,61 @@
+package bootstrapper
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNodeIP tests that the node IP is selected among the addresses of the network adapters of a multi-NIC node and
+// passed to the kubelet
+func TestNodeIP(t *testing.T) {
+	wmcb := winNodeBootstrapper{installDir: "C:\\k"}
+	assert.Error(t, wmcb.SetNodeIP("10.0.0.5", "Ethernet"), "expected the IP and interface to be exclusive")
+	assert.Error(t, wmcb.SetNodeIP("10.0.0", ""), "expected the IP to be validated")
+	require.NoError(t, wmcb.SetNodeIP("", "Ethernet 2"))
+	assert.Equal(t, nodeIPOptions{iface: "Ethernet 2"}, wmcb.nodeIP)
+
+	addresses := []nodeAddress{
+		{iface: "Ethernet", ip: net.ParseIP("10.0.0.5")},
+		{iface: "vEthernet (Ethernet 2)", ip: net.ParseIP("fd00::12")},
+		{iface: "vEthernet (Ethernet 2)", ip: net.ParseIP("192.168.10.12")},
+		{iface: "vEthernet (nat)", ip: net.ParseIP("172.20.96.1")},
+	}
+	testIO := []struct {
+		name     string
+		options  nodeIPOptions
+		routeIP  net.IP
+		expected string
+		errors   bool
+	}{
+		{name: "Given IP", options: nodeIPOptions{ip: "192.168.10.12"}, expected: "192.168.10.12"},
+		{name: "Unassigned IP", options: nodeIPOptions{ip: "192.168.10.13"}, errors: true},
+		{name: "Interface with HNS network", options: nodeIPOptions{iface: "ethernet 2"}, expected: "192.168.10.12"},
+		{name: "Interface", options: nodeIPOptions{iface: "Ethernet"}, expected: "10.0.0.5"},
+		{name: "Unknown interface", options: nodeIPOptions{iface: "Ethernet 3"}, errors: true},
+		{name: "Route to the API server", routeIP: net.ParseIP("192.168.10.12"), expected: "192.168.10.12"},
+		{name: "Route through the NAT network", routeIP: net.ParseIP("172.20.96.1"), expected: ""},
+		{name: "Unknown route", expected: ""},
+	}
+	for _, tt := range testIO {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, err := selectNodeIP(addresses, tt.options, tt.routeIP)
+			if tt.errors {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, ip)
+		})
+	}
+
+	// A single candidate address is left to the kubelet
+	ip, err := selectNodeIP(addresses[:1], nodeIPOptions{}, net.ParseIP("10.0.0.5"))
+	require.NoError(t, err)
+	assert.Empty(t, ip)
+
+	wmcb.nodeIPArg = "192.168.10.12"
+	assert.Contains(t, wmcb.getInitialKubeletArgs(), "--node-ip=192.168.10.12")
+}
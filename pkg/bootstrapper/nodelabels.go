@@ -0,0 +1,90 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// taintEffects are the valid effects of a node taint
+var taintEffects = map[string]bool{
+	"NoSchedule":       true,
+	"PreferNoSchedule": true,
+	"NoExecute":        true,
+}
+
+// SetNodeLabels sets the labels the node is registered with, in addition to the Windows node label, for example
+// topology.kubernetes.io/zone. This needs to be called before InitializeKubelet for the labels to take effect.
+func (wmcb *winNodeBootstrapper) SetNodeLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid node label key %q: %s", key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid value %q for node label %s: %s", value, key, strings.Join(errs, "; "))
+		}
+	}
+	wmcb.nodeLabels = labels
+	return nil
+}
+
+// SetNodeTaints sets the taints the node is registered with, in addition to the Windows taint, in the key=value:effect
+// format, for example dedicated=gpu:NoSchedule. This needs to be called before InitializeKubelet for the taints to take
+// effect.
+func (wmcb *winNodeBootstrapper) SetNodeTaints(taints []string) error {
+	for _, taint := range taints {
+		if err := validateTaint(taint); err != nil {
+			return err
+		}
+	}
+	wmcb.nodeTaints = taints
+	return nil
+}
+
+// validateTaint returns an error if the given taint is not in the key=value:effect or key:effect format
+func validateTaint(taint string) error {
+	keyValueEffect := strings.Split(taint, ":")
+	if len(keyValueEffect) != 2 {
+		return fmt.Errorf("invalid node taint %q, expected key=value:effect", taint)
+	}
+	if !taintEffects[keyValueEffect[1]] {
+		return fmt.Errorf("invalid effect %q for node taint %s, expected NoSchedule, PreferNoSchedule or NoExecute",
+			keyValueEffect[1], taint)
+	}
+	keyValue := strings.SplitN(keyValueEffect[0], "=", 2)
+	if errs := validation.IsQualifiedName(keyValue[0]); len(errs) > 0 {
+		return fmt.Errorf("invalid key %q for node taint %s: %s", keyValue[0], taint, strings.Join(errs, "; "))
+	}
+	if len(keyValue) == 2 {
+		if errs := validation.IsValidLabelValue(keyValue[1]); len(errs) > 0 {
+			return fmt.Errorf("invalid value %q for node taint %s: %s", keyValue[1], taint, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// nodeLabelsArg returns the value of the kubelet node-labels argument, with the Windows node label and the GMSA label
+// if enabled, followed by the user provided labels in a deterministic order
+func (wmcb *winNodeBootstrapper) nodeLabelsArg() string {
+	labels := []string{nodeLabel}
+	if wmcb.gmsa != nil {
+		labels = append(labels, gmsaNodeLabel)
+	}
+	var keys []string
+	for key := range wmcb.nodeLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		labels = append(labels, key+"="+wmcb.nodeLabels[key])
+	}
+	return strings.Join(labels, ",")
+}
+
+// taintsArg returns the value of the kubelet register-with-taints argument, with the Windows taint followed by the user
+// provided taints
+func (wmcb *winNodeBootstrapper) taintsArg() string {
+	return strings.Join(append([]string{windowsTaints}, wmcb.nodeTaints...), ",")
+}
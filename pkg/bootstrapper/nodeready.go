@@ -0,0 +1,158 @@
+package bootstrapper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// nodePollInterval is the interval at which the node object is checked while waiting for the node to be Ready
+	nodePollInterval = 10 * time.Second
+	// uninitializedTaint is set on the nodes until the cloud controller manager has initialized them
+	uninitializedTaint = "node.cloudprovider.kubernetes.io/uninitialized"
+	// kubeletLogTailSize is the size of the end of the kubelet log that is searched for cloud provider errors
+	kubeletLogTailSize = 64 << 10
+)
+
+// nodeName returns the name the kubelet registers the node with, which is the lower case hostname unless it is
+// overridden with the hostname-override kubelet argument
+func (wmcb *winNodeBootstrapper) nodeName() (string, error) {
+	if name, ok := wmcb.kubeletArgOverrides["hostname-override"]; ok {
+		return name, nil
+	}
+	return hostNodeName()
+}
+
+// WaitForNodeReady waits until the kubelet has registered the node object of the Windows node and the node is Ready,
+// the timeout is reached or the context is done. The node is read with the kubeconfig the kubelet writes once its
+// client certificate has been issued. If the node is not Ready in time, the error reports the stage the node is stuck
+// at: the kubelet not running, the client certificate CSR pending approval, the node not registered, a cloud provider
+// error, or the CNI plugin not ready.
+func (wmcb *winNodeBootstrapper) WaitForNodeReady(ctx context.Context, timeout time.Duration) (err error) {
+	wmcb.startPhase()
+	defer func() { wmcb.recordPhase(PhaseNodeReady, err) }()
+
+	if wmcb.kubeletSVC == nil {
+		return fmt.Errorf("kubelet service is not present")
+	}
+	nodeName, err := wmcb.nodeName()
+	if err != nil {
+		return err
+	}
+	wmcb.log.Info("waiting for node to be Ready", "node", nodeName)
+	var client *nodeClient
+	stage := ""
+	err = pollWithContext(ctx, nodePollInterval, timeout, func() (bool, error) {
+		var ready bool
+		previous := stage
+		stage, ready = wmcb.nodeJoinStage(ctx, &client, nodeName)
+		if stage != previous {
+			wmcb.log.Info("node join progress", "node", nodeName, "stage", stage)
+		}
+		return ready, nil
+	})
+	if err != nil {
+		return newError(ErrTransient, fmt.Errorf("node %s is not Ready, %s: %w", nodeName, stage, err))
+	}
+	wmcb.log.Info("node is Ready", "node", nodeName)
+	return nil
+}
+
+// nodeJoinStage returns the stage the node with the given name is at in joining the cluster, and true if it is Ready.
+// The client is created once the kubelet has written its kubeconfig.
+func (wmcb *winNodeBootstrapper) nodeJoinStage(ctx context.Context, client **nodeClient,
+	nodeName string) (string, bool) {
+	running, err := wmcb.kubeletSVC.isRunning()
+	if err != nil {
+		return fmt.Sprintf("unable to query the kubelet service: %v", err), false
+	}
+	if !running {
+		return "the kubelet service is not running", false
+	}
+	if _, err = wmcb.fileSystem().Stat(wmcb.kubeconfigPath); err != nil {
+		return "the node-bootstrapper CSR of the node is pending approval", false
+	}
+	if *client == nil {
+		if *client, err = newNodeClient(wmcb.kubeconfigPath); err != nil {
+			return err.Error(), false
+		}
+	}
+
+	node, err := (*client).core.Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if cloudErr := lastCloudProviderError(wmcb.fileSystem(), filepath.Join(wmcb.logDir, "kubelet.log")); cloudErr != "" {
+			return "the kubelet failed with a cloud provider error: " + cloudErr, false
+		}
+		return "the kubelet has not registered the node", false
+	}
+	if err != nil {
+		return fmt.Sprintf("unable to get the node: %v", err), false
+	}
+	return nodeStage(node)
+}
+
+// nodeStage returns the stage the given registered node is at in joining the cluster, and true if it is Ready
+func nodeStage(node *corev1.Node) (string, bool) {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == uninitializedTaint {
+			return "the cloud provider has not initialized the node", false
+		}
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type != corev1.NodeReady {
+			continue
+		}
+		if condition.Status == corev1.ConditionTrue {
+			return "the node is Ready", true
+		}
+		message := strings.ToLower(condition.Message)
+		if strings.Contains(message, "network plugin") || strings.Contains(message, "cni") {
+			return "the CNI plugin is not ready: " + condition.Message, false
+		}
+		return fmt.Sprintf("the node is not Ready: %s: %s", condition.Reason, condition.Message), false
+	}
+	return "the kubelet has not reported the node status", false
+}
+
+// lastCloudProviderError returns the last error logged by the kubelet in the given log about the cloud provider, or an
+// empty string if there is none. Only the end of the log is searched.
+func lastCloudProviderError(fs FileSystem, logPath string) string {
+	info, err := fs.Stat(logPath)
+	if err != nil {
+		return ""
+	}
+	f, err := fs.Open(logPath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	offset := info.Size() - kubeletLogTailSize
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err = io.CopyN(ioutil.Discard, f, offset); err != nil {
+		return ""
+	}
+	tail := make([]byte, info.Size()-offset)
+	if _, err = io.ReadFull(f, tail); err != nil && err != io.ErrUnexpectedEOF {
+		return ""
+	}
+	lines := strings.Split(string(tail), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		// The error lines of the kubelet start with E, followed by the date
+		if strings.HasPrefix(line, "E") && strings.Contains(strings.ToLower(line), "cloud provider") {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,140 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// WinNodeBootstrapper bootstraps a Windows node, initializing the kubelet and configuring CNI and kube-proxy. It is
+// created using New or NewFromOptions, and can be held by library consumers in their own types.
+type WinNodeBootstrapper = winNodeBootstrapper
+
+// Options holds the inputs the bootstrapper is created with. The zero value of a field selects its default.
+type Options struct {
+	// InstallDir is the directory the kubelet and the other node components are installed to, for example C:\k
+	InstallDir string
+	// IgnitionFile is the worker ignition file, or the stub ignition file pointing to the machine config server, the
+	// kubelet files are taken from. Only used by InitializeKubelet.
+	IgnitionFile string
+	// KubeletPath is the kubelet.exe installed to the install dir. Only used by InitializeKubelet.
+	KubeletPath string
+	// KubeconfigPath is the kubeconfig the kubelet writes once its client certificate has been issued, and the other
+	// node components connect to the API server with. Defaults to the kubeconfig file of the install dir.
+	KubeconfigPath string
+	// CNIDir is the directory holding the CNI plugin binaries. It needs to be given along with CNIConfig, and only
+	// for Configure.
+	CNIDir string
+	// CNIConfig is the CNI config file, or the directory of CNI config files. It needs to be given along with CNIDir,
+	// and only for Configure.
+	CNIConfig string
+	// ContainerRuntime is the container runtime the kubelet is configured with, docker or containerd. Defaults to
+	// docker.
+	ContainerRuntime string
+	// ContainerdDir is the directory holding the containerd binaries. Only used with the containerd runtime.
+	ContainerdDir string
+	// Logger is the logger used by the bootstrapper. Defaults to the controller-runtime logger.
+	Logger logr.Logger
+	// Clock is the clock the bootstrap phases are timed and the kubelet certificates are checked with. Defaults to
+	// the system clock.
+	Clock clock.PassiveClock
+	// FS is the file system the files of the node are read and written through. Defaults to the file system of the
+	// host.
+	FS FileSystem
+}
+
+// Option sets a field of the Options the bootstrapper is created with by New
+type Option func(*Options)
+
+// WithInstallDir sets the directory the node components are installed to
+func WithInstallDir(dir string) Option {
+	return func(o *Options) { o.InstallDir = dir }
+}
+
+// WithIgnitionFile sets the ignition file the kubelet files are taken from
+func WithIgnitionFile(path string) Option {
+	return func(o *Options) { o.IgnitionFile = path }
+}
+
+// WithKubeletPath sets the kubelet.exe installed to the install dir
+func WithKubeletPath(path string) Option {
+	return func(o *Options) { o.KubeletPath = path }
+}
+
+// WithKubeconfigPath sets the kubeconfig the kubelet writes and the other node components use
+func WithKubeconfigPath(path string) Option {
+	return func(o *Options) { o.KubeconfigPath = path }
+}
+
+// WithCNI sets the directory of the CNI plugin binaries and the CNI config file or directory
+func WithCNI(dir, config string) Option {
+	return func(o *Options) {
+		o.CNIDir = dir
+		o.CNIConfig = config
+	}
+}
+
+// WithContainerRuntime sets the container runtime, and the directory of the containerd binaries for containerd
+func WithContainerRuntime(runtime, containerdDir string) Option {
+	return func(o *Options) {
+		o.ContainerRuntime = runtime
+		o.ContainerdDir = containerdDir
+	}
+}
+
+// WithLogger sets the logger used by the bootstrapper
+func WithLogger(log logr.Logger) Option {
+	return func(o *Options) { o.Logger = log }
+}
+
+// WithClock sets the clock used by the bootstrapper
+func WithClock(c clock.PassiveClock) Option {
+	return func(o *Options) { o.Clock = c }
+}
+
+// WithFileSystem sets the file system the bootstrapper reads and writes the files of the node through
+func WithFileSystem(fs FileSystem) Option {
+	return func(o *Options) { o.FS = fs }
+}
+
+// OptionError is returned when the bootstrapper is created with an invalid option
+type OptionError struct {
+	// Option is the name of the invalid field of Options
+	Option string
+	// Err describes why the option is invalid
+	Err error
+}
+
+// Error returns the description of the invalid option
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("invalid %s option: %v", e.Option, e.Err)
+}
+
+// Unwrap returns the reason the option is invalid
+func (e *OptionError) Unwrap() error {
+	return e.Err
+}
+
+// New returns a bootstrapper created with the given options, applied in order to the zero Options
+func New(opts ...Option) (*WinNodeBootstrapper, error) {
+	var options Options
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return NewFromOptions(options)
+}
+
+// now returns the current time of the clock of the bootstrapper, or of the system clock if it has none
+func (wmcb *winNodeBootstrapper) now() time.Time {
+	if wmcb.clock == nil {
+		return time.Now()
+	}
+	return wmcb.clock.Now()
+}
+
+// fileSystem returns the file system of the bootstrapper, or the file system of the host if it has none
+func (wmcb *winNodeBootstrapper) fileSystem() FileSystem {
+	return orOSFileSystem(wmcb.fs)
+}
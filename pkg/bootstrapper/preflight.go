@@ -0,0 +1,483 @@
+package bootstrapper
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultMinFreeDisk is the free space in bytes required on the volume of the install directory if the preflight
+	// options do not specify it. The Windows container images alone take several GiB.
+	defaultMinFreeDisk = 20 << 30
+	// defaultMaxClockSkew is the maximum difference between the clocks of the Windows node and the API server if the
+	// preflight options do not specify it
+	defaultMaxClockSkew = time.Minute
+	// preflightDialTimeout is the timeout of the connection attempts made by the preflight checks
+	preflightDialTimeout = 10 * time.Second
+	// apiServerPort is the port the API server listens on
+	apiServerPort = "6443"
+	// kubeletPort is the port the kubelet serves its API on
+	kubeletPort = "10250"
+)
+
+// conflictingServices are the Windows services of other Kubernetes distributions that conflict with the services
+// created by WMCB
+var conflictingServices = []string{"flanneld", "rancher-wins", "CalicoNode", "CalicoFelix"}
+
+// PreflightStatus is the outcome of a preflight check
+type PreflightStatus string
+
+const (
+	// PreflightPassed indicates that the Windows node meets the requirement checked
+	PreflightPassed PreflightStatus = "Passed"
+	// PreflightFailed indicates that the Windows node does not meet the requirement checked
+	PreflightFailed PreflightStatus = "Failed"
+	// PreflightSkipped indicates that the requirement could not be checked with the given inputs
+	PreflightSkipped PreflightStatus = "Skipped"
+)
+
+// PreflightResult is the outcome of a preflight check
+type PreflightResult struct {
+	// Check is the name of the check
+	Check string `json:"check"`
+	// Status is the outcome of the check
+	Status PreflightStatus `json:"status"`
+	// Message describes the outcome of the check
+	Message string `json:"message"`
+}
+
+// PreflightReport holds the outcome of the preflight checks, in the order they were run
+type PreflightReport struct {
+	// Results are the outcomes of the checks
+	Results []PreflightResult `json:"results"`
+}
+
+// Passed returns true if none of the preflight checks failed
+func (r *PreflightReport) Passed() bool {
+	for _, result := range r.Results {
+		if result.Status == PreflightFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// add records the outcome of the check with the given name. The check passed if err is nil, in which case the given
+// message describes the outcome.
+func (r *PreflightReport) add(check, message string, err error) {
+	result := PreflightResult{Check: check, Status: PreflightPassed, Message: message}
+	if err != nil {
+		result.Status = PreflightFailed
+		result.Message = err.Error()
+	}
+	r.Results = append(r.Results, result)
+}
+
+// skip records that the check with the given name was skipped for the given reason
+func (r *PreflightReport) skip(check, reason string) {
+	r.Results = append(r.Results, PreflightResult{Check: check, Status: PreflightSkipped, Message: reason})
+}
+
+// PreflightOptions holds the requirements checked by Preflight
+type PreflightOptions struct {
+	// APIServer is the URL of the API server. It defaults to the URL set with SetBootstrapToken, and otherwise to port
+	// 6443 of the host of the machine config server.
+	APIServer string
+	// HyperV requires the Hyper-V feature to be enabled, for running Hyper-V isolated containers
+	HyperV bool
+	// MinFreeDisk is the free space in bytes required on the volume of the install directory. It defaults to 20 GiB.
+	MinFreeDisk uint64
+	// MinDiskSize is the size in bytes required of the volume of the install directory. The size is not checked if it
+	// is 0.
+	MinDiskSize uint64
+	// MaxClockSkew is the maximum difference between the clocks of the Windows node and the API server. It defaults
+	// to one minute.
+	MaxClockSkew time.Duration
+}
+
+// Preflight checks that the Windows node can be bootstrapped, without making any change to it: the Windows features
+// required by the container runtime are enabled along with the HNS and HCS services, the volume of the install
+// directory is large enough and has enough free space for the kubelet not to evict the pods right away given its
+// eviction thresholds, the clock is in sync with the API server, the API server and machine config server resolve and
+// are reachable, the proxy is reachable and bypassed for the API server, no conflicting Windows service is installed,
+// no antivirus, EDR agent, VPN client or filter driver is configured in a way known to break the node, the directories
+// of the node components are excluded from the scanning of the running antivirus and EDR agents, and, once the kubelet is initialized, the install directory and the credentials are only accessible by SYSTEM and the
+// Administrators. The machine config server is taken from the ignition URL or the stub ignition file, and the proxy
+// from SetProxy or the HTTPS_PROXY and NO_PROXY environment variables. The checks whose inputs are not known are
+// skipped.
+func (wmcb *winNodeBootstrapper) Preflight(ctx context.Context, options PreflightOptions) *PreflightReport {
+	if options.MinFreeDisk == 0 {
+		options.MinFreeDisk = defaultMinFreeDisk
+	}
+	if options.MaxClockSkew == 0 {
+		options.MaxClockSkew = defaultMaxClockSkew
+	}
+	report := &PreflightReport{}
+
+	for _, feature := range requiredWindowsFeatures(options.HyperV) {
+		report.add("WindowsFeature/"+feature, feature+" is enabled", checkWindowsFeature(feature))
+	}
+	for _, name := range containerServices {
+		report.add("Service/"+name, name+" service is installed and enabled", wmcb.checkContainerService(name))
+	}
+	free, total, diskErr := diskSpace(wmcb.installDir)
+	err := diskErr
+	if err == nil && options.MinDiskSize != 0 && total < options.MinDiskSize {
+		err = fmt.Errorf("the volume of %s has %d MiB, %d MiB required", wmcb.installDir, total>>20,
+			options.MinDiskSize>>20)
+	}
+	report.add("DiskSize", fmt.Sprintf("the volume of %s has %d MiB", wmcb.installDir, total>>20), err)
+	err = diskErr
+	if err == nil && free < options.MinFreeDisk {
+		err = fmt.Errorf("%d MiB free on the volume of %s, %d MiB required", free>>20, wmcb.installDir,
+			options.MinFreeDisk>>20)
+	}
+	report.add("DiskSpace", fmt.Sprintf("%d MiB free on the volume of %s", free>>20, wmcb.installDir), err)
+	err = diskErr
+	if err == nil {
+		err = checkEvictionThresholds(wmcb.evictionOptions(), free, total)
+	}
+	report.add("EvictionThresholds", "the kubelet eviction and image GC thresholds fit the volume of "+
+		wmcb.installDir, err)
+
+	mcsURL, apiServerURL, err := wmcb.preflightEndpoints(options.APIServer)
+	if err != nil {
+		report.add("Endpoints", "", err)
+	}
+	endpoints := map[string]*url.URL{"APIServer": apiServerURL, "MachineConfigServer": mcsURL}
+	for _, name := range []string{"APIServer", "MachineConfigServer"} {
+		endpoint := endpoints[name]
+		if endpoint == nil {
+			reason := "unknown endpoint, set the ignition file or URL"
+			if name == "APIServer" {
+				reason += " or the API server"
+			}
+			report.skip("DNS/"+name, reason)
+			report.skip("Reachability/"+name, reason)
+			continue
+		}
+		report.add("DNS/"+name, endpoint.Hostname()+" resolves", checkDNS(ctx, endpoint.Hostname()))
+		report.add("Reachability/"+name, endpoint.Host+" is reachable", checkReachability(ctx, endpoint.Host))
+	}
+	if apiServerURL == nil {
+		report.skip("ClockSkew", "unknown API server")
+	} else {
+		skew, err := clockSkew(ctx, apiServerURL)
+		if err == nil && (skew > options.MaxClockSkew || skew < -options.MaxClockSkew) {
+			err = fmt.Errorf("clock is %v off the API server, more than %v, which makes the kubelet certificates "+
+				"invalid", skew, options.MaxClockSkew)
+		}
+		report.add("ClockSkew", fmt.Sprintf("clock is %v off the API server", skew), err)
+	}
+
+	proxy := wmcb.proxy
+	if proxy.isEmpty() {
+		proxy = proxyConfig{httpsProxy: os.Getenv("HTTPS_PROXY"), noProxy: os.Getenv("NO_PROXY")}
+	}
+	if proxy.httpsProxy == "" {
+		report.skip("Proxy", "no HTTPS proxy configured")
+	} else {
+		report.add("Proxy", hostPort(proxy.httpsProxy)+" is reachable", checkProxy(ctx, proxy, apiServerURL))
+	}
+
+	report.add("ConflictingServices", "no conflicting service installed", wmcb.checkConflictingServices())
+	securityReport, err := wmcb.DetectSecuritySoftware()
+	if err != nil {
+		report.add("SecuritySoftware", "", err)
+		report.skip("ScanExclusions", "security software not detected")
+	} else {
+		report.add("SecuritySoftware", securitySoftwareSummary(securityReport), checkSecuritySoftware(securityReport))
+		message, err := checkScanExclusions(securityReport)
+		if message == "" && err == nil {
+			report.skip("ScanExclusions", "no running antivirus or EDR agent")
+		} else {
+			report.add("ScanExclusions", message, err)
+		}
+	}
+	// The access to the install directory is only restricted by InitializeKubelet
+	if wmcb.kubeletSVC == nil {
+		report.skip("ACL", "kubelet not initialized")
+	} else {
+		report.add("ACL", wmcb.installDir+" and the credentials are only accessible by SYSTEM and the Administrators",
+			wmcb.checkACLs())
+	}
+	return report
+}
+
+// preflightEndpoints returns the URLs of the machine config server and of the API server, which are nil if they are
+// not known. The API server defaults to the given API server, then to the one the bootstrap kubeconfig is generated
+// for, and then to the host of the machine config server.
+func (wmcb *winNodeBootstrapper) preflightEndpoints(apiServer string) (*url.URL, *url.URL, error) {
+	var mcsURL *url.URL
+	endpoint := wmcb.ignitionEndpoint
+	if endpoint == nil && wmcb.ignitionFilePath != "" {
+		contents, err := readFile(wmcb.fileSystem(), wmcb.ignitionFilePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read ignition file: %v", err)
+		}
+		if endpoint, err = stubIgnitionEndpoint(contents); err != nil {
+			return nil, nil, fmt.Errorf("could not parse ignition file: %v", err)
+		}
+	}
+	if endpoint != nil {
+		var err error
+		if mcsURL, err = parseHTTPSURL(endpoint.url); err != nil {
+			return nil, nil, fmt.Errorf("invalid ignition URL: %v", err)
+		}
+	}
+
+	if apiServer == "" && wmcb.bootstrapCredentials != nil {
+		apiServer = wmcb.bootstrapCredentials.apiServer
+	}
+	if apiServer != "" {
+		apiServerURL, err := parseHTTPSURL(apiServer)
+		if err != nil {
+			return mcsURL, nil, fmt.Errorf("invalid API server URL: %v", err)
+		}
+		return mcsURL, apiServerURL, nil
+	}
+	if mcsURL == nil {
+		return nil, nil, nil
+	}
+	// The machine config server is served by the internal API load balancer of the cluster
+	return mcsURL, &url.URL{Scheme: "https", Host: net.JoinHostPort(mcsURL.Hostname(), apiServerPort)}, nil
+}
+
+// securitySoftwareSummary describes the interfering software found on the node
+func securitySoftwareSummary(report *SecurityReport) string {
+	if len(report.Software) == 0 {
+		return "no antivirus, EDR agent, VPN client or filter driver found"
+	}
+	var found []string
+	for _, software := range report.Software {
+		found = append(found, fmt.Sprintf("%s %s", software.Kind, software.Name))
+	}
+	return "found " + strings.Join(found, ", ")
+}
+
+// checkSecuritySoftware returns an error listing the known incompatible configurations of the interfering software
+func checkSecuritySoftware(report *SecurityReport) error {
+	if incompatibilities := report.Incompatibilities(); len(incompatibilities) > 0 {
+		return fmt.Errorf("%s", strings.Join(incompatibilities, ", "))
+	}
+	return nil
+}
+
+// checkScanExclusions returns an error if a directory of the node components is not excluded from the scanning of a
+// running antivirus or EDR agent. The message describes the agents whose exclusions are checked, and is empty if no
+// agent is running. The agents whose exclusions cannot be read are listed in the message without failing the check.
+func checkScanExclusions(report *SecurityReport) (string, error) {
+	var checked, unknown, scanned []string
+	for _, software := range report.Software {
+		if !software.Running || len(software.Exclusions) == 0 {
+			continue
+		}
+		var dirs []string
+		known := false
+		for dir, state := range software.Exclusions {
+			known = known || state != ExclusionUnknown
+			if state == NotExcluded {
+				dirs = append(dirs, dir)
+			}
+		}
+		sort.Strings(dirs)
+		switch {
+		case len(dirs) > 0:
+			scanned = append(scanned, fmt.Sprintf("%s scans %s", software.Name, strings.Join(dirs, ", ")))
+		case !known:
+			unknown = append(unknown, software.Name)
+		default:
+			checked = append(checked, software.Name)
+		}
+	}
+	if len(checked)+len(unknown)+len(scanned) == 0 {
+		return "", nil
+	}
+	var messages []string
+	if len(checked) > 0 {
+		messages = append(messages, "the node directories are excluded from the scanning of "+
+			strings.Join(checked, ", "))
+	}
+	if len(unknown) > 0 {
+		messages = append(messages, "the exclusions of "+strings.Join(unknown, ", ")+" need to be checked in "+
+			"their management console")
+	}
+	message := strings.Join(messages, ", ")
+	if len(scanned) > 0 {
+		return message, fmt.Errorf("%s, run configure-host-security or exclude them in the agent",
+			strings.Join(scanned, ", "))
+	}
+	return message, nil
+}
+
+// checkWindowsFeature returns an error if the given Windows optional feature is not enabled
+func checkWindowsFeature(feature string) error {
+	out, err := runPowerShell("(Get-WindowsOptionalFeature -Online -FeatureName " + feature + ").State")
+	if err != nil {
+		return err
+	}
+	if state := strings.TrimSpace(out); state != "Enabled" {
+		return fmt.Errorf("%s is not enabled, its state is %q", feature, state)
+	}
+	return nil
+}
+
+// checkDNS returns an error if the given host does not resolve
+func checkDNS(ctx context.Context, host string) error {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	addresses, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return fmt.Errorf("%s does not resolve: %v", host, err)
+	}
+	if len(addresses) == 0 {
+		return fmt.Errorf("%s resolves to no address", host)
+	}
+	return nil
+}
+
+// checkReachability returns an error if no TCP connection can be opened to the given host and port
+func checkReachability(ctx context.Context, hostPort string) error {
+	dialer := net.Dialer{Timeout: preflightDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", hostPort)
+	if err != nil {
+		return fmt.Errorf("%s is not reachable: %v", hostPort, err)
+	}
+	return conn.Close()
+}
+
+// clockSkew returns the difference between the clock of the Windows node and the Date header of the response of the
+// given API server. The serving certificate of the API server is not verified, as only the date is read and a skewed
+// clock can make the certificate look invalid.
+func clockSkew(ctx context.Context, apiServer *url.URL) (time.Duration, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	client := &http.Client{Transport: transport, Timeout: preflightDialTimeout}
+	versionURL := *apiServer
+	versionURL.Path = "/version"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, versionURL.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("could not reach API server: %v", err)
+	}
+	resp.Body.Close()
+	// The date is read half way through the request on average
+	local := start.Add(time.Since(start) / 2)
+	serverDate, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid Date header in the API server response: %v", err)
+	}
+	// The Date header has a resolution of a second
+	return local.Sub(serverDate).Round(time.Second), nil
+}
+
+// checkProxy returns an error if the HTTPS proxy of the given settings is not reachable, or if the given API server is
+// not in the no proxy list, as the nodes need to reach the API server directly
+func checkProxy(ctx context.Context, proxy proxyConfig, apiServer *url.URL) error {
+	if err := checkReachability(ctx, hostPort(proxy.httpsProxy)); err != nil {
+		return fmt.Errorf("proxy %v", err)
+	}
+	if apiServer != nil && !proxy.bypasses(apiServer.Hostname()) {
+		return fmt.Errorf("API server %s is not in the no proxy list %q", apiServer.Hostname(), proxy.noProxy)
+	}
+	return nil
+}
+
+// bypasses returns true if requests to the given host are not proxied as per the no proxy list. The list holds host
+// names, domains matching their subdomains, CIDRs and IP addresses, optionally with a port, or * to bypass all hosts.
+func (p proxyConfig) bypasses(host string) bool {
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(p.noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if entryHost, _, err := net.SplitHostPort(entry); err == nil {
+			entry = entryHost
+		}
+		host := strings.ToLower(host)
+		domain := strings.TrimPrefix(entry, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkConflictingServices returns an error if a Windows service of another Kubernetes distribution is installed, if
+// a Windows service WMCB creates was installed from outside the install directory, or if the kubelet port is used by
+// another process than the kubelet installed by WMCB
+func (wmcb *winNodeBootstrapper) checkConflictingServices() error {
+	var conflicts []string
+	for _, name := range conflictingServices {
+		service, err := wmcb.svcMgr.OpenService(name)
+		if err != nil {
+			continue
+		}
+		service.Close()
+		conflicts = append(conflicts, name+" service is installed")
+	}
+
+	installDir, err := filepath.Abs(wmcb.installDir)
+	if err != nil {
+		return err
+	}
+	kubeletInstalled := false
+	for _, name := range []string{KubeletServiceName, kubeProxyServiceName, kubeletDependentSvc,
+		cloudNodeManagerServiceName} {
+		service, err := wmcb.svcMgr.OpenService(name)
+		if err != nil {
+			continue
+		}
+		config, err := service.Config()
+		service.Close()
+		if err != nil {
+			return fmt.Errorf("could not get config of %s service: %v", name, err)
+		}
+		binaryPath := strings.ToLower(strings.Trim(config.BinaryPathName, `"`))
+		if !strings.HasPrefix(binaryPath, strings.ToLower(installDir)) {
+			conflicts = append(conflicts, fmt.Sprintf("%s service runs %s, outside of %s", name,
+				config.BinaryPathName, installDir))
+			continue
+		}
+		if name == KubeletServiceName {
+			kubeletInstalled = true
+		}
+	}
+
+	if !kubeletInstalled {
+		listener, err := net.Listen("tcp", ":"+kubeletPort)
+		if err != nil {
+			conflicts = append(conflicts, "port "+kubeletPort+" is in use")
+		} else {
+			listener.Close()
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%s", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
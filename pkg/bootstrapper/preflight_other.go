@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package bootstrapper
+
+// diskSpace returns errUnsupportedPlatform as the Windows volumes cannot be queried
+func diskSpace(dir string) (free, total uint64, err error) {
+	return 0, 0, errUnsupportedPlatform
+}
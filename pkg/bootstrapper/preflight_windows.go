@@ -0,0 +1,26 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// diskSpace returns the free space available and the total size in bytes of the volume of the given directory, which
+// does not need to exist
+func diskSpace(dir string) (free, total uint64, err error) {
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	root, err := windows.UTF16PtrFromString(filepath.VolumeName(dir) + `\`)
+	if err != nil {
+		return 0, 0, err
+	}
+	var totalFree uint64
+	if err = windows.GetDiskFreeSpaceEx(root, &free, &total, &totalFree); err != nil {
+		return 0, 0, fmt.Errorf("could not get free space of the volume of %s: %v", dir, err)
+	}
+	return free, total, nil
+}
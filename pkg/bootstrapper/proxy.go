@@ -0,0 +1,196 @@
+package bootstrapper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// proxyEnvFile is the systemd configuration file in the ignition file that holds the cluster-wide proxy settings
+	proxyEnvFile = "/etc/systemd/system.conf.d/10-default-env.conf"
+	// serviceRegistryPath is the registry path under which the configuration of the Windows services is stored
+	serviceRegistryPath = `SYSTEM\CurrentControlSet\Services\`
+)
+
+// proxyConfig holds the cluster-wide proxy settings
+type proxyConfig struct {
+	// httpProxy is the proxy used for HTTP requests
+	httpProxy string
+	// httpsProxy is the proxy used for HTTPS requests
+	httpsProxy string
+	// noProxy is the comma separated list of hosts, domains and CIDRs that are not proxied
+	noProxy string
+}
+
+// SetProxy sets the proxy settings the kubelet and the container runtime are configured with. Settings that are empty
+// are taken from the cluster-wide proxy settings in the ignition file. This needs to be called before InitializeKubelet
+// for the settings to take effect.
+func (wmcb *winNodeBootstrapper) SetProxy(httpProxy, httpsProxy, noProxy string) error {
+	for _, proxy := range []string{httpProxy, httpsProxy} {
+		if proxy == "" {
+			continue
+		}
+		if _, err := url.Parse(proxy); err != nil {
+			return fmt.Errorf("invalid proxy %s: %v", proxy, err)
+		}
+	}
+	if strings.ContainsAny(noProxy, " \t") {
+		return fmt.Errorf("invalid no proxy list %s: expected a comma separated list", noProxy)
+	}
+	wmcb.proxy = proxyConfig{
+		httpProxy:  httpProxy,
+		httpsProxy: httpsProxy,
+		noProxy:    noProxy,
+	}
+	return nil
+}
+
+// parseProxyEnv returns the proxy settings from the DefaultEnvironment entry of the given systemd configuration
+func parseProxyEnv(contents []byte) proxyConfig {
+	var proxy proxyConfig
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "DefaultEnvironment=") {
+			continue
+		}
+		for _, variable := range strings.Fields(strings.TrimPrefix(line, "DefaultEnvironment=")) {
+			keyValue := strings.SplitN(strings.Trim(variable, `"`), "=", 2)
+			if len(keyValue) != 2 {
+				continue
+			}
+			switch strings.ToUpper(keyValue[0]) {
+			case "HTTP_PROXY":
+				proxy.httpProxy = keyValue[1]
+			case "HTTPS_PROXY":
+				proxy.httpsProxy = keyValue[1]
+			case "NO_PROXY":
+				proxy.noProxy = keyValue[1]
+			}
+		}
+	}
+	return proxy
+}
+
+// merge fills the settings that are not set with the given proxy settings
+func (p *proxyConfig) merge(other proxyConfig) {
+	if p.httpProxy == "" {
+		p.httpProxy = other.httpProxy
+	}
+	if p.httpsProxy == "" {
+		p.httpsProxy = other.httpsProxy
+	}
+	if p.noProxy == "" {
+		p.noProxy = other.noProxy
+	}
+}
+
+// isEmpty returns true if no proxy has been configured
+func (p proxyConfig) isEmpty() bool {
+	return p.httpProxy == "" && p.httpsProxy == ""
+}
+
+// environment returns the environment variables that configure the proxy for the Windows services
+func (p proxyConfig) environment() []string {
+	var env []string
+	if p.httpProxy != "" {
+		env = append(env, "HTTP_PROXY="+p.httpProxy)
+	}
+	if p.httpsProxy != "" {
+		env = append(env, "HTTPS_PROXY="+p.httpsProxy)
+	}
+	if p.noProxy != "" {
+		env = append(env, "NO_PROXY="+p.noProxy)
+	}
+	return env
+}
+
+// winHTTPProxyArgs returns the netsh arguments that configure the machine-level WinHTTP proxy
+func (p proxyConfig) winHTTPProxyArgs() []string {
+	var servers []string
+	if p.httpProxy != "" {
+		servers = append(servers, "http="+hostPort(p.httpProxy))
+	}
+	if p.httpsProxy != "" {
+		servers = append(servers, "https="+hostPort(p.httpsProxy))
+	}
+	args := []string{"winhttp", "set", "proxy", "proxy-server=" + strings.Join(servers, ";")}
+	if p.noProxy != "" {
+		// WinHTTP expects a semicolon separated bypass list
+		args = append(args, "bypass-list="+strings.ReplaceAll(p.noProxy, ",", ";"))
+	}
+	return args
+}
+
+// hostPort returns the host and port of the given proxy URL, as WinHTTP does not accept the scheme
+func hostPort(proxy string) string {
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return proxy
+	}
+	return u.Host
+}
+
+// configureWinHTTPProxy sets the machine-level WinHTTP proxy, which is used by Windows components like Windows Update
+// and the certificate revocation checks
+func configureWinHTTPProxy(ctx context.Context, proxy proxyConfig) error {
+	out, err := exec.CommandContext(ctx, "netsh", proxy.winHTTPProxyArgs()...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error setting WinHTTP proxy: %v: %s", err, out)
+	}
+	return nil
+}
+
+// configureProxy configures the kubelet and the container runtime services, as well as the machine-level WinHTTP
+// proxy, with the proxy settings. The kubelet is marked for a restart if its environment changed, and the container
+// runtime service is restarted if its environment changed.
+func (wmcb *winNodeBootstrapper) configureProxy(ctx context.Context) error {
+	if wmcb.proxy.isEmpty() {
+		return nil
+	}
+	runtimeService := wmcb.runtimeServiceName()
+	wmcb.log.Info("configuring proxy", "httpProxy", wmcb.proxy.httpProxy, "httpsProxy", wmcb.proxy.httpsProxy,
+		"noProxy", wmcb.proxy.noProxy)
+
+	if err := configureWinHTTPProxy(ctx, wmcb.proxy); err != nil {
+		return err
+	}
+
+	changed, err := setServiceEnvironment(wmcb.journal, KubeletServiceName, wmcb.proxy.environment())
+	if err != nil {
+		return err
+	}
+	if changed {
+		wmcb.kubeletRestartRequired = true
+	}
+
+	changed, err = setServiceEnvironment(wmcb.journal, runtimeService, wmcb.proxy.environment())
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+	service, err := wmcb.svcMgr.OpenService(runtimeService)
+	if err != nil {
+		return fmt.Errorf("error opening %s service: %v", runtimeService, err)
+	}
+	defer service.Close()
+	// The kubelet depends on the container runtime and needs to be stopped first. It is started again by the caller.
+	if err = wmcb.kubeletSVC.stop(); err != nil {
+		return fmt.Errorf("unable to stop kubelet service: %v", err)
+	}
+	wmcb.kubeletRestartRequired = true
+	if err = stopService(service); err != nil {
+		return fmt.Errorf("unable to stop %s service: %v", runtimeService, err)
+	}
+	if err = startService(service); err != nil {
+		return fmt.Errorf("unable to start %s service: %v", runtimeService, err)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package bootstrapper
+
+// setServiceEnvironment returns errUnsupportedPlatform as there is no registry to set the environment in
+func setServiceEnvironment(j *journal, serviceName string, env []string) (bool, error) {
+	return false, errUnsupportedPlatform
+}
+
+// serviceEnvironment returns errUnsupportedPlatform as there is no registry to read the environment from
+func serviceEnvironment(serviceName string) ([]string, error) {
+	return nil, errUnsupportedPlatform
+}
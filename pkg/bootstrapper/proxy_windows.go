@@ -0,0 +1,50 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// setServiceEnvironment sets the environment variables of the given Windows service, replacing the existing ones, and
+// records the change in the given journal. Returns true if the environment changed, in which case the service needs
+// to be restarted for it to take effect.
+func setServiceEnvironment(j *journal, serviceName string, env []string) (bool, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, serviceRegistryPath+serviceName,
+		registry.QUERY_VALUE|registry.SET_VALUE)
+	if err != nil {
+		return false, fmt.Errorf("error opening registry key of %s service: %v", serviceName, err)
+	}
+	defer key.Close()
+
+	existingEnv, _, err := key.GetStringsValue("Environment")
+	if err != nil && err != registry.ErrNotExist {
+		return false, fmt.Errorf("error reading environment of %s service: %v", serviceName, err)
+	}
+	if strings.Join(existingEnv, "\n") == strings.Join(env, "\n") {
+		return false, nil
+	}
+	if err = j.serviceEnvironmentSet(serviceName, existingEnv); err != nil {
+		return false, err
+	}
+	if err = key.SetStringsValue("Environment", env); err != nil {
+		return false, fmt.Errorf("error setting environment of %s service: %v", serviceName, err)
+	}
+	return true, nil
+}
+
+// serviceEnvironment returns the environment variables of the given Windows service
+func serviceEnvironment(serviceName string) ([]string, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, serviceRegistryPath+serviceName, registry.QUERY_VALUE)
+	if err != nil {
+		return nil, fmt.Errorf("error opening registry key of %s service: %v", serviceName, err)
+	}
+	defer key.Close()
+
+	env, _, err := key.GetStringsValue("Environment")
+	if err != nil && err != registry.ErrNotExist {
+		return nil, fmt.Errorf("error reading environment of %s service: %v", serviceName, err)
+	}
+	return env, nil
+}
@@ -0,0 +1,123 @@
+package bootstrapper
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultRestartDelay is the time the SCM waits before restarting a failed service
+	defaultRestartDelay = 5 * time.Second
+	// defaultRecoveryResetPeriod is the time without failures after which the SCM resets the failure count of a service
+	defaultRecoveryResetPeriod = 10 * time.Minute
+)
+
+// recoveryServices are the Windows services created by WMCB whose recovery settings are managed by WMCB
+var recoveryServices = []string{KubeletServiceName, kubeProxyServiceName, kubeletDependentSvc,
+	cloudNodeManagerServiceName}
+
+// serviceRecovery holds the settings the SCM uses to recover a Windows service created by WMCB when it fails
+type serviceRecovery struct {
+	// restart is set if the service is restarted when it fails. The service stays down on failure otherwise.
+	restart bool
+	// restartDelay is the time the SCM waits before restarting the service
+	restartDelay time.Duration
+	// resetPeriod is the time without failures after which the failure count of the service is reset
+	resetPeriod time.Duration
+}
+
+// defaultServiceRecovery returns the recovery settings the Windows services are created with unless they are set
+// using SetServiceRecovery
+func defaultServiceRecovery() serviceRecovery {
+	return serviceRecovery{
+		restart:      true,
+		restartDelay: defaultRestartDelay,
+		resetPeriod:  defaultRecoveryResetPeriod,
+	}
+}
+
+// actions returns the recovery actions the SCM performs when the service fails. The last action is repeated on every
+// subsequent failure, so a single restart action restarts the service on every failure.
+func (r serviceRecovery) actions() []recoveryAction {
+	if !r.restart {
+		return nil
+	}
+	return []recoveryAction{{Type: serviceRestart, Delay: r.restartDelay}}
+}
+
+// apply sets the recovery settings on the given service
+func (r serviceRecovery) apply(service service) error {
+	if service == nil {
+		return fmt.Errorf("service object should not be nil")
+	}
+	actions := r.actions()
+	if len(actions) == 0 {
+		return service.ResetRecoveryActions()
+	}
+	return service.SetRecoveryActions(actions, uint32(r.resetPeriod/time.Second))
+}
+
+// SetServiceRecovery sets whether the kubelet, kube-proxy, hybrid-overlay-node and cloud-node-manager Windows services
+// are restarted by the SCM when they fail, the delay before they are restarted, and the period without failures after
+// which their failure count is reset. By default the services are restarted after 5 seconds and their failure count is
+// reset after 10 minutes. This needs to be called before InitializeKubelet, Configure, ConfigureKubeProxy or
+// UpdateServiceRecovery to take effect.
+func (wmcb *winNodeBootstrapper) SetServiceRecovery(restartOnFailure bool, restartDelay,
+	resetPeriod time.Duration) error {
+	// The SCM takes the delay in milliseconds and the reset period in seconds as 32 bit integers
+	if restartDelay < 0 || restartDelay/time.Millisecond > math.MaxUint32 {
+		return fmt.Errorf("invalid restart delay %s", restartDelay)
+	}
+	if resetPeriod < time.Second || resetPeriod/time.Second > math.MaxUint32 {
+		return fmt.Errorf("invalid reset period %s: expected at least 1s", resetPeriod)
+	}
+	wmcb.serviceRecovery = serviceRecovery{
+		restart:      restartOnFailure,
+		restartDelay: restartDelay,
+		resetPeriod:  resetPeriod,
+	}
+	return nil
+}
+
+// UpdateServiceRecovery applies the recovery settings to the given Windows services created by WMCB, which need to be
+// installed. The settings are applied to all of the kubelet, kube-proxy, hybrid-overlay-node and cloud-node-manager
+// services that are installed if no service is given.
+func (wmcb *winNodeBootstrapper) UpdateServiceRecovery(serviceNames []string) error {
+	required := len(serviceNames) != 0
+	if !required {
+		serviceNames = recoveryServices
+	}
+	for _, name := range serviceNames {
+		if !isRecoveryService(name) {
+			return fmt.Errorf("unsupported service %s, expected one of %s", name,
+				strings.Join(recoveryServices, ", "))
+		}
+		service, err := wmcb.svcMgr.OpenService(name)
+		if err != nil {
+			if !required && errors.Is(err, errServiceDoesNotExist) {
+				continue
+			}
+			return fmt.Errorf("error getting %s service: %v", name, err)
+		}
+		err = wmcb.serviceRecovery.apply(service)
+		service.Close()
+		if err != nil {
+			return fmt.Errorf("failed to set recovery actions for Windows service %s: %v", name, err)
+		}
+		wmcb.log.Info("updated service recovery", "service", name, "restart", wmcb.serviceRecovery.restart)
+	}
+	return nil
+}
+
+// isRecoveryService returns true if the recovery settings of the given service are managed by WMCB
+func isRecoveryService(name string) bool {
+	for _, service := range recoveryServices {
+		if name == service {
+			return true
+		}
+	}
+	return false
+}
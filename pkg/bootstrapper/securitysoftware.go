@@ -0,0 +1,229 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SecuritySoftwareKind is the kind of a software interfering with the node components
+type SecuritySoftwareKind string
+
+const (
+	// Antivirus is an antivirus scanning the files written by the container runtime
+	Antivirus SecuritySoftwareKind = "Antivirus"
+	// EDR is an endpoint detection and response agent hooking the processes and the network stack of the node
+	EDR SecuritySoftwareKind = "EDR"
+	// VPN is a VPN client changing the routes and DNS servers of the node
+	VPN SecuritySoftwareKind = "VPN"
+	// FilterDriver is a third-party network filter driver bound to the virtual switch of the HNS networks
+	FilterDriver SecuritySoftwareKind = "FilterDriver"
+)
+
+// ExclusionState tells whether a directory is excluded from the scanning of an antivirus or EDR agent
+type ExclusionState string
+
+const (
+	// Excluded indicates that the directory is excluded from the scanning
+	Excluded ExclusionState = "Excluded"
+	// NotExcluded indicates that the directory is scanned
+	NotExcluded ExclusionState = "NotExcluded"
+	// ExclusionUnknown indicates that the exclusions of the agent cannot be read by WMCB, and need to be checked in its
+	// management console
+	ExclusionUnknown ExclusionState = "Unknown"
+)
+
+// defenderServiceName is the name of the Windows service of Microsoft Defender Antivirus
+const defenderServiceName = "WinDefend"
+
+// knownSecuritySoftware are the Windows services of the antivirus, EDR agents and VPN clients commonly found on
+// Windows nodes
+var knownSecuritySoftware = []struct {
+	// name is the product name
+	name string
+	// kind is the kind of the product
+	kind SecuritySoftwareKind
+	// service is the name of the Windows service of the product
+	service string
+}{
+	{name: "Microsoft Defender Antivirus", kind: Antivirus, service: defenderServiceName},
+	{name: "Microsoft Defender for Endpoint", kind: EDR, service: "Sense"},
+	{name: "CrowdStrike Falcon", kind: EDR, service: "CSFalconService"},
+	{name: "SentinelOne", kind: EDR, service: "SentinelAgent"},
+	{name: "VMware Carbon Black", kind: EDR, service: "CbDefense"},
+	{name: "Symantec Endpoint Protection", kind: Antivirus, service: "SepMasterService"},
+	{name: "Trellix Endpoint Security", kind: Antivirus, service: "mfemms"},
+	{name: "Sophos Anti-Virus", kind: Antivirus, service: "SAVService"},
+	{name: "Trend Micro Deep Security", kind: Antivirus, service: "ds_agent"},
+	{name: "Cisco AnyConnect", kind: VPN, service: "vpnagent"},
+	{name: "Palo Alto GlobalProtect", kind: VPN, service: "PanGPS"},
+	{name: "FortiClient", kind: VPN, service: "FA_Scheduler"},
+	{name: "OpenVPN", kind: VPN, service: "OpenVPNService"},
+	{name: "WireGuard", kind: VPN, service: "WireGuardManager"},
+}
+
+// adapterBindingsCommand lists the enabled network components bound to the vEthernet adapters of the virtual switches
+// of the HNS networks, other than the Microsoft ones, one per line as <adapter>|<component ID>|<display name>
+const adapterBindingsCommand = "Get-NetAdapterBinding -Name 'vEthernet*' -ErrorAction SilentlyContinue | " +
+	"Where-Object { $_.Enabled -and $_.ComponentID -notlike 'ms_*' -and $_.ComponentID -notlike 'vms_*' } | " +
+	"ForEach-Object { \"$($_.Name)|$($_.ComponentID)|$($_.DisplayName)\" }"
+
+// SecuritySoftware is a software that is known to interfere with the node components
+type SecuritySoftware struct {
+	// Name is the name of the product, or of the filter driver
+	Name string `json:"name"`
+	// Kind is the kind of the software
+	Kind SecuritySoftwareKind `json:"kind"`
+	// Component is the Windows service of the software, or the component ID and adapter of the filter driver
+	Component string `json:"component"`
+	// Running indicates that the Windows service of the software is running, or that the filter driver is enabled
+	Running bool `json:"running"`
+	// Exclusions maps the directories of the node components to their exclusion from the scanning of the antivirus or
+	// EDR agent
+	Exclusions map[string]ExclusionState `json:"exclusions,omitempty"`
+	// Incompatible describes why the configuration of the software is known to break the node, if it is
+	Incompatible string `json:"incompatible,omitempty"`
+}
+
+// SecurityReport is the compatibility report of the software interfering with the node components, which is
+// recorded in the status file
+type SecurityReport struct {
+	// Software are the interfering software found on the node
+	Software []SecuritySoftware `json:"software"`
+	// Timestamp is the time at which the report was made
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Incompatibilities returns the descriptions of the known incompatible configurations found on the node
+func (r *SecurityReport) Incompatibilities() []string {
+	var incompatibilities []string
+	for _, software := range r.Software {
+		if software.Incompatible != "" {
+			incompatibilities = append(incompatibilities, fmt.Sprintf("%s (%s): %s", software.Name,
+				software.Component, software.Incompatible))
+		}
+	}
+	return incompatibilities
+}
+
+// scannedDirs returns the directories of the node components that need to be excluded from the scanning of the
+// antivirus and EDR agents, as scanning the container layers and CNI configs as they are written slows down, or
+// fails, the start of the containers
+func (wmcb *winNodeBootstrapper) scannedDirs() []string {
+	layout := wmcb.cniLayout()
+	return []string{wmcb.installDir, wmcb.containerdInstallDir(), layout.binDir, layout.confDir}
+}
+
+// dirExclusions returns the exclusion state of each of the given directories given the paths excluded from the
+// scanning. A directory is excluded if it or one of its parents is.
+func dirExclusions(dirs, excludedPaths []string) map[string]ExclusionState {
+	exclusions := make(map[string]ExclusionState)
+	for _, dir := range dirs {
+		exclusions[dir] = NotExcluded
+		for _, path := range excludedPaths {
+			path = strings.TrimRight(strings.TrimSpace(path), `\/`)
+			if path == "" {
+				continue
+			}
+			if relative, err := filepath.Rel(strings.ToLower(path), strings.ToLower(dir)); err == nil &&
+				relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+				exclusions[dir] = Excluded
+				break
+			}
+		}
+	}
+	return exclusions
+}
+
+// parseAdapterBindings returns the filter drivers listed by adapterBindingsCommand
+func parseAdapterBindings(out string) []SecuritySoftware {
+	var drivers []SecuritySoftware
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), "|", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		drivers = append(drivers, SecuritySoftware{
+			Name:      fields[2],
+			Kind:      FilterDriver,
+			Component: fields[1] + " on " + fields[0],
+			Running:   true,
+			Incompatible: "third-party filter drivers bound to the virtual switch of the HNS networks drop or " +
+				"rewrite the VXLAN and pod traffic, unbind it from the vEthernet adapters",
+		})
+	}
+	return drivers
+}
+
+// defenderExclusionPaths returns the paths excluded from the Microsoft Defender Antivirus scanning
+func defenderExclusionPaths() ([]string, error) {
+	out, err := runPowerShell("(Get-MpPreference).ExclusionPath")
+	if err != nil {
+		return nil, fmt.Errorf("error reading Microsoft Defender exclusions: %v", err)
+	}
+	var paths []string
+	for _, line := range strings.Split(out, "\n") {
+		if path := strings.TrimSpace(line); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// installedSecuritySoftware returns the known antivirus, EDR agents and VPN clients whose Windows service is installed.
+// The scanning exclusions of the node directories are read for Microsoft Defender, and are unknown for the other
+// antivirus and EDR agents. A running VPN client is reported as incompatible.
+func (wmcb *winNodeBootstrapper) installedSecuritySoftware() []SecuritySoftware {
+	var found []SecuritySoftware
+	for _, known := range knownSecuritySoftware {
+		service, err := wmcb.svcMgr.OpenService(known.service)
+		if err != nil {
+			continue
+		}
+		running, err := isServiceRunning(service)
+		service.Close()
+		if err != nil {
+			wmcb.log.Error(err, "unable to query service", "service", known.service)
+		}
+		software := SecuritySoftware{Name: known.name, Kind: known.kind, Component: known.service, Running: running}
+		switch known.kind {
+		case Antivirus, EDR:
+			var excludedPaths []string
+			if known.service == defenderServiceName && running {
+				if excludedPaths, err = defenderExclusionPaths(); err != nil {
+					wmcb.log.Error(err, "unable to read exclusions", "service", known.service)
+				}
+			}
+			software.Exclusions = dirExclusions(wmcb.scannedDirs(), excludedPaths)
+			if known.service != defenderServiceName || err != nil {
+				for dir := range software.Exclusions {
+					software.Exclusions[dir] = ExclusionUnknown
+				}
+			}
+		case VPN:
+			if running {
+				software.Incompatible = "VPN clients change the routes and DNS servers of the node, which conflicts " +
+					"with the HNS networks of the pods"
+			}
+		}
+		found = append(found, software)
+	}
+	return found
+}
+
+// DetectSecuritySoftware reports the antivirus, EDR agents, VPN clients and third-party network filter drivers bound to
+// the virtual switch of the HNS networks installed on the node, which are a common cause of pods failing to start or
+// to reach the network. The report flags the configurations known to break the node, and tells for each antivirus and
+// EDR agent whether the install, containerd and CNI directories are excluded from its scanning. The report is recorded
+// in the status file.
+func (wmcb *winNodeBootstrapper) DetectSecuritySoftware() (*SecurityReport, error) {
+	report := &SecurityReport{Software: wmcb.installedSecuritySoftware(), Timestamp: wmcb.now()}
+	out, err := runPowerShell(adapterBindingsCommand)
+	if err != nil {
+		return nil, fmt.Errorf("error listing the network adapter bindings: %v", err)
+	}
+	report.Software = append(report.Software, parseAdapterBindings(out)...)
+	wmcb.updateStatus(func(status *Status) { status.SecuritySoftware = report })
+	return report, nil
+}
@@ -0,0 +1,154 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// VirtualServiceAccount selects the virtual account of each service, NT SERVICE\<service name>, which is managed by
+	// Windows and has no password
+	VirtualServiceAccount = "virtual"
+	// virtualAccountDomain is the domain of the virtual accounts of the services
+	virtualAccountDomain = "NT SERVICE"
+	// kubeletRootDir is the directory the kubelet keeps the volumes and the state of the pods in
+	kubeletRootDir = "C:\\var\\lib\\kubelet"
+	// serviceLogonRight allows an account to run a service
+	serviceLogonRight = "SeServiceLogonRight"
+)
+
+// gmsaAccountRegex matches the name of a group managed service account, in the DOMAIN\name$ form
+var gmsaAccountRegex = regexp.MustCompile(`^[^\\]+\\[^\\]+\$$`)
+
+// serviceAccountRights are the rights of a service run as a service account
+type serviceAccountRights struct {
+	// privileges are the privileges the token of the service is restricted to, which are granted to the account
+	privileges []string
+	// modifyDirs are the directories the service writes to
+	modifyDirs []string
+	// readDirs are the directories the service only reads from
+	readDirs []string
+}
+
+// SetServiceAccount configures InitializeKubelet and ConfigureKubeProxy to run the kubelet and kube-proxy services as
+// the given account with the minimal rights they require, instead of LocalSystem. The account is either
+// VirtualServiceAccount, for the virtual account of each service, or a group managed service account in the
+// DOMAIN\name$ form, which needs to be installed on the node. The account is granted the privileges of the services
+// and access to their directories, and the services are restricted to these privileges. This needs to be called
+// before InitializeKubelet and ConfigureKubeProxy to take effect.
+func (wmcb *winNodeBootstrapper) SetServiceAccount(account string) error {
+	if account != VirtualServiceAccount && !gmsaAccountRegex.MatchString(account) {
+		return fmt.Errorf("invalid service account %q, expected %s or a group managed service account in the "+
+			"DOMAIN\\name$ form", account, VirtualServiceAccount)
+	}
+	wmcb.serviceAccount = account
+	return nil
+}
+
+// serviceSIDName returns the name of the SID of the given service, which is part of the token of the service once its
+// SID type is unrestricted. It is also the name of the virtual account of the service.
+func serviceSIDName(service string) string {
+	return virtualAccountDomain + "\\" + service
+}
+
+// serviceStartName returns the account the given service is run as, or an empty string to keep the account of the
+// service if no service account has been set
+func (wmcb *winNodeBootstrapper) serviceStartName(service string) string {
+	if wmcb.serviceAccount == VirtualServiceAccount {
+		return serviceSIDName(service)
+	}
+	return wmcb.serviceAccount
+}
+
+// setServiceAccountConfig sets the account the given service is run as in the given service config, along with the
+// unrestricted SID type the directories of the service are granted access to. The config is left untouched if no
+// service account has been set.
+func (wmcb *winNodeBootstrapper) setServiceAccountConfig(service string, config *serviceConfig) {
+	if wmcb.serviceAccount == "" {
+		return
+	}
+	config.ServiceStartName = wmcb.serviceStartName(service)
+	config.SidType = serviceSIDTypeUnrestricted
+}
+
+// kubeletAccountRights returns the rights of the kubelet service. The kubelet writes its kubeconfig to the install
+// dir, and creates symbolic links for the volumes of the pods.
+func (wmcb *winNodeBootstrapper) kubeletAccountRights() serviceAccountRights {
+	modifyDirs := []string{wmcb.installDir, wmcb.logDir, kubeletRootDir}
+	if !strings.HasPrefix(strings.ToLower(filepath.Clean(wmcb.certDir)), strings.ToLower(kubeletRootDir)) {
+		modifyDirs = append(modifyDirs, wmcb.certDir)
+	}
+	return serviceAccountRights{
+		privileges: []string{"SeChangeNotifyPrivilege", "SeCreateGlobalPrivilege", "SeCreateSymbolicLinkPrivilege",
+			"SeImpersonatePrivilege"},
+		modifyDirs: modifyDirs,
+	}
+}
+
+// kubeProxyAccountRights returns the rights of the kube-proxy service, which only writes its logs
+func (wmcb *winNodeBootstrapper) kubeProxyAccountRights(logDir string) serviceAccountRights {
+	return serviceAccountRights{
+		privileges: []string{"SeChangeNotifyPrivilege", "SeCreateGlobalPrivilege", "SeImpersonatePrivilege"},
+		modifyDirs: []string{logDir},
+		readDirs:   []string{wmcb.installDir},
+	}
+}
+
+// configureServiceAccount grants the service account the given rights of the given service, restricts the service to
+// the privileges of the rights and grants the SID of the service access to the directories of the rights. Nothing is
+// done if no service account has been set. The changes are only recorded in dry-run mode.
+func (wmcb *winNodeBootstrapper) configureServiceAccount(service service, name string,
+	rights serviceAccountRights) error {
+	if wmcb.serviceAccount == "" {
+		return nil
+	}
+	account := wmcb.serviceStartName(name)
+	sidName := serviceSIDName(name)
+	if wmcb.dryRun != nil {
+		wmcb.dryRun.addAction("run the %s service as %s with the %s privileges", name, account,
+			strings.Join(rights.privileges, ", "))
+		for _, dir := range rights.modifyDirs {
+			wmcb.dryRun.addAction("grant %s modify access to %s", sidName, dir)
+		}
+		for _, dir := range rights.readDirs {
+			wmcb.dryRun.addAction("grant %s read access to %s", sidName, dir)
+		}
+		return nil
+	}
+
+	if err := addAccountRights(account, append([]string{serviceLogonRight}, rights.privileges...)); err != nil {
+		return fmt.Errorf("error granting rights to %s: %w", account, err)
+	}
+	if err := service.SetRequiredPrivileges(rights.privileges); err != nil {
+		return fmt.Errorf("error restricting the privileges of the %s service: %w", name, err)
+	}
+	for _, dir := range rights.modifyDirs {
+		if err := grantDirAccess(wmcb.fileSystem(), dir, sidName, "M"); err != nil {
+			return err
+		}
+	}
+	for _, dir := range rights.readDirs {
+		if err := grantDirAccess(wmcb.fileSystem(), dir, sidName, "RX"); err != nil {
+			return err
+		}
+	}
+	wmcb.log.Info("service account configured", "service", name, "account", account)
+	return nil
+}
+
+// grantDirAccess grants the given account the given access to the given directory, inherited by its files and
+// subdirectories. The directory is made if it does not exist.
+func grantDirAccess(fs FileSystem, dir, account, access string) error {
+	if err := fs.MkdirAll(dir, os.ModeDir); err != nil {
+		return fmt.Errorf("could not make %s directory: %w", dir, err)
+	}
+	out, err := exec.Command("icacls", dir, "/grant", account+":(OI)(CI)"+access).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error granting %s access to %s: %w: %s", account, dir, err, out)
+	}
+	return nil
+}
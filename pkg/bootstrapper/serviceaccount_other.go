@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package bootstrapper
+
+// addAccountRights returns errUnsupportedPlatform as there is no LSA policy
+func addAccountRights(account string, rights []string) error {
+	return errUnsupportedPlatform
+}
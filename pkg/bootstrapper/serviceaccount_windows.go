@@ -0,0 +1,82 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// policyCreateAccount and policyLookupNames are the access rights to the LSA policy required to grant rights to
+	// an account
+	policyCreateAccount = 0x10
+	policyLookupNames   = 0x800
+)
+
+var (
+	advapi32                  = windows.NewLazySystemDLL("advapi32.dll")
+	procLsaOpenPolicy         = advapi32.NewProc("LsaOpenPolicy")
+	procLsaAddAccountRights   = advapi32.NewProc("LsaAddAccountRights")
+	procLsaClose              = advapi32.NewProc("LsaClose")
+	procLsaNtStatusToWinError = advapi32.NewProc("LsaNtStatusToWinError")
+)
+
+// lsaUnicodeString is the LSA_UNICODE_STRING the LSA functions take strings as
+type lsaUnicodeString struct {
+	Length        uint16
+	MaximumLength uint16
+	Buffer        *uint16
+}
+
+// lsaObjectAttributes is the LSA_OBJECT_ATTRIBUTES the LSA policy is opened with, which are unused
+type lsaObjectAttributes struct {
+	Length                   uint32
+	RootDirectory            windows.Handle
+	ObjectName               *lsaUnicodeString
+	Attributes               uint32
+	SecurityDescriptor       uintptr
+	SecurityQualityOfService uintptr
+}
+
+// newLSAUnicodeString returns the given string as an LSA_UNICODE_STRING
+func newLSAUnicodeString(s string) lsaUnicodeString {
+	buffer := windows.StringToUTF16(s)
+	// The lengths are in bytes and exclude the null terminator
+	length := uint16((len(buffer) - 1) * 2)
+	return lsaUnicodeString{Length: length, MaximumLength: length + 2, Buffer: &buffer[0]}
+}
+
+// lsaError returns the Windows error of the given NTSTATUS returned by an LSA function, or nil if it is a success
+func lsaError(status uintptr) error {
+	if status == 0 {
+		return nil
+	}
+	code, _, _ := procLsaNtStatusToWinError.Call(status)
+	return syscall.Errno(code)
+}
+
+// addAccountRights grants the given account the given rights and privileges through the LSA policy of the node
+func addAccountRights(account string, rights []string) error {
+	sid, _, _, err := windows.LookupSID("", account)
+	if err != nil {
+		return fmt.Errorf("error looking up account: %w", err)
+	}
+	var attributes lsaObjectAttributes
+	var policy windows.Handle
+	status, _, _ := procLsaOpenPolicy.Call(0, uintptr(unsafe.Pointer(&attributes)),
+		policyCreateAccount|policyLookupNames, uintptr(unsafe.Pointer(&policy)))
+	if err = lsaError(status); err != nil {
+		return fmt.Errorf("error opening LSA policy: %w", err)
+	}
+	defer procLsaClose.Call(uintptr(policy))
+
+	lsaRights := make([]lsaUnicodeString, len(rights))
+	for i, right := range rights {
+		lsaRights[i] = newLSAUnicodeString(right)
+	}
+	status, _, _ = procLsaAddAccountRights.Call(uintptr(policy), uintptr(unsafe.Pointer(sid)),
+		uintptr(unsafe.Pointer(&lsaRights[0])), uintptr(len(lsaRights)))
+	return lsaError(status)
+}
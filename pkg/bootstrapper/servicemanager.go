@@ -0,0 +1,131 @@
+package bootstrapper
+
+import (
+	"errors"
+	"time"
+)
+
+// Start types of a Windows service, matching the SERVICE_*_START values of the SCM
+const (
+	// startAutomatic starts the service by itself whenever the host boots
+	startAutomatic uint32 = 2
+	// startManual requires the service to be started manually
+	startManual uint32 = 3
+	// startDisabled prevents the service from being started
+	startDisabled uint32 = 4
+)
+
+// serviceSIDTypeUnrestricted is the SERVICE_SID_TYPE_UNRESTRICTED SID type, which adds the SID of the service to its
+// token so that files and registry keys can be restricted to it
+const serviceSIDTypeUnrestricted uint32 = 1
+
+// serviceConfig is the config of a Windows service. Its fields are those of the config of the SCM API, which keeps
+// the configs recorded in the journal by earlier versions readable.
+type serviceConfig struct {
+	ServiceType    uint32
+	StartType      uint32
+	ErrorControl   uint32
+	BinaryPathName string
+	LoadOrderGroup string
+	TagId          uint32
+	Dependencies   []string
+	// ServiceStartName is the account the service runs as
+	ServiceStartName string
+	DisplayName      string
+	Password         string
+	Description      string
+	// SidType is the type of the SID of the service
+	SidType uint32
+	// DelayedAutoStart starts the service after the other automatic services, plus a short delay
+	DelayedAutoStart bool
+}
+
+// serviceState is the execution state of a Windows service, matching the SERVICE_* states of the SCM
+type serviceState uint32
+
+const (
+	// serviceStopped is the state of a service that is not running
+	serviceStopped serviceState = 1
+	// serviceRunning is the state of a service that is running
+	serviceRunning serviceState = 4
+)
+
+// serviceCmd is a control request sent to a Windows service, matching the SERVICE_CONTROL_* requests of the SCM
+type serviceCmd uint32
+
+const (
+	// serviceStop requests the service to stop
+	serviceStop serviceCmd = 1
+	// serviceInterrogate requests the service to report its status
+	serviceInterrogate serviceCmd = 4
+)
+
+// serviceStatus is the status of a Windows service
+type serviceStatus struct {
+	// State is the execution state of the service
+	State serviceState
+}
+
+// serviceRestart is the SC_ACTION_RESTART recovery action, restarting the service
+const serviceRestart = 1
+
+// recoveryAction is an action taken by the SCM when a service fails
+type recoveryAction struct {
+	// Type is the type of the action, only serviceRestart is used
+	Type int
+	// Delay is the time to wait before taking the action
+	Delay time.Duration
+}
+
+var (
+	// errServiceDoesNotExist is matched by the errors returned when opening a service that is not installed
+	errServiceDoesNotExist = errors.New("the specified service does not exist as an installed service")
+	// errServiceExists is matched by the errors returned when creating a service that is already installed
+	errServiceExists = errors.New("the specified service already exists")
+	// errServiceAlreadyRunning is matched by the errors returned when starting a service that is running
+	errServiceAlreadyRunning = errors.New("an instance of the service is already running")
+	// errServiceNotActive is matched by the errors returned when stopping a service that is not running
+	errServiceNotActive = errors.New("the service has not been started")
+)
+
+// serviceManager is the part of the Windows service control manager (SCM) API WMCB uses. It abstracts the SCM so that
+// the logic creating and configuring the services can be unit tested against an in-memory implementation.
+type serviceManager interface {
+	// CreateService creates the service with the given name, executable, config and arguments. The error matches
+	// errServiceExists if the service is already installed.
+	CreateService(name, exePath string, config serviceConfig, args ...string) (service, error)
+	// OpenService opens the service with the given name. The error matches errServiceDoesNotExist if the service is
+	// not installed.
+	OpenService(name string) (service, error)
+	// Disconnect closes the connection to the SCM
+	Disconnect() error
+}
+
+// service is the part of the API of a Windows service WMCB uses
+type service interface {
+	// Name returns the name of the service
+	Name() string
+	// Config returns the config of the service
+	Config() (serviceConfig, error)
+	// UpdateConfig updates the config of the service
+	UpdateConfig(config serviceConfig) error
+	// Start starts the service with the given arguments. The error matches errServiceAlreadyRunning if the service is
+	// running.
+	Start(args ...string) error
+	// Control sends the given control request to the service and returns its status. The error matches
+	// errServiceNotActive if the service is stopped.
+	Control(cmd serviceCmd) (serviceStatus, error)
+	// Query returns the status of the service
+	Query() (serviceStatus, error)
+	// SetRecoveryActions sets the actions taken when the service fails, and the period after which the failure count
+	// is reset, in seconds
+	SetRecoveryActions(actions []recoveryAction, resetPeriod uint32) error
+	// ResetRecoveryActions removes the actions taken when the service fails
+	ResetRecoveryActions() error
+	// SetRequiredPrivileges restricts the token of the service to the given privileges
+	SetRequiredPrivileges(privileges []string) error
+	// Delete marks the service for deletion, which happens once all the handles of the service are closed
+	Delete() error
+	// Close closes the handle of the service
+	Close() error
+}
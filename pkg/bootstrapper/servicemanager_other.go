@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package bootstrapper
+
+// connectServiceManager returns errUnsupportedPlatform as there is no SCM to connect to
+func connectServiceManager() (serviceManager, error) {
+	return nil, errUnsupportedPlatform
+}
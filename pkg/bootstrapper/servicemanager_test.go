@@ -0,0 +1,233 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServiceManager is an in-memory serviceManager, which allows testing the logic managing the Windows services
+// without the SCM of a Windows host
+type fakeServiceManager struct {
+	// services are the installed services, by name
+	services map[string]*fakeService
+}
+
+// newFakeServiceManager returns a fakeServiceManager with no installed service
+func newFakeServiceManager() *fakeServiceManager {
+	return &fakeServiceManager{services: make(map[string]*fakeService)}
+}
+
+// CreateService installs the service with the given name, executable, config and arguments. The command line of the
+// service is built as the SCM does.
+func (m *fakeServiceManager) CreateService(name, exePath string, config serviceConfig, args ...string) (service, error) {
+	if _, ok := m.services[name]; ok {
+		return nil, errServiceExists
+	}
+	command := escapeArg(exePath)
+	for _, arg := range args {
+		command += " " + escapeArg(arg)
+	}
+	config.BinaryPathName = command
+	s := &fakeService{manager: m, name: name, config: config, status: serviceStatus{State: serviceStopped}}
+	m.services[name] = s
+	return s, nil
+}
+
+// OpenService returns the installed service with the given name
+func (m *fakeServiceManager) OpenService(name string) (service, error) {
+	s, ok := m.services[name]
+	if !ok {
+		return nil, errServiceDoesNotExist
+	}
+	return s, nil
+}
+
+// Disconnect does nothing
+func (m *fakeServiceManager) Disconnect() error {
+	return nil
+}
+
+// fakeService is a service of a fakeServiceManager, which changes state as soon as it is controlled
+type fakeService struct {
+	// manager is the fakeServiceManager the service is installed in
+	manager *fakeServiceManager
+	// name is the name of the service
+	name string
+	// config is the config of the service
+	config serviceConfig
+	// status is the status of the service
+	status serviceStatus
+	// recoveryActions are the actions taken when the service fails
+	recoveryActions []recoveryAction
+	// privileges are the privileges the token of the service is restricted to
+	privileges []string
+}
+
+// Name returns the name of the service
+func (s *fakeService) Name() string {
+	return s.name
+}
+
+// Config returns the config of the service
+func (s *fakeService) Config() (serviceConfig, error) {
+	return s.config, nil
+}
+
+// UpdateConfig replaces the config of the service
+func (s *fakeService) UpdateConfig(config serviceConfig) error {
+	s.config = config
+	return nil
+}
+
+// Start runs the service, which fails if it is already running
+func (s *fakeService) Start(args ...string) error {
+	if s.status.State == serviceRunning {
+		return errServiceAlreadyRunning
+	}
+	s.status.State = serviceRunning
+	return nil
+}
+
+// Control stops the service on serviceStop and returns its status
+func (s *fakeService) Control(cmd serviceCmd) (serviceStatus, error) {
+	switch cmd {
+	case serviceStop:
+		if s.status.State != serviceRunning {
+			return s.status, errServiceNotActive
+		}
+		s.status.State = serviceStopped
+	case serviceInterrogate:
+	default:
+		return s.status, fmt.Errorf("unsupported control request %d", cmd)
+	}
+	return s.status, nil
+}
+
+// Query returns the status of the service
+func (s *fakeService) Query() (serviceStatus, error) {
+	return s.status, nil
+}
+
+// SetRecoveryActions records the recovery actions of the service
+func (s *fakeService) SetRecoveryActions(actions []recoveryAction, resetPeriod uint32) error {
+	s.recoveryActions = actions
+	return nil
+}
+
+// ResetRecoveryActions removes the recovery actions of the service
+func (s *fakeService) ResetRecoveryActions() error {
+	s.recoveryActions = nil
+	return nil
+}
+
+// SetRequiredPrivileges records the privileges the service is restricted to
+func (s *fakeService) SetRequiredPrivileges(privileges []string) error {
+	s.privileges = privileges
+	return nil
+}
+
+// Delete uninstalls the service right away, unlike the SCM which waits for the handles of the service to be closed
+func (s *fakeService) Delete() error {
+	delete(s.manager.services, s.name)
+	return nil
+}
+
+// Close does nothing
+func (s *fakeService) Close() error {
+	return nil
+}
+
+// TestServiceManagement tests the creation, update, start, stop and removal of the Windows services against an
+// in-memory service manager
+func TestServiceManagement(t *testing.T) {
+	svcMgr := newFakeServiceManager()
+	c := serviceConfig{DisplayName: "kube-proxy", StartType: startAutomatic, Dependencies: []string{KubeletServiceName}}
+	created, err := createOrUpdateService(svcMgr, nil, nil, kubeProxyServiceName, `c:\k\kube-proxy.exe`, c,
+		[]string{"--windows-service", "--hostname-override=node name"})
+	require.NoError(t, err)
+	config, err := created.Config()
+	require.NoError(t, err)
+	assert.Equal(t, `c:\k\kube-proxy.exe --windows-service "--hostname-override=node name"`, config.BinaryPathName)
+	assert.Equal(t, []string{KubeletServiceName}, config.Dependencies)
+	_, err = createOrUpdateService(svcMgr, nil, nil, kubeProxyServiceName, `c:\k\kube-proxy.exe`, c, nil)
+	assert.Error(t, err, "expected creating an installed service to fail")
+
+	c.StartType = startManual
+	c.Description = "updated"
+	updated, err := createOrUpdateService(svcMgr, nil, created, kubeProxyServiceName, `c:\k\kube-proxy.exe`, c,
+		[]string{"--v=4"})
+	require.NoError(t, err)
+	config, err = updated.Config()
+	require.NoError(t, err)
+	assert.Equal(t, `c:\k\kube-proxy.exe --v=4`, config.BinaryPathName)
+	assert.Equal(t, uint32(startManual), config.StartType)
+	assert.Equal(t, "kube-proxy", config.DisplayName, "expected the fields that are not updated to be kept")
+
+	kubelet, err := svcMgr.CreateService(KubeletServiceName, `c:\k\kubelet.exe`, serviceConfig{})
+	require.NoError(t, err)
+	dependents, err := updateKubeletDependents(svcMgr)
+	require.NoError(t, err)
+	require.Len(t, dependents, 1)
+	assert.Equal(t, kubeProxyServiceName, dependents[0].Name())
+	kubeletSVC, err := newKubeletService(kubelet, dependents)
+	require.NoError(t, err)
+	require.NoError(t, kubeletSVC.start())
+	for _, s := range []service{kubelet, dependents[0]} {
+		running, err := isServiceRunning(s)
+		require.NoError(t, err)
+		assert.True(t, running, "expected %s to be started", s.Name())
+	}
+	require.NoError(t, kubeletSVC.stop())
+	for _, s := range []service{kubelet, dependents[0]} {
+		running, err := isServiceRunning(s)
+		require.NoError(t, err)
+		assert.False(t, running, "expected %s to be stopped", s.Name())
+	}
+
+	require.NoError(t, kubeletSVC.setRecoveryActions(defaultServiceRecovery()))
+	assert.NotEmpty(t, svcMgr.services[KubeletServiceName].recoveryActions)
+
+	require.NoError(t, removeService(svcMgr, kubeProxyServiceName))
+	assert.NotContains(t, svcMgr.services, kubeProxyServiceName)
+	assert.NoError(t, removeService(svcMgr, kubeProxyServiceName), "expected removing a missing service to succeed")
+	require.NoError(t, kubeletSVC.stopAndRemove())
+	assert.Empty(t, svcMgr.services)
+}
+
+// escapeArg escapes the given argument of a Windows command line as the SCM does, quoting it if it contains spaces or
+// tabs and escaping its quotes along with the backslashes preceding them
+func escapeArg(arg string) string {
+	if arg == "" {
+		return `""`
+	}
+	if !strings.ContainsAny(arg, " \t\"") {
+		return arg
+	}
+	var escaped strings.Builder
+	quote := strings.ContainsAny(arg, " \t")
+	if quote {
+		escaped.WriteByte('"')
+	}
+	backslashes := 0
+	for i := 0; i < len(arg); i++ {
+		switch arg[i] {
+		case '\\':
+			backslashes++
+		case '"':
+			escaped.WriteString(strings.Repeat(`\`, backslashes+1))
+			backslashes = 0
+		default:
+			backslashes = 0
+		}
+		escaped.WriteByte(arg[i])
+	}
+	if quote {
+		escaped.WriteString(strings.Repeat(`\`, backslashes))
+		escaped.WriteByte('"')
+	}
+	return escaped.String()
+}
@@ -0,0 +1,136 @@
+package bootstrapper
+
+import (
+	"errors"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// windowsServiceManager is the serviceManager of the SCM of the Windows host
+type windowsServiceManager struct {
+	*mgr.Mgr
+}
+
+// connectServiceManager connects to the SCM of the Windows host
+func connectServiceManager() (serviceManager, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, err
+	}
+	return &windowsServiceManager{m}, nil
+}
+
+// serviceError returns the given error of the SCM classified as one of the service errors the callers match, or the
+// given error if it is not one of them
+func serviceError(err error) error {
+	switch {
+	case errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST):
+		return newError(errServiceDoesNotExist, err)
+	case errors.Is(err, windows.ERROR_SERVICE_EXISTS):
+		return newError(errServiceExists, err)
+	case errors.Is(err, windows.ERROR_SERVICE_ALREADY_RUNNING):
+		return newError(errServiceAlreadyRunning, err)
+	case errors.Is(err, windows.ERROR_SERVICE_NOT_ACTIVE):
+		return newError(errServiceNotActive, err)
+	}
+	return err
+}
+
+// CreateService creates the service with the given name, executable, config and arguments
+func (m *windowsServiceManager) CreateService(name, exePath string, config serviceConfig,
+	args ...string) (service, error) {
+	s, err := m.Mgr.CreateService(name, exePath, mgr.Config(config), args...)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return &windowsService{s}, nil
+}
+
+// OpenService opens the service with the given name
+func (m *windowsServiceManager) OpenService(name string) (service, error) {
+	s, err := m.Mgr.OpenService(name)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return &windowsService{s}, nil
+}
+
+// windowsService is a service of the SCM of the Windows host
+type windowsService struct {
+	*mgr.Service
+}
+
+// Name returns the name of the service
+func (s *windowsService) Name() string {
+	return s.Service.Name
+}
+
+// Config returns the config of the service
+func (s *windowsService) Config() (serviceConfig, error) {
+	config, err := s.Service.Config()
+	if err != nil {
+		return serviceConfig{}, serviceError(err)
+	}
+	return serviceConfig(config), nil
+}
+
+// UpdateConfig updates the config of the service
+func (s *windowsService) UpdateConfig(config serviceConfig) error {
+	return serviceError(s.Service.UpdateConfig(mgr.Config(config)))
+}
+
+// Start starts the service with the given arguments
+func (s *windowsService) Start(args ...string) error {
+	return serviceError(s.Service.Start(args...))
+}
+
+// Control sends the given control request to the service and returns its status
+func (s *windowsService) Control(cmd serviceCmd) (serviceStatus, error) {
+	status, err := s.Service.Control(svc.Cmd(cmd))
+	return serviceStatus{State: serviceState(status.State)}, serviceError(err)
+}
+
+// Query returns the status of the service
+func (s *windowsService) Query() (serviceStatus, error) {
+	status, err := s.Service.Query()
+	return serviceStatus{State: serviceState(status.State)}, serviceError(err)
+}
+
+// SetRecoveryActions sets the actions taken when the service fails, and the period after which the failure count is
+// reset, in seconds
+func (s *windowsService) SetRecoveryActions(actions []recoveryAction, resetPeriod uint32) error {
+	mgrActions := make([]mgr.RecoveryAction, 0, len(actions))
+	for _, action := range actions {
+		mgrActions = append(mgrActions, mgr.RecoveryAction(action))
+	}
+	return serviceError(s.Service.SetRecoveryActions(mgrActions, resetPeriod))
+}
+
+// ResetRecoveryActions removes the actions taken when the service fails
+func (s *windowsService) ResetRecoveryActions() error {
+	return serviceError(s.Service.ResetRecoveryActions())
+}
+
+// Delete marks the service for deletion
+func (s *windowsService) Delete() error {
+	return serviceError(s.Service.Delete())
+}
+
+// serviceRequiredPrivilegesInfo is the SERVICE_REQUIRED_PRIVILEGES_INFO the required privileges of a service are set
+// with
+type serviceRequiredPrivilegesInfo struct {
+	requiredPrivileges *uint16
+}
+
+// SetRequiredPrivileges restricts the token of the service to the given privileges
+func (s *windowsService) SetRequiredPrivileges(privileges []string) error {
+	// The privileges are a sequence of null terminated strings, terminated by an empty string
+	block := windows.StringToUTF16(strings.Join(privileges, "\x00") + "\x00")
+	info := serviceRequiredPrivilegesInfo{requiredPrivileges: &block[0]}
+	return windows.ChangeServiceConfig2(s.Handle, windows.SERVICE_CONFIG_REQUIRED_PRIVILEGES_INFO,
+		(*byte)(unsafe.Pointer(&info)))
+}
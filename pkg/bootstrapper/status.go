@@ -0,0 +1,219 @@
+package bootstrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// statusFileName is the name of the file in the install directory that records the progress of the bootstrap
+const statusFileName = "bootstrap-status.json"
+
+// Phase is a step of the bootstrap process that is recorded in the status file
+type Phase string
+
+const (
+	// PhaseUpdatesApplied is recorded once the pinned Windows updates have been installed
+	PhaseUpdatesApplied Phase = "UpdatesApplied"
+	// PhaseFeaturesEnabled is recorded once the Windows features and services required by the container runtime have
+	// been enabled
+	PhaseFeaturesEnabled Phase = "FeaturesEnabled"
+	// PhaseIgnitionParsed is recorded once the files required by the kubelet have been extracted from the ignition file
+	PhaseIgnitionParsed Phase = "IgnitionParsed"
+	// PhaseFilesWritten is recorded once the kubelet binary and configuration have been written to the install directory
+	PhaseFilesWritten Phase = "FilesWritten"
+	// PhaseServiceCreated is recorded once the kubelet Windows service has been created or updated
+	PhaseServiceCreated Phase = "ServiceCreated"
+	// PhaseKubeletStarted is recorded once the kubelet Windows service has been started
+	PhaseKubeletStarted Phase = "KubeletStarted"
+	// PhaseCertificatesIssued is recorded once the CSRs of the kubelet client and serving certificates have been
+	// approved and the certificates have been issued
+	PhaseCertificatesIssued Phase = "CertificatesIssued"
+	// PhaseCNIConfigured is recorded once the kubelet has been configured for CNI
+	PhaseCNIConfigured Phase = "CNIConfigured"
+	// PhaseKubeProxyConfigured is recorded once the kube-proxy Windows service has been configured and started
+	PhaseKubeProxyConfigured Phase = "KubeProxyConfigured"
+	// PhaseNodeReady is recorded once the kubelet has registered the node object and the node is Ready
+	PhaseNodeReady Phase = "NodeReady"
+)
+
+// PhaseStatus is the outcome of the last attempt of a bootstrap phase
+type PhaseStatus struct {
+	// Phase is the bootstrap phase
+	Phase Phase `json:"phase"`
+	// Timestamp is the time at which the phase completed or failed
+	Timestamp time.Time `json:"timestamp"`
+	// Succeeded indicates if the phase completed successfully
+	Succeeded bool `json:"succeeded"`
+	// Error is the error the phase failed with
+	Error string `json:"error,omitempty"`
+	// Attempts is the number of times the phase has been attempted since the status was reset
+	Attempts int `json:"attempts"`
+	// DurationSeconds is the time the latest attempt of the phase took
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// Status is the machine readable progress of the bootstrap that is written to the status file
+type Status struct {
+	// Phases holds the status of the phases in the order they were first attempted
+	Phases []PhaseStatus `json:"phases"`
+	// ServiceRestarts holds the number of times each Windows service has been restarted by WMCB since the status was
+	// reset
+	ServiceRestarts map[string]int `json:"serviceRestarts,omitempty"`
+	// PatchLevel is the patch level of the node recorded by the latest installation of the Windows updates
+	PatchLevel *PatchLevel `json:"patchLevel,omitempty"`
+	// SecuritySoftware is the report of the software interfering with the node components made by the latest
+	// preflight
+	SecuritySoftware *SecurityReport `json:"securitySoftware,omitempty"`
+}
+
+// statusFilePath returns the path of the status file in the given install directory
+func statusFilePath(installDir string) string {
+	return filepath.Join(installDir, statusFileName)
+}
+
+// ReadStatus reads the bootstrap status file from the given install directory
+func ReadStatus(installDir string) (*Status, error) {
+	return readStatus(osFileSystem, installDir)
+}
+
+// readStatus reads the bootstrap status file from the given install directory of the given file system
+func readStatus(fs FileSystem, installDir string) (*Status, error) {
+	content, err := readFile(fs, statusFilePath(installDir))
+	if err != nil {
+		return nil, fmt.Errorf("error reading bootstrap status: %v", err)
+	}
+	var status Status
+	if err = json.Unmarshal(content, &status); err != nil {
+		return nil, fmt.Errorf("error parsing bootstrap status: %v", err)
+	}
+	return &status, nil
+}
+
+// writeStatus writes the bootstrap status file to the given install directory of the given file system
+func writeStatus(fs FileSystem, installDir string, status *Status) error {
+	if err := fs.MkdirAll(installDir, os.ModeDir); err != nil {
+		return fmt.Errorf("could not make install directory: %v", err)
+	}
+	content, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling bootstrap status: %v", err)
+	}
+	return writeFile(fs, statusFilePath(installDir), content, 0644)
+}
+
+// record updates the status of the given phase with the outcome and duration of its latest attempt
+func (s *Status) record(phase Phase, timestamp time.Time, duration time.Duration, err error) {
+	phaseStatus := PhaseStatus{Phase: phase, Timestamp: timestamp, Succeeded: err == nil, Attempts: 1,
+		DurationSeconds: duration.Seconds()}
+	if err != nil {
+		phaseStatus.Error = err.Error()
+	}
+	for i := range s.Phases {
+		if s.Phases[i].Phase == phase {
+			phaseStatus.Attempts += s.Phases[i].Attempts
+			s.Phases[i] = phaseStatus
+			return
+		}
+	}
+	s.Phases = append(s.Phases, phaseStatus)
+}
+
+// Succeeded returns true if the latest attempt of each of the given phases succeeded
+func (s *Status) Succeeded(phases ...Phase) bool {
+	for _, phase := range phases {
+		succeeded := false
+		for _, phaseStatus := range s.Phases {
+			if phaseStatus.Phase == phase {
+				succeeded = phaseStatus.Succeeded
+				break
+			}
+		}
+		if !succeeded {
+			return false
+		}
+	}
+	return true
+}
+
+// recordRestart increments the number of times the given service has been restarted
+func (s *Status) recordRestart(service string) {
+	if s.ServiceRestarts == nil {
+		s.ServiceRestarts = make(map[string]int)
+	}
+	s.ServiceRestarts[service]++
+}
+
+// startPhase marks the start of the next bootstrap phase, whose duration is recorded along with its outcome
+func (wmcb *winNodeBootstrapper) startPhase() {
+	wmcb.phaseStart = wmcb.now()
+}
+
+// recordPhase records the outcome of the given phase in the status file and the event log, and returns the given error,
+// so that it can wrap the error returned by the phase. The next phase is considered to start once the outcome has been
+// recorded. Failing to write the status file is logged and does not fail the bootstrap.
+func (wmcb *winNodeBootstrapper) recordPhase(phase Phase, err error) error {
+	now := wmcb.now()
+	var duration time.Duration
+	if !wmcb.phaseStart.IsZero() {
+		duration = now.Sub(wmcb.phaseStart)
+	}
+	wmcb.phaseStart = now
+	wmcb.updateStatus(func(status *Status) { status.record(phase, now, duration, err) })
+	if err != nil {
+		wmcb.writeEvent(eventIDPhaseFailed, true, fmt.Sprintf("Bootstrap phase %s failed: %v", phase, err))
+	} else {
+		wmcb.writeEvent(eventIDPhaseSucceeded, false, fmt.Sprintf("Bootstrap phase %s succeeded", phase))
+	}
+	return err
+}
+
+// recordServiceRestart records that the given service has been restarted by WMCB in the status file and the event log
+func (wmcb *winNodeBootstrapper) recordServiceRestart(service string) {
+	wmcb.updateStatus(func(status *Status) { status.recordRestart(service) })
+	wmcb.writeEvent(eventIDServiceRestarted, false, fmt.Sprintf("%s service restarted by WMCB", service))
+}
+
+// updateStatus applies the given update to the status file, and writes the metrics file if one has been set. Failing
+// to write either is logged and does not fail the bootstrap. Neither is written in dry-run mode.
+func (wmcb *winNodeBootstrapper) updateStatus(update func(status *Status)) {
+	if wmcb.installDir == "" || wmcb.dryRun != nil {
+		return
+	}
+	status, readErr := readStatus(wmcb.fileSystem(), wmcb.installDir)
+	if readErr != nil {
+		status = &Status{}
+	}
+	update(status)
+	if err := writeStatus(wmcb.fileSystem(), wmcb.installDir, status); err != nil {
+		wmcb.log.Error(err, "unable to write bootstrap status")
+	}
+	if metricsFile != "" {
+		if err := writeMetrics(wmcb.fileSystem(), metricsFile, status); err != nil {
+			wmcb.log.Error(err, "unable to write bootstrap metrics", "metricsFile", metricsFile)
+		}
+	}
+}
+
+// resetStatus removes the status file so that a new bootstrap does not report the phases of a previous one. The patch
+// level and the security software report of the node are kept, as the Windows updates are installed and the preflight
+// is run before the node is bootstrapped.
+func (wmcb *winNodeBootstrapper) resetStatus() {
+	if wmcb.installDir == "" || wmcb.dryRun != nil {
+		return
+	}
+	status, err := readStatus(wmcb.fileSystem(), wmcb.installDir)
+	if err == nil && (status.PatchLevel != nil || status.SecuritySoftware != nil) {
+		err = writeStatus(wmcb.fileSystem(), wmcb.installDir, &Status{PatchLevel: status.PatchLevel,
+			SecuritySoftware: status.SecuritySoftware})
+		if err != nil {
+			wmcb.log.Error(err, "unable to reset bootstrap status")
+		}
+		return
+	}
+	if err = wmcb.fileSystem().Remove(statusFilePath(wmcb.installDir)); err != nil && !os.IsNotExist(err) {
+		wmcb.log.Error(err, "unable to remove bootstrap status")
+	}
+}
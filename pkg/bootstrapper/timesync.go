@@ -0,0 +1,114 @@
+package bootstrapper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// w32TimeServiceName is the name of the Windows Time service
+	w32TimeServiceName = "W32Time"
+	// w32tmClientFlag makes the Windows Time service act as a client of a peer of its manual peer list
+	w32tmClientFlag = "0x8"
+)
+
+// SetTimeServers sets the NTP servers the Windows Time service of the node is synchronized with by InitializeKubelet,
+// taking precedence over the servers of the chrony configuration of the ignition file. The clock of the node needs to
+// be in sync with the cluster for the certificates of the kubelet to be valid. This needs to be called before
+// InitializeKubelet to take effect.
+func (wmcb *winNodeBootstrapper) SetTimeServers(servers []string) error {
+	for _, server := range servers {
+		if net.ParseIP(server) != nil {
+			continue
+		}
+		if errs := validation.IsDNS1123Subdomain(server); len(errs) > 0 {
+			return fmt.Errorf("invalid time server %q: %s", server, strings.Join(errs, "; "))
+		}
+	}
+	wmcb.timeServers = servers
+	return nil
+}
+
+// parseTimeServers returns the servers and pools of the given chrony configuration
+func parseTimeServers(chronyConf []byte) []string {
+	var servers []string
+	scanner := bufio.NewScanner(bytes.NewReader(chronyConf))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && (fields[0] == "server" || fields[0] == "pool" || fields[0] == "peer") {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+// w32tmConfigArgs returns the w32tm arguments configuring the given servers as the peers of the Windows Time service
+func w32tmConfigArgs(servers []string) []string {
+	var peers []string
+	for _, server := range servers {
+		peers = append(peers, server+","+w32tmClientFlag)
+	}
+	return []string{"/config", "/manualpeerlist:" + strings.Join(peers, " "), "/syncfromflags:manual", "/update"}
+}
+
+// configureTimeSync synchronizes the clock of the Windows node with the time servers given using SetTimeServers, or
+// otherwise the ones of the chrony configuration of the ignition file, if any. The Windows Time service is started
+// automatically, configured to use the servers and resynchronized right away, so that the certificates the kubelet
+// requests are not issued for a skewed clock. The changes are only recorded in dry-run mode.
+func (wmcb *winNodeBootstrapper) configureTimeSync(ctx context.Context) error {
+	servers := wmcb.timeServers
+	if len(servers) == 0 {
+		servers = wmcb.chronyTimeServers
+	}
+	if len(servers) == 0 {
+		return nil
+	}
+	args := w32tmConfigArgs(servers)
+	if wmcb.dryRun != nil {
+		wmcb.dryRun.addAction("start the %s service automatically", w32TimeServiceName)
+		wmcb.dryRun.addAction("run w32tm %s", strings.Join(args, " "))
+		wmcb.dryRun.addAction("run w32tm /resync /rediscover")
+		return nil
+	}
+
+	service, err := wmcb.svcMgr.OpenService(w32TimeServiceName)
+	if err != nil {
+		return fmt.Errorf("error opening %s service: %v", w32TimeServiceName, err)
+	}
+	defer service.Close()
+	// The Windows Time service is started on demand on the nodes that are not domain joined, and would not keep the
+	// clock in sync after a reboot
+	config, err := service.Config()
+	if err != nil {
+		return fmt.Errorf("error getting %s service config: %v", w32TimeServiceName, err)
+	}
+	if config.StartType != startAutomatic {
+		if err = wmcb.journal.serviceUpdated(w32TimeServiceName, config); err != nil {
+			return err
+		}
+		config.StartType = startAutomatic
+		if err = service.UpdateConfig(config); err != nil {
+			return fmt.Errorf("error updating %s service config: %v", w32TimeServiceName, err)
+		}
+	}
+	// The Windows Time service needs to be running to be configured
+	if err = startService(service); err != nil {
+		return fmt.Errorf("error starting %s service: %v", w32TimeServiceName, err)
+	}
+
+	wmcb.log.Info("configuring time servers", "servers", servers)
+	if out, err := exec.CommandContext(ctx, "w32tm", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("error configuring %s service: %v: %s", w32TimeServiceName, err, out)
+	}
+	if out, err := exec.CommandContext(ctx, "w32tm", "/resync", "/rediscover").CombinedOutput(); err != nil {
+		return fmt.Errorf("error synchronizing the clock with %s: %v: %s", strings.Join(servers, ", "), err, out)
+	}
+	return nil
+}
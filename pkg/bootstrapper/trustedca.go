@@ -0,0 +1,69 @@
+package bootstrapper
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"path/filepath"
+)
+
+const (
+	// userCABundlePath is the path of the trusted CA bundle of the cluster in the ignition file, holding the
+	// certificates of the trustedCA of the cluster-wide proxy and of the additional trust bundle
+	userCABundlePath = "/etc/pki/ca-trust/source/anchors/openshift-config-user-ca-bundle.crt"
+	// certsDirName is the directory in the install dir the trusted CA bundle is written to
+	certsDirName = "certs"
+	// userCABundleName is the name of the trusted CA bundle in the certs directory of the install dir
+	userCABundleName = "user-ca-bundle.crt"
+	// rootStoreName is the name of the system store holding the trusted root certificates
+	rootStoreName = "ROOT"
+)
+
+// parseCertificates returns the certificates of the given PEM bundle
+func parseCertificates(bundle []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(bundle); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return certs, nil
+}
+
+// installTrustedCABundle writes the given trusted CA bundle of the cluster to the certs directory of the install dir,
+// for the components and the hooks that are given CA files, and adds its certificates to the trusted root certificates
+// of the Windows node. The kubelet and the container runtime verify the proxy and the registries against the trusted
+// root certificates, unless they are given other CA certificates, like the registry CAs.
+func (wmcb *winNodeBootstrapper) installTrustedCABundle(bundle []byte) error {
+	certs, err := parseCertificates(bundle)
+	if err != nil {
+		return fmt.Errorf("invalid trusted CA bundle: %v", err)
+	}
+	dir := filepath.Join(wmcb.installDir, certsDirName)
+	if err = wmcb.mkdirAll(dir); err != nil {
+		return fmt.Errorf("could not make %s directory: %v", dir, err)
+	}
+	path := filepath.Join(dir, userCABundleName)
+	if _, err = wmcb.writeFile(path, bundle); err != nil {
+		return err
+	}
+	if wmcb.dryRun != nil {
+		wmcb.dryRun.addAction("add the %d certificates of the trusted CA bundle to the trusted root certificates",
+			len(certs))
+		return nil
+	}
+
+	if err = addRootCertificates(certs); err != nil {
+		return err
+	}
+	wmcb.log.Info("trusted CA bundle installed", "path", path, "certificates", len(certs))
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package bootstrapper
+
+import "crypto/x509"
+
+// addRootCertificates returns errUnsupportedPlatform as there is no Windows certificate store
+func addRootCertificates(certs []*x509.Certificate) error {
+	return errUnsupportedPlatform
+}
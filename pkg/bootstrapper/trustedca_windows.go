@@ -0,0 +1,33 @@
+package bootstrapper
+
+import (
+	"crypto/x509"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// addRootCertificates adds the given certificates to the trusted root certificates of the local machine, replacing
+// the existing ones
+func addRootCertificates(certs []*x509.Certificate) error {
+	store, err := windows.CertOpenStore(windows.CERT_STORE_PROV_SYSTEM, 0, 0, windows.CERT_SYSTEM_STORE_LOCAL_MACHINE,
+		uintptr(unsafe.Pointer(windows.StringToUTF16Ptr(rootStoreName))))
+	if err != nil {
+		return fmt.Errorf("error opening the trusted root certificates store: %v", err)
+	}
+	defer windows.CertCloseStore(store, 0)
+	for _, cert := range certs {
+		certContext, err := windows.CertCreateCertificateContext(windows.X509_ASN_ENCODING|windows.PKCS_7_ASN_ENCODING,
+			&cert.Raw[0], uint32(len(cert.Raw)))
+		if err != nil {
+			return fmt.Errorf("error decoding certificate %s: %v", cert.Subject, err)
+		}
+		err = windows.CertAddCertificateContextToStore(store, certContext, windows.CERT_STORE_ADD_REPLACE_EXISTING, nil)
+		windows.CertFreeCertificateContext(certContext)
+		if err != nil {
+			return fmt.Errorf("error adding certificate %s to the trusted root certificates: %v", cert.Subject, err)
+		}
+	}
+	return nil
+}
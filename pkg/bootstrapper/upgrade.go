@@ -0,0 +1,190 @@
+package bootstrapper
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+var (
+	// dockershimRemoval is the kubelet version the dockershim was removed in, which only supports the containerd
+	// runtime on Windows
+	dockershimRemoval = version.MustParseGeneric("1.24.0")
+	// removedKubeletArgs are the kubelet arguments removed by the kubelet versions, which are dropped from the command
+	// line of the kubelet service when the kubelet is upgraded to these versions
+	removedKubeletArgs = []struct {
+		// version is the kubelet version the arguments were removed in
+		version *version.Version
+		// args are the names of the removed arguments
+		args []string
+	}{
+		// The CNI plugins are run by the container runtime rather than by the kubelet once the dockershim is removed
+		{version: dockershimRemoval, args: []string{"network-plugin", "cni-bin-dir", "cni-conf-dir",
+			"image-pull-progress-deadline", "docker-endpoint"}},
+		// The remote container runtime is the only one left
+		{version: version.MustParseGeneric("1.27.0"), args: []string{"container-runtime"}},
+	}
+)
+
+// kubeletVersion returns the version of the given kubelet executable
+func kubeletVersion(kubeletPath string) (*version.Version, error) {
+	out, err := exec.Command(kubeletPath, "--version").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("error getting version of %s: %w: %s", kubeletPath, err, out)
+	}
+	// The version is printed as Kubernetes v1.20.0
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no version printed by %s", kubeletPath)
+	}
+	return version.ParseGeneric(fields[len(fields)-1])
+}
+
+// migrateKubeletCommand returns the given command line of the kubelet service without the arguments removed by the
+// given kubelet version, along with the removed arguments
+func migrateKubeletCommand(command string, kubeletVersion *version.Version) (string, []string) {
+	removed := make(map[string]bool)
+	for _, removal := range removedKubeletArgs {
+		if kubeletVersion.AtLeast(removal.version) {
+			for _, arg := range removal.args {
+				removed[arg] = true
+			}
+		}
+	}
+	var kept, dropped []string
+	for _, field := range strings.Fields(command) {
+		name := strings.SplitN(strings.TrimPrefix(field, "--"), "=", 2)[0]
+		if strings.HasPrefix(field, "--") && removed[name] {
+			dropped = append(dropped, field)
+			continue
+		}
+		kept = append(kept, field)
+	}
+	return strings.Join(kept, " "), dropped
+}
+
+// Upgrade upgrades the kubelet and the CNI plugins of the bootstrapped Windows node in place with the ones of the
+// given artifacts, and waits up to the given timeout for the node to be Ready again. The kubelet service and its
+// dependent services are stopped while the binaries are replaced, and the arguments the new kubelet version removed
+// are dropped from the command line of the kubelet service. If any step fails, the previous binaries and kubelet
+// service config are restored and the services are started again. The changes are recorded in the journal of the
+// install directory, so that a successful upgrade can also be reverted using Rollback.
+func (wmcb *winNodeBootstrapper) Upgrade(ctx context.Context, artifacts *Artifacts, timeout time.Duration) error {
+	if wmcb.kubeletSVC == nil {
+		return fmt.Errorf("kubelet service is not present, the Windows node needs to be bootstrapped before being " +
+			"upgraded")
+	}
+	newVersion, err := kubeletVersion(artifacts.KubeletPath())
+	if err != nil {
+		return err
+	}
+	if !wmcb.skipVersionCheck {
+		if err = checkSupportedKubelet(newVersion); err != nil {
+			return newError(ErrVersionSkew, err)
+		}
+		if err = wmcb.checkAPIServerVersion(ctx, newVersion, wmcb.kubeconfigPath); err != nil {
+			return err
+		}
+	}
+	config, err := wmcb.kubeletSVC.config()
+	if err != nil {
+		return fmt.Errorf("error getting kubelet service config: %w", err)
+	}
+	if !strings.Contains(config.BinaryPathName, "--container-runtime=remote") && newVersion.AtLeast(dockershimRemoval) {
+		return fmt.Errorf("kubelet %s does not support the docker runtime, the Windows node needs to be bootstrapped "+
+			"again with the containerd runtime", newVersion)
+	}
+	command, removed := migrateKubeletCommand(config.BinaryPathName, newVersion)
+	// The node keeps the name it was registered with
+	for _, field := range strings.Fields(command) {
+		if strings.HasPrefix(field, "--hostname-override=") {
+			wmcb.kubeletArgOverrides["hostname-override"] = strings.TrimPrefix(field, "--hostname-override=")
+		}
+	}
+
+	// The changes of a previous bootstrap are no longer rolled back, as the upgrade is reverted on its own
+	if wmcb.journal, err = newJournal(wmcb.fileSystem(), wmcb.installDir); err != nil {
+		return err
+	}
+	wmcb.log.Info("upgrading kubelet", "version", newVersion, "removedArgs", removed)
+	if err = wmcb.upgrade(ctx, artifacts, config, command, timeout); err != nil {
+		wmcb.log.Error(err, "upgrade failed, restoring the previous kubelet")
+		if rollbackErr := wmcb.rollbackUpgrade(); rollbackErr != nil {
+			return fmt.Errorf("upgrade failed and could not be rolled back: %v: %w", rollbackErr, err)
+		}
+		return fmt.Errorf("upgrade rolled back: %w", err)
+	}
+	wmcb.log.Info("kubelet upgraded", "version", newVersion)
+	return nil
+}
+
+// upgrade replaces the kubelet and CNI binaries with the ones of the given artifacts, updates the command line of the
+// kubelet service to the given one, restarts the kubelet and waits for the node to be Ready
+func (wmcb *winNodeBootstrapper) upgrade(ctx context.Context, artifacts *Artifacts, config serviceConfig, command string,
+	timeout time.Duration) error {
+	// The kubelet holds handles on its executable and on the CNI plugins while it is running
+	if err := wmcb.kubeletSVC.stop(); err != nil {
+		return fmt.Errorf("unable to stop kubelet service: %w", err)
+	}
+	binaries := map[string]string{artifacts.KubeletPath(): filepath.Join(wmcb.installDir, "kubelet.exe")}
+	if cniDir := artifacts.CNIDir(); cniDir != "" {
+		files, err := wmcb.fileSystem().ReadDir(cniDir)
+		if err != nil {
+			return fmt.Errorf("error reading CNI dir %s: %w", cniDir, err)
+		}
+		binDir := wmcb.cniLayout().binDir
+		if err = wmcb.fileSystem().MkdirAll(binDir, 0755); err != nil {
+			return err
+		}
+		for _, file := range files {
+			if !file.IsDir() {
+				binaries[filepath.Join(cniDir, file.Name())] = filepath.Join(binDir, file.Name())
+			}
+		}
+	}
+	for src, dest := range binaries {
+		if err := wmcb.journal.fileWritten(dest); err != nil {
+			return err
+		}
+		if err := copyFile(wmcb.fileSystem(), src, dest); err != nil {
+			return fmt.Errorf("error copying %s --> %s: %w", src, dest, err)
+		}
+	}
+
+	if err := wmcb.journal.serviceUpdated(KubeletServiceName, config); err != nil {
+		return err
+	}
+	config.BinaryPathName = command
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("upgrade interrupted: %w", err)
+	}
+	if err := wmcb.kubeletSVC.refresh(ctx, config); err != nil {
+		return fmt.Errorf("unable to restart kubelet service: %w", err)
+	}
+	wmcb.recordServiceRestart(KubeletServiceName)
+	if err := wmcb.updateKubeletUnit(); err != nil {
+		return err
+	}
+	return wmcb.WaitForNodeReady(ctx, timeout)
+}
+
+// rollbackUpgrade restores the kubelet and CNI binaries and the kubelet service config of before the upgrade, and
+// starts the kubelet service and its dependent services again
+func (wmcb *winNodeBootstrapper) rollbackUpgrade() error {
+	if err := wmcb.kubeletSVC.stop(); err != nil {
+		return fmt.Errorf("unable to stop kubelet service: %w", err)
+	}
+	if err := wmcb.Rollback(); err != nil {
+		return err
+	}
+	if err := wmcb.kubeletSVC.start(); err != nil {
+		return fmt.Errorf("unable to start kubelet service: %w", err)
+	}
+	wmcb.recordServiceRestart(KubeletServiceName)
+	return nil
+}
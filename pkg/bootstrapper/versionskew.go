@@ -0,0 +1,154 @@
+package bootstrapper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/util/version"
+	apiversion "k8s.io/apimachinery/pkg/version"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// maxKubeletSkew is the number of minor versions the kubelet can be older than the API server by, according to the
+// Kubernetes version skew policy. The kubelet cannot be newer than the API server.
+const maxKubeletSkew = 2
+
+var (
+	// minKubeletVersion and maxKubeletVersion are the oldest and the newest kubelet minor versions WMCB supports. They
+	// can be overridden when building WMCB with -ldflags -X.
+	minKubeletVersion = "1.20"
+	maxKubeletVersion = "1.27"
+)
+
+// SupportedKubeletVersions returns the oldest and the newest kubelet minor versions WMCB supports
+func SupportedKubeletVersions() (string, string) {
+	return minKubeletVersion, maxKubeletVersion
+}
+
+// SkipVersionCheck disables the validation of the kubelet version against the versions WMCB supports and the version
+// of the API server. This needs to be called before InitializeKubelet or Upgrade to take effect.
+func (wmcb *winNodeBootstrapper) SkipVersionCheck() {
+	wmcb.skipVersionCheck = true
+}
+
+// checkSupportedKubelet returns an error if the minor version of the given kubelet version is not within the range
+// WMCB supports
+func checkSupportedKubelet(kubelet *version.Version) error {
+	minVersion, err := version.ParseGeneric(minKubeletVersion)
+	if err != nil {
+		return fmt.Errorf("invalid minimum kubelet version: %w", err)
+	}
+	maxVersion, err := version.ParseGeneric(maxKubeletVersion)
+	if err != nil {
+		return fmt.Errorf("invalid maximum kubelet version: %w", err)
+	}
+	if compareMinor(kubelet, minVersion) < 0 || compareMinor(kubelet, maxVersion) > 0 {
+		return fmt.Errorf("kubelet %s is not supported, the supported kubelet versions are %s to %s", kubelet,
+			minKubeletVersion, maxKubeletVersion)
+	}
+	return nil
+}
+
+// checkVersionSkew returns an error if the given kubelet version is not compatible with the given API server version,
+// which is the case if the kubelet is newer than the API server or older by more than maxKubeletSkew minor versions
+func checkVersionSkew(kubelet, apiServer *version.Version) error {
+	if kubelet.Major() != apiServer.Major() {
+		return fmt.Errorf("kubelet %s and the API server %s have different major versions", kubelet, apiServer)
+	}
+	if kubelet.Minor() > apiServer.Minor() {
+		return fmt.Errorf("kubelet %s is newer than the API server %s", kubelet, apiServer)
+	}
+	if apiServer.Minor()-kubelet.Minor() > maxKubeletSkew {
+		return fmt.Errorf("kubelet %s is more than %d minor versions older than the API server %s", kubelet,
+			maxKubeletSkew, apiServer)
+	}
+	return nil
+}
+
+// compareMinor compares the major and minor versions of the given versions, returning -1, 0 or 1 if the first one is
+// older, the same or newer
+func compareMinor(a, b *version.Version) int {
+	for _, components := range [][2]uint{{a.Major(), b.Major()}, {a.Minor(), b.Minor()}} {
+		if components[0] < components[1] {
+			return -1
+		}
+		if components[0] > components[1] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// apiServerVersion returns the version of the API server of the given kubeconfig
+func apiServerVersion(ctx context.Context, kubeconfigPath string) (*version.Version, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("error loading kubeconfig %s: %w", kubeconfigPath, err)
+	}
+	client, err := corev1client.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	body, err := client.RESTClient().Get().AbsPath("/version").DoRaw(ctx)
+	if err != nil {
+		return nil, transientError(fmt.Errorf("error getting API server version: %w", err))
+	}
+	var info apiversion.Info
+	if err = json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("invalid API server version: %w", err)
+	}
+	return version.ParseGeneric(info.GitVersion)
+}
+
+// checkKubeletVersion returns the version of the given kubelet executable, or an ErrVersionSkew error if the version
+// is not supported by WMCB
+func checkKubeletVersion(kubeletPath string) (*version.Version, error) {
+	kubelet, err := kubeletVersion(kubeletPath)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSupportedKubelet(kubelet); err != nil {
+		return nil, newError(ErrVersionSkew, err)
+	}
+	return kubelet, nil
+}
+
+// checkAPIServerVersion returns an ErrVersionSkew error if the given kubelet version is not compatible with the version
+// of the API server of the given kubeconfig. The API server version is only checked if the kubeconfig is present and
+// the API server can be reached, as the kubelet reports its own connectivity errors.
+func (wmcb *winNodeBootstrapper) checkAPIServerVersion(ctx context.Context, kubelet *version.Version,
+	kubeconfigPath string) error {
+	if _, err := wmcb.fileSystem().Stat(kubeconfigPath); err != nil {
+		return nil
+	}
+	apiServer, err := apiServerVersion(ctx, kubeconfigPath)
+	if err != nil {
+		wmcb.log.Info("unable to check kubelet version against API server", "error", err.Error())
+		return nil
+	}
+	if err = checkVersionSkew(kubelet, apiServer); err != nil {
+		return newError(ErrVersionSkew, err)
+	}
+	wmcb.log.Info("kubelet version is compatible with API server", "kubelet", kubelet, "apiServer", apiServer)
+	return nil
+}
+
+// initialKubeletVersion returns the version of the kubelet InitializeKubelet installs, or of the installed kubelet if
+// none is given, or an ErrVersionSkew error if the version is not supported by WMCB. Nil is returned if there is no
+// kubelet to check or the version check is skipped.
+func (wmcb *winNodeBootstrapper) initialKubeletVersion() (*version.Version, error) {
+	if wmcb.skipVersionCheck {
+		return nil, nil
+	}
+	kubeletPath := wmcb.initialKubeletPath
+	if kubeletPath == "" {
+		kubeletPath = filepath.Join(wmcb.installDir, "kubelet.exe")
+		if _, err := wmcb.fileSystem().Stat(kubeletPath); err != nil {
+			return nil, nil
+		}
+	}
+	return checkKubeletVersion(kubeletPath)
+}
@@ -0,0 +1,75 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// currentVersionRegistryPath is the registry path that holds the version information of the Windows installation
+const currentVersionRegistryPath = `SOFTWARE\Microsoft\Windows NT\CurrentVersion`
+
+// windowsBuild holds the build specific defaults of a supported Windows Server build
+type windowsBuild struct {
+	// name is the release name of the build
+	name string
+	// pauseImage is the pause image that matches the build, as Windows containers require the container image and
+	// host builds to match when run with process isolation
+	pauseImage string
+	// enableDSR indicates if kube-proxy can use Direct Server Return for the load balancers, which requires HNS
+	// support that is only present from 2004 onwards
+	enableDSR bool
+}
+
+// supportedWindowsBuilds maps the build numbers of the supported Windows Server builds to their defaults
+var supportedWindowsBuilds = map[uint64]windowsBuild{
+	17763: {name: "1809", pauseImage: kubeletPauseContainerImage, enableDSR: false},
+	19041: {name: "2004", pauseImage: kubeletPauseContainerImage, enableDSR: true},
+	19042: {name: "20H2", pauseImage: kubeletPauseContainerImage, enableDSR: true},
+	20348: {name: "2022", pauseImage: "mcr.microsoft.com/oss/kubernetes/pause:3.6", enableDSR: true},
+}
+
+// getWindowsBuild returns the defaults of the given Windows build number, or an error if the build is not supported
+func getWindowsBuild(buildNumber uint64) (*windowsBuild, error) {
+	build, ok := supportedWindowsBuilds[buildNumber]
+	if !ok {
+		var supported []string
+		for number, build := range supportedWindowsBuilds {
+			supported = append(supported, fmt.Sprintf("%s (%d)", build.name, number))
+		}
+		sort.Strings(supported)
+		return nil, fmt.Errorf("unsupported Windows build %d, supported builds are %s", buildNumber,
+			strings.Join(supported, ", "))
+	}
+	return &build, nil
+}
+
+// detectWindowsBuild detects the Windows build the bootstrapper is running on and selects the build specific
+// defaults. Returns an error if the build is not supported, so that the node is not bootstrapped.
+func (wmcb *winNodeBootstrapper) detectWindowsBuild() error {
+	buildNumber, err := currentWindowsBuildNumber()
+	if err != nil {
+		return err
+	}
+	build, err := getWindowsBuild(buildNumber)
+	if err != nil {
+		return err
+	}
+	wmcb.log.Info("detected Windows build", "build", build.name, "buildNumber", buildNumber)
+	wmcb.windowsBuild = build
+	return nil
+}
+
+// pauseImage returns the pause image that matches the Windows build. The default pause image is returned if the build
+// has not been detected.
+func (wmcb *winNodeBootstrapper) pauseImage() string {
+	if wmcb.windowsBuild == nil {
+		return kubeletPauseContainerImage
+	}
+	return wmcb.windowsBuild.pauseImage
+}
+
+// enableDSR returns true if kube-proxy can use Direct Server Return on the Windows build
+func (wmcb *winNodeBootstrapper) enableDSR() bool {
+	return wmcb.windowsBuild != nil && wmcb.windowsBuild.enableDSR
+}
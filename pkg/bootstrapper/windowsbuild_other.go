@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package bootstrapper
+
+// currentWindowsBuildNumber returns errUnsupportedPlatform as there is no Windows installation
+func currentWindowsBuildNumber() (uint64, error) {
+	return 0, errUnsupportedPlatform
+}
+
+// currentWindowsPatchBuild returns errUnsupportedPlatform as there is no Windows installation
+func currentWindowsPatchBuild() (string, error) {
+	return "", errUnsupportedPlatform
+}
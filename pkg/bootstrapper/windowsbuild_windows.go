@@ -0,0 +1,43 @@
+package bootstrapper
+
+import (
+	"fmt"
+	"strconv"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// currentWindowsBuildNumber returns the build number of the Windows installation the bootstrapper is running on
+func currentWindowsBuildNumber() (uint64, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, currentVersionRegistryPath, registry.QUERY_VALUE)
+	if err != nil {
+		return 0, fmt.Errorf("error opening registry key %s: %v", currentVersionRegistryPath, err)
+	}
+	defer key.Close()
+
+	buildNumber, _, err := key.GetStringValue("CurrentBuildNumber")
+	if err != nil {
+		return 0, fmt.Errorf("error reading Windows build number: %v", err)
+	}
+	return strconv.ParseUint(buildNumber, 10, 32)
+}
+
+// currentWindowsPatchBuild returns the build number and update build revision of the Windows installation the
+// bootstrapper is running on, like 17763.2237, which identifies the cumulative update it is patched with
+func currentWindowsPatchBuild() (string, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, currentVersionRegistryPath, registry.QUERY_VALUE)
+	if err != nil {
+		return "", fmt.Errorf("error opening registry key %s: %v", currentVersionRegistryPath, err)
+	}
+	defer key.Close()
+
+	buildNumber, _, err := key.GetStringValue("CurrentBuildNumber")
+	if err != nil {
+		return "", fmt.Errorf("error reading Windows build number: %v", err)
+	}
+	revision, _, err := key.GetIntegerValue("UBR")
+	if err != nil {
+		return buildNumber, fmt.Errorf("error reading Windows update build revision: %v", err)
+	}
+	return fmt.Sprintf("%s.%d", buildNumber, revision), nil
+}
@@ -0,0 +1,132 @@
+package bootstrapper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	// containersFeature is the Windows optional feature required by every container runtime
+	containersFeature = "Containers"
+	// hyperVFeature is the Windows optional feature required to run Hyper-V isolated containers
+	hyperVFeature = "Microsoft-Hyper-V"
+)
+
+// containerServices are the Windows services of the Host Networking Service and of the Host Compute Service the
+// container runtime and the CNI plugins rely on. They are installed along with the Containers feature.
+var containerServices = []string{"hns", "vmcompute"}
+
+// requiredWindowsFeatures returns the Windows optional features required by the container runtime, including
+// Hyper-V if hyperV is true
+func requiredWindowsFeatures(hyperV bool) []string {
+	features := []string{containersFeature}
+	if hyperV {
+		features = append(features, hyperVFeature)
+	}
+	return features
+}
+
+// checkContainerService returns an error if the Windows service with the given name is not installed or is disabled
+func (wmcb *winNodeBootstrapper) checkContainerService(name string) error {
+	service, err := wmcb.svcMgr.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("%s service is not installed: %v", name, err)
+	}
+	defer service.Close()
+	config, err := service.Config()
+	if err != nil {
+		return fmt.Errorf("could not get config of %s service: %v", name, err)
+	}
+	if config.StartType == startDisabled {
+		return fmt.Errorf("%s service is disabled", name)
+	}
+	return nil
+}
+
+// enableWindowsFeature enables the given Windows optional feature along with its parent features, and returns true
+// if the Windows node needs to be rebooted for it to take effect
+func enableWindowsFeature(feature string) (bool, error) {
+	out, err := runPowerShell("(Enable-WindowsOptionalFeature -Online -All -NoRestart -FeatureName " + feature +
+		").RestartNeeded")
+	if err != nil {
+		return false, fmt.Errorf("error enabling %s: %v", feature, err)
+	}
+	return strings.EqualFold(strings.TrimSpace(out), "True"), nil
+}
+
+// enableContainerService sets the Windows service with the given name to start on demand if it is disabled, and
+// starts it
+func (wmcb *winNodeBootstrapper) enableContainerService(name string) error {
+	service, err := wmcb.svcMgr.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("%s service is not installed: %v", name, err)
+	}
+	defer service.Close()
+	config, err := service.Config()
+	if err != nil {
+		return fmt.Errorf("could not get config of %s service: %v", name, err)
+	}
+	if config.StartType == startDisabled {
+		wmcb.log.Info("enabling service", "service", name)
+		config.StartType = startManual
+		if err = service.UpdateConfig(config); err != nil {
+			return fmt.Errorf("could not enable %s service: %v", name, err)
+		}
+	}
+	if err = startService(service); err != nil {
+		return fmt.Errorf("could not start %s service: %v", name, err)
+	}
+	return nil
+}
+
+// EnableWindowsFeatures enables the Windows optional features required by the container runtime, the Containers
+// feature and the Hyper-V one if hyperV is true, and the Host Networking Service and Host Compute Service they
+// install, rather than assuming that the image of the Windows node was prepared with them. The features already
+// enabled are left as is, which makes it safe to run again once the node has been rebooted. An ErrRebootRequired error
+// is returned if the node needs to be rebooted for the features to take effect, before it is bootstrapped, in which
+// case the services are enabled by running it again after the reboot. In dry-run mode, the changes are recorded in the
+// plan instead.
+func (wmcb *winNodeBootstrapper) EnableWindowsFeatures(ctx context.Context, hyperV bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if wmcb.dryRun == nil {
+		wmcb.startPhase()
+	}
+	rebootRequired := false
+	for _, feature := range requiredWindowsFeatures(hyperV) {
+		if checkWindowsFeature(feature) == nil {
+			continue
+		}
+		if wmcb.dryRun != nil {
+			wmcb.dryRun.addAction("enable the %s Windows feature", feature)
+			continue
+		}
+		wmcb.log.Info("enabling Windows feature", "feature", feature)
+		restartNeeded, err := enableWindowsFeature(feature)
+		if err != nil {
+			return wmcb.recordPhase(PhaseFeaturesEnabled, err)
+		}
+		rebootRequired = rebootRequired || restartNeeded
+	}
+	if wmcb.dryRun != nil {
+		for _, name := range containerServices {
+			if wmcb.checkContainerService(name) != nil {
+				wmcb.dryRun.addAction("enable and start the %s service", name)
+			}
+		}
+		return nil
+	}
+	// The services are only installed once the node has rebooted with the Containers feature
+	if rebootRequired {
+		return wmcb.recordPhase(PhaseFeaturesEnabled, newError(ErrRebootRequired,
+			fmt.Errorf("the node needs to be rebooted for the Windows features to take effect")))
+	}
+	for _, name := range containerServices {
+		if err := wmcb.enableContainerService(name); err != nil {
+			return wmcb.recordPhase(PhaseFeaturesEnabled, err)
+		}
+	}
+	return wmcb.recordPhase(PhaseFeaturesEnabled, nil)
+}
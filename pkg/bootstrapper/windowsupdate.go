@@ -0,0 +1,170 @@
+package bootstrapper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// kbPattern matches the knowledge base IDs of the Windows updates, like KB5005568
+var kbPattern = regexp.MustCompile(`^KB[0-9]+$`)
+
+// windowsUpdateScript installs the updates with the knowledge base IDs given in the $kbs array through the Windows
+// Update Agent API, and prints the IDs of the updates it installed, the IDs of the updates installed on the node and
+// whether a reboot is required, in the JSON format. The updates that are not in $kbs are never installed, even if
+// they are available.
+const windowsUpdateScript = `$session = New-Object -ComObject Microsoft.Update.Session
+$result = $session.CreateUpdateSearcher().Search("IsInstalled=0 and Type='Software'")
+$updates = New-Object -ComObject Microsoft.Update.UpdateColl
+$applied = @()
+foreach ($update in $result.Updates) {
+  foreach ($id in $update.KBArticleIDs) {
+    if ($kbs -contains "KB$id") {
+      if (-not $update.EulaAccepted) { $update.AcceptEula() }
+      [void]$updates.Add($update)
+      $applied += "KB$id"
+      break
+    }
+  }
+}
+$rebootRequired = $false
+if ($updates.Count -gt 0) {
+  $downloader = $session.CreateUpdateDownloader()
+  $downloader.Updates = $updates
+  [void]$downloader.Download()
+  $installer = $session.CreateUpdateInstaller()
+  $installer.Updates = $updates
+  $installation = $installer.Install()
+  if ($installation.ResultCode -ne 2) { throw "installing the updates failed with result code $($installation.ResultCode)" }
+  $rebootRequired = $installation.RebootRequired
+}
+$installed = @(Get-HotFix | ForEach-Object { $_.HotFixID })
+$rebootRequired = $rebootRequired -or (New-Object -ComObject Microsoft.Update.SystemInfo).RebootRequired
+@{applied = @($applied); installed = $installed; rebootRequired = $rebootRequired} | ConvertTo-Json -Compress`
+
+// PatchLevel is the patch level of the Windows node that is recorded in the status file
+type PatchLevel struct {
+	// Build is the build number and update build revision of Windows, like 17763.2237
+	Build string `json:"build"`
+	// InstalledKBs are the knowledge base IDs of the updates installed on the node
+	InstalledKBs []string `json:"installedKBs"`
+	// AppliedKBs are the knowledge base IDs of the updates installed by the latest ApplyWindowsUpdates
+	AppliedKBs []string `json:"appliedKBs,omitempty"`
+	// RebootRequired indicates that the node needs to be rebooted for the installed updates to take effect
+	RebootRequired bool `json:"rebootRequired"`
+	// Timestamp is the time at which the patch level was recorded
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// windowsUpdateResult is the output of windowsUpdateScript
+type windowsUpdateResult struct {
+	Applied        []string `json:"applied"`
+	Installed      []string `json:"installed"`
+	RebootRequired bool     `json:"rebootRequired"`
+}
+
+// normalizeKBs returns the given knowledge base IDs in upper case with the KB prefix, sorted and without duplicates,
+// or an error if one of them is not a knowledge base ID
+func normalizeKBs(kbs []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var normalized []string
+	for _, kb := range kbs {
+		kb = strings.ToUpper(strings.TrimSpace(kb))
+		if !strings.HasPrefix(kb, "KB") {
+			kb = "KB" + kb
+		}
+		if !kbPattern.MatchString(kb) {
+			return nil, fmt.Errorf("invalid knowledge base ID %q, expected an ID like KB5005568", kb)
+		}
+		if !seen[kb] {
+			seen[kb] = true
+			normalized = append(normalized, kb)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// missingKBs returns the given knowledge base IDs that are neither installed nor applied
+func missingKBs(kbs []string, result *windowsUpdateResult) []string {
+	present := make(map[string]bool)
+	for _, kb := range append(result.Installed, result.Applied...) {
+		present[strings.ToUpper(kb)] = true
+	}
+	var missing []string
+	for _, kb := range kbs {
+		if !present[kb] {
+			missing = append(missing, kb)
+		}
+	}
+	return missing
+}
+
+// ApplyWindowsUpdates installs the Windows updates with the given knowledge base IDs, like KB5005568, through Windows
+// Update. Only the given updates are installed, so that the patch level of the node is pinned rather than following
+// the latest updates. The updates already installed are skipped, which makes it safe to run again once the node has
+// been rebooted. The patch level of the node is recorded in the status file and returned. An ErrRebootRequired error
+// is returned along with it if the node needs to be rebooted for the updates to take effect, before it is
+// bootstrapped. Nothing is done if no update is given, and in dry-run mode the installation is recorded in the plan
+// instead.
+func (wmcb *winNodeBootstrapper) ApplyWindowsUpdates(ctx context.Context, kbs []string) (*PatchLevel, error) {
+	kbs, err := normalizeKBs(kbs)
+	if err != nil {
+		return nil, err
+	}
+	if len(kbs) == 0 {
+		return nil, nil
+	}
+	if wmcb.dryRun != nil {
+		wmcb.dryRun.addAction("install the Windows updates %s", strings.Join(kbs, ", "))
+		return nil, nil
+	}
+	wmcb.startPhase()
+	wmcb.log.Info("installing Windows updates", "kbs", kbs)
+	command := fmt.Sprintf("$kbs = @(%s)\n%s", psList(kbs), windowsUpdateScript)
+	out, err := exec.CommandContext(ctx, powerShellExe, "-NonInteractive", "-ExecutionPolicy", "Bypass", "-Command",
+		command).CombinedOutput()
+	if err != nil {
+		return nil, wmcb.recordPhase(PhaseUpdatesApplied, transientError(fmt.Errorf("error installing Windows "+
+			"updates: %w: %s", err, out)))
+	}
+	var result windowsUpdateResult
+	if err = json.Unmarshal(out, &result); err != nil {
+		return nil, wmcb.recordPhase(PhaseUpdatesApplied, fmt.Errorf("error parsing Windows Update output %q: %v",
+			out, err))
+	}
+	build, err := currentWindowsPatchBuild()
+	if err != nil {
+		wmcb.log.Error(err, "unable to read the update build revision")
+	}
+	patchLevel := &PatchLevel{Build: build, InstalledKBs: result.Installed, AppliedKBs: result.Applied,
+		RebootRequired: result.RebootRequired, Timestamp: wmcb.now()}
+	sort.Strings(patchLevel.InstalledKBs)
+	wmcb.updateStatus(func(status *Status) { status.PatchLevel = patchLevel })
+
+	if missing := missingKBs(kbs, &result); len(missing) > 0 {
+		return patchLevel, wmcb.recordPhase(PhaseUpdatesApplied, fmt.Errorf("the Windows updates %s are not "+
+			"offered by Windows Update for this node", strings.Join(missing, ", ")))
+	}
+	if result.RebootRequired {
+		return patchLevel, wmcb.recordPhase(PhaseUpdatesApplied, newError(ErrRebootRequired,
+			fmt.Errorf("the node needs to be rebooted for the Windows updates to take effect")))
+	}
+	return patchLevel, wmcb.recordPhase(PhaseUpdatesApplied, nil)
+}
+
+// RebootNode restarts the Windows node after the given delay, so that the command requesting the reboot can exit and
+// report its outcome first
+func RebootNode(delay time.Duration) error {
+	out, err := exec.Command("shutdown.exe", "/r", "/t", fmt.Sprint(int(delay.Seconds())), "/d", "p:2:17",
+		"/c", "Rebooting to complete the Windows node configuration").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error scheduling reboot: %v: %s", err, out)
+	}
+	return nil
+}
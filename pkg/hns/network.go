@@ -0,0 +1,54 @@
+// Package hns manages the lifecycle of the HNS networks the Windows CNI plugins attach the pods to
+package hns
+
+import (
+	"fmt"
+	"net"
+)
+
+// NetworkType is the type of an HNS network used by the Windows CNI plugins
+type NetworkType string
+
+const (
+	// Overlay is the type of the network used by the win-overlay CNI plugin
+	Overlay NetworkType = "overlay"
+	// L2Bridge is the type of the network used by the win-bridge CNI plugin
+	L2Bridge NetworkType = "l2bridge"
+)
+
+// NetworkConfig describes the HNS network the CNI config refers to
+type NetworkConfig struct {
+	// Name is the name of the network
+	Name string
+	// Type is the type of the network
+	Type NetworkType
+	// AddressPrefix is the CIDR of the subnet of the network, for example the pod subnet of the node
+	AddressPrefix string
+	// Gateway is the IP address of the gateway of the subnet
+	Gateway string
+	// AdapterName is the name of the network adapter the network is bound to. HNS picks the adapter if it is empty.
+	AdapterName string
+	// VSID is the virtual subnet ID the subnet of an overlay network is isolated with. Defaults to 4096.
+	VSID uint32
+}
+
+// Validate returns an error if the network cannot be created from the config
+func (c NetworkConfig) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("network name cannot be empty")
+	}
+	if c.Type != Overlay && c.Type != L2Bridge {
+		return fmt.Errorf("unsupported network type %s, expected %s or %s", c.Type, Overlay, L2Bridge)
+	}
+	_, subnet, err := net.ParseCIDR(c.AddressPrefix)
+	if err != nil {
+		return fmt.Errorf("invalid address prefix %s: %v", c.AddressPrefix, err)
+	}
+	if gateway := net.ParseIP(c.Gateway); gateway == nil || !subnet.Contains(gateway) {
+		return fmt.Errorf("invalid gateway %s, expected an IP address in %s", c.Gateway, c.AddressPrefix)
+	}
+	if c.VSID != 0 && c.Type != Overlay {
+		return fmt.Errorf("VSID can only be set for %s networks", Overlay)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package hns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Microsoft/hcsshim/hcn"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// defaultVSID is the virtual subnet ID the subnet of an overlay network is isolated with unless given
+	defaultVSID = 4096
+	// defaultRoutePrefix is the destination prefix of the default route of the subnet of a network
+	defaultRoutePrefix = "0.0.0.0/0"
+	// pollInterval is the interval at which the availability of a network is checked
+	pollInterval = 2 * time.Second
+)
+
+// hcnType returns the HCN type of the network
+func (c NetworkConfig) hcnType() hcn.NetworkType {
+	if c.Type == Overlay {
+		return hcn.Overlay
+	}
+	return hcn.L2Bridge
+}
+
+// hostComputeNetwork returns the HCN network described by the config
+func (c NetworkConfig) hostComputeNetwork() (*hcn.HostComputeNetwork, error) {
+	subnet := hcn.Subnet{
+		IpAddressPrefix: c.AddressPrefix,
+		Routes:          []hcn.Route{{NextHop: c.Gateway, DestinationPrefix: defaultRoutePrefix}},
+	}
+	if c.Type == Overlay {
+		vsid := c.VSID
+		if vsid == 0 {
+			vsid = defaultVSID
+		}
+		policy, err := subnetPolicy(hcn.VSID, hcn.VsidPolicySetting{IsolationId: vsid})
+		if err != nil {
+			return nil, err
+		}
+		subnet.Policies = []json.RawMessage{policy}
+	}
+
+	network := &hcn.HostComputeNetwork{
+		Name:          c.Name,
+		Type:          c.hcnType(),
+		Ipams:         []hcn.Ipam{{Type: "Static", Subnets: []hcn.Subnet{subnet}}},
+		SchemaVersion: hcn.V2SchemaVersion(),
+	}
+	if c.AdapterName != "" {
+		settings, err := json.Marshal(hcn.NetAdapterNameNetworkPolicySetting{NetworkAdapterName: c.AdapterName})
+		if err != nil {
+			return nil, err
+		}
+		network.Policies = []hcn.NetworkPolicy{{Type: hcn.NetAdapterName, Settings: settings}}
+	}
+	return network, nil
+}
+
+// subnetPolicy returns the JSON encoded subnet policy of the given type with the given settings
+func subnetPolicy(policyType hcn.SubnetPolicyType, settings interface{}) (json.RawMessage, error) {
+	rawSettings, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(hcn.SubnetPolicy{Type: policyType, Settings: rawSettings})
+}
+
+// mismatches returns how the given network differs from the config. The network matches the config if none are
+// returned.
+func (c NetworkConfig) mismatches(network *hcn.HostComputeNetwork) []string {
+	var mismatches []string
+	if !strings.EqualFold(string(network.Type), string(c.hcnType())) {
+		mismatches = append(mismatches, fmt.Sprintf("type is %s instead of %s", network.Type, c.hcnType()))
+	}
+
+	var subnet *hcn.Subnet
+	for _, ipam := range network.Ipams {
+		for i := range ipam.Subnets {
+			if ipam.Subnets[i].IpAddressPrefix == c.AddressPrefix {
+				subnet = &ipam.Subnets[i]
+			}
+		}
+	}
+	if subnet == nil {
+		mismatches = append(mismatches, fmt.Sprintf("subnet %s is missing", c.AddressPrefix))
+	} else if !hasRoute(subnet.Routes, c.Gateway) {
+		mismatches = append(mismatches, fmt.Sprintf("gateway of subnet %s is not %s", c.AddressPrefix, c.Gateway))
+	}
+
+	if c.AdapterName != "" && adapterName(network.Policies) != c.AdapterName {
+		mismatches = append(mismatches, fmt.Sprintf("network adapter is not %s", c.AdapterName))
+	}
+	return mismatches
+}
+
+// hasRoute returns true if the given routes contain the default route through the given gateway
+func hasRoute(routes []hcn.Route, gateway string) bool {
+	for _, route := range routes {
+		if route.DestinationPrefix == defaultRoutePrefix && route.NextHop == gateway {
+			return true
+		}
+	}
+	return false
+}
+
+// adapterName returns the name of the network adapter set by the given network policies, or an empty string if none
+// is set
+func adapterName(policies []hcn.NetworkPolicy) string {
+	for _, policy := range policies {
+		if policy.Type != hcn.NetAdapterName {
+			continue
+		}
+		var setting hcn.NetAdapterNameNetworkPolicySetting
+		if err := json.Unmarshal(policy.Settings, &setting); err == nil {
+			return setting.NetworkAdapterName
+		}
+	}
+	return ""
+}
+
+// GetNetwork returns the HNS network with the given name, or nil if it does not exist
+func GetNetwork(name string) (*hcn.HostComputeNetwork, error) {
+	network, err := hcn.GetNetworkByName(name)
+	if err != nil {
+		if hcn.IsNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting HNS network %s: %v", name, err)
+	}
+	return network, nil
+}
+
+// WaitForNetwork waits for the HNS network with the given name to become available and returns it. Waiting is aborted
+// once the context is done.
+func WaitForNetwork(ctx context.Context, name string, timeout time.Duration) (*hcn.HostComputeNetwork, error) {
+	var network *hcn.HostComputeNetwork
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := wait.PollImmediateUntil(pollInterval, func() (bool, error) {
+		var err error
+		network, err = GetNetwork(name)
+		if err != nil {
+			return false, err
+		}
+		return network != nil, nil
+	}, ctx.Done())
+	if err != nil {
+		return nil, fmt.Errorf("HNS network %s is not available: %v", name, err)
+	}
+	return network, nil
+}
+
+// CreateNetwork creates the HNS network described by the config and waits for it to become available
+func CreateNetwork(ctx context.Context, config NetworkConfig, timeout time.Duration) (*hcn.HostComputeNetwork,
+	error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	network, err := config.hostComputeNetwork()
+	if err != nil {
+		return nil, fmt.Errorf("error generating HNS network %s: %v", config.Name, err)
+	}
+	// The connectivity of the host is briefly lost while the virtual switch of the network is created
+	if _, err = network.Create(); err != nil {
+		return nil, fmt.Errorf("error creating HNS network %s: %v", config.Name, err)
+	}
+	return WaitForNetwork(ctx, config.Name, timeout)
+}
+
+// ListNetworks returns all the HNS networks on the node, including the ones not used by the Windows CNI plugins
+func ListNetworks() ([]hcn.HostComputeNetwork, error) {
+	networks, err := hcn.ListNetworks()
+	if err != nil {
+		return nil, fmt.Errorf("error listing HNS networks: %v", err)
+	}
+	return networks, nil
+}
+
+// ListEndpoints returns all the HNS endpoints on the node
+func ListEndpoints() ([]hcn.HostComputeEndpoint, error) {
+	endpoints, err := hcn.ListEndpoints()
+	if err != nil {
+		return nil, fmt.Errorf("error listing HNS endpoints: %v", err)
+	}
+	return endpoints, nil
+}
+
+// DeleteNetwork deletes the HNS network with the given name along with the endpoints attached to it. It does not fail
+// if the network does not exist.
+func DeleteNetwork(name string) error {
+	network, err := GetNetwork(name)
+	if err != nil || network == nil {
+		return err
+	}
+	return deleteNetwork(network)
+}
+
+// DeleteNetworks deletes the overlay and l2bridge HNS networks used by the Windows CNI plugins along with the
+// endpoints of the pods attached to them, and returns the names of the deleted networks
+func DeleteNetworks() ([]string, error) {
+	networks, err := ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for i := range networks {
+		network := &networks[i]
+		if !strings.EqualFold(string(network.Type), string(hcn.Overlay)) &&
+			!strings.EqualFold(string(network.Type), string(hcn.L2Bridge)) {
+			continue
+		}
+		if err = deleteNetwork(network); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, network.Name)
+	}
+	return deleted, nil
+}
+
+// deleteNetwork deletes the given HNS network after deleting the endpoints attached to it
+func deleteNetwork(network *hcn.HostComputeNetwork) error {
+	endpoints, err := hcn.ListEndpointsOfNetwork(network.Id)
+	if err != nil {
+		return fmt.Errorf("error listing endpoints of HNS network %s: %v", network.Name, err)
+	}
+	for i := range endpoints {
+		if err = endpoints[i].Delete(); err != nil && !hcn.IsNotFoundError(err) {
+			return fmt.Errorf("error deleting HNS endpoint %s of network %s: %v", endpoints[i].Name, network.Name,
+				err)
+		}
+	}
+	if err = network.Delete(); err != nil && !hcn.IsNotFoundError(err) {
+		return fmt.Errorf("error deleting HNS network %s: %v", network.Name, err)
+	}
+	return nil
+}
+
+// EnsureNetwork ensures that the HNS network described by the config is present and available, and returns it. A
+// network with the same name that does not match the config is deleted and recreated, in which case the mismatches are
+// returned.
+func EnsureNetwork(ctx context.Context, config NetworkConfig, timeout time.Duration) (*hcn.HostComputeNetwork,
+	[]string, error) {
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
+	}
+	network, err := GetNetwork(config.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	var mismatches []string
+	if network != nil {
+		if mismatches = config.mismatches(network); len(mismatches) == 0 {
+			return network, nil, nil
+		}
+		if err = DeleteNetwork(config.Name); err != nil {
+			return nil, mismatches, err
+		}
+	}
+	network, err = CreateNetwork(ctx, config, timeout)
+	return network, mismatches, err
+}
@@ -0,0 +1,60 @@
+package hns
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Microsoft/hcsshim/hcn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNetworkConfig tests that the network config is validated, and that the networks generated from it match it
+// unless their settings have changed
+func TestNetworkConfig(t *testing.T) {
+	overlay := NetworkConfig{Name: "OpenShiftNetwork", Type: Overlay, AddressPrefix: "10.132.1.0/24",
+		Gateway: "10.132.1.1"}
+
+	invalid := []NetworkConfig{
+		{Type: Overlay, AddressPrefix: "10.132.1.0/24", Gateway: "10.132.1.1"},
+		{Name: "net", Type: "nat", AddressPrefix: "10.132.1.0/24", Gateway: "10.132.1.1"},
+		{Name: "net", Type: Overlay, AddressPrefix: "10.132.1.0", Gateway: "10.132.1.1"},
+		{Name: "net", Type: Overlay, AddressPrefix: "10.132.1.0/24", Gateway: "10.132.2.1"},
+		{Name: "net", Type: L2Bridge, AddressPrefix: "10.132.1.0/24", Gateway: "10.132.1.1", VSID: 4097},
+	}
+	for _, config := range invalid {
+		assert.Error(t, config.Validate(), "no error thrown for invalid config %+v", config)
+	}
+	require.NoError(t, overlay.Validate())
+
+	network, err := overlay.hostComputeNetwork()
+	require.NoError(t, err, "error generating network")
+	assert.Equal(t, hcn.Overlay, network.Type)
+	require.Len(t, network.Ipams, 1)
+	require.Len(t, network.Ipams[0].Subnets, 1)
+	var policy hcn.SubnetPolicy
+	require.NoError(t, json.Unmarshal(network.Ipams[0].Subnets[0].Policies[0], &policy))
+	assert.Equal(t, hcn.VSID, policy.Type)
+	assert.JSONEq(t, `{"IsolationId":4096}`, string(policy.Settings))
+	assert.Empty(t, overlay.mismatches(network), "generated network does not match its config")
+
+	bridge := NetworkConfig{Name: "l2bridge", Type: L2Bridge, AddressPrefix: "10.132.1.0/24", Gateway: "10.132.1.1",
+		AdapterName: "Ethernet 2"}
+	network, err = bridge.hostComputeNetwork()
+	require.NoError(t, err, "error generating network")
+	assert.Empty(t, network.Ipams[0].Subnets[0].Policies, "VSID set for an l2bridge network")
+	assert.Empty(t, bridge.mismatches(network), "generated network does not match its config")
+	// HNS does not preserve the case of the network type
+	network.Type = "l2bridge"
+	assert.Empty(t, bridge.mismatches(network), "network type is compared case sensitively")
+
+	changed := bridge
+	changed.Type = Overlay
+	changed.AddressPrefix = "10.132.2.0/24"
+	changed.Gateway = "10.132.2.1"
+	changed.AdapterName = "Ethernet"
+	assert.Len(t, changed.mismatches(network), 3, "incorrect mismatches of a changed network")
+	changed = bridge
+	changed.Gateway = "10.132.1.254"
+	assert.Len(t, changed.mismatches(network), 1, "incorrect mismatches of a network with a changed gateway")
+}
@@ -0,0 +1,6 @@
+// Package ignition holds the details of the worker ignition served by the machine config server that are shared by
+// the bootstrapper and the test tooling. It has no dependencies so that it can be imported by the test module.
+package ignition
+
+// AcceptHeader is the Accept header the machine config server serves the Ignition config spec v3.1.0 for
+const AcceptHeader = "application/vnd.coreos.ignition+json;version=3.1.0"
@@ -1,6 +1,7 @@
 package e2e
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -64,7 +65,7 @@ func TestBootstrapper(t *testing.T) {
 	// Run the bootstrapper, which will start the kubelet service
 	wmcb, err := bootstrapper.NewWinNodeBootstrapper(installDir, ignitionFilePath, kubeletPath, "", "")
 	require.NoErrorf(t, err, "Could not create WinNodeBootstrapper: %s", err)
-	err = wmcb.InitializeKubelet()
+	err = wmcb.InitializeKubelet(context.Background())
 	assert.NoErrorf(t, err, "Could not run bootstrapper: %s", err)
 
 	t.Run("Kubelet Windows service starts", func(t *testing.T) {
@@ -83,7 +84,7 @@ func TestBootstrapper(t *testing.T) {
 	})
 
 	t.Run("Update already running kubelet service", func(t *testing.T) {
-		err := wmcb.InitializeKubelet()
+		err := wmcb.InitializeKubelet(context.Background())
 		assert.NoError(t, err, "unable to update kubelet service")
 		err = wmcb.Disconnect()
 		assert.NoErrorf(t, err, "Could not disconnect from windows svc API: %s", err)
@@ -1,6 +1,7 @@
 package e2e
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"strings"
@@ -45,7 +46,7 @@ func testConfigureCNIWithoutKubeletSvc(t *testing.T) {
 	wmcb, err := bootstrapper.NewWinNodeBootstrapper(tempDir, "", "", tempDir, cniConfig.Name())
 	require.NoError(t, err, "could not instantiate wmcb")
 
-	err = wmcb.Configure()
+	err = wmcb.Configure(context.Background())
 	assert.Error(t, err, "no error when attempting to configure CNI without kubelet svc")
 	assert.Contains(t, err.Error(), "kubelet service is not present", "incorrect error thrown")
 }
@@ -56,7 +57,7 @@ func testConfigureCNI(t *testing.T) {
 	wmcb, err := bootstrapper.NewWinNodeBootstrapper(installDir, "", "", cniDir, cniConfig)
 	require.NoError(t, err, "could not create wmcb")
 
-	err = wmcb.Configure()
+	err = wmcb.Configure(context.Background())
 	assert.NoError(t, err, "error running wmcb.ConfigureCNI")
 
 	err = wmcb.Disconnect()